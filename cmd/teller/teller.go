@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -12,10 +13,9 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime/pprof"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/boltdb/bolt"
 	btcrpcclient "github.com/btcsuite/btcd/rpcclient"
 	"github.com/google/gops/agent"
 	"github.com/sirupsen/logrus"
@@ -24,13 +24,25 @@ import (
 	"github.com/skycoin/teller/src/addrs"
 	"github.com/skycoin/teller/src/config"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/metrics"
 	"github.com/skycoin/teller/src/monitor"
+	"github.com/skycoin/teller/src/notify"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
 	"github.com/skycoin/teller/src/teller"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/lifecycle"
 	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/version"
 )
 
+// preflightTimeout is how long the preflight checks may take, see config.Config.RunPreflight
+const preflightTimeout = 30 * time.Second
+
+// serviceShutdownTimeout is how long each service may take to shut down before it is reported as
+// timed out, see lifecycle.Manager.SetShutdownTimeout
+const serviceShutdownTimeout = 30 * time.Second
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Println(err)
@@ -135,8 +147,17 @@ func run() error {
 
 	log := rusloggger.WithField("prefix", "teller")
 
+	log.WithField("version", version.Get()).Info("Starting teller")
 	log.WithField("config", cfg.Redacted()).Info("Loaded teller config")
 
+	if cfg.SkyExchanger.AllowSimulatedDeposits {
+		log.Warning("sky_exchanger.allow_simulated_deposits is enabled, operators can inject deposits that will be sent. Never enable this in production")
+	}
+
+	if cfg.SkyExchanger.TestMode {
+		log.WithField("rate", cfg.SkyExchanger.TestModeRate).Warning("sky_exchanger.test_mode is enabled, every deposit is converted at the test mode rate and tagged as test data. Never enable this in production")
+	}
+
 	if cfg.Profile {
 		// Start gops agent, for profiling
 		if err := agent.Listen(&agent.Options{
@@ -147,35 +168,26 @@ func run() error {
 		}
 	}
 
-	quit := make(chan struct{})
-	go catchInterrupt(quit)
-
 	// Open db
 	dbPath := filepath.Join(*appDirOpt, cfg.DBFilename)
-	db, err := bolt.Open(dbPath, 0700, &bolt.Options{
-		Timeout: 1 * time.Second,
-	})
-	if err != nil {
-		log.WithError(err).Error("Open db failed")
-		return err
+	storageOpts := dbutil.StorageOptions{
+		Timeout:         1 * time.Second,
+		InitialMmapSize: cfg.DBInitialMmapSize,
+		AllowNetworkFS:  cfg.DBAllowNetworkFS,
 	}
 
-	errC := make(chan error, 20)
-	var wg sync.WaitGroup
+	// Compact the db before it is used, so that nothing writes to it mid-compaction
+	if cfg.DBCompactInterval > 0 {
+		if err := compactDB(log, dbPath, storageOpts, cfg.DBCompactInterval, cfg.DBCompactMinFreePercent); err != nil {
+			log.WithError(err).Error("Compact db failed")
+			return err
+		}
+	}
 
-	background := func(name string, errC chan<- error, f func() error) {
-		log.Infof("Backgrounding task %s", name)
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			err := f()
-			if err != nil {
-				log.WithError(err).Errorf("Backgrounded task %s failed", name)
-				errC <- fmt.Errorf("Backgrounded task %s failed: %v", name, err)
-			} else {
-				log.Infof("Backgrounded task %s shutdown", name)
-			}
-		}()
+	db, err := dbutil.Open(log, dbPath, storageOpts)
+	if err != nil {
+		log.WithError(err).Error("Open db failed")
+		return err
 	}
 
 	var btcScanner *scanner.BTCScanner
@@ -184,6 +196,8 @@ func run() error {
 	var scanEthService scanner.Scanner
 	var sendService *sender.SendService
 	var sendRPC sender.Sender
+	var skyBackends monitor.BackendStatusGetter
+	var txQuerier exchange.TxQuerier
 	var btcAddrMgr *addrs.Addrs
 	var ethAddrMgr *addrs.Addrs
 
@@ -214,7 +228,6 @@ func run() error {
 				log.WithError(err).Error("create btc scanner failed")
 				return err
 			}
-			background("btcScanner.Run", errC, btcScanner.Run)
 
 			scanService = btcScanner
 		}
@@ -227,8 +240,6 @@ func run() error {
 				return err
 			}
 
-			background("ethScanner.Run", errC, ethScanner.Run)
-
 			scanEthService = ethScanner
 
 			if err := multiplexer.AddScanner(scanEthService, scanner.CoinTypeETH); err != nil {
@@ -243,23 +254,35 @@ func run() error {
 		return err
 	}
 
-	background("multiplex.Run", errC, multiplexer.Multiplex)
-
 	if cfg.Dummy.Sender {
 		log.Info("skyd disabled, running dummy sender")
 		sendRPC = sender.NewDummySender(log)
 		sendRPC.(*sender.DummySender).BindHandlers(dummyMux)
 	} else {
-		skyClient, err := sender.NewRPC(cfg.SkyExchanger.Wallet, cfg.SkyRPC.Address)
+		var backends []sender.Backend
+		for _, addr := range append([]string{cfg.SkyRPC.Address}, cfg.SkyRPC.FallbackAddresses...) {
+			rpc, err := sender.NewRPC(cfg.SkyExchanger.Wallet, addr)
+			if err != nil {
+				log.WithError(err).Error("sender.NewRPC failed")
+				return err
+			}
+
+			backends = append(backends, sender.Backend{
+				Addr:      addr,
+				SkyClient: rpc,
+			})
+		}
+
+		skyClient, err := sender.NewFailoverClient(log, backends)
 		if err != nil {
-			log.WithError(err).Error("sender.NewRPC failed")
+			log.WithError(err).Error("sender.NewFailoverClient failed")
 			return err
 		}
+		skyBackends = skyClient
+		txQuerier = skyClient
 
 		sendService = sender.NewService(log, skyClient)
 
-		background("sendService.Run", errC, sendService.Run)
-
 		sendRPC = sender.NewRetrySender(sendService)
 	}
 
@@ -279,6 +302,13 @@ func run() error {
 		return err
 	}
 
+	// create AddrManager. The address generators are added once the exchange is running
+	addrManager := addrs.NewAddrManager()
+	exchangeStore.SetDerivationGetter(addrManager)
+	exchangeStore.SetHistoryLimit(cfg.SkyExchanger.DepositHistoryLimit)
+	exchangeStore.SetSnapshotTempFileSize(cfg.DBSnapshotTempFileSize)
+	exchangeStore.SetTestMode(cfg.SkyExchanger.TestMode)
+
 	var exchangeClient *exchange.Exchange
 
 	switch cfg.SkyExchanger.BuyMethod {
@@ -301,10 +331,33 @@ func run() error {
 		return config.ErrInvalidBuyMethod
 	}
 
-	background("exchangeClient.Run", errC, exchangeClient.Run)
+	if txQuerier != nil {
+		exchangeClient.SetTxQuerier(txQuerier)
+	}
 
-	// create AddrManager
-	addrManager := addrs.NewAddrManager()
+	if len(cfg.Notify.WebhookURLs) != 0 {
+		notifiers := make(notify.Multi, len(cfg.Notify.WebhookURLs))
+		for i, webhookURL := range cfg.Notify.WebhookURLs {
+			notifiers[i] = notify.NewWebhook(webhookURL)
+		}
+		exchangeClient.SetNotifier(notifiers)
+	}
+
+	var metricsRegistry *metrics.Registry
+	if cfg.AdminPanel.Metrics {
+		metricsRegistry = metrics.NewRegistry()
+
+		v := version.Get()
+		metricsRegistry.Gauge("teller_build_info", "Build version of teller, the value is always 1", metrics.Labels{
+			"version":    v.Version,
+			"commit":     v.Commit,
+			"build_time": v.BuildTime,
+		}).Set(1)
+
+		exchangeStore.SetMetrics(metricsRegistry)
+		exchangeClient.SetMetrics(metricsRegistry)
+		exchangeClient.SetMetricsTopAddresses(cfg.AdminPanel.MetricsTopAddresses)
+	}
 
 	if cfg.BtcRPC.Enabled {
 		// create bitcoin address manager
@@ -319,6 +372,7 @@ func run() error {
 			log.WithError(err).Error("Create bitcoin deposit address manager failed")
 			return err
 		}
+		btcAddrMgr.SetLowWatermark(uint64(cfg.AddressPoolLowWatermark), nil)
 		if err := addrManager.PushGenerator(btcAddrMgr, scanner.CoinTypeBTC); err != nil {
 			log.WithError(err).Error("add btc address manager failed")
 			return err
@@ -338,76 +392,168 @@ func run() error {
 			log.WithError(err).Error("Create ethcoin deposit address manager failed")
 			return err
 		}
+		ethAddrMgr.SetLowWatermark(uint64(cfg.AddressPoolLowWatermark), nil)
 		if err := addrManager.PushGenerator(ethAddrMgr, scanner.CoinTypeETH); err != nil {
 			log.WithError(err).Error("add eth address manager failed")
 			return err
 		}
 	}
 
-	tellerServer := teller.New(log, exchangeClient, addrManager, cfg)
-
-	// Run the service
-	background("tellerServer.Run", errC, tellerServer.Run)
+	tellerServer, err := teller.New(log, exchangeClient, addrManager, cfg)
+	if err != nil {
+		log.WithError(err).Error("teller.New failed")
+		return err
+	}
+	if metricsRegistry != nil {
+		tellerServer.SetMetrics(metricsRegistry)
+	}
 
 	// start monitor service
 	monitorCfg := monitor.Config{
-		Addr: cfg.AdminPanel.Host,
+		Addr:           cfg.AdminPanel.Host,
+		EventsToken:    cfg.AdminPanel.EventsToken,
+		OperatorTokens: cfg.AdminPanel.OperatorTokens,
+		BindEnabled:    cfg.Teller.BindEnabled,
+	}
+	if metricsRegistry != nil {
+		monitorCfg.Metrics = metricsRegistry
+	}
+	monitorService := monitor.New(log, monitorCfg, btcAddrMgr, ethAddrMgr, exchangeClient, btcScanner, exchangeClient, exchangeClient, exchangeClient, exchangeClient, exchangeClient, skyBackends, exchangeClient, exchangeClient, multiplexer)
+	monitorService.SetFreezeManager(exchangeClient)
+	monitorService.SetDepositExporter(exchangeClient)
+	monitorService.SetTestDataPurger(exchangeClient)
+	monitorService.SetOverviewGetter(exchangeClient)
+
+	if cfg.RunPreflight {
+		log.Info("Running preflight checks")
+		ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+		err := exchangeClient.Preflight(ctx)
+		cancel()
+		if err != nil {
+			log.WithError(err).Error("Preflight failed")
+			return err
+		}
+
+		monitorService.SetPreflightReporter(exchangeClient)
 	}
-	monitorService := monitor.New(log, monitorCfg, btcAddrMgr, ethAddrMgr, exchangeClient, btcScanner)
 
-	background("monitorService.Run", errC, monitorService.Run)
+	// Services are shut down in the reverse order they are added, so that the servers stop
+	// accepting requests and the scanners stop producing deposits before the exchange is
+	// drained and stopped, and the db is closed last
+	services := lifecycle.NewManager(log)
+	services.SetShutdownTimeout(serviceShutdownTimeout)
 
-	var finalErr error
-	select {
-	case <-quit:
-	case finalErr = <-errC:
-		if finalErr != nil {
-			log.WithError(finalErr).Error("Goroutine error")
-		}
+	if sendService != nil {
+		services.Add("sendService", sendService.Run, sendService.Shutdown)
 	}
 
-	log.Info("Shutting down...")
+	services.Add("exchangeClient", exchangeClient.Run, exchangeClient.Shutdown)
 
-	if monitorService != nil {
-		log.Info("Shutting down monitorService")
-		monitorService.Shutdown()
+	if btcScanner != nil {
+		services.Add("btcScanner", btcScanner.Run, btcScanner.Shutdown)
 	}
 
-	// close the teller service
-	log.Info("Shutting down tellerServer")
-	tellerServer.Shutdown()
+	if ethScanner != nil {
+		services.Add("ethScanner", ethScanner.Run, ethScanner.Shutdown)
+	}
 
-	log.Info("Shutting down the multiplexer")
-	multiplexer.Shutdown()
+	services.Add("multiplexer", multiplexer.Multiplex, multiplexer.Shutdown)
+	services.Add("tellerServer", tellerServer.Run, tellerServer.Shutdown)
 
-	// close the scan service
-	if btcScanner != nil {
-		log.Info("Shutting down btcScanner")
-		btcScanner.Shutdown()
+	hangupQuit := make(chan struct{})
+	services.Add("catchHangup", func() error {
+		catchHangup(log, hangupQuit, tellerServer.ReloadAllowlist)
+		return nil
+	}, func() {
+		close(hangupQuit)
+	})
+
+	services.Add("monitorService", monitorService.Run, monitorService.Shutdown)
+
+	services.AddCloser("db", db.Close)
+
+	// If interrupted again, panic so that the program state can be examined.
+	// It would be interrupted again if program shutdown was stuck.
+	services.SetRepeatSignalHandler(func() {
+		printProgramStatus()
+		panic("SIGINT")
+	})
+
+	return services.RunUntilSignal(os.Interrupt, syscall.SIGTERM)
+}
+
+// compactDB compacts the db if it was last compacted more than interval ago and
+// at least minFreePercent of the file is free pages. The db is copied to a new file,
+// which then replaces the db file.
+func compactDB(log logrus.FieldLogger, dbPath string, storageOpts dbutil.StorageOptions, interval time.Duration, minFreePercent float64) error {
+	// Compaction reads the db once, it does not need a large memory map
+	storageOpts.InitialMmapSize = 0
+
+	db, err := dbutil.Open(log, dbPath, storageOpts)
+	if err != nil {
+		return err
 	}
-	// close the scan service
-	if ethScanner != nil {
-		log.Info("Shutting down ethScanner")
-		ethScanner.Shutdown()
+	defer db.Close()
+
+	lastCompacted, err := dbutil.LastCompacted(db)
+	if err != nil {
+		return err
 	}
 
-	// close exchange service
-	log.Info("Shutting down exchangeClient")
-	exchangeClient.Shutdown()
+	freePercent, err := dbutil.FreeSpacePercent(db)
+	if err != nil {
+		return err
+	}
 
-	// close the skycoin send service
-	if sendService != nil {
-		log.Info("Shutting down sendService")
-		sendService.Shutdown()
+	log = log.WithFields(logrus.Fields{
+		"lastCompacted": lastCompacted,
+		"freePercent":   freePercent,
+	})
+
+	if time.Since(lastCompacted) < interval || freePercent < minFreePercent {
+		log.Info("Skipping db compaction")
+		return nil
 	}
 
-	log.Info("Waiting for goroutines to exit")
+	beforeSize, err := fileSize(dbPath)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("beforeSize", beforeSize).Info("Compacting db")
 
-	wg.Wait()
+	compactPath := dbPath + ".compact"
+	if err := dbutil.Compact(db, compactPath); err != nil {
+		return err
+	}
+
+	if err := db.Close(); err != nil {
+		return err
+	}
 
-	log.Info("Shutdown complete")
+	if err := os.Rename(compactPath, dbPath); err != nil {
+		return err
+	}
+
+	afterSize, err := fileSize(dbPath)
+	if err != nil {
+		return err
+	}
 
-	return finalErr
+	log.WithFields(logrus.Fields{
+		"beforeSize": beforeSize,
+		"afterSize":  afterSize,
+	}).Info("Compacted db")
+
+	return nil
+}
+
+func fileSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
 }
 
 func createFolderIfNotExist(path string) error {
@@ -428,24 +574,21 @@ func printProgramStatus() {
 	}
 }
 
-func catchInterrupt(quit chan<- struct{}) {
+// catchHangup calls reload each time SIGHUP is received, until quit is closed
+func catchHangup(log logrus.FieldLogger, quit <-chan struct{}, reload func() error) {
 	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, os.Interrupt)
-	<-sigchan
-	signal.Stop(sigchan)
-	close(quit)
-
-	// If ctrl-c is called again, panic so that the program state can be examined.
-	// Ctrl-c would be called again if program shutdown was stuck.
-	go catchInterruptPanic()
-}
-
-// catchInterruptPanic catches os.Interrupt and panics
-func catchInterruptPanic() {
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, os.Interrupt)
-	<-sigchan
-	signal.Stop(sigchan)
-	printProgramStatus()
-	panic("SIGINT")
+	signal.Notify(sigchan, syscall.SIGHUP)
+	defer signal.Stop(sigchan)
+
+	for {
+		select {
+		case <-sigchan:
+			log.Info("Received SIGHUP, reloading")
+			if err := reload(); err != nil {
+				log.WithError(err).Error("Reload failed")
+			}
+		case <-quit:
+			return
+		}
+	}
 }
@@ -31,6 +31,8 @@ const (
 // btc address json struct
 type addressJSON struct {
 	BtcAddresses []string `json:"btc_addresses"`
+	// BtcDerivations is kept as is when adding addresses
+	BtcDerivations json.RawMessage `json:"btc_derivations,omitempty"`
 }
 
 var usage = fmt.Sprintf(`%s is a teller helper tool:
@@ -17,17 +17,31 @@ var (
 	ErrCoinTypeNotExists = errors.New("Invalid coin type")
 )
 
+// Derivation records how a deposit address was derived from an HD wallet
+type Derivation struct {
+	Index uint32 `json:"index"`
+	Path  string `json:"path,omitempty"`
+}
+
 // AddrGenerator generate new deposit address
 type AddrGenerator interface {
 	NewAddress() (string, error)
+	// Derivation returns how the address was derived, or nil if it was not derived or is unknown
+	Derivation(addr string) *Derivation
 }
 
+// LowWatermarkFunc is called with the remaining pool size when it drops below the low watermark
+type LowWatermarkFunc func(remaining uint64)
+
 // Addrs manages deposit addresses
 type Addrs struct {
 	sync.RWMutex
-	log       logrus.FieldLogger
-	used      *Store   // all used addresses
-	addresses []string // address pool for deposit
+	log          logrus.FieldLogger
+	used         *Store                // all used addresses
+	addresses    []string              // address pool for deposit
+	lowWatermark uint64                // warn when the pool has fewer addresses than this, 0 disables
+	onLow        LowWatermarkFunc      // optional callback when the pool is below lowWatermark
+	derivations  map[string]Derivation // optional derivation info of the addresses
 }
 
 // AddrManager control all AddrGenerator according to coinType
@@ -70,6 +84,17 @@ func (am *AddrManager) NewAddress(coinType string) (string, error) {
 	return depositAddr, nil
 }
 
+// Derivation returns how addr was derived according to coinType, or nil if unknown
+func (am *AddrManager) Derivation(coinType, addr string) *Derivation {
+	am.Mutex.RLock()
+	defer am.Mutex.RUnlock()
+	ag, ok := am.AGHolder[coinType]
+	if !ok {
+		return nil
+	}
+	return ag.Derivation(addr)
+}
+
 // NewAddrs creates Addrs instance, will load and verify the addresses
 func NewAddrs(log logrus.FieldLogger, db *bolt.DB, addresses []string, bucketKey string) (*Addrs, error) {
 	used, err := NewStore(db, bucketKey)
@@ -136,13 +161,89 @@ func (a *Addrs) NewAddress() (string, error) {
 
 	// remove used addr
 	a.addresses = a.addresses[pt+1:]
+
+	a.checkLowWatermark()
+
 	return chosenAddr, nil
 }
 
+// Derivation returns how addr was derived, as loaded from the addresses file, or nil if unknown
+func (a *Addrs) Derivation(addr string) *Derivation {
+	a.RLock()
+	defer a.RUnlock()
+
+	d, ok := a.derivations[addr]
+	if !ok {
+		return nil
+	}
+	return &d
+}
+
+// verifyDerivations checks that every derivation is for one of the addresses
+func verifyDerivations(addrs []string, derivations map[string]Derivation) error {
+	addrMap := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		addrMap[addr] = struct{}{}
+	}
+
+	for addr := range derivations {
+		if _, ok := addrMap[addr]; !ok {
+			return fmt.Errorf("Derivation of unknown deposit address `%s`", addr)
+		}
+	}
+
+	return nil
+}
+
+// checkLowWatermark warns and calls the low watermark callback if the pool
+// has fewer addresses than the low watermark. Must be called with the lock held.
+func (a *Addrs) checkLowWatermark() {
+	remaining := uint64(len(a.addresses))
+	if a.lowWatermark == 0 || remaining >= a.lowWatermark {
+		return
+	}
+
+	a.log.WithFields(logrus.Fields{
+		"remaining":    remaining,
+		"lowWatermark": a.lowWatermark,
+	}).Warn("Deposit address pool is below the low watermark, add more addresses before binds fail")
+
+	if a.onLow != nil {
+		a.onLow(remaining)
+	}
+}
+
+// SetLowWatermark configures a warning for when the pool has fewer than watermark addresses remaining.
+// Each address taken from the pool while below the watermark logs a warning and calls onLow, if not nil.
+// A watermark of 0 disables the warning.
+func (a *Addrs) SetLowWatermark(watermark uint64, onLow LowWatermarkFunc) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.lowWatermark = watermark
+	a.onLow = onLow
+}
+
 // Remaining returns the rest btc address number
 func (a *Addrs) Remaining() uint64 {
+	if a == nil {
+		return 0
+	}
+
 	a.RLock()
 	defer a.RUnlock()
 
 	return uint64(len(a.addresses))
 }
+
+// LowWatermark returns the configured low watermark of the address pool
+func (a *Addrs) LowWatermark() uint64 {
+	if a == nil {
+		return 0
+	}
+
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.lowWatermark
+}
@@ -117,6 +117,44 @@ func TestNewAddress(t *testing.T) {
 	require.Equal(t, ErrDepositAddressEmpty, err)
 }
 
+func TestAddrsLowWatermark(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	btca, _ := testNewBtcAddrManager(t, db, log)
+
+	var calls []uint64
+	btca.SetLowWatermark(2, func(remaining uint64) {
+		calls = append(calls, remaining)
+	})
+	require.Equal(t, uint64(2), btca.LowWatermark())
+
+	// 2 addresses remain, which is not below the watermark
+	_, err := btca.NewAddress()
+	require.NoError(t, err)
+	require.Empty(t, calls)
+
+	_, err = btca.NewAddress()
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, calls)
+
+	_, err = btca.NewAddress()
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 0}, calls)
+	require.Equal(t, uint64(0), btca.Remaining())
+
+	// A watermark of 0 disables the callback
+	btca2, _ := testNewEthAddrManager(t, db, log)
+	btca2.SetLowWatermark(0, func(remaining uint64) {
+		t.Fatal("low watermark callback should not be called")
+	})
+	for i := 0; i < 3; i++ {
+		_, err := btca2.NewAddress()
+		require.NoError(t, err)
+	}
+}
+
 func TestNewEthAddrs(t *testing.T) {
 	db, shutdown := testutil.PrepareDB(t)
 	defer shutdown()
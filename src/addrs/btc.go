@@ -16,27 +16,39 @@ const btcBucketKey = "used_btc_address"
 
 // NewBTCAddrs returns an Addrs loaded with BTC addresses
 func NewBTCAddrs(log logrus.FieldLogger, db *bolt.DB, addrsReader io.Reader) (*Addrs, error) {
-	loader, err := loadBTCAddresses(addrsReader)
+	loader, derivations, err := loadBTCAddresses(addrsReader)
 	if err != nil {
 		return nil, err
 	}
-	return NewAddrs(log, db, loader, btcBucketKey)
+
+	a, err := NewAddrs(log, db, loader, btcBucketKey)
+	if err != nil {
+		return nil, err
+	}
+	a.derivations = derivations
+
+	return a, nil
 }
 
-func loadBTCAddresses(addrsReader io.Reader) ([]string, error) {
+func loadBTCAddresses(addrsReader io.Reader) ([]string, map[string]Derivation, error) {
 	var addrs struct {
-		Addresses []string `json:"btc_addresses"`
+		Addresses   []string              `json:"btc_addresses"`
+		Derivations map[string]Derivation `json:"btc_derivations"`
 	}
 
 	if err := json.NewDecoder(addrsReader).Decode(&addrs); err != nil {
-		return nil, fmt.Errorf("Decode loaded address json failed: %v", err)
+		return nil, nil, fmt.Errorf("Decode loaded address json failed: %v", err)
 	}
 
 	if err := verifyBTCAddresses(addrs.Addresses); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if err := verifyDerivations(addrs.Addresses, addrs.Derivations); err != nil {
+		return nil, nil, err
 	}
 
-	return addrs.Addresses, nil
+	return addrs.Addresses, addrs.Derivations, nil
 }
 
 func verifyBTCAddresses(addrs []string) error {
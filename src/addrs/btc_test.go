@@ -115,3 +115,50 @@ func TestNewBTCAddrsBadFormat(t *testing.T) {
 	require.Equal(t, expectedErr, err)
 	require.Nil(t, btcAddrMgr)
 }
+
+func TestNewBTCAddrsDerivations(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+
+	addressesJSON := `{
+    "btc_addresses": [
+        "1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB",
+        "14FG8vQnmK6B7YbLSr6uC5wfGY78JFNCYg"
+    ],
+    "btc_derivations": {
+        "14FG8vQnmK6B7YbLSr6uC5wfGY78JFNCYg": {"index": 1, "path": "m/44'/0'/0'/0/1"}
+    }
+}`
+
+	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJSON)))
+	require.NoError(t, err)
+
+	require.Equal(t, &Derivation{
+		Index: 1,
+		Path:  "m/44'/0'/0'/0/1",
+	}, btcAddrMgr.Derivation("14FG8vQnmK6B7YbLSr6uC5wfGY78JFNCYg"))
+
+	// An address without derivation info has none
+	require.Nil(t, btcAddrMgr.Derivation("1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB"))
+
+	am := NewAddrManager()
+	require.NoError(t, am.PushGenerator(btcAddrMgr, "BTC"))
+	require.NotNil(t, am.Derivation("BTC", "14FG8vQnmK6B7YbLSr6uC5wfGY78JFNCYg"))
+	require.Nil(t, am.Derivation("ETH", "14FG8vQnmK6B7YbLSr6uC5wfGY78JFNCYg"))
+
+	// A derivation of an address that is not in the list is invalid
+	addressesJSON = `{
+    "btc_addresses": [
+        "1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB"
+    ],
+    "btc_derivations": {
+        "14FG8vQnmK6B7YbLSr6uC5wfGY78JFNCYg": {"index": 1}
+    }
+}`
+
+	btcAddrMgr, err = NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJSON)))
+	require.Equal(t, errors.New("Derivation of unknown deposit address `14FG8vQnmK6B7YbLSr6uC5wfGY78JFNCYg`"), err)
+	require.Nil(t, btcAddrMgr)
+}
@@ -15,27 +15,39 @@ const ethBucketKey = "used_eth_address"
 
 // NewETHAddrs returns an Addrs loaded with ETH addresses
 func NewETHAddrs(log logrus.FieldLogger, db *bolt.DB, addrsReader io.Reader) (*Addrs, error) {
-	loader, err := loadETHAddresses(addrsReader)
+	loader, derivations, err := loadETHAddresses(addrsReader)
 	if err != nil {
 		return nil, err
 	}
-	return NewAddrs(log, db, loader, ethBucketKey)
+
+	a, err := NewAddrs(log, db, loader, ethBucketKey)
+	if err != nil {
+		return nil, err
+	}
+	a.derivations = derivations
+
+	return a, nil
 }
 
-func loadETHAddresses(addrsReader io.Reader) ([]string, error) {
+func loadETHAddresses(addrsReader io.Reader) ([]string, map[string]Derivation, error) {
 	var addrs struct {
-		Addresses []string `json:"eth_addresses"`
+		Addresses   []string              `json:"eth_addresses"`
+		Derivations map[string]Derivation `json:"eth_derivations"`
 	}
 
 	if err := json.NewDecoder(addrsReader).Decode(&addrs); err != nil {
-		return nil, fmt.Errorf("Decode loaded address json failed: %v", err)
+		return nil, nil, fmt.Errorf("Decode loaded address json failed: %v", err)
 	}
 
 	if err := verifyETHAddresses(addrs.Addresses); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if err := verifyDerivations(addrs.Addresses, addrs.Derivations); err != nil {
+		return nil, nil, err
 	}
 
-	return addrs.Addresses, nil
+	return addrs.Addresses, addrs.Derivations, nil
 }
 
 // https://github.com/ethereum/go-ethereum/blob/2db97986460c57ba74a563d97a704a45a270df7d/common/icap.go
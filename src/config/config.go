@@ -2,19 +2,25 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/spf13/viper"
 
+	"github.com/skycoin/skycoin/src/util/droplet"
 	"github.com/skycoin/skycoin/src/visor"
 	"github.com/skycoin/skycoin/src/wallet"
 
+	"github.com/skycoin/teller/src/sender"
 	"github.com/skycoin/teller/src/util/mathutil"
 )
 
@@ -23,11 +29,20 @@ const (
 	BuyMethodDirect = "direct"
 	// BuyMethodPassthrough is used when coins are first bought from an exchange before sending from the local hot wallet
 	BuyMethodPassthrough = "passthrough"
+
+	// RoundingDown truncates SKY to sky_exchanger.max_decimals
+	RoundingDown = "down"
+	// RoundingNearest rounds SKY to the nearest sky_exchanger.max_decimals, halves up
+	RoundingNearest = "nearest"
+	// RoundingUp rounds SKY up to sky_exchanger.max_decimals
+	RoundingUp = "up"
 )
 
 var (
 	// ErrInvalidBuyMethod is returned if BindAddress is called with an invalid buy method
 	ErrInvalidBuyMethod = errors.New("Invalid buy method")
+	// ErrInvalidRoundingMode is returned if SKY is rounded with an invalid rounding mode
+	ErrInvalidRoundingMode = errors.New("Invalid rounding mode")
 )
 
 // ValidateBuyMethod returns an error if a buy method string is invalid
@@ -40,21 +55,47 @@ func ValidateBuyMethod(m string) error {
 	}
 }
 
+// ValidateRoundingMode returns an error if a rounding mode string is invalid. Empty is RoundingDown
+func ValidateRoundingMode(m string) error {
+	switch m {
+	case "", RoundingDown, RoundingNearest, RoundingUp:
+		return nil
+	default:
+		return ErrInvalidRoundingMode
+	}
+}
+
 // Config represents the configuration root
 type Config struct {
 	// Enable debug logging
 	Debug bool `mapstructure:"debug"`
 	// Run with gops profiler
 	Profile bool `mapstructure:"profile"`
+	// Check that the skycoin node, the database and the scanners' nodes work at startup, and exit if they don't
+	RunPreflight bool `mapstructure:"run_preflight"`
 	// Where log is saved
 	LogFilename string `mapstructure:"logfile"`
 	// Where database is saved, inside the ~/.teller-skycoin data directory
 	DBFilename string `mapstructure:"dbfile"`
+	// Compact the database at startup if it was last compacted more than this long ago. 0 disables compaction
+	DBCompactInterval time.Duration `mapstructure:"db_compact_interval"`
+	// Only compact the database if at least this percent of the file is free pages
+	DBCompactMinFreePercent float64 `mapstructure:"db_compact_min_free_percent"`
+	// Initial size in bytes of the database's memory map. A database smaller than this
+	// never remaps, so writes never wait for open reads to finish. 0 uses bolt's default
+	DBInitialMmapSize int `mapstructure:"db_initial_mmap_size"`
+	// Open the database even if it is on a network filesystem, where it can be corrupted
+	DBAllowNetworkFS bool `mapstructure:"db_allow_network_fs"`
+	// Snapshots of a database larger than this many bytes are copied to a temp file, then downloaded from it,
+	// so that a slow download doesn't hold a database transaction open. 0 always downloads directly from the database
+	DBSnapshotTempFileSize int64 `mapstructure:"db_snapshot_temp_file_size"`
 
 	// Path of BTC addresses JSON file
 	BtcAddresses string `mapstructure:"btc_addresses"`
 	// Path of ETH addresses JSON file
 	EthAddresses string `mapstructure:"eth_addresses"`
+	// Warn when a deposit address pool has fewer than this many addresses remaining. 0 disables the warning
+	AddressPoolLowWatermark int `mapstructure:"address_pool_low_watermark"`
 
 	Teller Teller `mapstructure:"teller"`
 
@@ -70,20 +111,35 @@ type Config struct {
 
 	AdminPanel AdminPanel `mapstructure:"admin_panel"`
 
+	Notify Notify `mapstructure:"notify"`
+
 	Dummy Dummy `mapstructure:"dummy"`
 }
 
 // Teller config for teller
 type Teller struct {
-	// Max number of btc addresses a skycoin address can bind
+	// Max number of deposit addresses a skycoin address can bind, of all coin types. Unlimited if 0
 	MaxBoundAddresses int `mapstructure:"max_bound_addrs"`
 	// Allow address binding
 	BindEnabled bool `mapstructure:"bind_enabled"`
+	// Hex encoded Ed25519 seed used to sign deposit receipts. Receipts are disabled if empty
+	ReceiptKey string `mapstructure:"receipt_key"`
+	// Require the caller of bind to prove ownership of the skycoin address, by signing a challenge with its key
+	RequireAddressProof bool `mapstructure:"require_address_proof"`
+	// How long an address proof challenge can be used for
+	AddressProofTTL time.Duration `mapstructure:"address_proof_ttl"`
+	// File of skycoin addresses allowed to bind, one per line. All addresses are allowed if empty
+	AllowlistFile string `mapstructure:"allowlist_file"`
+	// User-facing messages or localization keys returned by the status API, keyed by deposit status.
+	// They override the default English messages
+	StatusMessages map[string]string `mapstructure:"status_messages"`
 }
 
 // SkyRPC config for Skycoin daemon node RPC
 type SkyRPC struct {
 	Address string `mapstructure:"address"`
+	// Skycoin nodes to fail over to, in order, if the node at Address is unavailable
+	FallbackAddresses []string `mapstructure:"fallback_addresses"`
 }
 
 // BtcRPC config for btcrpc
@@ -125,14 +181,73 @@ type SkyExchanger struct {
 	SkyEthExchangeRate string `mapstructure:"sky_eth_exchange_rate"`
 	// Number of decimal places to truncate SKY to
 	MaxDecimals int `mapstructure:"max_decimals"`
+	// How SKY is rounded to MaxDecimals ("down", "nearest" or "up"). Rounded down if empty
+	RoundingMode string `mapstructure:"rounding_mode"`
 	// How long to wait before rechecking transaction confirmations
 	TxConfirmationCheckWait time.Duration `mapstructure:"tx_confirmation_check_wait"`
+	// How long to wait for a sent transaction to confirm before the deposit is set aside as stuck. No timeout if 0
+	ConfirmationTimeout time.Duration `mapstructure:"confirmation_timeout"`
+	// How long a deposit can wait to be sent before it is set aside as stuck, for an operator to approve or reject. No limit if 0
+	StuckSendAge time.Duration `mapstructure:"stuck_send_age"`
+	// How long to keep review decisions in the review audit log. Kept forever if 0
+	ReviewAuditRetention time.Duration `mapstructure:"review_audit_retention"`
+	// How often to compare the bound deposit addresses with the addresses watched by the scanners,
+	// adding bound addresses the scanners are missing. Never checked if 0
+	ScanDriftCheckInterval time.Duration `mapstructure:"scan_drift_check_interval"`
+	// Deposit addresses are single use. Deposits to an address that already has a completed deposit are not sent,
+	// they are set aside to be refunded manually
+	SingleUseAddresses bool `mapstructure:"single_use_addresses"`
+	// Allow operators to inject simulated deposits, for testing the deposit pipeline in staging. Never enable in production
+	AllowSimulatedDeposits bool `mapstructure:"allow_simulated_deposits"`
+	// Convert every deposit at TestModeRate instead of the configured rates, and tag it as test data,
+	// so that it is left out of the deposit stats and can be purged. For staging, never enable in production
+	TestMode bool `mapstructure:"test_mode"`
+	// SKY/BTC and SKY/ETH exchange rate of test mode. Can be an int, float or rational fraction string
+	TestModeRate string `mapstructure:"test_mode_rate"`
+	// Allow test mode with the real sender, which sends real SKY at TestModeRate
+	TestModeRealSender bool `mapstructure:"test_mode_real_sender"`
 	// Path of hot Skycoin wallet file on disk
 	Wallet string `mapstructure:"wallet"`
 	// Allow sending of coins (deposits will still be received and recorded)
 	SendEnabled bool `mapstructure:"send_enabled"`
 	// Method of purchasing coins ("direct buy" or "passthrough"
 	BuyMethod string `mapstructure:"buy_method"`
+	// How the coin hours of the hot wallet's outputs are spent by a send ("share", "minimal" or "burn")
+	CoinHourStrategy string `mapstructure:"coin_hour_strategy"`
+	// Template of the memo that tags each send's transaction, if the sender supports memos.
+	// {deposit_id}, {deposit_address}, {sky_address} and {coin_type} are replaced with the deposit's. No memo if empty
+	SendMemo string `mapstructure:"send_memo"`
+	// Deposits to the same deposit address received within this window of the first are sent in one transaction.
+	// Only applies to the direct buy method. Every deposit is sent separately if 0
+	MergeWindow time.Duration `mapstructure:"merge_window"`
+	// Up to this many deposits are sent in one transaction, with an output to each skycoin address.
+	// Every deposit is sent separately if 0 or 1
+	BatchSize int `mapstructure:"batch_size"`
+	// How long to wait for a batch to fill up before sending the deposits received so far
+	BatchInterval time.Duration `mapstructure:"batch_interval"`
+	// Volume discount tiers for BTC deposits, sorted by MinBtc. Deposits smaller than the first tier use SkyBtcExchangeRate
+	SkyBtcRateTiers []RateTier `mapstructure:"sky_btc_rate_tiers"`
+	// Only deposits to deposit addresses starting with one of these prefixes are processed, the others are ignored.
+	// Used to shard a shared wallet's deposits across teller instances. Every deposit is processed if empty
+	DepositAddressPrefixes []string `mapstructure:"deposit_address_prefixes"`
+	// Deposits whose send amount is greater than this many SKY are held until an operator clears their deposit address's KYC.
+	// Decimal SKY amount. No deposits are held if empty
+	KYCThresholdSky string `mapstructure:"kyc_threshold_sky"`
+	// At most this many SKY are sent within any SendAllowanceWindow. Deposits that would exceed it wait until
+	// enough of the window's sends expire. Decimal SKY amount. No limit if empty
+	SendAllowanceSky string `mapstructure:"send_allowance_sky"`
+	// The sliding window of SendAllowanceSky. Must be set if SendAllowanceSky is set
+	SendAllowanceWindow time.Duration `mapstructure:"send_allowance_window"`
+	// Up to this many status changes are kept in each deposit's history, the oldest are dropped. No history is recorded if 0
+	DepositHistoryLimit int `mapstructure:"deposit_history_limit"`
+}
+
+// RateTier is an exchange rate applied to deposits of at least a minimum amount
+type RateTier struct {
+	// Minimum deposit amount, in BTC, for this tier's rate to apply
+	MinBtc string `mapstructure:"min_btc"`
+	// SKY/BTC exchange rate. Can be an int, float or rational fraction string
+	Rate string `mapstructure:"rate"`
 }
 
 // Validate validates the SkyExchanger config
@@ -159,6 +274,12 @@ func (c SkyExchanger) validate() []error {
 		errs = append(errs, fmt.Errorf("sky_exchanger.sky_eth_exchange_rate invalid: %v", err))
 	}
 
+	if c.TestMode {
+		if _, err := mathutil.ParseRate(c.TestModeRate); err != nil {
+			errs = append(errs, fmt.Errorf("sky_exchanger.test_mode_rate invalid: %v", err))
+		}
+	}
+
 	if c.MaxDecimals < 0 {
 		errs = append(errs, errors.New("sky_exchanger.max_decimals can't be negative"))
 	}
@@ -167,13 +288,113 @@ func (c SkyExchanger) validate() []error {
 		errs = append(errs, fmt.Errorf("sky_exchanger.max_decimals is larger than visor.MaxDropletPrecision=%d", visor.MaxDropletPrecision))
 	}
 
+	if err := ValidateRoundingMode(c.RoundingMode); err != nil {
+		errs = append(errs, fmt.Errorf("sky_exchanger.rounding_mode must be \"%s\", \"%s\" or \"%s\"", RoundingDown, RoundingNearest, RoundingUp))
+	}
+
+	if c.ConfirmationTimeout < 0 {
+		errs = append(errs, errors.New("sky_exchanger.confirmation_timeout can't be negative"))
+	}
+
+	if c.StuckSendAge < 0 {
+		errs = append(errs, errors.New("sky_exchanger.stuck_send_age can't be negative"))
+	}
+
+	if c.ReviewAuditRetention < 0 {
+		errs = append(errs, errors.New("sky_exchanger.review_audit_retention can't be negative"))
+	}
+
+	if c.ScanDriftCheckInterval < 0 {
+		errs = append(errs, errors.New("sky_exchanger.scan_drift_check_interval can't be negative"))
+	}
+
+	if c.MergeWindow < 0 {
+		errs = append(errs, errors.New("sky_exchanger.merge_window can't be negative"))
+	}
+
+	if c.BatchSize < 0 {
+		errs = append(errs, errors.New("sky_exchanger.batch_size can't be negative"))
+	}
+
+	if c.BatchInterval < 0 {
+		errs = append(errs, errors.New("sky_exchanger.batch_interval can't be negative"))
+	} else if c.BatchSize > 1 && c.BatchInterval == 0 {
+		errs = append(errs, errors.New("sky_exchanger.batch_interval must be set if sky_exchanger.batch_size is greater than 1"))
+	}
+
 	if err := ValidateBuyMethod(c.BuyMethod); err != nil {
 		errs = append(errs, fmt.Errorf("sky_exchanger.buy_method must be \"%s\" or \"%s\"", BuyMethodDirect, BuyMethodPassthrough))
 	}
 
+	if err := sender.ValidateCoinHourStrategy(sender.CoinHourStrategy(c.CoinHourStrategy)); err != nil {
+		errs = append(errs, fmt.Errorf("sky_exchanger.coin_hour_strategy must be \"%s\", \"%s\" or \"%s\"", sender.CoinHourStrategyShare, sender.CoinHourStrategyMinimal, sender.CoinHourStrategyBurn))
+	}
+
+	for i, p := range c.DepositAddressPrefixes {
+		if p == "" {
+			errs = append(errs, fmt.Errorf("sky_exchanger.deposit_address_prefixes[%d] can't be empty", i))
+		}
+	}
+
+	var prevMinBtc int64
+	for i, t := range c.SkyBtcRateTiers {
+		minBtc, err := mathutil.ParseBtcAmount(t.MinBtc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sky_exchanger.sky_btc_rate_tiers[%d].min_btc invalid: %v", i, err))
+		} else if minBtc <= prevMinBtc {
+			errs = append(errs, fmt.Errorf("sky_exchanger.sky_btc_rate_tiers[%d].min_btc must be greater than the previous tier's min_btc, and greater than 0", i))
+		} else {
+			prevMinBtc = minBtc
+		}
+
+		if _, err := mathutil.ParseRate(t.Rate); err != nil {
+			errs = append(errs, fmt.Errorf("sky_exchanger.sky_btc_rate_tiers[%d].rate invalid: %v", i, err))
+		}
+	}
+
+	if threshold, err := c.KYCThresholdDroplets(); err != nil {
+		errs = append(errs, fmt.Errorf("sky_exchanger.kyc_threshold_sky invalid: %v", err))
+	} else if c.KYCThresholdSky != "" && threshold == 0 {
+		errs = append(errs, errors.New("sky_exchanger.kyc_threshold_sky must be greater than 0"))
+	}
+
+	if allowance, err := c.SendAllowanceDroplets(); err != nil {
+		errs = append(errs, fmt.Errorf("sky_exchanger.send_allowance_sky invalid: %v", err))
+	} else if c.SendAllowanceSky != "" && allowance == 0 {
+		errs = append(errs, errors.New("sky_exchanger.send_allowance_sky must be greater than 0"))
+	}
+
+	if c.SendAllowanceWindow < 0 {
+		errs = append(errs, errors.New("sky_exchanger.send_allowance_window can't be negative"))
+	} else if c.SendAllowanceSky != "" && c.SendAllowanceWindow == 0 {
+		errs = append(errs, errors.New("sky_exchanger.send_allowance_window must be set if sky_exchanger.send_allowance_sky is set"))
+	}
+
+	if c.DepositHistoryLimit < 0 {
+		errs = append(errs, errors.New("sky_exchanger.deposit_history_limit can't be negative"))
+	}
+
 	return errs
 }
 
+// KYCThresholdDroplets returns sky_exchanger.kyc_threshold_sky in droplets, 0 if it is not set
+func (c SkyExchanger) KYCThresholdDroplets() (uint64, error) {
+	if c.KYCThresholdSky == "" {
+		return 0, nil
+	}
+
+	return droplet.FromString(c.KYCThresholdSky)
+}
+
+// SendAllowanceDroplets returns sky_exchanger.send_allowance_sky in droplets, 0 if it is not set
+func (c SkyExchanger) SendAllowanceDroplets() (uint64, error) {
+	if c.SendAllowanceSky == "" {
+		return 0, nil
+	}
+
+	return droplet.FromString(c.SendAllowanceSky)
+}
+
 func (c SkyExchanger) validateWallet() []error {
 	var errs []error
 
@@ -206,6 +427,47 @@ type Web struct {
 	ThrottleMax      int64         `mapstructure:"throttle_max"` // Maximum number of requests per duration
 	ThrottleDuration time.Duration `mapstructure:"throttle_duration"`
 	BehindProxy      bool          `mapstructure:"behind_proxy"`
+	AccessLog        bool          `mapstructure:"access_log"` // Log the method, path, status, duration and remote IP of each request
+	// Redact query params containing addresses in the access log
+	AccessLogRedactAddresses bool `mapstructure:"access_log_redact_addresses"`
+	// Maximum size of a request body. Larger requests are rejected with 413 Request Entity Too Large
+	MaxRequestBodyBytes int64 `mapstructure:"max_request_body_bytes"`
+	// Maximum time a long-poll status request waits for a status change.
+	// Its write timeout is extended by this, so it is not cut off by WriteTimeout
+	LongPollTimeout time.Duration `mapstructure:"long_poll_timeout"`
+	// Maximum time to read a request, including its body
+	ReadTimeout time.Duration `mapstructure:"read_timeout"`
+	// Maximum time to read a request's headers. Cuts off clients that send their headers slowly
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+	// Maximum time to write a response, from the end of reading the request headers
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// Maximum time to keep an idle keep-alive connection open
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+	// Maximum number of skycoin addresses in a bulk status request
+	MaxBulkStatusAddrs int `mapstructure:"max_bulk_status_addrs"`
+	// Wrap API responses in a versioned {"api_version", "data", "error"} envelope
+	APIEnvelope bool `mapstructure:"api_envelope"`
+	// Origins allowed to make cross-origin API requests. "*" allows all origins. No CORS headers are sent if empty
+	CORSAllowedOrigins []string `mapstructure:"cors_allowed_origins"`
+	// Methods allowed in cross-origin API requests. Defaults to GET, POST and HEAD if empty
+	CORSAllowedMethods []string `mapstructure:"cors_allowed_methods"`
+	// Allow cross-origin API requests with credentials, e.g. cookies
+	CORSAllowCredentials bool `mapstructure:"cors_allow_credentials"`
+	// Public URL the API is served at, including any path prefix added by a reverse proxy, e.g. https://example.com/teller.
+	// Used to return ready-to-use status URLs from bind. No URLs are returned if empty
+	BaseURL string `mapstructure:"base_url"`
+	// Certificates selected by the hostname clients request with SNI. Clients requesting another hostname,
+	// or none, are served TLSCert
+	TLSHosts []TLSHost `mapstructure:"tls_hosts"`
+	// Directory where the certificates obtained for AutoTLSHost are cached. It is created at startup if missing
+	AutoTLSCacheDir string `mapstructure:"auto_tls_cache_dir"`
+}
+
+// TLSHost is the TLS certificate served for a hostname
+type TLSHost struct {
+	Host string `mapstructure:"host"`
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
 }
 
 // Validate validates Web config
@@ -230,12 +492,97 @@ func (c Web) Validate() error {
 		return errors.New("web.auto_tls_host or web.tls_key or web.tls_cert is set but web.https_addr is not enabled")
 	}
 
+	if c.AutoTLSHost != "" && c.AutoTLSCacheDir == "" {
+		return errors.New("web.auto_tls_cache_dir must be set when using web.auto_tls_host")
+	}
+
+	if len(c.TLSHosts) != 0 {
+		if c.HTTPSAddr == "" || c.TLSCert == "" {
+			return errors.New("web.tls_hosts requires web.https_addr, and web.tls_cert and web.tls_key for clients requesting other hostnames")
+		}
+
+		hosts := make(map[string]struct{}, len(c.TLSHosts))
+		for i, h := range c.TLSHosts {
+			if h.Host == "" || h.Cert == "" || h.Key == "" {
+				return fmt.Errorf("web.tls_hosts[%d] must set host, cert and key", i)
+			}
+
+			host := strings.ToLower(h.Host)
+			if _, ok := hosts[host]; ok {
+				return fmt.Errorf("web.tls_hosts[%d].host %s is duplicated", i, h.Host)
+			}
+			hosts[host] = struct{}{}
+		}
+	}
+
+	if c.MaxRequestBodyBytes <= 0 {
+		return errors.New("web.max_request_body_bytes must be greater than 0")
+	}
+
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil {
+			return fmt.Errorf("web.base_url is invalid: %v", err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return errors.New("web.base_url must be an absolute http or https URL without a query or fragment")
+		}
+	}
+
+	// Long polls hold a connection open, keep them short
+	if c.LongPollTimeout <= 0 || c.LongPollTimeout >= time.Minute {
+		return errors.New("web.long_poll_timeout must be greater than 0 and less than 1m")
+	}
+
+	if c.ReadTimeout <= 0 {
+		return errors.New("web.read_timeout must be greater than 0")
+	}
+
+	if c.ReadHeaderTimeout <= 0 || c.ReadHeaderTimeout > c.ReadTimeout {
+		return errors.New("web.read_header_timeout must be greater than 0 and at most web.read_timeout")
+	}
+
+	if c.WriteTimeout <= 0 {
+		return errors.New("web.write_timeout must be greater than 0")
+	}
+
+	if c.IdleTimeout <= 0 {
+		return errors.New("web.idle_timeout must be greater than 0")
+	}
+
+	if c.MaxBulkStatusAddrs <= 0 {
+		return errors.New("web.max_bulk_status_addrs must be greater than 0")
+	}
+
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin == "*" && c.CORSAllowCredentials {
+			return errors.New("web.cors_allow_credentials can't be used with the \"*\" web.cors_allowed_origins")
+		}
+	}
+
 	return nil
 }
 
 // AdminPanel config for the admin panel AdminPanel
 type AdminPanel struct {
 	Host string `mapstructure:"host"`
+	// Token required to stream deposit status changes. The stream is disabled if empty
+	EventsToken string `mapstructure:"events_token"`
+	// Tokens of the operators allowed to review held deposits and pause or drain sending, keyed by operator name.
+	// The operator name is recorded in the review audit log. These endpoints are disabled if empty
+	OperatorTokens map[string]string `mapstructure:"operator_tokens"`
+	// Serve metrics in the Prometheus text format at /metrics
+	Metrics bool `mapstructure:"metrics"`
+	// Export the value deposited to the deposit addresses that received the most, up to this many per coin type.
+	// The other addresses are summed into one series. 0 disables the per-address metric
+	MetricsTopAddresses int `mapstructure:"metrics_top_addresses"`
+}
+
+// Notify config for the deposit event notifications
+type Notify struct {
+	// URLs that each deposit event is POSTed to as JSON. Notifications are disabled if empty
+	WebhookURLs []string `mapstructure:"webhook_urls"`
 }
 
 // Dummy config for the fake sender and scanner
@@ -255,6 +602,34 @@ func (c Config) Redacted() Config {
 		c.BtcRPC.Pass = "<redacted>"
 	}
 
+	if c.Teller.ReceiptKey != "" {
+		c.Teller.ReceiptKey = "<redacted>"
+	}
+
+	if c.AdminPanel.EventsToken != "" {
+		c.AdminPanel.EventsToken = "<redacted>"
+	}
+
+	if len(c.AdminPanel.OperatorTokens) != 0 {
+		operatorTokens := make(map[string]string, len(c.AdminPanel.OperatorTokens))
+		for name := range c.AdminPanel.OperatorTokens {
+			operatorTokens[name] = "<redacted>"
+		}
+		c.AdminPanel.OperatorTokens = operatorTokens
+	}
+
+	// Webhook URLs often embed a secret, e.g. Slack's, so only the host is kept
+	if len(c.Notify.WebhookURLs) != 0 {
+		webhookURLs := make([]string, len(c.Notify.WebhookURLs))
+		for i, webhookURL := range c.Notify.WebhookURLs {
+			webhookURLs[i] = "<redacted>"
+			if u, err := url.Parse(webhookURL); err == nil && u.Host != "" {
+				webhookURLs[i] = fmt.Sprintf("%s://%s/<redacted>", u.Scheme, u.Host)
+			}
+		}
+		c.Notify.WebhookURLs = webhookURLs
+	}
+
 	return c
 }
 
@@ -265,6 +640,48 @@ func (c Config) Validate() error {
 		errs = append(errs, err)
 	}
 
+	if c.Teller.ReceiptKey != "" {
+		if seed, err := hex.DecodeString(c.Teller.ReceiptKey); err != nil || len(seed) != ed25519.SeedSize {
+			oops(fmt.Sprintf("teller.receipt_key must be a hex encoded %d byte Ed25519 seed", ed25519.SeedSize))
+		}
+	}
+
+	if c.Teller.MaxBoundAddresses < 0 {
+		oops("teller.max_bound_addrs can't be negative")
+	}
+
+	if c.Teller.RequireAddressProof && c.Teller.AddressProofTTL <= 0 {
+		oops("teller.address_proof_ttl must be greater than 0")
+	}
+
+	statuses := make([]string, 0, len(c.Teller.StatusMessages))
+	for st := range c.Teller.StatusMessages {
+		statuses = append(statuses, st)
+	}
+	sort.Strings(statuses)
+	for _, st := range statuses {
+		if c.Teller.StatusMessages[st] == "" {
+			oops(fmt.Sprintf("teller.status_messages.%s missing", st))
+		}
+	}
+
+	if c.AddressPoolLowWatermark < 0 {
+		oops("address_pool_low_watermark can't be negative")
+	}
+
+	if c.DBCompactInterval < 0 {
+		oops("db_compact_interval can't be negative")
+	}
+	if c.DBCompactMinFreePercent < 0 || c.DBCompactMinFreePercent > 100 {
+		oops("db_compact_min_free_percent must be between 0 and 100")
+	}
+	if c.DBInitialMmapSize < 0 {
+		oops("db_initial_mmap_size can't be negative")
+	}
+	if c.DBSnapshotTempFileSize < 0 {
+		oops("db_snapshot_temp_file_size can't be negative")
+	}
+
 	if c.BtcAddresses == "" {
 		oops("btc_addresses missing")
 	}
@@ -283,15 +700,39 @@ func (c Config) Validate() error {
 			oops("sky_rpc.address missing")
 		}
 
-		// test if skycoin node rpc service is reachable
-		conn, err := net.Dial("tcp", c.SkyRPC.Address)
-		if err != nil {
-			oops(fmt.Sprintf("sky_rpc.address connect failed: %v", err))
-		} else {
+		// test if skycoin node rpc service is reachable.
+		// If fallback nodes are configured, only one node needs to be reachable
+		var reachable int
+		for _, addr := range c.SkyRPC.FallbackAddresses {
+			if addr == "" {
+				oops("sky_rpc.fallback_addresses contains an empty address")
+			}
+		}
+
+		for _, addr := range append([]string{c.SkyRPC.Address}, c.SkyRPC.FallbackAddresses...) {
+			if addr == "" {
+				continue
+			}
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				if len(c.SkyRPC.FallbackAddresses) == 0 {
+					oops(fmt.Sprintf("sky_rpc.address connect failed: %v", err))
+				} else {
+					log.Printf("Skycoin node %s connect failed: %v", addr, err)
+				}
+				continue
+			}
+
+			reachable++
 			if err := conn.Close(); err != nil {
-				log.Printf("Failed to close test connection to sky_rpc.address: %v", err)
+				log.Printf("Failed to close test connection to %s: %v", addr, err)
 			}
 		}
+
+		if reachable == 0 && len(c.SkyRPC.FallbackAddresses) != 0 {
+			oops("sky_rpc.address and sky_rpc.fallback_addresses connect failed")
+		}
 	}
 
 	if !c.Dummy.Scanner {
@@ -347,12 +788,46 @@ func (c Config) Validate() error {
 		for _, err := range exchangeErrs {
 			oops(err.Error())
 		}
+
+		if c.SkyExchanger.TestMode && !c.SkyExchanger.TestModeRealSender {
+			oops("sky_exchanger.test_mode sends real SKY at sky_exchanger.test_mode_rate with the real sender, set sky_exchanger.test_mode_real_sender to allow it or enable dummy.sender")
+		}
 	}
 
 	if err := c.Web.Validate(); err != nil {
 		oops(err.Error())
 	}
 
+	operatorNames := make([]string, 0, len(c.AdminPanel.OperatorTokens))
+	for name := range c.AdminPanel.OperatorTokens {
+		operatorNames = append(operatorNames, name)
+	}
+	sort.Strings(operatorNames)
+
+	operators := make(map[string]string, len(operatorNames))
+	for _, name := range operatorNames {
+		token := c.AdminPanel.OperatorTokens[name]
+		if token == "" {
+			oops(fmt.Sprintf("admin_panel.operator_tokens.%s missing", name))
+			continue
+		}
+		if other, ok := operators[token]; ok {
+			oops(fmt.Sprintf("admin_panel.operator_tokens.%s has the same token as %s", name, other))
+		}
+		operators[token] = name
+	}
+
+	if c.AdminPanel.MetricsTopAddresses < 0 {
+		oops("admin_panel.metrics_top_addresses can't be negative")
+	}
+
+	for i, webhookURL := range c.Notify.WebhookURLs {
+		u, err := url.Parse(webhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			oops(fmt.Sprintf("notify.webhook_urls[%d] must be an absolute http or https URL", i))
+		}
+	}
+
 	if len(errs) == 0 {
 		return nil
 	}
@@ -363,12 +838,19 @@ func (c Config) Validate() error {
 func setDefaults() {
 	// Top-level args
 	viper.SetDefault("profile", false)
+	viper.SetDefault("run_preflight", false)
 	viper.SetDefault("debug", true)
 	viper.SetDefault("logfile", "./teller.log")
 	viper.SetDefault("dbfile", "teller.db")
+	viper.SetDefault("db_compact_interval", time.Duration(0))
+	viper.SetDefault("db_compact_min_free_percent", 25.0)
+	viper.SetDefault("db_initial_mmap_size", 0)
+	viper.SetDefault("db_allow_network_fs", false)
+	viper.SetDefault("db_snapshot_temp_file_size", 64*1024*1024)
 
 	// Teller
-	viper.SetDefault("teller.max_bound_btc_addrs", 5)
+	viper.SetDefault("teller.max_bound_addrs", 0)
+	viper.SetDefault("teller.address_proof_ttl", time.Minute*5)
 
 	// SkyRPC
 	viper.SetDefault("sky_rpc.address", "127.0.0.1:6430")
@@ -389,17 +871,39 @@ func setDefaults() {
 	viper.SetDefault("sky_exchanger.tx_confirmation_check_wait", time.Second*5)
 	viper.SetDefault("sky_exchanger.max_decimals", 3)
 	viper.SetDefault("sky_exchanger.buy_method", BuyMethodDirect)
+	viper.SetDefault("sky_exchanger.rounding_mode", RoundingDown)
+	viper.SetDefault("sky_exchanger.coin_hour_strategy", string(sender.CoinHourStrategyShare))
+	viper.SetDefault("sky_exchanger.batch_interval", time.Second*10)
+	viper.SetDefault("sky_exchanger.deposit_history_limit", 20)
+	viper.SetDefault("sky_exchanger.test_mode", false)
+	viper.SetDefault("sky_exchanger.test_mode_rate", "1")
+	viper.SetDefault("sky_exchanger.test_mode_real_sender", false)
+	viper.SetDefault("sky_exchanger.scan_drift_check_interval", time.Hour)
 
 	// Web
 	viper.SetDefault("web.bind_enabled", true)
 	viper.SetDefault("web.send_enabled", true)
 	viper.SetDefault("web.http_addr", "127.0.0.1:7071")
 	viper.SetDefault("web.static_dir", "./web/build")
+	viper.SetDefault("web.auto_tls_cache_dir", "cert-cache")
 	viper.SetDefault("web.throttle_max", int64(60))
 	viper.SetDefault("web.throttle_duration", time.Minute)
+	viper.SetDefault("web.access_log", true)
+	viper.SetDefault("web.max_request_body_bytes", int64(64*1024))
+	viper.SetDefault("web.long_poll_timeout", time.Second*30)
+	viper.SetDefault("web.read_timeout", time.Second*10)
+	viper.SetDefault("web.read_header_timeout", time.Second*5)
+	viper.SetDefault("web.write_timeout", time.Second*60)
+	viper.SetDefault("web.idle_timeout", time.Second*120)
+	viper.SetDefault("web.max_bulk_status_addrs", 20)
+	viper.SetDefault("web.api_envelope", false)
+	// Allow requests from a local skycoin wallet
+	viper.SetDefault("web.cors_allowed_origins", []string{"http://127.0.0.1:6420"})
+	viper.SetDefault("web.cors_allow_credentials", false)
 
 	// AdminPanel
 	viper.SetDefault("admin_panel.host", "127.0.0.1:7711")
+	viper.SetDefault("admin_panel.metrics_top_addresses", 0)
 
 	// DummySender
 	viper.SetDefault("dummy.http_addr", "127.0.0.1:4121")
@@ -0,0 +1,420 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkyExchangerValidateRateTiers(t *testing.T) {
+	cases := []struct {
+		name  string
+		tiers []RateTier
+		errs  []error
+	}{
+		{
+			name: "no tiers",
+		},
+		{
+			name: "sorted tiers",
+			tiers: []RateTier{
+				{MinBtc: "0.5", Rate: "550"},
+				{MinBtc: "1", Rate: "600"},
+			},
+		},
+		{
+			name: "unsorted tiers",
+			tiers: []RateTier{
+				{MinBtc: "1", Rate: "600"},
+				{MinBtc: "0.5", Rate: "550"},
+			},
+			errs: []error{
+				errors.New("sky_exchanger.sky_btc_rate_tiers[1].min_btc must be greater than the previous tier's min_btc, and greater than 0"),
+			},
+		},
+		{
+			name: "overlapping tiers",
+			tiers: []RateTier{
+				{MinBtc: "1", Rate: "550"},
+				{MinBtc: "1.0", Rate: "600"},
+			},
+			errs: []error{
+				errors.New("sky_exchanger.sky_btc_rate_tiers[1].min_btc must be greater than the previous tier's min_btc, and greater than 0"),
+			},
+		},
+		{
+			name: "zero min_btc",
+			tiers: []RateTier{
+				{MinBtc: "0", Rate: "550"},
+			},
+			errs: []error{
+				errors.New("sky_exchanger.sky_btc_rate_tiers[0].min_btc must be greater than the previous tier's min_btc, and greater than 0"),
+			},
+		},
+		{
+			name: "invalid values",
+			tiers: []RateTier{
+				{MinBtc: "0.000000001", Rate: "0"},
+			},
+			errs: []error{
+				errors.New("sky_exchanger.sky_btc_rate_tiers[0].min_btc invalid: BTC amount has more than 8 decimal places"),
+				errors.New("sky_exchanger.sky_btc_rate_tiers[0].rate invalid: rate must be greater than zero"),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := SkyExchanger{
+				SkyBtcExchangeRate: "500",
+				SkyEthExchangeRate: "50",
+				BuyMethod:          BuyMethodDirect,
+				SkyBtcRateTiers:    tc.tiers,
+			}
+
+			require.Equal(t, tc.errs, c.validate())
+		})
+	}
+}
+
+func TestSkyExchangerValidateCoinHourStrategy(t *testing.T) {
+	cases := []struct {
+		strategy string
+		errs     []error
+	}{
+		{strategy: ""},
+		{strategy: "share"},
+		{strategy: "minimal"},
+		{strategy: "burn"},
+		{
+			strategy: "all",
+			errs: []error{
+				errors.New(`sky_exchanger.coin_hour_strategy must be "share", "minimal" or "burn"`),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.strategy, func(t *testing.T) {
+			c := SkyExchanger{
+				SkyBtcExchangeRate: "500",
+				SkyEthExchangeRate: "50",
+				BuyMethod:          BuyMethodDirect,
+				CoinHourStrategy:   tc.strategy,
+			}
+
+			require.Equal(t, tc.errs, c.validate())
+		})
+	}
+}
+
+func TestSkyExchangerValidateRoundingMode(t *testing.T) {
+	cases := []struct {
+		mode string
+		errs []error
+	}{
+		{mode: ""},
+		{mode: "down"},
+		{mode: "nearest"},
+		{mode: "up"},
+		{
+			mode: "bankers",
+			errs: []error{
+				errors.New(`sky_exchanger.rounding_mode must be "down", "nearest" or "up"`),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.mode, func(t *testing.T) {
+			c := SkyExchanger{
+				SkyBtcExchangeRate: "500",
+				SkyEthExchangeRate: "50",
+				BuyMethod:          BuyMethodDirect,
+				RoundingMode:       tc.mode,
+			}
+
+			require.Equal(t, tc.errs, c.validate())
+		})
+	}
+}
+
+func TestSkyExchangerValidateBatch(t *testing.T) {
+	cases := []struct {
+		name          string
+		batchSize     int
+		batchInterval time.Duration
+		errs          []error
+	}{
+		{name: "disabled"},
+		{name: "single", batchSize: 1},
+		{name: "enabled", batchSize: 10, batchInterval: time.Second},
+		{
+			name:      "negative size",
+			batchSize: -1,
+			errs: []error{
+				errors.New("sky_exchanger.batch_size can't be negative"),
+			},
+		},
+		{
+			name:          "negative interval",
+			batchInterval: -time.Second,
+			errs: []error{
+				errors.New("sky_exchanger.batch_interval can't be negative"),
+			},
+		},
+		{
+			name:      "no interval",
+			batchSize: 10,
+			errs: []error{
+				errors.New("sky_exchanger.batch_interval must be set if sky_exchanger.batch_size is greater than 1"),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := SkyExchanger{
+				SkyBtcExchangeRate: "500",
+				SkyEthExchangeRate: "50",
+				BuyMethod:          BuyMethodDirect,
+				BatchSize:          tc.batchSize,
+				BatchInterval:      tc.batchInterval,
+			}
+
+			require.Equal(t, tc.errs, c.validate())
+		})
+	}
+}
+
+func TestSkyExchangerValidateDepositAddressPrefixes(t *testing.T) {
+	c := SkyExchanger{
+		SkyBtcExchangeRate:     "500",
+		SkyEthExchangeRate:     "50",
+		BuyMethod:              BuyMethodDirect,
+		DepositAddressPrefixes: []string{"1", ""},
+	}
+
+	require.Equal(t, []error{
+		errors.New("sky_exchanger.deposit_address_prefixes[1] can't be empty"),
+	}, c.validate())
+
+	c.DepositAddressPrefixes = []string{"1", "3"}
+	require.Empty(t, c.validate())
+}
+
+func TestSkyExchangerValidateKYCThreshold(t *testing.T) {
+	cases := []struct {
+		threshold string
+		droplets  uint64
+		valid     bool
+	}{
+		{"", 0, true},
+		{"1000", 1000e6, true},
+		{"0.5", 5e5, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"1.0000001", 0, false},
+		{"bad", 0, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.threshold, func(t *testing.T) {
+			c := SkyExchanger{
+				SkyBtcExchangeRate: "500",
+				SkyEthExchangeRate: "50",
+				BuyMethod:          BuyMethodDirect,
+				KYCThresholdSky:    tc.threshold,
+			}
+
+			errs := c.validate()
+			if !tc.valid {
+				require.Len(t, errs, 1)
+				require.Contains(t, errs[0].Error(), "sky_exchanger.kyc_threshold_sky")
+				return
+			}
+
+			require.Empty(t, errs)
+
+			droplets, err := c.KYCThresholdDroplets()
+			require.NoError(t, err)
+			require.Equal(t, tc.droplets, droplets)
+		})
+	}
+}
+
+func TestSkyExchangerValidateSendAllowance(t *testing.T) {
+	cases := []struct {
+		name      string
+		allowance string
+		window    time.Duration
+		droplets  uint64
+		valid     bool
+	}{
+		{"unset", "", 0, 0, true},
+		{"hourly", "1000", time.Hour, 1000e6, true},
+		{"fraction", "0.5", time.Minute, 5e5, true},
+		{"window without allowance", "", time.Hour, 0, true},
+		{"no window", "1000", 0, 0, false},
+		{"negative window", "1000", -time.Hour, 0, false},
+		{"zero", "0", time.Hour, 0, false},
+		{"too precise", "1.0000001", time.Hour, 0, false},
+		{"bad", "bad", time.Hour, 0, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := SkyExchanger{
+				SkyBtcExchangeRate:  "500",
+				SkyEthExchangeRate:  "50",
+				BuyMethod:           BuyMethodDirect,
+				SendAllowanceSky:    tc.allowance,
+				SendAllowanceWindow: tc.window,
+			}
+
+			errs := c.validate()
+			if !tc.valid {
+				require.Len(t, errs, 1)
+				require.Contains(t, errs[0].Error(), "sky_exchanger.send_allowance")
+				return
+			}
+
+			require.Empty(t, errs)
+
+			droplets, err := c.SendAllowanceDroplets()
+			require.NoError(t, err)
+			require.Equal(t, tc.droplets, droplets)
+		})
+	}
+}
+
+func TestSkyExchangerValidateTestMode(t *testing.T) {
+	cases := []struct {
+		name     string
+		testMode bool
+		rate     string
+		valid    bool
+	}{
+		{"disabled ignores rate", false, "bad", true},
+		{"one to one", true, "1", true},
+		{"fraction", true, "0.001", true},
+		{"zero", true, "0", false},
+		{"bad", true, "bad", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := SkyExchanger{
+				SkyBtcExchangeRate: "500",
+				SkyEthExchangeRate: "50",
+				BuyMethod:          BuyMethodDirect,
+				TestMode:           tc.testMode,
+				TestModeRate:       tc.rate,
+			}
+
+			errs := c.validate()
+			if !tc.valid {
+				require.Len(t, errs, 1)
+				require.Contains(t, errs[0].Error(), "sky_exchanger.test_mode_rate")
+				return
+			}
+
+			require.Empty(t, errs)
+		})
+	}
+}
+
+func TestWebValidateTLSHosts(t *testing.T) {
+	host := func(name string) TLSHost {
+		return TLSHost{
+			Host: name,
+			Cert: name + ".crt",
+			Key:  name + ".key",
+		}
+	}
+
+	tt := []struct {
+		name     string
+		tlsCert  string
+		tlsHosts []TLSHost
+		valid    bool
+	}{
+		{"unset", "default.crt", nil, true},
+		{"hosts", "default.crt", []TLSHost{host("a.example.com"), host("b.example.com")}, true},
+		{"no fallback", "", []TLSHost{host("a.example.com")}, false},
+		{"missing key", "default.crt", []TLSHost{{Host: "a.example.com", Cert: "a.crt"}}, false},
+		{"missing host", "default.crt", []TLSHost{{Cert: "a.crt", Key: "a.key"}}, false},
+		{"duplicate host", "default.crt", []TLSHost{host("a.example.com"), host("A.example.com")}, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Web{
+				HTTPSAddr:           "127.0.0.1:7072",
+				MaxRequestBodyBytes: 1024,
+				LongPollTimeout:     time.Second * 30,
+				ReadTimeout:         time.Second * 10,
+				ReadHeaderTimeout:   time.Second * 5,
+				WriteTimeout:        time.Second * 60,
+				IdleTimeout:         time.Second * 120,
+				MaxBulkStatusAddrs:  100,
+				TLSHosts:            tc.tlsHosts,
+			}
+			if tc.tlsCert != "" {
+				c.TLSCert = tc.tlsCert
+				c.TLSKey = "default.key"
+			} else {
+				c.AutoTLSHost = "example.com"
+				c.AutoTLSCacheDir = "cert-cache"
+			}
+
+			err := c.Validate()
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestWebValidateBaseURL(t *testing.T) {
+	tt := []struct {
+		name    string
+		baseURL string
+		valid   bool
+	}{
+		{"unset", "", true},
+		{"https", "https://example.com", true},
+		{"path prefix", "https://example.com/teller/", true},
+		{"relative", "/teller", false},
+		{"other scheme", "ftp://example.com", false},
+		{"query", "https://example.com/?a=b", false},
+		{"fragment", "https://example.com/#a", false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Web{
+				HTTPAddr:            "127.0.0.1:7071",
+				MaxRequestBodyBytes: 1024,
+				LongPollTimeout:     time.Second * 30,
+				ReadTimeout:         time.Second * 10,
+				ReadHeaderTimeout:   time.Second * 5,
+				WriteTimeout:        time.Second * 60,
+				IdleTimeout:         time.Second * 120,
+				MaxBulkStatusAddrs:  100,
+				BaseURL:             tc.baseURL,
+			}
+
+			err := c.Validate()
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
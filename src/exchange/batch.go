@@ -0,0 +1,303 @@
+package exchange
+
+import (
+	"time"
+
+	"github.com/skycoin/teller/src/sender"
+)
+
+// batching returns true if StatusWaitSend deposits are sent in batches, see sky_exchanger.batch_size
+func (s *Send) batching() bool {
+	return s.cfg.BatchSize > 1
+}
+
+// flushBatch sends the pending batch of StatusWaitSend deposits in one transaction,
+// then waits for the confirmation of each deposit like processWaitSendDeposit.
+//
+// Deposits that can't share the batch's transaction are sent separately: deposits that are invalid
+// or have nothing to send, so that they are handled like any other deposit, deposits with a different
+// coin hour strategy than the first deposit, deposits to a skycoin address that is already in the batch,
+// and deposits greater than the KYC threshold, so that they are held for KYC if needed.
+// If the batch would exceed the send allowance, its deposits are sent separately, so that they are rate limited.
+// If the batch's transaction fails for a reason other than a temporary failure, every deposit of the batch
+// remains StatusWaitSend, and they are sent separately.
+// It returns an error if sending must stop.
+func (s *Send) flushBatch() error {
+	pending := s.batch
+	s.batch = nil
+
+	log := s.log.WithField("pendingDeposits", len(pending))
+
+	var batch, separate []DepositInfo
+	var opt sender.SendOption
+	amounts := make(map[string]uint64, len(pending))
+	skyAddrs := make(map[string]struct{}, len(pending))
+	for _, di := range pending {
+		// A deposit whose rate can't be reloaded is sent separately, where the failure is handled
+		di, err := s.reloadRate(di)
+		if err != nil {
+			separate = append(separate, di)
+			continue
+		}
+
+		diOpt, amt, ok := s.batchAmount(di)
+		_, dup := skyAddrs[di.SkyAddress]
+		if !ok || dup || (len(batch) != 0 && diOpt != opt) {
+			separate = append(separate, di)
+			continue
+		}
+
+		if len(batch) == 0 {
+			opt = diOpt
+		}
+
+		batch = append(batch, di)
+		amounts[di.DepositID] = amt
+		skyAddrs[di.SkyAddress] = struct{}{}
+	}
+
+	// A batch of one deposit is sent like any other deposit
+	if len(batch) == 1 {
+		separate = append(batch, separate...)
+		batch = nil
+	}
+
+	// A batch that doesn't fit in the send allowance is sent separately, so that its deposits are rate limited
+	if len(batch) != 0 {
+		var total uint64
+		for _, amt := range amounts {
+			total += amt
+		}
+
+		if fits, err := s.allowanceFits(total); err != nil || !fits {
+			separate = append(batch, separate...)
+			batch = nil
+		}
+	}
+
+	if len(batch) != 0 {
+		sent, err := s.processBatch(batch, amounts, opt)
+		switch err {
+		case nil:
+			// The sent deposits are StatusWaitConfirm, wait for their confirmation
+			separate = append(sent, separate...)
+		case ErrReadOnly, ErrSentNotRecorded:
+			s.metrics.Counter("teller_send_failures_total", "Deposits that failed to send", nil).Add(float64(len(batch)))
+			for _, di := range batch {
+				s.notifySendFailed(di, err)
+			}
+			return s.stopReadOnly(log, err)
+		default:
+			log.WithError(err).Error("processBatch failed. The deposits of the batch will be sent separately.")
+			separate = append(batch, separate...)
+		}
+	}
+
+	for _, di := range separate {
+		if err := s.process(di); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// batchAmount returns the send option and amount of a deposit, and false if it can't be sent in a batch
+func (s *Send) batchAmount(di DepositInfo) (sender.SendOption, uint64, bool) {
+	if di.SkyAddress == "" || di.ValidateForStatus() != nil {
+		return sender.SendOption{}, 0, false
+	}
+
+	opt, err := s.sendOption(di)
+	if err != nil {
+		return sender.SendOption{}, 0, false
+	}
+
+	// Deposits that may be held for KYC are checked by processWaitSendDeposit
+	amt, err := s.sendAmount(di)
+	if err != nil || amt == 0 || (s.kycThreshold != 0 && amt > s.kycThreshold) {
+		return sender.SendOption{}, 0, false
+	}
+
+	return opt, amt, true
+}
+
+// processBatch sends a batch of StatusWaitSend deposits in one transaction, returning the deposits set to StatusWaitConfirm.
+// Skycoin RPC errors and store write failures are retried like in processWaitSendDeposit.
+// If it fails, every deposit of the batch remains StatusWaitSend.
+func (s *Send) processBatch(batch []DepositInfo, amounts map[string]uint64, opt sender.SendOption) ([]DepositInfo, error) {
+	log := s.log.WithField("batchSize", len(batch))
+
+	for {
+		select {
+		case <-s.quit:
+			return nil, nil
+		default:
+		}
+
+		if !s.gate.wait(s.quit) {
+			return nil, nil
+		}
+
+		sent, err := s.sendBatch(batch, amounts, opt)
+
+		s.setStatus(err)
+
+		if code := sendErrorCode(err); code != "" {
+			ids := make([]string, len(batch))
+			for i, di := range batch {
+				ids[i] = di.DepositID
+			}
+			s.recordSendError(ids, code)
+		}
+
+		if _, ok := err.(StoreWriteErr); ok {
+			s.storeWriteFailures++
+		} else if err != ErrSentNotRecorded {
+			s.storeWriteFailures = 0
+		}
+
+		switch err.(type) {
+		case StoreWriteErr:
+			log.WithError(err).WithField("storeWriteFailures", s.storeWriteFailures).Error("sendBatch failed to save the deposits")
+			if s.storeWriteFailures >= maxStoreWriteFailures {
+				return nil, ErrReadOnly
+			}
+		case sender.RPCError:
+			// Treat skycoin RPC/CLI errors as temporary, see processWaitSendDeposit
+			log.WithError(err).Error("sendBatch failed")
+		default:
+			return sent, err
+		}
+
+		select {
+		case <-time.After(s.cfg.TxConfirmationCheckWait):
+		case <-s.quit:
+			return nil, nil
+		}
+	}
+}
+
+// sendBatch creates a transaction with an output to the skycoin address of each deposit of the batch.
+// Within a bolt.DB transaction, it sets every deposit to StatusWaitConfirm with the transaction's txid,
+// then broadcasts the transaction. If the broadcast fails, no deposit is updated.
+func (s *Send) sendBatch(batch []DepositInfo, amounts map[string]uint64, opt sender.SendOption) ([]DepositInfo, error) {
+	log := s.log.WithField("batchSize", len(batch))
+
+	ids := make([]string, len(batch))
+	recipients := make([]sender.Recipient, len(batch))
+	rates := make(map[string]string, len(batch))
+	values := make(map[string]SkyValue, len(batch))
+	conversionRates := make(map[string]string, len(batch))
+	var total uint64
+	for i, di := range batch {
+		rate, err := appliedRate(di)
+		if err != nil {
+			log.WithError(err).WithField("depositID", di.DepositID).Error("appliedRate failed")
+			return nil, err
+		}
+
+		v, err := s.calculateSkyValue(di)
+		if err != nil {
+			log.WithError(err).WithField("depositID", di.DepositID).Error("calculateSkyValue failed")
+			return nil, err
+		}
+
+		rates[di.DepositID] = rate
+		values[di.DepositID] = v
+		conversionRates[di.DepositID] = di.ConversionRate
+		ids[i] = di.DepositID
+		recipients[i] = sender.Recipient{
+			Addr:  di.SkyAddress,
+			Coins: amounts[di.DepositID],
+		}
+		total += amounts[di.DepositID]
+	}
+
+	log = log.WithField("sendAmtDroplets", total)
+	log = log.WithField("coinHourStrategy", opt.CoinHourStrategy)
+
+	log.Info("Creating skycoin batch transaction")
+
+	skyTx, err := s.sender.CreateBatchTransaction(recipients, opt)
+	if err != nil {
+		log.WithError(err).Error("sender.CreateBatchTransaction failed")
+		return nil, err
+	}
+
+	log = log.WithField("transactionOutput", skyTx.Out)
+
+	for _, di := range batch {
+		if err := verifyCreatedTransaction(skyTx, di, amounts[di.DepositID]); err != nil {
+			log.WithError(err).WithField("depositID", di.DepositID).Error("verifyCreatedTransaction failed")
+			return nil, err
+		}
+	}
+
+	var broadcastErr error
+	var broadcast bool
+	sent, err := s.store.UpdateDepositInfosCallback(ids, func(di DepositInfo) DepositInfo {
+		// A deposit may have been set aside or repriced while the transaction was created
+		if di.Status != StatusWaitSend || di.ConversionRate != conversionRates[di.DepositID] {
+			return di
+		}
+		di.Status = StatusWaitConfirm
+		di.Txid = skyTx.TxIDHex()
+		di.SkySent = ownSkySent(di, values[di.DepositID], amounts[di.DepositID])
+		di.CoinHourStrategy = string(opt.CoinHourStrategy)
+		di.AppliedRate = rates[di.DepositID]
+		di.RoundingDroplets = values[di.DepositID].RoundingDroplets
+		di.Memo = opt.Memo
+		return di
+	}, func(dis []DepositInfo) error {
+		for _, di := range dis {
+			switch di.Status {
+			case StatusWaitConfirm:
+			case StatusWaitSend:
+				return ErrDepositRepriced
+			default:
+				return ErrDepositStatusChanged
+			}
+		}
+
+		// NOTE: broadcastTransaction retries indefinitely on error, see handleDepositInfoState
+		rsp, err := s.broadcastTransaction(skyTx)
+		if err != nil {
+			log.WithError(err).Error("broadcastTransaction failed")
+			broadcastErr = err
+			return err
+		}
+
+		broadcast = true
+
+		if rsp.Txid != skyTx.TxIDHex() {
+			log.Error("CRITICAL ERROR: BroadcastTxResponse.Txid != skyTx.TxIDHex()")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		switch {
+		case broadcast:
+			log.WithError(err).WithField("txid", skyTx.TxIDHex()).Error("CRITICAL ERROR: Coins were sent but the batch's deposits could not be saved")
+			return nil, ErrSentNotRecorded
+		case err == broadcastErr, err == ErrDepositStatusChanged, err == ErrDepositRepriced:
+			log.WithError(err).Error("store.UpdateDepositInfosCallback failed")
+			return nil, err
+		default:
+			log.WithError(err).Error("store.UpdateDepositInfosCallback failed")
+			return nil, NewStoreWriteErr(err)
+		}
+	}
+
+	s.metrics.Counter("teller_sky_sent_droplets_total", "SKY sent, in droplets", nil).Add(float64(total))
+	s.recordSend(total)
+	for _, di := range sent {
+		s.recordRounding(di, values[di.DepositID])
+	}
+
+	log.WithField("txid", skyTx.TxIDHex()).Info("Batch of deposits set to StatusWaitConfirm")
+
+	return sent, nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/skycoin/skycoin/src/util/droplet"
 
+	"github.com/skycoin/teller/src/config"
 	"github.com/skycoin/teller/src/util/mathutil"
 )
 
@@ -16,6 +17,8 @@ const (
 	SatoshisPerBTC int64 = 1e8
 	// WeiPerETH is the number of wei per 1 ETH
 	WeiPerETH int64 = 1e18
+	// GweiPerETH is the number of gwei per 1 ETH
+	GweiPerETH int64 = 1e9
 )
 
 // CalculateBtcSkyValue returns the amount of SKY (in droplets) to give for an
@@ -23,69 +26,162 @@ const (
 // Rate is measured in SKY per BTC. It should be a decimal string.
 // MaxDecimals is the number of decimal places to truncate to.
 func CalculateBtcSkyValue(satoshis int64, skyPerBTC string, maxDecimals int) (uint64, error) {
-	if satoshis < 0 {
-		return 0, errors.New("satoshis must be greater than or equal to 0")
-	}
-	if maxDecimals < 0 {
-		return 0, errors.New("maxDecimals can't be negative")
-	}
-
-	rate, err := mathutil.ParseRate(skyPerBTC)
+	v, err := CalculateBtcSkyValueRounded(satoshis, skyPerBTC, maxDecimals, config.RoundingDown)
 	if err != nil {
 		return 0, err
 	}
 
+	return v.Droplets, nil
+}
+
+// CalculateBtcSkyValueRounded returns the SKY to give for an amount of BTC (in satoshis),
+// rounded to maxDecimals decimal places by roundingMode
+func CalculateBtcSkyValueRounded(satoshis int64, skyPerBTC string, maxDecimals int, roundingMode string) (SkyValue, error) {
+	if satoshis < 0 {
+		return SkyValue{}, errors.New("satoshis must be greater than or equal to 0")
+	}
+
 	btc := decimal.New(satoshis, 0)
 	btcToSatoshi := decimal.New(SatoshisPerBTC, 0)
 	btc = btc.DivRound(btcToSatoshi, 8)
 
-	sky := btc.Mul(rate)
-	sky = sky.Truncate(int32(maxDecimals))
-
-	skyToDroplets := decimal.New(droplet.Multiplier, 0)
-	droplets := sky.Mul(skyToDroplets)
+	return calculateSkyValue(btc, SatoshisPerBTC, skyPerBTC, maxDecimals, roundingMode)
+}
 
-	amt := droplets.IntPart()
-	if amt < 0 {
-		// This should never occur, but double check before we convert to uint64,
-		// otherwise we would send all the coins due to integer wrapping.
-		return 0, errors.New("calculated sky amount is negative")
+// SelectRateTier returns the rate tier with the largest minimum amount that is not
+// more than satoshis. tiers must be sorted by minimum amount. Returns nil if satoshis
+// is less than the minimum amount of every tier.
+func SelectRateTier(tiers []config.RateTier, satoshis int64) (*config.RateTier, error) {
+	var selected *config.RateTier
+	for i, t := range tiers {
+		minSatoshis, err := mathutil.ParseBtcAmount(t.MinBtc)
+		if err != nil {
+			return nil, err
+		}
+
+		if satoshis < minSatoshis {
+			break
+		}
+
+		selected = &tiers[i]
 	}
 
-	return uint64(amt), nil
+	return selected, nil
 }
 
 // CalculateEthSkyValue returns the amount of SKY (in droplets) to give for an
 // amount of Eth (in wei).
 // Rate is measured in SKY per Eth
 func CalculateEthSkyValue(wei *big.Int, skyPerETH string, maxDecimals int) (uint64, error) {
-	if wei.Sign() < 0 {
-		return 0, errors.New("wei must be greater than or equal to 0")
-	}
-	if maxDecimals < 0 {
-		return 0, errors.New("maxDecimals can't be negative")
-	}
-	rate, err := mathutil.ParseRate(skyPerETH)
+	v, err := CalculateEthSkyValueRounded(wei, skyPerETH, maxDecimals, config.RoundingDown)
 	if err != nil {
 		return 0, err
 	}
 
+	return v.Droplets, nil
+}
+
+// CalculateEthSkyValueRounded returns the SKY to give for an amount of Eth (in wei),
+// rounded to maxDecimals decimal places by roundingMode.
+// ETH deposits are recorded in gwei, so a gwei is the unit of SignificantLoss
+func CalculateEthSkyValueRounded(wei *big.Int, skyPerETH string, maxDecimals int, roundingMode string) (SkyValue, error) {
+	if wei.Sign() < 0 {
+		return SkyValue{}, errors.New("wei must be greater than or equal to 0")
+	}
+
 	eth := decimal.NewFromBigInt(wei, 0)
 	ethToWei := decimal.New(WeiPerETH, 0)
 	eth = eth.DivRound(ethToWei, 18)
 
-	sky := eth.Mul(rate)
-	sky = sky.Truncate(int32(maxDecimals))
+	return calculateSkyValue(eth, GweiPerETH, skyPerETH, maxDecimals, roundingMode)
+}
+
+// SkyValue is the SKY to give for a deposit, and the rounding applied to it
+type SkyValue struct {
+	// SKY to give, in droplets
+	Droplets uint64
+	// Droplets minus the exact value in droplets, truncated to a whole droplet.
+	// Negative if the value was rounded down, positive if it was rounded up
+	RoundingDroplets int64
+	// The value was rounded down by at least the SKY of one unit of the deposit's coin,
+	// i.e. a deposit smaller by a unit would have been given the same SKY
+	SignificantLoss bool
+}
+
+// calculateSkyValue converts an amount of a coin to SKY at rate SKY per coin, rounded to maxDecimals
+// decimal places by roundingMode. unitsPerCoin is the number of smallest units of the coin
+func calculateSkyValue(coins decimal.Decimal, unitsPerCoin int64, skyPerCoin string, maxDecimals int, roundingMode string) (SkyValue, error) {
+	if maxDecimals < 0 {
+		return SkyValue{}, errors.New("maxDecimals can't be negative")
+	}
+
+	rate, err := mathutil.ParseRate(skyPerCoin)
+	if err != nil {
+		return SkyValue{}, err
+	}
+
+	sky := coins.Mul(rate)
+
+	var rounded decimal.Decimal
+	places := int32(maxDecimals)
+	switch roundingMode {
+	case config.RoundingDown, "":
+		rounded = sky.Truncate(places)
+	case config.RoundingNearest:
+		rounded = sky.Round(places)
+	case config.RoundingUp:
+		rounded = sky.Mul(decimal.New(1, places)).Ceil().Mul(decimal.New(1, -places))
+	default:
+		return SkyValue{}, config.ErrInvalidRoundingMode
+	}
 
 	skyToDroplets := decimal.New(droplet.Multiplier, 0)
-	droplets := sky.Mul(skyToDroplets)
+	droplets := rounded.Mul(skyToDroplets)
 
 	amt := droplets.IntPart()
 	if amt < 0 {
 		// This should never occur, but double check before we convert to uint64,
 		// otherwise we would send all the coins due to integer wrapping.
-		return 0, errors.New("calculated sky amount is negative")
+		return SkyValue{}, errors.New("calculated sky amount is negative")
 	}
 
-	return uint64(amt), nil
+	// The SKY lost to rounding is significant if it is worth at least one unit of the coin,
+	// i.e. loss >= rate / unitsPerCoin
+	loss := sky.Sub(rounded)
+	significant := loss.Mul(decimal.New(unitsPerCoin, 0)).GreaterThanOrEqual(rate)
+
+	return SkyValue{
+		Droplets:         uint64(amt),
+		RoundingDroplets: amt - sky.Mul(skyToDroplets).IntPart(),
+		SignificantLoss:  significant,
+	}, nil
+}
+
+// MaxRoundingLoss returns the most that rounding to maxDecimals decimal places by roundingMode
+// can take from a deposit converted at rate SKY per coin, in units of the coin.
+// unitsPerCoin is the number of smallest units of the coin.
+// A deposit loses up to just under this amount, which is 0 if SKY is rounded up
+func MaxRoundingLoss(skyPerCoin string, unitsPerCoin int64, maxDecimals int, roundingMode string) (decimal.Decimal, error) {
+	if maxDecimals < 0 {
+		return decimal.Decimal{}, errors.New("maxDecimals can't be negative")
+	}
+
+	rate, err := mathutil.ParseRate(skyPerCoin)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	// The SKY of one rounding step, converted to units of the coin
+	step := decimal.New(1, -int32(maxDecimals)).Mul(decimal.New(unitsPerCoin, 0)).Div(rate)
+
+	switch roundingMode {
+	case config.RoundingDown, "":
+		return step, nil
+	case config.RoundingNearest:
+		return step.Div(decimal.New(2, 0)), nil
+	case config.RoundingUp:
+		return decimal.New(0, 0), nil
+	default:
+		return decimal.Decimal{}, config.ErrInvalidRoundingMode
+	}
 }
@@ -7,6 +7,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/scanner"
 )
 
 func TestCalculateSkyValue(t *testing.T) {
@@ -259,6 +262,26 @@ func TestCalculateSkyValue(t *testing.T) {
 			rate:        "1250",
 			result:      15e6 + 6e5 + 2e4 + 5e3, // 15.625 SKY
 		},
+
+		// Amounts that are inexact as float64 BTC are exact in satoshis
+		{
+			maxDecimals: 6,
+			satoshis:    29e6, // 0.29 BTC
+			rate:        "100",
+			result:      29e6, // 29 SKY
+		},
+		{
+			maxDecimals: 6,
+			satoshis:    3e7, // 0.3 BTC
+			rate:        "10",
+			result:      3e6, // 3 SKY
+		},
+		{
+			maxDecimals: 6,
+			satoshis:    1, // 0.00000001 BTC
+			rate:        "1000000",
+			result:      1e4, // 0.01 SKY
+		},
 	}
 
 	for _, tc := range cases {
@@ -491,3 +514,443 @@ func TestCalculateEthSkyValue(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateSkyValueRounded(t *testing.T) {
+	cases := []struct {
+		name        string
+		maxDecimals int
+		satoshis    int64
+		rate        string
+		mode        string
+		value       SkyValue
+		err         error
+	}{
+		{
+			name:        "exact down",
+			maxDecimals: 3,
+			satoshis:    1e8,
+			rate:        "1",
+			mode:        config.RoundingDown,
+			value:       SkyValue{Droplets: 1e6},
+		},
+		{
+			name:        "exact nearest",
+			maxDecimals: 3,
+			satoshis:    1e8,
+			rate:        "1",
+			mode:        config.RoundingNearest,
+			value:       SkyValue{Droplets: 1e6},
+		},
+		{
+			name:        "exact up",
+			maxDecimals: 3,
+			satoshis:    1e8,
+			rate:        "1",
+			mode:        config.RoundingUp,
+			value:       SkyValue{Droplets: 1e6},
+		},
+		{
+			name:        "one satoshi above a step down",
+			maxDecimals: 3,
+			satoshis:    100001, // 0.00100001 SKY
+			rate:        "1",
+			mode:        config.RoundingDown,
+			value:       SkyValue{Droplets: 1000, SignificantLoss: true},
+		},
+		{
+			name:        "one satoshi above a step nearest",
+			maxDecimals: 3,
+			satoshis:    100001,
+			rate:        "1",
+			mode:        config.RoundingNearest,
+			value:       SkyValue{Droplets: 1000, SignificantLoss: true},
+		},
+		{
+			name:        "one satoshi above a step up",
+			maxDecimals: 3,
+			satoshis:    100001,
+			rate:        "1",
+			mode:        config.RoundingUp,
+			value:       SkyValue{Droplets: 2000, RoundingDroplets: 1000},
+		},
+		{
+			name:        "below half down",
+			maxDecimals: 3,
+			satoshis:    149999, // 0.00149999 SKY
+			rate:        "1",
+			mode:        config.RoundingDown,
+			value:       SkyValue{Droplets: 1000, RoundingDroplets: -499, SignificantLoss: true},
+		},
+		{
+			name:        "below half nearest",
+			maxDecimals: 3,
+			satoshis:    149999,
+			rate:        "1",
+			mode:        config.RoundingNearest,
+			value:       SkyValue{Droplets: 1000, RoundingDroplets: -499, SignificantLoss: true},
+		},
+		{
+			name:        "below half up",
+			maxDecimals: 3,
+			satoshis:    149999,
+			rate:        "1",
+			mode:        config.RoundingUp,
+			value:       SkyValue{Droplets: 2000, RoundingDroplets: 501},
+		},
+		{
+			name:        "half down",
+			maxDecimals: 3,
+			satoshis:    150000, // 0.0015 SKY
+			rate:        "1",
+			mode:        config.RoundingDown,
+			value:       SkyValue{Droplets: 1000, RoundingDroplets: -500, SignificantLoss: true},
+		},
+		{
+			name:        "half nearest",
+			maxDecimals: 3,
+			satoshis:    150000,
+			rate:        "1",
+			mode:        config.RoundingNearest,
+			value:       SkyValue{Droplets: 2000, RoundingDroplets: 500},
+		},
+		{
+			name:        "half up",
+			maxDecimals: 3,
+			satoshis:    150000,
+			rate:        "1",
+			mode:        config.RoundingUp,
+			value:       SkyValue{Droplets: 2000, RoundingDroplets: 500},
+		},
+		{
+			name:        "one satoshi below a step down",
+			maxDecimals: 3,
+			satoshis:    199999, // 0.00199999 SKY
+			rate:        "1",
+			mode:        config.RoundingDown,
+			value:       SkyValue{Droplets: 1000, RoundingDroplets: -999, SignificantLoss: true},
+		},
+		{
+			name:        "one satoshi below a step nearest",
+			maxDecimals: 3,
+			satoshis:    199999,
+			rate:        "1",
+			mode:        config.RoundingNearest,
+			value:       SkyValue{Droplets: 2000, RoundingDroplets: 1},
+		},
+		{
+			name:        "one satoshi below a step up",
+			maxDecimals: 3,
+			satoshis:    199999,
+			rate:        "1",
+			mode:        config.RoundingUp,
+			value:       SkyValue{Droplets: 2000, RoundingDroplets: 1},
+		},
+		{
+			name:        "one satoshi down",
+			maxDecimals: 0,
+			satoshis:    1, // 0.00000001 SKY
+			rate:        "1",
+			mode:        config.RoundingDown,
+			value:       SkyValue{Droplets: 0, SignificantLoss: true},
+		},
+		{
+			name:        "one satoshi up",
+			maxDecimals: 0,
+			satoshis:    1,
+			rate:        "1",
+			mode:        config.RoundingUp,
+			value:       SkyValue{Droplets: 1e6, RoundingDroplets: 1e6},
+		},
+		{
+			name:        "loss worth less than a satoshi down",
+			maxDecimals: 3,
+			satoshis:    1, // 0.001234567 SKY
+			rate:        "123456.7",
+			mode:        config.RoundingDown,
+			value:       SkyValue{Droplets: 1000, RoundingDroplets: -234},
+		},
+		{
+			name:        "empty mode rounds down",
+			maxDecimals: 3,
+			satoshis:    150000,
+			rate:        "1",
+			mode:        "",
+			value:       SkyValue{Droplets: 1000, RoundingDroplets: -500, SignificantLoss: true},
+		},
+		{
+			name:        "invalid mode",
+			maxDecimals: 3,
+			satoshis:    150000,
+			rate:        "1",
+			mode:        "bankers",
+			err:         config.ErrInvalidRoundingMode,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := CalculateBtcSkyValueRounded(tc.satoshis, tc.rate, tc.maxDecimals, tc.mode)
+			if tc.err == nil {
+				require.NoError(t, err)
+				require.Equal(t, tc.value, value)
+			} else {
+				require.Equal(t, tc.err, err)
+				require.Equal(t, SkyValue{}, value)
+			}
+		})
+	}
+}
+
+func TestCalculateEthSkyValueRounded(t *testing.T) {
+	cases := []struct {
+		name  string
+		wei   *big.Int
+		rate  string
+		mode  string
+		value SkyValue
+	}{
+		{
+			name:  "half down",
+			wei:   big.NewInt(1500000000000000), // 0.0015 SKY
+			rate:  "1",
+			mode:  config.RoundingDown,
+			value: SkyValue{Droplets: 1000, RoundingDroplets: -500, SignificantLoss: true},
+		},
+		{
+			name:  "half nearest",
+			wei:   big.NewInt(1500000000000000),
+			rate:  "1",
+			mode:  config.RoundingNearest,
+			value: SkyValue{Droplets: 2000, RoundingDroplets: 500},
+		},
+		{
+			name:  "half up",
+			wei:   big.NewInt(1500000000000000),
+			rate:  "1",
+			mode:  config.RoundingUp,
+			value: SkyValue{Droplets: 2000, RoundingDroplets: 500},
+		},
+		{
+			name:  "loss worth less than a gwei down",
+			wei:   big.NewInt(1234500000000), // 1.2345 SKY
+			rate:  "1000000",
+			mode:  config.RoundingDown,
+			value: SkyValue{Droplets: 1234000, RoundingDroplets: -500},
+		},
+		{
+			name:  "loss worth less than a gwei nearest",
+			wei:   big.NewInt(1234500000000),
+			rate:  "1000000",
+			mode:  config.RoundingNearest,
+			value: SkyValue{Droplets: 1235000, RoundingDroplets: 500},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := CalculateEthSkyValueRounded(tc.wei, tc.rate, 3, tc.mode)
+			require.NoError(t, err)
+			require.Equal(t, tc.value, value)
+		})
+	}
+}
+
+func TestMaxRoundingLoss(t *testing.T) {
+	cases := []struct {
+		name         string
+		rate         string
+		unitsPerCoin int64
+		maxDecimals  int
+		mode         string
+		loss         string
+	}{
+		{
+			name:         "btc down",
+			rate:         "1",
+			unitsPerCoin: SatoshisPerBTC,
+			maxDecimals:  3,
+			mode:         config.RoundingDown,
+			loss:         "100000",
+		},
+		{
+			name:         "btc nearest",
+			rate:         "1",
+			unitsPerCoin: SatoshisPerBTC,
+			maxDecimals:  3,
+			mode:         config.RoundingNearest,
+			loss:         "50000",
+		},
+		{
+			name:         "btc up",
+			rate:         "1",
+			unitsPerCoin: SatoshisPerBTC,
+			maxDecimals:  3,
+			mode:         config.RoundingUp,
+			loss:         "0",
+		},
+		{
+			name:         "btc less than a satoshi",
+			rate:         "200000",
+			unitsPerCoin: SatoshisPerBTC,
+			maxDecimals:  3,
+			mode:         config.RoundingDown,
+			loss:         "0.5",
+		},
+		{
+			name:         "eth down",
+			rate:         "10",
+			unitsPerCoin: GweiPerETH,
+			maxDecimals:  0,
+			mode:         config.RoundingDown,
+			loss:         "100000000",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			loss, err := MaxRoundingLoss(tc.rate, tc.unitsPerCoin, tc.maxDecimals, tc.mode)
+			require.NoError(t, err)
+			require.Equal(t, tc.loss, loss.String())
+		})
+	}
+
+	_, err := MaxRoundingLoss("1", SatoshisPerBTC, 3, "bankers")
+	require.Equal(t, config.ErrInvalidRoundingMode, err)
+}
+
+func TestSelectRateTier(t *testing.T) {
+	tiers := []config.RateTier{
+		{
+			MinBtc: "1",
+			Rate:   "550",
+		},
+		{
+			MinBtc: "10",
+			Rate:   "600",
+		},
+	}
+
+	cases := []struct {
+		name     string
+		satoshis int64
+		tier     *config.RateTier
+	}{
+		{
+			name:     "zero",
+			satoshis: 0,
+		},
+		{
+			name:     "below first tier",
+			satoshis: 1e8 - 1,
+		},
+		{
+			name:     "first tier boundary",
+			satoshis: 1e8,
+			tier:     &tiers[0],
+		},
+		{
+			name:     "below second tier",
+			satoshis: 10e8 - 1,
+			tier:     &tiers[0],
+		},
+		{
+			name:     "second tier boundary",
+			satoshis: 10e8,
+			tier:     &tiers[1],
+		},
+		{
+			name:     "above last tier",
+			satoshis: 100e8,
+			tier:     &tiers[1],
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tier, err := SelectRateTier(tiers, tc.satoshis)
+			require.NoError(t, err)
+			require.Equal(t, tc.tier, tier)
+		})
+	}
+
+	tier, err := SelectRateTier(nil, 100e8)
+	require.NoError(t, err)
+	require.Nil(t, tier)
+}
+
+func TestGetDepositRate(t *testing.T) {
+	cfg := config.SkyExchanger{
+		SkyBtcExchangeRate: "500",
+		SkyEthExchangeRate: "50",
+		SkyBtcRateTiers: []config.RateTier{
+			{
+				MinBtc: "1.5",
+				Rate:   "550",
+			},
+		},
+		TestModeRate: "1",
+	}
+
+	cases := []struct {
+		name     string
+		testMode bool
+		deposit  scanner.Deposit
+		rate     string
+		rateTier string
+	}{
+		{
+			name: "btc base rate",
+			deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Value:    1.5e8 - 1,
+			},
+			rate: "500",
+		},
+		{
+			name: "btc tier rate",
+			deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Value:    1.5e8,
+			},
+			rate:     "550",
+			rateTier: "1.5",
+		},
+		{
+			name: "eth ignores btc tiers",
+			deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeETH,
+				Value:    2e8,
+			},
+			rate: "50",
+		},
+		{
+			name:     "btc test mode ignores tiers",
+			testMode: true,
+			deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Value:    1.5e8,
+			},
+			rate: "1",
+		},
+		{
+			name:     "eth test mode",
+			testMode: true,
+			deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeETH,
+				Value:    2e8,
+			},
+			rate: "1",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.TestMode = tc.testMode
+			rate, rateTier, err := getDepositRate(cfg, tc.deposit)
+			require.NoError(t, err)
+			require.Equal(t, tc.rate, rate)
+			require.Equal(t, tc.rateTier, rateTier)
+		})
+	}
+}
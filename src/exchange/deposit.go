@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/skycoin/teller/src/addrs"
 	"github.com/skycoin/teller/src/config"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/util/mathutil"
@@ -29,25 +30,129 @@ const (
 	StatusWaitDecide
 	// StatusWaitPassthrough wait to buy from 3rd party exchange
 	StatusWaitPassthrough
+	// StatusWaitReview deposit is ready for send, but held for an operator to approve or reject
+	StatusWaitReview
+	// StatusRejected deposit was rejected by an operator and will not be sent, it must be refunded manually
+	StatusRejected
+	// StatusInvalid deposit cannot be processed, e.g. its value is zero, and will not be sent
+	StatusInvalid
+	// StatusStuck coins sent, but not confirmed within the confirmation timeout. It is no longer checked and must be investigated manually
+	StatusStuck
+	// StatusUnexpectedDeposit deposit to a single use address that was already used. It will not be sent and must be refunded manually
+	StatusUnexpectedDeposit
+	// StatusStuckSend deposit was not sent within sky_exchanger.stuck_send_age. It is held for an operator to approve or reject
+	StatusStuckSend
+	// StatusKYCHold deposit's send amount is greater than sky_exchanger.kyc_threshold_sky. It is held until an operator
+	// clears the KYC of its deposit address, or rejects it
+	StatusKYCHold
+	// StatusRateLimited deposit would send more than sky_exchanger.send_allowance_sky within sky_exchanger.send_allowance_window.
+	// It waits until enough of the window's sends expire, then returns to StatusWaitSend
+	StatusRateLimited
 
 	// PassthroughExchangeC2CX for deposits using passthrough to c2cx.com
 	PassthroughExchangeC2CX = "c2cx"
 )
 
 var statusString = []string{
-	StatusWaitDeposit:     "waiting_deposit",
-	StatusWaitSend:        "waiting_send",
-	StatusWaitConfirm:     "waiting_confirm",
-	StatusDone:            "done",
-	StatusUnknown:         "unknown",
-	StatusWaitDecide:      "waiting_decide",
-	StatusWaitPassthrough: "waiting_passthrough",
+	StatusWaitDeposit:       "waiting_deposit",
+	StatusWaitSend:          "waiting_send",
+	StatusWaitConfirm:       "waiting_confirm",
+	StatusDone:              "done",
+	StatusUnknown:           "unknown",
+	StatusWaitDecide:        "waiting_decide",
+	StatusWaitPassthrough:   "waiting_passthrough",
+	StatusWaitReview:        "waiting_review",
+	StatusRejected:          "rejected",
+	StatusInvalid:           "invalid",
+	StatusStuck:             "stuck",
+	StatusUnexpectedDeposit: "unexpected_deposit",
+	StatusStuckSend:         "stuck_send",
+	StatusKYCHold:           "kyc_hold",
+	StatusRateLimited:       "rate_limited",
+}
+
+// statusMessage is the default user-facing message of each status, returned by the status API.
+// It can be overridden by teller.status_messages
+var statusMessage = []string{
+	StatusWaitDeposit:       "Waiting for your deposit",
+	StatusWaitSend:          "We've received your deposit and are sending your SKY",
+	StatusWaitConfirm:       "Your SKY has been sent and is waiting for confirmation",
+	StatusDone:              "Your SKY has been sent",
+	StatusUnknown:           "Your deposit's status is unknown, please contact support",
+	StatusWaitDecide:        "We've seen your deposit and are processing it",
+	StatusWaitPassthrough:   "We've received your deposit and are buying your SKY",
+	StatusWaitReview:        "Your deposit is being reviewed before your SKY is sent",
+	StatusRejected:          "Your deposit was rejected, please contact support for a refund",
+	StatusInvalid:           "Your deposit can't be processed, please contact support",
+	StatusStuck:             "Your SKY was sent but is taking longer than expected to confirm, please contact support",
+	StatusUnexpectedDeposit: "This deposit address was already used, please contact support for a refund",
+	StatusStuckSend:         "Sending your SKY is delayed, your deposit is being reviewed",
+	StatusKYCHold:           "Your deposit is held for identity verification",
+	StatusRateLimited:       "Your deposit is queued, your SKY will be sent shortly",
+}
+
+// statusTransitions is the deposit state machine: the statuses each status can move to.
+// A deposit is created in StatusWaitDecide, or StatusInvalid if it can't be processed.
+// Statuses that are not listed are final.
+var statusTransitions = map[Status][]Status{
+	// Decided by the buy method, or set aside if the deposit address was already used
+	StatusWaitDecide: {StatusWaitSend, StatusWaitPassthrough, StatusUnexpectedDeposit},
+	// Bought from the 3rd party exchange
+	StatusWaitPassthrough: {StatusWaitSend},
+	// Sent, held for review or KYC, done without sending if the send amount is 0, not sent in time, or over the send allowance
+	StatusWaitSend: {StatusWaitConfirm, StatusWaitReview, StatusKYCHold, StatusDone, StatusStuckSend, StatusRateLimited,
+		// Rejected with the deposit it was merged into
+		StatusRejected},
+	// Approved, KYC cleared, or rejected by an operator
+	StatusWaitReview: {StatusWaitSend, StatusRejected},
+	StatusStuckSend:  {StatusWaitSend, StatusRejected},
+	StatusKYCHold:    {StatusWaitSend, StatusRejected},
+	// Released when the send allowance has room for it, or rejected by an operator
+	StatusRateLimited: {StatusWaitSend, StatusRejected},
+	// Confirmed, or not confirmed within the confirmation timeout
+	StatusWaitConfirm: {StatusDone, StatusStuck},
+}
+
+// canTransition returns true if a deposit can move from status "from" to status "to".
+// Keeping the same status is always allowed.
+func canTransition(from, to Status) bool {
+	if from == to {
+		return true
+	}
+
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inReview returns true if a deposit with status s waits for an operator's decision, see Store.ReviewDeposit
+func inReview(s Status) bool {
+	switch s {
+	case StatusWaitReview, StatusStuckSend, StatusKYCHold, StatusRateLimited:
+		return true
+	default:
+		return false
+	}
 }
 
 func (s Status) String() string {
 	return statusString[s]
 }
 
+// Message returns the default user-facing message of the status
+func (s Status) Message() string {
+	return statusMessage[s]
+}
+
+// ValidStatusStr returns true if st is the string of a status
+func ValidStatusStr(st string) bool {
+	return NewStatusFromStr(st) != StatusUnknown || st == StatusUnknown.String()
+}
+
 // NewStatusFromStr create status from string
 func NewStatusFromStr(st string) Status {
 	switch st {
@@ -63,6 +168,22 @@ func NewStatusFromStr(st string) Status {
 		return StatusWaitDecide
 	case statusString[StatusWaitPassthrough]:
 		return StatusWaitPassthrough
+	case statusString[StatusWaitReview]:
+		return StatusWaitReview
+	case statusString[StatusRejected]:
+		return StatusRejected
+	case statusString[StatusInvalid]:
+		return StatusInvalid
+	case statusString[StatusUnexpectedDeposit]:
+		return StatusUnexpectedDeposit
+	case statusString[StatusStuck]:
+		return StatusStuck
+	case statusString[StatusStuckSend]:
+		return StatusStuckSend
+	case statusString[StatusKYCHold]:
+		return StatusKYCHold
+	case statusString[StatusRateLimited]:
+		return StatusRateLimited
 	default:
 		return StatusUnknown
 	}
@@ -74,10 +195,13 @@ type BoundAddress struct {
 	Address    string
 	CoinType   string
 	BuyMethod  string
+	// Derivation is how Address was derived, nil if it was not derived or is unknown
+	Derivation *addrs.Derivation `json:",omitempty"`
 }
 
 // DepositInfo records the deposit info
 type DepositInfo struct {
+	SchemaVersion  int // Schema version of the record when it was last written, see SchemaVersion
 	Seq            uint64
 	UpdatedAt      int64
 	Status         Status // TODO -- migrate to string statuses?
@@ -85,13 +209,37 @@ type DepositInfo struct {
 	SkyAddress     string
 	BuyMethod      string
 	DepositAddress string
+	Derivation     *addrs.Derivation `json:",omitempty"` // How DepositAddress was derived, nil if unknown
 	DepositID      string
 	Txid           string
 	ConversionRate string // SKY per other coin, as a decimal string (allows integers, floats, fractions)
+	RateTier       string // Minimum deposit amount of the rate tier applied, as configured. Empty if the base rate was applied
 	DepositValue   int64  // Deposit amount. Should be measured in the smallest unit possible (e.g. satoshis for BTC)
 	SkySent        uint64 // SKY sent, measured in droplets
 	Passthrough    PassthroughData
 	Error          string // An error that occurred during processing
+	// How the coin hours of the send were spent, see sender.CoinHourStrategy. Empty for deposits sent before it was recorded
+	CoinHourStrategy string `json:",omitempty"`
+	// SKY per deposit coin applied by the send, as a precise decimal string, recorded with the txid.
+	// Empty if unknown, e.g. for deposits sent before it was recorded
+	AppliedRate string `json:",omitempty"`
+	// Droplets the send's SKY was rounded by to sky_exchanger.max_decimals, recorded with the txid.
+	// Negative if rounded down, positive if rounded up, see SkyValue.RoundingDroplets
+	RoundingDroplets int64 `json:",omitempty"`
+	// Memo the send's transaction was tagged with, see sky_exchanger.send_memo. Empty if the send had no memo
+	Memo string `json:",omitempty"`
+	// Cause of the deposit's last failed send. Empty if no send failed
+	LastSendErrorCode SendErrorCode `json:",omitempty"`
+	// IDs of the deposits merged into this deposit, whose coins are sent in this deposit's transaction, see sky_exchanger.merge_window
+	MergedDeposits []string `json:",omitempty"`
+	// ID of the deposit this deposit was merged into. Its status follows that deposit's, and SkySent is its share of the send
+	MergedInto string `json:",omitempty"`
+	// When Status last changed, as a Unix time. 0 for deposits saved before it was recorded
+	StatusUpdatedAt int64 `json:",omitempty"`
+	// When the deposit was first saved, as a Unix time. 0 for deposits saved before it was recorded
+	CreatedAt int64 `json:",omitempty"`
+	// The deposit was received in test mode, see sky_exchanger.test_mode. It is left out of the deposit stats
+	TestMode bool `json:",omitempty"`
 	// The original Deposit is saved for the records, in case there is a mistake.
 	// Do not use this data directly.  All necessary data is copied to the top level
 	// of DepositInfo (e.g. DepositID, DepositAddress, DepositValue, CoinType).
@@ -115,6 +263,126 @@ type PassthroughOrder struct {
 	CoinType  string
 }
 
+// SendErrorCode classifies why sending a deposit failed, see DepositInfo.LastSendErrorCode
+type SendErrorCode string
+
+const (
+	// SendErrorInsufficientBalance the wallet's balance is too low for the send
+	SendErrorInsufficientBalance SendErrorCode = "insufficient_balance"
+	// SendErrorNodeUnreachable the skycoin node could not be reached
+	SendErrorNodeUnreachable SendErrorCode = "node_unreachable"
+	// SendErrorInvalidAddress the deposit's skycoin address is invalid
+	SendErrorInvalidAddress SendErrorCode = "invalid_address"
+	// SendErrorTxRejected the skycoin node refused to broadcast the transaction
+	SendErrorTxRejected SendErrorCode = "tx_rejected"
+	// SendErrorUnknown the sender failed for another reason
+	SendErrorUnknown SendErrorCode = "unknown"
+)
+
+// ValidateSendErrorCode returns ErrInvalidSendErrorCode if code is not a known SendErrorCode
+func ValidateSendErrorCode(code SendErrorCode) error {
+	switch code {
+	case SendErrorInsufficientBalance,
+		SendErrorNodeUnreachable,
+		SendErrorInvalidAddress,
+		SendErrorTxRejected,
+		SendErrorUnknown:
+		return nil
+	default:
+		return ErrInvalidSendErrorCode
+	}
+}
+
+// DeadLetter records a deposit that failed processing and was set aside for operator review
+type DeadLetter struct {
+	DepositID   string      `json:"deposit_id"`
+	Reason      string      `json:"reason"`
+	Attempts    int         `json:"attempts"`
+	Pending     bool        `json:"pending"` // false once the deposit has been resubmitted for processing
+	CreatedAt   int64       `json:"created_at"`
+	UpdatedAt   int64       `json:"updated_at"`
+	DepositInfo DepositInfo `json:"deposit_info"`
+}
+
+// ReviewAction is an operator's decision on a deposit held for review
+type ReviewAction string
+
+const (
+	// ReviewActionApprove sends the deposit
+	ReviewActionApprove ReviewAction = "approve"
+	// ReviewActionReject does not send the deposit, it must be refunded manually
+	ReviewActionReject ReviewAction = "reject"
+	// ReviewActionFreeze freezes the exchange, see Exchange.Freeze. It has no deposit
+	ReviewActionFreeze ReviewAction = "freeze"
+	// ReviewActionUnfreeze unfreezes the exchange, see Exchange.Unfreeze. It has no deposit
+	ReviewActionUnfreeze ReviewAction = "unfreeze"
+	// ReviewActionKYCHold records a deposit held by teller for KYC, see sky_exchanger.kyc_threshold_sky. It has no operator
+	ReviewActionKYCHold ReviewAction = "kyc_hold"
+	// ReviewActionKYCClear clears the KYC of a deposit address, see Exchange.ClearKYC. It has no deposit
+	ReviewActionKYCClear ReviewAction = "kyc_clear"
+	// ReviewActionReprice changes the rate of a deposit that was not sent yet, see Exchange.RepricePending
+	ReviewActionReprice ReviewAction = "reprice"
+	// ReviewActionRetry resubmits a dead-lettered deposit, see Exchange.RetryDeadLetter
+	ReviewActionRetry ReviewAction = "retry"
+)
+
+// ReviewAudit records an operator's decision on a deposit held for review, a freeze or unfreeze of the exchange,
+// a KYC hold or clearance, a reprice, or a retry of a dead letter
+type ReviewAudit struct {
+	Seq       uint64       `json:"seq"`
+	DepositID string       `json:"deposit_id,omitempty"`
+	Action    ReviewAction `json:"action"`
+	Operator  string       `json:"operator"`
+	Reason    string       `json:"reason,omitempty"`
+	// Deposit address of a KYC hold or clearance
+	Address string `json:"address,omitempty"`
+	// Rate of a repriced deposit, and its rate before
+	Rate      string `json:"rate,omitempty"`
+	PrevRate  string `json:"prev_rate,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// DepositEvent is a status change of a deposit, recorded in its history
+type DepositEvent struct {
+	DepositID string `json:"deposit_id"`
+	Status    string `json:"status"`
+	// Message is a user-facing message of the status, see teller.status_messages. It is not saved
+	Message string `json:"message,omitempty"`
+	// Why the deposit changed status, e.g. why it was held or rejected. Empty if there is no reason
+	Reason string `json:"reason,omitempty"`
+	// Skycoin transaction of the deposit's send, once it was sent
+	Txid      string `json:"txid,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// repriceable returns true if the deposit's rate can be changed by Exchange.RepricePending:
+// it is bought directly, and its SKY was not sent yet
+func repriceable(di DepositInfo) bool {
+	if di.BuyMethod != config.BuyMethodDirect || di.Txid != "" {
+		return false
+	}
+
+	switch di.Status {
+	case StatusWaitDecide, StatusWaitSend, StatusWaitReview, StatusStuckSend, StatusKYCHold, StatusRateLimited:
+		return true
+	default:
+		return false
+	}
+}
+
+// KYCClearance records that an operator cleared the KYC of a deposit address
+type KYCClearance struct {
+	Address   string `json:"address"`
+	Operator  string `json:"operator"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// SendRecord records the droplets sent by a transaction, for the send allowance, see sky_exchanger.send_allowance_sky
+type SendRecord struct {
+	Droplets uint64 `json:"droplets"`
+	SentAt   int64  `json:"sent_at"`
+}
+
 // DepositStats records overall statistics about deposits
 type DepositStats struct {
 	TotalBTCReceived int64 `json:"total_btc_received"`
@@ -168,7 +436,7 @@ func (di DepositInfo) ValidateForStatus() error {
 		// without doing StatusWaitConfirm
 		return checkWaitSend()
 
-	case StatusWaitConfirm:
+	case StatusWaitConfirm, StatusStuck:
 		if di.Txid == "" {
 			return errors.New("Txid missing")
 		}
@@ -183,6 +451,24 @@ func (di DepositInfo) ValidateForStatus() error {
 	case StatusWaitDecide:
 		return checkWaitSend()
 
+	case StatusWaitReview, StatusRejected, StatusStuckSend, StatusKYCHold, StatusRateLimited:
+		return checkWaitSend()
+
+	case StatusUnexpectedDeposit:
+		if di.Error == "" {
+			return errors.New("Error missing")
+		}
+		return checkWaitSend()
+
+	case StatusInvalid:
+		if di.DepositID == "" {
+			return errors.New("DepositID missing")
+		}
+		if di.Error == "" {
+			return errors.New("Error missing")
+		}
+		return nil
+
 	case StatusWaitDeposit, StatusUnknown:
 		fallthrough
 	default:
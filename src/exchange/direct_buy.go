@@ -2,6 +2,7 @@ package exchange
 
 import (
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -20,6 +21,8 @@ type ProcessRunner interface {
 }
 
 // DirectBuy implements a Processor. All deposits are sent directly to the sender for processing.
+// If sky_exchanger.merge_window is set, deposits to the same deposit address received within the window
+// of the first are merged, and only the first is sent to the sender, carrying the others.
 type DirectBuy struct {
 	log      logrus.FieldLogger
 	cfg      config.SkyExchanger
@@ -28,6 +31,14 @@ type DirectBuy struct {
 	deposits chan DepositInfo
 	quit     chan struct{}
 	done     chan struct{}
+	// deposits waiting for their merge window to close, by mergeKey
+	pending map[string][]DepositInfo
+	// receives the mergeKey of a merge window that closed
+	mergeC chan string
+	// calls f once a merge window of d closes
+	afterFunc func(d time.Duration, f func())
+	// parks deposits while frozen, nil if it can't be frozen
+	gate *freezeGate
 }
 
 // NewDirectBuy creates DirectBuy
@@ -44,6 +55,11 @@ func NewDirectBuy(log logrus.FieldLogger, cfg config.SkyExchanger, store Storer,
 		deposits: make(chan DepositInfo, 100),
 		quit:     make(chan struct{}),
 		done:     make(chan struct{}),
+		pending:  make(map[string][]DepositInfo),
+		mergeC:   make(chan string),
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
 	}, nil
 }
 
@@ -78,16 +94,91 @@ func (p *DirectBuy) runUpdateStatus() {
 			log.Info("quit")
 			return
 		case d := <-p.receiver.Deposits():
+			if !p.gate.wait(p.quit) {
+				log.Info("quit")
+				return
+			}
+
 			updatedDeposit, err := p.updateStatus(d)
 			if err != nil {
 				msg := "updateStatus failed. This deposit will not be reprocessed until teller is restarted."
 				log.WithField("depositInfo", d).WithError(err).Error(msg)
+				addDeadLetter(log, p.store, d, err)
 				continue
 			}
 
-			p.deposits <- updatedDeposit
+			if p.cfg.MergeWindow == 0 {
+				p.deposits <- updatedDeposit
+				continue
+			}
+
+			p.addPending(updatedDeposit)
+		case key := <-p.mergeC:
+			if !p.gate.wait(p.quit) {
+				log.Info("quit")
+				return
+			}
+
+			p.merge(key)
+		}
+	}
+}
+
+// mergeKey returns the key of the deposits that can be merged with di
+func mergeKey(di DepositInfo) string {
+	return di.CoinType + ":" + di.DepositAddress
+}
+
+// addPending holds a StatusWaitSend deposit until the merge window of its deposit address closes.
+// The window is opened by the first deposit to the address.
+// Pending deposits are saved with StatusWaitSend, so they are sent separately after a restart
+func (p *DirectBuy) addPending(di DepositInfo) {
+	key := mergeKey(di)
+	if _, ok := p.pending[key]; !ok {
+		p.afterFunc(p.cfg.MergeWindow, func() {
+			select {
+			case <-p.quit:
+			case p.mergeC <- key:
+			}
+		})
+	}
+
+	p.pending[key] = append(p.pending[key], di)
+}
+
+// merge merges the pending deposits of a closed merge window into the first deposit, and sends it on
+func (p *DirectBuy) merge(key string) {
+	dis := p.pending[key]
+	delete(p.pending, key)
+
+	if len(dis) == 1 {
+		p.deposits <- dis[0]
+		return
+	}
+
+	mergedIDs := make([]string, len(dis)-1)
+	for i, di := range dis[1:] {
+		mergedIDs[i] = di.DepositID
+	}
+
+	log := p.log.WithFields(logrus.Fields{
+		"depositInfo":    dis[0],
+		"mergedDeposits": mergedIDs,
+	})
+
+	primary, err := p.store.MergeDeposits(dis[0].DepositID, mergedIDs)
+	if err != nil {
+		// Nothing was merged, send each deposit separately
+		log.WithError(err).Error("MergeDeposits failed, sending the deposits separately")
+		for _, di := range dis {
+			p.deposits <- di
 		}
+		return
 	}
+
+	log.Info("Merged deposits")
+
+	p.deposits <- primary
 }
 
 // Shutdown stops a previous call to Run
@@ -0,0 +1,152 @@
+package exchange
+
+import (
+	"sort"
+	"time"
+
+	"github.com/skycoin/teller/src/metrics"
+)
+
+// ScanDrift is the difference between the deposit addresses of a coin type bound in the store
+// and the deposit addresses watched by its scanner
+type ScanDrift struct {
+	CoinType string `json:"coin_type"`
+	// Unwatched are bound deposit addresses the scanner was not watching. They are added to the scanner and rescanned
+	Unwatched []string `json:"unwatched"`
+	// Unbound are deposit addresses the scanner watches that are not bound. Deposits to them can't be sent, they are only reported
+	Unbound []string `json:"unbound"`
+}
+
+// CheckScanDrift compares the bound deposit addresses of each coin type with the addresses watched by its scanner.
+// Bound addresses the scanner is not watching are added to the scanner, and rescanned for deposits missed meanwhile.
+// It returns the drift of each coin type that has any, found before it was repaired
+func (r *Receive) CheckScanDrift() ([]ScanDrift, error) {
+	if r.multiplexer == nil {
+		return nil, nil
+	}
+
+	var drifts []ScanDrift
+	for _, coinType := range r.multiplexer.CoinTypes() {
+		log := r.log.WithField("coinType", coinType)
+
+		bound, err := r.store.GetBoundDepositAddresses(coinType)
+		if err != nil {
+			log.WithError(err).Error("GetBoundDepositAddresses failed")
+			return nil, err
+		}
+
+		watched, err := r.multiplexer.GetScanAddresses(coinType)
+		if err != nil {
+			log.WithError(err).Error("GetScanAddresses failed")
+			return nil, err
+		}
+
+		drift := diffScanAddresses(coinType, bound, watched)
+
+		r.metrics.Gauge("teller_scan_address_drift", "Deposit addresses that differ between the bindings and the scanner, found by the last drift check", metrics.Labels{
+			"coin_type": coinType,
+			"kind":      "unwatched",
+		}).Set(float64(len(drift.Unwatched)))
+		r.metrics.Gauge("teller_scan_address_drift", "Deposit addresses that differ between the bindings and the scanner, found by the last drift check", metrics.Labels{
+			"coin_type": coinType,
+			"kind":      "unbound",
+		}).Set(float64(len(drift.Unbound)))
+
+		if len(drift.Unwatched) == 0 && len(drift.Unbound) == 0 {
+			continue
+		}
+
+		drifts = append(drifts, drift)
+
+		if len(drift.Unbound) != 0 {
+			log.WithField("unbound", drift.Unbound).Warning("Scanner watches deposit addresses that are not bound")
+		}
+
+		if len(drift.Unwatched) == 0 {
+			continue
+		}
+
+		log = log.WithField("unwatched", drift.Unwatched)
+		log.Warning("Bound deposit addresses are not watched by the scanner, adding them")
+
+		if err := r.multiplexer.AddScanAddresses(drift.Unwatched, coinType); err != nil {
+			log.WithError(err).Error("AddScanAddresses failed")
+			return nil, err
+		}
+
+		for _, depositAddr := range drift.Unwatched {
+			if err := r.multiplexer.Rescan(depositAddr, coinType); err != nil {
+				log.WithError(err).WithField("depositAddr", depositAddr).Error("Rescan failed")
+			}
+		}
+	}
+
+	return drifts, nil
+}
+
+// diffScanAddresses returns the bound addresses that are not watched, and the watched addresses that are not bound, sorted
+func diffScanAddresses(coinType string, bound, watched []string) ScanDrift {
+	boundMap := make(map[string]struct{}, len(bound))
+	for _, a := range bound {
+		boundMap[a] = struct{}{}
+	}
+
+	watchedMap := make(map[string]struct{}, len(watched))
+	for _, a := range watched {
+		watchedMap[a] = struct{}{}
+	}
+
+	drift := ScanDrift{
+		CoinType: coinType,
+	}
+
+	for a := range boundMap {
+		if _, ok := watchedMap[a]; !ok {
+			drift.Unwatched = append(drift.Unwatched, a)
+		}
+	}
+
+	for a := range watchedMap {
+		if _, ok := boundMap[a]; !ok {
+			drift.Unbound = append(drift.Unbound, a)
+		}
+	}
+
+	sort.Strings(drift.Unwatched)
+	sort.Strings(drift.Unbound)
+
+	return drift
+}
+
+// CheckScanDrift repairs drift between the bound deposit addresses and the scanners, see Receive.CheckScanDrift
+func (e *Exchange) CheckScanDrift() ([]ScanDrift, error) {
+	if e.Frozen() {
+		return nil, ErrFrozen
+	}
+
+	return e.Receiver.CheckScanDrift()
+}
+
+// runScanDriftCheck checks for scan drift at startup and then every sky_exchanger.scan_drift_check_interval
+func (e *Exchange) runScanDriftCheck() {
+	log := e.log.WithField("goroutine", "runScanDriftCheck").WithField("interval", e.cfg.ScanDriftCheckInterval)
+
+	ticker := time.NewTicker(e.cfg.ScanDriftCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if drifts, err := e.CheckScanDrift(); err == ErrFrozen {
+			log.Info("Skipping the scan drift check while frozen")
+		} else if err != nil {
+			log.WithError(err).Error("CheckScanDrift failed")
+		} else if len(drifts) > 0 {
+			log.WithField("drifts", drifts).Warning("Repaired scan drift")
+		}
+
+		select {
+		case <-e.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
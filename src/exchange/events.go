@@ -0,0 +1,92 @@
+package exchange
+
+import (
+	"sync"
+)
+
+// statusSubscriberBufferSize is the number of StatusEvents buffered for each subscriber.
+// Events are dropped for a subscriber that falls this far behind.
+const statusSubscriberBufferSize = 100
+
+// StatusEvent is published when a deposit's status changes
+type StatusEvent struct {
+	DepositID      string `json:"deposit_id"`
+	CoinType       string `json:"coin_type"`
+	DepositAddress string `json:"deposit_address"`
+	SkyAddress     string `json:"skycoin_address"`
+	Status         string `json:"status"`
+	UpdatedAt      int64  `json:"updated_at"`
+	// Number of events dropped for this subscriber since the last event it received,
+	// because it was not reading events fast enough
+	Dropped uint64 `json:"dropped"`
+}
+
+// NewStatusEvent creates a StatusEvent from a DepositInfo
+func NewStatusEvent(di DepositInfo) StatusEvent {
+	return StatusEvent{
+		DepositID:      di.DepositID,
+		CoinType:       di.CoinType,
+		DepositAddress: di.DepositAddress,
+		SkyAddress:     di.SkyAddress,
+		Status:         di.Status.String(),
+		UpdatedAt:      di.UpdatedAt,
+	}
+}
+
+type statusSubscriber struct {
+	c       chan StatusEvent
+	dropped uint64
+}
+
+// StatusFeed multiplexes StatusEvents to subscribers.
+// Publishing never blocks: if a subscriber's buffer is full, the event is dropped
+// for that subscriber and counted in the Dropped field of the next event it receives.
+type StatusFeed struct {
+	sync.Mutex
+	subscribers map[*statusSubscriber]struct{}
+}
+
+// NewStatusFeed creates a StatusFeed
+func NewStatusFeed() *StatusFeed {
+	return &StatusFeed{
+		subscribers: make(map[*statusSubscriber]struct{}),
+	}
+}
+
+// Subscribe returns a channel of StatusEvents and a function to unsubscribe.
+// The channel is closed when unsubscribed. Unsubscribe may be called multiple times.
+func (f *StatusFeed) Subscribe() (<-chan StatusEvent, func()) {
+	sub := &statusSubscriber{
+		c: make(chan StatusEvent, statusSubscriberBufferSize),
+	}
+
+	f.Lock()
+	f.subscribers[sub] = struct{}{}
+	f.Unlock()
+
+	var once sync.Once
+	return sub.c, func() {
+		once.Do(func() {
+			f.Lock()
+			defer f.Unlock()
+			delete(f.subscribers, sub)
+			close(sub.c)
+		})
+	}
+}
+
+// Publish sends a StatusEvent to all subscribers without blocking
+func (f *StatusFeed) Publish(ev StatusEvent) {
+	f.Lock()
+	defer f.Unlock()
+
+	for sub := range f.subscribers {
+		ev.Dropped = sub.dropped
+		select {
+		case sub.c <- ev:
+			sub.dropped = 0
+		default:
+			sub.dropped++
+		}
+	}
+}
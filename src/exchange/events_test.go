@@ -0,0 +1,60 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusFeed(t *testing.T) {
+	f := NewStatusFeed()
+
+	c1, unsub1 := f.Subscribe()
+	c2, unsub2 := f.Subscribe()
+
+	f.Publish(StatusEvent{DepositID: "a"})
+
+	require.Equal(t, StatusEvent{DepositID: "a"}, <-c1)
+	require.Equal(t, StatusEvent{DepositID: "a"}, <-c2)
+
+	unsub2()
+	unsub2()
+
+	// Unsubscribing closes the channel and releases the subscriber
+	_, ok := <-c2
+	require.False(t, ok)
+	require.Len(t, f.subscribers, 1)
+
+	f.Publish(StatusEvent{DepositID: "b"})
+	require.Equal(t, StatusEvent{DepositID: "b"}, <-c1)
+
+	unsub1()
+	require.Empty(t, f.subscribers)
+}
+
+func TestStatusFeedSlowSubscriber(t *testing.T) {
+	f := NewStatusFeed()
+
+	c, unsub := f.Subscribe()
+	defer unsub()
+
+	// Publishing does not block when the subscriber's buffer is full
+	for i := 0; i < statusSubscriberBufferSize+3; i++ {
+		f.Publish(StatusEvent{DepositID: "a"})
+	}
+
+	for i := 0; i < statusSubscriberBufferSize; i++ {
+		ev := <-c
+		require.Equal(t, uint64(0), ev.Dropped)
+	}
+
+	// The next event received records how many were dropped
+	f.Publish(StatusEvent{DepositID: "b"})
+	ev := <-c
+	require.Equal(t, "b", ev.DepositID)
+	require.Equal(t, uint64(3), ev.Dropped)
+
+	f.Publish(StatusEvent{DepositID: "c"})
+	ev = <-c
+	require.Equal(t, uint64(0), ev.Dropped)
+}
@@ -1,7 +1,11 @@
 package exchange
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"io"
 	"sync"
 	"time"
 
@@ -9,13 +13,19 @@ import (
 
 	"github.com/skycoin/skycoin/src/api/cli"
 
+	"github.com/skycoin/teller/src/addrs"
 	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/metrics"
+	"github.com/skycoin/teller/src/notify"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/mathutil"
 )
 
 const (
 	txConfirmationCheckWait = time.Second * 3
+	// how often review audits older than the configured retention are pruned
+	reviewAuditPruneInterval = time.Hour
 )
 
 var (
@@ -30,6 +40,51 @@ var (
 	ErrDepositStatusInvalid = errors.New("Deposit status cannot be handled")
 	// ErrNoBoundAddress is returned if no skycoin address is bound to a deposit's address
 	ErrNoBoundAddress = errors.New("Deposit has no bound skycoin address")
+	// ErrRequeueClosed is returned if a deposit is requeued to a component that is shutting down
+	ErrRequeueClosed = errors.New("Cannot requeue deposit, the component is shutting down")
+	// ErrSendStopped is reported by the send service status after a ProcessErrorHandler returned DecisionStop
+	ErrSendStopped = errors.New("Sending stopped after a deposit failed processing")
+	// ErrReadOnly is reported by the send service status after it stopped sending because the deposit store is not writable
+	ErrReadOnly = errors.New("Sending stopped, the deposit store is not writable")
+	// ErrSentNotRecorded is returned if coins were sent for a deposit but the deposit could not be saved
+	ErrSentNotRecorded = errors.New("Coins were sent but the deposit could not be saved")
+	// ErrConfirmationTimeout is recorded on a deposit whose transaction was not confirmed within the confirmation timeout
+	ErrConfirmationTimeout = errors.New("Transaction was not confirmed within the confirmation timeout")
+	// ErrStuckSend is recorded on a deposit that was not sent within sky_exchanger.stuck_send_age
+	ErrStuckSend = errors.New("Deposit was not sent within the stuck send age")
+	// ErrKYCHold is recorded on a deposit whose send amount is greater than sky_exchanger.kyc_threshold_sky
+	ErrKYCHold = errors.New("Deposit is greater than the KYC threshold, held until its deposit address KYC is cleared")
+	// ErrSendAllowanceExceeded is recorded on a deposit that would exceed sky_exchanger.send_allowance_sky within its window
+	ErrSendAllowanceExceeded = errors.New("Deposit would exceed the send allowance, waiting for the send allowance window to have room")
+	// ErrDepositAddressReused is recorded on a deposit to a single use deposit address that already has a completed deposit
+	ErrDepositAddressReused = errors.New("Deposit address was already used by a completed deposit")
+	// ErrPauseClosed is returned if sending is paused while the send service is shutting down
+	ErrPauseClosed = errors.New("Cannot pause sending, the send service is shutting down")
+	// ErrInvalidDepositValue is recorded for a deposit whose value is zero or negative, which is not processed
+	ErrInvalidDepositValue = errors.New("Deposit value is zero or negative")
+	// ErrSimulatedDepositsDisabled is returned by SimulateDeposit if sky_exchanger.allow_simulated_deposits is not enabled
+	ErrSimulatedDepositsDisabled = errors.New("Simulated deposits are disabled")
+	// ErrReceiveClosed is returned if a deposit is simulated while the receiver is shutting down
+	ErrReceiveClosed = errors.New("Cannot receive deposit, the component is shutting down")
+	// ErrInvalidStatusTransition is returned if a deposit update changes its status in a way the deposit state machine does not allow
+	ErrInvalidStatusTransition = errors.New("Deposit status transition is not allowed")
+	// ErrDepositStatusChanged is returned if a deposit's saved status was changed while it was being sent,
+	// e.g. it was set aside as stuck. Its coins are not sent
+	ErrDepositStatusChanged = errors.New("Deposit status was changed while it was being sent")
+	// ErrDepositRepriced is returned if a deposit was repriced while it was being sent. It is sent again at its new rate
+	ErrDepositRepriced = errors.New("Deposit was repriced while it was being sent")
+	// ErrInvalidRate is returned by RepricePending if the rate is not a decimal greater than 0
+	ErrInvalidRate = errors.New("Rate must be a decimal greater than 0")
+	// ErrFrozen is returned by write operations while the exchange is frozen by an operator, see Exchange.Freeze
+	ErrFrozen = errors.New("Teller is frozen")
+	// ErrNotFrozen is returned if the exchange is unfrozen while it is not frozen
+	ErrNotFrozen = errors.New("Teller is not frozen")
+	// ErrMergeMismatch is returned if deposits with different coin types or skycoin addresses are merged
+	ErrMergeMismatch = errors.New("Merged deposits must have the same coin type and skycoin address")
+	// ErrMemoUnsupported is returned if sky_exchanger.send_memo is set but the sender does not support memos
+	ErrMemoUnsupported = errors.New("sky_exchanger.send_memo is set but the sender does not support memos")
+	// ErrInvalidSendErrorCode is returned by ValidateSendErrorCode for an unknown SendErrorCode
+	ErrInvalidSendErrorCode = errors.New("Invalid send error code")
 )
 
 // DepositFilter filters deposits
@@ -41,15 +96,25 @@ type Runner interface {
 	Shutdown()
 }
 
+// Requeuer is a component that accepts a previously failed deposit for reprocessing
+type Requeuer interface {
+	Requeue(DepositInfo) error
+}
+
 // Exchanger provides APIs to interact with the exchange service
 type Exchanger interface {
 	BindAddress(skyAddr, depositAddr, coinType string) (*BoundAddress, error)
 	GetDepositStatuses(skyAddr string) ([]DepositStatus, error)
+	GetDepositStatusesOfSkyAddresses(skyAddrs []string) (map[string][]DepositStatus, error)
 	GetDepositStatusDetail(flt DepositFilter) ([]DepositStatusDetail, error)
+	GetDepositInfo(depositID string) (DepositInfo, error)
+	GetDepositHistory(depositAddr string) ([]DepositEvent, error)
 	GetBindNum(skyAddr string) (int, error)
 	GetDepositStats() (*DepositStats, error)
 	Status() error
 	Balance() (*cli.Balance, error)
+	Subscribe() (<-chan StatusEvent, func())
+	Frozen() bool
 }
 
 // Exchange encompasses an entire coin<>skycoin deposit-process-send flow
@@ -59,6 +124,16 @@ type Exchange struct {
 	cfg   config.SkyExchanger
 	quit  chan struct{}
 	done  chan struct{}
+	// txQuerier looks up skycoin transactions for Reconcile, nil if unavailable
+	txQuerier TxQuerier
+	// preflight is the report of the last Preflight, nil if it was not run
+	preflight     *PreflightReport
+	preflightLock sync.RWMutex
+	// gate parks the receive, process and send loops while frozen, see Freeze
+	gate    *freezeGate
+	metrics metrics.Metrics
+	// notifier delivers deposit notifications in the background, nil if SetNotifier was not called
+	notifier *notify.Queue
 
 	Receiver  ReceiveRunner
 	Processor ProcessRunner
@@ -90,12 +165,19 @@ func NewDirectExchange(log logrus.FieldLogger, cfg config.SkyExchanger, store St
 		return nil, err
 	}
 
+	gate := &freezeGate{}
+	receiver.gate = gate
+	processor.gate = gate
+	sender.gate = gate
+
 	return &Exchange{
 		log:       log.WithField("prefix", "teller.exchange.exchange"),
 		store:     store,
 		cfg:       cfg,
 		quit:      make(chan struct{}),
 		done:      make(chan struct{}),
+		gate:      gate,
+		metrics:   metrics.Nop{},
 		Receiver:  receiver,
 		Processor: processor,
 		Sender:    sender,
@@ -128,12 +210,19 @@ func NewPassthroughExchange(log logrus.FieldLogger, cfg config.SkyExchanger, sto
 		return nil, err
 	}
 
+	gate := &freezeGate{}
+	receiver.gate = gate
+	processor.gate = gate
+	sender.gate = gate
+
 	return &Exchange{
 		log:       log.WithField("prefix", "teller.exchange.exchange"),
 		store:     store,
 		cfg:       cfg,
 		quit:      make(chan struct{}),
 		done:      make(chan struct{}),
+		gate:      gate,
+		metrics:   metrics.Nop{},
 		Receiver:  receiver,
 		Processor: processor,
 		Sender:    sender,
@@ -152,9 +241,46 @@ func (e *Exchange) Run() error {
 	// Create channels for linking two components, initialize the components with the channels
 	// Close them to teardown
 
+	// Restore a freeze before any deposit is processed
+	if err := e.loadFreezeState(); err != nil {
+		e.log.WithError(err).Error("loadFreezeState failed")
+		return err
+	}
+
 	errC := make(chan error, 3)
 	var wg sync.WaitGroup
 
+	// Subscribe before the components start, so that no status change is missed
+	if e.notifier != nil {
+		events, unsubscribe := e.store.SubscribeStatus()
+		defer unsubscribe()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.runNotify(events)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.notifier.Run(); err != nil {
+				e.log.WithError(err).Error("notifier.Run failed")
+			}
+		}()
+	}
+
+	if _, nop := e.metrics.(metrics.Nop); !nop {
+		events, unsubscribe := e.store.SubscribeStatus()
+		defer unsubscribe()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.runLatency(events)
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -182,6 +308,22 @@ func (e *Exchange) Run() error {
 		}
 	}()
 
+	if e.cfg.ReviewAuditRetention > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.runPruneReviewAudits()
+		}()
+	}
+
+	if e.cfg.ScanDriftCheckInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.runScanDriftCheck()
+		}()
+	}
+
 	var err error
 	select {
 	case <-e.quit:
@@ -191,9 +333,40 @@ func (e *Exchange) Run() error {
 
 	wg.Wait()
 
+	// Report an error from a component that stopped early, e.g. a Sender stopped by DecisionStop
+	if err == nil {
+		select {
+		case err = <-errC:
+		default:
+		}
+	}
+
 	return err
 }
 
+// runPruneReviewAudits deletes review audits older than the retention, at startup and then every reviewAuditPruneInterval
+func (e *Exchange) runPruneReviewAudits() {
+	log := e.log.WithField("goroutine", "runPruneReviewAudits").WithField("retention", e.cfg.ReviewAuditRetention)
+
+	ticker := time.NewTicker(reviewAuditPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := e.store.PruneReviewAudits(time.Now().Add(-e.cfg.ReviewAuditRetention))
+		if err != nil {
+			log.WithError(err).Error("PruneReviewAudits failed")
+		} else if n > 0 {
+			log.WithField("pruned", n).Info("Pruned review audits")
+		}
+
+		select {
+		case <-e.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // Shutdown stops a previous call to run
 func (e *Exchange) Shutdown() {
 	e.log.Info("Shutting down Exchange")
@@ -203,6 +376,9 @@ func (e *Exchange) Shutdown() {
 	e.Receiver.Shutdown()
 	e.Processor.Shutdown()
 	e.Sender.Shutdown()
+	if e.notifier != nil {
+		e.notifier.Shutdown()
+	}
 
 	e.log.Info("Waiting for run to finish")
 	<-e.done
@@ -214,7 +390,13 @@ type DepositStatus struct {
 	Seq       uint64 `json:"seq"`
 	UpdatedAt int64  `json:"updated_at"`
 	Status    string `json:"status"`
-	CoinType  string `json:"coin_type"`
+	// Message is a user-facing message of the status, or a localization key, see teller.status_messages
+	Message  string `json:"message"`
+	CoinType string `json:"coin_type"`
+	// SKY per deposit coin applied by the send, empty if not sent yet or unknown
+	AppliedRate string `json:"applied_rate,omitempty"`
+	// Height of the block the deposit was first observed in, 0 if there is no deposit yet or it was simulated
+	BlockHeight int64 `json:"block_height,omitempty"`
 }
 
 // DepositStatusDetail deposit status detail info
@@ -226,6 +408,10 @@ type DepositStatusDetail struct {
 	DepositAddress string `json:"deposit_address"`
 	CoinType       string `json:"coin_type"`
 	Txid           string `json:"txid"`
+	// Derivation is how the deposit address was derived, if known
+	Derivation *addrs.Derivation `json:"derivation,omitempty"`
+	// Height of the block the deposit was first observed in
+	BlockHeight int64 `json:"block_height"`
 }
 
 // GetDepositStatuses returns deamon.DepositStatus array of given skycoin address
@@ -235,16 +421,64 @@ func (e *Exchange) GetDepositStatuses(skyAddr string) ([]DepositStatus, error) {
 		return []DepositStatus{}, err
 	}
 
+	return depositStatuses(dis), nil
+}
+
+// GetDepositStatusesOfSkyAddresses returns the DepositStatus array of each of the given skycoin addresses,
+// read in one db transaction. Every address is a key of the result, with an empty array if nothing is bound to it
+func (e *Exchange) GetDepositStatusesOfSkyAddresses(skyAddrs []string) (map[string][]DepositStatus, error) {
+	diss, err := e.store.GetDepositInfosOfSkyAddresses(skyAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	dss := make(map[string][]DepositStatus, len(diss))
+	for skyAddr, dis := range diss {
+		dss[skyAddr] = depositStatuses(dis)
+	}
+
+	return dss, nil
+}
+
+func depositStatuses(dis []DepositInfo) []DepositStatus {
 	dss := make([]DepositStatus, 0, len(dis))
 	for _, di := range dis {
 		dss = append(dss, DepositStatus{
 			Seq:       di.Seq,
 			UpdatedAt: di.UpdatedAt,
 			Status:    di.Status.String(),
+			Message:   di.Status.Message(),
 			CoinType:  di.CoinType,
+
+			AppliedRate: di.AppliedRate,
+			BlockHeight: di.Deposit.Height,
 		})
 	}
-	return dss, nil
+	return dss
+}
+
+// GetAppliedRate returns the rate applied by the send of the latest deposit to a deposit address,
+// and false if no deposit to the address was sent, or its rate is unknown because it was sent before it was recorded
+func (e *Exchange) GetAppliedRate(depositAddr string) (string, bool, error) {
+	dis, err := e.store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return di.DepositAddress == depositAddr && di.Txid != ""
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	var latest *DepositInfo
+	for i := range dis {
+		if latest == nil || dis[i].Seq > latest.Seq {
+			latest = &dis[i]
+		}
+	}
+
+	if latest == nil || latest.AppliedRate == "" {
+		return "", false, nil
+	}
+
+	return latest.AppliedRate, true, nil
 }
 
 // GetDepositStatusDetail returns deposit status details
@@ -264,17 +498,50 @@ func (e *Exchange) GetDepositStatusDetail(flt DepositFilter) ([]DepositStatusDet
 			DepositAddress: di.DepositAddress,
 			Txid:           di.Txid,
 			CoinType:       di.CoinType,
+			Derivation:     di.Derivation,
+			BlockHeight:    di.Deposit.Height,
 		})
 	}
 	return dss, nil
 }
 
+// GetDepositInfo returns the DepositInfo of a given deposit ID
+func (e *Exchange) GetDepositInfo(depositID string) (DepositInfo, error) {
+	return e.store.GetDepositInfo(depositID)
+}
+
+// GetDepositHistory returns the status changes of the deposits to a deposit address, oldest first,
+// see sky_exchanger.deposit_history_limit
+func (e *Exchange) GetDepositHistory(depositAddr string) ([]DepositEvent, error) {
+	return e.store.GetDepositHistory(depositAddr)
+}
+
 // GetBindNum returns the number of btc/eth address the given sky address binded
 func (e *Exchange) GetBindNum(skyAddr string) (int, error) {
 	addrs, err := e.store.GetSkyBindAddresses(skyAddr)
 	return len(addrs), err
 }
 
+// Overview is a summary of the deposits for operators
+type Overview struct {
+	// Deposits is the number of deposits in each status. Bound addresses that have no deposit are not counted
+	Deposits map[string]int `json:"deposits"`
+	// TotalBTCReceived and TotalSKYSent are the totals of DepositStats
+	TotalBTCReceived int64 `json:"total_btc_received"`
+	TotalSKYSent     int64 `json:"total_sky_sent"`
+	// PendingReview is the number of deposits waiting for an operator, see PendingReview
+	PendingReview int `json:"pending_review"`
+	// PendingDeadLetters is the number of dead letters that were not retried yet
+	PendingDeadLetters int `json:"pending_dead_letters"`
+	// DBSize is the size of the database in bytes
+	DBSize int64 `json:"db_size"`
+}
+
+// Overview returns a summary of the deposits, read in one pass over the database
+func (e *Exchange) Overview() (Overview, error) {
+	return e.store.GetOverview()
+}
+
 // GetDepositStats returns deposit status
 func (e *Exchange) GetDepositStats() (*DepositStats, error) {
 	tbr, tss, err := e.store.GetDepositStats()
@@ -298,10 +565,357 @@ func (e *Exchange) Status() error {
 	return e.Sender.Status()
 }
 
+// ListDeadLetters returns deposits that failed processing and are waiting for operator review
+func (e *Exchange) ListDeadLetters() ([]DeadLetter, error) {
+	return e.store.GetDeadLetters()
+}
+
+// RetryDeadLetter resubmits a dead-lettered deposit to the component that
+// handles its current status. If the deposit fails again, it is returned to
+// the dead letter store with its attempt count incremented.
+// The retry is recorded in the review audit log with the operator's identity.
+func (e *Exchange) RetryDeadLetter(depositID, operator string) (DeadLetter, error) {
+	log := e.log.WithField("depositID", depositID).WithField("operator", operator)
+
+	if e.Frozen() {
+		return DeadLetter{}, ErrFrozen
+	}
+
+	dl, err := e.store.ResolveDeadLetter(depositID, operator, func(dl DeadLetter) error {
+		switch dl.DepositInfo.Status {
+		case StatusWaitDecide, StatusWaitPassthrough, StatusWaitSend, StatusWaitConfirm:
+			return nil
+		default:
+			return ErrDepositStatusInvalid
+		}
+	})
+	if err != nil {
+		log.WithError(err).Error("RetryDeadLetter failed")
+		return DeadLetter{}, err
+	}
+
+	// The deposit is requeued after the resolution is saved, because Requeue blocks until the queue
+	// has room, and the component processing the queue needs the db to make room
+	switch dl.DepositInfo.Status {
+	case StatusWaitDecide, StatusWaitPassthrough:
+		err = e.Receiver.Requeue(dl.DepositInfo)
+	default:
+		err = e.Sender.Requeue(dl.DepositInfo)
+	}
+	if err != nil {
+		log.WithError(err).Error("Requeue failed")
+		if _, err := e.store.RestoreDeadLetter(depositID); err != nil {
+			log.WithError(err).Error("RestoreDeadLetter failed")
+		}
+		return DeadLetter{}, err
+	}
+
+	log.WithField("deadLetter", dl).Info("Resubmitted dead letter for processing")
+
+	return dl, nil
+}
+
+// PendingReview returns deposits held for an operator to approve or reject,
+// including deposits set aside because they were not sent in time, deposits held for KYC,
+// and deposits over the send allowance
+func (e *Exchange) PendingReview() ([]DepositInfo, error) {
+	return e.store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return inReview(di.Status)
+	})
+}
+
+// ApproveSend releases a deposit held for review to the send service.
+// The decision is recorded in the review audit log with the operator's identity.
+func (e *Exchange) ApproveSend(depositID, operator string) (DepositInfo, error) {
+	log := e.log.WithField("depositID", depositID).WithField("operator", operator)
+
+	if e.Frozen() {
+		return DepositInfo{}, ErrFrozen
+	}
+
+	di, err := e.store.ReviewDeposit(depositID, ReviewActionApprove, operator, "")
+	if err != nil {
+		log.WithError(err).Error("ApproveSend failed")
+		return DepositInfo{}, err
+	}
+
+	log.Info("Deposit approved for sending")
+
+	// The deposit is requeued after the decision is saved, because Requeue blocks until the send queue has room,
+	// and the send service needs the db to make room. Requeue only fails when the send service is shutting down,
+	// the approved deposit is then loaded when it starts again
+	if err := e.Sender.Requeue(di); err != nil {
+		log.WithError(err).Warning("Requeue failed, the deposit will be sent after a restart")
+	}
+
+	return di, nil
+}
+
+// RejectSend moves a deposit held for review to StatusRejected. It is never sent
+// and must be refunded manually. The decision is recorded in the review audit log
+// with the operator's identity and reason.
+func (e *Exchange) RejectSend(depositID, operator, reason string) (DepositInfo, error) {
+	log := e.log.WithField("depositID", depositID).WithField("operator", operator)
+
+	if e.Frozen() {
+		return DepositInfo{}, ErrFrozen
+	}
+
+	di, err := e.store.ReviewDeposit(depositID, ReviewActionReject, operator, reason)
+	if err != nil {
+		log.WithError(err).Error("RejectSend failed")
+		return DepositInfo{}, err
+	}
+
+	log.WithField("reason", reason).Warn("Deposit rejected, it must be refunded manually")
+
+	return di, nil
+}
+
+// ClearKYC records that an operator cleared the KYC of a deposit address. Its deposits held for KYC
+// are released to the send service, and its later deposits are not held.
+// The clearance is recorded in the review audit log with the operator's identity.
+func (e *Exchange) ClearKYC(depositAddr, operator string) ([]DepositInfo, error) {
+	log := e.log.WithField("depositAddr", depositAddr).WithField("operator", operator)
+
+	if e.Frozen() {
+		return nil, ErrFrozen
+	}
+
+	dis, err := e.store.ClearKYC(depositAddr, operator)
+	if err != nil {
+		log.WithError(err).Error("ClearKYC failed")
+		return nil, err
+	}
+
+	log.WithField("released", len(dis)).Info("Deposit address KYC cleared")
+
+	// The deposits are requeued after the clearance is saved, see ApproveSend
+	for _, di := range dis {
+		if err := e.Sender.Requeue(di); err != nil {
+			log.WithField("depositID", di.DepositID).WithError(err).Warning("Requeue failed, the deposit will be sent after a restart")
+		}
+	}
+
+	return dis, nil
+}
+
+// RepricePending sets the rate of the deposits of coinType whose SKY was not sent yet to rate, in one transaction,
+// e.g. after the configured rate was found to be wrong. The SKY amount of a deposit is calculated from its rate when
+// it is sent, so they are sent at the new rate. Sent deposits are not changed. Each repriced deposit is recorded in
+// the review audit log with the operator and reason. It can be called while frozen, to reprice before anything is sent.
+// The configured rate is not changed, it must be corrected before teller is restarted.
+// Returns the number of deposits repriced.
+func (e *Exchange) RepricePending(coinType, rate, operator, reason string) (int, error) {
+	log := e.log.WithField("coinType", coinType).WithField("rate", rate).WithField("operator", operator)
+
+	switch coinType {
+	case scanner.CoinTypeBTC, scanner.CoinTypeETH:
+	default:
+		return 0, scanner.ErrUnsupportedCoinType
+	}
+
+	if _, err := mathutil.ParseRate(rate); err != nil {
+		return 0, ErrInvalidRate
+	}
+
+	dis, err := e.store.RepricePending(coinType, rate, operator, reason)
+	if err != nil {
+		log.WithError(err).Error("RepricePending failed")
+		return 0, err
+	}
+
+	log.WithField("repriced", len(dis)).WithField("reason", reason).Warning("Deposits that were not sent yet are repriced by an operator")
+
+	return len(dis), nil
+}
+
+// GetReviewAudits returns all review decisions, oldest first
+func (e *Exchange) GetReviewAudits() ([]ReviewAudit, error) {
+	return e.store.GetReviewAudits()
+}
+
+// Pause stops sending once the deposit being sent, if any, is done, waiting up to ctx for it.
+// Deposits are still received, recorded and moved to StatusWaitSend while paused, and sent in order after Resume.
+func (e *Exchange) Pause(ctx context.Context) error {
+	return e.Sender.Pause(ctx)
+}
+
+// Resume restarts sending after Pause or DrainAndSnapshot
+func (e *Exchange) Resume() {
+	e.Sender.Resume()
+}
+
+// Paused returns true if sending is paused
+func (e *Exchange) Paused() bool {
+	return e.Sender.Paused()
+}
+
+// DrainAndSnapshot pauses sending, waits for the deposit being sent to be done,
+// then writes a consistent snapshot of the database to w. Sending stays paused
+// until Resume is called, so that no deposit is sent after the snapshot was taken,
+// e.g. when migrating to a new instance.
+func (e *Exchange) DrainAndSnapshot(ctx context.Context, w io.Writer) error {
+	if err := e.Pause(ctx); err != nil {
+		return err
+	}
+
+	n, err := e.store.WriteSnapshot(w)
+	if err != nil {
+		e.log.WithError(err).Error("WriteSnapshot failed")
+		return err
+	}
+
+	e.log.WithField("bytes", n).Info("Sending paused and snapshot written")
+
+	return nil
+}
+
+// addDeadLetter records a deposit that failed processing in the dead letter store
+func addDeadLetter(log logrus.FieldLogger, store Storer, di DepositInfo, reason error) {
+	log = log.WithField("depositInfo", di)
+
+	dl, err := store.AddDeadLetter(di, reason.Error())
+	if err != nil {
+		log.WithError(err).Error("AddDeadLetter failed")
+		return
+	}
+
+	log.WithField("attempts", dl.Attempts).Warn("Deposit added to the dead letter store")
+}
+
 // BindAddress binds deposit address with skycoin address, and
 // add the btc/eth address to scan service, when detect deposit coin
 // to the btc/eth address, will send specific skycoin to the binded
 // skycoin address
 func (e *Exchange) BindAddress(skyAddr, depositAddr, coinType string) (*BoundAddress, error) {
+	if e.Frozen() {
+		return nil, ErrFrozen
+	}
+
 	return e.Receiver.BindAddress(skyAddr, depositAddr, coinType, e.cfg.BuyMethod)
 }
+
+// BindAddresses binds multiple deposit addresses to a skycoin address and
+// registers them with the scan service in one call. See Receive.BindAddresses
+// for the atomicity of partial failures.
+func (e *Exchange) BindAddresses(skyAddr string, depositAddrs []string, coinType string) ([]BoundAddress, error) {
+	if e.Frozen() {
+		return nil, ErrFrozen
+	}
+
+	return e.Receiver.BindAddresses(skyAddr, depositAddrs, coinType, e.cfg.BuyMethod)
+}
+
+// Subscribe returns a channel of every deposit status change and a function to unsubscribe.
+// A subscriber that falls behind has events dropped, see StatusEvent.Dropped.
+// The exchange is never blocked by a slow subscriber.
+func (e *Exchange) Subscribe() (<-chan StatusEvent, func()) {
+	return e.store.SubscribeStatus()
+}
+
+// SimulateDeposit injects a deposit of value to depositAddr, as if the scanner had seen it, and returns its deposit ID.
+// The deposit goes through the same processing as a scanned deposit, and is sent if sending is enabled.
+// Its txid starts with "simulated-". Returns ErrSimulatedDepositsDisabled unless AllowSimulatedDeposits is enabled.
+func (e *Exchange) SimulateDeposit(coinType, depositAddr string, value int64) (string, error) {
+	if !e.cfg.AllowSimulatedDeposits {
+		return "", ErrSimulatedDepositsDisabled
+	}
+
+	if e.Frozen() {
+		return "", ErrFrozen
+	}
+
+	if value <= 0 {
+		return "", ErrInvalidDepositValue
+	}
+
+	boundAddr, err := e.store.GetBindAddress(depositAddr, coinType)
+	if err != nil {
+		return "", err
+	}
+	if boundAddr == nil {
+		return "", ErrNoBoundAddress
+	}
+
+	txid := make([]byte, 16)
+	if _, err := rand.Read(txid); err != nil {
+		return "", err
+	}
+
+	dv := scanner.Deposit{
+		CoinType: coinType,
+		Address:  depositAddr,
+		Value:    value,
+		Tx:       "simulated-" + hex.EncodeToString(txid),
+	}
+
+	log := e.log.WithField("deposit", dv)
+	log.Warning("Simulating deposit")
+
+	if err := e.Receiver.SimulateDeposit(dv); err != nil {
+		log.WithError(err).Error("SimulateDeposit failed")
+		return "", err
+	}
+
+	return dv.ID(), nil
+}
+
+// PurgeTestData deletes the deposits saved in test mode once they are done or rejected, e.g. before going live.
+// It can be called after test mode is disabled. Returns the number of deposits deleted.
+func (e *Exchange) PurgeTestData() (int, error) {
+	n, err := e.store.PurgeTestData()
+	if err != nil {
+		e.log.WithError(err).Error("PurgeTestData failed")
+		return 0, err
+	}
+
+	e.log.WithField("purged", n).Warning("Test mode deposits are purged")
+
+	return n, nil
+}
+
+// SetMetrics sets where the exchange emits metrics. It must be called before Run
+func (e *Exchange) SetMetrics(m metrics.Metrics) {
+	e.metrics = m
+	e.Receiver.SetMetrics(m)
+	e.Sender.SetMetrics(m)
+}
+
+// SetMetricsTopAddresses sets how many deposit addresses of each coin type are exported in the
+// teller_deposit_address_value metric, the ones that received the most. It must be called before Run
+func (e *Exchange) SetMetricsTopAddresses(n int) {
+	e.Receiver.SetMetricsTopAddresses(n)
+}
+
+// setAsideMetric counts deposits that are set aside for an operator, by status
+func setAsideMetric(m metrics.Metrics, status Status) metrics.Counter {
+	return m.Counter("teller_deposits_set_aside_total", "Deposits set aside for an operator, that will not be sent automatically", metrics.Labels{
+		"status": status.String(),
+	})
+}
+
+// SetOnProcessError sets the handler that decides what to do when a deposit fails to send.
+// It must be called before Run.
+func (e *Exchange) SetOnProcessError(h ProcessErrorHandler) {
+	e.Sender.SetOnProcessError(h)
+}
+
+// SetCoinHourStrategy sets a func that chooses the coin hour strategy of each deposit's send.
+// It must be called before Run.
+func (e *Exchange) SetCoinHourStrategy(f CoinHourStrategyFunc) {
+	e.Sender.SetCoinHourStrategy(f)
+}
+
+// SetMemo sets a func that chooses the memo of each deposit's send.
+// It must be called before Run.
+func (e *Exchange) SetMemo(f MemoFunc) {
+	e.Sender.SetMemo(f)
+}
+
+// SetAddressFilter sets which deposit addresses the exchange processes the deposits of,
+// so that a shared wallet's deposits can be sharded across teller instances. Deposits to other addresses are ignored.
+// It must be called before Run.
+func (e *Exchange) SetAddressFilter(f AddressFilter) {
+	e.Receiver.SetAddressFilter(f)
+}
@@ -1,9 +1,15 @@
 package exchange
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"os"
 	"strings"
 	"sync"
 	"testing"
@@ -17,12 +23,18 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/skycoin/skycoin/src/api/cli"
+	"github.com/skycoin/skycoin/src/api/webrpc"
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skycoin/src/coin"
+	"github.com/skycoin/skycoin/src/visor"
+	"github.com/skycoin/skycoin/src/wallet"
 
 	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/metrics"
+	"github.com/skycoin/teller/src/notify"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/dbutil"
 	"github.com/skycoin/teller/src/util/testutil"
 )
 
@@ -34,8 +46,12 @@ type dummySender struct {
 	txidConfirmMap          map[string]bool
 	changeAddr              string
 	changeCoins             uint64
+	lastOption              sender.SendOption
+	balanceErr              error
 }
 
+const dummyMaxMemoLength = 16
+
 func newDummySender() *dummySender {
 	return &dummySender{
 		txidConfirmMap: make(map[string]bool),
@@ -44,7 +60,12 @@ func newDummySender() *dummySender {
 	}
 }
 
-func (s *dummySender) CreateTransaction(destAddr string, coins uint64) (*coin.Transaction, error) {
+func (s *dummySender) CreateTransaction(destAddr string, coins uint64, opt sender.SendOption) (*coin.Transaction, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastOption = opt
+
 	if s.createTransactionErr != nil {
 		return nil, s.createTransactionErr
 	}
@@ -66,6 +87,40 @@ func (s *dummySender) CreateTransaction(destAddr string, coins uint64) (*coin.Tr
 	}, nil
 }
 
+func (s *dummySender) CreateBatchTransaction(recipients []sender.Recipient, opt sender.SendOption) (*coin.Transaction, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastOption = opt
+
+	if s.createTransactionErr != nil {
+		return nil, s.createTransactionErr
+	}
+
+	tx := &coin.Transaction{
+		Out: []coin.TransactionOutput{
+			{
+				Address: cipher.MustDecodeBase58Address(s.changeAddr),
+				Coins:   s.changeCoins,
+			},
+		},
+	}
+
+	for _, r := range recipients {
+		tx.Out = append(tx.Out, coin.TransactionOutput{
+			Address: cipher.MustDecodeBase58Address(r.Addr),
+			Coins:   r.Coins,
+		})
+	}
+
+	return tx, nil
+}
+
+// MaxMemoLength makes dummySender a sender.MemoSender
+func (s *dummySender) MaxMemoLength() int {
+	return dummyMaxMemoLength
+}
+
 func (s *dummySender) BroadcastTransaction(tx *coin.Transaction) *sender.BroadcastTxResponse {
 	req := sender.BroadcastTxRequest{
 		Tx:   tx,
@@ -108,7 +163,7 @@ func (s *dummySender) IsTxConfirmed(txid string) *sender.ConfirmResponse {
 }
 
 func (s *dummySender) predictTxid(t *testing.T, destAddr string, coins uint64) string {
-	tx, err := s.CreateTransaction(destAddr, coins)
+	tx, err := s.CreateTransaction(destAddr, coins, sender.SendOption{})
 	require.NoError(t, err)
 	return tx.TxIDHex()
 }
@@ -121,6 +176,13 @@ func (s *dummySender) setTxConfirmed(txid string) {
 }
 
 func (s *dummySender) Balance() (*cli.Balance, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.balanceErr != nil {
+		return nil, s.balanceErr
+	}
+
 	return &cli.Balance{
 		Coins: "100.000000",
 		Hours: "100",
@@ -143,12 +205,21 @@ func (scan *dummyScanner) AddScanAddress(btcAddr, coinType string) error {
 	return nil
 }
 
+func (scan *dummyScanner) AddScanAddresses(btcAddrs []string, coinType string) error {
+	scan.addrs = append(scan.addrs, btcAddrs...)
+	return nil
+}
+
 func (scan *dummyScanner) GetDeposit() <-chan scanner.DepositNote {
 	return scan.dvC
 }
 
+func (scan *dummyScanner) Rescan(addr string) error {
+	return nil
+}
+
 func (scan *dummyScanner) GetScanAddresses() ([]string, error) {
-	return []string{}, nil
+	return append([]string{}, scan.addrs...), nil
 }
 
 func (scan *dummyScanner) addDeposit(d scanner.DepositNote) {
@@ -188,21 +259,80 @@ func newTestExchange(t *testing.T, log *logrus.Logger, db *bolt.DB) *Exchange {
 	store, err := NewStore(log, db)
 	require.NoError(t, err)
 
+	return newTestExchangeWithStore(t, log, store)
+}
+
+func newTestExchangeWithStore(t *testing.T, log *logrus.Logger, store Storer) *Exchange {
+	return newTestExchangeWithConfig(t, log, store, defaultCfg)
+}
+
+func newTestExchangeWithConfig(t *testing.T, log *logrus.Logger, store Storer, cfg config.SkyExchanger) *Exchange {
 	bscr := newDummyScanner()
 	escr := newDummyScanner()
 	multiplexer := scanner.NewMultiplexer(log)
-	err = multiplexer.AddScanner(bscr, scanner.CoinTypeBTC)
+	err := multiplexer.AddScanner(bscr, scanner.CoinTypeBTC)
 	require.NoError(t, err)
 	err = multiplexer.AddScanner(escr, scanner.CoinTypeETH)
 	require.NoError(t, err)
 
 	go testutil.CheckError(t, multiplexer.Multiplex)
 
-	e, err := NewDirectExchange(log, defaultCfg, store, multiplexer, newDummySender())
+	e, err := NewDirectExchange(log, cfg, store, multiplexer, newDummySender())
 	require.NoError(t, err)
 	return e
 }
 
+// failingWriteStore is a Store that fails to save the updates made by the send service
+type failingWriteStore struct {
+	*Store
+	sync.Mutex
+	err          error
+	commitFails  bool // call the update callback, then fail as if the db commit failed
+	callbackRuns int
+}
+
+func (s *failingWriteStore) UpdateDepositInfo(depositID string, update func(DepositInfo) DepositInfo) (DepositInfo, error) {
+	return s.UpdateDepositInfoCallback(depositID, update, func(DepositInfo) error { return nil })
+}
+
+func (s *failingWriteStore) UpdateDepositInfoCallback(depositID string, update func(DepositInfo) DepositInfo, callback func(DepositInfo) error) (DepositInfo, error) {
+	di, err := s.Store.GetDepositInfo(depositID)
+	if err != nil {
+		return DepositInfo{}, err
+	}
+
+	di = update(di)
+	if di.Status != StatusWaitConfirm && di.Status != StatusDone {
+		return s.Store.UpdateDepositInfoCallback(depositID, update, callback)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.commitFails {
+		s.callbackRuns++
+		if err := callback(di); err != nil {
+			return DepositInfo{}, err
+		}
+	}
+
+	return DepositInfo{}, s.err
+}
+
+func waitExchangerStatus(t *testing.T, e Exchanger, expectedErr error) {
+	timeout := time.After(statusCheckTimeout)
+	for {
+		select {
+		case <-time.Tick(statusCheckInterval):
+			if e.Status() == expectedErr {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Waiting for status %v timed out, status is %v", expectedErr, e.Status())
+		}
+	}
+}
+
 func setupExchange(t *testing.T, log *logrus.Logger) (*Exchange, func(), func()) {
 	db, shutdownDB := testutil.PrepareDB(t)
 
@@ -254,6 +384,9 @@ func runExchangeMockStore(t *testing.T) (*Exchange, func(), *logrus_test.Hook) {
 	e, err := NewDirectExchange(log, defaultCfg, store, multiplexer, newDummySender())
 	require.NoError(t, err)
 
+	// Run loads the freeze state before starting
+	store.On("GetFreezeState").Return(FreezeState{}, nil)
+
 	done := make(chan struct{})
 	run := func() {
 		err := e.Run()
@@ -355,7 +488,7 @@ func TestExchangeRunSend(t *testing.T) {
 	go func() {
 		defer close(done)
 		for range time.Tick(dbCheckWaitTime) {
-			di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+			di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
 			log.Printf("loop getDepositInfo %v %v\n", di, err)
 			require.NoError(t, err)
 
@@ -372,25 +505,30 @@ func TestExchangeRunSend(t *testing.T) {
 	}
 
 	// Check DepositInfo
-	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
 	require.NoError(t, err)
 
 	require.NotEmpty(t, di.UpdatedAt)
 
 	expectedDeposit := DepositInfo{
-		Seq:            1,
-		CoinType:       scanner.CoinTypeBTC,
-		UpdatedAt:      di.UpdatedAt,
-		Status:         StatusWaitConfirm,
-		SkyAddress:     skyAddr,
-		DepositAddress: dn.Deposit.Address,
-		DepositID:      dn.Deposit.ID(),
-		Txid:           txid,
-		SkySent:        100e6,
-		BuyMethod:      config.BuyMethodDirect,
-		ConversionRate: testSkyBtcRate,
-		DepositValue:   dn.Deposit.Value,
-		Deposit:        dn.Deposit,
+		SchemaVersion:    SchemaVersion,
+		Seq:              1,
+		CoinType:         scanner.CoinTypeBTC,
+		UpdatedAt:        di.UpdatedAt,
+		StatusUpdatedAt:  di.StatusUpdatedAt,
+		CreatedAt:        di.CreatedAt,
+		Status:           StatusWaitConfirm,
+		SkyAddress:       skyAddr,
+		DepositAddress:   dn.Deposit.Address,
+		DepositID:        dn.Deposit.ID(),
+		Txid:             txid,
+		SkySent:          100e6,
+		CoinHourStrategy: string(sender.CoinHourStrategyShare),
+		AppliedRate:      testSkyBtcRate,
+		BuyMethod:        config.BuyMethodDirect,
+		ConversionRate:   testSkyBtcRate,
+		DepositValue:     dn.Deposit.Value,
+		Deposit:          dn.Deposit,
 	}
 
 	require.Equal(t, expectedDeposit, di)
@@ -403,7 +541,7 @@ func TestExchangeRunSend(t *testing.T) {
 	go func() {
 		defer close(done)
 		for range time.Tick(dbCheckWaitTime) {
-			di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+			di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
 			require.NoError(t, err)
 
 			if di.Status == StatusDone {
@@ -421,31 +559,117 @@ func TestExchangeRunSend(t *testing.T) {
 	checkExchangerStatus(t, e, nil)
 
 	// Check DepositInfo
-	di, err = e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	di, err = e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
 	require.NoError(t, err)
 
 	require.NotEmpty(t, di.UpdatedAt)
 
 	expectedDeposit = DepositInfo{
-		Seq:            1,
-		CoinType:       scanner.CoinTypeBTC,
-		UpdatedAt:      di.UpdatedAt,
-		Status:         StatusDone,
-		SkyAddress:     skyAddr,
-		DepositAddress: dn.Deposit.Address,
-		DepositID:      dn.Deposit.ID(),
-		Txid:           txid,
-		SkySent:        100e6,
-		BuyMethod:      config.BuyMethodDirect,
-		ConversionRate: testSkyBtcRate,
-		DepositValue:   dn.Deposit.Value,
-		Deposit:        dn.Deposit,
+		SchemaVersion:    SchemaVersion,
+		Seq:              1,
+		CoinType:         scanner.CoinTypeBTC,
+		UpdatedAt:        di.UpdatedAt,
+		StatusUpdatedAt:  di.StatusUpdatedAt,
+		CreatedAt:        di.CreatedAt,
+		Status:           StatusDone,
+		SkyAddress:       skyAddr,
+		DepositAddress:   dn.Deposit.Address,
+		DepositID:        dn.Deposit.ID(),
+		Txid:             txid,
+		SkySent:          100e6,
+		CoinHourStrategy: string(sender.CoinHourStrategyShare),
+		AppliedRate:      testSkyBtcRate,
+		BuyMethod:        config.BuyMethodDirect,
+		ConversionRate:   testSkyBtcRate,
+		DepositValue:     dn.Deposit.Value,
+		Deposit:          dn.Deposit,
 	}
 
 	require.Equal(t, expectedDeposit, di)
 	closeMultiplexer(e)
 }
 
+func TestExchangeMultipleDepositAddresses(t *testing.T) {
+	// Test that deposits to several addresses bound to one skycoin address
+	// are all sent to it, and are aggregated in the status and stats
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	skyAddr := testSkyAddr
+	btcAddrs := []string{"foo-btc-addr-1", "foo-btc-addr-2"}
+	for _, btcAddr := range btcAddrs {
+		_, err := e.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC)
+		require.NoError(t, err)
+	}
+
+	num, err := e.GetBindNum(skyAddr)
+	require.NoError(t, err)
+	require.Equal(t, len(btcAddrs), num)
+
+	values := []int64{1e8, 2e8}
+	mp := e.Receiver.(*Receive).multiplexer
+	s := e.Sender.(*Send).sender.(*dummySender)
+
+	var totalSkySent uint64
+	for i, btcAddr := range btcAddrs {
+		skySent, err := CalculateBtcSkyValue(values[i], testSkyBtcRate, testMaxDecimals)
+		require.NoError(t, err)
+		totalSkySent += skySent
+
+		dn := scanner.DepositNote{
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Value:    values[i],
+				Height:   20,
+				Tx:       fmt.Sprintf("foo-tx-%d", i),
+				N:        0,
+			},
+			ErrC: make(chan error, 1),
+		}
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+		err = <-dn.ErrC
+		require.NoError(t, err)
+
+		// Each deposit is sent to the same skycoin address, then confirmed
+		timeout := time.After(dbScanTimeout)
+	loop:
+		for {
+			select {
+			case <-time.Tick(dbCheckWaitTime):
+				di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+				require.NoError(t, err)
+				switch di.Status {
+				case StatusWaitConfirm:
+					require.Equal(t, skyAddr, di.SkyAddress)
+					require.Equal(t, skySent, di.SkySent)
+					s.setTxConfirmed(di.Txid)
+				case StatusDone:
+					break loop
+				}
+			case <-timeout:
+				t.Fatal("Waiting for confirmed deposit timed out")
+			}
+		}
+	}
+
+	dss, err := e.GetDepositStatuses(skyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, len(btcAddrs))
+	for _, ds := range dss {
+		require.Equal(t, StatusDone.String(), ds.Status)
+	}
+
+	stats, err := e.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, values[0]+values[1], stats.TotalBTCReceived)
+	require.Equal(t, int64(totalSkySent), stats.TotalSKYSent)
+
+	closeMultiplexer(e)
+}
+
 func TestExchangeUpdateBroadcastTxFailure(t *testing.T) {
 	// Test that a BroadcastTransaction error is handled properly
 	// The DepositInfo should not be updated if BroadcastTransaction fails.
@@ -488,21 +712,24 @@ func TestExchangeUpdateBroadcastTxFailure(t *testing.T) {
 
 	// Check the DepositInfo in the database
 	// Sky should not be sent
-	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
 	require.NoError(t, err)
 	require.NotEmpty(t, di.UpdatedAt)
 	require.Equal(t, DepositInfo{
-		Seq:            1,
-		CoinType:       scanner.CoinTypeBTC,
-		UpdatedAt:      di.UpdatedAt,
-		SkyAddress:     skyAddr,
-		DepositAddress: btcAddr,
-		DepositID:      dn.Deposit.ID(),
-		Status:         StatusWaitSend,
-		BuyMethod:      config.BuyMethodDirect,
-		ConversionRate: testSkyBtcRate,
-		DepositValue:   dn.Deposit.Value,
-		Deposit:        dn.Deposit,
+		SchemaVersion:   SchemaVersion,
+		Seq:             1,
+		CoinType:        scanner.CoinTypeBTC,
+		UpdatedAt:       di.UpdatedAt,
+		StatusUpdatedAt: di.StatusUpdatedAt,
+		CreatedAt:       di.CreatedAt,
+		SkyAddress:      skyAddr,
+		DepositAddress:  btcAddr,
+		DepositID:       dn.Deposit.ID(),
+		Status:          StatusWaitSend,
+		BuyMethod:       config.BuyMethodDirect,
+		ConversionRate:  testSkyBtcRate,
+		DepositValue:    dn.Deposit.Value,
+		Deposit:         dn.Deposit,
 	}, di)
 }
 
@@ -546,27 +773,32 @@ func TestExchangeCreateTxFailure(t *testing.T) {
 	checkExchangerStatus(t, e, createTransactionErr)
 
 	// Check the DepositInfo in the database
-	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
 	require.NoError(t, err)
 	require.NotEmpty(t, di.UpdatedAt)
 	require.Equal(t, DepositInfo{
-		Seq:            1,
-		CoinType:       scanner.CoinTypeBTC,
-		UpdatedAt:      di.UpdatedAt,
-		SkyAddress:     skyAddr,
-		DepositAddress: btcAddr,
-		DepositID:      dn.Deposit.ID(),
-		Status:         StatusWaitSend,
-		ConversionRate: testSkyBtcRate,
-		BuyMethod:      config.BuyMethodDirect,
-		DepositValue:   dn.Deposit.Value,
-		Deposit:        dn.Deposit,
+		SchemaVersion:   SchemaVersion,
+		Seq:             1,
+		CoinType:        scanner.CoinTypeBTC,
+		UpdatedAt:       di.UpdatedAt,
+		StatusUpdatedAt: di.StatusUpdatedAt,
+		CreatedAt:       di.CreatedAt,
+		SkyAddress:      skyAddr,
+		DepositAddress:  btcAddr,
+		DepositID:       dn.Deposit.ID(),
+		Status:          StatusWaitSend,
+		ConversionRate:  testSkyBtcRate,
+		BuyMethod:       config.BuyMethodDirect,
+		DepositValue:    dn.Deposit.Value,
+		Deposit:         dn.Deposit,
 	}, di)
 
 	require.Error(t, e.Status())
 }
 
-func TestExchangeTxConfirmFailure(t *testing.T) {
+func TestExchangeRetryDeadLetter(t *testing.T) {
+	// Test that a deposit which fails to send is dead-lettered, and that
+	// it is sent after being retried
 	e, shutdown, _ := runExchange(t)
 	defer shutdown()
 	defer e.Shutdown()
@@ -575,20 +807,18 @@ func TestExchangeTxConfirmFailure(t *testing.T) {
 	btcAddr := "foo-btc-addr"
 	mustBindAddress(t, e.store, skyAddr, btcAddr)
 
-	var value int64 = 1e8
-	skySent, err := CalculateBtcSkyValue(value, testSkyBtcRate, testMaxDecimals)
-	require.NoError(t, err)
-	txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, skyAddr, skySent)
-
-	// Force sender to return a confirm error so that the deposit stays at StatusWaitConfirm
-	confirmErr := errors.New("fake confirm error")
-	e.Sender.(*Send).sender.(*dummySender).confirmErr = confirmErr
+	// Force sender to return a create tx error so that the deposit is dead-lettered
+	createTransactionErr := errors.New("fake create transaction error")
+	s := e.Sender.(*Send).sender.(*dummySender)
+	s.Lock()
+	s.createTransactionErr = createTransactionErr
+	s.Unlock()
 
 	dn := scanner.DepositNote{
 		Deposit: scanner.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    value,
+			Value:    1e8,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
@@ -598,74 +828,115 @@ func TestExchangeTxConfirmFailure(t *testing.T) {
 	mp := e.Receiver.(*Receive).multiplexer
 	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
 
-	// First loop calls saveIncomingDeposit
-	// nil is written to ErrC after this method finishes
-	err = <-dn.ErrC
+	err := <-dn.ErrC
 	require.NoError(t, err)
 
-	// Wait for the deposit status error to update
-	checkExchangerStatus(t, e, confirmErr)
+	checkExchangerStatus(t, e, createTransactionErr)
 
-	// Wait for StatusWaitSend deposit in the database
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		for range time.Tick(dbCheckWaitTime) {
-			// Check the DepositInfo in the database
-			di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	var dls []DeadLetter
+	timeout := time.After(dbScanTimeout)
+loop:
+	for {
+		select {
+		case <-time.Tick(dbCheckWaitTime):
+			dls, err = e.ListDeadLetters()
 			require.NoError(t, err)
-
-			if di.Status == StatusWaitConfirm {
-				return
+			if len(dls) > 0 {
+				break loop
 			}
+		case <-timeout:
+			t.Fatal("Waiting for dead letter timed out")
 		}
-	}()
-
-	select {
-	case <-done:
-	case <-time.After(dbScanTimeout):
-		t.Fatal("Waiting to check for StatusWaitSend deposits timed out")
 	}
 
-	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	require.Len(t, dls, 1)
+	require.Equal(t, dn.Deposit.ID(), dls[0].DepositID)
+	require.Equal(t, createTransactionErr.Error(), dls[0].Reason)
+	require.Equal(t, 1, dls[0].Attempts)
+	require.True(t, dls[0].Pending)
+	require.Equal(t, StatusWaitSend, dls[0].DepositInfo.Status)
+
+	// Unknown deposits can't be retried
+	_, err = e.RetryDeadLetter("unknown-tx:0", "alice")
+	require.Equal(t, ErrDeadLetterNotFound, err)
+
+	// Clear the error and retry the deposit
+	s.Lock()
+	s.createTransactionErr = nil
+	s.Unlock()
+
+	dl, err := e.RetryDeadLetter(dn.Deposit.ID(), "alice")
 	require.NoError(t, err)
-	require.NotEmpty(t, di.UpdatedAt)
-	require.Equal(t, DepositInfo{
-		Seq:            1,
-		CoinType:       scanner.CoinTypeBTC,
-		UpdatedAt:      di.UpdatedAt,
-		SkyAddress:     skyAddr,
-		DepositAddress: btcAddr,
-		DepositID:      dn.Deposit.ID(),
-		Txid:           txid,
-		SkySent:        100e6,
-		DepositValue:   dn.Deposit.Value,
-		BuyMethod:      config.BuyMethodDirect,
-		Status:         StatusWaitConfirm,
-		ConversionRate: testSkyBtcRate,
-		Deposit:        dn.Deposit,
-	}, di)
+	require.False(t, dl.Pending)
+	require.Equal(t, 1, dl.Attempts)
+
+	dls, err = e.ListDeadLetters()
+	require.NoError(t, err)
+	require.Empty(t, dls)
+
+	// The retry is audited with the operator
+	audits, err := e.GetReviewAudits()
+	require.NoError(t, err)
+	require.Len(t, audits, 1)
+	require.Equal(t, ReviewActionRetry, audits[0].Action)
+	require.Equal(t, dn.Deposit.ID(), audits[0].DepositID)
+	require.Equal(t, "alice", audits[0].Operator)
 
+	// A resolved dead letter can't be retried twice
+	_, err = e.RetryDeadLetter(dn.Deposit.ID(), "alice")
+	require.Equal(t, ErrDeadLetterNotFound, err)
+
+	// The deposit is sent
+	timeout = time.After(dbScanTimeout)
+	for {
+		select {
+		case <-time.Tick(dbCheckWaitTime):
+			di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+			require.NoError(t, err)
+			if di.Status == StatusWaitConfirm {
+				require.NotEmpty(t, di.Txid)
+				return
+			}
+		case <-timeout:
+			t.Fatal("Waiting for retried deposit to send timed out")
+		}
+	}
 }
 
-func TestExchangeQuitBeforeConfirm(t *testing.T) {
-	e, shutdown, _ := runExchange(t)
+func TestExchangeOnProcessErrorRetry(t *testing.T) {
+	// Test that a deposit which fails to send is retried if the ProcessErrorHandler decides so
+	log, _ := testutil.NewLogger(t)
+	e, run, shutdown := setupExchange(t, log)
 	defer shutdown()
+	defer e.Shutdown()
+
+	createTransactionErr := errors.New("fake create transaction error")
+	s := e.Sender.(*Send).sender.(*dummySender)
+	s.Lock()
+	s.createTransactionErr = createTransactionErr
+	s.Unlock()
+
+	failures := make(chan error, 1)
+	e.SetOnProcessError(func(di DepositInfo, err error) Decision {
+		// Clear the error, so the deposit sends when retried
+		s.Lock()
+		s.createTransactionErr = nil
+		s.Unlock()
+		failures <- err
+		return DecisionRetry
+	})
+
+	go run()
 
 	skyAddr := testSkyAddr
 	btcAddr := "foo-btc-addr"
 	mustBindAddress(t, e.store, skyAddr, btcAddr)
 
-	var value int64 = 1e8
-	skySent, err := CalculateBtcSkyValue(value, testSkyBtcRate, testMaxDecimals)
-	require.NoError(t, err)
-	txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, skyAddr, skySent)
-
 	dn := scanner.DepositNote{
 		Deposit: scanner.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    value,
+			Value:    1e8,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
@@ -675,814 +946,4342 @@ func TestExchangeQuitBeforeConfirm(t *testing.T) {
 	mp := e.Receiver.(*Receive).multiplexer
 	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
 
-	// First loop calls saveIncomingDeposit
-	// nil is written to ErrC after this method finishes
-	err = <-dn.ErrC
+	err := <-dn.ErrC
 	require.NoError(t, err)
 
-	// Second loop calls processWaitSendDeposit
-	// It sends the coins, then confirms them
-	expectedDeposit := DepositInfo{
-		Seq:            1,
-		CoinType:       scanner.CoinTypeBTC,
-		Status:         StatusWaitConfirm,
-		SkyAddress:     skyAddr,
-		DepositAddress: dn.Deposit.Address,
-		DepositID:      dn.Deposit.ID(),
-		Txid:           txid,
-		SkySent:        100e6,
-		BuyMethod:      config.BuyMethodDirect,
-		DepositValue:   dn.Deposit.Value,
-		ConversionRate: testSkyBtcRate,
-		Deposit:        dn.Deposit,
-	}
+	require.Equal(t, createTransactionErr, <-failures)
 
-	// Periodically check the database until we observe the sent deposit
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		for range time.Tick(dbCheckWaitTime) {
-			di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	// The deposit is sent after the retry, and is not dead-lettered
+	timeout := time.After(dbScanTimeout)
+loop:
+	for {
+		select {
+		case <-time.Tick(dbCheckWaitTime):
+			di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
 			require.NoError(t, err)
-
-			if di.Status != expectedDeposit.Status {
-				continue
+			if di.Status == StatusWaitConfirm {
+				break loop
 			}
+		case <-timeout:
+			t.Fatal("Waiting for retried deposit to send timed out")
+		}
+	}
 
-			require.NotEmpty(t, di.UpdatedAt)
+	dls, err := e.ListDeadLetters()
+	require.NoError(t, err)
+	require.Empty(t, dls)
+}
 
-			ed := expectedDeposit
-			ed.UpdatedAt = di.UpdatedAt
+func TestExchangeReviewSend(t *testing.T) {
+	// Test that a deposit held for review is sent once approved, and not sent if rejected
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
 
-			require.Equal(t, ed, di)
-			return
-		}
-	}()
+	store := e.store.(*Store)
 
-	select {
-	case <-done:
-	case <-time.After(dbScanTimeout):
-		t.Fatal("Waiting for sent deposit timed out")
+	holdDeposit := func(depositID string) DepositInfo {
+		di, err := store.addDepositInfo(DepositInfo{
+			DepositID:      depositID,
+			SkyAddress:     testSkyAddr,
+			DepositAddress: "foo-btc-addr",
+			DepositValue:   1e8,
+			ConversionRate: testSkyBtcRate,
+			CoinType:       scanner.CoinTypeBTC,
+			Status:         StatusWaitSend,
+			BuyMethod:      config.BuyMethodDirect,
+		})
+		require.NoError(t, err)
+
+		di, err = store.HoldForReview(di.DepositID, "large deposit")
+		require.NoError(t, err)
+		return di
 	}
 
-	e.Shutdown()
+	di1 := holdDeposit("foo-tx:1")
+	di2 := holdDeposit("foo-tx:2")
 
-	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	pending, err := e.PendingReview()
 	require.NoError(t, err)
+	require.Len(t, pending, 2)
 
-	require.NotEmpty(t, di.UpdatedAt)
-	ed := expectedDeposit
-	ed.UpdatedAt = di.UpdatedAt
+	_, err = e.ApproveSend("unknown-tx:0", "alice")
+	require.IsType(t, dbutil.ObjectNotExistErr{}, err)
 
-	require.Equal(t, ed, di)
-}
+	_, err = e.ApproveSend(di1.DepositID, "alice")
+	require.NoError(t, err)
 
-func TestExchangeSendZeroCoins(t *testing.T) {
-	// Tests what happens when the scanner sends us an empty deposit value,
-	// or the deposit value is so small that it is worth less than 1 SKY after
-	// rate conversion.
-	// The scanner should never do this, but we must handle it in case it happens
-	e, shutdown, hook := runExchange(t)
-	defer shutdown()
+	di, err := e.RejectSend(di2.DepositID, "bob", "sender failed kyc")
+	require.NoError(t, err)
+	require.Equal(t, StatusRejected, di.Status)
 
-	skyAddr := testSkyAddr
-	btcAddr := "foo-btc-addr"
-	mustBindAddress(t, e.store, skyAddr, btcAddr)
+	pending, err = e.PendingReview()
+	require.NoError(t, err)
+	require.Empty(t, pending)
 
-	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
-			CoinType: scanner.CoinTypeBTC,
-			Address:  btcAddr,
-			Value:    1, // The amount is so low that no SKY can be sent
-			Height:   20,
-			Tx:       "foo-tx",
-			N:        2,
-		},
-		ErrC: make(chan error, 1),
+	audits, err := e.GetReviewAudits()
+	require.NoError(t, err)
+	require.Len(t, audits, 2)
+	require.Equal(t, "alice", audits[0].Operator)
+	require.Equal(t, "bob", audits[1].Operator)
+
+	// The approved deposit is sent
+	timeout := time.After(dbScanTimeout)
+loop:
+	for {
+		select {
+		case <-time.Tick(dbCheckWaitTime):
+			di, err := store.GetDepositInfo(di1.DepositID)
+			require.NoError(t, err)
+			if di.Status == StatusWaitConfirm || di.Status == StatusDone {
+				require.NotEmpty(t, di.Txid)
+				break loop
+			}
+		case <-timeout:
+			t.Fatal("Waiting for approved deposit to send timed out")
+		}
 	}
-	mp := e.Receiver.(*Receive).multiplexer
-	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
 
-	// First loop calls saveIncomingDeposit
-	// nil is written to ErrC after this method finishes
-	err := <-dn.ErrC
+	// The rejected deposit is not sent
+	di, err = store.GetDepositInfo(di2.DepositID)
 	require.NoError(t, err)
+	require.Equal(t, StatusRejected, di.Status)
+	require.Empty(t, di.Txid)
+}
 
-	// Second loop calls processWaitSendDeposit
-	// It sends the coins, then confirms them
+func TestExchangeKYCHold(t *testing.T) {
+	// Test that deposits greater than the KYC threshold are held until their deposit address is cleared, then sent
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
 
-	expectedDeposit := DepositInfo{
-		Seq:            1,
-		CoinType:       scanner.CoinTypeBTC,
-		Status:         StatusDone,
-		SkyAddress:     skyAddr,
-		DepositAddress: dn.Deposit.Address,
-		DepositID:      dn.Deposit.ID(),
-		Txid:           "",
-		SkySent:        0,
-		ConversionRate: testSkyBtcRate,
-		BuyMethod:      config.BuyMethodDirect,
-		DepositValue:   dn.Deposit.Value,
-		Deposit:        dn.Deposit,
-		Error:          ErrEmptySendAmount.Error(),
-	}
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	cfg := defaultCfg
+	cfg.KYCThresholdSky = "100"
+	e := newTestExchangeWithConfig(t, log, store, cfg)
 
-	// Periodically check the database until we observe the sent deposit
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		for range time.Tick(dbCheckWaitTime) {
-			di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
-			require.NoError(t, err)
-
-			if di.Status != expectedDeposit.Status {
-				continue
-			}
-
-			require.NotEmpty(t, di.UpdatedAt)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
 
-			ed := expectedDeposit
-			ed.UpdatedAt = di.UpdatedAt
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, testSkyAddr, btcAddr)
 
-			require.Equal(t, ed, di)
-			return
+	mp := e.Receiver.(*Receive).multiplexer
+	addDeposit := func(tx string, value int64) scanner.Deposit {
+		dn := scanner.DepositNote{
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Value:    value,
+				Height:   20,
+				Tx:       tx,
+				N:        2,
+			},
+			ErrC: make(chan error, 1),
 		}
-	}()
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		err := <-dn.ErrC
+		require.NoError(t, err)
+		return dn.Deposit
+	}
 
-	select {
-	case <-done:
-	case <-time.After(dbScanTimeout):
-		t.Fatal("Waiting for sent deposit timed out")
+	waitForStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(depositID)
+				require.NoError(t, err)
+				if di.Status == status {
+					return di
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
+			}
+		}
 	}
 
-	e.Shutdown()
+	// sendAndConfirm waits for a deposit to be sent, and confirms it so that the next deposit is sent
+	sendAndConfirm := func(depositID string, skySent uint64) {
+		di := waitForStatus(depositID, StatusWaitConfirm)
+		require.Equal(t, skySent, di.SkySent)
+		require.NotEmpty(t, di.Txid)
+		require.Empty(t, di.Error)
+		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(di.Txid)
+		waitForStatus(depositID, StatusDone)
+	}
 
-	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
-	require.NoError(t, err)
+	// A deposit of exactly the threshold, 100 SKY, is sent
+	atThreshold := addDeposit("foo-tx", 1e8)
+	sendAndConfirm(atThreshold.ID(), 100e6)
 
-	require.NotEmpty(t, di.UpdatedAt)
-	ed := expectedDeposit
-	ed.UpdatedAt = di.UpdatedAt
+	// A deposit greater than the threshold is held
+	overThreshold := addDeposit("bar-tx", 2e8)
+	di := waitForStatus(overThreshold.ID(), StatusKYCHold)
+	require.Equal(t, ErrKYCHold.Error(), di.Error)
 
-	require.Equal(t, ed, di)
+	// It is not sent while held, and can't be approved
+	time.Sleep(defaultCfg.TxConfirmationCheckWait * 2)
+	di, err = store.GetDepositInfo(overThreshold.ID())
+	require.NoError(t, err)
+	require.Equal(t, StatusKYCHold, di.Status)
+	require.Empty(t, di.Txid)
 
-	loggedErrEmptySendAmount := false
-	for _, e := range hook.AllEntries() {
-		err, ok := e.Data["error"].(error)
-		if ok && err == ErrEmptySendAmount {
-			loggedErrEmptySendAmount = true
-			break
-		}
-	}
+	pending, err := e.PendingReview()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, overThreshold.ID(), pending[0].DepositID)
 
-	require.True(t, loggedErrEmptySendAmount)
-}
+	_, err = e.ApproveSend(overThreshold.ID(), "alice")
+	require.Equal(t, ErrDepositNotInReview, err)
 
-func testExchangeRunProcessDepositBacklog(t *testing.T, dis []DepositInfo, configureSender func(*Exchange, DepositInfo)) {
-	log, _ := testutil.NewLogger(t)
-	e, run, shutdown := setupExchange(t, log)
+	// Clearing the deposit address's KYC sends it
+	released, err := e.ClearKYC(btcAddr, "alice")
+	require.NoError(t, err)
+	require.Len(t, released, 1)
+	require.Equal(t, overThreshold.ID(), released[0].DepositID)
 
-	updatedDis := make([]DepositInfo, 0, len(dis))
-	for _, di := range dis {
-		err := di.ValidateForStatus()
-		require.NoError(t, err)
-		configureSender(e, di)
+	sendAndConfirm(overThreshold.ID(), 200e6)
 
-		updatedDi, err := e.store.(*Store).addDepositInfo(di)
-		require.NoError(t, err)
-		updatedDis = append(updatedDis, updatedDi)
-	}
+	// Later deposits to the cleared address are not held
+	afterClear := addDeposit("baz-tx", 3e8)
+	sendAndConfirm(afterClear.ID(), 300e6)
 
-	dis = updatedDis
+	// The hold and the clearance are audited
+	audits, err := e.GetReviewAudits()
+	require.NoError(t, err)
+	require.Len(t, audits, 2)
+	require.Equal(t, ReviewActionKYCHold, audits[0].Action)
+	require.Equal(t, overThreshold.ID(), audits[0].DepositID)
+	require.Equal(t, btcAddr, audits[0].Address)
+	require.Equal(t, ReviewActionKYCClear, audits[1].Action)
+	require.Equal(t, btcAddr, audits[1].Address)
+	require.Equal(t, "alice", audits[1].Operator)
+}
 
-	filter := func(di DepositInfo) bool {
-		return di.Status == StatusDone
-	}
+func TestExchangeRepricePending(t *testing.T) {
+	// Test that a held deposit is sent at the rate it was repriced to, and a sent deposit is not repriced
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
 
-	// Make sure that there are no confirmed deposits yet
-	confirmed, err := e.store.GetDepositInfoArray(filter)
+	store, err := NewStore(log, db)
 	require.NoError(t, err)
-	require.Len(t, confirmed, 0)
 
-	// Run the exchange
-	go run()
-	defer shutdown()
-	defer e.Shutdown()
+	cfg := defaultCfg
+	cfg.KYCThresholdSky = "100"
+	e := newTestExchangeWithConfig(t, log, store, cfg)
 
-	// Wait until we find 2 confirmed deposits
 	done := make(chan struct{})
-	complete := make(chan struct{})
 	go func() {
 		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
+
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, testSkyAddr, btcAddr)
+
+	mp := e.Receiver.(*Receive).multiplexer
+	addDeposit := func(tx string, value int64) scanner.Deposit {
+		dn := scanner.DepositNote{
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Value:    value,
+				Height:   20,
+				Tx:       tx,
+				N:        2,
+			},
+			ErrC: make(chan error, 1),
+		}
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		err := <-dn.ErrC
+		require.NoError(t, err)
+		return dn.Deposit
+	}
+
+	waitForStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
 		for {
 			select {
-			case <-complete:
-				return
-			case <-time.After(dbCheckWaitTime):
-				confirmed, err := e.store.GetDepositInfoArray(filter)
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(depositID)
 				require.NoError(t, err)
-				if len(confirmed) == len(dis) {
-					return
+				if di.Status == status {
+					return di
 				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
 			}
 		}
-	}()
+	}
 
-	select {
-	case <-done:
-	case <-time.After(dbScanTimeout):
-		close(complete)
-		t.Fatal("Waiting for confirmed deposits timed out")
+	sendAndConfirm := func(depositID string, skySent uint64) DepositInfo {
+		di := waitForStatus(depositID, StatusWaitConfirm)
+		require.Equal(t, skySent, di.SkySent)
+		require.NotEmpty(t, di.Txid)
+		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(di.Txid)
+		return waitForStatus(depositID, StatusDone)
 	}
 
-	confirmed, err = e.store.GetDepositInfoArray(filter)
-	require.NoError(t, err)
-	require.Len(t, confirmed, len(dis))
+	sent := addDeposit("foo-tx", 1e8)
+	sendAndConfirm(sent.ID(), 100e6)
 
-	// Verify the 2 confirmed deposits
-	expectedDis := make([]DepositInfo, len(dis))
-	for i, di := range dis {
-		expectedDis[i] = di
-		expectedDis[i].Status = StatusDone
+	held := addDeposit("bar-tx", 2e8)
+	waitForStatus(held.ID(), StatusKYCHold)
 
-		if expectedDis[i].SkySent == 0 {
-			t.Logf("di.DepositValue=%d e.cfg.SkyBtcExchangeRate=%s", di.DepositValue, e.cfg.SkyBtcExchangeRate)
-			amt, err := CalculateBtcSkyValue(di.DepositValue, e.cfg.SkyBtcExchangeRate, testMaxDecimals)
-			require.NoError(t, err)
-			expectedDis[i].SkySent = amt
-		}
+	_, err = e.RepricePending(scanner.CoinTypeBTC, "bad", "alice", "")
+	require.Equal(t, ErrInvalidRate, err)
 
-		require.NotEmpty(t, confirmed[i].UpdatedAt)
-		expectedDis[i].UpdatedAt = confirmed[i].UpdatedAt
+	_, err = e.RepricePending("SKY", "150", "alice", "")
+	require.Equal(t, scanner.ErrUnsupportedCoinType, err)
 
-		require.Equal(t, expectedDis[i], confirmed[i])
-	}
-}
+	n, err := e.RepricePending(scanner.CoinTypeBTC, "150", "alice", "rate moved")
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
 
-func TestExchangeProcessUnconfirmedTx(t *testing.T) {
-	// Tests that StatusWaitConfirm deposits found in the db are processed
-	// on exchange startup.
+	// The sent deposit keeps its rate
+	di, err := store.GetDepositInfo(sent.ID())
+	require.NoError(t, err)
+	require.Equal(t, testSkyBtcRate, di.ConversionRate)
+	require.Equal(t, uint64(100e6), di.SkySent)
 
-	var depositValue int64 = 1e8
-	s := newDummySender()
-	skySent, err := CalculateBtcSkyValue(depositValue, testSkyBtcRate, testMaxDecimals)
+	// The held deposit is sent at the new rate once released
+	_, err = e.ClearKYC(btcAddr, "alice")
 	require.NoError(t, err)
-	txid1 := s.predictTxid(t, testSkyAddr, skySent)
-	txid2 := s.predictTxid(t, testSkyAddr2, skySent)
+	di = sendAndConfirm(held.ID(), 300e6)
+	require.Equal(t, "150", di.ConversionRate)
 
-	// Add StatusWaitConfirm deposits
-	// They should all be confirmed after shutdown
-	dis := []DepositInfo{
-		{
-			Seq:            1,
-			Status:         StatusWaitConfirm,
-			SkyAddress:     testSkyAddr,
-			DepositAddress: "foo-btc-addr-1",
-			DepositID:      "foo-tx-1:1",
-			Txid:           txid1,
-			SkySent:        skySent,
-			ConversionRate: testSkyBtcRate,
-			BuyMethod:      config.BuyMethodDirect,
-			DepositValue:   depositValue,
+	audits, err := e.GetReviewAudits()
+	require.NoError(t, err)
+	require.Len(t, audits, 3)
+	require.Equal(t, ReviewActionReprice, audits[1].Action)
+	require.Equal(t, held.ID(), audits[1].DepositID)
+	require.Equal(t, "150", audits[1].Rate)
+	require.Equal(t, testSkyBtcRate, audits[1].PrevRate)
+	require.Equal(t, "rate moved", audits[1].Reason)
+}
+
+func TestExchangeSendAllowance(t *testing.T) {
+	// Test that deposits exceeding the send allowance wait for the window to have room, then are sent
+	log, hook := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	cfg := defaultCfg
+	cfg.SendAllowanceSky = "250"
+	cfg.SendAllowanceWindow = time.Hour
+	e := newTestExchangeWithConfig(t, log, store, cfg)
+	s := e.Sender.(*Send)
+
+	registry := metrics.NewRegistry()
+	e.SetMetrics(registry)
+
+	var clockLock sync.Mutex
+	now := time.Now()
+	s.now = func() time.Time {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		return now
+	}
+	setClock := func(t time.Time) {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		now = t
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
+
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, testSkyAddr, btcAddr)
+
+	mp := e.Receiver.(*Receive).multiplexer
+	addDeposit := func(tx string, value int64) scanner.Deposit {
+		dn := scanner.DepositNote{
 			Deposit: scanner.Deposit{
 				CoinType: scanner.CoinTypeBTC,
-				Address:  "foo-btc-addr-1",
-				Value:    depositValue,
+				Address:  btcAddr,
+				Value:    value,
 				Height:   20,
-				Tx:       "foo-tx-1",
-				N:        1,
+				Tx:       tx,
+				N:        2,
+			},
+			ErrC: make(chan error, 1),
+		}
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		err := <-dn.ErrC
+		require.NoError(t, err)
+		return dn.Deposit
+	}
+
+	waitForStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(depositID)
+				require.NoError(t, err)
+				if di.Status == status {
+					return di
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
+			}
+		}
+	}
+
+	// sendAndConfirm waits for a deposit to be sent, and confirms it so that the next deposit is sent
+	sendAndConfirm := func(depositID string, skySent uint64) {
+		di := waitForStatus(depositID, StatusWaitConfirm)
+		require.Equal(t, skySent, di.SkySent)
+		s.sender.(*dummySender).setTxConfirmed(di.Txid)
+		waitForStatus(depositID, StatusDone)
+	}
+
+	requireAllowanceUsed := func(droplets string) {
+		var buf bytes.Buffer
+		_, err := registry.WriteTo(&buf)
+		require.NoError(t, err)
+		require.Contains(t, buf.String(), "teller_send_allowance_used_droplets "+droplets+"\n")
+	}
+
+	// 220 of the 250 SKY allowance are sent
+	first := addDeposit("foo-tx", 1e8)
+	sendAndConfirm(first.ID(), 100e6)
+	second := addDeposit("bar-tx", 12e7)
+	sendAndConfirm(second.ID(), 120e6)
+	requireAllowanceUsed("2.2e+08")
+
+	// A deposit exceeding the allowance is rate limited
+	limited := addDeposit("baz-tx", 5e7)
+	di := waitForStatus(limited.ID(), StatusRateLimited)
+	require.Equal(t, ErrSendAllowanceExceeded.Error(), di.Error)
+	require.Empty(t, di.Txid)
+	require.NoError(t, di.ValidateForStatus())
+
+	// It is listed for review, so that an operator can reject it
+	pending, err := e.PendingReview()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, limited.ID(), pending[0].DepositID)
+	require.Equal(t, StatusRateLimited, pending[0].Status)
+
+	// It is not released while the window is full
+	released, err := s.ReleaseRateLimited()
+	require.NoError(t, err)
+	require.Empty(t, released)
+
+	// Once the earlier sends leave the window, it is released and sent
+	setClock(now.Add(time.Hour + time.Minute))
+
+	released, err = s.ReleaseRateLimited()
+	require.NoError(t, err)
+	require.Len(t, released, 1)
+	require.Equal(t, limited.ID(), released[0].DepositID)
+	require.Equal(t, StatusWaitSend, released[0].Status)
+
+	sendAndConfirm(limited.ID(), 50e6)
+	requireAllowanceUsed("5e+07")
+
+	// A deposit greater than the whole allowance is never released, and must be rejected by an operator
+	tooLarge := addDeposit("qux-tx", 3e8)
+	waitForStatus(tooLarge.ID(), StatusRateLimited)
+
+	var alerted bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["alert"] == "rate_limited" {
+			alerted = true
+		}
+	}
+	require.True(t, alerted)
+
+	setClock(now.Add(time.Hour * 2))
+
+	released, err = s.ReleaseRateLimited()
+	require.NoError(t, err)
+	require.Empty(t, released)
+
+	di, err = e.RejectSend(tooLarge.ID(), "alice", "greater than the send allowance")
+	require.NoError(t, err)
+	require.Equal(t, StatusRejected, di.Status)
+}
+func TestExchangeFreeze(t *testing.T) {
+	// Test that nothing is bound or sent while frozen, and that the deposit is sent once unfrozen
+	log, _ := testutil.NewLogger(t)
+	e, run, shutdown := setupExchange(t, log)
+	defer shutdown()
+	defer e.Shutdown()
+
+	store := e.store.(*Store)
+
+	_, err := e.Unfreeze("alice", "not frozen")
+	require.Equal(t, ErrNotFrozen, err)
+
+	state, err := e.Freeze("alice", "incident 42")
+	require.NoError(t, err)
+	require.True(t, state.Frozen)
+	require.True(t, e.Frozen())
+
+	_, err = e.Freeze("bob", "again")
+	require.Equal(t, ErrFrozen, err)
+
+	saved, err := e.FreezeState()
+	require.NoError(t, err)
+	require.Equal(t, state, saved)
+
+	_, err = e.BindAddress(testSkyAddr, "foo-btc-addr", scanner.CoinTypeBTC)
+	require.Equal(t, ErrFrozen, err)
+
+	_, err = e.ApproveSend("foo-tx:1", "alice")
+	require.Equal(t, ErrFrozen, err)
+
+	di, err := store.addDepositInfo(DepositInfo{
+		DepositID:      "foo-tx:1",
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "foo-btc-addr",
+		DepositValue:   1e8,
+		ConversionRate: testSkyBtcRate,
+		CoinType:       scanner.CoinTypeBTC,
+		Status:         StatusWaitSend,
+		BuyMethod:      config.BuyMethodDirect,
+	})
+	require.NoError(t, err)
+
+	// The exchange is started after the deposit is saved, so that it is loaded with the saved deposits
+	go run()
+
+	// The deposit is parked while frozen
+	timeout := time.After(dbScanTimeout)
+	for e.gate.parkedLoops() == 0 {
+		select {
+		case <-time.After(dbCheckWaitTime):
+		case <-timeout:
+			t.Fatal("Waiting for the deposit to park timed out")
+		}
+	}
+
+	di, err = store.GetDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+
+	state, err = e.Unfreeze("bob", "resolved")
+	require.NoError(t, err)
+	require.False(t, state.Frozen)
+	require.False(t, e.Frozen())
+
+	audits, err := e.GetReviewAudits()
+	require.NoError(t, err)
+	require.Len(t, audits, 2)
+	require.Equal(t, ReviewActionFreeze, audits[0].Action)
+	require.Equal(t, "alice", audits[0].Operator)
+	require.Equal(t, "incident 42", audits[0].Reason)
+	require.Empty(t, audits[0].DepositID)
+	require.Equal(t, ReviewActionUnfreeze, audits[1].Action)
+	require.Equal(t, "bob", audits[1].Operator)
+
+	// The deposit is sent once unfrozen
+	timeout = time.After(dbScanTimeout)
+loop:
+	for {
+		select {
+		case <-time.Tick(dbCheckWaitTime):
+			di, err := store.GetDepositInfo(di.DepositID)
+			require.NoError(t, err)
+			if di.Status == StatusWaitConfirm || di.Status == StatusDone {
+				require.NotEmpty(t, di.Txid)
+				break loop
+			}
+		case <-timeout:
+			t.Fatal("Waiting for the deposit to send timed out")
+		}
+	}
+
+	require.Equal(t, 0, e.gate.parkedLoops())
+}
+
+func TestExchangeOnProcessErrorStop(t *testing.T) {
+	// Test that sending stops if the ProcessErrorHandler decides so
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	e := newTestExchange(t, log, db)
+
+	createTransactionErr := errors.New("fake create transaction error")
+	s := e.Sender.(*Send).sender.(*dummySender)
+	s.Lock()
+	s.createTransactionErr = createTransactionErr
+	s.Unlock()
+
+	e.SetOnProcessError(func(di DepositInfo, err error) Decision {
+		return DecisionStop
+	})
+
+	runErrC := make(chan error, 1)
+	go func() {
+		runErrC <- e.Run()
+	}()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, e.store, skyAddr, btcAddr)
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	err := <-dn.ErrC
+	require.NoError(t, err)
+
+	checkExchangerStatus(t, e, ErrSendStopped)
+
+	dls, err := e.ListDeadLetters()
+	require.NoError(t, err)
+	require.Len(t, dls, 1)
+	require.Equal(t, dn.Deposit.ID(), dls[0].DepositID)
+
+	e.Shutdown()
+	require.Equal(t, createTransactionErr, <-runErrC)
+}
+
+func TestExchangeReadOnlyStoreWriteFailures(t *testing.T) {
+	// Test that sending stops if the store persistently fails to save deposits
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	s, err := NewStore(log, db)
+	require.NoError(t, err)
+	store := &failingWriteStore{
+		Store: s,
+		err:   errors.New("no space left on device"),
+	}
+
+	e := newTestExchangeWithStore(t, log, store)
+
+	runErrC := make(chan error, 1)
+	go func() {
+		runErrC <- e.Run()
+	}()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, s, skyAddr, btcAddr)
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	waitExchangerStatus(t, e, ErrReadOnly)
+
+	// No coins were sent, and the deposit is parked in its last saved state
+	store.Lock()
+	require.Equal(t, 0, store.callbackRuns)
+	store.Unlock()
+
+	di, err := s.GetDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+
+	e.Shutdown()
+	require.Equal(t, ErrReadOnly, <-runErrC)
+}
+
+func TestExchangeReadOnlySentNotRecorded(t *testing.T) {
+	// Test that sending stops immediately if coins were sent but the deposit could not be saved
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	s, err := NewStore(log, db)
+	require.NoError(t, err)
+	store := &failingWriteStore{
+		Store:       s,
+		err:         errors.New("no space left on device"),
+		commitFails: true,
+	}
+
+	e := newTestExchangeWithStore(t, log, store)
+
+	runErrC := make(chan error, 1)
+	go func() {
+		runErrC <- e.Run()
+	}()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, s, skyAddr, btcAddr)
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	waitExchangerStatus(t, e, ErrReadOnly)
+
+	// The coins were sent once, and not sent again
+	time.Sleep(defaultCfg.TxConfirmationCheckWait * 2)
+	store.Lock()
+	require.Equal(t, 1, store.callbackRuns)
+	store.Unlock()
+
+	e.Shutdown()
+	require.Equal(t, ErrSentNotRecorded, <-runErrC)
+}
+
+func TestExchangeTxConfirmFailure(t *testing.T) {
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, e.store, skyAddr, btcAddr)
+
+	var value int64 = 1e8
+	skySent, err := CalculateBtcSkyValue(value, testSkyBtcRate, testMaxDecimals)
+	require.NoError(t, err)
+	txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, skyAddr, skySent)
+
+	// Force sender to return a confirm error so that the deposit stays at StatusWaitConfirm
+	confirmErr := errors.New("fake confirm error")
+	e.Sender.(*Send).sender.(*dummySender).confirmErr = confirmErr
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    value,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	// First loop calls saveIncomingDeposit
+	// nil is written to ErrC after this method finishes
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	// Wait for the deposit status error to update
+	checkExchangerStatus(t, e, confirmErr)
+
+	// Wait for StatusWaitSend deposit in the database
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range time.Tick(dbCheckWaitTime) {
+			// Check the DepositInfo in the database
+			di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+			require.NoError(t, err)
+
+			if di.Status == StatusWaitConfirm {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dbScanTimeout):
+		t.Fatal("Waiting to check for StatusWaitSend deposits timed out")
+	}
+
+	di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+	require.NotEmpty(t, di.UpdatedAt)
+	require.Equal(t, DepositInfo{
+		SchemaVersion:    SchemaVersion,
+		Seq:              1,
+		CoinType:         scanner.CoinTypeBTC,
+		UpdatedAt:        di.UpdatedAt,
+		StatusUpdatedAt:  di.StatusUpdatedAt,
+		CreatedAt:        di.CreatedAt,
+		SkyAddress:       skyAddr,
+		DepositAddress:   btcAddr,
+		DepositID:        dn.Deposit.ID(),
+		Txid:             txid,
+		SkySent:          100e6,
+		CoinHourStrategy: string(sender.CoinHourStrategyShare),
+		AppliedRate:      testSkyBtcRate,
+		DepositValue:     dn.Deposit.Value,
+		BuyMethod:        config.BuyMethodDirect,
+		Status:           StatusWaitConfirm,
+		ConversionRate:   testSkyBtcRate,
+		Deposit:          dn.Deposit,
+	}, di)
+
+}
+
+func TestExchangeConfirmationTimeout(t *testing.T) {
+	// Tests that a deposit whose transaction is never confirmed is moved to StatusStuck
+	// after the confirmation timeout
+	log, hook := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	bscr := newDummyScanner()
+	escr := newDummyScanner()
+	multiplexer := scanner.NewMultiplexer(log)
+	err = multiplexer.AddScanner(bscr, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	err = multiplexer.AddScanner(escr, scanner.CoinTypeETH)
+	require.NoError(t, err)
+
+	go testutil.CheckError(t, multiplexer.Multiplex)
+
+	cfg := defaultCfg
+	cfg.ConfirmationTimeout = time.Hour
+	e, err := NewDirectExchange(log, cfg, store, multiplexer, newDummySender())
+	require.NoError(t, err)
+
+	// The clock is advanced past the confirmation timeout once the transaction is broadcast
+	var clockLock sync.Mutex
+	now := time.Now()
+	e.Sender.(*Send).now = func() time.Time {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		return now
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, e.store, skyAddr, btcAddr)
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	bscr.addDeposit(dn)
+
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	waitForStatus := func(status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(dn.Deposit.ID())
+				require.NoError(t, err)
+				if di.Status == status {
+					return di
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
+			}
+		}
+	}
+
+	// Confirmations never arrive, within the timeout the deposit waits for confirmation
+	waitForStatus(StatusWaitConfirm)
+	checkExchangerStatus(t, e, ErrNotConfirmed)
+
+	clockLock.Lock()
+	now = now.Add(time.Hour * 2)
+	clockLock.Unlock()
+
+	di := waitForStatus(StatusStuck)
+	require.Equal(t, ErrConfirmationTimeout.Error(), di.Error)
+	require.NotEmpty(t, di.Txid)
+	require.NotEmpty(t, di.SkySent)
+	require.NoError(t, di.ValidateForStatus())
+
+	// An alert is logged for operators
+	var alerted bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["alert"] == "stuck" {
+			alerted = true
+		}
+	}
+	require.True(t, alerted)
+
+	// The stuck deposit is no longer checked
+	require.Nil(t, e.Status())
+}
+
+func TestExchangeQuitBeforeConfirm(t *testing.T) {
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, e.store, skyAddr, btcAddr)
+
+	var value int64 = 1e8
+	skySent, err := CalculateBtcSkyValue(value, testSkyBtcRate, testMaxDecimals)
+	require.NoError(t, err)
+	txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, skyAddr, skySent)
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    value,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	// First loop calls saveIncomingDeposit
+	// nil is written to ErrC after this method finishes
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	// Second loop calls processWaitSendDeposit
+	// It sends the coins, then confirms them
+	expectedDeposit := DepositInfo{
+		SchemaVersion:    SchemaVersion,
+		Seq:              1,
+		CoinType:         scanner.CoinTypeBTC,
+		Status:           StatusWaitConfirm,
+		SkyAddress:       skyAddr,
+		DepositAddress:   dn.Deposit.Address,
+		DepositID:        dn.Deposit.ID(),
+		Txid:             txid,
+		SkySent:          100e6,
+		CoinHourStrategy: string(sender.CoinHourStrategyShare),
+		AppliedRate:      testSkyBtcRate,
+		BuyMethod:        config.BuyMethodDirect,
+		DepositValue:     dn.Deposit.Value,
+		ConversionRate:   testSkyBtcRate,
+		Deposit:          dn.Deposit,
+	}
+
+	// Periodically check the database until we observe the sent deposit
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range time.Tick(dbCheckWaitTime) {
+			di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+			require.NoError(t, err)
+
+			if di.Status != expectedDeposit.Status {
+				continue
+			}
+
+			require.NotEmpty(t, di.UpdatedAt)
+
+			ed := expectedDeposit
+			ed.UpdatedAt = di.UpdatedAt
+			ed.StatusUpdatedAt = di.StatusUpdatedAt
+			ed.CreatedAt = di.CreatedAt
+
+			require.Equal(t, ed, di)
+			return
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dbScanTimeout):
+		t.Fatal("Waiting for sent deposit timed out")
+	}
+
+	e.Shutdown()
+
+	di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+
+	require.NotEmpty(t, di.UpdatedAt)
+	ed := expectedDeposit
+	ed.UpdatedAt = di.UpdatedAt
+	ed.StatusUpdatedAt = di.StatusUpdatedAt
+	ed.CreatedAt = di.CreatedAt
+
+	require.Equal(t, ed, di)
+}
+
+func TestExchangeSendZeroCoins(t *testing.T) {
+	// Tests what happens when the scanner sends us an empty deposit value,
+	// or the deposit value is so small that it is worth less than 1 SKY after
+	// rate conversion.
+	// The scanner should never do this, but we must handle it in case it happens
+	e, shutdown, hook := runExchange(t)
+	defer shutdown()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, e.store, skyAddr, btcAddr)
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1, // The amount is so low that no SKY can be sent
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	// First loop calls saveIncomingDeposit
+	// nil is written to ErrC after this method finishes
+	err := <-dn.ErrC
+	require.NoError(t, err)
+
+	// Second loop calls processWaitSendDeposit
+	// It sends the coins, then confirms them
+
+	expectedDeposit := DepositInfo{
+		SchemaVersion:  SchemaVersion,
+		Seq:            1,
+		CoinType:       scanner.CoinTypeBTC,
+		Status:         StatusDone,
+		SkyAddress:     skyAddr,
+		DepositAddress: dn.Deposit.Address,
+		DepositID:      dn.Deposit.ID(),
+		Txid:           "",
+		SkySent:        0,
+		ConversionRate: testSkyBtcRate,
+		BuyMethod:      config.BuyMethodDirect,
+		DepositValue:   dn.Deposit.Value,
+		Deposit:        dn.Deposit,
+		Error:          ErrEmptySendAmount.Error(),
+	}
+
+	// Periodically check the database until we observe the sent deposit
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range time.Tick(dbCheckWaitTime) {
+			di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+			require.NoError(t, err)
+
+			if di.Status != expectedDeposit.Status {
+				continue
+			}
+
+			require.NotEmpty(t, di.UpdatedAt)
+
+			ed := expectedDeposit
+			ed.UpdatedAt = di.UpdatedAt
+			ed.StatusUpdatedAt = di.StatusUpdatedAt
+			ed.CreatedAt = di.CreatedAt
+
+			require.Equal(t, ed, di)
+			return
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dbScanTimeout):
+		t.Fatal("Waiting for sent deposit timed out")
+	}
+
+	e.Shutdown()
+
+	di, err := e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+
+	require.NotEmpty(t, di.UpdatedAt)
+	ed := expectedDeposit
+	ed.UpdatedAt = di.UpdatedAt
+	ed.StatusUpdatedAt = di.StatusUpdatedAt
+	ed.CreatedAt = di.CreatedAt
+
+	require.Equal(t, ed, di)
+
+	loggedErrEmptySendAmount := false
+	for _, e := range hook.AllEntries() {
+		err, ok := e.Data["error"].(error)
+		if ok && err == ErrEmptySendAmount {
+			loggedErrEmptySendAmount = true
+			break
+		}
+	}
+
+	require.True(t, loggedErrEmptySendAmount)
+}
+
+func TestExchangeZeroValueDeposit(t *testing.T) {
+	// Tests that a deposit with no value is marked invalid instead of being sent.
+	// The scanner should never do this, but we must handle it in case it happens
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, e.store, skyAddr, btcAddr)
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    0,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	err := <-dn.ErrC
+	require.NoError(t, err)
+
+	expectedDeposit := DepositInfo{
+		SchemaVersion:  SchemaVersion,
+		Seq:            1,
+		CoinType:       scanner.CoinTypeBTC,
+		Status:         StatusInvalid,
+		SkyAddress:     skyAddr,
+		DepositAddress: dn.Deposit.Address,
+		DepositID:      dn.Deposit.ID(),
+		ConversionRate: testSkyBtcRate,
+		BuyMethod:      config.BuyMethodDirect,
+		Deposit:        dn.Deposit,
+		Error:          ErrInvalidDepositValue.Error(),
+	}
+
+	var di DepositInfo
+	timeout := time.After(dbScanTimeout)
+loop:
+	for {
+		select {
+		case <-time.Tick(dbCheckWaitTime):
+			di, err = e.store.(*Store).GetDepositInfo(dn.Deposit.ID())
+			require.NoError(t, err)
+			if di.Status == StatusInvalid {
+				break loop
+			}
+		case <-timeout:
+			t.Fatal("Waiting for invalid deposit timed out")
+		}
+	}
+
+	require.NotEmpty(t, di.UpdatedAt)
+	expectedDeposit.UpdatedAt = di.UpdatedAt
+	expectedDeposit.StatusUpdatedAt = di.StatusUpdatedAt
+	expectedDeposit.CreatedAt = di.CreatedAt
+	require.Equal(t, expectedDeposit, di)
+	require.NoError(t, di.ValidateForStatus())
+
+	// Invalid deposits are not counted in the stats
+	stats, err := e.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, &DepositStats{}, stats)
+}
+
+func TestExchangeSingleUseAddresses(t *testing.T) {
+	// Tests that a deposit to a single use address that already has a completed deposit is not sent
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	cfg := defaultCfg
+	cfg.SingleUseAddresses = true
+	e := newTestExchangeWithConfig(t, log, store, cfg)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, skyAddr, btcAddr)
+
+	mp := e.Receiver.(*Receive).multiplexer
+	addDeposit := func(tx string) scanner.Deposit {
+		dn := scanner.DepositNote{
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Value:    1e8,
+				Height:   20,
+				Tx:       tx,
+				N:        2,
+			},
+			ErrC: make(chan error, 1),
+		}
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		err := <-dn.ErrC
+		require.NoError(t, err)
+		return dn.Deposit
+	}
+
+	waitForStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(depositID)
+				require.NoError(t, err)
+				if di.Status == status {
+					return di
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
+			}
+		}
+	}
+
+	// A second deposit before the first completes is sent
+	first := addDeposit("foo-tx")
+	second := addDeposit("bar-tx")
+
+	// The dummy sender creates the same transaction for both deposits, since they have the same amount
+	di := waitForStatus(first.ID(), StatusWaitConfirm)
+	e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(di.Txid)
+	waitForStatus(first.ID(), StatusDone)
+	waitForStatus(second.ID(), StatusDone)
+
+	// A deposit to the address after completion is set aside
+	third := addDeposit("baz-tx")
+
+	di, err = store.GetDepositInfo(third.ID())
+	require.NoError(t, err)
+	require.Equal(t, StatusUnexpectedDeposit, di.Status)
+	require.Equal(t, ErrDepositAddressReused.Error(), di.Error)
+	require.NoError(t, di.ValidateForStatus())
+
+	// It is never sent
+	time.Sleep(defaultCfg.TxConfirmationCheckWait * 2)
+	di, err = store.GetDepositInfo(third.ID())
+	require.NoError(t, err)
+	require.Equal(t, StatusUnexpectedDeposit, di.Status)
+	require.Empty(t, di.Txid)
+	require.Equal(t, uint64(0), di.SkySent)
+
+	// It is not counted in the stats
+	stats, err := e.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, int64(2e8), stats.TotalBTCReceived)
+}
+
+func TestExchangeMetrics(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	e := newTestExchangeWithConfig(t, log, store, defaultCfg)
+
+	registry := metrics.NewRegistry()
+	e.SetMetrics(registry)
+	e.SetMetricsTopAddresses(1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, skyAddr, btcAddr)
+
+	skySent, err := CalculateBtcSkyValue(1e8, testSkyBtcRate, testMaxDecimals)
+	require.NoError(t, err)
+	txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, skyAddr, skySent)
+	e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(txid)
+
+	mp := e.Receiver.(*Receive).multiplexer
+	for _, v := range []int64{1e8, 0} {
+		dn := scanner.DepositNote{
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Value:    v,
+				Height:   20,
+				Tx:       fmt.Sprintf("foo-tx-%d", v),
+				N:        2,
+			},
+			ErrC: make(chan error, 1),
+		}
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		err = <-dn.ErrC
+		require.NoError(t, err)
+	}
+
+	// Pausing is reported
+	err = e.Pause(context.Background())
+	require.NoError(t, err)
+	e.Resume()
+
+	expected := []string{
+		`teller_deposits_received_total{coin_type="BTC"} 2`,
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="foo-btc-addr"} 1e+08`,
+		`teller_deposits_set_aside_total{status="invalid"} 1`,
+		`teller_send_paused 0`,
+		`teller_sky_sent_droplets_total 1e+08`,
+		`teller_confirmation_seconds_count 1`,
+	}
+
+	timeout := time.After(dbScanTimeout)
+	for {
+		var buf bytes.Buffer
+		_, err := registry.WriteTo(&buf)
+		require.NoError(t, err)
+
+		var missing []string
+		for _, m := range expected {
+			if !strings.Contains(buf.String(), m+"\n") {
+				missing = append(missing, m)
+			}
+		}
+
+		if len(missing) == 0 {
+			break
+		}
+
+		select {
+		case <-time.After(statusCheckInterval):
+		case <-timeout:
+			t.Fatalf("Waiting for metrics timed out, missing %v in:\n%s", missing, buf.String())
+		}
+	}
+}
+
+func TestExchangeRounding(t *testing.T) {
+	// Test that the rounding applied to each sent deposit is recorded, and that a significant loss is reported
+	log, hook := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	cfg := defaultCfg
+	cfg.RoundingMode = config.RoundingNearest
+	e := newTestExchangeWithConfig(t, log, store, cfg)
+
+	registry := metrics.NewRegistry()
+	e.SetMetrics(registry)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
+
+	// 1.5 SKY is rounded up to 2 SKY, 1.2 SKY is rounded down to 1 SKY
+	deposits := map[string]int64{
+		"foo-btc-addr": 15e5,
+		"bar-btc-addr": 12e5,
+	}
+
+	dummySender := e.Sender.(*Send).sender.(*dummySender)
+	for _, skySent := range []uint64{2e6, 1e6} {
+		dummySender.setTxConfirmed(dummySender.predictTxid(t, testSkyAddr, skySent))
+	}
+
+	var ids []string
+	for btcAddr, v := range deposits {
+		mustBindAddress(t, store, testSkyAddr, btcAddr)
+
+		dn := scanner.DepositNote{
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Value:    v,
+				Height:   20,
+				Tx:       "tx-" + btcAddr,
+				N:        2,
+			},
+			ErrC: make(chan error, 1),
+		}
+		e.Receiver.(*Receive).multiplexer.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		require.NoError(t, <-dn.ErrC)
+		ids = append(ids, dn.Deposit.ID())
+	}
+
+	expected := []string{
+		`teller_rounding_droplets_total{direction="up"} 500000`,
+		`teller_rounding_droplets_total{direction="down"} 200000`,
+		`teller_rounding_loss_deposits_total{coin_type="BTC"} 1`,
+	}
+
+	timeout := time.After(dbScanTimeout)
+	for {
+		var buf bytes.Buffer
+		_, err := registry.WriteTo(&buf)
+		require.NoError(t, err)
+
+		var missing []string
+		for _, m := range expected {
+			if !strings.Contains(buf.String(), m+"\n") {
+				missing = append(missing, m)
+			}
+		}
+
+		if len(missing) == 0 {
+			break
+		}
+
+		select {
+		case <-time.After(statusCheckInterval):
+		case <-timeout:
+			t.Fatalf("Waiting for metrics timed out, missing %v in:\n%s", missing, buf.String())
+		}
+	}
+
+	rounding := make(map[string]int64)
+	for _, id := range ids {
+		di, err := store.GetDepositInfo(id)
+		require.NoError(t, err)
+		rounding[di.DepositAddress] = di.RoundingDroplets
+	}
+	require.Equal(t, map[string]int64{
+		"foo-btc-addr": 5e5,
+		"bar-btc-addr": -2e5,
+	}, rounding)
+
+	var warned bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && strings.HasPrefix(entry.Message, "Rounding to sky_exchanger.max_decimals") {
+			require.Equal(t, "tx-bar-btc-addr:2", entry.Data["depositID"])
+			warned = true
+		}
+	}
+	require.True(t, warned)
+}
+
+func TestExchangeDepositLatency(t *testing.T) {
+	// Test that the time a deposit spends in each status, and from being saved to done, is recorded
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	var clockLock sync.Mutex
+	now := time.Now()
+	store.now = func() time.Time {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		return now
+	}
+	advanceClock := func(d time.Duration) {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		now = now.Add(d)
+	}
+
+	cfg := defaultCfg
+	cfg.KYCThresholdSky = "100"
+	e := newTestExchangeWithConfig(t, log, store, cfg)
+
+	registry := metrics.NewRegistry()
+	e.SetMetrics(registry)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
+
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, testSkyAddr, btcAddr)
+
+	waitForStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(depositID)
+				require.NoError(t, err)
+				if di.Status == status {
+					return di
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
+			}
+		}
+	}
+
+	// The deposit is held for KYC, so that the clock can be advanced before it moves on
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    2e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	e.Receiver.(*Receive).multiplexer.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	di := waitForStatus(dn.Deposit.ID(), StatusKYCHold)
+	require.Equal(t, now.Unix(), di.CreatedAt)
+
+	advanceClock(time.Minute * 5)
+	_, err = e.ClearKYC(btcAddr, "alice")
+	require.NoError(t, err)
+
+	di = waitForStatus(dn.Deposit.ID(), StatusWaitConfirm)
+
+	advanceClock(time.Minute * 10)
+	e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(di.Txid)
+
+	di = waitForStatus(dn.Deposit.ID(), StatusDone)
+	require.Equal(t, now.Unix(), di.StatusUpdatedAt)
+
+	expected := []string{
+		`teller_deposit_processing_seconds_sum{coin_type="BTC"} 900`,
+		`teller_deposit_processing_seconds_count{coin_type="BTC"} 1`,
+		`teller_deposit_status_seconds_sum{status="waiting_decide"} 0`,
+		`teller_deposit_status_seconds_sum{status="waiting_send"} 0`,
+		`teller_deposit_status_seconds_count{status="waiting_send"} 2`,
+		`teller_deposit_status_seconds_sum{status="kyc_hold"} 300`,
+		`teller_deposit_status_seconds_sum{status="waiting_confirm"} 600`,
+	}
+
+	timeout := time.After(dbScanTimeout)
+	for {
+		var buf bytes.Buffer
+		_, err := registry.WriteTo(&buf)
+		require.NoError(t, err)
+
+		var missing []string
+		for _, m := range expected {
+			if !strings.Contains(buf.String(), m+"\n") {
+				missing = append(missing, m)
+			}
+		}
+
+		if len(missing) == 0 {
+			break
+		}
+
+		select {
+		case <-time.After(statusCheckInterval):
+		case <-timeout:
+			t.Fatalf("Waiting for metrics timed out, missing %v in:\n%s", missing, buf.String())
+		}
+	}
+}
+
+func TestExchangeDrainAndSnapshot(t *testing.T) {
+	// Test that no deposit is sent after DrainAndSnapshot until Resume,
+	// and that the snapshot is a usable database
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	store := e.store.(*Store)
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, skyAddr, btcAddr)
+
+	require.False(t, e.Paused())
+
+	var buf bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), dbScanTimeout)
+	defer cancel()
+	err := e.DrainAndSnapshot(ctx, &buf)
+	require.NoError(t, err)
+	require.True(t, e.Paused())
+
+	// Pausing again is a no-op
+	require.NoError(t, e.Pause(ctx))
+
+	f, err := ioutil.TempFile("", "teller-snapshot")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	snapshot, err := bolt.Open(f.Name(), 0600, nil)
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	log, _ := testutil.NewLogger(t)
+	snapshotStore, err := NewStore(log, snapshot)
+	require.NoError(t, err)
+	ba, err := snapshotStore.GetBindAddress(btcAddr, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.NotNil(t, ba)
+	require.Equal(t, skyAddr, ba.SkyAddress)
+
+	// A deposit received while paused is recorded but not sent
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	waitStatus := func(statuses ...Status) {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.Tick(dbCheckWaitTime):
+				di, err := store.GetDepositInfo(dn.Deposit.ID())
+				require.NoError(t, err)
+				for _, status := range statuses {
+					if di.Status == status {
+						return
+					}
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %v timed out", statuses)
+			}
+		}
+	}
+
+	waitStatus(StatusWaitSend)
+	time.Sleep(dbCheckWaitTime)
+	di, err := store.GetDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+
+	// The deposit is sent after Resume
+	e.Resume()
+	require.False(t, e.Paused())
+	waitStatus(StatusWaitConfirm, StatusDone)
+}
+
+func TestExchangePauseResume(t *testing.T) {
+	// Test that deposits received while sending is paused are recorded and moved to StatusWaitSend,
+	// but not sent until Resume, then they are sent in the order they were received
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	store := e.store.(*Store)
+
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, testSkyAddr, btcAddr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbScanTimeout)
+	defer cancel()
+	require.NoError(t, e.Pause(ctx))
+	require.True(t, e.Paused())
+
+	mp := e.Receiver.(*Receive).multiplexer
+	var depositIDs []string
+	// The deposit values differ so that each send has its own txid
+	for i := 0; i < 3; i++ {
+		dn := scanner.DepositNote{
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Value:    int64(i+1) * 1e8,
+				Height:   20,
+				Tx:       fmt.Sprintf("foo-tx-%d", i),
+				N:        2,
+			},
+			ErrC: make(chan error, 1),
+		}
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		require.NoError(t, <-dn.ErrC)
+		depositIDs = append(depositIDs, dn.Deposit.ID())
+	}
+
+	waitForStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(depositID)
+				require.NoError(t, err)
+				if di.Status == status {
+					return di
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
+			}
+		}
+	}
+
+	// The deposits accumulate in StatusWaitSend
+	for _, depositID := range depositIDs {
+		waitForStatus(depositID, StatusWaitSend)
+	}
+
+	time.Sleep(dbCheckWaitTime)
+	for _, depositID := range depositIDs {
+		di, err := store.GetDepositInfo(depositID)
+		require.NoError(t, err)
+		require.Equal(t, StatusWaitSend, di.Status)
+		require.Empty(t, di.Txid)
+	}
+
+	// After Resume, the deposits are sent one at a time in the order they were received
+	e.Resume()
+	require.False(t, e.Paused())
+
+	for i, depositID := range depositIDs {
+		di := waitForStatus(depositID, StatusWaitConfirm)
+		require.Equal(t, uint64(i+1)*100e6, di.SkySent)
+
+		for _, laterID := range depositIDs[i+1:] {
+			later, err := store.GetDepositInfo(laterID)
+			require.NoError(t, err)
+			require.Equal(t, StatusWaitSend, later.Status)
+		}
+
+		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(di.Txid)
+		waitForStatus(depositID, StatusDone)
+	}
+}
+
+func testExchangeRunProcessDepositBacklog(t *testing.T, dis []DepositInfo, configureSender func(*Exchange, DepositInfo)) {
+	log, _ := testutil.NewLogger(t)
+	e, run, shutdown := setupExchange(t, log)
+
+	// The clock is fixed, and is advanced once before the exchange runs,
+	// so that the deposits' timestamps don't depend on how long they take to process
+	created := time.Now().Truncate(time.Second)
+	processed := created.Add(time.Minute)
+	now := created
+	clock := func() time.Time {
+		return now
+	}
+	e.store.(*Store).now = clock
+	e.Sender.(*Send).now = clock
+
+	updatedDis := make([]DepositInfo, 0, len(dis))
+	for _, di := range dis {
+		err := di.ValidateForStatus()
+		require.NoError(t, err)
+		configureSender(e, di)
+
+		updatedDi, err := e.store.(*Store).addDepositInfo(di)
+		require.NoError(t, err)
+		updatedDis = append(updatedDis, updatedDi)
+	}
+
+	dis = updatedDis
+
+	filter := func(di DepositInfo) bool {
+		return di.Status == StatusDone
+	}
+
+	// Make sure that there are no confirmed deposits yet
+	confirmed, err := e.store.GetDepositInfoArray(filter)
+	require.NoError(t, err)
+	require.Len(t, confirmed, 0)
+
+	// Run the exchange
+	now = processed
+	go run()
+	defer shutdown()
+	defer e.Shutdown()
+
+	// Wait until we find 2 confirmed deposits
+	done := make(chan struct{})
+	complete := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-complete:
+				return
+			case <-time.After(dbCheckWaitTime):
+				confirmed, err := e.store.GetDepositInfoArray(filter)
+				require.NoError(t, err)
+				if len(confirmed) == len(dis) {
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dbScanTimeout):
+		close(complete)
+		t.Fatal("Waiting for confirmed deposits timed out")
+	}
+
+	confirmed, err = e.store.GetDepositInfoArray(filter)
+	require.NoError(t, err)
+	require.Len(t, confirmed, len(dis))
+
+	// Verify the 2 confirmed deposits
+	expectedDis := make([]DepositInfo, len(dis))
+	for i, di := range dis {
+		expectedDis[i] = di
+		expectedDis[i].Status = StatusDone
+
+		if expectedDis[i].SkySent == 0 {
+			t.Logf("di.DepositValue=%d e.cfg.SkyBtcExchangeRate=%s", di.DepositValue, e.cfg.SkyBtcExchangeRate)
+			amt, err := CalculateBtcSkyValue(di.DepositValue, e.cfg.SkyBtcExchangeRate, testMaxDecimals)
+			require.NoError(t, err)
+			expectedDis[i].SkySent = amt
+			expectedDis[i].CoinHourStrategy = string(sender.CoinHourStrategyShare)
+			expectedDis[i].AppliedRate = e.cfg.SkyBtcExchangeRate
+		}
+
+		require.Equal(t, created.Unix(), di.StatusUpdatedAt)
+		expectedDis[i].UpdatedAt = processed.Unix()
+		expectedDis[i].StatusUpdatedAt = processed.Unix()
+
+		require.Equal(t, expectedDis[i], confirmed[i])
+	}
+}
+
+func TestExchangeProcessUnconfirmedTx(t *testing.T) {
+	// Tests that StatusWaitConfirm deposits found in the db are processed
+	// on exchange startup.
+
+	var depositValue int64 = 1e8
+	s := newDummySender()
+	skySent, err := CalculateBtcSkyValue(depositValue, testSkyBtcRate, testMaxDecimals)
+	require.NoError(t, err)
+	txid1 := s.predictTxid(t, testSkyAddr, skySent)
+	txid2 := s.predictTxid(t, testSkyAddr2, skySent)
+
+	// Add StatusWaitConfirm deposits
+	// They should all be confirmed after shutdown
+	dis := []DepositInfo{
+		{
+			Seq:            1,
+			Status:         StatusWaitConfirm,
+			SkyAddress:     testSkyAddr,
+			DepositAddress: "foo-btc-addr-1",
+			DepositID:      "foo-tx-1:1",
+			Txid:           txid1,
+			SkySent:        skySent,
+			ConversionRate: testSkyBtcRate,
+			BuyMethod:      config.BuyMethodDirect,
+			DepositValue:   depositValue,
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  "foo-btc-addr-1",
+				Value:    depositValue,
+				Height:   20,
+				Tx:       "foo-tx-1",
+				N:        1,
+			},
+		},
+		{
+			Seq:            2,
+			Status:         StatusWaitConfirm,
+			SkyAddress:     testSkyAddr2,
+			DepositAddress: "foo-btc-addr-2",
+			DepositID:      "foo-tx-2:2",
+			Txid:           txid2,
+			SkySent:        skySent,
+			ConversionRate: testSkyBtcRate,
+			BuyMethod:      config.BuyMethodDirect,
+			DepositValue:   depositValue,
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  "foo-btc-addr-2",
+				Value:    depositValue,
+				Height:   20,
+				Tx:       "foo-tx-2",
+				N:        2,
+			},
+		},
+	}
+
+	testExchangeRunProcessDepositBacklog(t, dis, func(e *Exchange, di DepositInfo) {
+		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(di.Txid)
+	})
+}
+
+func TestExchangeProcessWaitSendDeposits(t *testing.T) {
+	// Tests that StatusWaitSend deposits found in the db are processed
+	// on exchange startup
+
+	var depositValue int64 = 1e8
+	s := newDummySender()
+	skySent, err := CalculateBtcSkyValue(depositValue, testSkyBtcRate, testMaxDecimals)
+	require.NoError(t, err)
+	txid1 := s.predictTxid(t, testSkyAddr, skySent)
+	txid2 := s.predictTxid(t, testSkyAddr2, skySent)
+
+	// Add StatusWaitSend deposits
+	// They should all be confirmed after shutdown
+	dis := []DepositInfo{
+		{
+			Seq:            1,
+			CoinType:       scanner.CoinTypeBTC,
+			Status:         StatusWaitSend,
+			SkyAddress:     testSkyAddr,
+			DepositAddress: "foo-btc-addr-1",
+			DepositID:      "foo-tx-1:1",
+			Txid:           txid1,
+			ConversionRate: testSkyBtcRate,
+			DepositValue:   depositValue,
+			BuyMethod:      config.BuyMethodDirect,
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  "foo-btc-addr-1",
+				Value:    depositValue,
+				Height:   20,
+				Tx:       "foo-tx-1",
+				N:        1,
+			},
+		},
+		{
+			Seq:            2,
+			CoinType:       scanner.CoinTypeBTC,
+			Status:         StatusWaitSend,
+			SkyAddress:     testSkyAddr2,
+			DepositAddress: "foo-btc-addr-2",
+			DepositID:      "foo-tx-2:2",
+			Txid:           txid2,
+			ConversionRate: testSkyBtcRate,
+			DepositValue:   depositValue,
+			BuyMethod:      config.BuyMethodDirect,
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  "foo-btc-addr-2",
+				Value:    depositValue,
+				Height:   20,
+				Tx:       "foo-tx-2",
+				N:        2,
+			},
+		},
+	}
+
+	testExchangeRunProcessDepositBacklog(t, dis, func(e *Exchange, di DepositInfo) {
+		boundAddr, err := e.store.BindAddress(di.SkyAddress, di.DepositAddress, di.CoinType, di.BuyMethod)
+		require.NoError(t, err)
+		require.Equal(t, di.SkyAddress, boundAddr.SkyAddress)
+		require.Equal(t, di.DepositAddress, boundAddr.Address)
+		require.Equal(t, di.CoinType, boundAddr.CoinType)
+		require.Equal(t, di.BuyMethod, boundAddr.BuyMethod)
+
+		skySent, err := CalculateBtcSkyValue(di.DepositValue, di.ConversionRate, testMaxDecimals)
+		require.NoError(t, err)
+
+		txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, di.SkyAddress, skySent)
+		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(txid)
+	})
+}
+
+func TestExchangeProcessWaitDecideDeposits(t *testing.T) {
+	// Tests that StatusWaitDecide deposits found in the db are processed
+	// on exchange startup
+
+	var depositValue int64 = 1e8
+	s := newDummySender()
+	skySent, err := CalculateBtcSkyValue(depositValue, testSkyBtcRate, testMaxDecimals)
+	require.NoError(t, err)
+	txid1 := s.predictTxid(t, testSkyAddr, skySent)
+	txid2 := s.predictTxid(t, testSkyAddr2, skySent)
+
+	// Add StatusWaitDecide deposits
+	// They should all be confirmed after shutdown
+	dis := []DepositInfo{
+		{
+			Seq:            1,
+			CoinType:       scanner.CoinTypeBTC,
+			Status:         StatusWaitDecide,
+			SkyAddress:     testSkyAddr,
+			DepositAddress: "foo-btc-addr-1",
+			DepositID:      "foo-tx-1:1",
+			Txid:           txid1,
+			ConversionRate: testSkyBtcRate,
+			DepositValue:   depositValue,
+			BuyMethod:      config.BuyMethodDirect,
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  "foo-btc-addr-1",
+				Value:    depositValue,
+				Height:   20,
+				Tx:       "foo-tx-1",
+				N:        1,
+			},
+		},
+		{
+			Seq:            2,
+			CoinType:       scanner.CoinTypeBTC,
+			Status:         StatusWaitDecide,
+			SkyAddress:     testSkyAddr2,
+			DepositAddress: "foo-btc-addr-2",
+			DepositID:      "foo-tx-2:2",
+			Txid:           txid2,
+			ConversionRate: testSkyBtcRate,
+			DepositValue:   depositValue,
+			BuyMethod:      config.BuyMethodDirect,
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  "foo-btc-addr-2",
+				Value:    depositValue,
+				Height:   20,
+				Tx:       "foo-tx-2",
+				N:        2,
+			},
+		},
+	}
+
+	testExchangeRunProcessDepositBacklog(t, dis, func(e *Exchange, di DepositInfo) {
+		boundAddr, err := e.store.BindAddress(di.SkyAddress, di.DepositAddress, di.CoinType, di.BuyMethod)
+		require.NoError(t, err)
+		require.Equal(t, di.SkyAddress, boundAddr.SkyAddress)
+		require.Equal(t, di.DepositAddress, boundAddr.Address)
+		require.Equal(t, di.CoinType, boundAddr.CoinType)
+		require.Equal(t, di.BuyMethod, boundAddr.BuyMethod)
+
+		skySent, err := CalculateBtcSkyValue(di.DepositValue, di.ConversionRate, testMaxDecimals)
+		require.NoError(t, err)
+
+		txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, di.SkyAddress, skySent)
+		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(txid)
+	})
+}
+
+func TestExchangeSaveIncomingDepositCreateDepositFailed(t *testing.T) {
+	// Tests that we log a message and continue if saveIncomingDeposit fails
+	e, shutdown, hook := runExchangeMockStore(t)
+	defer shutdown()
+	didShutdown := false
+	defer func() {
+		if !didShutdown {
+			e.Shutdown()
+		}
+	}()
+
+	btcAddr := "foo-btc-addr"
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	// Configure database mocks
+
+	// GetDepositInfoArray is called twice on startup
+	e.store.(*MockStore).On("GetDepositInfoArray", mock.MatchedBy(func(filt DepositFilter) bool {
+		return true
+	})).Return(nil, nil).Times(4)
+
+	// Return error on GetOrCreateDepositInfo
+	createDepositErr := errors.New("GetOrCreateDepositInfo failed")
+	e.store.(*MockStore).On("GetOrCreateDepositInfo", dn.Deposit, testSkyBtcRate, "").Return(DepositInfo{}, createDepositErr)
+
+	// First loop calls saveIncomingDeposit
+	// err is written to ErrC after this method finishes
+	err := <-dn.ErrC
+	require.Error(t, err)
+	require.Equal(t, createDepositErr, err)
+
+	// Check that we logged the failed save, so that we can recover it later
+	logEntry := hook.LastEntry()
+	require.Equal(t, logEntry.Message, "saveIncomingDeposit failed. This deposit will not be reprocessed until teller is restarted.")
+	loggedDeposit := logEntry.Data["deposit"].(scanner.Deposit)
+	require.Equal(t, dn.Deposit, loggedDeposit)
+}
+
+func TestExchangeProcessWaitSendDepositFailed(t *testing.T) {
+	// Tests that we log a message and continue if processWaitSendDeposit fails
+	e, shutdown, hook := runExchangeMockStore(t)
+	defer shutdown()
+	didShutdown := false
+	defer func() {
+		if !didShutdown {
+			e.Shutdown()
+		}
+	}()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	// Configure database mocks
+
+	// GetDepositInfoArray is called twice on startup
+	e.store.(*MockStore).On("GetDepositInfoArray", mock.MatchedBy(func(filt DepositFilter) bool {
+		return true
+	})).Return(nil, nil).Times(4)
+
+	// GetBindAddress returns a bound address
+	e.store.(*MockStore).On("GetBindAddress", btcAddr).Return(skyAddr, nil)
+
+	// GetOrCreateDepositInfo returns a valid DepositInfo
+	di := DepositInfo{
+		Seq:            1,
+		CoinType:       scanner.CoinTypeBTC,
+		Status:         StatusWaitSend,
+		SkyAddress:     skyAddr,
+		DepositAddress: btcAddr,
+		DepositID:      dn.Deposit.ID(),
+		BuyMethod:      config.BuyMethodDirect,
+		ConversionRate: testSkyBtcRate,
+		Deposit:        dn.Deposit,
+	}
+	e.store.(*MockStore).On("GetOrCreateDepositInfo", dn.Deposit, testSkyBtcRate, "").Return(di, nil)
+
+	// UpdateDepositInfo fails
+	updateDepositInfoErr := errors.New("UpdateDepositInfo error")
+	e.store.(*MockStore).On("UpdateDepositInfo", di.DepositID, mock.MatchedBy(func(f func(DepositInfo) DepositInfo) bool {
+		return true
+	})).Return(DepositInfo{}, updateDepositInfoErr)
+
+	// The failed deposit is added to the dead letter store
+	e.store.(*MockStore).On("AddDeadLetter", di, updateDepositInfoErr.Error()).Return(DeadLetter{
+		DepositID:   di.DepositID,
+		Reason:      updateDepositInfoErr.Error(),
+		Attempts:    1,
+		Pending:     true,
+		DepositInfo: di,
+	}, nil)
+
+	// First loop calls saveIncomingDeposit
+	// nil is written to ErrC after this method finishes
+	err := <-dn.ErrC
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range time.Tick(dbCheckWaitTime) {
+			for _, e := range hook.AllEntries() {
+				if strings.Contains(e.Message, "updateStatus failed") {
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dbScanTimeout):
+		t.Fatal("Waiting for deposit send failure timed out")
+	}
+
+	didShutdown = true
+	e.Shutdown()
+
+	foundMsg := false
+	for _, e := range hook.AllEntries() {
+		if !strings.Contains(e.Message, "updateStatus failed") {
+			continue
+		}
+		foundMsg = true
+		require.Equal(t, "updateStatus failed. This deposit will not be reprocessed until teller is restarted.", e.Message)
+		loggedDepositInfo, ok := e.Data["depositInfo"].(DepositInfo)
+		require.True(t, ok)
+		require.Equal(t, di, loggedDepositInfo)
+	}
+
+	require.True(t, foundMsg)
+}
+
+func TestExchangeProcessWaitSendNoSkyAddrBound(t *testing.T) {
+	// Tests that we log a message and continue if processWaitSendDeposit fails
+	e, shutdown, hook := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	btcAddr := "foo-btc-addr"
+
+	dn := scanner.DepositNote{
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Value:    1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.Receiver.(*Receive).multiplexer
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	// First loop calls saveIncomingDeposit
+	// nil is written to ErrC after this method finishes
+	err := <-dn.ErrC
+	require.Error(t, err)
+	require.Equal(t, err, ErrNoBoundAddress)
+
+	// Check that we logged the failed save, so that we can recover it later
+	logEntry := hook.LastEntry()
+	require.Equal(t, logEntry.Message, "saveIncomingDeposit failed. This deposit will not be reprocessed until teller is restarted.")
+	loggedDeposit := logEntry.Data["deposit"].(scanner.Deposit)
+	require.Equal(t, dn.Deposit, loggedDeposit)
+}
+
+func TestExchangeBindAddress(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+	dummyScanner := newDummyScanner()
+	multiplexer := scanner.NewMultiplexer(log)
+	err = multiplexer.AddScanner(dummyScanner, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+
+	s, err := NewDirectExchange(log, defaultCfg, store, multiplexer, nil)
+	require.NoError(t, err)
+
+	require.Len(t, dummyScanner.addrs, 0)
+
+	boundAddr, err := s.BindAddress("a", "b", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "a", boundAddr.SkyAddress)
+	require.Equal(t, "b", boundAddr.Address)
+
+	// Should be added to dummyScanner
+	require.Len(t, dummyScanner.addrs, 1)
+	require.Equal(t, "b", dummyScanner.addrs[0])
+
+	// Should be in the store
+	skyAddr, err := s.store.GetBindAddress("b", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, &BoundAddress{
+		SkyAddress: "a",
+		Address:    "b",
+		CoinType:   scanner.CoinTypeBTC,
+		BuyMethod:  config.BuyMethodDirect,
+	}, skyAddr)
+}
+
+func TestExchangeBindAddresses(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+	dummyScanner := newDummyScanner()
+	multiplexer := scanner.NewMultiplexer(log)
+	err = multiplexer.AddScanner(dummyScanner, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+
+	s, err := NewDirectExchange(log, defaultCfg, store, multiplexer, nil)
+	require.NoError(t, err)
+
+	boundAddrs, err := s.BindAddresses("a", []string{"b", "c"}, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Len(t, boundAddrs, 2)
+
+	// Should be added to dummyScanner
+	require.Equal(t, []string{"b", "c"}, dummyScanner.addrs)
+
+	num, err := s.GetBindNum("a")
+	require.NoError(t, err)
+	require.Equal(t, 2, num)
+
+	// A batch containing a bound address fails without touching the scanner
+	_, err = s.BindAddresses("a", []string{"d", "b"}, scanner.CoinTypeBTC)
+	require.Equal(t, ErrAddressAlreadyBound, err)
+	require.Equal(t, []string{"b", "c"}, dummyScanner.addrs)
+}
+
+func TestExchangeCheckScanDrift(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	btcScanner := newDummyScanner()
+	ethScanner := newDummyScanner()
+	multiplexer := scanner.NewMultiplexer(log)
+	err = multiplexer.AddScanner(btcScanner, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	err = multiplexer.AddScanner(ethScanner, scanner.CoinTypeETH)
+	require.NoError(t, err)
+
+	e, err := NewDirectExchange(log, defaultCfg, store, multiplexer, nil)
+	require.NoError(t, err)
+
+	registry := metrics.NewRegistry()
+	e.SetMetrics(registry)
+
+	// b1 and b3 are bound, but the BTC scanner only watches b3 and the unbound b2.
+	// The ETH bindings and scanner agree
+	_, err = store.BindAddresses(testSkyAddr, []string{"b1", "b3"}, scanner.CoinTypeBTC, config.BuyMethodDirect)
+	require.NoError(t, err)
+	_, err = store.BindAddress(testSkyAddr, "e1", scanner.CoinTypeETH, config.BuyMethodDirect)
+	require.NoError(t, err)
+	btcScanner.addrs = []string{"b2", "b3"}
+	ethScanner.addrs = []string{"e1"}
+
+	drifts, err := e.CheckScanDrift()
+	require.NoError(t, err)
+	require.Equal(t, []ScanDrift{
+		{
+			CoinType:  scanner.CoinTypeBTC,
+			Unwatched: []string{"b1"},
+			Unbound:   []string{"b2"},
+		},
+	}, drifts)
+
+	// The unwatched address is added to the scanner, the unbound address is left
+	require.Equal(t, []string{"b2", "b3", "b1"}, btcScanner.addrs)
+	require.Equal(t, []string{"e1"}, ethScanner.addrs)
+
+	var buf bytes.Buffer
+	_, err = registry.WriteTo(&buf)
+	require.NoError(t, err)
+	for _, m := range []string{
+		`teller_scan_address_drift{coin_type="BTC",kind="unwatched"} 1`,
+		`teller_scan_address_drift{coin_type="BTC",kind="unbound"} 1`,
+		`teller_scan_address_drift{coin_type="ETH",kind="unwatched"} 0`,
+		`teller_scan_address_drift{coin_type="ETH",kind="unbound"} 0`,
+	} {
+		require.Contains(t, buf.String(), m+"\n")
+	}
+
+	// Once repaired, only the unbound address is reported
+	drifts, err = e.CheckScanDrift()
+	require.NoError(t, err)
+	require.Equal(t, []ScanDrift{
+		{
+			CoinType: scanner.CoinTypeBTC,
+			Unbound:  []string{"b2"},
+		},
+	}, drifts)
+	require.Equal(t, []string{"b2", "b3", "b1"}, btcScanner.addrs)
+
+	buf.Reset()
+	_, err = registry.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `teller_scan_address_drift{coin_type="BTC",kind="unwatched"} 0`+"\n")
+}
+
+func TestExchangeCreateTransaction(t *testing.T) {
+	cfg := defaultCfg
+	cfg.SkyBtcExchangeRate = "111"
+
+	log, _ := testutil.NewLogger(t)
+	s, err := NewDirectExchange(log, cfg, nil, nil, newDummySender())
+	require.NoError(t, err)
+
+	// Create transaction with no SkyAddress
+	di := DepositInfo{
+		CoinType:       scanner.CoinTypeBTC,
+		SkyAddress:     "",
+		DepositValue:   1e8,
+		ConversionRate: "100",
+	}
+
+	_, err = s.Sender.(*Send).createTransaction(di, sender.SendOption{})
+	require.Equal(t, ErrNoBoundAddress, err)
+
+	// Create transaction with no coins sent, due to a very low DepositValue
+	di = DepositInfo{
+		CoinType:       scanner.CoinTypeBTC,
+		SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
+		DepositValue:   1,
+		ConversionRate: "100",
+	}
+	_, err = s.Sender.(*Send).createTransaction(di, sender.SendOption{})
+	require.Equal(t, ErrEmptySendAmount, err)
+
+	// Create valid transaction
+	di = DepositInfo{
+		CoinType:       scanner.CoinTypeBTC,
+		SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
+		DepositValue:   1e8,
+		ConversionRate: "100",
+	}
+	// Make sure DepositInfo.ConversionRate != s.cfg.SkyBtcExchangeRate, to confirm
+	// that the DepositInfo's ConversionRate is used instead of cfg.SkyBtcExchangeRate
+	require.NotEqual(t, s.cfg.SkyBtcExchangeRate, di.ConversionRate)
+
+	tx, err := s.Sender.(*Send).createTransaction(di, sender.SendOption{})
+	require.NoError(t, err)
+	// Should have one output for destination and one for change
+	require.Len(t, tx.Out, 2)
+
+	// Check that the coins sent are based upon the DepositValue.ConversionRate
+	var txOut *coin.TransactionOutput
+	for _, o := range tx.Out {
+		if o.Address.String() == di.SkyAddress {
+			txOut = &o
+			break
+		}
+	}
+	require.NotNil(t, txOut)
+	require.Equal(t, uint64(100e6), txOut.Coins)
+}
+
+func TestSendCoinHourStrategy(t *testing.T) {
+	cases := []struct {
+		name     string
+		cfg      string
+		f        CoinHourStrategyFunc
+		strategy sender.CoinHourStrategy
+		err      error
+	}{
+		{
+			name:     "default",
+			strategy: sender.CoinHourStrategyShare,
+		},
+		{
+			name:     "configured",
+			cfg:      "burn",
+			strategy: sender.CoinHourStrategyBurn,
+		},
+		{
+			name: "per deposit",
+			cfg:  "burn",
+			f: func(di DepositInfo) sender.CoinHourStrategy {
+				return sender.CoinHourStrategyMinimal
+			},
+			strategy: sender.CoinHourStrategyMinimal,
+		},
+		{
+			name: "per deposit falls back to configured",
+			cfg:  "burn",
+			f: func(di DepositInfo) sender.CoinHourStrategy {
+				return ""
+			},
+			strategy: sender.CoinHourStrategyBurn,
+		},
+		{
+			name: "per deposit invalid",
+			f: func(di DepositInfo) sender.CoinHourStrategy {
+				return "all"
+			},
+			err: sender.ErrInvalidCoinHourStrategy,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, shutdown := newTestStore(t)
+			defer shutdown()
+
+			cfg := defaultCfg
+			cfg.CoinHourStrategy = tc.cfg
+
+			log, _ := testutil.NewLogger(t)
+			ds := newDummySender()
+			e, err := NewDirectExchange(log, cfg, store, nil, ds)
+			require.NoError(t, err)
+			e.SetCoinHourStrategy(tc.f)
+
+			di, err := store.addDepositInfo(DepositInfo{
+				Status:         StatusWaitSend,
+				CoinType:       scanner.CoinTypeBTC,
+				SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
+				BuyMethod:      config.BuyMethodDirect,
+				DepositAddress: "foo-btc-addr",
+				DepositID:      "foo-tx:1",
+				DepositValue:   1e8,
+				ConversionRate: "100",
+			})
+			require.NoError(t, err)
+
+			di, err = e.Sender.(*Send).handleDepositInfoState(di)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				require.Equal(t, StatusWaitSend, di.Status)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, StatusWaitConfirm, di.Status)
+			require.Equal(t, tc.strategy, ds.lastOption.CoinHourStrategy)
+			require.Equal(t, string(tc.strategy), di.CoinHourStrategy)
+
+			// The strategy is saved with the deposit
+			di, err = store.GetDepositInfo(di.DepositID)
+			require.NoError(t, err)
+			require.Equal(t, string(tc.strategy), di.CoinHourStrategy)
+		})
+	}
+}
+
+func TestSendMemo(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  string
+		f    MemoFunc
+		memo string
+		err  error
+	}{
+		{
+			name: "no memo",
+		},
+		{
+			name: "configured",
+			cfg:  "order-{deposit_id}",
+			memo: "order-foo-tx:1",
+		},
+		{
+			name: "per deposit",
+			cfg:  "order-{deposit_id}",
+			f: func(di DepositInfo) string {
+				return "id-" + di.DepositAddress
 			},
+			memo: "id-foo-btc-addr",
 		},
 		{
-			Seq:            2,
-			Status:         StatusWaitConfirm,
-			SkyAddress:     testSkyAddr2,
-			DepositAddress: "foo-btc-addr-2",
-			DepositID:      "foo-tx-2:2",
-			Txid:           txid2,
-			SkySent:        skySent,
-			ConversionRate: testSkyBtcRate,
+			name: "too long",
+			f: func(di DepositInfo) string {
+				return strings.Repeat("m", dummyMaxMemoLength+1)
+			},
+			err: sender.ErrMemoTooLong,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, shutdown := newTestStore(t)
+			defer shutdown()
+
+			cfg := defaultCfg
+			cfg.SendMemo = tc.cfg
+
+			log, _ := testutil.NewLogger(t)
+			ds := newDummySender()
+			e, err := NewDirectExchange(log, cfg, store, nil, ds)
+			require.NoError(t, err)
+			if tc.f != nil {
+				e.SetMemo(tc.f)
+			}
+
+			di, err := store.addDepositInfo(DepositInfo{
+				Status:         StatusWaitSend,
+				CoinType:       scanner.CoinTypeBTC,
+				SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
+				BuyMethod:      config.BuyMethodDirect,
+				DepositAddress: "foo-btc-addr",
+				DepositID:      "foo-tx:1",
+				DepositValue:   1e8,
+				ConversionRate: "100",
+			})
+			require.NoError(t, err)
+
+			di, err = e.Sender.(*Send).handleDepositInfoState(di)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				require.Equal(t, StatusWaitSend, di.Status)
+				return
+			}
+
+			// The memo reaches the sender unchanged, and is saved with the deposit
+			require.NoError(t, err)
+			require.Equal(t, StatusWaitConfirm, di.Status)
+			require.Equal(t, tc.memo, ds.lastOption.Memo)
+
+			di, err = store.GetDepositInfo(di.DepositID)
+			require.NoError(t, err)
+			require.Equal(t, tc.memo, di.Memo)
+		})
+	}
+}
+
+func TestSendMemoUnsupported(t *testing.T) {
+	store, shutdown := newTestStore(t)
+	defer shutdown()
+
+	cfg := defaultCfg
+	cfg.SendMemo = "order-{deposit_id}"
+
+	log, _ := testutil.NewLogger(t)
+	_, err := NewSend(log, cfg, store, &sender.RetrySender{}, nil)
+	require.Equal(t, ErrMemoUnsupported, err)
+}
+
+// dummyReceiver is a Receiver that emits the deposits written to its deposits channel
+type dummyReceiver struct {
+	deposits chan DepositInfo
+}
+
+func (r *dummyReceiver) Deposits() <-chan DepositInfo {
+	return r.deposits
+}
+
+func (r *dummyReceiver) BindAddress(skyAddr, depositAddr, coinType, buyMethod string) (*BoundAddress, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (r *dummyReceiver) BindAddresses(skyAddr string, depositAddrs []string, coinType, buyMethod string) ([]BoundAddress, error) {
+	return nil, errors.New("not implemented")
+}
+
+// mergeWindows records the merge windows opened by a DirectBuy, and closes them when the test decides
+type mergeWindows struct {
+	sync.Mutex
+	durations []time.Duration
+	closers   []func()
+}
+
+func (w *mergeWindows) open(d time.Duration, f func()) {
+	w.Lock()
+	defer w.Unlock()
+	w.durations = append(w.durations, d)
+	w.closers = append(w.closers, f)
+}
+
+// waitOpened waits until n merge windows were opened
+func (w *mergeWindows) waitOpened(t *testing.T, n int) {
+	timeout := time.After(5 * time.Second)
+	for {
+		w.Lock()
+		opened := len(w.closers)
+		w.Unlock()
+
+		if opened >= n {
+			require.Equal(t, n, opened)
+			return
+		}
+
+		select {
+		case <-time.After(dbCheckWaitTime):
+		case <-timeout:
+			t.Fatalf("Waiting for %d merge windows timed out", n)
+		}
+	}
+}
+
+// close closes the i-th merge window
+func (w *mergeWindows) close(i int) {
+	w.Lock()
+	f := w.closers[i]
+	w.Unlock()
+	f()
+}
+
+func TestDirectBuyMergeWindow(t *testing.T) {
+	mergeWindow := time.Minute
+
+	setup := func(t *testing.T) (*Store, *dummyReceiver, *DirectBuy, *mergeWindows, func()) {
+		store, shutdownStore := newTestStore(t)
+
+		cfg := defaultCfg
+		cfg.MergeWindow = mergeWindow
+
+		log, _ := testutil.NewLogger(t)
+		r := &dummyReceiver{
+			deposits: make(chan DepositInfo),
+		}
+		p, err := NewDirectBuy(log, cfg, store, r)
+		require.NoError(t, err)
+
+		// Merge windows close when the test closes them
+		windows := &mergeWindows{}
+		p.afterFunc = windows.open
+
+		go testutil.CheckError(t, p.Run)
+
+		return store, r, p, windows, func() {
+			p.Shutdown()
+			shutdownStore()
+		}
+	}
+
+	addDeposit := func(t *testing.T, store *Store, depositID, depositAddr string) DepositInfo {
+		di, err := store.addDepositInfo(DepositInfo{
+			Status:         StatusWaitDecide,
+			CoinType:       scanner.CoinTypeBTC,
+			SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
 			BuyMethod:      config.BuyMethodDirect,
-			DepositValue:   depositValue,
-			Deposit: scanner.Deposit{
-				CoinType: scanner.CoinTypeBTC,
-				Address:  "foo-btc-addr-2",
-				Value:    depositValue,
-				Height:   20,
-				Tx:       "foo-tx-2",
-				N:        2,
+			DepositAddress: depositAddr,
+			DepositID:      depositID,
+			DepositValue:   1e8,
+			ConversionRate: "100",
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	receive := func(t *testing.T, p *DirectBuy) DepositInfo {
+		select {
+		case di := <-p.Deposits():
+			return di
+		case <-time.After(5 * time.Second):
+			t.Fatal("Waiting for processed deposit timed out")
+			return DepositInfo{}
+		}
+	}
+
+	requireNoDeposit := func(t *testing.T, p *DirectBuy) {
+		select {
+		case di := <-p.Deposits():
+			t.Fatalf("Unexpected deposit %s before its merge window closed", di.DepositID)
+		default:
+		}
+	}
+
+	t.Run("in window", func(t *testing.T) {
+		store, r, p, windows, shutdown := setup(t)
+		defer shutdown()
+
+		di1 := addDeposit(t, store, "btx1:1", "btcaddr1")
+		di2 := addDeposit(t, store, "btx2:1", "btcaddr1")
+		di3 := addDeposit(t, store, "btx3:1", "btcaddr2")
+
+		r.deposits <- di1
+		r.deposits <- di2
+		r.deposits <- di3
+
+		// The first deposit to each deposit address opens its window, the second deposit to btcaddr1 joins it
+		windows.waitOpened(t, 2)
+		require.Equal(t, []time.Duration{mergeWindow, mergeWindow}, windows.durations)
+		requireNoDeposit(t, p)
+
+		windows.close(0)
+		windows.close(1)
+
+		// The deposits to btcaddr1 are merged into the first, the deposit to btcaddr2 is sent on its own
+		processed := map[string]DepositInfo{}
+		for i := 0; i < 2; i++ {
+			di := receive(t, p)
+			processed[di.DepositID] = di
+		}
+
+		require.Len(t, processed, 2)
+		require.Equal(t, []string{di2.DepositID}, processed[di1.DepositID].MergedDeposits)
+		require.Equal(t, StatusWaitSend, processed[di1.DepositID].Status)
+		require.Empty(t, processed[di3.DepositID].MergedDeposits)
+		require.Equal(t, StatusWaitSend, processed[di3.DepositID].Status)
+
+		di2, err := store.GetDepositInfo(di2.DepositID)
+		require.NoError(t, err)
+		require.Equal(t, di1.DepositID, di2.MergedInto)
+		require.Equal(t, StatusWaitSend, di2.Status)
+	})
+
+	t.Run("out of window", func(t *testing.T) {
+		store, r, p, windows, shutdown := setup(t)
+		defer shutdown()
+
+		di1 := addDeposit(t, store, "btx1:1", "btcaddr1")
+		di2 := addDeposit(t, store, "btx2:1", "btcaddr1")
+
+		// The deposit is held until its window closes
+		r.deposits <- di1
+		windows.waitOpened(t, 1)
+		requireNoDeposit(t, p)
+
+		windows.close(0)
+		processed1 := receive(t, p)
+
+		// The merge window closed, the second deposit opens a new one and is sent on its own
+		r.deposits <- di2
+		windows.waitOpened(t, 2)
+		windows.close(1)
+		processed2 := receive(t, p)
+
+		require.Equal(t, di1.DepositID, processed1.DepositID)
+		require.Empty(t, processed1.MergedDeposits)
+		require.Equal(t, di2.DepositID, processed2.DepositID)
+		require.Empty(t, processed2.MergedDeposits)
+		require.Empty(t, processed2.MergedInto)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		store, shutdownStore := newTestStore(t)
+		defer shutdownStore()
+
+		log, _ := testutil.NewLogger(t)
+		r := &dummyReceiver{
+			deposits: make(chan DepositInfo),
+		}
+		p, err := NewDirectBuy(log, defaultCfg, store, r)
+		require.NoError(t, err)
+		go testutil.CheckError(t, p.Run)
+		defer p.Shutdown()
+
+		di1 := addDeposit(t, store, "btx1:1", "btcaddr1")
+		di2 := addDeposit(t, store, "btx2:1", "btcaddr1")
+
+		r.deposits <- di1
+		r.deposits <- di2
+
+		require.Equal(t, di1.DepositID, receive(t, p).DepositID)
+		require.Equal(t, di2.DepositID, receive(t, p).DepositID)
+	})
+}
+
+func TestSendErrorCode(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		code SendErrorCode
+	}{
+		{
+			name: "insufficient balance",
+			err:  sender.NewRPCError(wallet.ErrInsufficientBalance),
+			code: SendErrorInsufficientBalance,
+		},
+		{
+			name: "node unreachable",
+			err: sender.NewRPCError(&net.OpError{
+				Op:  "dial",
+				Net: "tcp",
+				Err: errors.New("connection refused"),
+			}),
+			code: SendErrorNodeUnreachable,
+		},
+		{
+			name: "invalid address",
+			err:  sender.NewInvalidAddressErr(errors.New("Invalid base58 character")),
+			code: SendErrorInvalidAddress,
+		},
+		{
+			name: "tx rejected",
+			err: sender.RPCError{
+				Kind: sender.RPCErrorTxRejected,
 			},
+			code: SendErrorTxRejected,
+		},
+		{
+			name: "unknown rpc error",
+			err:  sender.NewRPCError(errors.New("unknown")),
+			code: SendErrorUnknown,
+		},
+		{
+			name: "not a sender error",
+			err:  ErrEmptySendAmount,
+		},
+		{
+			name: "no error",
 		},
 	}
 
-	testExchangeRunProcessDepositBacklog(t, dis, func(e *Exchange, di DepositInfo) {
-		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(di.Txid)
-	})
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code := sendErrorCode(tc.err)
+			require.Equal(t, tc.code, code)
+			if code != "" {
+				require.NoError(t, ValidateSendErrorCode(code))
+			}
+		})
+	}
+
+	require.Equal(t, ErrInvalidSendErrorCode, ValidateSendErrorCode("foo"))
+}
+
+func TestSendRecordsSendErrorCode(t *testing.T) {
+	store, shutdown := newTestStore(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	ds := newDummySender()
+	e, err := NewDirectExchange(log, defaultCfg, store, nil, ds)
+	require.NoError(t, err)
+	s := e.Sender.(*Send)
+
+	addDeposit := func(depositID string) DepositInfo {
+		di, err := store.addDepositInfo(DepositInfo{
+			Status:         StatusWaitSend,
+			CoinType:       scanner.CoinTypeBTC,
+			SkyAddress:     testSkyAddr,
+			BuyMethod:      config.BuyMethodDirect,
+			DepositAddress: "foo-btc-addr",
+			DepositID:      depositID,
+			DepositValue:   1e8,
+			ConversionRate: testSkyBtcRate,
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	// A permanent sender error is recorded before the deposit is set aside
+	di := addDeposit("foo-tx:1")
+	ds.Lock()
+	ds.createTransactionErr = sender.NewInvalidAddressErr(errors.New("Invalid base58 character"))
+	ds.Unlock()
+
+	err = s.processWaitSendDeposit(di)
+	require.IsType(t, sender.InvalidAddressErr{}, err)
+
+	di, err = store.GetDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+	require.Equal(t, SendErrorInvalidAddress, di.LastSendErrorCode)
+
+	// A temporary sender error is recorded while the send is retried
+	di = addDeposit("foo-tx:2")
+	ds.Lock()
+	ds.createTransactionErr = sender.NewRPCError(wallet.ErrInsufficientBalance)
+	ds.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.processWaitSendDeposit(di)
+	}()
+
+	for {
+		di, err = store.GetDepositInfo(di.DepositID)
+		require.NoError(t, err)
+		if di.LastSendErrorCode != "" {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	require.Equal(t, SendErrorInsufficientBalance, di.LastSendErrorCode)
+
+	ds.Lock()
+	ds.createTransactionErr = nil
+	ds.Unlock()
+	ds.setTxConfirmed(ds.predictTxid(t, testSkyAddr, 100e6))
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Waiting for the deposit to send timed out")
+	}
+
+	// The code of the last failure is kept after the deposit is sent
+	di, err = store.GetDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusDone, di.Status)
+	require.Equal(t, SendErrorInsufficientBalance, di.LastSendErrorCode)
+}
+
+func TestSendMergedDeposits(t *testing.T) {
+	store, shutdown := newTestStore(t)
+	defer shutdown()
+
+	skyAddr := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	addDeposit := func(depositID string, value int64) DepositInfo {
+		di, err := store.addDepositInfo(DepositInfo{
+			Status:         StatusWaitSend,
+			CoinType:       scanner.CoinTypeBTC,
+			SkyAddress:     skyAddr,
+			BuyMethod:      config.BuyMethodDirect,
+			DepositAddress: "btcaddr1",
+			DepositID:      depositID,
+			DepositValue:   value,
+			ConversionRate: "100",
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	di1 := addDeposit("btx1:1", 1e8)
+	di2 := addDeposit("btx2:1", 2e8)
+
+	primary, err := store.MergeDeposits(di1.DepositID, []string{di2.DepositID})
+	require.NoError(t, err)
+
+	log, _ := testutil.NewLogger(t)
+	ds := newDummySender()
+	e, err := NewDirectExchange(log, defaultCfg, store, nil, ds)
+	require.NoError(t, err)
+
+	// Both deposits are sent in one transaction
+	txid := ds.predictTxid(t, skyAddr, 300e6)
+	ds.setTxConfirmed(txid)
+
+	err = e.Sender.(*Send).processWaitSendDeposit(primary)
+	require.NoError(t, err)
+
+	di1, err = store.GetDepositInfo(di1.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusDone, di1.Status)
+	require.Equal(t, txid, di1.Txid)
+	require.Equal(t, uint64(100e6), di1.SkySent)
+	require.Equal(t, "100", di1.AppliedRate)
+
+	// The merged deposit follows the primary deposit, with its share of the coins sent
+	di2, err = store.GetDepositInfo(di2.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusDone, di2.Status)
+	require.Equal(t, txid, di2.Txid)
+	require.Equal(t, uint64(200e6), di2.SkySent)
+	require.Equal(t, "100", di2.AppliedRate)
+
+	// The coins sent are counted once
+	_, skySent, err := store.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, int64(300e6), skySent)
+}
+
+func TestSendMergedDepositsRejected(t *testing.T) {
+	// Test that the deposits merged into a held deposit are rejected with it
+	store, shutdown := newTestStore(t)
+	defer shutdown()
+
+	skyAddr := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	addDeposit := func(depositID string, value int64) DepositInfo {
+		di, err := store.addDepositInfo(DepositInfo{
+			Status:         StatusWaitSend,
+			CoinType:       scanner.CoinTypeBTC,
+			SkyAddress:     skyAddr,
+			BuyMethod:      config.BuyMethodDirect,
+			DepositAddress: "btcaddr1",
+			DepositID:      depositID,
+			DepositValue:   value,
+			ConversionRate: "100",
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	di1 := addDeposit("btx1:1", 1e8)
+	di2 := addDeposit("btx2:1", 2e8)
+
+	primary, err := store.MergeDeposits(di1.DepositID, []string{di2.DepositID})
+	require.NoError(t, err)
+
+	cfg := defaultCfg
+	cfg.KYCThresholdSky = "100"
+
+	log, _ := testutil.NewLogger(t)
+	e, err := NewDirectExchange(log, cfg, store, nil, newDummySender())
+	require.NoError(t, err)
+
+	// The merged send amount is over the KYC threshold, the primary deposit is held
+	err = e.Sender.(*Send).processWaitSendDeposit(primary)
+	require.NoError(t, err)
+
+	di1, err = store.GetDepositInfo(di1.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusKYCHold, di1.Status)
+
+	di2, err = store.GetDepositInfo(di2.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di2.Status)
+
+	di1, err = e.RejectSend(di1.DepositID, "bob", "sender failed kyc")
+	require.NoError(t, err)
+	require.Equal(t, StatusRejected, di1.Status)
+
+	di2, err = store.GetDepositInfo(di2.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusRejected, di2.Status)
+	require.Equal(t, "sender failed kyc", di2.Error)
+	require.Empty(t, di2.Txid)
+	require.Equal(t, di1.DepositID, di2.MergedInto)
+}
+
+func TestSendReconcileMergedDeposits(t *testing.T) {
+	// Test that the deposits merged into a deposit that was done or stuck before they were updated,
+	// e.g. because teller stopped in between, follow it after a restart
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	skyAddr := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	addDeposit := func(depositID, depositAddr string, value int64) DepositInfo {
+		di, err := store.addDepositInfo(DepositInfo{
+			Status:         StatusWaitSend,
+			CoinType:       scanner.CoinTypeBTC,
+			SkyAddress:     skyAddr,
+			BuyMethod:      config.BuyMethodDirect,
+			DepositAddress: depositAddr,
+			DepositID:      depositID,
+			DepositValue:   value,
+			ConversionRate: "100",
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	// The primary deposits were saved as done and stuck, but teller stopped before their merged deposits were updated
+	di1 := addDeposit("btx1:1", "btcaddr1", 1e8)
+	di2 := addDeposit("btx2:1", "btcaddr1", 2e8)
+	di3 := addDeposit("btx3:1", "btcaddr2", 1e8)
+	di4 := addDeposit("btx4:1", "btcaddr2", 1e8)
+
+	_, err = store.MergeDeposits(di1.DepositID, []string{di2.DepositID})
+	require.NoError(t, err)
+	_, err = store.MergeDeposits(di3.DepositID, []string{di4.DepositID})
+	require.NoError(t, err)
+
+	setStatuses := func(depositID, txid string, skySent uint64, statuses ...Status) {
+		for _, status := range statuses {
+			_, err := store.UpdateDepositInfo(depositID, func(di DepositInfo) DepositInfo {
+				di.Status = status
+				di.Txid = txid
+				di.SkySent = skySent
+				di.AppliedRate = "100"
+				return di
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	setStatuses(di1.DepositID, "txid1", 100e6, StatusWaitConfirm, StatusDone)
+	setStatuses(di3.DepositID, "txid3", 100e6, StatusWaitConfirm, StatusStuck)
+
+	e := newTestExchangeWithStore(t, log, store)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
+
+	waitForStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(depositID)
+				require.NoError(t, err)
+				if di.Status == status {
+					return di
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
+			}
+		}
+	}
+
+	di2 = waitForStatus(di2.DepositID, StatusDone)
+	require.Equal(t, "txid1", di2.Txid)
+	require.Equal(t, uint64(200e6), di2.SkySent)
+	require.Equal(t, "100", di2.AppliedRate)
+
+	di4 = waitForStatus(di4.DepositID, StatusStuck)
+	require.Equal(t, "txid3", di4.Txid)
+	require.Equal(t, uint64(100e6), di4.SkySent)
+
+	// The coins sent are counted once
+	_, skySent, err := store.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, int64(500e6), skySent)
 }
 
-func TestExchangeProcessWaitSendDeposits(t *testing.T) {
-	// Tests that StatusWaitSend deposits found in the db are processed
-	// on exchange startup
+func TestExchangeGetAppliedRate(t *testing.T) {
+	store, shutdown := newTestStore(t)
+	defer shutdown()
 
-	var depositValue int64 = 1e8
-	s := newDummySender()
-	skySent, err := CalculateBtcSkyValue(depositValue, testSkyBtcRate, testMaxDecimals)
+	log, _ := testutil.NewLogger(t)
+	e, err := NewDirectExchange(log, defaultCfg, store, nil, newDummySender())
 	require.NoError(t, err)
-	txid1 := s.predictTxid(t, testSkyAddr, skySent)
-	txid2 := s.predictTxid(t, testSkyAddr2, skySent)
 
-	// Add StatusWaitSend deposits
-	// They should all be confirmed after shutdown
-	dis := []DepositInfo{
-		{
-			Seq:            1,
+	addDeposit := func(depositID, depositAddr, txid, rate string) {
+		status := StatusWaitSend
+		if txid != "" {
+			status = StatusDone
+		}
+
+		_, err := store.addDepositInfo(DepositInfo{
+			Status:         status,
 			CoinType:       scanner.CoinTypeBTC,
-			Status:         StatusWaitSend,
-			SkyAddress:     testSkyAddr,
-			DepositAddress: "foo-btc-addr-1",
-			DepositID:      "foo-tx-1:1",
-			Txid:           txid1,
-			ConversionRate: testSkyBtcRate,
-			DepositValue:   depositValue,
+			SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
 			BuyMethod:      config.BuyMethodDirect,
-			Deposit: scanner.Deposit{
-				CoinType: scanner.CoinTypeBTC,
-				Address:  "foo-btc-addr-1",
-				Value:    depositValue,
-				Height:   20,
-				Tx:       "foo-tx-1",
-				N:        1,
-			},
-		},
-		{
-			Seq:            2,
+			DepositAddress: depositAddr,
+			DepositID:      depositID,
+			DepositValue:   1e8,
+			ConversionRate: "100",
+			Txid:           txid,
+			AppliedRate:    rate,
+		})
+		require.NoError(t, err)
+	}
+
+	// The latest sent deposit's rate is returned
+	addDeposit("btx1:1", "btcaddr1", "txid1", "100")
+	addDeposit("btx2:1", "btcaddr1", "txid2", "125.5")
+	addDeposit("btx3:1", "btcaddr1", "", "")
+
+	rate, ok, err := e.GetAppliedRate("btcaddr1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "125.5", rate)
+
+	// A deposit sent before the rate was recorded has an unknown rate
+	addDeposit("btx4:1", "btcaddr2", "txid4", "")
+
+	_, ok, err = e.GetAppliedRate("btcaddr2")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// No deposit was sent
+	_, ok, err = e.GetAppliedRate("btcaddr3")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestSendBatchMergedDeposits(t *testing.T) {
+	store, shutdown := newTestStore(t)
+	defer shutdown()
+
+	skyAddr1 := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	skyAddr2 := "2VZu3rZozQ6nN37YSdj3EZJV7wSFVuLSm2X"
+	addDeposit := func(depositID, skyAddr, depositAddr string, value int64) DepositInfo {
+		di, err := store.addDepositInfo(DepositInfo{
+			Status:         StatusWaitSend,
 			CoinType:       scanner.CoinTypeBTC,
+			SkyAddress:     skyAddr,
+			BuyMethod:      config.BuyMethodDirect,
+			DepositAddress: depositAddr,
+			DepositID:      depositID,
+			DepositValue:   value,
+			ConversionRate: "100",
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	di1 := addDeposit("btx1:1", skyAddr1, "btcaddr1", 1e8)
+	di2 := addDeposit("btx2:1", skyAddr1, "btcaddr1", 2e8)
+	di3 := addDeposit("btx3:1", skyAddr2, "btcaddr2", 1e8)
+
+	primary, err := store.MergeDeposits(di1.DepositID, []string{di2.DepositID})
+	require.NoError(t, err)
+
+	cfg := defaultCfg
+	cfg.BatchSize = 2
+	cfg.BatchInterval = time.Hour
+
+	log, _ := testutil.NewLogger(t)
+	ds := newDummySender()
+	e, err := NewDirectExchange(log, cfg, store, nil, ds)
+	require.NoError(t, err)
+	s := e.Sender.(*Send)
+
+	// The output to the primary deposit's address includes the merged deposit's coins
+	tx, err := ds.CreateBatchTransaction([]sender.Recipient{
+		{Addr: skyAddr1, Coins: 300e6},
+		{Addr: skyAddr2, Coins: 100e6},
+	}, sender.SendOption{})
+	require.NoError(t, err)
+	batchTxid := tx.TxIDHex()
+	ds.setTxConfirmed(batchTxid)
+
+	s.batch = []DepositInfo{primary, di3}
+	err = s.flushBatch()
+	require.NoError(t, err)
+
+	// Each deposit records its own share of the coins sent
+	for _, tc := range []struct {
+		depositID string
+		skySent   uint64
+	}{
+		{di1.DepositID, 100e6},
+		{di2.DepositID, 200e6},
+		{di3.DepositID, 100e6},
+	} {
+		di, err := store.GetDepositInfo(tc.depositID)
+		require.NoError(t, err)
+		require.Equal(t, StatusDone, di.Status, tc.depositID)
+		require.Equal(t, batchTxid, di.Txid, tc.depositID)
+		require.Equal(t, tc.skySent, di.SkySent, tc.depositID)
+	}
+
+	// The coins sent are counted once
+	_, skySent, err := store.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, int64(400e6), skySent)
+}
+
+func TestSendBatch(t *testing.T) {
+	store, shutdown := newTestStore(t)
+	defer shutdown()
+
+	skyAddr1 := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	skyAddr2 := "2VZu3rZozQ6nN37YSdj3EZJV7wSFVuLSm2X"
+	addDeposit := func(depositID, skyAddr string, value int64) DepositInfo {
+		di, err := store.addDepositInfo(DepositInfo{
 			Status:         StatusWaitSend,
-			SkyAddress:     testSkyAddr2,
-			DepositAddress: "foo-btc-addr-2",
-			DepositID:      "foo-tx-2:2",
-			Txid:           txid2,
-			ConversionRate: testSkyBtcRate,
-			DepositValue:   depositValue,
+			CoinType:       scanner.CoinTypeBTC,
+			SkyAddress:     skyAddr,
 			BuyMethod:      config.BuyMethodDirect,
-			Deposit: scanner.Deposit{
-				CoinType: scanner.CoinTypeBTC,
-				Address:  "foo-btc-addr-2",
-				Value:    depositValue,
-				Height:   20,
-				Tx:       "foo-tx-2",
-				N:        2,
-			},
-		},
+			DepositAddress: "btcaddr-" + depositID,
+			DepositID:      depositID,
+			DepositValue:   value,
+			ConversionRate: "100",
+		})
+		require.NoError(t, err)
+		return di
 	}
 
-	testExchangeRunProcessDepositBacklog(t, dis, func(e *Exchange, di DepositInfo) {
-		boundAddr, err := e.store.BindAddress(di.SkyAddress, di.DepositAddress, di.CoinType, di.BuyMethod)
+	predictBatchTxid := func(ds *dummySender, recipients []sender.Recipient) string {
+		tx, err := ds.CreateBatchTransaction(recipients, sender.SendOption{})
 		require.NoError(t, err)
-		require.Equal(t, di.SkyAddress, boundAddr.SkyAddress)
-		require.Equal(t, di.DepositAddress, boundAddr.Address)
-		require.Equal(t, di.CoinType, boundAddr.CoinType)
-		require.Equal(t, di.BuyMethod, boundAddr.BuyMethod)
+		return tx.TxIDHex()
+	}
 
-		skySent, err := CalculateBtcSkyValue(di.DepositValue, di.ConversionRate, testMaxDecimals)
+	cfg := defaultCfg
+	cfg.BatchSize = 3
+	cfg.BatchInterval = time.Hour
+
+	log, _ := testutil.NewLogger(t)
+	ds := newDummySender()
+	e, err := NewDirectExchange(log, cfg, store, nil, ds)
+	require.NoError(t, err)
+	s := e.Sender.(*Send)
+
+	// The first deposit to each skycoin address is sent in the batch's transaction,
+	// a second deposit to the same address is sent separately
+	di1 := addDeposit("btx1:1", skyAddr1, 1e8)
+	di2 := addDeposit("btx2:1", skyAddr2, 2e8)
+	di3 := addDeposit("btx3:1", skyAddr1, 3e8)
+
+	batchTxid := predictBatchTxid(ds, []sender.Recipient{
+		{Addr: skyAddr1, Coins: 100e6},
+		{Addr: skyAddr2, Coins: 200e6},
+	})
+	ds.setTxConfirmed(batchTxid)
+	separateTxid := ds.predictTxid(t, skyAddr1, 300e6)
+	ds.setTxConfirmed(separateTxid)
+
+	s.batch = []DepositInfo{di1, di2, di3}
+	err = s.flushBatch()
+	require.NoError(t, err)
+	require.Empty(t, s.batch)
+
+	for _, tc := range []struct {
+		depositID string
+		txid      string
+		skySent   uint64
+	}{
+		{di1.DepositID, batchTxid, 100e6},
+		{di2.DepositID, batchTxid, 200e6},
+		{di3.DepositID, separateTxid, 300e6},
+	} {
+		di, err := store.GetDepositInfo(tc.depositID)
 		require.NoError(t, err)
+		require.Equal(t, StatusDone, di.Status, tc.depositID)
+		require.Equal(t, tc.txid, di.Txid, tc.depositID)
+		require.Equal(t, tc.skySent, di.SkySent, tc.depositID)
+	}
 
-		txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, di.SkyAddress, skySent)
-		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(txid)
+	// If the batch fails to send, every deposit of the batch stays StatusWaitSend
+	di4 := addDeposit("btx4:1", skyAddr1, 1e8)
+	di5 := addDeposit("btx5:1", skyAddr2, 1e8)
+	amounts := map[string]uint64{
+		di4.DepositID: 100e6,
+		di5.DepositID: 100e6,
+	}
+
+	ds.createTransactionErr = sender.NewRPCError(errors.New("connection refused"))
+	_, err = s.sendBatch([]DepositInfo{di4, di5}, amounts, sender.SendOption{})
+	require.IsType(t, sender.RPCError{}, err)
+
+	ds.createTransactionErr = nil
+	ds.broadcastTransactionErr = errors.New("broadcast failed")
+	_, err = s.sendBatch([]DepositInfo{di4, di5}, amounts, sender.SendOption{})
+	require.Error(t, err)
+
+	for _, id := range []string{di4.DepositID, di5.DepositID} {
+		di, err := store.GetDepositInfo(id)
+		require.NoError(t, err)
+		require.Equal(t, StatusWaitSend, di.Status)
+		require.Empty(t, di.Txid)
+	}
+
+	// If the batch fails permanently, its deposits are sent separately,
+	// and the deposits that fail are set aside like any other deposit
+	s.batch = []DepositInfo{di4, di5}
+	err = s.flushBatch()
+	require.NoError(t, err)
+
+	dls, err := store.GetDeadLetters()
+	require.NoError(t, err)
+	require.Len(t, dls, 2)
+
+	// The send loop sends a batch that is not full when its interval elapses
+	store2, shutdown2 := newTestStore(t)
+	defer shutdown2()
+	store = store2
+
+	di6 := addDeposit("btx6:1", skyAddr1, 1e8)
+	di7 := addDeposit("btx7:1", skyAddr2, 1e8)
+
+	cfg.BatchInterval = 100 * time.Millisecond
+	ds = newDummySender()
+	e, err = NewDirectExchange(log, cfg, store, nil, ds)
+	require.NoError(t, err)
+	s = e.Sender.(*Send)
+
+	batchTxid = predictBatchTxid(ds, []sender.Recipient{
+		{Addr: skyAddr1, Coins: 100e6},
+		{Addr: skyAddr2, Coins: 100e6},
 	})
+	ds.setTxConfirmed(batchTxid)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := s.Run()
+		require.NoError(t, err)
+	}()
+
+	// Periodically check the database until we observe the sent batch
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for range time.Tick(dbCheckWaitTime) {
+			confirmed := 0
+			for _, id := range []string{di6.DepositID, di7.DepositID} {
+				di, err := store.GetDepositInfo(id)
+				require.NoError(t, err)
+				if di.Status == StatusDone && di.Txid == batchTxid {
+					confirmed++
+				}
+			}
+
+			if confirmed == 2 {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(dbScanTimeout):
+		t.Fatal("Waiting for sent batch timed out")
+	}
+
+	s.Shutdown()
+	<-done
 }
 
-func TestExchangeProcessWaitDecideDeposits(t *testing.T) {
-	// Tests that StatusWaitDecide deposits found in the db are processed
-	// on exchange startup
+func TestExchangeGetDepositStatuses(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
 
-	var depositValue int64 = 1e8
-	s := newDummySender()
-	skySent, err := CalculateBtcSkyValue(depositValue, testSkyBtcRate, testMaxDecimals)
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+	dummyScanner := newDummyScanner()
+	dummyScannerEth := newDummyScanner()
+	multiplexer := scanner.NewMultiplexer(log)
+	err = multiplexer.AddScanner(dummyScanner, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	err = multiplexer.AddScanner(dummyScannerEth, scanner.CoinTypeETH)
 	require.NoError(t, err)
-	txid1 := s.predictTxid(t, testSkyAddr, skySent)
-	txid2 := s.predictTxid(t, testSkyAddr2, skySent)
 
-	// Add StatusWaitDecide deposits
-	// They should all be confirmed after shutdown
-	dis := []DepositInfo{
-		{
-			Seq:            1,
-			CoinType:       scanner.CoinTypeBTC,
-			Status:         StatusWaitDecide,
-			SkyAddress:     testSkyAddr,
-			DepositAddress: "foo-btc-addr-1",
-			DepositID:      "foo-tx-1:1",
-			Txid:           txid1,
-			ConversionRate: testSkyBtcRate,
-			DepositValue:   depositValue,
-			BuyMethod:      config.BuyMethodDirect,
-			Deposit: scanner.Deposit{
-				CoinType: scanner.CoinTypeBTC,
-				Address:  "foo-btc-addr-1",
-				Value:    depositValue,
-				Height:   20,
-				Tx:       "foo-tx-1",
-				N:        1,
-			},
-		},
-		{
-			Seq:            2,
-			CoinType:       scanner.CoinTypeBTC,
-			Status:         StatusWaitDecide,
-			SkyAddress:     testSkyAddr2,
-			DepositAddress: "foo-btc-addr-2",
-			DepositID:      "foo-tx-2:2",
-			Txid:           txid2,
-			ConversionRate: testSkyBtcRate,
-			DepositValue:   depositValue,
-			BuyMethod:      config.BuyMethodDirect,
-			Deposit: scanner.Deposit{
-				CoinType: scanner.CoinTypeBTC,
-				Address:  "foo-btc-addr-2",
-				Value:    depositValue,
-				Height:   20,
-				Tx:       "foo-tx-2",
-				N:        2,
-			},
-		},
-	}
+	s, err := NewDirectExchange(log, defaultCfg, store, multiplexer, nil)
+	require.NoError(t, err)
+
+	require.Len(t, dummyScanner.addrs, 0)
+
+	boundAddr, err := s.BindAddress("a", "b", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "a", boundAddr.SkyAddress)
+	require.Equal(t, "b", boundAddr.Address)
+
+	boundAddr, err = s.BindAddress("a", "e", scanner.CoinTypeETH)
+	require.NoError(t, err)
+	require.Equal(t, "a", boundAddr.SkyAddress)
+	require.Equal(t, "e", boundAddr.Address)
 
-	testExchangeRunProcessDepositBacklog(t, dis, func(e *Exchange, di DepositInfo) {
-		boundAddr, err := e.store.BindAddress(di.SkyAddress, di.DepositAddress, di.CoinType, di.BuyMethod)
-		require.NoError(t, err)
-		require.Equal(t, di.SkyAddress, boundAddr.SkyAddress)
-		require.Equal(t, di.DepositAddress, boundAddr.Address)
-		require.Equal(t, di.CoinType, boundAddr.CoinType)
-		require.Equal(t, di.BuyMethod, boundAddr.BuyMethod)
+	// Should be added to dummyScanner
+	require.Len(t, dummyScanner.addrs, 1)
+	require.Equal(t, "b", dummyScanner.addrs[0])
 
-		skySent, err := CalculateBtcSkyValue(di.DepositValue, di.ConversionRate, testMaxDecimals)
-		require.NoError(t, err)
+	require.Len(t, dummyScannerEth.addrs, 1)
+	require.Equal(t, "e", dummyScannerEth.addrs[0])
 
-		txid := e.Sender.(*Send).sender.(*dummySender).predictTxid(t, di.SkyAddress, skySent)
-		e.Sender.(*Send).sender.(*dummySender).setTxConfirmed(txid)
-	})
+	// Should be in the store
+	depositInfos, err := s.store.GetDepositInfoOfSkyAddress("a")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(depositInfos))
+	depositInfo := depositInfos[0]
+	require.Equal(t, uint64(0), depositInfo.Seq)
+	require.Equal(t, scanner.CoinTypeBTC, depositInfo.CoinType)
+	require.Equal(t, StatusWaitDeposit, depositInfo.Status)
+	require.NotEmpty(t, depositInfo.UpdatedAt)
+	depositInfo = depositInfos[1]
+	require.Equal(t, uint64(1), depositInfo.Seq)
+	require.Equal(t, scanner.CoinTypeETH, depositInfo.CoinType)
+	require.Equal(t, StatusWaitDeposit, depositInfo.Status)
+	require.NotEmpty(t, depositInfo.UpdatedAt)
 }
 
-func TestExchangeSaveIncomingDepositCreateDepositFailed(t *testing.T) {
-	// Tests that we log a message and continue if saveIncomingDeposit fails
-	e, shutdown, hook := runExchangeMockStore(t)
+func TestExchangeGetDepositStatusDetail(t *testing.T) {
+	// TODO
+}
+
+func TestExchangeDepositBlockHeight(t *testing.T) {
+	// Test that the height of the block a deposit was observed in is saved with it,
+	// and returned in the deposit statuses
+	e, shutdown, _ := runExchange(t)
 	defer shutdown()
-	didShutdown := false
-	defer func() {
-		if !didShutdown {
-			e.Shutdown()
-		}
-	}()
+	defer e.Shutdown()
+
+	store := e.store.(*Store)
 
 	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, testSkyAddr, btcAddr)
 
 	dn := scanner.DepositNote{
 		Deposit: scanner.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
 			Value:    1e8,
-			Height:   20,
+			Height:   494713,
 			Tx:       "foo-tx",
 			N:        2,
 		},
 		ErrC: make(chan error, 1),
 	}
-	mp := e.Receiver.(*Receive).multiplexer
-	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
-
-	// Configure database mocks
+	e.Receiver.(*Receive).multiplexer.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+	require.NoError(t, <-dn.ErrC)
 
-	// GetDepositInfoArray is called twice on startup
-	e.store.(*MockStore).On("GetDepositInfoArray", mock.MatchedBy(func(filt DepositFilter) bool {
-		return true
-	})).Return(nil, nil).Times(3)
+	di, err := store.GetDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+	require.Equal(t, int64(494713), di.Deposit.Height)
 
-	// Return error on GetOrCreateDepositInfo
-	createDepositErr := errors.New("GetOrCreateDepositInfo failed")
-	e.store.(*MockStore).On("GetOrCreateDepositInfo", dn.Deposit, testSkyBtcRate).Return(DepositInfo{}, createDepositErr)
+	statuses, err := e.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, int64(494713), statuses[0].BlockHeight)
 
-	// First loop calls saveIncomingDeposit
-	// err is written to ErrC after this method finishes
-	err := <-dn.ErrC
-	require.Error(t, err)
-	require.Equal(t, createDepositErr, err)
+	details, err := e.GetDepositStatusDetail(func(di DepositInfo) bool {
+		return di.DepositID == dn.Deposit.ID()
+	})
+	require.NoError(t, err)
+	require.Len(t, details, 1)
+	require.Equal(t, int64(494713), details[0].BlockHeight)
 
-	// Check that we logged the failed save, so that we can recover it later
-	logEntry := hook.LastEntry()
-	require.Equal(t, logEntry.Message, "saveIncomingDeposit failed. This deposit will not be reprocessed until teller is restarted.")
-	loggedDeposit := logEntry.Data["deposit"].(scanner.Deposit)
-	require.Equal(t, dn.Deposit, loggedDeposit)
+	// An address without a deposit has no height
+	mustBindAddress(t, store, testSkyAddr, "bar-btc-addr")
+	statuses, err = e.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	require.Equal(t, StatusWaitDeposit.String(), statuses[1].Status)
+	require.Equal(t, int64(0), statuses[1].BlockHeight)
 }
 
-func TestExchangeProcessWaitSendDepositFailed(t *testing.T) {
-	// Tests that we log a message and continue if processWaitSendDeposit fails
-	e, shutdown, hook := runExchangeMockStore(t)
+func TestExchangeGetBindNum(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
 	defer shutdown()
-	didShutdown := false
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	bscr := newDummyScanner()
+	multiplexer := scanner.NewMultiplexer(log)
+	err = multiplexer.AddScanner(bscr, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+
+	s, err := NewDirectExchange(log, defaultCfg, store, multiplexer, nil)
+	require.NoError(t, err)
+
+	num, err := s.GetBindNum("a")
+	require.Equal(t, num, 0)
+	require.NoError(t, err)
+
+	mustBindAddress(t, store, "a", "b")
+
+	num, err = s.GetBindNum("a")
+	require.NoError(t, err)
+	require.Equal(t, num, 1)
+}
+
+func TestExchangeSimulateDeposit(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	// Simulated deposits are disabled by default
+	e := newTestExchangeWithConfig(t, log, store, defaultCfg)
+	_, err = e.SimulateDeposit(scanner.CoinTypeBTC, "foo-btc-addr", 1e8)
+	require.Equal(t, ErrSimulatedDepositsDisabled, err)
+
+	cfg := defaultCfg
+	cfg.AllowSimulatedDeposits = true
+	e = newTestExchangeWithConfig(t, log, store, cfg)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
 	defer func() {
-		if !didShutdown {
-			e.Shutdown()
-		}
+		e.Shutdown()
+		<-done
 	}()
 
-	skyAddr := testSkyAddr
 	btcAddr := "foo-btc-addr"
 
-	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
-			CoinType: scanner.CoinTypeBTC,
-			Address:  btcAddr,
-			Value:    1e8,
-			Height:   20,
-			Tx:       "foo-tx",
-			N:        2,
-		},
-		ErrC: make(chan error, 1),
-	}
-	mp := e.Receiver.(*Receive).multiplexer
-	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+	_, err = e.SimulateDeposit(scanner.CoinTypeBTC, btcAddr, 1e8)
+	require.Equal(t, ErrNoBoundAddress, err)
 
-	// Configure database mocks
+	mustBindAddress(t, store, testSkyAddr, btcAddr)
 
-	// GetDepositInfoArray is called twice on startup
-	e.store.(*MockStore).On("GetDepositInfoArray", mock.MatchedBy(func(filt DepositFilter) bool {
-		return true
-	})).Return(nil, nil).Times(3)
+	_, err = e.SimulateDeposit(scanner.CoinTypeBTC, btcAddr, 0)
+	require.Equal(t, ErrInvalidDepositValue, err)
 
-	// GetBindAddress returns a bound address
-	e.store.(*MockStore).On("GetBindAddress", btcAddr).Return(skyAddr, nil)
+	_, err = e.SimulateDeposit("foo", btcAddr, 1e8)
+	require.Equal(t, scanner.ErrUnsupportedCoinType, err)
 
-	// GetOrCreateDepositInfo returns a valid DepositInfo
-	di := DepositInfo{
-		Seq:            1,
-		CoinType:       scanner.CoinTypeBTC,
-		Status:         StatusWaitSend,
-		SkyAddress:     skyAddr,
-		DepositAddress: btcAddr,
-		DepositID:      dn.Deposit.ID(),
-		BuyMethod:      config.BuyMethodDirect,
-		ConversionRate: testSkyBtcRate,
-		Deposit:        dn.Deposit,
+	depositID, err := e.SimulateDeposit(scanner.CoinTypeBTC, btcAddr, 1e8)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(depositID, "simulated-"))
+
+	// The simulated deposit is processed and sent like a scanned deposit
+	skySent, err := CalculateBtcSkyValue(1e8, testSkyBtcRate, testMaxDecimals)
+	require.NoError(t, err)
+	ds := e.Sender.(*Send).sender.(*dummySender)
+	txid := ds.predictTxid(t, testSkyAddr, skySent)
+	ds.setTxConfirmed(txid)
+
+	timeout := time.After(dbScanTimeout)
+	for {
+		select {
+		case <-time.After(statusCheckInterval):
+			di, err := store.GetDepositInfo(depositID)
+			require.NoError(t, err)
+			if di.Status == StatusDone {
+				require.Equal(t, txid, di.Txid)
+				require.Equal(t, skySent, di.SkySent)
+				return
+			}
+		case <-timeout:
+			t.Fatal("Waiting for simulated deposit to be sent timed out")
+		}
 	}
-	e.store.(*MockStore).On("GetOrCreateDepositInfo", dn.Deposit, testSkyBtcRate).Return(di, nil)
+}
 
-	// UpdateDepositInfo fails
-	updateDepositInfoErr := errors.New("UpdateDepositInfo error")
-	e.store.(*MockStore).On("UpdateDepositInfo", di.DepositID, mock.MatchedBy(func(f func(DepositInfo) DepositInfo) bool {
-		return true
-	})).Return(DepositInfo{}, updateDepositInfoErr)
+func TestAddressPrefixFilter(t *testing.T) {
+	f := AddressPrefixFilter([]string{"1A", "3"})
+
+	cases := []struct {
+		addr  string
+		match bool
+	}{
+		{addr: "1ABCdef", match: true},
+		{addr: "3Jkxyz", match: true},
+		{addr: "1BCdef", match: false},
+		{addr: "bc1qxyz", match: false},
+		{addr: "", match: false},
+	}
 
-	// First loop calls saveIncomingDeposit
-	// nil is written to ErrC after this method finishes
-	err := <-dn.ErrC
+	for _, tc := range cases {
+		t.Run(tc.addr, func(t *testing.T) {
+			require.Equal(t, tc.match, f(scanner.CoinTypeBTC, tc.addr))
+		})
+	}
+}
+
+func TestExchangeAddressFilter(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
 	require.NoError(t, err)
 
+	cfg := defaultCfg
+	cfg.AllowSimulatedDeposits = true
+	cfg.DepositAddressPrefixes = []string{"shard1-"}
+	e := newTestExchangeWithConfig(t, log, store, cfg)
+
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		for range time.Tick(dbCheckWaitTime) {
-			for _, e := range hook.AllEntries() {
-				if strings.Contains(e.Message, "updateStatus failed") {
-					return
-				}
-			}
-		}
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
 	}()
 
-	select {
-	case <-done:
-	case <-time.After(dbScanTimeout):
-		t.Fatal("Waiting for deposit send failure timed out")
-	}
+	ownedAddr := "shard1-btc-addr"
+	otherAddr := "shard2-btc-addr"
+	mustBindAddress(t, store, testSkyAddr, ownedAddr)
+	mustBindAddress(t, store, "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", otherAddr)
 
-	didShutdown = true
-	e.Shutdown()
+	// A deposit to an address of another shard is ignored without an error
+	_, err = e.SimulateDeposit(scanner.CoinTypeBTC, otherAddr, 1e8)
+	require.NoError(t, err)
 
-	foundMsg := false
-	for _, e := range hook.AllEntries() {
-		if !strings.Contains(e.Message, "updateStatus failed") {
-			continue
-		}
-		foundMsg = true
-		require.Equal(t, "updateStatus failed. This deposit will not be reprocessed until teller is restarted.", e.Message)
-		loggedDepositInfo, ok := e.Data["depositInfo"].(DepositInfo)
-		require.True(t, ok)
-		require.Equal(t, di, loggedDepositInfo)
-	}
+	dis, err := store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return di.DepositAddress == otherAddr
+	})
+	require.NoError(t, err)
+	require.Empty(t, dis)
 
-	require.True(t, foundMsg)
-}
+	// A deposit to an owned address is recorded
+	depositID, err := e.SimulateDeposit(scanner.CoinTypeBTC, ownedAddr, 1e8)
+	require.NoError(t, err)
 
-func TestExchangeProcessWaitSendNoSkyAddrBound(t *testing.T) {
-	// Tests that we log a message and continue if processWaitSendDeposit fails
-	e, shutdown, hook := runExchange(t)
-	defer shutdown()
-	defer e.Shutdown()
+	di, err := store.GetDepositInfo(depositID)
+	require.NoError(t, err)
+	require.Equal(t, ownedAddr, di.DepositAddress)
+}
 
-	btcAddr := "foo-btc-addr"
+type fakeTxQuerier map[string]*webrpc.TxnResult
 
-	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
-			CoinType: scanner.CoinTypeBTC,
-			Address:  btcAddr,
-			Value:    1e8,
-			Height:   20,
-			Tx:       "foo-tx",
-			N:        2,
-		},
-		ErrC: make(chan error, 1),
+func (q fakeTxQuerier) GetTransaction(txid string) (*webrpc.TxnResult, error) {
+	switch txid {
+	case "txid-missing":
+		return nil, sender.NewRPCError(errors.New("transaction doesn't exist"))
+	case "txid-error":
+		return nil, sender.NewRPCError(errors.New("connection refused"))
 	}
-	mp := e.Receiver.(*Receive).multiplexer
-	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
-
-	// First loop calls saveIncomingDeposit
-	// nil is written to ErrC after this method finishes
-	err := <-dn.ErrC
-	require.Error(t, err)
-	require.Equal(t, err, ErrNoBoundAddress)
+	return q[txid], nil
+}
 
-	// Check that we logged the failed save, so that we can recover it later
-	logEntry := hook.LastEntry()
-	require.Equal(t, logEntry.Message, "saveIncomingDeposit failed. This deposit will not be reprocessed until teller is restarted.")
-	loggedDeposit := logEntry.Data["deposit"].(scanner.Deposit)
-	require.Equal(t, dn.Deposit, loggedDeposit)
+func newFakeTxnResult(confirmed bool, addr, coins string) *webrpc.TxnResult {
+	return &webrpc.TxnResult{
+		Transaction: &visor.TransactionResult{
+			Status: visor.TransactionStatus{
+				Confirmed: confirmed,
+			},
+			Transaction: visor.ReadableTransaction{
+				Out: []visor.ReadableTransactionOutput{
+					{
+						Address: addr,
+						Coins:   coins,
+					},
+				},
+			},
+		},
+	}
 }
 
-func TestExchangeBindAddress(t *testing.T) {
+func TestExchangePreflight(t *testing.T) {
 	db, shutdown := testutil.PrepareDB(t)
 	defer shutdown()
 
 	log, _ := testutil.NewLogger(t)
-	store, err := NewStore(log, db)
-	require.NoError(t, err)
-	dummyScanner := newDummyScanner()
-	multiplexer := scanner.NewMultiplexer(log)
-	err = multiplexer.AddScanner(dummyScanner, scanner.CoinTypeBTC)
+	e := newTestExchange(t, log, db)
+	defer closeMultiplexer(e)
+
+	require.Nil(t, e.PreflightReport())
+
+	err := e.Preflight(context.Background())
 	require.NoError(t, err)
 
-	s, err := NewDirectExchange(log, defaultCfg, store, multiplexer, nil)
+	report := e.PreflightReport()
+	require.NotNil(t, report)
+	require.True(t, report.Passed)
+	require.Equal(t, []PreflightCheck{
+		{Name: "store", OK: true},
+		{Name: "sender", OK: true},
+		{Name: "scanner", OK: true},
+	}, report.Checks)
+
+	// The store check does not save anything
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket(ExchangeMetaBkt).Get([]byte("preflight")))
+		return nil
+	})
 	require.NoError(t, err)
 
-	require.Len(t, dummyScanner.addrs, 0)
+	// A failed check fails the preflight, the other checks still run
+	ds := e.Sender.(*Send).sender.(*dummySender)
+	ds.Lock()
+	ds.balanceErr = errors.New("connection refused")
+	ds.Unlock()
+
+	err = e.Preflight(context.Background())
+	require.Equal(t, errors.New("Preflight check sender failed: connection refused"), err)
+
+	report = e.PreflightReport()
+	require.False(t, report.Passed)
+	require.Equal(t, []PreflightCheck{
+		{Name: "store", OK: true},
+		{Name: "sender", Error: "connection refused"},
+		{Name: "scanner", OK: true},
+	}, report.Checks)
+}
 
-	boundAddr, err := s.BindAddress("a", "b", scanner.CoinTypeBTC)
+func TestExchangeReconcile(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	store, err := NewStore(log, db)
 	require.NoError(t, err)
-	require.Equal(t, "a", boundAddr.SkyAddress)
-	require.Equal(t, "b", boundAddr.Address)
 
-	// Should be added to dummyScanner
-	require.Len(t, dummyScanner.addrs, 1)
-	require.Equal(t, "b", dummyScanner.addrs[0])
+	e := newTestExchangeWithConfig(t, log, store, defaultCfg)
 
-	// Should be in the store
-	skyAddr, err := s.store.GetBindAddress("b", scanner.CoinTypeBTC)
+	_, err = e.Reconcile(context.Background())
+	require.Equal(t, ErrReconcileUnavailable, err)
+
+	e.SetTxQuerier(fakeTxQuerier{
+		"txid-ok":          newFakeTxnResult(true, testSkyAddr, "100.000000"),
+		"txid-unconfirmed": newFakeTxnResult(false, testSkyAddr, "100.000000"),
+		"txid-wrong":       newFakeTxnResult(true, testSkyAddr, "99.000000"),
+	})
+
+	addDeposit := func(btcAddr, txid string, status Status) string {
+		depositID := fmt.Sprintf("btx-%s:0", btcAddr)
+		_, err := store.addDepositInfo(DepositInfo{
+			CoinType:       scanner.CoinTypeBTC,
+			DepositID:      depositID,
+			DepositAddress: btcAddr,
+			SkyAddress:     testSkyAddr,
+			DepositValue:   1e6,
+			Txid:           txid,
+			ConversionRate: testSkyBtcRate,
+			SkySent:        100e6,
+			Status:         status,
+			BuyMethod:      config.BuyMethodDirect,
+		})
+		require.NoError(t, err)
+		return depositID
+	}
+
+	for _, addr := range []string{"a1", "a2", "a3", "a4", "a5", "a6"} {
+		mustBindAddress(t, store, testSkyAddr, addr)
+	}
+
+	addDeposit("a1", "txid-ok", StatusDone)
+	unconfirmed := addDeposit("a2", "txid-unconfirmed", StatusDone)
+	wrong := addDeposit("a3", "txid-wrong", StatusDone)
+	missing := addDeposit("a4", "txid-missing", StatusDone)
+	failed := addDeposit("a5", "txid-error", StatusDone)
+	// Deposits that are not done are not looked up
+	addDeposit("a6", "txid-missing", StatusWaitConfirm)
+	// A deposit to an address that is not bound
+	orphaned := addDeposit("a7", "txid-ok", StatusDone)
+
+	report, err := e.Reconcile(context.Background())
 	require.NoError(t, err)
-	require.Equal(t, &BoundAddress{
-		SkyAddress: "a",
-		Address:    "b",
-		CoinType:   scanner.CoinTypeBTC,
-		BuyMethod:  config.BuyMethodDirect,
-	}, skyAddr)
+	require.Equal(t, 7, report.Deposits)
+	require.Equal(t, 6, report.Transactions)
+	require.False(t, report.FinishedAt.Before(report.StartedAt))
+
+	kinds := make(map[string]DiscrepancyKind, len(report.Discrepancies))
+	for _, d := range report.Discrepancies {
+		kinds[d.DepositID] = d.Kind
+	}
+	require.Equal(t, map[string]DiscrepancyKind{
+		unconfirmed: DiscrepancyUnconfirmedTx,
+		wrong:       DiscrepancyAmountMismatch,
+		missing:     DiscrepancyMissingTx,
+		failed:      DiscrepancyQueryFailed,
+		orphaned:    DiscrepancyOrphaned,
+	}, kinds)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = e.Reconcile(ctx)
+	require.Equal(t, context.Canceled, err)
 }
 
-func TestExchangeCreateTransaction(t *testing.T) {
-	cfg := defaultCfg
-	cfg.SkyBtcExchangeRate = "111"
+func TestSendSweepStuckSends(t *testing.T) {
+	store, shutdown := newTestStore(t)
+	defer shutdown()
 
-	log, _ := testutil.NewLogger(t)
-	s, err := NewDirectExchange(log, cfg, nil, nil, newDummySender())
+	log, hook := testutil.NewLogger(t)
+	ds := newDummySender()
+	cfg := defaultCfg
+	cfg.StuckSendAge = time.Hour
+	e, err := NewDirectExchange(log, cfg, store, nil, ds)
 	require.NoError(t, err)
+	s := e.Sender.(*Send)
 
-	// Create transaction with no SkyAddress
-	di := DepositInfo{
+	registry := metrics.NewRegistry()
+	e.SetMetrics(registry)
+
+	var clockLock sync.Mutex
+	now := time.Now()
+	s.now = func() time.Time {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		return now
+	}
+	setClock := func(t time.Time) {
+		clockLock.Lock()
+		defer clockLock.Unlock()
+		now = t
+	}
+
+	di, err := store.addDepositInfo(DepositInfo{
+		Status:         StatusWaitSend,
 		CoinType:       scanner.CoinTypeBTC,
-		SkyAddress:     "",
+		SkyAddress:     testSkyAddr,
+		BuyMethod:      config.BuyMethodDirect,
+		DepositAddress: "foo-btc-addr",
+		DepositID:      "foo-tx:1",
 		DepositValue:   1e8,
-		ConversionRate: "100",
+		ConversionRate: testSkyBtcRate,
+	})
+	require.NoError(t, err)
+
+	// The send is retried while the wallet's balance is too low
+	ds.Lock()
+	ds.createTransactionErr = sender.NewRPCError(wallet.ErrInsufficientBalance)
+	ds.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.processWaitSendDeposit(di)
+	}()
+
+	for {
+		di, err = store.GetDepositInfo(di.DepositID)
+		require.NoError(t, err)
+		if di.LastSendErrorCode != "" {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
 	}
 
-	_, err = s.Sender.(*Send).createTransaction(di)
-	require.Equal(t, ErrNoBoundAddress, err)
+	// The deposit is not set aside before the stuck send age
+	stuck, err := s.SweepStuckSends()
+	require.NoError(t, err)
+	require.Empty(t, stuck)
 
-	// Create transaction with no coins sent, due to a very low DepositValue
-	di = DepositInfo{
-		CoinType:       scanner.CoinTypeBTC,
-		SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
-		DepositValue:   1,
-		ConversionRate: "100",
+	setClock(now.Add(time.Hour * 2))
+
+	stuck, err = s.SweepStuckSends()
+	require.NoError(t, err)
+	require.Len(t, stuck, 1)
+	require.Equal(t, di.DepositID, stuck[0].DepositID)
+	require.Equal(t, StatusStuckSend, stuck[0].Status)
+	require.Equal(t, ErrStuckSend.Error(), stuck[0].Error)
+	require.NoError(t, stuck[0].ValidateForStatus())
+
+	// An alert is logged for operators
+	var alerted bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["alert"] == "stuck_send" {
+			alerted = true
+		}
 	}
-	_, err = s.Sender.(*Send).createTransaction(di)
-	require.Equal(t, ErrEmptySendAmount, err)
+	require.True(t, alerted)
 
-	// Create valid transaction
-	di = DepositInfo{
-		CoinType:       scanner.CoinTypeBTC,
-		SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
-		DepositValue:   1e8,
-		ConversionRate: "100",
+	var buf bytes.Buffer
+	_, err = registry.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `teller_deposits_set_aside_total{status="stuck_send"} 1`+"\n")
+
+	// The send being retried stops without sending the coins
+	ds.Lock()
+	ds.createTransactionErr = nil
+	ds.Unlock()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Waiting for the send to stop timed out")
 	}
-	// Make sure DepositInfo.ConversionRate != s.cfg.SkyBtcExchangeRate, to confirm
-	// that the DepositInfo's ConversionRate is used instead of cfg.SkyBtcExchangeRate
-	require.NotEqual(t, s.cfg.SkyBtcExchangeRate, di.ConversionRate)
 
-	tx, err := s.Sender.(*Send).createTransaction(di)
+	di, err = store.GetDepositInfo(di.DepositID)
 	require.NoError(t, err)
-	// Should have one output for destination and one for change
-	require.Len(t, tx.Out, 2)
+	require.Equal(t, StatusStuckSend, di.Status)
+	require.Empty(t, di.Txid)
 
-	// Check that the coins sent are based upon the DepositValue.ConversionRate
-	var txOut *coin.TransactionOutput
-	for _, o := range tx.Out {
-		if o.Address.String() == di.SkyAddress {
-			txOut = &o
-			break
+	pending, err := e.PendingReview()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+
+	// A deposit approved by an operator waits for the stuck send age again
+	di, err = e.ApproveSend(di.DepositID, "alice")
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+
+	setClock(time.Now())
+
+	stuck, err = s.SweepStuckSends()
+	require.NoError(t, err)
+	require.Empty(t, stuck)
+}
+
+func TestExportFilterMatch(t *testing.T) {
+	march := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	di := func(status Status, updatedAt time.Time) DepositInfo {
+		return DepositInfo{
+			Status:    status,
+			UpdatedAt: updatedAt.Unix(),
 		}
 	}
-	require.NotNil(t, txOut)
-	require.Equal(t, uint64(100e6), txOut.Coins)
+
+	tt := []struct {
+		name  string
+		flt   ExportFilter
+		di    DepositInfo
+		match bool
+	}{
+		{"no filter", ExportFilter{}, di(StatusWaitSend, march), true},
+		{"status", ExportFilter{Statuses: []Status{StatusDone, StatusWaitConfirm}}, di(StatusDone, march), true},
+		{"other status", ExportFilter{Statuses: []Status{StatusDone}}, di(StatusWaitSend, march), false},
+		{"from is inclusive", ExportFilter{From: march}, di(StatusDone, march), true},
+		{"before from", ExportFilter{From: march}, di(StatusDone, march.Add(-time.Second)), false},
+		{"to is exclusive", ExportFilter{From: march, To: april}, di(StatusDone, april), false},
+		{"before to", ExportFilter{From: march, To: april}, di(StatusDone, april.Add(-time.Second)), true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.match, tc.flt.Match(tc.di))
+		})
+	}
 }
 
-func TestExchangeGetDepositStatuses(t *testing.T) {
+func TestExchangeExportCSV(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
 	db, shutdown := testutil.PrepareDB(t)
 	defer shutdown()
 
-	log, _ := testutil.NewLogger(t)
-	store, err := NewStore(log, db)
-	require.NoError(t, err)
-	dummyScanner := newDummyScanner()
-	dummyScannerEth := newDummyScanner()
-	multiplexer := scanner.NewMultiplexer(log)
-	err = multiplexer.AddScanner(dummyScanner, scanner.CoinTypeBTC)
-	require.NoError(t, err)
-	err = multiplexer.AddScanner(dummyScannerEth, scanner.CoinTypeETH)
+	e := newTestExchange(t, log, db)
+	store := e.store.(*Store)
+
+	done, err := store.addDepositInfo(DepositInfo{
+		DepositID:      "foo-tx:1",
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "foo-btc-addr",
+		DepositValue:   1e8,
+		ConversionRate: testSkyBtcRate,
+		AppliedRate:    testSkyBtcRate,
+		CoinType:       scanner.CoinTypeBTC,
+		Status:         StatusDone,
+		Txid:           "sky-txid",
+		SkySent:        100e6,
+		BuyMethod:      config.BuyMethodDirect,
+		Deposit: scanner.Deposit{
+			Tx: "foo-tx",
+			N:  1,
+		},
+	})
 	require.NoError(t, err)
 
-	s, err := NewDirectExchange(log, defaultCfg, store, multiplexer, nil)
+	// Errors can contain anything, including text a spreadsheet would run as a formula
+	_, err = store.addDepositInfo(DepositInfo{
+		DepositID:      "foo-tx:2",
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "foo-btc-addr",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		CoinType:       scanner.CoinTypeBTC,
+		Status:         StatusWaitSend,
+		BuyMethod:      config.BuyMethodDirect,
+		Error:          "=HYPERLINK(\"http://evil\", \"a, b\")\nline 2",
+	})
 	require.NoError(t, err)
 
-	require.Len(t, dummyScanner.addrs, 0)
+	export := func(flt ExportFilter) [][]string {
+		var buf bytes.Buffer
+		err := e.ExportCSV(&buf, flt)
+		require.NoError(t, err)
 
-	boundAddr, err := s.BindAddress("a", "b", scanner.CoinTypeBTC)
-	require.NoError(t, err)
-	require.Equal(t, "a", boundAddr.SkyAddress)
-	require.Equal(t, "b", boundAddr.Address)
+		records, err := csv.NewReader(&buf).ReadAll()
+		require.NoError(t, err)
+		require.Equal(t, exportCSVHeader, records[0])
+		return records[1:]
+	}
 
-	boundAddr, err = s.BindAddress("a", "e", scanner.CoinTypeETH)
-	require.NoError(t, err)
-	require.Equal(t, "a", boundAddr.SkyAddress)
-	require.Equal(t, "e", boundAddr.Address)
+	updatedAt := time.Unix(done.UpdatedAt, 0).UTC().Format(time.RFC3339)
 
-	// Should be added to dummyScanner
-	require.Len(t, dummyScanner.addrs, 1)
-	require.Equal(t, "b", dummyScanner.addrs[0])
+	records := export(ExportFilter{
+		Statuses: []Status{StatusDone},
+	})
+	require.Equal(t, [][]string{
+		{
+			"foo-tx:1",
+			"done",
+			scanner.CoinTypeBTC,
+			"foo-btc-addr",
+			"foo-tx",
+			"100000000",
+			testSkyAddr,
+			testSkyBtcRate,
+			testSkyBtcRate,
+			"100.000000",
+			"sky-txid",
+			"",
+			"",
+			updatedAt,
+			updatedAt,
+		},
+	}, records)
 
-	require.Len(t, dummyScannerEth.addrs, 1)
-	require.Equal(t, "e", dummyScannerEth.addrs[0])
+	// The error is quoted, and escaped as text
+	records = export(ExportFilter{
+		Statuses: []Status{StatusWaitSend},
+	})
+	require.Len(t, records, 1)
+	require.Equal(t, "'=HYPERLINK(\"http://evil\", \"a, b\")\nline 2", records[0][12])
 
-	// Should be in the store
-	depositInfos, err := s.store.GetDepositInfoOfSkyAddress("a")
-	require.NoError(t, err)
-	require.Equal(t, 2, len(depositInfos))
-	depositInfo := depositInfos[0]
-	require.Equal(t, uint64(0), depositInfo.Seq)
-	require.Equal(t, scanner.CoinTypeBTC, depositInfo.CoinType)
-	require.Equal(t, StatusWaitDeposit, depositInfo.Status)
-	require.NotEmpty(t, depositInfo.UpdatedAt)
-	depositInfo = depositInfos[1]
-	require.Equal(t, uint64(1), depositInfo.Seq)
-	require.Equal(t, scanner.CoinTypeETH, depositInfo.CoinType)
-	require.Equal(t, StatusWaitDeposit, depositInfo.Status)
-	require.NotEmpty(t, depositInfo.UpdatedAt)
+	require.Len(t, export(ExportFilter{}), 2)
+	require.Empty(t, export(ExportFilter{
+		From: time.Now().Add(time.Hour),
+	}))
 }
 
-func TestExchangeGetDepositStatusDetail(t *testing.T) {
-	// TODO
+// captureNotifier is a notify.Notifier that records the events it is given
+type captureNotifier struct {
+	sync.Mutex
+	events []notify.NotificationEvent
 }
 
-func TestExchangeGetBindNum(t *testing.T) {
-	db, shutdown := testutil.PrepareDB(t)
-	defer shutdown()
+func (c *captureNotifier) Notify(ctx context.Context, event notify.NotificationEvent) error {
+	c.Lock()
+	defer c.Unlock()
+	c.events = append(c.events, event)
+	return nil
+}
+
+// waitForEvent waits for the notification of depositID of type t
+func (c *captureNotifier) waitForEvent(t *testing.T, depositID string, typ notify.EventType) notify.NotificationEvent {
+	timeout := time.After(dbScanTimeout)
+	for {
+		c.Lock()
+		for _, event := range c.events {
+			if event.DepositID == depositID && event.Type == typ {
+				c.Unlock()
+				return event
+			}
+		}
+		c.Unlock()
 
+		select {
+		case <-time.After(statusCheckInterval):
+		case <-timeout:
+			t.Fatalf("Waiting for notification %s of %s timed out", typ, depositID)
+		}
+	}
+}
+
+func TestExchangeNotify(t *testing.T) {
+	// Test that received deposits, confirmed sends, failed sends and held deposits are notified
 	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
 	store, err := NewStore(log, db)
 	require.NoError(t, err)
 
-	bscr := newDummyScanner()
-	multiplexer := scanner.NewMultiplexer(log)
-	err = multiplexer.AddScanner(bscr, scanner.CoinTypeBTC)
-	require.NoError(t, err)
+	cfg := defaultCfg
+	cfg.KYCThresholdSky = "100"
+	e := newTestExchangeWithConfig(t, log, store, cfg)
 
-	s, err := NewDirectExchange(log, defaultCfg, store, multiplexer, nil)
-	require.NoError(t, err)
+	n := &captureNotifier{}
+	e.SetNotifier(n)
 
-	num, err := s.GetBindNum("a")
-	require.Equal(t, num, 0)
-	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := e.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		e.Shutdown()
+		<-done
+	}()
 
-	mustBindAddress(t, store, "a", "b")
+	btcAddr := "foo-btc-addr"
+	mustBindAddress(t, store, testSkyAddr, btcAddr)
 
-	num, err = s.GetBindNum("a")
-	require.NoError(t, err)
-	require.Equal(t, num, 1)
+	mp := e.Receiver.(*Receive).multiplexer
+	addDeposit := func(tx string, value int64) scanner.Deposit {
+		dn := scanner.DepositNote{
+			Deposit: scanner.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Value:    value,
+				Height:   20,
+				Tx:       tx,
+				N:        2,
+			},
+			ErrC: make(chan error, 1),
+		}
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		err := <-dn.ErrC
+		require.NoError(t, err)
+		return dn.Deposit
+	}
+
+	waitForStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			select {
+			case <-time.After(statusCheckInterval):
+				di, err := store.GetDepositInfo(depositID)
+				require.NoError(t, err)
+				if di.Status == status {
+					return di
+				}
+			case <-timeout:
+				t.Fatalf("Waiting for deposit status %s timed out", status)
+			}
+		}
+	}
+
+	s := e.Sender.(*Send).sender.(*dummySender)
+
+	// A deposit is received, sent and confirmed
+	sent := addDeposit("foo-tx", 1e8)
+	event := n.waitForEvent(t, sent.ID(), notify.EventDepositReceived)
+	require.Equal(t, scanner.CoinTypeBTC, event.CoinType)
+	require.Equal(t, btcAddr, event.DepositAddress)
+	require.Equal(t, testSkyAddr, event.SkyAddress)
+	require.Equal(t, StatusWaitDecide.String(), event.Status)
+
+	di := waitForStatus(sent.ID(), StatusWaitConfirm)
+	s.setTxConfirmed(di.Txid)
+
+	event = n.waitForEvent(t, sent.ID(), notify.EventSendDone)
+	require.Equal(t, StatusDone.String(), event.Status)
+	require.Equal(t, di.Txid, event.Txid)
+	require.Equal(t, uint64(100e6), event.SkySent)
+
+	// A deposit greater than the KYC threshold is held for review
+	held := addDeposit("bar-tx", 2e8)
+	event = n.waitForEvent(t, held.ID(), notify.EventReviewNeeded)
+	require.Equal(t, StatusKYCHold.String(), event.Status)
+	require.Equal(t, ErrKYCHold.Error(), event.Error)
+
+	// A deposit that fails to send is notified once it is given up on
+	s.Lock()
+	s.createTransactionErr = errors.New("fake create transaction error")
+	s.Unlock()
+
+	failed := addDeposit("baz-tx", 5e7)
+	event = n.waitForEvent(t, failed.ID(), notify.EventSendFailed)
+	require.Equal(t, "fake create transaction error", event.Error)
 }
@@ -0,0 +1,138 @@
+package exchange
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
+)
+
+// ExportFilter selects the deposits exported by ExportCSV
+type ExportFilter struct {
+	// Only deposits with one of these statuses are exported. All statuses if empty
+	Statuses []Status
+	// Only deposits last updated at or after From, and before To, are exported. Unbounded if zero
+	From time.Time
+	To   time.Time
+}
+
+// Match returns true if the deposit is selected by the filter
+func (f ExportFilter) Match(di DepositInfo) bool {
+	if len(f.Statuses) != 0 {
+		found := false
+		for _, st := range f.Statuses {
+			if di.Status == st {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	updatedAt := time.Unix(di.UpdatedAt, 0)
+
+	if !f.From.IsZero() && updatedAt.Before(f.From) {
+		return false
+	}
+
+	if !f.To.IsZero() && !updatedAt.Before(f.To) {
+		return false
+	}
+
+	return true
+}
+
+// exportCSVHeader is the header row of ExportCSV
+var exportCSVHeader = []string{
+	"deposit_id",
+	"status",
+	"coin_type",
+	"deposit_address",
+	"deposit_txid",
+	"deposit_value",
+	"skycoin_address",
+	"conversion_rate",
+	"applied_rate",
+	"sky_sent",
+	"skycoin_txid",
+	"merged_into",
+	"error",
+	"updated_at",
+	"status_updated_at",
+}
+
+// ExportCSV writes the deposits selected by flt to w as CSV, in deposit ID order, with a header row.
+// deposit_value is in the smallest unit of the coin type (e.g. satoshis), sky_sent is in SKY,
+// and the times are RFC3339 in UTC, empty if unknown.
+// The deposits are streamed as they are read, so that large exports are not buffered in memory.
+func (e *Exchange) ExportCSV(w io.Writer, flt ExportFilter) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(exportCSVHeader); err != nil {
+		return err
+	}
+
+	if err := e.store.ForEachDepositInfo(flt.Match, func(di DepositInfo) error {
+		record, err := exportCSVRecord(di)
+		if err != nil {
+			return err
+		}
+
+		return cw.Write(record)
+	}); err != nil {
+		e.log.WithError(err).Error("ForEachDepositInfo failed")
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func exportCSVRecord(di DepositInfo) ([]string, error) {
+	skySent, err := droplet.ToString(di.SkySent)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		di.DepositID,
+		di.Status.String(),
+		di.CoinType,
+		di.DepositAddress,
+		di.Deposit.Tx,
+		strconv.FormatInt(di.DepositValue, 10),
+		di.SkyAddress,
+		di.ConversionRate,
+		di.AppliedRate,
+		skySent,
+		di.Txid,
+		di.MergedInto,
+		escapeCSVFormula(di.Error),
+		formatExportTime(di.UpdatedAt),
+		formatExportTime(di.StatusUpdatedAt),
+	}, nil
+}
+
+// escapeCSVFormula prefixes free text that a spreadsheet would evaluate as a formula with a quote,
+// so that opening an export can't run a formula injected into it
+func escapeCSVFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+func formatExportTime(t int64) string {
+	if t == 0 {
+		return ""
+	}
+
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,164 @@
+package exchange
+
+import (
+	"sync"
+
+	"github.com/skycoin/teller/src/metrics"
+)
+
+// FreezeState is whether the exchange is frozen by an operator, see Exchange.Freeze
+type FreezeState struct {
+	Frozen bool `json:"frozen"`
+	// Operator who last froze or unfroze the exchange
+	Operator string `json:"operator,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// When the exchange was last frozen or unfrozen, as a Unix time. 0 if it was never frozen
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+}
+
+// freezeGate parks the receive, process and send loops while the exchange is frozen.
+// A nil freezeGate is never frozen.
+type freezeGate struct {
+	sync.Mutex
+	// thawed is closed when the exchange is unfrozen, nil if it is not frozen
+	thawed chan struct{}
+	// parked is the number of loops blocked in wait
+	parked int
+}
+
+func (g *freezeGate) freeze() {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.thawed == nil {
+		g.thawed = make(chan struct{})
+	}
+}
+
+func (g *freezeGate) unfreeze() {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.thawed != nil {
+		close(g.thawed)
+		g.thawed = nil
+	}
+}
+
+func (g *freezeGate) frozen() bool {
+	if g == nil {
+		return false
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	return g.thawed != nil
+}
+
+// parkedLoops returns the number of loops blocked in wait
+func (g *freezeGate) parkedLoops() int {
+	if g == nil {
+		return 0
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	return g.parked
+}
+
+// wait blocks while the exchange is frozen. It returns false if quit was closed first
+func (g *freezeGate) wait(quit <-chan struct{}) bool {
+	if g == nil {
+		return true
+	}
+
+	g.Lock()
+	thawed := g.thawed
+	if thawed != nil {
+		g.parked++
+	}
+	g.Unlock()
+
+	if thawed == nil {
+		return true
+	}
+
+	defer func() {
+		g.Lock()
+		defer g.Unlock()
+		g.parked--
+	}()
+
+	select {
+	case <-thawed:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// Freeze stops every change to deposits and bindings, e.g. during a security incident, while deposit
+// statuses can still be read. The receive, process and send loops park the deposits they hold
+// without processing them, and write operations return ErrFrozen, until Unfreeze is called.
+// A deposit whose transaction is being broadcast finishes first.
+// The freeze is saved, so teller stays frozen after a restart. The operator and reason are recorded
+// in the review audit log.
+func (e *Exchange) Freeze(operator, reason string) (FreezeState, error) {
+	state, err := e.store.SetFreezeState(true, operator, reason)
+	if err != nil {
+		e.log.WithError(err).Error("SetFreezeState failed")
+		return FreezeState{}, err
+	}
+
+	e.gate.freeze()
+	e.frozenMetric().Set(1)
+
+	e.log.WithField("operator", operator).WithField("reason", reason).WithField("alert", "frozen").Warning("ALERT: Teller is frozen by an operator")
+
+	return state, nil
+}
+
+// Unfreeze resumes processing after Freeze. The operator and reason are recorded in the review audit log
+func (e *Exchange) Unfreeze(operator, reason string) (FreezeState, error) {
+	state, err := e.store.SetFreezeState(false, operator, reason)
+	if err != nil {
+		e.log.WithError(err).Error("SetFreezeState failed")
+		return FreezeState{}, err
+	}
+
+	e.gate.unfreeze()
+	e.frozenMetric().Set(0)
+
+	e.log.WithField("operator", operator).WithField("reason", reason).Warning("Teller is unfrozen by an operator")
+
+	return state, nil
+}
+
+// Frozen returns true if the exchange is frozen, see Freeze
+func (e *Exchange) Frozen() bool {
+	return e.gate.frozen()
+}
+
+// FreezeState returns whether the exchange is frozen, by whom and why
+func (e *Exchange) FreezeState() (FreezeState, error) {
+	return e.store.GetFreezeState()
+}
+
+// loadFreezeState restores a freeze saved before teller was restarted
+func (e *Exchange) loadFreezeState() error {
+	state, err := e.store.GetFreezeState()
+	if err != nil {
+		return err
+	}
+
+	if state.Frozen {
+		e.gate.freeze()
+		e.log.WithField("operator", state.Operator).WithField("reason", state.Reason).WithField("alert", "frozen").Warning("ALERT: Teller is frozen by an operator")
+	}
+
+	return nil
+}
+
+func (e *Exchange) frozenMetric() metrics.Gauge {
+	return e.metrics.Gauge("teller_frozen", "1 if teller is frozen by an operator", nil)
+}
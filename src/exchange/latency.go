@@ -0,0 +1,107 @@
+package exchange
+
+import (
+	"github.com/skycoin/teller/src/metrics"
+)
+
+// statusSince is a deposit's status, and when it was moved to it as a Unix time
+type statusSince struct {
+	status Status
+	since  int64
+}
+
+// latencyTracker records how long deposits wait in each status, and how long they take from being saved to StatusDone.
+// It follows the deposit status changes published by the store, using the times the store stamped them with.
+// Deposits are forgotten once they reach a final status
+type latencyTracker struct {
+	metrics  metrics.Metrics
+	deposits map[string]statusSince
+}
+
+func newLatencyTracker(m metrics.Metrics) *latencyTracker {
+	return &latencyTracker{
+		metrics:  m,
+		deposits: make(map[string]statusSince),
+	}
+}
+
+// finalStatus returns true if a deposit does not change status after status
+func finalStatus(status Status) bool {
+	switch status {
+	case StatusDone, StatusRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// observe records a deposit's move to ev.Status. The time spent in its previous status is only known
+// if the tracker saw the deposit move to it, i.e. not for the status a deposit had when teller started.
+// createdAt is when the deposit was saved, 0 if unknown; it is only used when the deposit is done
+func (lt *latencyTracker) observe(ev StatusEvent, createdAt int64) {
+	status := NewStatusFromStr(ev.Status)
+
+	prev, ok := lt.deposits[ev.DepositID]
+	if ok && prev.status == status {
+		return
+	}
+
+	if ok {
+		lt.metrics.Histogram("teller_deposit_status_seconds", "Time deposits spent in a status before moving to the next", metrics.DefaultBuckets, metrics.Labels{
+			"status": prev.status.String(),
+		}).Observe(float64(ev.UpdatedAt - prev.since))
+	}
+
+	if !finalStatus(status) {
+		lt.deposits[ev.DepositID] = statusSince{
+			status: status,
+			since:  ev.UpdatedAt,
+		}
+		return
+	}
+
+	delete(lt.deposits, ev.DepositID)
+
+	if status == StatusDone && createdAt != 0 {
+		lt.metrics.Histogram("teller_deposit_processing_seconds", "Time from saving a deposit to its send being confirmed", metrics.DefaultBuckets, metrics.Labels{
+			"coin_type": ev.CoinType,
+		}).Observe(float64(ev.UpdatedAt - createdAt))
+	}
+}
+
+// runLatency records the latency of the deposit status changes published by the store until the exchange quits
+func (e *Exchange) runLatency(events <-chan StatusEvent) {
+	log := e.log.WithField("goroutine", "runLatency")
+	lt := newLatencyTracker(e.metrics)
+
+	for {
+		var ev StatusEvent
+		var ok bool
+		select {
+		case <-e.quit:
+			return
+		case ev, ok = <-events:
+			if !ok {
+				return
+			}
+		}
+
+		if ev.Dropped != 0 {
+			// The statuses of the tracked deposits may be out of date, so they are forgotten
+			log.WithField("dropped", ev.Dropped).Warning("Deposit status changes were dropped, their latency is not recorded")
+			lt.deposits = make(map[string]statusSince)
+		}
+
+		// The status event has no creation time
+		var createdAt int64
+		if NewStatusFromStr(ev.Status) == StatusDone {
+			di, err := e.store.GetDepositInfo(ev.DepositID)
+			if err != nil {
+				log.WithError(err).WithField("depositID", ev.DepositID).Error("GetDepositInfo failed")
+			}
+			createdAt = di.CreatedAt
+		}
+
+		lt.observe(ev, createdAt)
+	}
+}
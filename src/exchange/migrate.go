@@ -0,0 +1,161 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+const (
+	// SchemaVersion is the schema version of the records written by this version of teller.
+	// Increment it and add a migration to migrations when changing the stored records in
+	// a way that older records could be misinterpreted.
+	SchemaVersion = 2
+
+	// schemaVersionKey is the key of the schema version in ExchangeMetaBkt
+	schemaVersionKey = "schema_version"
+
+	// legacySchemaVersion is the schema version of a db created before schema versions were recorded
+	legacySchemaVersion = 1
+)
+
+// SchemaVersionTooNewErr is returned when opening a db that was written by a newer version of teller
+type SchemaVersionTooNewErr struct {
+	Version   int
+	Supported int
+}
+
+func (e SchemaVersionTooNewErr) Error() string {
+	return fmt.Sprintf("Database schema version %d is newer than the supported version %d, upgrade teller to open it", e.Version, e.Supported)
+}
+
+// NewSchemaVersionTooNewErr returns a SchemaVersionTooNewErr
+func NewSchemaVersionTooNewErr(version int) error {
+	return SchemaVersionTooNewErr{
+		Version:   version,
+		Supported: SchemaVersion,
+	}
+}
+
+// migration upgrades the db from schema version From to From+1
+type migration struct {
+	From    int
+	Migrate func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order to bring a db up to SchemaVersion
+var migrations = []migration{
+	{
+		From:    1,
+		Migrate: migrateV1DepositInfo,
+	},
+}
+
+func init() {
+	// Check that there is a migration for every schema version
+	for i, m := range migrations {
+		if m.From != legacySchemaVersion+i {
+			panic(fmt.Sprintf("migration %d has From=%d, expected %d", i, m.From, legacySchemaVersion+i))
+		}
+	}
+
+	if legacySchemaVersion+len(migrations) != SchemaVersion {
+		panic("migrations do not upgrade to SchemaVersion")
+	}
+}
+
+// getSchemaVersionTx returns the schema version of the db.
+// A db with deposits but no recorded schema version has the legacy schema version.
+// A db without deposits has the current schema version.
+func getSchemaVersionTx(tx *bolt.Tx) (int, error) {
+	v, err := dbutil.GetBucketString(tx, ExchangeMetaBkt, schemaVersionKey)
+	switch err.(type) {
+	case nil:
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid schema version %q: %v", v, err)
+		}
+		return version, nil
+
+	case dbutil.ObjectNotExistErr:
+		if tx.Bucket(DepositInfoBkt).Stats().KeyN == 0 {
+			return SchemaVersion, nil
+		}
+		return legacySchemaVersion, nil
+
+	default:
+		return 0, err
+	}
+}
+
+func setSchemaVersionTx(tx *bolt.Tx, version int) error {
+	return tx.Bucket(ExchangeMetaBkt).Put([]byte(schemaVersionKey), []byte(strconv.Itoa(version)))
+}
+
+// migrateTx upgrades the db to SchemaVersion.
+// Returns a SchemaVersionTooNewErr if the db was written by a newer version of teller.
+func migrateTx(tx *bolt.Tx) error {
+	version, err := getSchemaVersionTx(tx)
+	if err != nil {
+		return err
+	}
+
+	if version > SchemaVersion {
+		return NewSchemaVersionTooNewErr(version)
+	}
+
+	for _, m := range migrations {
+		if m.From < version {
+			continue
+		}
+
+		if err := m.Migrate(tx); err != nil {
+			return fmt.Errorf("Migrate schema version %d to %d failed: %v", m.From, m.From+1, err)
+		}
+	}
+
+	return setSchemaVersionTx(tx, SchemaVersion)
+}
+
+// migrateV1DepositInfo sets SchemaVersion on all DepositInfo records.
+// Version 1 records written before multiple coin types and buy methods
+// were supported are BTC deposits bought directly.
+func migrateV1DepositInfo(tx *bolt.Tx) error {
+	bkt := tx.Bucket(DepositInfoBkt)
+
+	updated := make(map[string]DepositInfo)
+	if err := bkt.ForEach(func(k, v []byte) error {
+		var di DepositInfo
+		if err := json.Unmarshal(v, &di); err != nil {
+			return err
+		}
+
+		if di.CoinType == "" {
+			di.CoinType = scanner.CoinTypeBTC
+		}
+		if di.BuyMethod == "" {
+			di.BuyMethod = config.BuyMethodDirect
+		}
+		di.SchemaVersion = 2
+
+		updated[string(k)] = di
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Keys cannot be modified while iterating with ForEach
+	for k, di := range updated {
+		if err := dbutil.PutBucketValue(tx, DepositInfoBkt, k, di); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package exchange
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func getTestSchemaVersion(t *testing.T, db *bolt.DB) int {
+	var version int
+	err := db.View(func(tx *bolt.Tx) error {
+		v, err := dbutil.GetBucketString(tx, ExchangeMetaBkt, schemaVersionKey)
+		if err != nil {
+			return err
+		}
+		version, err = strconv.Atoi(v)
+		return err
+	})
+	require.NoError(t, err)
+	return version
+}
+
+func TestMigrateNewDB(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	_, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	require.Equal(t, SchemaVersion, getTestSchemaVersion(t, db))
+}
+
+func TestMigrateV1DepositInfo(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	// A v1 record, written before coin types, buy methods and schema versions were recorded
+	v1Record := []byte(`{
+		"Seq": 1,
+		"UpdatedAt": 1510000000,
+		"Status": 3,
+		"SkyAddress": "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW",
+		"DepositAddress": "foo-btc-addr",
+		"DepositID": "foo-tx:2",
+		"Txid": "ff9bf608e11fc2a95d5b0769bd2e449e7b3b59e2d6cad4e5f50c514f4f9e1489",
+		"ConversionRate": "100",
+		"DepositValue": 100000000,
+		"SkySent": 100000000
+	}`)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(DepositInfoBkt)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte("foo-tx:2"), v1Record)
+	})
+	require.NoError(t, err)
+
+	log, _ := testutil.NewLogger(t)
+	s, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	require.Equal(t, SchemaVersion, getTestSchemaVersion(t, db))
+
+	di, err := s.GetDepositInfo("foo-tx:2")
+	require.NoError(t, err)
+	require.Equal(t, DepositInfo{
+		SchemaVersion:  2,
+		Seq:            1,
+		UpdatedAt:      1510000000,
+		Status:         StatusDone,
+		CoinType:       scanner.CoinTypeBTC,
+		SkyAddress:     "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW",
+		BuyMethod:      config.BuyMethodDirect,
+		DepositAddress: "foo-btc-addr",
+		DepositID:      "foo-tx:2",
+		Txid:           "ff9bf608e11fc2a95d5b0769bd2e449e7b3b59e2d6cad4e5f50c514f4f9e1489",
+		ConversionRate: "100",
+		DepositValue:   100000000,
+		SkySent:        100000000,
+	}, di)
+
+	// Opening a migrated db again is a no-op
+	_, err = NewStore(log, db)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion, getTestSchemaVersion(t, db))
+}
+
+func TestMigrateSchemaVersionTooNew(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(ExchangeMetaBkt); err != nil {
+			return err
+		}
+		return tx.Bucket(ExchangeMetaBkt).Put([]byte(schemaVersionKey), []byte(strconv.Itoa(SchemaVersion+1)))
+	})
+	require.NoError(t, err)
+
+	log, _ := testutil.NewLogger(t)
+	_, err = NewStore(log, db)
+	require.Equal(t, NewSchemaVersionTooNewErr(SchemaVersion+1), err)
+
+	// The schema version must not be changed
+	require.Equal(t, SchemaVersion+1, getTestSchemaVersion(t, db))
+}
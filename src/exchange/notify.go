@@ -0,0 +1,93 @@
+package exchange
+
+import (
+	"context"
+	"time"
+
+	"github.com/skycoin/teller/src/notify"
+)
+
+// SetNotifier sets where key deposit events are notified: received deposits, confirmed sends,
+// failed sends and deposits set aside for an operator. Notifications are delivered in the background
+// and retried, so a slow or failing sink never blocks the exchange. It must be called before Run.
+func (e *Exchange) SetNotifier(n notify.Notifier) {
+	e.notifier = notify.NewQueue(e.log, n)
+	e.Sender.SetNotifier(e.notifier)
+}
+
+// statusNotification returns the notification of a deposit moving to status, false if it is not notified
+func statusNotification(status Status) (notify.EventType, bool) {
+	switch status {
+	case StatusWaitDecide:
+		return notify.EventDepositReceived, true
+	case StatusDone:
+		return notify.EventSendDone, true
+	case StatusWaitReview, StatusStuckSend, StatusKYCHold, StatusStuck, StatusUnexpectedDeposit, StatusInvalid:
+		return notify.EventReviewNeeded, true
+	default:
+		return "", false
+	}
+}
+
+// newNotificationEvent creates a notify.NotificationEvent of a deposit
+func newNotificationEvent(t notify.EventType, di DepositInfo) notify.NotificationEvent {
+	return notify.NotificationEvent{
+		Type:           t,
+		DepositID:      di.DepositID,
+		CoinType:       di.CoinType,
+		DepositAddress: di.DepositAddress,
+		SkyAddress:     di.SkyAddress,
+		Status:         di.Status.String(),
+		Txid:           di.Txid,
+		SkySent:        di.SkySent,
+		Error:          di.Error,
+		CreatedAt:      time.Now().UTC().Unix(),
+	}
+}
+
+// runNotify notifies the deposit status changes published by the store until the exchange quits
+func (e *Exchange) runNotify(events <-chan StatusEvent) {
+	log := e.log.WithField("goroutine", "runNotify")
+
+	for {
+		var ev StatusEvent
+		var ok bool
+		select {
+		case <-e.quit:
+			return
+		case ev, ok = <-events:
+			if !ok {
+				return
+			}
+		}
+
+		if ev.Dropped != 0 {
+			log.WithField("dropped", ev.Dropped).Error("Deposit status changes were dropped, they are not notified")
+		}
+
+		t, notified := statusNotification(NewStatusFromStr(ev.Status))
+		if !notified {
+			continue
+		}
+
+		// The status event has no txid, amount or error
+		di, err := e.store.GetDepositInfo(ev.DepositID)
+		if err != nil {
+			log.WithError(err).WithField("depositID", ev.DepositID).Error("GetDepositInfo failed")
+			di = DepositInfo{
+				DepositID:      ev.DepositID,
+				CoinType:       ev.CoinType,
+				DepositAddress: ev.DepositAddress,
+				SkyAddress:     ev.SkyAddress,
+			}
+		}
+
+		event := newNotificationEvent(t, di)
+		// The deposit may have changed status since the event
+		event.Status = ev.Status
+
+		if err := e.notifier.Notify(context.Background(), event); err != nil {
+			log.WithError(err).WithField("event", event).Error("Notify failed")
+		}
+	}
+}
@@ -25,6 +25,8 @@ type Passthrough struct {
 	done       chan struct{}
 	statusLock sync.RWMutex
 	status     error
+	// parks deposits while frozen, nil if it can't be frozen
+	gate *freezeGate
 }
 
 // NewPassthrough creates Passthrough
@@ -74,11 +76,17 @@ func (p *Passthrough) runBuy() {
 			log.Info("quit")
 			return
 		case d := <-p.receiver.Deposits():
+			if !p.gate.wait(p.quit) {
+				log.Info("quit")
+				return
+			}
+
 			// TODO -- buy from the exchange
 			updatedDeposit, err := p.processWaitDecideDeposit(d)
 			if err != nil {
 				msg := "handleDeposit failed. This deposit will not be reprocessed until teller is restarted."
 				log.WithField("depositInfo", d).WithError(err).Error(msg)
+				addDeadLetter(log, p.store, d, err)
 				continue
 			}
 
@@ -0,0 +1,107 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PreflightCheck is the result of one of the dependency checks of Preflight
+type PreflightCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// PreflightReport is the result of Preflight
+type PreflightReport struct {
+	Time   time.Time        `json:"time"`
+	Passed bool             `json:"passed"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// Preflight checks that the exchange's dependencies work before it is run: that the deposit store
+// can be written and read, that the skycoin node of the sender responds to a balance request,
+// and that the nodes of the scanners are reachable. Every check is run, and the error of the
+// first failed check is returned. If ctx is done before a check finishes, the check fails with ctx.Err().
+func (e *Exchange) Preflight(ctx context.Context) error {
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"store", e.store.CheckReadWrite},
+		{"sender", func() error {
+			_, err := e.Sender.Balance()
+			return err
+		}},
+		{"scanner", e.Receiver.Ping},
+	}
+
+	report := PreflightReport{
+		Time:   time.Now().UTC(),
+		Passed: true,
+		Checks: make([]PreflightCheck, len(checks)),
+	}
+
+	var firstErr error
+	for i, c := range checks {
+		report.Checks[i].Name = c.name
+
+		err := runPreflightCheck(ctx, c.check)
+		if err != nil {
+			e.log.WithError(err).WithField("check", c.name).Error("Preflight check failed")
+			report.Checks[i].Error = err.Error()
+			report.Passed = false
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Preflight check %s failed: %v", c.name, err)
+			}
+			continue
+		}
+
+		report.Checks[i].OK = true
+	}
+
+	e.preflightLock.Lock()
+	e.preflight = &report
+	e.preflightLock.Unlock()
+
+	e.log.WithFields(logrus.Fields{
+		"passed": report.Passed,
+		"checks": report.Checks,
+	}).Info("Preflight finished")
+
+	return firstErr
+}
+
+// runPreflightCheck runs check, returning ctx.Err() if ctx is done before it returns.
+// The check keeps running in the background if ctx is done first
+func runPreflightCheck(ctx context.Context, check func() error) error {
+	errC := make(chan error, 1)
+	go func() {
+		errC <- check()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errC:
+		return err
+	}
+}
+
+// PreflightReport returns the report of the last Preflight, or nil if it was not run
+func (e *Exchange) PreflightReport() *PreflightReport {
+	e.preflightLock.RLock()
+	defer e.preflightLock.RUnlock()
+
+	if e.preflight == nil {
+		return nil
+	}
+
+	report := *e.preflight
+	report.Checks = append([]PreflightCheck(nil), e.preflight.Checks...)
+
+	return &report
+}
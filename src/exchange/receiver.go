@@ -2,11 +2,13 @@ package exchange
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/metrics"
 	"github.com/skycoin/teller/src/scanner"
 )
 
@@ -31,12 +33,36 @@ func init() {
 type Receiver interface {
 	Deposits() <-chan DepositInfo
 	BindAddress(skyAddr, depositAddr, coinType, buyMethod string) (*BoundAddress, error)
+	BindAddresses(skyAddr string, depositAddrs []string, coinType, buyMethod string) ([]BoundAddress, error)
+}
+
+// AddressFilter returns true if the deposits to a deposit address are processed by this teller
+type AddressFilter func(coinType, depositAddr string) bool
+
+// AddressPrefixFilter returns an AddressFilter that matches deposit addresses starting with one of prefixes
+func AddressPrefixFilter(prefixes []string) AddressFilter {
+	prefixes = append([]string(nil), prefixes...)
+	return func(coinType, depositAddr string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(depositAddr, p) {
+				return true
+			}
+		}
+		return false
+	}
 }
 
 // ReceiveRunner is a Receiver than can be run
 type ReceiveRunner interface {
 	Runner
 	Receiver
+	Requeuer
+	SetMetrics(metrics.Metrics)
+	SetMetricsTopAddresses(int)
+	SetAddressFilter(AddressFilter)
+	CheckScanDrift() ([]ScanDrift, error)
+	SimulateDeposit(scanner.Deposit) error
+	Ping() error
 }
 
 // Receive implements a Receiver. All incoming deposits are saved,
@@ -47,8 +73,18 @@ type Receive struct {
 	multiplexer *scanner.Multiplexer
 	store       Storer
 	deposits    chan DepositInfo
+	simulated   chan scanner.DepositNote
 	quit        chan struct{}
 	done        chan struct{}
+	metrics     metrics.Metrics
+	// the number of deposit addresses of each coin type exported by the value deposited to them, 0 if none
+	topAddresses int
+	// the value deposited to each deposit address, by coin type
+	addressValues map[string]*metrics.TopGauge
+	// deposits to addresses it rejects are ignored, nil if every deposit is processed
+	addressFilter AddressFilter
+	// parks incoming deposits while frozen, nil if it can't be frozen
+	gate *freezeGate
 }
 
 // NewReceive creates a Receive
@@ -59,17 +95,68 @@ func NewReceive(log logrus.FieldLogger, cfg config.SkyExchanger, store Storer, m
 		return nil, err
 	}
 
+	var addressFilter AddressFilter
+	if len(cfg.DepositAddressPrefixes) != 0 {
+		addressFilter = AddressPrefixFilter(cfg.DepositAddressPrefixes)
+	}
+
 	return &Receive{
 		log:         log.WithField("prefix", "teller.exchange.Receive"),
 		cfg:         cfg,
 		store:       store,
 		multiplexer: multiplexer,
 		deposits:    make(chan DepositInfo, 100),
+		simulated:   make(chan scanner.DepositNote),
 		quit:        make(chan struct{}),
 		done:        make(chan struct{}),
+		metrics:     metrics.Nop{},
+
+		addressValues: make(map[string]*metrics.TopGauge),
+
+		addressFilter: addressFilter,
 	}, nil
 }
 
+// SetMetrics sets where metrics are emitted. It must be called before Run
+func (r *Receive) SetMetrics(m metrics.Metrics) {
+	r.metrics = m
+}
+
+// SetMetricsTopAddresses sets how many deposit addresses of each coin type are exported by the value
+// deposited to them, the ones that received the most. The other addresses are summed into one series,
+// so that the metric's cardinality is bounded. No address is exported if 0. It must be called before Run
+func (r *Receive) SetMetricsTopAddresses(n int) {
+	r.topAddresses = n
+}
+
+// addressValueMetric returns the metric of the value deposited to each deposit address of coinType
+func (r *Receive) addressValueMetric(coinType string) *metrics.TopGauge {
+	g, ok := r.addressValues[coinType]
+	if !ok {
+		g = metrics.NewTopGauge(r.metrics, "teller_deposit_address_value", "Value deposited to the deposit addresses that received the most, in the smallest unit of the coin type", "deposit_address", metrics.Labels{
+			"coin_type": coinType,
+		}, r.topAddresses)
+		r.addressValues[coinType] = g
+	}
+	return g
+}
+
+// SetAddressFilter sets which deposit addresses this teller processes the deposits of,
+// overriding sky_exchanger.deposit_address_prefixes. Every deposit is processed if f is nil.
+// It must be called before Run
+func (r *Receive) SetAddressFilter(f AddressFilter) {
+	r.addressFilter = f
+}
+
+// Ping checks that the nodes of the scanners are reachable
+func (r *Receive) Ping() error {
+	if r.multiplexer == nil {
+		return nil
+	}
+
+	return r.multiplexer.Ping()
+}
+
 // Run processes deposits from the scanner.Scanner, recording them and exposing them over the Deposits() channel
 func (r *Receive) Run() error {
 	log := r.log
@@ -94,7 +181,11 @@ func (r *Receive) Run() error {
 	// This will block if there are too many waiting deposits, make sure that
 	// the Processor is running to receive them
 	for _, di := range waitDecideDeposits {
-		r.deposits <- di
+		select {
+		case <-r.quit:
+			return nil
+		case r.deposits <- di:
+		}
 	}
 
 	var wg sync.WaitGroup
@@ -127,21 +218,66 @@ func (r *Receive) runReadMultiplexer() {
 				log.Warn("Scan service closed, watch deposits loop quit")
 				return
 			}
-
+		case dv = <-r.simulated:
 		}
 		log := log.WithField("deposit", dv.Deposit)
 
+		// The deposit is left unacknowledged while frozen, and is resent by the scanner after a restart
+		if !r.gate.wait(r.quit) {
+			log.Info("quit")
+			return
+		}
+
+		// Deposits to addresses owned by another teller are acknowledged without being recorded
+		if r.addressFilter != nil && !r.addressFilter(dv.Deposit.CoinType, dv.Deposit.Address) {
+			log.Debug("Ignoring deposit to an address this teller does not process")
+			dv.ErrC <- nil
+			r.metrics.Counter("teller_deposits_ignored_total", "Deposits ignored because their address is not processed by this teller", metrics.Labels{
+				"coin_type": dv.Deposit.CoinType,
+			}).Inc()
+			continue
+		}
+
 		// Save a new DepositInfo based upon the scanner.Deposit.
 		// If the save fails, report it to the scanner.
 		// The scanner will mark the deposit as "processed" if no error
 		// occurred.  Any unprocessed deposits held by the scanner
 		// will be resent to the exchange when teller is started.
-		if d, err := r.saveIncomingDeposit(dv.Deposit); err != nil {
+		d, err := r.saveIncomingDeposit(dv.Deposit)
+		if err != nil {
 			log.WithError(err).Error("saveIncomingDeposit failed. This deposit will not be reprocessed until teller is restarted.")
 			dv.ErrC <- err
-		} else {
-			dv.ErrC <- nil
-			r.deposits <- d
+			continue
+		}
+
+		dv.ErrC <- nil
+
+		r.metrics.Counter("teller_deposits_received_total", "Deposits received from the scanners", metrics.Labels{
+			"coin_type": d.CoinType,
+		}).Inc()
+
+		if r.topAddresses > 0 {
+			r.addressValueMetric(d.CoinType).Add(d.DepositAddress, float64(d.DepositValue))
+		}
+
+		// Invalid deposits are recorded but never processed
+		switch d.Status {
+		case StatusInvalid:
+			log.WithField("depositInfo", d).Warn("Deposit is invalid and will not be processed")
+			setAsideMetric(r.metrics, d.Status).Inc()
+			continue
+		case StatusUnexpectedDeposit:
+			log.WithField("depositInfo", d).Warn("Deposit to a used address will not be processed")
+			setAsideMetric(r.metrics, d.Status).Inc()
+			continue
+		}
+
+		// The deposit is saved, so it is processed on restart if teller shuts down before it is queued
+		select {
+		case <-r.quit:
+			log.Info("quit")
+			return
+		case r.deposits <- d:
 		}
 	}
 }
@@ -160,18 +296,40 @@ func (r *Receive) Deposits() <-chan DepositInfo {
 	return r.deposits
 }
 
+// Requeue places a previously failed deposit back on the Deposits() channel
+func (r *Receive) Requeue(di DepositInfo) error {
+	select {
+	case <-r.quit:
+		return ErrRequeueClosed
+	case r.deposits <- di:
+		return nil
+	}
+}
+
+// SimulateDeposit processes dv as if it was received from the scanner, and waits for it to be saved
+func (r *Receive) SimulateDeposit(dv scanner.Deposit) error {
+	dn := scanner.NewDepositNote(dv)
+
+	select {
+	case <-r.quit:
+		return ErrReceiveClosed
+	case r.simulated <- dn:
+	}
+
+	return <-dn.ErrC
+}
+
 // saveIncomingDeposit is called when receiving a deposit from the scanner
 func (r *Receive) saveIncomingDeposit(dv scanner.Deposit) (DepositInfo, error) {
 	log := r.log.WithField("deposit", dv)
 
-	var rate string
-	rate, err := r.getRate(dv.CoinType)
+	rate, rateTier, err := getDepositRate(r.cfg, dv)
 	if err != nil {
 		log.WithError(err).Error("get conversion rate failed")
 		return DepositInfo{}, err
 	}
 
-	di, err := r.store.GetOrCreateDepositInfo(dv, rate)
+	di, err := r.store.GetOrCreateDepositInfo(dv, rate, rateTier)
 	if err != nil {
 		log.WithError(err).Error("GetOrCreateDepositInfo failed")
 		return DepositInfo{}, err
@@ -180,12 +338,43 @@ func (r *Receive) saveIncomingDeposit(dv scanner.Deposit) (DepositInfo, error) {
 	log = log.WithField("depositInfo", di)
 	log.Info("Saved DepositInfo")
 
+	if r.cfg.SingleUseAddresses && di.Status == StatusWaitDecide {
+		return r.checkAddressReused(di)
+	}
+
 	return di, err
 }
 
-// getRate returns conversion rate according to coin type
-func (r *Receive) getRate(coinType string) (string, error) {
-	return getRate(r.cfg, coinType)
+// checkAddressReused moves a deposit to StatusUnexpectedDeposit if its deposit address
+// already has a completed deposit, so that it is not sent
+func (r *Receive) checkAddressReused(di DepositInfo) (DepositInfo, error) {
+	log := r.log.WithField("depositInfo", di)
+
+	done, err := r.store.GetDepositInfoArray(func(d DepositInfo) bool {
+		return d.DepositAddress == di.DepositAddress && d.DepositID != di.DepositID && d.Status == StatusDone
+	})
+	if err != nil {
+		log.WithError(err).Error("GetDepositInfoArray failed")
+		return DepositInfo{}, err
+	}
+
+	if len(done) == 0 {
+		return di, nil
+	}
+
+	di, err = r.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusUnexpectedDeposit
+		di.Error = ErrDepositAddressReused.Error()
+		return di
+	})
+	if err != nil {
+		log.WithError(err).Error("UpdateDepositInfo set StatusUnexpectedDeposit failed")
+		return DepositInfo{}, err
+	}
+
+	log.WithField("alert", "unexpected_deposit").Warn("ALERT: Deposit to a single use address that was already used. It will not be sent and must be refunded manually.")
+
+	return di, nil
 }
 
 // getRate returns conversion rate according to coin type
@@ -200,6 +389,35 @@ func getRate(cfg config.SkyExchanger, coinType string) (string, error) {
 	}
 }
 
+// getDepositRate returns the conversion rate for a deposit, and the minimum deposit
+// amount of the rate tier applied. The rate tier is empty if the base rate is applied.
+// In test mode, every deposit is converted at the test mode rate.
+func getDepositRate(cfg config.SkyExchanger, dv scanner.Deposit) (string, string, error) {
+	if cfg.TestMode {
+		return cfg.TestModeRate, "", nil
+	}
+
+	rate, err := getRate(cfg, dv.CoinType)
+	if err != nil {
+		return "", "", err
+	}
+
+	if dv.CoinType != scanner.CoinTypeBTC {
+		return rate, "", nil
+	}
+
+	tier, err := SelectRateTier(cfg.SkyBtcRateTiers, dv.Value)
+	if err != nil {
+		return "", "", err
+	}
+
+	if tier == nil {
+		return rate, "", nil
+	}
+
+	return tier.Rate, tier.MinBtc, nil
+}
+
 // BindAddress binds deposit address with skycoin address, and
 // add the btc/eth address to scan service, when detect deposit coin
 // to the btc/eth address, will send specific skycoin to the binded
@@ -224,3 +442,29 @@ func (r *Receive) BindAddress(skyAddr, depositAddr, coinType, buyMethod string)
 
 	return boundAddr, nil
 }
+
+// BindAddresses binds multiple deposit addresses to a skycoin address, and adds
+// them to the scan service in a single call.
+// The store binding and the scanner registration are each atomic, but not
+// atomic together: if the scanner fails to add the addresses after they were bound,
+// the bindings remain and the error is returned. This matches BindAddress.
+func (r *Receive) BindAddresses(skyAddr string, depositAddrs []string, coinType, buyMethod string) ([]BoundAddress, error) {
+	if err := config.ValidateBuyMethod(buyMethod); err != nil {
+		return nil, err
+	}
+
+	if err := r.multiplexer.ValidateCoinType(coinType); err != nil {
+		return nil, err
+	}
+
+	boundAddrs, err := r.store.BindAddresses(skyAddr, depositAddrs, coinType, buyMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.multiplexer.AddScanAddresses(depositAddrs, coinType); err != nil {
+		return nil, err
+	}
+
+	return boundAddrs, nil
+}
@@ -0,0 +1,198 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/api/webrpc"
+	"github.com/skycoin/skycoin/src/util/droplet"
+)
+
+// ErrReconcileUnavailable is returned by Reconcile if no TxQuerier is set, e.g. with the dummy sender
+var ErrReconcileUnavailable = errors.New("Reconciliation is unavailable without a skycoin node")
+
+// TxQuerier looks up skycoin transactions by txid, e.g. a sender.SkyClient
+type TxQuerier interface {
+	GetTransaction(txid string) (*webrpc.TxnResult, error)
+}
+
+// DiscrepancyKind is the kind of a Discrepancy found by Reconcile
+type DiscrepancyKind string
+
+const (
+	// DiscrepancyMissingTx is a done deposit whose skycoin transaction is not on chain
+	DiscrepancyMissingTx DiscrepancyKind = "missing_tx"
+	// DiscrepancyUnconfirmedTx is a done deposit whose skycoin transaction is not confirmed
+	DiscrepancyUnconfirmedTx DiscrepancyKind = "unconfirmed_tx"
+	// DiscrepancyAmountMismatch is a done deposit whose skycoin transaction does not send SkySent to the skycoin address
+	DiscrepancyAmountMismatch DiscrepancyKind = "amount_mismatch"
+	// DiscrepancyOrphaned is a deposit whose deposit address is not bound, or is bound to a different skycoin address
+	DiscrepancyOrphaned DiscrepancyKind = "orphaned"
+	// DiscrepancyQueryFailed is a done deposit whose skycoin transaction could not be looked up
+	DiscrepancyQueryFailed DiscrepancyKind = "query_failed"
+)
+
+// Discrepancy is a deposit record that does not match the chain or the other records
+type Discrepancy struct {
+	DepositID string          `json:"deposit_id"`
+	Txid      string          `json:"txid,omitempty"`
+	Kind      DiscrepancyKind `json:"kind"`
+	Detail    string          `json:"detail"`
+}
+
+// ReconcileReport is the result of Reconcile
+type ReconcileReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Deposits is the number of deposits checked
+	Deposits int `json:"deposits"`
+	// Transactions is the number of skycoin transactions looked up
+	Transactions  int           `json:"transactions"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// SetTxQuerier sets how Reconcile looks up skycoin transactions. It must be called before Reconcile
+func (e *Exchange) SetTxQuerier(q TxQuerier) {
+	e.txQuerier = q
+}
+
+// Reconcile cross-checks every deposit against its bound address, and every done deposit
+// against its skycoin transaction on chain, and reports the discrepancies.
+// Deposits whose send amount was zero have no transaction and are not looked up.
+// The transaction of a merged deposit is checked against the SkySent of the deposit it was merged into.
+// If ctx is cancelled, the deposits checked so far are not reported and ctx.Err() is returned.
+func (e *Exchange) Reconcile(ctx context.Context) (ReconcileReport, error) {
+	if e.txQuerier == nil {
+		return ReconcileReport{}, ErrReconcileUnavailable
+	}
+
+	report := ReconcileReport{
+		StartedAt:     time.Now().UTC(),
+		Discrepancies: []Discrepancy{},
+	}
+
+	dis, err := e.store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return true
+	})
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+
+	for _, di := range dis {
+		select {
+		case <-ctx.Done():
+			return ReconcileReport{}, ctx.Err()
+		default:
+		}
+
+		report.Deposits++
+
+		d, err := e.reconcileBoundAddress(di)
+		if err != nil {
+			return ReconcileReport{}, err
+		}
+		if d != nil {
+			report.Discrepancies = append(report.Discrepancies, *d)
+		}
+
+		// A merged deposit's transaction is checked with the deposit it was merged into
+		if di.Status != StatusDone || di.Txid == "" || di.MergedInto != "" {
+			continue
+		}
+
+		report.Transactions++
+		if d := e.reconcileTx(di); d != nil {
+			report.Discrepancies = append(report.Discrepancies, *d)
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+
+	e.log.WithFields(logrus.Fields{
+		"deposits":      report.Deposits,
+		"transactions":  report.Transactions,
+		"discrepancies": len(report.Discrepancies),
+	}).Info("Reconciled deposits")
+
+	return report, nil
+}
+
+// reconcileBoundAddress checks that the deposit's address is bound to its skycoin address
+func (e *Exchange) reconcileBoundAddress(di DepositInfo) (*Discrepancy, error) {
+	boundAddr, err := e.store.GetBindAddress(di.DepositAddress, di.CoinType)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case boundAddr == nil:
+		return &Discrepancy{
+			DepositID: di.DepositID,
+			Kind:      DiscrepancyOrphaned,
+			Detail:    fmt.Sprintf("Deposit address %s is not bound", di.DepositAddress),
+		}, nil
+	case boundAddr.SkyAddress != di.SkyAddress:
+		return &Discrepancy{
+			DepositID: di.DepositID,
+			Kind:      DiscrepancyOrphaned,
+			Detail:    fmt.Sprintf("Deposit address %s is bound to %s, not %s", di.DepositAddress, boundAddr.SkyAddress, di.SkyAddress),
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// reconcileTx checks that a done deposit's transaction is confirmed and sends SkySent to the skycoin address
+func (e *Exchange) reconcileTx(di DepositInfo) *Discrepancy {
+	d := &Discrepancy{
+		DepositID: di.DepositID,
+		Txid:      di.Txid,
+	}
+
+	txn, err := e.txQuerier.GetTransaction(di.Txid)
+	switch {
+	// The error message of the skycoin node's get_transaction for an unknown txid
+	case err != nil && strings.Contains(err.Error(), "transaction doesn't exist"):
+		d.Kind = DiscrepancyMissingTx
+		d.Detail = "Transaction not found"
+		return d
+	case err != nil:
+		d.Kind = DiscrepancyQueryFailed
+		d.Detail = err.Error()
+		return d
+	case txn == nil || txn.Transaction == nil || txn.Transaction.Status.Unknown:
+		d.Kind = DiscrepancyMissingTx
+		d.Detail = "Transaction not found"
+		return d
+	case !txn.Transaction.Status.Confirmed:
+		d.Kind = DiscrepancyUnconfirmedTx
+		d.Detail = "Transaction is not confirmed"
+		return d
+	}
+
+	for _, o := range txn.Transaction.Transaction.Out {
+		if o.Address != di.SkyAddress {
+			continue
+		}
+
+		coins, err := droplet.FromString(o.Coins)
+		if err != nil {
+			d.Kind = DiscrepancyQueryFailed
+			d.Detail = fmt.Sprintf("Invalid output coins %q: %v", o.Coins, err)
+			return d
+		}
+
+		if coins == di.SkySent {
+			return nil
+		}
+	}
+
+	d.Kind = DiscrepancyAmountMismatch
+	d.Detail = fmt.Sprintf("Transaction has no output of %d droplets to %s", di.SkySent, di.SkyAddress)
+	return d
+}
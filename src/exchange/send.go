@@ -1,11 +1,14 @@
 package exchange
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 
 	"github.com/skycoin/skycoin/src/api/cli"
@@ -13,6 +16,8 @@ import (
 	"github.com/skycoin/skycoin/src/util/droplet"
 
 	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/metrics"
+	"github.com/skycoin/teller/src/notify"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
 	"github.com/skycoin/teller/src/util/mathutil"
@@ -28,6 +33,57 @@ type Sender interface {
 type SendRunner interface {
 	Runner
 	Sender
+	Requeuer
+	SetOnProcessError(ProcessErrorHandler)
+	SetCoinHourStrategy(CoinHourStrategyFunc)
+	SetMemo(MemoFunc)
+	SetMetrics(metrics.Metrics)
+	SetNotifier(notify.Notifier)
+	Pause(context.Context) error
+	Resume()
+	Paused() bool
+}
+
+// Decision is the action taken when a deposit fails processing
+type Decision int
+
+const (
+	// DecisionSkip sets the deposit aside in the dead letter list and continues with the next deposit
+	DecisionSkip Decision = iota
+	// DecisionRetry requeues the deposit for processing after a delay
+	DecisionRetry
+	// DecisionStop stops sending coins. Deposits continue to be received and are sent after a restart
+	DecisionStop
+)
+
+// ProcessErrorHandler decides what to do with a deposit that failed processing
+type ProcessErrorHandler func(di DepositInfo, err error) Decision
+
+// DefaultProcessErrorHandler skips every failed deposit, leaving it in the dead letter list for review
+func DefaultProcessErrorHandler(di DepositInfo, err error) Decision {
+	return DecisionSkip
+}
+
+// CoinHourStrategyFunc chooses how the coin hours of a deposit's send are spent.
+// If it returns an empty strategy, sky_exchanger.coin_hour_strategy is used
+type CoinHourStrategyFunc func(di DepositInfo) sender.CoinHourStrategy
+
+// MemoFunc chooses the memo that tags a deposit's send, e.g. an order ID from the deposit.
+// If it returns an empty memo, the transaction has no memo
+type MemoFunc func(di DepositInfo) string
+
+// maxStoreWriteFailures is the number of consecutive failures to save a deposit
+// after which sending is stopped and the send service becomes read-only
+const maxStoreWriteFailures = 3
+
+// StoreWriteErr is returned when the deposit store fails to save a deposit update
+type StoreWriteErr struct {
+	error
+}
+
+// NewStoreWriteErr returns a StoreWriteErr
+func NewStoreWriteErr(err error) error {
+	return StoreWriteErr{err}
 }
 
 // Send reads deposits from a Processor and sends coins
@@ -42,6 +98,30 @@ type Send struct {
 	depositChan chan DepositInfo
 	statusLock  sync.RWMutex
 	status      error
+	// onProcessError decides what to do with a deposit that failed processing
+	onProcessError ProcessErrorHandler
+	retryWait      time.Duration // how long to wait before retrying a failed deposit
+	// number of consecutive failures to save a deposit, see maxStoreWriteFailures
+	storeWriteFailures int
+	pauseC             chan chan struct{} // pause requests to the send loop, see Pause
+	resumeC            chan struct{}      // resumes a paused send loop, see Resume
+	pauseLock          sync.Mutex
+	paused             bool
+	now                func() time.Time
+	metrics            metrics.Metrics
+	notifier           notify.Notifier
+	// coinHourStrategy chooses the coin hour strategy of a deposit's send, if set
+	coinHourStrategy CoinHourStrategyFunc
+	// memo chooses the memo of a deposit's send, if set
+	memo MemoFunc
+	// StatusWaitSend deposits waiting to be sent in one transaction, see flushBatch
+	batch []DepositInfo
+	// parks deposits while frozen, nil if it can't be frozen
+	gate *freezeGate
+	// deposits sending more droplets than this are held for KYC, see sky_exchanger.kyc_threshold_sky. Never held if 0
+	kycThreshold uint64
+	// at most this many droplets are sent within sky_exchanger.send_allowance_window, see limitSend. No limit if 0
+	sendAllowance uint64
 }
 
 // NewSend creates exchange service
@@ -54,18 +134,79 @@ func NewSend(log logrus.FieldLogger, cfg config.SkyExchanger, store Storer, send
 		cfg.TxConfirmationCheckWait = txConfirmationCheckWait
 	}
 
+	if cfg.SendMemo != "" && !supportsMemo(sender) {
+		return nil, ErrMemoUnsupported
+	}
+
+	kycThreshold, err := cfg.KYCThresholdDroplets()
+	if err != nil {
+		return nil, err
+	}
+
+	sendAllowance, err := cfg.SendAllowanceDroplets()
+	if err != nil {
+		return nil, err
+	}
+
+	log = log.WithField("prefix", "teller.exchange.send")
+
+	if err := warnRoundingLoss(log, cfg); err != nil {
+		return nil, err
+	}
+
 	return &Send{
 		cfg:         cfg,
-		log:         log.WithField("prefix", "teller.exchange.send"),
+		log:         log,
 		processor:   processor,
 		sender:      sender,
 		store:       store,
 		quit:        make(chan struct{}),
 		done:        make(chan struct{}, 1),
 		depositChan: make(chan DepositInfo, 100),
+		pauseC:      make(chan chan struct{}),
+		resumeC:     make(chan struct{}),
+		now:         time.Now,
+		metrics:     metrics.Nop{},
+		notifier:    notify.Nop{},
+
+		onProcessError: DefaultProcessErrorHandler,
+		retryWait:      cfg.TxConfirmationCheckWait,
+		kycThreshold:   kycThreshold,
+		sendAllowance:  sendAllowance,
 	}, nil
 }
 
+// SetOnProcessError sets the handler that decides what to do when a deposit fails to send.
+// If h is nil, DefaultProcessErrorHandler is used. It must be called before Run.
+func (s *Send) SetOnProcessError(h ProcessErrorHandler) {
+	if h == nil {
+		h = DefaultProcessErrorHandler
+	}
+	s.onProcessError = h
+}
+
+// SetCoinHourStrategy sets a func that chooses the coin hour strategy of each deposit's send,
+// overriding sky_exchanger.coin_hour_strategy. It must be called before Run.
+func (s *Send) SetCoinHourStrategy(f CoinHourStrategyFunc) {
+	s.coinHourStrategy = f
+}
+
+// SetMemo sets a func that chooses the memo of each deposit's send,
+// overriding sky_exchanger.send_memo. It must be called before Run.
+func (s *Send) SetMemo(f MemoFunc) {
+	s.memo = f
+}
+
+// SetMetrics sets where metrics are emitted. It must be called before Run
+func (s *Send) SetMetrics(m metrics.Metrics) {
+	s.metrics = m
+}
+
+// SetNotifier sets where failed sends are notified. It must not block. It must be called before Run
+func (s *Send) SetNotifier(n notify.Notifier) {
+	s.notifier = n
+}
+
 // Run starts the exchange process
 func (s *Send) Run() error {
 	log := s.log
@@ -76,11 +217,19 @@ func (s *Send) Run() error {
 	}()
 
 	var wg sync.WaitGroup
+	var runErr error
 
 	if s.cfg.SendEnabled {
-		// Load StatusWaitSend deposits for processing later
+		if err := s.reconcileMergedDeposits(); err != nil {
+			err = fmt.Errorf("reconcileMergedDeposits failed: %v", err)
+			log.WithError(err).Error(err)
+			return err
+		}
+
+		// Load StatusWaitSend deposits for processing later.
+		// Merged deposits are updated with the deposit they were merged into
 		waitSendDeposits, err := s.store.GetDepositInfoArray(func(di DepositInfo) bool {
-			return di.Status == StatusWaitSend
+			return di.Status == StatusWaitSend && di.MergedInto == ""
 		})
 
 		if err != nil {
@@ -91,7 +240,7 @@ func (s *Send) Run() error {
 
 		// Load StatusWaitConfirm deposits for processing later
 		waitConfirmDeposits, err := s.store.GetDepositInfoArray(func(di DepositInfo) bool {
-			return di.Status == StatusWaitConfirm
+			return di.Status == StatusWaitConfirm && di.MergedInto == ""
 		})
 
 		if err != nil {
@@ -103,7 +252,12 @@ func (s *Send) Run() error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			s.runSend()
+			if err := s.runSend(); err != nil {
+				runErr = err
+				// Keep draining deposits so that the receiver and processor are not blocked.
+				// They remain saved and are sent after a restart.
+				s.runNoSend()
+			}
 		}()
 
 		// Queue the saved StatusWaitConfirm deposits
@@ -123,6 +277,22 @@ func (s *Send) Run() error {
 		}()
 	}
 
+	if s.cfg.SendEnabled && s.cfg.StuckSendAge > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runStuckSendSweep()
+		}()
+	}
+
+	if s.cfg.SendEnabled && s.sendAllowance != 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runRateLimitRelease()
+		}()
+	}
+
 	// Merge processor.Deposits() into the internal depositChan
 	wg.Add(1)
 	go func() {
@@ -132,28 +302,129 @@ func (s *Send) Run() error {
 
 	wg.Wait()
 
-	return nil
+	return runErr
 }
 
-func (s *Send) runSend() {
+func (s *Send) runSend() error {
 	// This loop processes StatusWaitSend deposits.
 	// Only one deposit is processed at a time; it will not send more coins
 	// until it receives confirmation of the previous send.
+	// If batching is enabled, StatusWaitSend deposits are collected instead,
+	// and sent in one transaction when the batch is full or its interval elapsed.
 	log := s.log.WithField("goroutine", "runSend")
+
+	var batchTimer *time.Timer
+	var batchC <-chan time.Time
 	for {
 		select {
 		case <-s.quit:
 			log.Info("quit")
-			return
+			return nil
+		case ack := <-s.pauseC:
+			s.waitPaused(ack)
+		case <-batchC:
+			batchC = nil
+			if err := s.flushBatch(); err != nil {
+				return err
+			}
 		case d := <-s.depositChan:
-			log := log.WithField("depositInfo", d)
-			if err := s.processWaitSendDeposit(d); err != nil {
-				log.WithError(err).Error("processWaitSendDeposit failed. This deposit will not be reprocessed until teller is restarted.")
+			if !s.batching() || d.Status != StatusWaitSend {
+				if err := s.process(d); err != nil {
+					return err
+				}
+				continue
+			}
+
+			s.batch = append(s.batch, d)
+			if len(s.batch) == 1 {
+				batchTimer = time.NewTimer(s.cfg.BatchInterval)
+				batchC = batchTimer.C
+			}
+
+			if len(s.batch) >= s.cfg.BatchSize {
+				batchTimer.Stop()
+				batchC = nil
+				if err := s.flushBatch(); err != nil {
+					return err
+				}
 			}
 		}
 	}
 }
 
+// process sends a deposit, and decides what to do if it failed with onProcessError.
+// It returns an error if sending must stop
+func (s *Send) process(d DepositInfo) error {
+	err := s.processWaitSendDeposit(d)
+	if err == nil {
+		return nil
+	}
+
+	log := s.log.WithField("goroutine", "runSend").WithField("depositInfo", d)
+
+	s.metrics.Counter("teller_send_failures_total", "Deposits that failed to send", nil).Inc()
+
+	if err == ErrReadOnly || err == ErrSentNotRecorded {
+		// The deposit can't be dead-lettered, since the store is not writable.
+		// It remains saved in its last recorded state, and is sent after a restart.
+		s.notifySendFailed(d, err)
+		return s.stopReadOnly(log, err)
+	}
+
+	decision := s.onProcessError(d, err)
+	if decision != DecisionRetry {
+		s.notifySendFailed(d, err)
+	}
+
+	switch decision {
+	case DecisionRetry:
+		log.WithError(err).Error("processWaitSendDeposit failed. This deposit will be retried.")
+		s.retry(d)
+	case DecisionStop:
+		log.WithError(err).Error("processWaitSendDeposit failed. Sending is stopped until teller is restarted.")
+		addDeadLetter(log, s.store, d, err)
+		s.setStatus(ErrSendStopped)
+		return err
+	default:
+		log.WithError(err).Error("processWaitSendDeposit failed. This deposit will not be reprocessed until teller is restarted.")
+		addDeadLetter(log, s.store, d, err)
+	}
+
+	return nil
+}
+
+// notifySendFailed notifies that a deposit failed to send and will not be retried automatically
+func (s *Send) notifySendFailed(di DepositInfo, err error) {
+	event := newNotificationEvent(notify.EventSendFailed, di)
+	event.Error = err.Error()
+
+	if err := s.notifier.Notify(context.Background(), event); err != nil {
+		s.log.WithError(err).WithField("depositInfo", di).Error("Notify failed")
+	}
+}
+
+// stopReadOnly stops sending after the store failed to save a deposit, returning err
+func (s *Send) stopReadOnly(log logrus.FieldLogger, err error) error {
+	log.WithError(err).WithField("alert", "read_only").Error("ALERT: The deposit store is not writable. Sending is stopped until teller is restarted.")
+	s.setStatus(ErrReadOnly)
+	return err
+}
+
+// retry requeues a failed deposit after retryWait, without blocking the send loop
+func (s *Send) retry(di DepositInfo) {
+	go func() {
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(s.retryWait):
+		}
+
+		if err := s.Requeue(di); err != nil {
+			s.log.WithField("depositInfo", di).WithError(err).Warning("Requeue failed deposit failed")
+		}
+	}()
+}
+
 func (s *Send) runNoSend() {
 	// Flush the deposit channel so that it doesn't fill up
 	log := s.log.WithField("goroutine", "runNoSend")
@@ -162,6 +433,8 @@ func (s *Send) runNoSend() {
 		case <-s.quit:
 			log.Info("quit")
 			return
+		case ack := <-s.pauseC:
+			s.waitPaused(ack)
 		case d := <-s.depositChan:
 			log := log.WithField("depositInfo", d)
 			log.Warning("Received depositInfo, but sending is disabled")
@@ -187,6 +460,85 @@ func (s *Send) receiveDeposits() {
 	}
 }
 
+// Pause stops the send loop from starting on new deposits. It blocks until the
+// deposit being processed, if any, is done or ctx is done. Deposits received while
+// paused are queued, and processed after Resume.
+func (s *Send) Pause(ctx context.Context) error {
+	s.pauseLock.Lock()
+	defer s.pauseLock.Unlock()
+
+	if s.paused {
+		return nil
+	}
+
+	// The send loop only accepts a pause request between deposits
+	ack := make(chan struct{})
+	select {
+	case <-s.quit:
+		return ErrPauseClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.pauseC <- ack:
+	}
+
+	<-ack
+	s.paused = true
+	s.pausedMetric().Set(1)
+
+	return nil
+}
+
+// Resume restarts a send loop stopped by Pause
+func (s *Send) Resume() {
+	s.pauseLock.Lock()
+	defer s.pauseLock.Unlock()
+
+	if !s.paused {
+		return
+	}
+
+	select {
+	case <-s.quit:
+	case s.resumeC <- struct{}{}:
+	}
+
+	s.paused = false
+	s.pausedMetric().Set(0)
+}
+
+// Paused returns true if the send loop is stopped by Pause
+func (s *Send) Paused() bool {
+	s.pauseLock.Lock()
+	defer s.pauseLock.Unlock()
+	return s.paused
+}
+
+func (s *Send) pausedMetric() metrics.Gauge {
+	return s.metrics.Gauge("teller_send_paused", "1 if sending is paused by an operator", nil)
+}
+
+// waitPaused acknowledges a pause request, then blocks until Resume is called or quit
+func (s *Send) waitPaused(ack chan struct{}) {
+	s.log.Info("Sending paused")
+	close(ack)
+
+	select {
+	case <-s.quit:
+	case <-s.resumeC:
+		s.log.Info("Sending resumed")
+	}
+}
+
+// Requeue places a previously failed deposit back on the internal deposit channel
+func (s *Send) Requeue(di DepositInfo) error {
+	select {
+	case <-s.quit:
+		return ErrRequeueClosed
+	case s.depositChan <- di:
+		return nil
+	}
+}
+
 // Shutdown close the exchange service
 func (s *Send) Shutdown() {
 	close(s.quit)
@@ -195,9 +547,49 @@ func (s *Send) Shutdown() {
 	s.log.Info("Shutdown complete")
 }
 
+// reconcileMergedDeposits updates the deposits merged into a deposit that is done, stuck or rejected, but that were
+// not updated with it, e.g. if teller stopped in between. Such a deposit is not reloaded, so its merged deposits
+// would not follow it otherwise. Deposits merged into a StatusWaitConfirm deposit are updated when it is reloaded
+func (s *Send) reconcileMergedDeposits() error {
+	merged, err := s.store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return di.MergedInto != "" && (di.Status == StatusWaitSend || di.Status == StatusWaitConfirm)
+	})
+	if err != nil {
+		return err
+	}
+
+	reconciled := make(map[string]struct{}, len(merged))
+	for _, di := range merged {
+		if _, ok := reconciled[di.MergedInto]; ok {
+			continue
+		}
+		reconciled[di.MergedInto] = struct{}{}
+
+		primary, err := s.store.GetDepositInfo(di.MergedInto)
+		if err != nil {
+			return err
+		}
+
+		switch primary.Status {
+		case StatusDone, StatusStuck, StatusRejected:
+		default:
+			continue
+		}
+
+		s.log.WithField("depositID", primary.DepositID).Warning("Updating the deposits merged into a deposit that was not reloaded")
+
+		if err := s.updateMergedDeposits(primary); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // processDeposit advances a single deposit through three states:
 // StatusWaitSend -> StatusWaitConfirm
 // StatusWaitConfirm -> StatusDone
+// StatusWaitConfirm -> StatusStuck, if not confirmed within the confirmation timeout
 // StatusWaitDeposit is never saved to the database, so it does not transition
 func (s *Send) processWaitSendDeposit(di DepositInfo) error {
 	log := s.log.WithField("depositInfo", di)
@@ -210,15 +602,45 @@ func (s *Send) processWaitSendDeposit(di DepositInfo) error {
 		default:
 		}
 
+		if !s.gate.wait(s.quit) {
+			return nil
+		}
+
 		log.Info("handleDepositInfoState")
 
 		var err error
 		di, err = s.handleDepositInfoState(di)
+		if err == nil && len(di.MergedDeposits) != 0 {
+			err = s.updateMergedDeposits(di)
+		}
 		log = log.WithField("depositInfo", di)
 
 		s.setStatus(err)
 
+		if code := sendErrorCode(err); code != "" {
+			s.recordSendError([]string{di.DepositID}, code)
+		}
+
+		if _, ok := err.(StoreWriteErr); ok {
+			s.storeWriteFailures++
+		} else if err != ErrSentNotRecorded {
+			s.storeWriteFailures = 0
+		}
+
 		switch err.(type) {
+		case StoreWriteErr:
+			// Retry the deposit in case the failure is temporary, but stop
+			// sending if the store is persistently unable to save deposits
+			log.WithError(err).WithField("storeWriteFailures", s.storeWriteFailures).Error("handleDepositInfoState failed to save the deposit")
+			if s.storeWriteFailures >= maxStoreWriteFailures {
+				return ErrReadOnly
+			}
+
+			select {
+			case <-time.After(s.cfg.TxConfirmationCheckWait):
+			case <-s.quit:
+				return nil
+			}
 		case sender.RPCError:
 			// Treat skycoin RPC/CLI errors as temporary.
 			// Some RPC/CLI errors are hypothetically permanent,
@@ -241,18 +663,111 @@ func (s *Send) processWaitSendDeposit(di DepositInfo) error {
 				case <-s.quit:
 					return nil
 				}
+			case ErrDepositStatusChanged:
+				log.WithError(err).Warning("Deposit is no longer StatusWaitSend, it is not sent")
+				return nil
+			case ErrDepositRepriced:
+				// The deposit is reloaded with its new rate and sent again
+				log.WithError(err).Warning("Deposit was repriced while it was being sent, it is sent at its new rate")
 			default:
 				log.WithError(err).Error("handleDepositInfoState failed")
 				return err
 			}
 		}
 
-		if di.Status == StatusDone {
+		// If the merged deposits failed to update, retry them
+		if err == nil && (di.Status == StatusDone || di.Status == StatusStuck || di.Status == StatusKYCHold || di.Status == StatusRateLimited) {
 			return nil
 		}
 	}
 }
 
+// updateMergedDeposits sets the status and txid of the deposits merged into primary to primary's,
+// after primary was sent or rejected. The SkySent of a merged deposit is its share of primary's SkySent
+func (s *Send) updateMergedDeposits(primary DepositInfo) error {
+	switch primary.Status {
+	case StatusWaitConfirm, StatusDone, StatusStuck, StatusRejected:
+	default:
+		return nil
+	}
+
+	for _, id := range primary.MergedDeposits {
+		log := s.log.WithField("depositID", id)
+
+		di, err := s.store.GetDepositInfo(id)
+		if err != nil {
+			log.WithError(err).Error("GetDepositInfo of merged deposit failed")
+			return NewStoreWriteErr(err)
+		}
+
+		if di.Status == primary.Status {
+			continue
+		}
+
+		if primary.Status == StatusRejected {
+			if _, err := s.store.UpdateDepositInfo(id, func(di DepositInfo) DepositInfo {
+				di.Status = StatusRejected
+				di.Error = primary.Error
+				return di
+			}); err != nil {
+				log.WithError(err).Error("UpdateDepositInfo of merged deposit failed")
+				return NewStoreWriteErr(err)
+			}
+
+			log.WithField("status", primary.Status.String()).Info("Updated merged deposit")
+			continue
+		}
+
+		v, err := s.calculateSkyValue(di)
+		if err != nil {
+			log.WithError(err).Error("calculateSkyValue of merged deposit failed")
+			return err
+		}
+
+		// Each merged deposit is converted at its own rate
+		rate, err := appliedRate(di)
+		if err != nil {
+			log.WithError(err).Error("appliedRate of merged deposit failed")
+			return err
+		}
+
+		// The rounding is recorded once, when the merged deposit is first updated after the send
+		recorded := di.Txid != ""
+
+		// A merged deposit that missed primary's send, e.g. if teller stopped before it was updated,
+		// is sent before it is stuck
+		statuses := []Status{primary.Status}
+		if primary.Status == StatusStuck && di.Status == StatusWaitSend {
+			statuses = []Status{StatusWaitConfirm, StatusStuck}
+		}
+
+		for _, status := range statuses {
+			if _, err := s.store.UpdateDepositInfo(id, func(di DepositInfo) DepositInfo {
+				di.Status = status
+				di.Txid = primary.Txid
+				di.SkySent = v.Droplets
+				di.CoinHourStrategy = primary.CoinHourStrategy
+				di.AppliedRate = rate
+				di.RoundingDroplets = v.RoundingDroplets
+				di.Memo = primary.Memo
+				di.Error = primary.Error
+				return di
+			}); err != nil {
+				log.WithError(err).Error("UpdateDepositInfo of merged deposit failed")
+				return NewStoreWriteErr(err)
+			}
+		}
+
+		if !recorded {
+			s.recordRounding(di, v)
+		}
+
+		log.WithField("status", primary.Status.String()).Info("Updated merged deposit")
+	}
+
+	return nil
+}
+
 func (s *Send) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 	log := s.log.WithField("depositInfo", di)
 
@@ -263,8 +778,28 @@ func (s *Send) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 
 	switch di.Status {
 	case StatusWaitSend:
+		var err error
+		di, err = s.reloadRate(di)
+		if err != nil {
+			return di, err
+		}
+
+		if heldDi, held, err := s.holdForKYC(di); err != nil || held {
+			return heldDi, err
+		}
+
+		if limitedDi, limited, err := s.limitSend(di); err != nil || limited {
+			return limitedDi, err
+		}
+
+		opt, err := s.sendOption(di)
+		if err != nil {
+			log.WithError(err).Error("sendOption failed")
+			return di, err
+		}
+
 		// Prepare skycoin transaction
-		skyTx, err := s.createTransaction(di)
+		skyTx, err := s.createTransaction(di, opt)
 
 		if err != nil {
 			log.WithError(err).Error("createTransaction failed")
@@ -272,15 +807,16 @@ func (s *Send) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 			// If the send amount is empty, skip to StatusDone.
 			if err == ErrEmptySendAmount {
 				log.Info("Send amount is 0, skipping to StatusDone")
-				di, err = s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+				updatedDi, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
 					di.Status = StatusDone
 					di.Error = ErrEmptySendAmount.Error()
 					return di
 				})
 				if err != nil {
 					log.WithError(err).Error("Update DepositInfo set StatusDone failed")
-					return di, err
+					return di, NewStoreWriteErr(err)
 				}
+				di = updatedDi
 
 				log.WithError(ErrEmptySendAmount).Info("DepositInfo set to StatusDone")
 
@@ -308,24 +844,58 @@ func (s *Send) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 			return di, err
 		}
 
+		rate, err := appliedRate(di)
+		if err != nil {
+			log.WithError(err).Error("appliedRate failed")
+			return di, err
+		}
+
+		value, err := s.calculateSkyValue(di)
+		if err != nil {
+			log.WithError(err).Error("calculateSkyValue failed")
+			return di, err
+		}
+
 		// Within a bolt.DB transaction, update the db then send the coins
 		// If the send fails, the data is rolled back
 		// If the db save fails, no coins had been sent
-		di, err = s.store.UpdateDepositInfoCallback(di.DepositID, func(di DepositInfo) DepositInfo {
+		var broadcastErr error
+		var broadcast bool
+		conversionRate := di.ConversionRate
+		updatedDi, err := s.store.UpdateDepositInfoCallback(di.DepositID, func(di DepositInfo) DepositInfo {
+			// The deposit may have been set aside or repriced while its transaction was created
+			if di.Status != StatusWaitSend || di.ConversionRate != conversionRate {
+				return di
+			}
 			di.Status = StatusWaitConfirm
 			di.Txid = skyTx.TxIDHex()
-			di.SkySent = skySent
+			di.SkySent = ownSkySent(di, value, skySent)
+			di.CoinHourStrategy = string(opt.CoinHourStrategy)
+			di.AppliedRate = rate
+			di.RoundingDroplets = value.RoundingDroplets
+			di.Memo = opt.Memo
 			return di
 		}, func(di DepositInfo) error {
+			switch di.Status {
+			case StatusWaitConfirm:
+			case StatusWaitSend:
+				return ErrDepositRepriced
+			default:
+				return ErrDepositStatusChanged
+			}
+
 			// NOTE: broadcastTransaction retries indefinitely on error
 			// If the skycoin node is not reachable, this will block,
 			// which will also block the database since it's in a transaction
 			rsp, err := s.broadcastTransaction(skyTx)
 			if err != nil {
 				log.WithError(err).Error("broadcastTransaction failed")
+				broadcastErr = err
 				return err
 			}
 
+			broadcast = true
+
 			// Invariant assertion: do not return this as an error, since
 			// coins have been sent. This should never occur.
 			if rsp.Txid != skyTx.TxIDHex() {
@@ -336,9 +906,25 @@ func (s *Send) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 		})
 
 		if err != nil {
-			log.WithError(err).Error("store.UpdateDepositInfoCallback failed")
-			return di, err
+			switch {
+			case broadcast:
+				// The coins were sent, but the db transaction failed to commit.
+				// The deposit would be sent again if it were retried.
+				log.WithError(err).WithField("txid", skyTx.TxIDHex()).Error("CRITICAL ERROR: Coins were sent but the deposit could not be saved")
+				return di, ErrSentNotRecorded
+			case err == broadcastErr, err == ErrDepositStatusChanged, err == ErrDepositRepriced:
+				log.WithError(err).Error("store.UpdateDepositInfoCallback failed")
+				return di, err
+			default:
+				log.WithError(err).Error("store.UpdateDepositInfoCallback failed")
+				return di, NewStoreWriteErr(err)
+			}
 		}
+		di = updatedDi
+
+		s.metrics.Counter("teller_sky_sent_droplets_total", "SKY sent, in droplets", nil).Add(float64(skySent))
+		s.recordSend(skySent)
+		s.recordRounding(di, value)
 
 		log.Info("DepositInfo set to StatusWaitConfirm")
 
@@ -359,20 +945,33 @@ func (s *Send) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 		}
 
 		if !rsp.Confirmed {
+			// The deposit was last updated when the transaction was broadcast
+			if s.cfg.ConfirmationTimeout > 0 && s.now().Sub(time.Unix(di.UpdatedAt, 0)) >= s.cfg.ConfirmationTimeout {
+				return s.setStuck(di)
+			}
+
 			log.Info("Transaction is not confirmed yet")
 			return di, ErrNotConfirmed
 		}
 
 		log.Info("Transaction is confirmed")
 
-		di, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		// The deposit was last updated when the transaction was broadcast
+		sentAt := time.Unix(di.UpdatedAt, 0)
+
+		updatedDi, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
 			di.Status = StatusDone
 			return di
 		})
 		if err != nil {
 			log.WithError(err).Error("UpdateDepositInfo set StatusDone failed")
-			return di, err
+			return di, NewStoreWriteErr(err)
 		}
+		di = updatedDi
+
+		// The txid is attached as an exemplar, linking slow confirmations to their transaction
+		confirmation := s.metrics.Histogram("teller_confirmation_seconds", "Time from broadcasting a transaction to its confirmation", metrics.DefaultBuckets, nil)
+		metrics.ObserveWithExemplar(confirmation, s.now().Sub(sentAt).Seconds(), metrics.Labels{"txid": di.Txid})
 
 		log.Info("DepositInfo status set to StatusDone")
 
@@ -396,32 +995,300 @@ func (s *Send) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 	}
 }
 
+// reloadRate updates the rate of a deposit from the store, in case it was repriced since it was queued,
+// see Exchange.RepricePending
+func (s *Send) reloadRate(di DepositInfo) (DepositInfo, error) {
+	saved, err := s.store.GetDepositInfo(di.DepositID)
+	if err != nil {
+		s.log.WithError(err).WithField("depositInfo", di).Error("GetDepositInfo failed")
+		return di, err
+	}
+
+	di.ConversionRate = saved.ConversionRate
+	di.RateTier = saved.RateTier
+
+	return di, nil
+}
+
+// holdForKYC moves a StatusWaitSend deposit whose send amount is greater than sky_exchanger.kyc_threshold_sky
+// to StatusKYCHold, unless the KYC of its deposit address was cleared. Returns true if the deposit was held
+func (s *Send) holdForKYC(di DepositInfo) (DepositInfo, bool, error) {
+	if s.kycThreshold == 0 {
+		return di, false, nil
+	}
+
+	log := s.log.WithField("depositInfo", di)
+
+	amt, err := s.sendAmount(di)
+	if err != nil {
+		log.WithError(err).Error("sendAmount failed")
+		return di, false, err
+	}
+
+	if amt <= s.kycThreshold {
+		return di, false, nil
+	}
+
+	updatedDi, err := s.store.HoldForKYC(di.DepositID, ErrKYCHold.Error())
+	switch err {
+	case nil:
+	case ErrKYCCleared:
+		return di, false, nil
+	case ErrDepositStatusInvalid:
+		// The deposit was set aside since it was loaded
+		return di, false, ErrDepositStatusChanged
+	default:
+		log.WithError(err).Error("HoldForKYC failed")
+		return di, false, NewStoreWriteErr(err)
+	}
+
+	setAsideMetric(s.metrics, StatusKYCHold).Inc()
+
+	log.WithField("alert", "kyc_hold").WithField("sendAmtDroplets", amt).Warn("ALERT: Deposit is greater than the KYC threshold. The deposit is set to StatusKYCHold until its deposit address KYC is cleared by an operator.")
+
+	return updatedDi, true, nil
+}
+
+// setStuck moves a StatusWaitConfirm deposit whose transaction was not confirmed within the confirmation timeout to StatusStuck
+func (s *Send) setStuck(di DepositInfo) (DepositInfo, error) {
+	log := s.log.WithField("depositInfo", di)
+
+	updatedDi, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusStuck
+		di.Error = ErrConfirmationTimeout.Error()
+		return di
+	})
+	if err != nil {
+		log.WithError(err).Error("UpdateDepositInfo set StatusStuck failed")
+		return di, NewStoreWriteErr(err)
+	}
+
+	setAsideMetric(s.metrics, StatusStuck).Inc()
+
+	log.WithField("alert", "stuck").WithField("confirmationTimeout", s.cfg.ConfirmationTimeout).Error("ALERT: Transaction was not confirmed within the confirmation timeout. The deposit is set to StatusStuck and must be investigated.")
+
+	return updatedDi, nil
+}
+
 func (s *Send) calculateSkyDroplets(di DepositInfo) (uint64, error) {
+	v, err := s.calculateSkyValue(di)
+	if err != nil {
+		return 0, err
+	}
+
+	return v.Droplets, nil
+}
+
+// calculateSkyValue returns the SKY to give for a deposit, rounded by sky_exchanger.rounding_mode
+func (s *Send) calculateSkyValue(di DepositInfo) (SkyValue, error) {
 	log := s.log
 	var err error
-	var skyAmt uint64
+	var v SkyValue
 	switch di.CoinType {
 	case scanner.CoinTypeBTC:
-		skyAmt, err = CalculateBtcSkyValue(di.DepositValue, di.ConversionRate, s.cfg.MaxDecimals)
+		v, err = CalculateBtcSkyValueRounded(di.DepositValue, di.ConversionRate, s.cfg.MaxDecimals, s.cfg.RoundingMode)
 		if err != nil {
-			log.WithError(err).Error("CalculateBtcSkyValue failed")
-			return 0, err
+			log.WithError(err).Error("CalculateBtcSkyValueRounded failed")
+			return SkyValue{}, err
 		}
 	case scanner.CoinTypeETH:
 		//Gwei convert to wei, because stored-value is Gwei in case overflow of uint64
-		skyAmt, err = CalculateEthSkyValue(mathutil.Gwei2Wei(di.DepositValue), di.ConversionRate, s.cfg.MaxDecimals)
+		v, err = CalculateEthSkyValueRounded(mathutil.Gwei2Wei(di.DepositValue), di.ConversionRate, s.cfg.MaxDecimals, s.cfg.RoundingMode)
 		if err != nil {
-			log.WithError(err).Error("CalculateEthSkyValue failed")
-			return 0, err
+			log.WithError(err).Error("CalculateEthSkyValueRounded failed")
+			return SkyValue{}, err
 		}
 	default:
 		log.WithError(scanner.ErrUnsupportedCoinType).Error()
-		return 0, scanner.ErrUnsupportedCoinType
+		return SkyValue{}, scanner.ErrUnsupportedCoinType
 	}
+	return v, nil
+}
+
+// recordRounding records the rounding applied to a sent deposit. A significant loss is logged,
+// since the rates and sky_exchanger.max_decimals round away SKY worth a unit of the deposit's coin
+func (s *Send) recordRounding(di DepositInfo, v SkyValue) {
+	if v.RoundingDroplets == 0 {
+		return
+	}
+
+	direction := config.RoundingDown
+	droplets := -v.RoundingDroplets
+	if v.RoundingDroplets > 0 {
+		direction = config.RoundingUp
+		droplets = v.RoundingDroplets
+	}
+
+	s.metrics.Counter("teller_rounding_droplets_total", "SKY added or taken from sends by rounding to sky_exchanger.max_decimals, in droplets", metrics.Labels{
+		"direction": direction,
+	}).Add(float64(droplets))
+
+	if !v.SignificantLoss {
+		return
+	}
+
+	s.metrics.Counter("teller_rounding_loss_deposits_total", "Deposits that lost SKY worth at least one unit of their coin to rounding", metrics.Labels{
+		"coin_type": di.CoinType,
+	}).Inc()
+
+	s.log.WithFields(logrus.Fields{
+		"depositID":        di.DepositID,
+		"depositValue":     di.DepositValue,
+		"conversionRate":   di.ConversionRate,
+		"roundingDroplets": v.RoundingDroplets,
+	}).Warning("Rounding to sky_exchanger.max_decimals took SKY worth at least one unit of the deposit's coin")
+}
+
+// warnRoundingLoss logs a warning for each configured rate at which rounding SKY to sky_exchanger.max_decimals
+// can take SKY worth at least one unit of the deposit's coin, e.g. a satoshi. At such rates, deposits that differ by
+// a few units are given the same SKY
+func warnRoundingLoss(log logrus.FieldLogger, cfg config.SkyExchanger) error {
+	type configuredRate struct {
+		name         string
+		rate         string
+		unitsPerCoin int64
+		unit         string
+	}
+
+	var rates []configuredRate
+	if cfg.TestMode {
+		rates = []configuredRate{
+			{"sky_exchanger.test_mode_rate", cfg.TestModeRate, SatoshisPerBTC, "satoshis"},
+			{"sky_exchanger.test_mode_rate", cfg.TestModeRate, GweiPerETH, "gwei"},
+		}
+	} else {
+		rates = append(rates, configuredRate{"sky_exchanger.sky_btc_exchange_rate", cfg.SkyBtcExchangeRate, SatoshisPerBTC, "satoshis"})
+		for i, t := range cfg.SkyBtcRateTiers {
+			rates = append(rates, configuredRate{fmt.Sprintf("sky_exchanger.sky_btc_rate_tiers[%d].rate", i), t.Rate, SatoshisPerBTC, "satoshis"})
+		}
+		rates = append(rates, configuredRate{"sky_exchanger.sky_eth_exchange_rate", cfg.SkyEthExchangeRate, GweiPerETH, "gwei"})
+	}
+
+	one := decimal.New(1, 0)
+	for _, r := range rates {
+		loss, err := MaxRoundingLoss(r.rate, r.unitsPerCoin, cfg.MaxDecimals, cfg.RoundingMode)
+		if err != nil {
+			return err
+		}
+
+		if loss.LessThan(one) {
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"rate":         r.name,
+			"maxDecimals":  cfg.MaxDecimals,
+			"roundingMode": cfg.RoundingMode,
+			"maxLoss":      loss.StringFixed(0) + " " + r.unit,
+		}).Warning("Rounding SKY to sky_exchanger.max_decimals can take SKY worth at least one unit of the deposit's coin at this rate. Increase max_decimals, or set rounding_mode to \"up\"")
+	}
+
+	return nil
+}
+
+// appliedRate returns the rate that calculateSkyDroplets applies to a deposit, as a precise decimal string
+func appliedRate(di DepositInfo) (string, error) {
+	rate, err := mathutil.ParseRate(di.ConversionRate)
+	if err != nil {
+		return "", err
+	}
+
+	return rate.String(), nil
+}
+
+// sendOption returns the options of a deposit's send
+func (s *Send) sendOption(di DepositInfo) (sender.SendOption, error) {
+	var strategy sender.CoinHourStrategy
+	if s.coinHourStrategy != nil {
+		strategy = s.coinHourStrategy(di)
+	}
+
+	if strategy == "" {
+		strategy = sender.CoinHourStrategy(s.cfg.CoinHourStrategy)
+	}
+
+	if strategy == "" {
+		strategy = sender.CoinHourStrategyShare
+	}
+
+	if err := sender.ValidateCoinHourStrategy(strategy); err != nil {
+		return sender.SendOption{}, err
+	}
+
+	var memo string
+	if s.memo != nil {
+		memo = s.memo(di)
+	} else if s.cfg.SendMemo != "" {
+		memo = expandMemo(s.cfg.SendMemo, di)
+	}
+
+	if err := sender.ValidateMemo(s.sender, memo); err != nil {
+		return sender.SendOption{}, err
+	}
+
+	return sender.SendOption{
+		CoinHourStrategy: strategy,
+		Memo:             memo,
+	}, nil
+}
+
+// supportsMemo returns true if s can tag transactions with a memo
+func supportsMemo(s sender.Sender) bool {
+	_, ok := s.(sender.MemoSender)
+	return ok
+}
+
+// expandMemo replaces the placeholders of a sky_exchanger.send_memo template with the deposit's fields
+func expandMemo(template string, di DepositInfo) string {
+	return strings.NewReplacer(
+		"{deposit_id}", di.DepositID,
+		"{deposit_address}", di.DepositAddress,
+		"{sky_address}", di.SkyAddress,
+		"{coin_type}", di.CoinType,
+	).Replace(template)
+}
+
+// sendAmount returns the droplets to send for a deposit. The coins of merged deposits are sent
+// in the same transaction, each at its own rate
+func (s *Send) sendAmount(di DepositInfo) (uint64, error) {
+	log := s.log.WithField("deposit", di)
+
+	skyAmt, err := s.calculateSkyDroplets(di)
+	if err != nil {
+		log.WithError(err).Error("calculateSkyDroplets failed")
+		return 0, err
+	}
+
+	for _, id := range di.MergedDeposits {
+		mergedDi, err := s.store.GetDepositInfo(id)
+		if err != nil {
+			log.WithError(err).WithField("mergedDepositID", id).Error("GetDepositInfo of merged deposit failed")
+			return 0, err
+		}
+
+		mergedAmt, err := s.calculateSkyDroplets(mergedDi)
+		if err != nil {
+			log.WithError(err).WithField("mergedDepositID", id).Error("calculateSkyDroplets of merged deposit failed")
+			return 0, err
+		}
+
+		skyAmt += mergedAmt
+	}
+
 	return skyAmt, nil
 }
 
-func (s *Send) createTransaction(di DepositInfo) (*coin.Transaction, error) {
+// ownSkySent returns the SkySent of a deposit whose transaction output has sent droplets, v being the deposit's own value.
+// The output of a deposit that merged others includes their coins, which they record in their own SkySent,
+// see updateMergedDeposits, so that the coins are counted once
+func ownSkySent(di DepositInfo, v SkyValue, sent uint64) uint64 {
+	if len(di.MergedDeposits) == 0 {
+		return sent
+	}
+	return v.Droplets
+}
+
+func (s *Send) createTransaction(di DepositInfo, opt sender.SendOption) (*coin.Transaction, error) {
 	log := s.log.WithField("deposit", di)
 
 	// This should never occur, the DepositInfo is saved with a SkyAddress
@@ -436,11 +1303,11 @@ func (s *Send) createTransaction(di DepositInfo) (*coin.Transaction, error) {
 	log = log.WithField("skyRate", di.ConversionRate)
 	log = log.WithField("maxDecimals", s.cfg.MaxDecimals)
 
-	skyAmt, err := s.calculateSkyDroplets(di)
+	skyAmt, err := s.sendAmount(di)
 	if err != nil {
-		log.WithError(err).Error("calculateSkyDroplets failed")
 		return nil, err
 	}
+
 	skyAmtCoins, err := droplet.ToString(skyAmt)
 	if err != nil {
 		log.WithError(err).Error("droplet.ToString failed")
@@ -450,6 +1317,8 @@ func (s *Send) createTransaction(di DepositInfo) (*coin.Transaction, error) {
 	log = log.WithField("sendAmtDroplets", skyAmt)
 	log = log.WithField("sendAmtCoins", skyAmtCoins)
 
+	log = log.WithField("coinHourStrategy", opt.CoinHourStrategy)
+
 	log.Info("Creating skycoin transaction")
 
 	if skyAmt == 0 {
@@ -458,7 +1327,7 @@ func (s *Send) createTransaction(di DepositInfo) (*coin.Transaction, error) {
 		return nil, err
 	}
 
-	tx, err := s.sender.CreateTransaction(di.SkyAddress, skyAmt)
+	tx, err := s.sender.CreateTransaction(di.SkyAddress, skyAmt, opt)
 	if err != nil {
 		log.WithError(err).Error("sender.CreateTransaction failed")
 		return nil, err
@@ -517,6 +1386,12 @@ func (s *Send) broadcastTransaction(tx *coin.Transaction) (*sender.BroadcastTxRe
 	}
 
 	if rsp.Err != nil {
+		// Keep RPCErrors as they are, their cause is recorded on the deposit
+		if _, ok := rsp.Err.(sender.RPCError); ok {
+			log.WithError(rsp.Err).Error("Send skycoin failed")
+			return nil, rsp.Err
+		}
+
 		err := fmt.Errorf("Send skycoin failed: %v", rsp.Err)
 		log.WithError(err).Error(err)
 		return nil, err
@@ -527,6 +1402,43 @@ func (s *Send) broadcastTransaction(tx *coin.Transaction) (*sender.BroadcastTxRe
 	return rsp, nil
 }
 
+// sendErrorCode maps an error returned by the sender to a SendErrorCode.
+// It returns an empty code if err did not come from the sender
+func sendErrorCode(err error) SendErrorCode {
+	switch e := err.(type) {
+	case sender.InvalidAddressErr:
+		return SendErrorInvalidAddress
+	case sender.RPCError:
+		switch e.Kind {
+		case sender.RPCErrorInsufficientBalance:
+			return SendErrorInsufficientBalance
+		case sender.RPCErrorNodeUnreachable:
+			return SendErrorNodeUnreachable
+		case sender.RPCErrorTxRejected:
+			return SendErrorTxRejected
+		default:
+			return SendErrorUnknown
+		}
+	default:
+		return ""
+	}
+}
+
+// recordSendError saves code as the LastSendErrorCode of the deposits, after their send failed.
+// The code is informational, so a failure to save it is only logged
+func (s *Send) recordSendError(depositIDs []string, code SendErrorCode) {
+	log := s.log.WithField("sendErrorCode", code)
+
+	if _, err := s.store.UpdateDepositInfosCallback(depositIDs, func(di DepositInfo) DepositInfo {
+		di.LastSendErrorCode = code
+		return di
+	}, func([]DepositInfo) error {
+		return nil
+	}); err != nil {
+		log.WithError(err).WithField("depositIDs", depositIDs).Error("Failed to record the send error code")
+	}
+}
+
 // Balance returns the number of coins left in the OTC wallet
 func (s *Send) Balance() (*cli.Balance, error) {
 	return s.sender.Balance()
@@ -0,0 +1,200 @@
+package exchange
+
+import (
+	"sort"
+	"time"
+
+	"github.com/skycoin/teller/src/metrics"
+)
+
+// rateLimitReleaseInterval is how often StatusRateLimited deposits are checked against the send allowance
+const rateLimitReleaseInterval = time.Minute
+
+// sendAllowanceUsed returns the droplets sent within the current sky_exchanger.send_allowance_window,
+// and updates the teller_send_allowance_used_droplets metric
+func (s *Send) sendAllowanceUsed() (uint64, error) {
+	used, err := s.store.GetSentSince(s.now().Add(-s.cfg.SendAllowanceWindow))
+	if err != nil {
+		s.log.WithError(err).Error("GetSentSince failed")
+		return 0, err
+	}
+
+	s.allowanceUsedMetric().Set(float64(used))
+
+	return used, nil
+}
+
+func (s *Send) allowanceUsedMetric() metrics.Gauge {
+	return s.metrics.Gauge("teller_send_allowance_used_droplets", "SKY sent within the current send allowance window, in droplets", nil)
+}
+
+// allowanceFits returns true if amt droplets can be sent without exceeding sky_exchanger.send_allowance_sky
+func (s *Send) allowanceFits(amt uint64) (bool, error) {
+	if s.sendAllowance == 0 {
+		return true, nil
+	}
+
+	used, err := s.sendAllowanceUsed()
+	if err != nil {
+		return false, err
+	}
+
+	return used <= s.sendAllowance && amt <= s.sendAllowance-used, nil
+}
+
+// limitSend moves a StatusWaitSend deposit whose send amount would exceed sky_exchanger.send_allowance_sky
+// within the current window to StatusRateLimited. Returns true if the deposit was rate limited
+func (s *Send) limitSend(di DepositInfo) (DepositInfo, bool, error) {
+	if s.sendAllowance == 0 {
+		return di, false, nil
+	}
+
+	log := s.log.WithField("depositInfo", di)
+
+	amt, err := s.sendAmount(di)
+	if err != nil {
+		log.WithError(err).Error("sendAmount failed")
+		return di, false, err
+	}
+
+	fits, err := s.allowanceFits(amt)
+	if err != nil {
+		return di, false, err
+	}
+
+	if fits {
+		return di, false, nil
+	}
+
+	updatedDi, err := s.store.MarkRateLimited(di.DepositID, ErrSendAllowanceExceeded.Error())
+	switch err {
+	case nil:
+	case ErrDepositStatusInvalid:
+		// The deposit was set aside since it was loaded
+		return di, false, ErrDepositStatusChanged
+	default:
+		log.WithError(err).Error("MarkRateLimited failed")
+		return di, false, NewStoreWriteErr(err)
+	}
+
+	s.metrics.Counter("teller_deposits_rate_limited_total", "Deposits that waited for room in the send allowance", nil).Inc()
+
+	log = log.WithField("sendAmtDroplets", amt).WithField("sendAllowanceDroplets", s.sendAllowance)
+	if amt > s.sendAllowance {
+		log.WithField("alert", "rate_limited").Error("ALERT: Deposit is greater than the whole send allowance and will never be sent. It must be rejected by an operator, or sky_exchanger.send_allowance_sky raised.")
+	} else {
+		log.Warn("Deposit would exceed the send allowance. The deposit is set to StatusRateLimited until the send allowance window has room for it.")
+	}
+
+	return updatedDi, true, nil
+}
+
+// recordSend records the droplets of a broadcast transaction in the send ledger, for the send allowance.
+// The send was already saved, so a failure to record it is only logged
+func (s *Send) recordSend(amt uint64) {
+	if s.sendAllowance == 0 {
+		return
+	}
+
+	now := s.now()
+	if err := s.store.RecordSend(amt, now, now.Add(-s.cfg.SendAllowanceWindow)); err != nil {
+		s.log.WithError(err).WithField("sendAmtDroplets", amt).Error("RecordSend failed, the send does not count towards the send allowance")
+		return
+	}
+
+	// Refresh the metric. A failure is logged by sendAllowanceUsed
+	_, _ = s.sendAllowanceUsed() // nolint: errcheck
+}
+
+// ReleaseRateLimited returns the StatusRateLimited deposits that fit in the send allowance, oldest first,
+// to StatusWaitSend and queues them to be sent. Deposits that don't fit wait for the next check.
+// It returns the deposits that were released
+func (s *Send) ReleaseRateLimited() ([]DepositInfo, error) {
+	if s.sendAllowance == 0 {
+		return nil, nil
+	}
+
+	if s.gate.frozen() {
+		return nil, ErrFrozen
+	}
+
+	dis, err := s.store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return di.Status == StatusRateLimited
+	})
+	if err != nil {
+		s.log.WithError(err).Error("GetDepositInfoArray failed")
+		return nil, err
+	}
+
+	if len(dis) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(dis, func(i, j int) bool {
+		return dis[i].StatusUpdatedAt < dis[j].StatusUpdatedAt
+	})
+
+	used, err := s.sendAllowanceUsed()
+	if err != nil {
+		return nil, err
+	}
+
+	var released []DepositInfo
+	for _, di := range dis {
+		log := s.log.WithField("depositInfo", di)
+
+		amt, err := s.sendAmount(di)
+		if err != nil {
+			log.WithError(err).Error("sendAmount failed")
+			continue
+		}
+
+		if used > s.sendAllowance || amt > s.sendAllowance-used {
+			continue
+		}
+
+		updatedDi, err := s.store.ReleaseRateLimited(di.DepositID)
+		switch err {
+		case nil:
+		case ErrDepositStatusInvalid:
+			// The deposit was rejected since it was loaded
+			continue
+		default:
+			log.WithError(err).Error("ReleaseRateLimited failed")
+			return released, err
+		}
+
+		// Leave room for the released deposits, so that they are not rate limited again
+		used += amt
+		released = append(released, updatedDi)
+
+		if err := s.Requeue(updatedDi); err != nil {
+			return released, err
+		}
+
+		log.WithField("sendAmtDroplets", amt).Info("Send allowance has room for the deposit, it is set to StatusWaitSend")
+	}
+
+	return released, nil
+}
+
+// runRateLimitRelease calls ReleaseRateLimited every rateLimitReleaseInterval
+func (s *Send) runRateLimitRelease() {
+	log := s.log.WithField("goroutine", "runRateLimitRelease")
+
+	ticker := time.NewTicker(rateLimitReleaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			log.Info("quit")
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.ReleaseRateLimited(); err != nil && err != ErrFrozen && err != ErrRequeueClosed {
+			log.WithError(err).Error("ReleaseRateLimited failed")
+		}
+	}
+}
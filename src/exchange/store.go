@@ -1,23 +1,34 @@
 package exchange
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/sirupsen/logrus"
 
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/metrics"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/util/dbutil"
 )
 
 var (
-	// ExchangeMetaBkt stores metadata about the exchange (unused)
+	// ExchangeMetaBkt stores metadata about the exchange, such as the schema version
 	ExchangeMetaBkt = []byte("exchange_meta")
 
+	// freezeKey is the key of the FreezeState in ExchangeMetaBkt
+	freezeKey = "freeze"
+
 	// DepositInfoBkt maps a BTC transaction to a DepositInfo
 	DepositInfoBkt = []byte("deposit_info")
 
@@ -27,8 +38,38 @@ var (
 	// SkyDepositSeqsIndexBkt maps a SKY address to its BTC addresses
 	SkyDepositSeqsIndexBkt = []byte("sky_deposit_seqs_index")
 
+	// DeadLetterBkt maps a DepositID to a DeadLetter
+	DeadLetterBkt = []byte("dead_letter")
+
+	// ReviewAuditBkt maps a sequence number to a ReviewAudit
+	ReviewAuditBkt = []byte("review_audit")
+
+	// KYCClearedBkt maps a deposit address whose KYC was cleared to a KYCClearance
+	KYCClearedBkt = []byte("kyc_cleared")
+
+	// SendLedgerBkt maps a sequence number to a SendRecord, for the sends within the send allowance window
+	SendLedgerBkt = []byte("send_ledger")
+
+	// DepositHistoryBkt maps a DepositID to its DepositEvents, oldest first
+	DepositHistoryBkt = []byte("deposit_history")
+
 	// ErrAddressAlreadyBound is returned if an address has already been bound to a SKY address
 	ErrAddressAlreadyBound = errors.New("Address already bound to a SKY address")
+
+	// ErrDeadLetterNotFound is returned if no pending dead letter exists for a deposit
+	ErrDeadLetterNotFound = errors.New("Dead letter not found")
+
+	// ErrDepositNotInReview is returned if a review action is taken on a deposit that is not held for review
+	ErrDepositNotInReview = errors.New("Deposit is not waiting for review")
+
+	// ErrInvalidReviewAction is returned if a review action is not ReviewActionApprove or ReviewActionReject
+	ErrInvalidReviewAction = errors.New("Invalid review action")
+
+	// ErrKYCCleared is returned if a deposit is held for KYC but its deposit address's KYC was already cleared
+	ErrKYCCleared = errors.New("Deposit address KYC is cleared")
+
+	// errRollback rolls back the db transaction of CheckReadWrite
+	errRollback = errors.New("rollback")
 )
 
 const bindAddressBktPrefix = "bind_address"
@@ -74,19 +115,68 @@ func init() {
 type Storer interface {
 	GetBindAddress(depositAddr, coinType string) (*BoundAddress, error)
 	BindAddress(skyAddr, depositAddr, coinType, buyMethod string) (*BoundAddress, error)
-	GetOrCreateDepositInfo(scanner.Deposit, string) (DepositInfo, error)
+	BindAddresses(skyAddr string, depositAddrs []string, coinType, buyMethod string) ([]BoundAddress, error)
+	GetOrCreateDepositInfo(scanner.Deposit, string, string) (DepositInfo, error)
+	GetDepositInfo(string) (DepositInfo, error)
 	GetDepositInfoArray(DepositFilter) ([]DepositInfo, error)
+	ForEachDepositInfo(DepositFilter, func(DepositInfo) error) error
 	GetDepositInfoOfSkyAddress(string) ([]DepositInfo, error)
+	GetDepositInfosOfSkyAddresses([]string) (map[string][]DepositInfo, error)
+	GetDepositHistory(string) ([]DepositEvent, error)
 	UpdateDepositInfo(string, func(DepositInfo) DepositInfo) (DepositInfo, error)
 	UpdateDepositInfoCallback(string, func(DepositInfo) DepositInfo, func(DepositInfo) error) (DepositInfo, error)
+	UpdateDepositInfosCallback([]string, func(DepositInfo) DepositInfo, func([]DepositInfo) error) ([]DepositInfo, error)
 	GetSkyBindAddresses(string) ([]BoundAddress, error)
+	GetBoundDepositAddresses(string) ([]string, error)
 	GetDepositStats() (int64, int64, error)
+	GetOverview() (Overview, error)
+	AddDeadLetter(DepositInfo, string) (DeadLetter, error)
+	GetDeadLetters() ([]DeadLetter, error)
+	ResolveDeadLetter(string, string, func(DeadLetter) error) (DeadLetter, error)
+	RestoreDeadLetter(string) (DeadLetter, error)
+	SubscribeStatus() (<-chan StatusEvent, func())
+	HoldForReview(string, string) (DepositInfo, error)
+	HoldForKYC(string, string) (DepositInfo, error)
+	ClearKYC(string, string) ([]DepositInfo, error)
+	RepricePending(coinType, rate, operator, reason string) ([]DepositInfo, error)
+	MarkStuckSend(string, string) (DepositInfo, error)
+	MarkRateLimited(string, string) (DepositInfo, error)
+	ReleaseRateLimited(string) (DepositInfo, error)
+	RecordSend(uint64, time.Time, time.Time) error
+	GetSentSince(time.Time) (uint64, error)
+	ReviewDeposit(string, ReviewAction, string, string) (DepositInfo, error)
+	GetReviewAudits() ([]ReviewAudit, error)
+	PruneReviewAudits(time.Time) (int, error)
+	PurgeTestData() (int, error)
+	GetFreezeState() (FreezeState, error)
+	SetFreezeState(bool, string, string) (FreezeState, error)
+	WriteSnapshot(io.Writer) (int64, error)
+	MergeDeposits(string, []string) (DepositInfo, error)
+	CheckReadWrite() error
 }
 
 // Store storage for exchange
 type Store struct {
-	db  *bolt.DB
-	log logrus.FieldLogger
+	db         *bolt.DB
+	log        logrus.FieldLogger
+	statusFeed *StatusFeed // publishes deposit status changes
+	timer      *dbutil.TxTimer
+	// derivations looks up how deposit addresses were derived when binding, nil if unknown
+	derivations DerivationGetter
+	// now returns the time that deposits and audits are stamped with. Tests replace it to control the timestamps
+	now func() time.Time
+	// historyLimit is the number of status changes kept in each deposit's history. No history is recorded if 0
+	historyLimit int
+	// testMode tags the deposits it saves as test data
+	testMode bool
+	// snapshotTempFileSize is the database size in bytes above which WriteSnapshot copies the database
+	// to a temp file before writing it. Snapshots are always written directly if 0
+	snapshotTempFileSize int64
+}
+
+// DerivationGetter looks up how a deposit address was derived, e.g. an addrs.AddrManager
+type DerivationGetter interface {
+	Derivation(coinType, addr string) *addrs.Derivation
 }
 
 // NewStore creates a Store instance
@@ -122,22 +212,78 @@ func NewStore(log logrus.FieldLogger, db *bolt.DB) (*Store, error) {
 			return dbutil.NewCreateBucketFailedErr(BtcTxsBkt, err)
 		}
 
-		return nil
+		if _, err := tx.CreateBucketIfNotExists(DeadLetterBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(DeadLetterBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(ReviewAuditBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(ReviewAuditBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(KYCClearedBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(KYCClearedBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(SendLedgerBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(SendLedgerBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(DepositHistoryBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(DepositHistoryBkt, err)
+		}
+
+		return migrateTx(tx)
 	}); err != nil {
 		return nil, err
 	}
 
 	return &Store{
-		db:  db,
-		log: log.WithField("prefix", "exchange.Store"),
+		db:         db,
+		log:        log.WithField("prefix", "exchange.Store"),
+		statusFeed: NewStatusFeed(),
+		timer:      dbutil.NewTxTimer(nil),
+		now:        time.Now,
 	}, nil
 }
 
+// SetMetrics sets where the store records the wait and hold times of its db transactions.
+// It must be called before the store is used
+func (s *Store) SetMetrics(m metrics.Metrics) {
+	s.timer = dbutil.NewTxTimer(m)
+}
+
+// SetHistoryLimit sets how many status changes are kept in each deposit's history, see GetDepositHistory.
+// The oldest are dropped. No history is recorded if 0. It must be called before the store is used
+func (s *Store) SetHistoryLimit(n int) {
+	s.historyLimit = n
+}
+
+// SetSnapshotTempFileSize sets the database size in bytes above which WriteSnapshot copies the database
+// to a temp file first, see WriteSnapshot. Snapshots are always written directly if 0.
+// It must be called before the store is used
+func (s *Store) SetSnapshotTempFileSize(n int64) {
+	s.snapshotTempFileSize = n
+}
+
+// SetTestMode sets whether the deposits saved by the store are tagged as test data, see DepositInfo.TestMode.
+// It must be called before the store is used
+func (s *Store) SetTestMode(testMode bool) {
+	s.testMode = testMode
+}
+
+// SetDerivationGetter sets how the store looks up the derivation info recorded when binding
+// deposit addresses. It must be called before the store is used
+func (s *Store) SetDerivationGetter(g DerivationGetter) {
+	s.derivations = g
+}
+
 // GetBindAddress returns bound skycoin address of given bitcoin address.
+// A skycoin address may be bound to many deposit addresses, but each deposit
+// address is bound to a single skycoin address.
 // If no skycoin address is found, returns empty string and nil error.
 func (s *Store) GetBindAddress(depositAddr, coinType string) (*BoundAddress, error) {
 	var boundAddr *BoundAddress
-	if err := s.db.View(func(tx *bolt.Tx) error {
+	if err := s.timer.View(s.db, "GetBindAddress", func(tx *bolt.Tx) error {
 		var err error
 		boundAddr, err = s.getBindAddressTx(tx, depositAddr, coinType)
 		return err
@@ -168,10 +314,23 @@ func (s *Store) getBindAddressTx(tx *bolt.Tx, depositAddr, coinType string) (*Bo
 	}
 }
 
-// BindAddress binds a skycoin address to a deposit address
+// BindAddress binds a skycoin address to a deposit address.
+// The skycoin address may already be bound to other deposit addresses.
 func (s *Store) BindAddress(skyAddr, depositAddr, coinType, buyMethod string) (*BoundAddress, error) {
+	boundAddrs, err := s.BindAddresses(skyAddr, []string{depositAddr}, coinType, buyMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boundAddrs[0], nil
+}
+
+// BindAddresses binds a skycoin address to multiple deposit addresses in one transaction.
+// The binding is atomic: if any deposit address is already bound, ErrAddressAlreadyBound
+// is returned and no addresses are bound.
+func (s *Store) BindAddresses(skyAddr string, depositAddrs []string, coinType, buyMethod string) ([]BoundAddress, error) {
 	log := s.log.WithField("skyAddr", skyAddr)
-	log = log.WithField("depositAddr", depositAddr)
+	log = log.WithField("depositAddrs", depositAddrs)
 	log = log.WithField("coinType", coinType)
 	log = log.WithField("buyMethod", buyMethod)
 
@@ -180,23 +339,38 @@ func (s *Store) BindAddress(skyAddr, depositAddr, coinType, buyMethod string) (*
 		return nil, err
 	}
 
-	boundAddr := BoundAddress{
-		SkyAddress: skyAddr,
-		Address:    depositAddr,
-		CoinType:   coinType,
-		BuyMethod:  buyMethod,
+	boundAddrs := make([]BoundAddress, 0, len(depositAddrs))
+	for _, depositAddr := range depositAddrs {
+		var derivation *addrs.Derivation
+		if s.derivations != nil {
+			derivation = s.derivations.Derivation(coinType, depositAddr)
+		}
+
+		boundAddrs = append(boundAddrs, BoundAddress{
+			SkyAddress: skyAddr,
+			Address:    depositAddr,
+			CoinType:   coinType,
+			BuyMethod:  buyMethod,
+			Derivation: derivation,
+		})
 	}
 
-	if err := s.db.Update(func(tx *bolt.Tx) error {
-		existingSkyAddr, err := s.getBindAddressTx(tx, depositAddr, coinType)
-		if err != nil {
-			return err
-		}
+	if err := s.timer.Update(s.db, "BindAddresses", func(tx *bolt.Tx) error {
+		for _, boundAddr := range boundAddrs {
+			existingSkyAddr, err := s.getBindAddressTx(tx, boundAddr.Address, coinType)
+			if err != nil {
+				return err
+			}
 
-		if existingSkyAddr != nil {
-			err := ErrAddressAlreadyBound
-			log.WithError(err).Error("Attempted to bind an address twice")
-			return err
+			if existingSkyAddr != nil {
+				err := ErrAddressAlreadyBound
+				log.WithError(err).WithField("depositAddr", boundAddr.Address).Error("Attempted to bind an address twice")
+				return err
+			}
+
+			if err := dbutil.PutBucketValue(tx, bindBktFullName, boundAddr.Address, boundAddr); err != nil {
+				return err
+			}
 		}
 
 		// Update index of skycoin address and the deposit seq
@@ -209,28 +383,28 @@ func (s *Store) BindAddress(skyAddr, depositAddr, coinType, buyMethod string) (*
 			}
 		}
 
-		addrs = append(addrs, boundAddr)
+		addrs = append(addrs, boundAddrs...)
 
-		if err := dbutil.PutBucketValue(tx, SkyDepositSeqsIndexBkt, skyAddr, addrs); err != nil {
-			return err
-		}
-
-		return dbutil.PutBucketValue(tx, bindBktFullName, depositAddr, boundAddr)
+		return dbutil.PutBucketValue(tx, SkyDepositSeqsIndexBkt, skyAddr, addrs)
 	}); err != nil {
 		return nil, err
 	}
 
-	return &boundAddr, nil
+	return boundAddrs, nil
 }
 
 // GetOrCreateDepositInfo creates a DepositInfo unless one exists with the DepositInfo.DepositID key,
 // in which case it returns the existing DepositInfo.
-func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (DepositInfo, error) {
+// rate and rateTier are the conversion rate and rate tier applied to a new DepositInfo.
+func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate, rateTier string) (DepositInfo, error) {
 	log := s.log.WithField("deposit", dv)
 	log = log.WithField("rate", rate)
+	log = log.WithField("rateTier", rateTier)
 
 	var finalDepositInfo DepositInfo
-	if err := s.db.Update(func(tx *bolt.Tx) error {
+	var created bool
+	if err := s.timer.Update(s.db, "GetOrCreateDepositInfo", func(tx *bolt.Tx) error {
+		created = false
 		di, err := s.getDepositInfoTx(tx, dv.ID())
 
 		switch err.(type) {
@@ -272,14 +446,24 @@ func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (Deposit
 				DepositAddress: dv.Address,
 				SkyAddress:     boundAddr.SkyAddress,
 				BuyMethod:      boundAddr.BuyMethod,
+				Derivation:     boundAddr.Derivation,
 				DepositID:      dv.ID(),
 				Status:         StatusWaitDecide,
 				DepositValue:   dv.Value,
 				// Save the rate at the time this deposit was noticed
 				ConversionRate: rate,
+				RateTier:       rateTier,
 				Deposit:        dv,
 			}
 
+			// A deposit with no value can't be exchanged (e.g. a scanner bug or an OP_RETURN-only tx).
+			// Record it as invalid so that it is not processed
+			if dv.Value <= 0 {
+				log.Warn("Deposit value is zero or negative, saving as StatusInvalid")
+				di.Status = StatusInvalid
+				di.Error = ErrInvalidDepositValue.Error()
+			}
+
 			log = log.WithField("depositInfo", di)
 
 			updatedDi, err := s.addDepositInfoTx(tx, di)
@@ -290,6 +474,7 @@ func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (Deposit
 			}
 
 			finalDepositInfo = updatedDi
+			created = true
 
 			return nil
 
@@ -302,6 +487,10 @@ func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (Deposit
 		return DepositInfo{}, err
 	}
 
+	if created {
+		s.statusFeed.Publish(NewStatusEvent(finalDepositInfo))
+	}
+
 	return finalDepositInfo, nil
 
 }
@@ -309,7 +498,7 @@ func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (Deposit
 // addDepositInfo adds deposit info into storage, return seq or error
 func (s *Store) addDepositInfo(di DepositInfo) (DepositInfo, error) {
 	var updatedDi DepositInfo
-	if err := s.db.Update(func(tx *bolt.Tx) error {
+	if err := s.timer.Update(s.db, "addDepositInfo", func(tx *bolt.Tx) error {
 		var err error
 		updatedDi, err = s.addDepositInfoTx(tx, di)
 		return err
@@ -337,15 +526,19 @@ func (s *Store) addDepositInfoTx(tx *bolt.Tx, di DepositInfo) (DepositInfo, erro
 	}
 
 	updatedDi := di
+	updatedDi.SchemaVersion = SchemaVersion
 	updatedDi.Seq = seq
-	updatedDi.UpdatedAt = time.Now().UTC().Unix()
+	updatedDi.UpdatedAt = s.now().UTC().Unix()
+	updatedDi.StatusUpdatedAt = updatedDi.UpdatedAt
+	updatedDi.CreatedAt = updatedDi.UpdatedAt
+	updatedDi.TestMode = s.testMode
 
 	if err := updatedDi.ValidateForStatus(); err != nil {
 		log.WithError(err).Error("FIXME: Constructed invalid DepositInfo")
 		return di, err
 	}
 
-	if err := dbutil.PutBucketValue(tx, DepositInfoBkt, updatedDi.DepositID, updatedDi); err != nil {
+	if err := s.putDepositInfoTx(tx, updatedDi); err != nil {
 		return di, err
 	}
 
@@ -367,11 +560,103 @@ func (s *Store) addDepositInfoTx(tx *bolt.Tx, di DepositInfo) (DepositInfo, erro
 	return updatedDi, nil
 }
 
-// getDepositInfo returns depsoit info of given address
-func (s *Store) getDepositInfo(btcTx string) (DepositInfo, error) {
+// putDepositInfoTx saves a deposit. If it is new or its status changed, the change is added to its history
+func (s *Store) putDepositInfoTx(tx *bolt.Tx, di DepositInfo) error {
+	if s.historyLimit > 0 {
+		var prev DepositInfo
+		err := dbutil.GetBucketObject(tx, DepositInfoBkt, di.DepositID, &prev)
+		switch err.(type) {
+		case nil:
+		case dbutil.ObjectNotExistErr:
+			prev.Status = StatusUnknown
+		default:
+			return err
+		}
+
+		if prev.Status != di.Status {
+			if err := s.addDepositEventTx(tx, di); err != nil {
+				return err
+			}
+		}
+	}
+
+	return dbutil.PutBucketValue(tx, DepositInfoBkt, di.DepositID, di)
+}
+
+// addDepositEventTx appends the deposit's current status to its history, dropping the oldest events beyond historyLimit
+func (s *Store) addDepositEventTx(tx *bolt.Tx, di DepositInfo) error {
+	var events []DepositEvent
+	if err := dbutil.GetBucketObject(tx, DepositHistoryBkt, di.DepositID, &events); err != nil {
+		switch err.(type) {
+		case dbutil.ObjectNotExistErr:
+		default:
+			return err
+		}
+	}
+
+	events = append(events, DepositEvent{
+		DepositID: di.DepositID,
+		Status:    di.Status.String(),
+		Reason:    di.Error,
+		Txid:      di.Txid,
+		CreatedAt: s.now().UTC().Unix(),
+	})
+
+	if len(events) > s.historyLimit {
+		events = events[len(events)-s.historyLimit:]
+	}
+
+	return dbutil.PutBucketValue(tx, DepositHistoryBkt, di.DepositID, events)
+}
+
+// GetDepositHistory returns the status changes of the deposits to a deposit address, oldest first.
+// Returns no events if the address has no deposits
+func (s *Store) GetDepositHistory(depositAddr string) ([]DepositEvent, error) {
+	var history []DepositEvent
+
+	if err := s.timer.View(s.db, "GetDepositHistory", func(tx *bolt.Tx) error {
+		var txns []string
+		if err := dbutil.GetBucketObject(tx, BtcTxsBkt, depositAddr, &txns); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		for _, txn := range txns {
+			var events []DepositEvent
+			if err := dbutil.GetBucketObject(tx, DepositHistoryBkt, txn, &events); err != nil {
+				switch err.(type) {
+				case dbutil.ObjectNotExistErr:
+					continue
+				default:
+					return err
+				}
+			}
+
+			history = append(history, events...)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// The deposits' events are interleaved in time, events of the same time keep their order
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].CreatedAt < history[j].CreatedAt
+	})
+
+	return history, nil
+}
+
+// GetDepositInfo returns the deposit info of a given deposit ID
+func (s *Store) GetDepositInfo(btcTx string) (DepositInfo, error) {
 	var di DepositInfo
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.timer.View(s.db, "GetDepositInfo", func(tx *bolt.Tx) error {
 		var err error
 		di, err = s.getDepositInfoTx(tx, btcTx)
 		return err
@@ -395,7 +680,7 @@ func (s *Store) getDepositInfoTx(tx *bolt.Tx, btcTx string) (DepositInfo, error)
 func (s *Store) GetDepositInfoArray(flt DepositFilter) ([]DepositInfo, error) {
 	var dpis []DepositInfo
 
-	if err := s.db.View(func(tx *bolt.Tx) error {
+	if err := s.timer.View(s.db, "GetDepositInfoArray", func(tx *bolt.Tx) error {
 		return dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
 			var dpi DepositInfo
 			if err := json.Unmarshal(v, &dpi); err != nil {
@@ -415,49 +700,102 @@ func (s *Store) GetDepositInfoArray(flt DepositFilter) ([]DepositInfo, error) {
 	return dpis, nil
 }
 
-// GetDepositInfoOfSkyAddress returns all deposit info that are bound
-// to the given skycoin address
-func (s *Store) GetDepositInfoOfSkyAddress(skyAddr string) ([]DepositInfo, error) {
-	var dpis []DepositInfo
-
-	if err := s.db.View(func(tx *bolt.Tx) error {
-		// TODO: DB queries in a loop, may need restructuring for performance
-		boundAddrs, err := s.getSkyBindAddressesTx(tx, skyAddr)
-		if err != nil {
-			return err
-		}
+// depositInfoPageSize is the number of deposits ForEachDepositInfo reads per transaction
+var depositInfoPageSize = 500
+
+// ForEachDepositInfo calls f with each deposit info matched by flt, in deposit ID order.
+// The deposits are read in pages, each in its own read-only transaction, and f is called between them,
+// so that a slow f, e.g. writing to a slow client, does not hold a transaction open.
+// A deposit that changes meanwhile is seen as it was when its page was read.
+func (s *Store) ForEachDepositInfo(flt DepositFilter, f func(DepositInfo) error) error {
+	var after []byte
+	for {
+		var page []DepositInfo
+		var last []byte
+		n := 0
+
+		if err := s.timer.View(s.db, "ForEachDepositInfo", func(tx *bolt.Tx) error {
+			bkt := tx.Bucket(DepositInfoBkt)
+			if bkt == nil {
+				return dbutil.NewBucketNotExistErr(DepositInfoBkt)
+			}
 
-		for _, boundAddr := range boundAddrs {
-			var txns []string
-			if err := dbutil.GetBucketObject(tx, BtcTxsBkt, boundAddr.Address, &txns); err != nil {
-				switch err.(type) {
-				case dbutil.ObjectNotExistErr:
-				default:
-					return err
+			c := bkt.Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if bytes.Equal(k, after) {
+					k, v = c.Next()
 				}
 			}
 
-			// If this db has no DepositInfo records yet, it means the scanner
-			// has not sent a deposit to the exchange, so the status is
-			// StatusWaitDeposit.
-			if len(txns) == 0 {
-				dpis = append(dpis, DepositInfo{
-					Status:         StatusWaitDeposit,
-					DepositAddress: boundAddr.Address,
-					SkyAddress:     skyAddr,
-					UpdatedAt:      time.Now().UTC().Unix(),
-					CoinType:       boundAddr.CoinType,
-				})
-			}
+			for ; k != nil && n < depositInfoPageSize; k, v = c.Next() {
+				n++
+				last = append([]byte(nil), k...)
 
-			for _, txn := range txns {
 				var dpi DepositInfo
-				if err := dbutil.GetBucketObject(tx, DepositInfoBkt, txn, &dpi); err != nil {
+				if err := json.Unmarshal(v, &dpi); err != nil {
 					return err
 				}
 
-				dpis = append(dpis, dpi)
+				if flt(dpi) {
+					page = append(page, dpi)
+				}
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, dpi := range page {
+			if err := f(dpi); err != nil {
+				return err
+			}
+		}
+
+		if n < depositInfoPageSize {
+			return nil
+		}
+
+		after = last
+	}
+}
+
+// GetDepositInfoOfSkyAddress returns all deposit info that are bound
+// to the given skycoin address
+func (s *Store) GetDepositInfoOfSkyAddress(skyAddr string) ([]DepositInfo, error) {
+	var dpis []DepositInfo
+
+	if err := s.timer.View(s.db, "GetDepositInfoOfSkyAddress", func(tx *bolt.Tx) error {
+		var err error
+		dpis, err = s.getDepositInfoOfSkyAddressTx(tx, skyAddr)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return dpis, nil
+}
+
+// GetDepositInfosOfSkyAddresses returns the deposit info bound to each of the given
+// skycoin addresses, read in one db transaction. Every address is a key of the result,
+// addresses with no bound deposit address have no deposit info
+func (s *Store) GetDepositInfosOfSkyAddresses(skyAddrs []string) (map[string][]DepositInfo, error) {
+	dpis := make(map[string][]DepositInfo, len(skyAddrs))
+
+	if err := s.timer.View(s.db, "GetDepositInfosOfSkyAddresses", func(tx *bolt.Tx) error {
+		for _, skyAddr := range skyAddrs {
+			if _, ok := dpis[skyAddr]; ok {
+				continue
+			}
+
+			d, err := s.getDepositInfoOfSkyAddressTx(tx, skyAddr)
+			if err != nil {
+				return err
 			}
+
+			dpis[skyAddr] = d
 		}
 
 		return nil
@@ -465,6 +803,52 @@ func (s *Store) GetDepositInfoOfSkyAddress(skyAddr string) ([]DepositInfo, error
 		return nil, err
 	}
 
+	return dpis, nil
+}
+
+// getDepositInfoOfSkyAddressTx returns all deposit info that are bound to the given skycoin address,
+// sorted by update time and numbered by Seq
+func (s *Store) getDepositInfoOfSkyAddressTx(tx *bolt.Tx, skyAddr string) ([]DepositInfo, error) {
+	// TODO: DB queries in a loop, may need restructuring for performance
+	boundAddrs, err := s.getSkyBindAddressesTx(tx, skyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var dpis []DepositInfo
+	for _, boundAddr := range boundAddrs {
+		var txns []string
+		if err := dbutil.GetBucketObject(tx, BtcTxsBkt, boundAddr.Address, &txns); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+			default:
+				return nil, err
+			}
+		}
+
+		// If this db has no DepositInfo records yet, it means the scanner
+		// has not sent a deposit to the exchange, so the status is
+		// StatusWaitDeposit.
+		if len(txns) == 0 {
+			dpis = append(dpis, DepositInfo{
+				Status:         StatusWaitDeposit,
+				DepositAddress: boundAddr.Address,
+				SkyAddress:     skyAddr,
+				UpdatedAt:      s.now().UTC().Unix(),
+				CoinType:       boundAddr.CoinType,
+			})
+		}
+
+		for _, txn := range txns {
+			var dpi DepositInfo
+			if err := dbutil.GetBucketObject(tx, DepositInfoBkt, txn, &dpi); err != nil {
+				return nil, err
+			}
+
+			dpis = append(dpis, dpi)
+		}
+	}
+
 	// sort the dpis by update time
 	sort.Slice(dpis, func(i, j int) bool {
 		return dpis[i].UpdatedAt < dpis[j].UpdatedAt
@@ -479,7 +863,7 @@ func (s *Store) GetDepositInfoOfSkyAddress(skyAddr string) ([]DepositInfo, error
 }
 
 // UpdateDepositInfo updates deposit info. The update func takes a DepositInfo
-// and returns a modified copy of it.
+// and returns a modified copy of it. Illegal status transitions are rejected with ErrInvalidStatusTransition.
 func (s *Store) UpdateDepositInfo(btcTx string, update func(DepositInfo) DepositInfo) (DepositInfo, error) {
 	return s.UpdateDepositInfoCallback(btcTx, update, func(di DepositInfo) error { return nil })
 }
@@ -488,44 +872,141 @@ func (s *Store) UpdateDepositInfo(btcTx string, update func(DepositInfo) Deposit
 // and returns a modified copy of it.  After updating the DepositInfo, it calls callback,
 // inside of the transaction.  If the callback returns an error, the DepositInfo update
 // is rolled back.
+// If the update changes the status in a way the deposit state machine does not allow,
+// ErrInvalidStatusTransition is returned and nothing is saved.
 func (s *Store) UpdateDepositInfoCallback(btcTx string, update func(DepositInfo) DepositInfo, callback func(DepositInfo) error) (DepositInfo, error) {
-	log := s.log.WithField("btcTx", btcTx)
-
 	var dpi DepositInfo
-	if err := s.db.Update(func(tx *bolt.Tx) error {
-		if err := dbutil.GetBucketObject(tx, DepositInfoBkt, btcTx, &dpi); err != nil {
+	var prevStatus Status
+	if err := s.timer.Update(s.db, "UpdateDepositInfoCallback", func(tx *bolt.Tx) error {
+		var err error
+		dpi, prevStatus, err = s.updateDepositInfoTx(tx, btcTx, update)
+		if err != nil {
 			return err
 		}
 
-		log = log.WithField("depositInfo", dpi)
+		return callback(dpi)
 
-		if dpi.DepositID != btcTx {
-			log.Error("DepositInfo.DepositID does not match btcTx")
-			err := fmt.Errorf("DepositInfo %+v saved under different key %s", dpi, btcTx)
-			return err
-		}
+	}); err != nil {
+		return DepositInfo{}, err
+	}
 
-		dpi = update(dpi)
-		dpi.UpdatedAt = time.Now().UTC().Unix()
+	if dpi.Status != prevStatus {
+		s.statusFeed.Publish(NewStatusEvent(dpi))
+	}
 
-		if err := dbutil.PutBucketValue(tx, DepositInfoBkt, btcTx, dpi); err != nil {
-			return err
+	return dpi, nil
+}
+
+// UpdateDepositInfosCallback updates several deposit infos in one db transaction, like UpdateDepositInfoCallback.
+// After updating every DepositInfo, it calls callback with the updated deposits, inside of the transaction.
+// If any update is an illegal status transition, or the callback returns an error, no DepositInfo is updated.
+func (s *Store) UpdateDepositInfosCallback(btcTxs []string, update func(DepositInfo) DepositInfo, callback func([]DepositInfo) error) ([]DepositInfo, error) {
+	dpis := make([]DepositInfo, len(btcTxs))
+	prevStatuses := make([]Status, len(btcTxs))
+	if err := s.timer.Update(s.db, "UpdateDepositInfosCallback", func(tx *bolt.Tx) error {
+		for i, btcTx := range btcTxs {
+			var err error
+			dpis[i], prevStatuses[i], err = s.updateDepositInfoTx(tx, btcTx, update)
+			if err != nil {
+				return err
+			}
 		}
 
-		return callback(dpi)
+		return callback(dpis)
 
 	}); err != nil {
+		return nil, err
+	}
+
+	for i, dpi := range dpis {
+		if dpi.Status != prevStatuses[i] {
+			s.statusFeed.Publish(NewStatusEvent(dpi))
+		}
+	}
+
+	return dpis, nil
+}
+
+// updateDepositInfoTx applies update to the deposit info btcTx and saves it, returning the updated
+// deposit info and its previous status
+func (s *Store) updateDepositInfoTx(tx *bolt.Tx, btcTx string, update func(DepositInfo) DepositInfo) (DepositInfo, Status, error) {
+	log := s.log.WithField("btcTx", btcTx)
+
+	var dpi DepositInfo
+	if err := dbutil.GetBucketObject(tx, DepositInfoBkt, btcTx, &dpi); err != nil {
+		return DepositInfo{}, StatusUnknown, err
+	}
+
+	prevStatus := dpi.Status
+
+	log = log.WithField("depositInfo", dpi)
+
+	if dpi.DepositID != btcTx {
+		log.Error("DepositInfo.DepositID does not match btcTx")
+		err := fmt.Errorf("DepositInfo %+v saved under different key %s", dpi, btcTx)
+		return DepositInfo{}, StatusUnknown, err
+	}
+
+	dpi = update(dpi)
+
+	if !canTransition(prevStatus, dpi.Status) {
+		log.WithFields(logrus.Fields{
+			"fromStatus": prevStatus.String(),
+			"toStatus":   dpi.Status.String(),
+		}).Error("Illegal deposit status transition")
+		return DepositInfo{}, StatusUnknown, ErrInvalidStatusTransition
+	}
+
+	dpi.SchemaVersion = SchemaVersion
+	dpi.UpdatedAt = s.now().UTC().Unix()
+	if dpi.Status != prevStatus {
+		dpi.StatusUpdatedAt = dpi.UpdatedAt
+	}
+
+	if err := s.putDepositInfoTx(tx, dpi); err != nil {
+		return DepositInfo{}, StatusUnknown, err
+	}
+
+	return dpi, prevStatus, nil
+}
+
+// setStatusTx moves a deposit to status, records reason in DepositInfo.Error, and saves it. Returns ErrDepositStatusInvalid,
+// and saves nothing, if the deposit is already in status, if the deposit state machine does not allow the change,
+// or if the deposit is merged into another deposit, which it follows
+func (s *Store) setStatusTx(tx *bolt.Tx, di DepositInfo, status Status, reason string) (DepositInfo, error) {
+	if di.Status == status || !canTransition(di.Status, status) || di.MergedInto != "" {
+		s.log.WithFields(logrus.Fields{
+			"depositID":  di.DepositID,
+			"fromStatus": di.Status.String(),
+			"toStatus":   status.String(),
+		}).Debug("Deposit status can't be set")
+		return DepositInfo{}, ErrDepositStatusInvalid
+	}
+
+	di.Status = status
+	di.Error = reason
+	di.SchemaVersion = SchemaVersion
+	di.UpdatedAt = s.now().UTC().Unix()
+	di.StatusUpdatedAt = di.UpdatedAt
+
+	if err := s.putDepositInfoTx(tx, di); err != nil {
 		return DepositInfo{}, err
 	}
 
-	return dpi, nil
+	return di, nil
+}
+
+// SubscribeStatus returns a channel of deposit status changes and a function to unsubscribe.
+// See StatusFeed for the delivery guarantees.
+func (s *Store) SubscribeStatus() (<-chan StatusEvent, func()) {
+	return s.statusFeed.Subscribe()
 }
 
 // GetSkyBindAddresses returns the addresses of the given sky address bound
 func (s *Store) GetSkyBindAddresses(skyAddr string) ([]BoundAddress, error) {
 	var boundAddrs []BoundAddress
 
-	if err := s.db.View(func(tx *bolt.Tx) error {
+	if err := s.timer.View(s.db, "GetSkyBindAddresses", func(tx *bolt.Tx) error {
 		var err error
 		boundAddrs, err = s.getSkyBindAddressesTx(tx, skyAddr)
 		return err
@@ -554,16 +1035,40 @@ func (s *Store) getSkyBindAddressesTx(tx *bolt.Tx, skyAddr string) ([]BoundAddre
 	return addrs, nil
 }
 
-// GetDepositStats returns BTC received and SKY sent
-func (s *Store) GetDepositStats() (int64, int64, error) {
-	var totalBTCReceived int64
-	var totalSKYSent int64
+// GetBoundDepositAddresses returns every deposit address of coinType that is bound to a skycoin address
+func (s *Store) GetBoundDepositAddresses(coinType string) ([]string, error) {
+	bindBktFullName, err := GetBindAddressBkt(coinType)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := s.db.View(func(tx *bolt.Tx) error {
-		return dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
-			var dpi DepositInfo
-			if err := json.Unmarshal(v, &dpi); err != nil {
-				return err
+	var depositAddrs []string
+	if err := s.timer.View(s.db, "GetBoundDepositAddresses", func(tx *bolt.Tx) error {
+		return dbutil.ForEach(tx, bindBktFullName, func(k, v []byte) error {
+			depositAddrs = append(depositAddrs, string(k))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return depositAddrs, nil
+}
+
+// GetDepositStats returns BTC received and SKY sent. Test mode deposits are not counted
+func (s *Store) GetDepositStats() (int64, int64, error) {
+	var totalBTCReceived int64
+	var totalSKYSent int64
+
+	if err := s.timer.View(s.db, "GetDepositStats", func(tx *bolt.Tx) error {
+		return dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
+			var dpi DepositInfo
+			if err := json.Unmarshal(v, &dpi); err != nil {
+				return err
+			}
+
+			if !countedInStats(dpi) {
+				return nil
 			}
 
 			if dpi.CoinType == scanner.CoinTypeBTC {
@@ -579,3 +1084,981 @@ func (s *Store) GetDepositStats() (int64, int64, error) {
 
 	return totalBTCReceived, totalSKYSent, nil
 }
+
+// countedInStats returns true if a deposit is counted in the deposit stats
+func countedInStats(di DepositInfo) bool {
+	// Invalid and unexpected deposits were never exchanged
+	if di.Status == StatusInvalid || di.Status == StatusUnexpectedDeposit {
+		return false
+	}
+
+	// Test mode deposits are not production volume
+	return !di.TestMode
+}
+
+// GetOverview returns a summary of the deposits and dead letters, read in one db transaction
+func (s *Store) GetOverview() (Overview, error) {
+	ov := Overview{
+		Deposits: make(map[string]int),
+	}
+
+	if err := s.timer.View(s.db, "GetOverview", func(tx *bolt.Tx) error {
+		if err := dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
+			var di DepositInfo
+			if err := json.Unmarshal(v, &di); err != nil {
+				return err
+			}
+
+			ov.Deposits[di.Status.String()]++
+
+			if inReview(di.Status) {
+				ov.PendingReview++
+			}
+
+			if !countedInStats(di) {
+				return nil
+			}
+
+			if di.CoinType == scanner.CoinTypeBTC {
+				ov.TotalBTCReceived += di.DepositValue
+			}
+			ov.TotalSKYSent += int64(di.SkySent)
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if err := dbutil.ForEach(tx, DeadLetterBkt, func(k, v []byte) error {
+			var dl DeadLetter
+			if err := json.Unmarshal(v, &dl); err != nil {
+				return err
+			}
+
+			if dl.Pending {
+				ov.PendingDeadLetters++
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		ov.DBSize = tx.Size()
+		return nil
+	}); err != nil {
+		return Overview{}, err
+	}
+
+	return ov, nil
+}
+
+// AddDeadLetter records a deposit that failed processing. If the deposit
+// was dead-lettered before, its attempt count is incremented and the entry
+// becomes pending again.
+func (s *Store) AddDeadLetter(di DepositInfo, reason string) (DeadLetter, error) {
+	var dl DeadLetter
+	if err := s.timer.Update(s.db, "AddDeadLetter", func(tx *bolt.Tx) error {
+		if err := dbutil.GetBucketObject(tx, DeadLetterBkt, di.DepositID, &dl); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+				dl = DeadLetter{
+					DepositID: di.DepositID,
+					CreatedAt: s.now().UTC().Unix(),
+				}
+			default:
+				return err
+			}
+		}
+
+		dl.Reason = reason
+		dl.Attempts++
+		dl.Pending = true
+		dl.UpdatedAt = s.now().UTC().Unix()
+		dl.DepositInfo = di
+
+		return dbutil.PutBucketValue(tx, DeadLetterBkt, di.DepositID, dl)
+	}); err != nil {
+		return DeadLetter{}, err
+	}
+
+	return dl, nil
+}
+
+// GetDeadLetters returns all pending dead letters, sorted by the time they
+// were last updated. The DepositInfo of each entry is refreshed from the
+// deposit info bucket.
+func (s *Store) GetDeadLetters() ([]DeadLetter, error) {
+	var dls []DeadLetter
+
+	if err := s.timer.View(s.db, "GetDeadLetters", func(tx *bolt.Tx) error {
+		return dbutil.ForEach(tx, DeadLetterBkt, func(k, v []byte) error {
+			var dl DeadLetter
+			if err := json.Unmarshal(v, &dl); err != nil {
+				return err
+			}
+
+			if !dl.Pending {
+				return nil
+			}
+
+			di, err := s.getDepositInfoTx(tx, dl.DepositID)
+			switch err.(type) {
+			case nil:
+				dl.DepositInfo = di
+			case dbutil.ObjectNotExistErr:
+			default:
+				return err
+			}
+
+			dls = append(dls, dl)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(dls, func(i, j int) bool {
+		return dls[i].UpdatedAt < dls[j].UpdatedAt
+	})
+
+	return dls, nil
+}
+
+// ResolveDeadLetter marks a pending dead letter as no longer pending, and records the operator's retry in the
+// review audit log. Before saving, it calls check inside of the transaction with the entry and its current DepositInfo.
+// If check returns an error, nothing is saved. check must not block, it holds the db writer lock.
+func (s *Store) ResolveDeadLetter(depositID, operator string, check func(DeadLetter) error) (DeadLetter, error) {
+	var dl DeadLetter
+	if err := s.timer.Update(s.db, "ResolveDeadLetter", func(tx *bolt.Tx) error {
+		if err := dbutil.GetBucketObject(tx, DeadLetterBkt, depositID, &dl); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+				return ErrDeadLetterNotFound
+			default:
+				return err
+			}
+		}
+
+		if !dl.Pending {
+			return ErrDeadLetterNotFound
+		}
+
+		di, err := s.getDepositInfoTx(tx, depositID)
+		if err != nil {
+			return err
+		}
+
+		dl.DepositInfo = di
+
+		if err := check(dl); err != nil {
+			return err
+		}
+
+		dl.Pending = false
+		dl.UpdatedAt = s.now().UTC().Unix()
+
+		if err := dbutil.PutBucketValue(tx, DeadLetterBkt, depositID, dl); err != nil {
+			return err
+		}
+
+		return addReviewAuditTx(tx, ReviewAudit{
+			DepositID: depositID,
+			Action:    ReviewActionRetry,
+			Operator:  operator,
+			CreatedAt: dl.UpdatedAt,
+		})
+	}); err != nil {
+		return DeadLetter{}, err
+	}
+
+	return dl, nil
+}
+
+// RestoreDeadLetter marks a resolved dead letter as pending again, e.g. if it could not be
+// resubmitted after it was resolved. Its attempt count is not changed.
+func (s *Store) RestoreDeadLetter(depositID string) (DeadLetter, error) {
+	var dl DeadLetter
+	if err := s.timer.Update(s.db, "RestoreDeadLetter", func(tx *bolt.Tx) error {
+		if err := dbutil.GetBucketObject(tx, DeadLetterBkt, depositID, &dl); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+				return ErrDeadLetterNotFound
+			default:
+				return err
+			}
+		}
+
+		dl.Pending = true
+		dl.UpdatedAt = s.now().UTC().Unix()
+
+		return dbutil.PutBucketValue(tx, DeadLetterBkt, depositID, dl)
+	}); err != nil {
+		return DeadLetter{}, err
+	}
+
+	return dl, nil
+}
+
+// HoldForReview moves a StatusWaitSend deposit to StatusWaitReview, where it waits
+// for an operator to approve or reject it. The reason is recorded in DepositInfo.Error.
+func (s *Store) HoldForReview(depositID, reason string) (DepositInfo, error) {
+	var di DepositInfo
+	if err := s.timer.Update(s.db, "HoldForReview", func(tx *bolt.Tx) error {
+		var err error
+		di, err = s.getDepositInfoTx(tx, depositID)
+		if err != nil {
+			return err
+		}
+
+		di, err = s.setStatusTx(tx, di, StatusWaitReview, reason)
+		return err
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	s.statusFeed.Publish(NewStatusEvent(di))
+
+	return di, nil
+}
+
+// HoldForKYC moves a StatusWaitSend deposit to StatusKYCHold, where it waits for an operator to clear
+// the KYC of its deposit address, and records the hold in the review audit log. The reason is recorded
+// in DepositInfo.Error. Returns ErrKYCCleared, leaving the deposit unchanged, if the deposit address's
+// KYC was already cleared. Deposits merged into another deposit follow that deposit, and can't be held.
+func (s *Store) HoldForKYC(depositID, reason string) (DepositInfo, error) {
+	var di DepositInfo
+	if err := s.timer.Update(s.db, "HoldForKYC", func(tx *bolt.Tx) error {
+		var err error
+		di, err = s.getDepositInfoTx(tx, depositID)
+		if err != nil {
+			return err
+		}
+
+		if cleared, err := dbutil.BucketHasKey(tx, KYCClearedBkt, di.DepositAddress); err != nil {
+			return err
+		} else if cleared {
+			return ErrKYCCleared
+		}
+
+		di, err = s.setStatusTx(tx, di, StatusKYCHold, reason)
+		if err != nil {
+			return err
+		}
+
+		return addReviewAuditTx(tx, ReviewAudit{
+			DepositID: depositID,
+			Action:    ReviewActionKYCHold,
+			Reason:    reason,
+			Address:   di.DepositAddress,
+			CreatedAt: di.UpdatedAt,
+		})
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	s.statusFeed.Publish(NewStatusEvent(di))
+
+	return di, nil
+}
+
+// ClearKYC records that an operator cleared the KYC of a deposit address, so that its deposits are no longer
+// held for KYC, and records the clearance in the review audit log. Its StatusKYCHold deposits return to StatusWaitSend.
+// Returns the released deposits
+func (s *Store) ClearKYC(depositAddr, operator string) ([]DepositInfo, error) {
+	var released []DepositInfo
+	if err := s.timer.Update(s.db, "ClearKYC", func(tx *bolt.Tx) error {
+		released = nil
+
+		var txns []string
+		if err := dbutil.GetBucketObject(tx, BtcTxsBkt, depositAddr, &txns); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+			default:
+				return err
+			}
+		}
+
+		for _, txn := range txns {
+			di, err := s.getDepositInfoTx(tx, txn)
+			if err != nil {
+				return err
+			}
+
+			if di.Status != StatusKYCHold {
+				continue
+			}
+
+			di, err = s.setStatusTx(tx, di, StatusWaitSend, "")
+			if err != nil {
+				return err
+			}
+
+			released = append(released, di)
+		}
+
+		now := s.now().UTC().Unix()
+
+		if err := dbutil.PutBucketValue(tx, KYCClearedBkt, depositAddr, KYCClearance{
+			Address:   depositAddr,
+			Operator:  operator,
+			CreatedAt: now,
+		}); err != nil {
+			return err
+		}
+
+		return addReviewAuditTx(tx, ReviewAudit{
+			Action:    ReviewActionKYCClear,
+			Operator:  operator,
+			Address:   depositAddr,
+			CreatedAt: now,
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, di := range released {
+		s.statusFeed.Publish(NewStatusEvent(di))
+	}
+
+	return released, nil
+}
+
+// MarkStuckSend moves a StatusWaitSend deposit that was not sent in time to StatusStuckSend, where it waits
+// for an operator to approve or reject it. The reason is recorded in DepositInfo.Error.
+// Deposits merged into another deposit follow that deposit, and can't be marked.
+func (s *Store) MarkStuckSend(depositID, reason string) (DepositInfo, error) {
+	var di DepositInfo
+	if err := s.timer.Update(s.db, "MarkStuckSend", func(tx *bolt.Tx) error {
+		var err error
+		di, err = s.getDepositInfoTx(tx, depositID)
+		if err != nil {
+			return err
+		}
+
+		di, err = s.setStatusTx(tx, di, StatusStuckSend, reason)
+		return err
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	s.statusFeed.Publish(NewStatusEvent(di))
+
+	return di, nil
+}
+
+// MarkRateLimited moves a StatusWaitSend deposit that would exceed the send allowance to StatusRateLimited,
+// where it waits for the allowance to have room for it. The reason is recorded in DepositInfo.Error.
+// Deposits merged into another deposit follow that deposit, and can't be marked.
+func (s *Store) MarkRateLimited(depositID, reason string) (DepositInfo, error) {
+	var di DepositInfo
+	if err := s.timer.Update(s.db, "MarkRateLimited", func(tx *bolt.Tx) error {
+		var err error
+		di, err = s.getDepositInfoTx(tx, depositID)
+		if err != nil {
+			return err
+		}
+
+		di, err = s.setStatusTx(tx, di, StatusRateLimited, reason)
+		return err
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	s.statusFeed.Publish(NewStatusEvent(di))
+
+	return di, nil
+}
+
+// ReleaseRateLimited returns a StatusRateLimited deposit to StatusWaitSend.
+// Returns ErrDepositStatusInvalid if the deposit is not StatusRateLimited
+func (s *Store) ReleaseRateLimited(depositID string) (DepositInfo, error) {
+	var di DepositInfo
+	if err := s.timer.Update(s.db, "ReleaseRateLimited", func(tx *bolt.Tx) error {
+		var err error
+		di, err = s.getDepositInfoTx(tx, depositID)
+		if err != nil {
+			return err
+		}
+
+		if di.Status != StatusRateLimited {
+			return ErrDepositStatusInvalid
+		}
+
+		di, err = s.setStatusTx(tx, di, StatusWaitSend, "")
+		return err
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	s.statusFeed.Publish(NewStatusEvent(di))
+
+	return di, nil
+}
+
+// RecordSend adds the droplets of a send to the send ledger, so that the send allowance
+// survives a restart. Records sent before pruneBefore no longer count and are deleted
+func (s *Store) RecordSend(droplets uint64, sentAt, pruneBefore time.Time) error {
+	return s.timer.Update(s.db, "RecordSend", func(tx *bolt.Tx) error {
+		var keys [][]byte
+		if err := dbutil.ForEach(tx, SendLedgerBkt, func(k, v []byte) error {
+			var r SendRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			if r.SentAt < pruneBefore.Unix() {
+				// Keys can't be deleted while iterating
+				keys = append(keys, append([]byte(nil), k...))
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		bkt := tx.Bucket(SendLedgerBkt)
+		for _, k := range keys {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		seq, err := dbutil.NextSequence(tx, SendLedgerBkt)
+		if err != nil {
+			return err
+		}
+
+		return dbutil.PutBucketValue(tx, SendLedgerBkt, strconv.FormatUint(seq, 10), SendRecord{
+			Droplets: droplets,
+			SentAt:   sentAt.Unix(),
+		})
+	})
+}
+
+// GetSentSince returns the droplets recorded in the send ledger that were sent at or after since
+func (s *Store) GetSentSince(since time.Time) (uint64, error) {
+	var sent uint64
+
+	if err := s.timer.View(s.db, "GetSentSince", func(tx *bolt.Tx) error {
+		return dbutil.ForEach(tx, SendLedgerBkt, func(k, v []byte) error {
+			var r SendRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			if r.SentAt >= since.Unix() {
+				sent += r.Droplets
+			}
+
+			return nil
+		})
+	}); err != nil {
+		return 0, err
+	}
+
+	return sent, nil
+}
+
+// MergeDeposits merges the deposits mergedIDs into the deposit primaryID, so that their coins are sent
+// in the primary deposit's transaction. Every deposit must be StatusWaitSend and not already merged,
+// else ErrDepositStatusInvalid is returned, and they must have the same coin type and skycoin address,
+// else ErrMergeMismatch is returned. All deposits are updated in one db transaction.
+func (s *Store) MergeDeposits(primaryID string, mergedIDs []string) (DepositInfo, error) {
+	var primary DepositInfo
+	if err := s.timer.Update(s.db, "MergeDeposits", func(tx *bolt.Tx) error {
+		var err error
+		primary, err = s.getDepositInfoTx(tx, primaryID)
+		if err != nil {
+			return err
+		}
+
+		if primary.Status != StatusWaitSend || primary.MergedInto != "" {
+			return ErrDepositStatusInvalid
+		}
+
+		now := s.now().UTC().Unix()
+
+		for _, id := range mergedIDs {
+			di, err := s.getDepositInfoTx(tx, id)
+			if err != nil {
+				return err
+			}
+
+			if id == primaryID || di.Status != StatusWaitSend || di.MergedInto != "" || len(di.MergedDeposits) != 0 {
+				return ErrDepositStatusInvalid
+			}
+
+			if di.CoinType != primary.CoinType || di.SkyAddress != primary.SkyAddress {
+				return ErrMergeMismatch
+			}
+
+			di.MergedInto = primaryID
+			di.SchemaVersion = SchemaVersion
+			di.UpdatedAt = now
+
+			if err := s.putDepositInfoTx(tx, di); err != nil {
+				return err
+			}
+		}
+
+		primary.MergedDeposits = append(primary.MergedDeposits, mergedIDs...)
+		primary.SchemaVersion = SchemaVersion
+		primary.UpdatedAt = now
+
+		return s.putDepositInfoTx(tx, primary)
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	return primary, nil
+}
+
+// ReviewDeposit applies an operator's decision to a deposit held for review, or stuck before it was sent, and
+// records it in the review audit log. An approved deposit returns to StatusWaitSend,
+// a rejected deposit moves to StatusRejected with the reason recorded in DepositInfo.Error, with the deposits merged into it.
+func (s *Store) ReviewDeposit(depositID string, action ReviewAction, operator, reason string) (DepositInfo, error) {
+	var di DepositInfo
+	var merged []DepositInfo
+	if err := s.timer.Update(s.db, "ReviewDeposit", func(tx *bolt.Tx) error {
+		merged = nil
+
+		var err error
+		di, err = s.getDepositInfoTx(tx, depositID)
+		if err != nil {
+			return err
+		}
+
+		if !inReview(di.Status) {
+			return ErrDepositNotInReview
+		}
+
+		switch action {
+		case ReviewActionApprove:
+			// KYC held deposits are sent once their deposit address's KYC is cleared, see ClearKYC.
+			// Rate limited deposits are sent once the send allowance has room for them, see Send.ReleaseRateLimited
+			if di.Status == StatusKYCHold || di.Status == StatusRateLimited {
+				return ErrDepositNotInReview
+			}
+			di, err = s.setStatusTx(tx, di, StatusWaitSend, "")
+		case ReviewActionReject:
+			di, err = s.setStatusTx(tx, di, StatusRejected, reason)
+		default:
+			return ErrInvalidReviewAction
+		}
+		if err != nil {
+			return err
+		}
+
+		// The deposits merged into a rejected deposit were not sent either, they are rejected with it
+		if di.Status == StatusRejected {
+			merged, err = s.rejectMergedTx(tx, di)
+			if err != nil {
+				return err
+			}
+		}
+
+		return addReviewAuditTx(tx, ReviewAudit{
+			DepositID: depositID,
+			Action:    action,
+			Operator:  operator,
+			Reason:    reason,
+			CreatedAt: di.UpdatedAt,
+		})
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	s.statusFeed.Publish(NewStatusEvent(di))
+	for _, mdi := range merged {
+		s.statusFeed.Publish(NewStatusEvent(mdi))
+	}
+
+	return di, nil
+}
+
+// rejectMergedTx rejects the deposits merged into primary that are still StatusWaitSend, with primary's reason
+func (s *Store) rejectMergedTx(tx *bolt.Tx, primary DepositInfo) ([]DepositInfo, error) {
+	var rejected []DepositInfo
+	for _, id := range primary.MergedDeposits {
+		di, err := s.getDepositInfoTx(tx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if di.Status != StatusWaitSend || di.MergedInto != primary.DepositID {
+			continue
+		}
+
+		di, _, err = s.updateDepositInfoTx(tx, id, func(di DepositInfo) DepositInfo {
+			di.Status = StatusRejected
+			di.Error = primary.Error
+			return di
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rejected = append(rejected, di)
+	}
+
+	return rejected, nil
+}
+
+// addReviewAuditTx appends audit to the review audit log, assigning its Seq
+func addReviewAuditTx(tx *bolt.Tx, audit ReviewAudit) error {
+	seq, err := dbutil.NextSequence(tx, ReviewAuditBkt)
+	if err != nil {
+		return err
+	}
+
+	audit.Seq = seq
+
+	return dbutil.PutBucketValue(tx, ReviewAuditBkt, strconv.FormatUint(seq, 10), audit)
+}
+
+// RepricePending sets the rate of every deposit of coinType whose SKY was not sent yet to rate, in one transaction,
+// and records each change in the review audit log. Deposits that were sent are not changed.
+// The SKY amount of a deposit is calculated from its rate when it is sent. Returns the repriced deposits
+func (s *Store) RepricePending(coinType, rate, operator, reason string) ([]DepositInfo, error) {
+	var repriced []DepositInfo
+	if err := s.timer.Update(s.db, "RepricePending", func(tx *bolt.Tx) error {
+		repriced = nil
+
+		if err := dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
+			var di DepositInfo
+			if err := json.Unmarshal(v, &di); err != nil {
+				return err
+			}
+
+			if di.CoinType == coinType && repriceable(di) && di.ConversionRate != rate {
+				repriced = append(repriced, di)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		now := s.now().UTC().Unix()
+
+		for i := range repriced {
+			di := &repriced[i]
+			prevRate := di.ConversionRate
+
+			di.ConversionRate = rate
+			// The rate no longer comes from a tier
+			di.RateTier = ""
+			di.SchemaVersion = SchemaVersion
+			di.UpdatedAt = now
+
+			if err := s.putDepositInfoTx(tx, *di); err != nil {
+				return err
+			}
+
+			if err := addReviewAuditTx(tx, ReviewAudit{
+				DepositID: di.DepositID,
+				Action:    ReviewActionReprice,
+				Operator:  operator,
+				Reason:    reason,
+				Rate:      rate,
+				PrevRate:  prevRate,
+				CreatedAt: now,
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return repriced, nil
+}
+
+// GetReviewAudits returns all review decisions, oldest first
+func (s *Store) GetReviewAudits() ([]ReviewAudit, error) {
+	var audits []ReviewAudit
+
+	if err := s.timer.View(s.db, "GetReviewAudits", func(tx *bolt.Tx) error {
+		return dbutil.ForEach(tx, ReviewAuditBkt, func(k, v []byte) error {
+			var audit ReviewAudit
+			if err := json.Unmarshal(v, &audit); err != nil {
+				return err
+			}
+
+			audits = append(audits, audit)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(audits, func(i, j int) bool {
+		return audits[i].Seq < audits[j].Seq
+	})
+
+	return audits, nil
+}
+
+// PruneReviewAudits deletes review decisions recorded before the given time, and returns the number deleted.
+// The reviewed deposits are not changed.
+func (s *Store) PruneReviewAudits(before time.Time) (int, error) {
+	var n int
+
+	if err := s.timer.Update(s.db, "PruneReviewAudits", func(tx *bolt.Tx) error {
+		var keys [][]byte
+		if err := dbutil.ForEach(tx, ReviewAuditBkt, func(k, v []byte) error {
+			var audit ReviewAudit
+			if err := json.Unmarshal(v, &audit); err != nil {
+				return err
+			}
+
+			if audit.CreatedAt < before.Unix() {
+				// Keys can't be deleted while iterating
+				keys = append(keys, append([]byte(nil), k...))
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		bkt := tx.Bucket(ReviewAuditBkt)
+		for _, k := range keys {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		n = len(keys)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// PurgeTestData deletes the test mode deposits that can't change status anymore, with their history and
+// dead letters, and removes them from their deposit address's deposits. Test mode deposits that are still
+// being processed are kept, so that the exchange doesn't lose track of them. Bound addresses and review audits are kept.
+// Returns the number of deposits deleted.
+func (s *Store) PurgeTestData() (int, error) {
+	var n int
+
+	if err := s.timer.Update(s.db, "PurgeTestData", func(tx *bolt.Tx) error {
+		var dis []DepositInfo
+		if err := dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
+			var di DepositInfo
+			if err := json.Unmarshal(v, &di); err != nil {
+				return err
+			}
+
+			// Deposits that can still change status may be in processing
+			if _, ok := statusTransitions[di.Status]; !di.TestMode || ok {
+				return nil
+			}
+
+			dis = append(dis, di)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Keys can't be deleted while iterating
+		for _, di := range dis {
+			for _, bktName := range [][]byte{DepositInfoBkt, DepositHistoryBkt, DeadLetterBkt} {
+				if err := tx.Bucket(bktName).Delete([]byte(di.DepositID)); err != nil {
+					return err
+				}
+			}
+
+			var txs []string
+			if err := dbutil.GetBucketObject(tx, BtcTxsBkt, di.DepositAddress, &txs); err != nil {
+				switch err.(type) {
+				case dbutil.ObjectNotExistErr:
+					continue
+				default:
+					return err
+				}
+			}
+
+			kept := txs[:0]
+			for _, txid := range txs {
+				if txid != di.DepositID {
+					kept = append(kept, txid)
+				}
+			}
+
+			if len(kept) == 0 {
+				if err := tx.Bucket(BtcTxsBkt).Delete([]byte(di.DepositAddress)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := dbutil.PutBucketValue(tx, BtcTxsBkt, di.DepositAddress, kept); err != nil {
+				return err
+			}
+		}
+
+		n = len(dis)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// GetFreezeState returns whether the exchange is frozen. It is not frozen if it was never frozen
+func (s *Store) GetFreezeState() (FreezeState, error) {
+	var state FreezeState
+
+	if err := s.timer.View(s.db, "GetFreezeState", func(tx *bolt.Tx) error {
+		err := dbutil.GetBucketObject(tx, ExchangeMetaBkt, freezeKey, &state)
+		switch err.(type) {
+		case nil:
+			return nil
+		case dbutil.ObjectNotExistErr:
+			state = FreezeState{}
+			return nil
+		default:
+			return err
+		}
+	}); err != nil {
+		return FreezeState{}, err
+	}
+
+	return state, nil
+}
+
+// SetFreezeState freezes or unfreezes the exchange, and records the operator's decision and reason
+// in the review audit log. Freezing a frozen exchange returns ErrFrozen, and unfreezing an exchange
+// that is not frozen returns ErrNotFrozen.
+func (s *Store) SetFreezeState(frozen bool, operator, reason string) (FreezeState, error) {
+	var state FreezeState
+
+	if err := s.timer.Update(s.db, "SetFreezeState", func(tx *bolt.Tx) error {
+		var prev FreezeState
+		err := dbutil.GetBucketObject(tx, ExchangeMetaBkt, freezeKey, &prev)
+		switch err.(type) {
+		case nil, dbutil.ObjectNotExistErr:
+		default:
+			return err
+		}
+
+		switch {
+		case frozen && prev.Frozen:
+			return ErrFrozen
+		case !frozen && !prev.Frozen:
+			return ErrNotFrozen
+		}
+
+		now := s.now().UTC().Unix()
+		state = FreezeState{
+			Frozen:    frozen,
+			Operator:  operator,
+			Reason:    reason,
+			UpdatedAt: now,
+		}
+
+		if err := dbutil.PutBucketValue(tx, ExchangeMetaBkt, freezeKey, state); err != nil {
+			return err
+		}
+
+		action := ReviewActionUnfreeze
+		if frozen {
+			action = ReviewActionFreeze
+		}
+
+		return addReviewAuditTx(tx, ReviewAudit{
+			Action:    action,
+			Operator:  operator,
+			Reason:    reason,
+			CreatedAt: now,
+		})
+	}); err != nil {
+		return FreezeState{}, err
+	}
+
+	return state, nil
+}
+
+// WriteSnapshot writes a consistent copy of the whole database to w, in bolt's file format.
+// The copy is made in a read transaction, which keeps the database from reusing its free pages and,
+// if it grows, makes writes wait for the transaction to finish. A database larger than the snapshot
+// temp file size is first copied to a temp file next to it, then w is written from the temp file
+// after the transaction is done, so that a slow w doesn't hold the transaction open.
+// Returns the number of bytes written.
+func (s *Store) WriteSnapshot(w io.Writer) (int64, error) {
+	var n int64
+	var f *os.File
+	err := s.timer.View(s.db, "WriteSnapshot", func(tx *bolt.Tx) error {
+		if s.snapshotTempFileSize == 0 || tx.Size() <= s.snapshotTempFileSize {
+			var err error
+			n, err = tx.WriteTo(w)
+			return err
+		}
+
+		var err error
+		f, err = ioutil.TempFile(filepath.Dir(s.db.Path()), "teller-snapshot-")
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.WriteTo(f)
+		return err
+	})
+
+	if f != nil {
+		defer func() {
+			f.Close()
+			if err := os.Remove(f.Name()); err != nil {
+				s.log.WithError(err).WithField("path", f.Name()).Error("Failed to remove the snapshot temp file")
+			}
+		}()
+	}
+
+	if err != nil || f == nil {
+		return n, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(w, f)
+}
+
+// CheckReadWrite checks that the database can be written and read, by writing a value and reading it back.
+// The db transaction is rolled back, so nothing is saved.
+func (s *Store) CheckReadWrite() error {
+	key := []byte("preflight")
+	value := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	err := s.timer.Update(s.db, "CheckReadWrite", func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(ExchangeMetaBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(ExchangeMetaBkt)
+		}
+
+		if err := bkt.Put(key, value); err != nil {
+			return err
+		}
+
+		if !bytes.Equal(bkt.Get(key), value) {
+			return errors.New("Value read back does not match the value written")
+		}
+
+		return errRollback
+	})
+
+	if err == errRollback {
+		return nil
+	}
+
+	return err
+}
@@ -1,12 +1,25 @@
 package exchange
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"github.com/skycoin/teller/src/addrs"
 	"github.com/skycoin/teller/src/config"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/util/dbutil"
@@ -39,8 +52,29 @@ func (m *MockStore) BindAddress(skyAddr, btcAddr, coinType, buyMethod string) (*
 	return ba.(*BoundAddress), args.Error(1)
 }
 
-func (m *MockStore) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (DepositInfo, error) {
-	args := m.Called(dv, rate)
+func (m *MockStore) BindAddresses(skyAddr string, depositAddrs []string, coinType, buyMethod string) ([]BoundAddress, error) {
+	args := m.Called(skyAddr, depositAddrs, coinType, buyMethod)
+
+	bas := args.Get(0)
+	if bas == nil {
+		return nil, args.Error(1)
+	}
+
+	return bas.([]BoundAddress), args.Error(1)
+}
+
+func (m *MockStore) SubscribeStatus() (<-chan StatusEvent, func()) {
+	args := m.Called()
+	return args.Get(0).(<-chan StatusEvent), args.Get(1).(func())
+}
+
+func (m *MockStore) GetOrCreateDepositInfo(dv scanner.Deposit, rate, rateTier string) (DepositInfo, error) {
+	args := m.Called(dv, rate, rateTier)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) GetDepositInfo(depositID string) (DepositInfo, error) {
+	args := m.Called(depositID)
 	return args.Get(0).(DepositInfo), args.Error(1)
 }
 
@@ -66,6 +100,28 @@ func (m *MockStore) GetDepositInfoOfSkyAddress(skyAddr string) ([]DepositInfo, e
 	return dis.([]DepositInfo), args.Error(1)
 }
 
+func (m *MockStore) GetDepositInfosOfSkyAddresses(skyAddrs []string) (map[string][]DepositInfo, error) {
+	args := m.Called(skyAddrs)
+
+	dis := args.Get(0)
+	if dis == nil {
+		return nil, args.Error(1)
+	}
+
+	return dis.(map[string][]DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) GetDepositHistory(depositAddr string) ([]DepositEvent, error) {
+	args := m.Called(depositAddr)
+
+	events := args.Get(0)
+	if events == nil {
+		return nil, args.Error(1)
+	}
+
+	return events.([]DepositEvent), args.Error(1)
+}
+
 func (m *MockStore) UpdateDepositInfo(btcTx string, f func(DepositInfo) DepositInfo) (DepositInfo, error) {
 	args := m.Called(btcTx, f)
 	return args.Get(0).(DepositInfo), args.Error(1)
@@ -76,6 +132,17 @@ func (m *MockStore) UpdateDepositInfoCallback(btcTx string, f func(DepositInfo)
 	return args.Get(0).(DepositInfo), args.Error(1)
 }
 
+func (m *MockStore) UpdateDepositInfosCallback(btcTxs []string, f func(DepositInfo) DepositInfo, callback func([]DepositInfo) error) ([]DepositInfo, error) {
+	args := m.Called(btcTxs, f, callback)
+
+	dis := args.Get(0)
+	if dis == nil {
+		return nil, args.Error(1)
+	}
+
+	return dis.([]DepositInfo), args.Error(1)
+}
+
 func (m *MockStore) GetSkyBindAddresses(skyAddr string) ([]BoundAddress, error) {
 	args := m.Called(skyAddr)
 
@@ -87,11 +154,162 @@ func (m *MockStore) GetSkyBindAddresses(skyAddr string) ([]BoundAddress, error)
 	return btcAddrs.([]BoundAddress), args.Error(1)
 }
 
+func (m *MockStore) GetBoundDepositAddresses(coinType string) ([]string, error) {
+	args := m.Called(coinType)
+
+	depositAddrs := args.Get(0)
+	if depositAddrs == nil {
+		return nil, args.Error(1)
+	}
+
+	return depositAddrs.([]string), args.Error(1)
+}
+
 func (m *MockStore) GetDepositStats() (int64, int64, error) {
 	args := m.Called()
 	return args.Get(0).(int64), args.Get(1).(int64), args.Error(2)
 }
 
+func (m *MockStore) AddDeadLetter(di DepositInfo, reason string) (DeadLetter, error) {
+	args := m.Called(di, reason)
+	return args.Get(0).(DeadLetter), args.Error(1)
+}
+
+func (m *MockStore) GetDeadLetters() ([]DeadLetter, error) {
+	args := m.Called()
+
+	dls := args.Get(0)
+	if dls == nil {
+		return nil, args.Error(1)
+	}
+
+	return dls.([]DeadLetter), args.Error(1)
+}
+
+func (m *MockStore) ResolveDeadLetter(depositID, operator string, check func(DeadLetter) error) (DeadLetter, error) {
+	args := m.Called(depositID, operator, check)
+	return args.Get(0).(DeadLetter), args.Error(1)
+}
+
+func (m *MockStore) RestoreDeadLetter(depositID string) (DeadLetter, error) {
+	args := m.Called(depositID)
+	return args.Get(0).(DeadLetter), args.Error(1)
+}
+
+func (m *MockStore) HoldForReview(depositID, reason string) (DepositInfo, error) {
+	args := m.Called(depositID, reason)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) HoldForKYC(depositID, reason string) (DepositInfo, error) {
+	args := m.Called(depositID, reason)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) ClearKYC(depositAddr, operator string) ([]DepositInfo, error) {
+	args := m.Called(depositAddr, operator)
+	dis := args.Get(0)
+	if dis == nil {
+		return nil, args.Error(1)
+	}
+	return dis.([]DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) RepricePending(coinType, rate, operator, reason string) ([]DepositInfo, error) {
+	args := m.Called(coinType, rate, operator, reason)
+	dis := args.Get(0)
+	if dis == nil {
+		return nil, args.Error(1)
+	}
+	return dis.([]DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) MarkStuckSend(depositID, reason string) (DepositInfo, error) {
+	args := m.Called(depositID, reason)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) MarkRateLimited(depositID, reason string) (DepositInfo, error) {
+	args := m.Called(depositID, reason)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) ReleaseRateLimited(depositID string) (DepositInfo, error) {
+	args := m.Called(depositID)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) RecordSend(droplets uint64, sentAt, pruneBefore time.Time) error {
+	args := m.Called(droplets, sentAt, pruneBefore)
+	return args.Error(0)
+}
+
+func (m *MockStore) GetSentSince(since time.Time) (uint64, error) {
+	args := m.Called(since)
+	return args.Get(0).(uint64), args.Error(1)
+}
+
+func (m *MockStore) ReviewDeposit(depositID string, action ReviewAction, operator, reason string) (DepositInfo, error) {
+	args := m.Called(depositID, action, operator, reason)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) ForEachDepositInfo(flt DepositFilter, f func(DepositInfo) error) error {
+	args := m.Called(flt, f)
+	return args.Error(0)
+}
+
+func (m *MockStore) GetFreezeState() (FreezeState, error) {
+	args := m.Called()
+	return args.Get(0).(FreezeState), args.Error(1)
+}
+
+func (m *MockStore) SetFreezeState(frozen bool, operator, reason string) (FreezeState, error) {
+	args := m.Called(frozen, operator, reason)
+	return args.Get(0).(FreezeState), args.Error(1)
+}
+
+func (m *MockStore) WriteSnapshot(w io.Writer) (int64, error) {
+	args := m.Called(w)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockStore) CheckReadWrite() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockStore) MergeDeposits(primaryID string, mergedIDs []string) (DepositInfo, error) {
+	args := m.Called(primaryID, mergedIDs)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) PruneReviewAudits(before time.Time) (int, error) {
+	args := m.Called(before)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStore) GetOverview() (Overview, error) {
+	args := m.Called()
+	return args.Get(0).(Overview), args.Error(1)
+}
+
+func (m *MockStore) PurgeTestData() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStore) GetReviewAudits() ([]ReviewAudit, error) {
+	args := m.Called()
+
+	audits := args.Get(0)
+	if audits == nil {
+		return nil, args.Error(1)
+	}
+
+	return audits.([]ReviewAudit), args.Error(1)
+}
+
 func newTestStore(t *testing.T) (*Store, func()) {
 	db, shutdown := testutil.PrepareDB(t)
 
@@ -114,6 +332,7 @@ func TestStoreNewStore(t *testing.T) {
 		require.NotNil(t, tx.Bucket(MustGetBindAddressBkt(scanner.CoinTypeETH)))
 		require.NotNil(t, tx.Bucket(SkyDepositSeqsIndexBkt))
 		require.NotNil(t, tx.Bucket(BtcTxsBkt))
+		require.NotNil(t, tx.Bucket(DeadLetterBkt))
 		return nil
 	})
 	require.NoError(t, err)
@@ -237,6 +456,98 @@ func TestStoreBindAddress(t *testing.T) {
 	mustBindAddress(t, s, "sa1", "ba2")
 }
 
+type fakeDerivationGetter map[string]addrs.Derivation
+
+func (g fakeDerivationGetter) Derivation(coinType, addr string) *addrs.Derivation {
+	d, ok := g[addr]
+	if !ok {
+		return nil
+	}
+	return &d
+}
+
+func TestStoreBindAddressDerivation(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	d := addrs.Derivation{
+		Index: 7,
+		Path:  "m/44'/0'/0'/0/7",
+	}
+	s.SetDerivationGetter(fakeDerivationGetter{
+		"ba1": d,
+	})
+
+	boundAddr, err := s.BindAddress("sa1", "ba1", scanner.CoinTypeBTC, config.BuyMethodDirect)
+	require.NoError(t, err)
+	require.Equal(t, &d, boundAddr.Derivation)
+
+	// An address without derivation info is bound without it
+	boundAddr, err = s.BindAddress("sa1", "ba2", scanner.CoinTypeBTC, config.BuyMethodDirect)
+	require.NoError(t, err)
+	require.Nil(t, boundAddr.Derivation)
+
+	// Deposits to the address record the derivation
+	di, err := s.GetOrCreateDepositInfo(scanner.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "ba1",
+		Value:    1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+	}, testSkyBtcRate, "")
+	require.NoError(t, err)
+	require.Equal(t, &d, di.Derivation)
+
+	di, err = s.GetDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, &d, di.Derivation)
+}
+
+func TestStoreBindAddresses(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	mustBindAddress(t, s, "a", "b")
+
+	// A batch containing an already bound address binds nothing
+	boundAddrs, err := s.BindAddresses("a", []string{"c", "b"}, scanner.CoinTypeBTC, config.BuyMethodDirect)
+	require.Equal(t, ErrAddressAlreadyBound, err)
+	require.Nil(t, boundAddrs)
+
+	ba, err := s.GetBindAddress("c", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Nil(t, ba)
+
+	boundAddrs, err = s.BindAddresses("a", []string{"c", "d"}, scanner.CoinTypeBTC, config.BuyMethodDirect)
+	require.NoError(t, err)
+	require.Equal(t, []BoundAddress{
+		{
+			SkyAddress: "a",
+			Address:    "c",
+			CoinType:   scanner.CoinTypeBTC,
+			BuyMethod:  config.BuyMethodDirect,
+		},
+		{
+			SkyAddress: "a",
+			Address:    "d",
+			CoinType:   scanner.CoinTypeBTC,
+			BuyMethod:  config.BuyMethodDirect,
+		},
+	}, boundAddrs)
+
+	for _, depositAddr := range []string{"c", "d"} {
+		ba, err := s.GetBindAddress(depositAddr, scanner.CoinTypeBTC)
+		require.NoError(t, err)
+		require.NotNil(t, ba)
+		require.Equal(t, "a", ba.SkyAddress)
+	}
+
+	skyBoundAddrs, err := s.GetSkyBindAddresses("a")
+	require.NoError(t, err)
+	require.Len(t, skyBoundAddrs, 3)
+}
+
 func TestStoreBindAddressTwiceFails(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
@@ -336,7 +647,7 @@ func TestStoreGetDepositInfo(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	dpi, err := s.getDepositInfo("btx1:1")
+	dpi, err := s.GetDepositInfo("btx1:1")
 	require.NoError(t, err)
 	require.Equal(t, "btcaddr1", dpi.DepositAddress)
 	require.Equal(t, "skyaddr1", dpi.SkyAddress)
@@ -344,6 +655,60 @@ func TestStoreGetDepositInfo(t *testing.T) {
 	require.NotEmpty(t, dpi.UpdatedAt)
 }
 
+func TestStoreSubscribeStatus(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	events, unsubscribe := s.SubscribeStatus()
+	defer unsubscribe()
+
+	mustBindAddress(t, s, "skyaddr1", "btcaddr1")
+
+	dv := scanner.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr1",
+		Value:    1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+	}
+
+	// A new deposit publishes an event
+	di, err := s.GetOrCreateDepositInfo(dv, testSkyBtcRate, "")
+	require.NoError(t, err)
+	require.Equal(t, NewStatusEvent(di), <-events)
+	require.Equal(t, StatusWaitDecide.String(), NewStatusEvent(di).Status)
+
+	// An existing deposit does not
+	_, err = s.GetOrCreateDepositInfo(dv, testSkyBtcRate, "")
+	require.NoError(t, err)
+
+	// An update that does not change the status does not publish an event
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Error = "foo"
+		return di
+	})
+	require.NoError(t, err)
+
+	di, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitSend
+		return di
+	})
+	require.NoError(t, err)
+	require.Equal(t, NewStatusEvent(di), <-events)
+
+	// A rolled back update does not publish an event
+	_, err = s.UpdateDepositInfoCallback(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		return di
+	}, func(di DepositInfo) error {
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+
+	require.Len(t, events, 0)
+}
+
 func TestStoreUpdateDepositInfo(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
@@ -424,65 +789,310 @@ func TestStoreUpdateDepositInfo(t *testing.T) {
 	// TODO: test no exist deposit info
 }
 
-func TestStoreGetDepositInfoOfSkyAddress(t *testing.T) {
+func TestStoreUpdateDepositInfosCallback(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
 
-	mustBindAddress(t, s, "skyaddr1", "btcaddr1")
+	addDeposit := func(depositID string, status Status, txid string) DepositInfo {
+		di, err := s.addDepositInfo(DepositInfo{
+			DepositID:      depositID,
+			SkyAddress:     "skyaddr1",
+			DepositAddress: "btcaddr1",
+			DepositValue:   1e6,
+			ConversionRate: testSkyBtcRate,
+			Status:         status,
+			Txid:           txid,
+			BuyMethod:      config.BuyMethodDirect,
+		})
+		require.NoError(t, err)
+		return di
+	}
 
-	dpis, err := s.GetDepositInfoOfSkyAddress("skyaddr1")
-	require.NoError(t, err)
-	require.Len(t, dpis, 1)
-	require.Equal(t, dpis[0].DepositAddress, "btcaddr1")
+	di1 := addDeposit("btx1:1", StatusWaitSend, "")
+	di2 := addDeposit("btx2:1", StatusWaitSend, "")
+	di3 := addDeposit("btx3:1", StatusDone, "343434")
 
-	mustBindAddress(t, s, "skyaddr1", "btcaddr2")
+	setWaitConfirm := func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "121212"
+		return di
+	}
 
-	dpis, err = s.GetDepositInfoOfSkyAddress("skyaddr1")
-	require.NoError(t, err)
-	require.Len(t, dpis, 2)
-	require.Equal(t, dpis[0].DepositAddress, "btcaddr1")
-	require.Equal(t, dpis[1].DepositAddress, "btcaddr2")
+	// An illegal transition of one deposit rolls back all of them
+	_, err := s.UpdateDepositInfosCallback([]string{di1.DepositID, di3.DepositID}, setWaitConfirm, func([]DepositInfo) error {
+		t.Fatal("callback called after an illegal transition")
+		return nil
+	})
+	require.Equal(t, ErrInvalidStatusTransition, err)
 
-	// Multiple txns saved
-	di3 := DepositInfo{
-		SkyAddress:     "skyaddr3",
-		DepositAddress: "btcaddr3",
-		DepositID:      "btctx:3",
-		DepositValue:   100e8,
-		ConversionRate: testSkyBtcRate,
-		Status:         StatusWaitSend,
-		BuyMethod:      config.BuyMethodDirect,
+	// A callback error rolls back all of them
+	callbackErr := errors.New("broadcast failed")
+	_, err = s.UpdateDepositInfosCallback([]string{di1.DepositID, di2.DepositID}, setWaitConfirm, func(dis []DepositInfo) error {
+		require.Len(t, dis, 2)
+		return callbackErr
+	})
+	require.Equal(t, callbackErr, err)
+
+	for _, id := range []string{di1.DepositID, di2.DepositID} {
+		di, err := s.GetDepositInfo(id)
+		require.NoError(t, err)
+		require.Equal(t, StatusWaitSend, di.Status)
+		require.Empty(t, di.Txid)
 	}
-	di3, err = s.addDepositInfo(di3)
-	require.Equal(t, di3.Seq, uint64(1))
-	require.NoError(t, err)
 
-	mustBindAddress(t, s, "skyaddr3", "btcaddr3")
-	mustBindAddress(t, s, "skyaddr3", "btcaddr4")
+	dis, err := s.UpdateDepositInfosCallback([]string{di1.DepositID, di2.DepositID}, setWaitConfirm, func(dis []DepositInfo) error {
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, dis, 2)
 
-	di4 := DepositInfo{
-		SkyAddress:     "skyaddr3",
-		DepositAddress: "btcaddr4",
-		DepositID:      "btctx:4",
-		DepositValue:   1000e8,
-		ConversionRate: testSkyBtcRate,
-		Status:         StatusWaitSend,
-		BuyMethod:      config.BuyMethodDirect,
+	for i, id := range []string{di1.DepositID, di2.DepositID} {
+		di, err := s.GetDepositInfo(id)
+		require.NoError(t, err)
+		require.Equal(t, dis[i], di)
+		require.Equal(t, StatusWaitConfirm, di.Status)
+		require.Equal(t, "121212", di.Txid)
 	}
-	di4, err = s.addDepositInfo(di4)
-	require.NoError(t, err)
+}
 
-	dpis, err = s.GetDepositInfoOfSkyAddress("skyaddr3")
-	require.NoError(t, err)
-	t.Logf("%v", dpis)
-	require.Len(t, dpis, 2)
+func TestCanTransition(t *testing.T) {
+	legal := map[Status][]Status{
+		StatusWaitDecide:      {StatusWaitSend, StatusWaitPassthrough, StatusUnexpectedDeposit},
+		StatusWaitPassthrough: {StatusWaitSend},
+		StatusWaitSend:        {StatusWaitConfirm, StatusWaitReview, StatusDone, StatusStuckSend, StatusRejected},
+		StatusWaitReview:      {StatusWaitSend, StatusRejected},
+		StatusStuckSend:       {StatusWaitSend, StatusRejected},
+		StatusWaitConfirm:     {StatusDone, StatusStuck},
+	}
 
-	// Sequences are renumbered in the result, starting from 0
-	di3.Seq = 0
-	di4.Seq = 1
+	for from := StatusWaitDeposit; from <= StatusStuckSend; from++ {
+		for to := StatusWaitDeposit; to <= StatusStuckSend; to++ {
+			expected := from == to
+			for _, s := range legal[from] {
+				if s == to {
+					expected = true
+				}
+			}
 
-	require.Equal(t, di3, dpis[0])
-	require.Equal(t, di4, dpis[1])
+			require.Equal(t, expected, canTransition(from, to), "%s -> %s", from, to)
+		}
+	}
+}
+
+func TestStatusMessages(t *testing.T) {
+	require.Len(t, statusMessage, len(statusString))
+
+	for st := StatusWaitDeposit; int(st) < len(statusString); st++ {
+		require.NotEmpty(t, st.Message(), "%s has no message", st)
+		require.True(t, ValidStatusStr(st.String()), "%s", st)
+	}
+
+	require.False(t, ValidStatusStr("foo"))
+}
+
+func TestStoreUpdateDepositInfoInvalidTransition(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	di, err := s.addDepositInfo(DepositInfo{
+		DepositID:      "btx1:1",
+		SkyAddress:     "skyaddr1",
+		DepositAddress: "btcaddr1",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitConfirm,
+		Txid:           "121212",
+		SkySent:        1e8,
+		BuyMethod:      config.BuyMethodDirect,
+	})
+	require.NoError(t, err)
+
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitSend
+		di.Error = "foo"
+		return di
+	})
+	require.Equal(t, ErrInvalidStatusTransition, err)
+
+	// Nothing was saved
+	saved, err := s.GetDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, saved.Status)
+	require.Empty(t, saved.Error)
+
+	di, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusDone
+		return di
+	})
+	require.NoError(t, err)
+	require.Equal(t, StatusDone, di.Status)
+}
+
+func TestStoreSetStatusInvalidTransition(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	confirming, err := s.addDepositInfo(DepositInfo{
+		DepositID:      "btx1:1",
+		SkyAddress:     "skyaddr1",
+		DepositAddress: "btcaddr1",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitConfirm,
+		Txid:           "121212",
+		SkySent:        1e8,
+		BuyMethod:      config.BuyMethodDirect,
+	})
+	require.NoError(t, err)
+
+	merged, err := s.addDepositInfo(DepositInfo{
+		DepositID:      "btx2:1",
+		SkyAddress:     "skyaddr1",
+		DepositAddress: "btcaddr1",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+		MergedInto:     "btx3:1",
+		BuyMethod:      config.BuyMethodDirect,
+	})
+	require.NoError(t, err)
+
+	setters := []struct {
+		name string
+		set  func(depositID string) (DepositInfo, error)
+	}{
+		{"HoldForReview", func(depositID string) (DepositInfo, error) {
+			return s.HoldForReview(depositID, "large deposit")
+		}},
+		{"HoldForKYC", func(depositID string) (DepositInfo, error) {
+			return s.HoldForKYC(depositID, ErrKYCHold.Error())
+		}},
+		{"MarkStuckSend", func(depositID string) (DepositInfo, error) {
+			return s.MarkStuckSend(depositID, ErrStuckSend.Error())
+		}},
+		{"MarkRateLimited", func(depositID string) (DepositInfo, error) {
+			return s.MarkRateLimited(depositID, ErrSendAllowanceExceeded.Error())
+		}},
+	}
+
+	for _, tc := range setters {
+		t.Run(tc.name, func(t *testing.T) {
+			// The deposit state machine does not allow the change
+			_, err := tc.set(confirming.DepositID)
+			require.Equal(t, ErrDepositStatusInvalid, err)
+
+			// Merged deposits follow the deposit they are merged into
+			_, err = tc.set(merged.DepositID)
+			require.Equal(t, ErrDepositStatusInvalid, err)
+
+			// Nothing was saved
+			di, err := s.GetDepositInfo(confirming.DepositID)
+			require.NoError(t, err)
+			require.Equal(t, StatusWaitConfirm, di.Status)
+			require.Empty(t, di.Error)
+
+			di, err = s.GetDepositInfo(merged.DepositID)
+			require.NoError(t, err)
+			require.Equal(t, StatusWaitSend, di.Status)
+			require.Empty(t, di.Error)
+		})
+	}
+}
+
+func TestStoreGetDepositInfoOfSkyAddress(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	mustBindAddress(t, s, "skyaddr1", "btcaddr1")
+
+	dpis, err := s.GetDepositInfoOfSkyAddress("skyaddr1")
+	require.NoError(t, err)
+	require.Len(t, dpis, 1)
+	require.Equal(t, dpis[0].DepositAddress, "btcaddr1")
+
+	mustBindAddress(t, s, "skyaddr1", "btcaddr2")
+
+	dpis, err = s.GetDepositInfoOfSkyAddress("skyaddr1")
+	require.NoError(t, err)
+	require.Len(t, dpis, 2)
+	require.Equal(t, dpis[0].DepositAddress, "btcaddr1")
+	require.Equal(t, dpis[1].DepositAddress, "btcaddr2")
+
+	// Multiple txns saved
+	di3 := DepositInfo{
+		SkyAddress:     "skyaddr3",
+		DepositAddress: "btcaddr3",
+		DepositID:      "btctx:3",
+		DepositValue:   100e8,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+		BuyMethod:      config.BuyMethodDirect,
+	}
+	di3, err = s.addDepositInfo(di3)
+	require.Equal(t, di3.Seq, uint64(1))
+	require.NoError(t, err)
+
+	mustBindAddress(t, s, "skyaddr3", "btcaddr3")
+	mustBindAddress(t, s, "skyaddr3", "btcaddr4")
+
+	di4 := DepositInfo{
+		SkyAddress:     "skyaddr3",
+		DepositAddress: "btcaddr4",
+		DepositID:      "btctx:4",
+		DepositValue:   1000e8,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+		BuyMethod:      config.BuyMethodDirect,
+	}
+	di4, err = s.addDepositInfo(di4)
+	require.NoError(t, err)
+
+	dpis, err = s.GetDepositInfoOfSkyAddress("skyaddr3")
+	require.NoError(t, err)
+	t.Logf("%v", dpis)
+	require.Len(t, dpis, 2)
+
+	// Sequences are renumbered in the result, starting from 0
+	di3.Seq = 0
+	di4.Seq = 1
+
+	require.Equal(t, di3, dpis[0])
+	require.Equal(t, di4, dpis[1])
+}
+
+func TestStoreGetDepositInfosOfSkyAddresses(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	mustBindAddress(t, s, "skyaddr1", "btcaddr1")
+	mustBindAddress(t, s, "skyaddr2", "btcaddr2")
+
+	di2, err := s.addDepositInfo(DepositInfo{
+		SkyAddress:     "skyaddr2",
+		DepositAddress: "btcaddr2",
+		DepositID:      "btctx:2",
+		DepositValue:   1e8,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+		BuyMethod:      config.BuyMethodDirect,
+	})
+	require.NoError(t, err)
+	di2.Seq = 0
+
+	// Existing and non-existing addresses, with a duplicate
+	dpis, err := s.GetDepositInfosOfSkyAddresses([]string{"skyaddr1", "skyaddr2", "skyaddr3", "skyaddr2"})
+	require.NoError(t, err)
+	require.Len(t, dpis, 3)
+
+	require.Len(t, dpis["skyaddr1"], 1)
+	require.Equal(t, StatusWaitDeposit, dpis["skyaddr1"][0].Status)
+	require.Equal(t, "btcaddr1", dpis["skyaddr1"][0].DepositAddress)
+
+	require.Equal(t, []DepositInfo{di2}, dpis["skyaddr2"])
+
+	d, ok := dpis["skyaddr3"]
+	require.True(t, ok)
+	require.Empty(t, d)
 }
 
 func TestStoreGetDepositInfoArray(t *testing.T) {
@@ -540,6 +1150,62 @@ func TestStoreGetDepositInfoArray(t *testing.T) {
 	require.Equal(t, dpis[1].SkyAddress, ds1[0].SkyAddress)
 }
 
+func TestStoreForEachDepositInfo(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	// Read across several pages, with the last one full
+	defer func(n int) {
+		depositInfoPageSize = n
+	}(depositInfoPageSize)
+	depositInfoPageSize = 2
+
+	for i := 0; i < 6; i++ {
+		di := DepositInfo{
+			DepositID:      fmt.Sprintf("t%d:1", i),
+			DepositAddress: fmt.Sprintf("b%d", i),
+			SkyAddress:     "s1",
+			DepositValue:   1e6,
+			ConversionRate: testSkyBtcRate,
+			Status:         StatusWaitSend,
+			BuyMethod:      config.BuyMethodDirect,
+		}
+		if i%2 == 0 {
+			di.Status = StatusDone
+			di.Txid = fmt.Sprintf("txid-%d", i)
+			di.SkySent = 100e8
+		}
+
+		_, err := s.addDepositInfo(di)
+		require.NoError(t, err)
+	}
+
+	var ids []string
+	err := s.ForEachDepositInfo(func(di DepositInfo) bool {
+		return di.Status == StatusDone
+	}, func(di DepositInfo) error {
+		ids = append(ids, di.DepositID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"t0:1", "t2:1", "t4:1"}, ids)
+
+	// An error from f stops the iteration
+	stopErr := errors.New("stop")
+	n := 0
+	err = s.ForEachDepositInfo(func(di DepositInfo) bool {
+		return true
+	}, func(di DepositInfo) error {
+		n++
+		if n == 3 {
+			return stopErr
+		}
+		return nil
+	})
+	require.Equal(t, stopErr, err)
+	require.Equal(t, 3, n)
+}
+
 func TestStoreIsValidBtcTx(t *testing.T) {
 	cases := []struct {
 		name  string
@@ -612,15 +1278,21 @@ func TestStoreGetOrCreateDepositInfoAlreadyExists(t *testing.T) {
 	require.NoError(t, err)
 
 	// Check the saved deposit info
-	foundDi, err := s.getDepositInfo(di.DepositID)
+	foundDi, err := s.GetDepositInfo(di.DepositID)
 	require.NoError(t, err)
-	// Seq and UpdatedAt should be set by addDepositInfo
+	// SchemaVersion, Seq, UpdatedAt, StatusUpdatedAt and CreatedAt should be set by addDepositInfo
+	require.Equal(t, SchemaVersion, foundDi.SchemaVersion)
 	require.Equal(t, uint64(1), foundDi.Seq)
 	require.NotEmpty(t, foundDi.UpdatedAt)
+	require.Equal(t, foundDi.UpdatedAt, foundDi.StatusUpdatedAt)
+	require.Equal(t, foundDi.UpdatedAt, foundDi.CreatedAt)
 
 	// Other fields should be unchanged
+	di.SchemaVersion = foundDi.SchemaVersion
 	di.Seq = foundDi.Seq
 	di.UpdatedAt = foundDi.UpdatedAt
+	di.StatusUpdatedAt = foundDi.StatusUpdatedAt
+	di.CreatedAt = foundDi.CreatedAt
 	require.Equal(t, di, foundDi)
 
 	// GetOrCreateDepositInfo, deposit info exists
@@ -636,7 +1308,7 @@ func TestStoreGetOrCreateDepositInfoAlreadyExists(t *testing.T) {
 
 	differentRate := "112233"
 	require.NotEqual(t, differentRate, di.ConversionRate)
-	existsDi, err := s.GetOrCreateDepositInfo(dv, differentRate)
+	existsDi, err := s.GetOrCreateDepositInfo(dv, differentRate, "")
 	require.NoError(t, err)
 
 	// di.Deposit won't be changed
@@ -653,7 +1325,7 @@ func TestStoreGetOrCreateDepositInfoNoBoundSkyAddr(t *testing.T) {
 	}
 
 	rate := "100"
-	_, err := s.GetOrCreateDepositInfo(dv, rate)
+	_, err := s.GetOrCreateDepositInfo(dv, rate, "")
 	require.Error(t, err)
 	require.Equal(t, err, ErrNoBoundAddress)
 }
@@ -699,3 +1371,1102 @@ func TestStoreGetSkyBindAddresses(t *testing.T) {
 		CoinType:   scanner.CoinTypeBTC,
 	})
 }
+
+func TestStoreDeadLetters(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	dls, err := s.GetDeadLetters()
+	require.NoError(t, err)
+	require.Empty(t, dls)
+
+	di, err := s.addDepositInfo(DepositInfo{
+		DepositID:      "btx1:2",
+		SkyAddress:     "skyaddr1",
+		DepositAddress: "btcaddr1",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+		BuyMethod:      config.BuyMethodDirect,
+	})
+	require.NoError(t, err)
+
+	dl, err := s.AddDeadLetter(di, "create transaction failed")
+	require.NoError(t, err)
+	require.Equal(t, di.DepositID, dl.DepositID)
+	require.Equal(t, "create transaction failed", dl.Reason)
+	require.Equal(t, 1, dl.Attempts)
+	require.True(t, dl.Pending)
+	require.NotEmpty(t, dl.CreatedAt)
+
+	// Adding the deposit again increments the attempt count
+	dl, err = s.AddDeadLetter(di, "broadcast failed")
+	require.NoError(t, err)
+	require.Equal(t, "broadcast failed", dl.Reason)
+	require.Equal(t, 2, dl.Attempts)
+
+	// The listed entry carries the current DepositInfo
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Error = "updated"
+		return di
+	})
+	require.NoError(t, err)
+
+	dls, err = s.GetDeadLetters()
+	require.NoError(t, err)
+	require.Len(t, dls, 1)
+	require.Equal(t, 2, dls[0].Attempts)
+	require.Equal(t, "updated", dls[0].DepositInfo.Error)
+
+	// A check error leaves the dead letter pending
+	checkErr := errors.New("check failed")
+	_, err = s.ResolveDeadLetter(di.DepositID, "alice", func(dl DeadLetter) error {
+		return checkErr
+	})
+	require.Equal(t, checkErr, err)
+
+	dls, err = s.GetDeadLetters()
+	require.NoError(t, err)
+	require.Len(t, dls, 1)
+
+	dl, err = s.ResolveDeadLetter(di.DepositID, "alice", func(dl DeadLetter) error {
+		require.Equal(t, StatusWaitSend, dl.DepositInfo.Status)
+		return nil
+	})
+	require.NoError(t, err)
+	require.False(t, dl.Pending)
+
+	dls, err = s.GetDeadLetters()
+	require.NoError(t, err)
+	require.Empty(t, dls)
+
+	_, err = s.ResolveDeadLetter(di.DepositID, "alice", func(dl DeadLetter) error { return nil })
+	require.Equal(t, ErrDeadLetterNotFound, err)
+
+	_, err = s.ResolveDeadLetter("btx9:9", "alice", func(dl DeadLetter) error { return nil })
+	require.Equal(t, ErrDeadLetterNotFound, err)
+
+	// A resolved dead letter that could not be resubmitted is pending again
+	dl, err = s.RestoreDeadLetter(di.DepositID)
+	require.NoError(t, err)
+	require.True(t, dl.Pending)
+	require.Equal(t, 2, dl.Attempts)
+
+	dls, err = s.GetDeadLetters()
+	require.NoError(t, err)
+	require.Len(t, dls, 1)
+
+	_, err = s.ResolveDeadLetter(di.DepositID, "alice", func(dl DeadLetter) error { return nil })
+	require.NoError(t, err)
+
+	_, err = s.RestoreDeadLetter("btx9:9")
+	require.Equal(t, ErrDeadLetterNotFound, err)
+
+	// A deposit that fails after being retried is pending again
+	dl, err = s.AddDeadLetter(di, "create transaction failed")
+	require.NoError(t, err)
+	require.Equal(t, 3, dl.Attempts)
+	require.True(t, dl.Pending)
+}
+
+func TestStoreReviewDeposit(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	addDeposit := func(depositID string) DepositInfo {
+		di, err := s.addDepositInfo(DepositInfo{
+			DepositID:      depositID,
+			SkyAddress:     "skyaddr1",
+			DepositAddress: "btcaddr1",
+			DepositValue:   1e6,
+			ConversionRate: testSkyBtcRate,
+			Status:         StatusWaitSend,
+			BuyMethod:      config.BuyMethodDirect,
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	di1 := addDeposit("btx1:2")
+	di2 := addDeposit("btx2:2")
+
+	// Only deposits held for review can be reviewed
+	_, err := s.ReviewDeposit(di1.DepositID, ReviewActionApprove, "alice", "")
+	require.Equal(t, ErrDepositNotInReview, err)
+
+	di1, err = s.HoldForReview(di1.DepositID, "large deposit")
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitReview, di1.Status)
+	require.Equal(t, "large deposit", di1.Error)
+
+	// A deposit can only be held once
+	_, err = s.HoldForReview(di1.DepositID, "large deposit")
+	require.Equal(t, ErrDepositStatusInvalid, err)
+
+	_, err = s.HoldForReview(di2.DepositID, "large deposit")
+	require.NoError(t, err)
+
+	// An invalid action changes nothing and is not audited
+	_, err = s.ReviewDeposit(di1.DepositID, ReviewAction("ignore"), "alice", "")
+	require.Equal(t, ErrInvalidReviewAction, err)
+
+	di, err := s.GetDepositInfo(di1.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitReview, di.Status)
+
+	audits, err := s.GetReviewAudits()
+	require.NoError(t, err)
+	require.Empty(t, audits)
+
+	di, err = s.ReviewDeposit(di1.DepositID, ReviewActionApprove, "alice", "")
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+	require.Empty(t, di.Error)
+
+	di, err = s.ReviewDeposit(di2.DepositID, ReviewActionReject, "bob", "sender failed kyc")
+	require.NoError(t, err)
+	require.Equal(t, StatusRejected, di.Status)
+	require.Equal(t, "sender failed kyc", di.Error)
+
+	// A reviewed deposit can't be reviewed again
+	_, err = s.ReviewDeposit(di2.DepositID, ReviewActionApprove, "alice", "")
+	require.Equal(t, ErrDepositNotInReview, err)
+
+	audits, err = s.GetReviewAudits()
+	require.NoError(t, err)
+	require.Len(t, audits, 2)
+
+	require.Equal(t, di1.DepositID, audits[0].DepositID)
+	require.Equal(t, ReviewActionApprove, audits[0].Action)
+	require.Equal(t, "alice", audits[0].Operator)
+	require.NotEmpty(t, audits[0].CreatedAt)
+
+	require.Equal(t, di2.DepositID, audits[1].DepositID)
+	require.Equal(t, ReviewActionReject, audits[1].Action)
+	require.Equal(t, "bob", audits[1].Operator)
+	require.Equal(t, "sender failed kyc", audits[1].Reason)
+	require.True(t, audits[0].Seq < audits[1].Seq)
+}
+
+func TestStoreDepositHistory(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	now := time.Unix(1500000000, 0)
+	s.now = func() time.Time {
+		return now
+	}
+	s.SetHistoryLimit(4)
+
+	addDeposit := func(depositID, depositAddr string) DepositInfo {
+		di, err := s.addDepositInfo(DepositInfo{
+			DepositID:      depositID,
+			SkyAddress:     "skyaddr1",
+			DepositAddress: depositAddr,
+			DepositValue:   1e6,
+			ConversionRate: testSkyBtcRate,
+			Status:         StatusWaitSend,
+			BuyMethod:      config.BuyMethodDirect,
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	di1 := addDeposit("btx1:2", "btcaddr1")
+
+	now = now.Add(time.Minute)
+	di2 := addDeposit("btx2:2", "btcaddr1")
+	addDeposit("btx3:2", "btcaddr2")
+
+	now = now.Add(time.Minute)
+	_, err := s.HoldForReview(di1.DepositID, "large deposit")
+	require.NoError(t, err)
+
+	// A failed decision is not recorded
+	_, err = s.ReviewDeposit(di1.DepositID, ReviewAction("ignore"), "alice", "")
+	require.Error(t, err)
+
+	now = now.Add(time.Minute)
+	_, err = s.ReviewDeposit(di1.DepositID, ReviewActionApprove, "alice", "")
+	require.NoError(t, err)
+
+	// Updates that don't change the status are not recorded
+	_, err = s.UpdateDepositInfo(di1.DepositID, func(di DepositInfo) DepositInfo {
+		di.ConversionRate = "200"
+		return di
+	})
+	require.NoError(t, err)
+
+	now = now.Add(time.Minute)
+	_, err = s.UpdateDepositInfo(di2.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "sky-tx"
+		di.SkySent = 100e6
+		return di
+	})
+	require.NoError(t, err)
+
+	// The events of the address's deposits are interleaved, oldest first
+	history, err := s.GetDepositHistory("btcaddr1")
+	require.NoError(t, err)
+	require.Equal(t, []DepositEvent{
+		{
+			DepositID: di1.DepositID,
+			Status:    StatusWaitSend.String(),
+			CreatedAt: 1500000000,
+		},
+		{
+			DepositID: di2.DepositID,
+			Status:    StatusWaitSend.String(),
+			CreatedAt: 1500000060,
+		},
+		{
+			DepositID: di1.DepositID,
+			Status:    StatusWaitReview.String(),
+			Reason:    "large deposit",
+			CreatedAt: 1500000120,
+		},
+		{
+			DepositID: di1.DepositID,
+			Status:    StatusWaitSend.String(),
+			CreatedAt: 1500000180,
+		},
+		{
+			DepositID: di2.DepositID,
+			Status:    StatusWaitConfirm.String(),
+			Txid:      "sky-tx",
+			CreatedAt: 1500000240,
+		},
+	}, history)
+
+	// Only the latest events of a deposit are kept
+	for _, status := range []Status{StatusWaitConfirm, StatusDone} {
+		now = now.Add(time.Minute)
+		_, err = s.UpdateDepositInfo(di1.DepositID, func(di DepositInfo) DepositInfo {
+			di.Status = status
+			di.Txid = "sky-tx1"
+			di.SkySent = 100e6
+			return di
+		})
+		require.NoError(t, err)
+	}
+
+	history, err = s.GetDepositHistory("btcaddr1")
+	require.NoError(t, err)
+
+	var statuses []string
+	for _, ev := range history {
+		if ev.DepositID == di1.DepositID {
+			statuses = append(statuses, ev.Status)
+		}
+	}
+	require.Equal(t, []string{
+		StatusWaitReview.String(),
+		StatusWaitSend.String(),
+		StatusWaitConfirm.String(),
+		StatusDone.String(),
+	}, statuses)
+
+	history, err = s.GetDepositHistory("btcaddr2")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+
+	// No history is recorded if the limit is 0
+	s.SetHistoryLimit(0)
+	addDeposit("btx4:2", "btcaddr3")
+
+	history, err = s.GetDepositHistory("btcaddr3")
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	history, err = s.GetDepositHistory("btcaddr4")
+	require.NoError(t, err)
+	require.Empty(t, history)
+}
+
+func TestStoreKYC(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	addDeposit := func(depositID, depositAddr string) DepositInfo {
+		di, err := s.addDepositInfo(DepositInfo{
+			DepositID:      depositID,
+			SkyAddress:     "skyaddr1",
+			DepositAddress: depositAddr,
+			DepositValue:   1e6,
+			ConversionRate: testSkyBtcRate,
+			Status:         StatusWaitSend,
+			BuyMethod:      config.BuyMethodDirect,
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	di1 := addDeposit("btx1:3", "btcaddr1")
+	di2 := addDeposit("btx2:3", "btcaddr1")
+	di3 := addDeposit("btx3:3", "btcaddr2")
+
+	for _, di := range []DepositInfo{di1, di2, di3} {
+		di, err := s.HoldForKYC(di.DepositID, ErrKYCHold.Error())
+		require.NoError(t, err)
+		require.Equal(t, StatusKYCHold, di.Status)
+		require.Equal(t, ErrKYCHold.Error(), di.Error)
+		require.NoError(t, di.ValidateForStatus())
+	}
+
+	// A deposit can only be held once
+	_, err := s.HoldForKYC(di1.DepositID, ErrKYCHold.Error())
+	require.Equal(t, ErrDepositStatusInvalid, err)
+
+	// KYC held deposits can't be approved, only rejected
+	_, err = s.ReviewDeposit(di3.DepositID, ReviewActionApprove, "alice", "")
+	require.Equal(t, ErrDepositNotInReview, err)
+
+	di, err := s.ReviewDeposit(di3.DepositID, ReviewActionReject, "bob", "sender failed kyc")
+	require.NoError(t, err)
+	require.Equal(t, StatusRejected, di.Status)
+
+	// Clearing an address releases its held deposits
+	dis, err := s.ClearKYC("btcaddr1", "alice")
+	require.NoError(t, err)
+	require.Len(t, dis, 2)
+	for _, di := range dis {
+		require.Equal(t, StatusWaitSend, di.Status)
+		require.Empty(t, di.Error)
+
+		saved, err := s.GetDepositInfo(di.DepositID)
+		require.NoError(t, err)
+		require.Equal(t, di, saved)
+	}
+
+	// Deposits to a cleared address are not held again
+	_, err = s.HoldForKYC(di1.DepositID, ErrKYCHold.Error())
+	require.Equal(t, ErrKYCCleared, err)
+
+	di, err = s.GetDepositInfo(di1.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+
+	audits, err := s.GetReviewAudits()
+	require.NoError(t, err)
+	require.Len(t, audits, 5)
+
+	for i, di := range []DepositInfo{di1, di2, di3} {
+		require.Equal(t, ReviewActionKYCHold, audits[i].Action)
+		require.Equal(t, di.DepositID, audits[i].DepositID)
+		require.Equal(t, di.DepositAddress, audits[i].Address)
+		require.Equal(t, ErrKYCHold.Error(), audits[i].Reason)
+		require.Empty(t, audits[i].Operator)
+	}
+
+	require.Equal(t, ReviewActionReject, audits[3].Action)
+
+	require.Equal(t, ReviewActionKYCClear, audits[4].Action)
+	require.Equal(t, "btcaddr1", audits[4].Address)
+	require.Equal(t, "alice", audits[4].Operator)
+	require.Empty(t, audits[4].DepositID)
+
+	// Only the deposits to the cleared address are released. An address without deposits can be cleared
+	di4 := addDeposit("btx4:3", "btcaddr2")
+	_, err = s.HoldForKYC(di4.DepositID, ErrKYCHold.Error())
+	require.NoError(t, err)
+
+	dis, err = s.ClearKYC("btcaddr3", "alice")
+	require.NoError(t, err)
+	require.Empty(t, dis)
+
+	di, err = s.GetDepositInfo(di4.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusKYCHold, di.Status)
+}
+
+func TestStoreRateLimited(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	di, err := s.addDepositInfo(DepositInfo{
+		DepositID:      "btx1:3",
+		SkyAddress:     "skyaddr1",
+		DepositAddress: "btcaddr1",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+		BuyMethod:      config.BuyMethodDirect,
+	})
+	require.NoError(t, err)
+
+	// Only rate limited deposits can be released
+	_, err = s.ReleaseRateLimited(di.DepositID)
+	require.Equal(t, ErrDepositStatusInvalid, err)
+
+	di, err = s.MarkRateLimited(di.DepositID, ErrSendAllowanceExceeded.Error())
+	require.NoError(t, err)
+	require.Equal(t, StatusRateLimited, di.Status)
+	require.Equal(t, ErrSendAllowanceExceeded.Error(), di.Error)
+	require.NoError(t, di.ValidateForStatus())
+
+	_, err = s.MarkRateLimited(di.DepositID, ErrSendAllowanceExceeded.Error())
+	require.Equal(t, ErrDepositStatusInvalid, err)
+
+	di, err = s.ReleaseRateLimited(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+	require.Empty(t, di.Error)
+}
+
+func TestStoreRepricePending(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	add := func(depositID, coinType, buyMethod string, status Status, txid string) DepositInfo {
+		di, err := s.addDepositInfo(DepositInfo{
+			DepositID:      depositID,
+			CoinType:       coinType,
+			SkyAddress:     "skyaddr1",
+			DepositAddress: "btcaddr1",
+			DepositValue:   1e6,
+			ConversionRate: testSkyBtcRate,
+			RateTier:       "0",
+			Status:         status,
+			BuyMethod:      buyMethod,
+			Txid:           txid,
+			SkySent:        1e6,
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	pending := []DepositInfo{
+		add("btx1:1", scanner.CoinTypeBTC, config.BuyMethodDirect, StatusWaitDecide, ""),
+		add("btx2:1", scanner.CoinTypeBTC, config.BuyMethodDirect, StatusWaitSend, ""),
+		add("btx3:1", scanner.CoinTypeBTC, config.BuyMethodDirect, StatusKYCHold, ""),
+		add("btx4:1", scanner.CoinTypeBTC, config.BuyMethodDirect, StatusRateLimited, ""),
+	}
+
+	untouched := []DepositInfo{
+		// Sent
+		add("btx5:1", scanner.CoinTypeBTC, config.BuyMethodDirect, StatusWaitConfirm, "skytx5"),
+		add("btx6:1", scanner.CoinTypeBTC, config.BuyMethodDirect, StatusDone, "skytx6"),
+		// Another coin type
+		add("etx1:1", scanner.CoinTypeETH, config.BuyMethodDirect, StatusWaitSend, ""),
+		// Bought from an exchange, not at the rate
+		add("btx7:1", scanner.CoinTypeBTC, config.BuyMethodPassthrough, StatusWaitDecide, ""),
+		// Not sent, and never will be
+		add("btx8:1", scanner.CoinTypeBTC, config.BuyMethodDirect, StatusRejected, ""),
+	}
+
+	repriced, err := s.RepricePending(scanner.CoinTypeBTC, "250", "alice", "rate was set in mBTC")
+	require.NoError(t, err)
+	require.Len(t, repriced, len(pending))
+
+	for i, di := range pending {
+		require.Equal(t, di.DepositID, repriced[i].DepositID)
+
+		saved, err := s.GetDepositInfo(di.DepositID)
+		require.NoError(t, err)
+		require.Equal(t, "250", saved.ConversionRate)
+		require.Empty(t, saved.RateTier)
+		require.Equal(t, di.Status, saved.Status)
+		require.Equal(t, repriced[i], saved)
+	}
+
+	for _, di := range untouched {
+		saved, err := s.GetDepositInfo(di.DepositID)
+		require.NoError(t, err)
+		require.Equal(t, di, saved)
+	}
+
+	// Each repriced deposit is audited
+	audits, err := s.GetReviewAudits()
+	require.NoError(t, err)
+	require.Len(t, audits, len(pending))
+	for i, audit := range audits {
+		require.Equal(t, pending[i].DepositID, audit.DepositID)
+		require.Equal(t, ReviewActionReprice, audit.Action)
+		require.Equal(t, "alice", audit.Operator)
+		require.Equal(t, "rate was set in mBTC", audit.Reason)
+		require.Equal(t, "250", audit.Rate)
+		require.Equal(t, testSkyBtcRate, audit.PrevRate)
+	}
+
+	// Deposits already at the rate are not repriced again
+	repriced, err = s.RepricePending(scanner.CoinTypeBTC, "250", "alice", "again")
+	require.NoError(t, err)
+	require.Empty(t, repriced)
+}
+
+func TestStoreSendLedger(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	sent, err := s.GetSentSince(now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), sent)
+
+	require.NoError(t, s.RecordSend(100, now.Add(-time.Minute*90), now.Add(-time.Hour*24)))
+	require.NoError(t, s.RecordSend(200, now.Add(-time.Minute*30), now.Add(-time.Hour*24)))
+	require.NoError(t, s.RecordSend(300, now, now.Add(-time.Hour*24)))
+
+	sent, err = s.GetSentSince(now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, uint64(500), sent)
+
+	sent, err = s.GetSentSince(now.Add(-time.Hour * 2))
+	require.NoError(t, err)
+	require.Equal(t, uint64(600), sent)
+
+	// The ledger is kept by the db, so it survives a restart
+	log, _ := testutil.NewLogger(t)
+	s, err = NewStore(log, s.db)
+	require.NoError(t, err)
+
+	sent, err = s.GetSentSince(now.Add(-time.Hour * 2))
+	require.NoError(t, err)
+	require.Equal(t, uint64(600), sent)
+
+	// Records older than the prune time are deleted
+	require.NoError(t, s.RecordSend(400, now.Add(time.Minute), now.Add(-time.Hour)))
+
+	sent, err = s.GetSentSince(now.Add(-time.Hour * 2))
+	require.NoError(t, err)
+	require.Equal(t, uint64(900), sent)
+}
+
+func TestStoreMergeDeposits(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	addDeposit := func(depositID, skyAddr string, status Status) DepositInfo {
+		di, err := s.addDepositInfo(DepositInfo{
+			DepositID:      depositID,
+			CoinType:       scanner.CoinTypeBTC,
+			SkyAddress:     skyAddr,
+			DepositAddress: "btcaddr1",
+			DepositValue:   1e6,
+			ConversionRate: testSkyBtcRate,
+			Status:         status,
+			BuyMethod:      config.BuyMethodDirect,
+		})
+		require.NoError(t, err)
+		return di
+	}
+
+	di1 := addDeposit("btx1:1", "skyaddr1", StatusWaitSend)
+	di2 := addDeposit("btx2:1", "skyaddr1", StatusWaitSend)
+	di3 := addDeposit("btx3:1", "skyaddr1", StatusWaitSend)
+	di4 := addDeposit("btx4:1", "skyaddr2", StatusWaitSend)
+	di5 := addDeposit("btx5:1", "skyaddr1", StatusWaitDecide)
+
+	// Deposits to another skycoin address can't be merged
+	_, err := s.MergeDeposits(di1.DepositID, []string{di2.DepositID, di4.DepositID})
+	require.Equal(t, ErrMergeMismatch, err)
+
+	// Deposits that are not StatusWaitSend can't be merged
+	_, err = s.MergeDeposits(di1.DepositID, []string{di5.DepositID})
+	require.Equal(t, ErrDepositStatusInvalid, err)
+
+	// Nothing was saved by the failed merges
+	di2, err = s.GetDepositInfo(di2.DepositID)
+	require.NoError(t, err)
+	require.Empty(t, di2.MergedInto)
+
+	primary, err := s.MergeDeposits(di1.DepositID, []string{di2.DepositID, di3.DepositID})
+	require.NoError(t, err)
+	require.Equal(t, []string{di2.DepositID, di3.DepositID}, primary.MergedDeposits)
+	require.Equal(t, StatusWaitSend, primary.Status)
+
+	di1, err = s.GetDepositInfo(di1.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, primary, di1)
+
+	for _, id := range []string{di2.DepositID, di3.DepositID} {
+		di, err := s.GetDepositInfo(id)
+		require.NoError(t, err)
+		require.Equal(t, di1.DepositID, di.MergedInto)
+		require.Equal(t, StatusWaitSend, di.Status)
+	}
+
+	// A merged deposit can't be merged again
+	_, err = s.MergeDeposits(di2.DepositID, []string{di3.DepositID})
+	require.Equal(t, ErrDepositStatusInvalid, err)
+
+	_, err = s.MergeDeposits("btx9:9", []string{di3.DepositID})
+	require.IsType(t, dbutil.ObjectNotExistErr{}, err)
+}
+
+func TestStorePruneReviewAudits(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	now := time.Now().UTC()
+
+	// Seed review audits, two older than the retention and one newer
+	audits := []ReviewAudit{
+		{Seq: 1, DepositID: "foo-tx:0", Action: ReviewActionApprove, Operator: "alice", CreatedAt: now.Add(-time.Hour * 72).Unix()},
+		{Seq: 2, DepositID: "foo-tx:1", Action: ReviewActionReject, Operator: "bob", Reason: "failed kyc", CreatedAt: now.Add(-time.Hour * 49).Unix()},
+		{Seq: 3, DepositID: "foo-tx:2", Action: ReviewActionApprove, Operator: "alice", CreatedAt: now.Add(-time.Hour).Unix()},
+	}
+
+	di := DepositInfo{
+		SchemaVersion:  SchemaVersion,
+		Seq:            1,
+		Status:         StatusRejected,
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "foo-btc-addr",
+		DepositID:      "foo-tx:1",
+		CoinType:       scanner.CoinTypeBTC,
+		Error:          "failed kyc",
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, a := range audits {
+			if err := dbutil.PutBucketValue(tx, ReviewAuditBkt, strconv.FormatUint(a.Seq, 10), a); err != nil {
+				return err
+			}
+		}
+		return dbutil.PutBucketValue(tx, DepositInfoBkt, di.DepositID, di)
+	})
+	require.NoError(t, err)
+
+	n, err := s.PruneReviewAudits(now.Add(-time.Hour * 48))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	remaining, err := s.GetReviewAudits()
+	require.NoError(t, err)
+	require.Equal(t, audits[2:], remaining)
+
+	// The reviewed deposit is kept
+	storedDi, err := s.GetDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, di, storedDi)
+
+	// Nothing more to prune
+	n, err = s.PruneReviewAudits(now.Add(-time.Hour * 48))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestStoreGetOverview(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	dis := []DepositInfo{
+		{DepositID: "btc-tx:0", CoinType: scanner.CoinTypeBTC, Status: StatusDone, DepositValue: 1e8, SkySent: 500e6},
+		{DepositID: "btc-tx:1", CoinType: scanner.CoinTypeBTC, Status: StatusDone, DepositValue: 2e8, SkySent: 1000e6},
+		{DepositID: "btc-tx:2", CoinType: scanner.CoinTypeBTC, Status: StatusWaitReview, DepositValue: 3e8},
+		{DepositID: "btc-tx:3", CoinType: scanner.CoinTypeBTC, Status: StatusKYCHold, DepositValue: 4e8},
+		{DepositID: "btc-tx:4", CoinType: scanner.CoinTypeBTC, Status: StatusInvalid, DepositValue: 5e8},
+		{DepositID: "btc-tx:5", CoinType: scanner.CoinTypeBTC, Status: StatusDone, DepositValue: 6e8, SkySent: 6e6, TestMode: true},
+		{DepositID: "btc-tx:6", CoinType: scanner.CoinTypeBTC, Status: StatusRateLimited, DepositValue: 7e8},
+		{DepositID: "eth-tx:0", CoinType: scanner.CoinTypeETH, Status: StatusWaitSend, DepositValue: 1e18},
+		{DepositID: "eth-tx:1", CoinType: scanner.CoinTypeETH, Status: StatusStuckSend, DepositValue: 2e18},
+	}
+
+	dls := []DeadLetter{
+		{DepositID: "btc-tx:2", Pending: true},
+		{DepositID: "eth-tx:0", Pending: false},
+		{DepositID: "eth-tx:1", Pending: true},
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, di := range dis {
+			if err := dbutil.PutBucketValue(tx, DepositInfoBkt, di.DepositID, di); err != nil {
+				return err
+			}
+		}
+		for _, dl := range dls {
+			if err := dbutil.PutBucketValue(tx, DeadLetterBkt, dl.DepositID, dl); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	ov, err := s.GetOverview()
+	require.NoError(t, err)
+	require.NotZero(t, ov.DBSize)
+	ov.DBSize = 0
+
+	// Invalid and test mode deposits are counted by status but not in the totals
+	require.Equal(t, Overview{
+		Deposits: map[string]int{
+			StatusDone.String():        3,
+			StatusWaitReview.String():  1,
+			StatusKYCHold.String():     1,
+			StatusInvalid.String():     1,
+			StatusWaitSend.String():    1,
+			StatusStuckSend.String():   1,
+			StatusRateLimited.String(): 1,
+		},
+		TotalBTCReceived:   1e8 + 2e8 + 3e8 + 4e8 + 7e8,
+		TotalSKYSent:       1500e6,
+		PendingReview:      4,
+		PendingDeadLetters: 2,
+	}, ov)
+
+	// The totals are the same as the deposit stats
+	btcReceived, skySent, err := s.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, btcReceived, ov.TotalBTCReceived)
+	require.Equal(t, skySent, ov.TotalSKYSent)
+}
+
+func TestStorePurgeTestData(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+	s.SetHistoryLimit(10)
+
+	_, err := s.BindAddress(testSkyAddr, "test-btc-addr", scanner.CoinTypeBTC, config.BuyMethodDirect)
+	require.NoError(t, err)
+	_, err = s.BindAddress(testSkyAddr, "live-btc-addr", scanner.CoinTypeBTC, config.BuyMethodDirect)
+	require.NoError(t, err)
+
+	create := func(addr, tx string, value int64) DepositInfo {
+		di, err := s.GetOrCreateDepositInfo(scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  addr,
+			Value:    value,
+			Tx:       tx,
+		}, testSkyBtcRate, "")
+		require.NoError(t, err)
+		return di
+	}
+
+	// setStatus moves a deposit through statuses
+	setStatus := func(depositID string, skySent uint64, statuses ...Status) {
+		for _, status := range statuses {
+			_, err := s.UpdateDepositInfo(depositID, func(di DepositInfo) DepositInfo {
+				di.Status = status
+				di.SkySent = skySent
+				return di
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	// Deposits saved in test mode are tagged
+	s.SetTestMode(true)
+	done := create("test-btc-addr", "test-tx1", 1e6)
+	require.True(t, done.TestMode)
+	setStatus(done.DepositID, 1e6, StatusWaitSend, StatusDone)
+	_, err = s.AddDeadLetter(done, "test")
+	require.NoError(t, err)
+	inFlight := create("test-btc-addr", "test-tx2", 2e6)
+	setStatus(inFlight.DepositID, 0, StatusWaitSend)
+
+	s.SetTestMode(false)
+	live := create("live-btc-addr", "live-tx1", 4e6)
+	require.False(t, live.TestMode)
+	setStatus(live.DepositID, 4e6, StatusWaitSend, StatusDone)
+
+	// Test mode deposits are not counted in the stats
+	btcReceived, skySent, err := s.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, int64(4e6), btcReceived)
+	require.Equal(t, int64(4e6), skySent)
+
+	// Only the test mode deposit that can't change status anymore is purged
+	n, err := s.PurgeTestData()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	_, err = s.GetDepositInfo(done.DepositID)
+	require.IsType(t, dbutil.ObjectNotExistErr{}, err)
+	dls, err := s.GetDeadLetters()
+	require.NoError(t, err)
+	require.Empty(t, dls)
+
+	dis, err := s.GetDepositInfoOfSkyAddress(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, dis, 2)
+	require.Equal(t, inFlight.DepositID, dis[0].DepositID)
+	require.Equal(t, live.DepositID, dis[1].DepositID)
+
+	history, err := s.GetDepositHistory("test-btc-addr")
+	require.NoError(t, err)
+	require.NotEmpty(t, history)
+	for _, ev := range history {
+		require.Equal(t, inFlight.DepositID, ev.DepositID)
+	}
+
+	// The in-flight deposit is purged once it is done
+	setStatus(inFlight.DepositID, 2e6, StatusDone)
+	n, err = s.PurgeTestData()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	history, err = s.GetDepositHistory("test-btc-addr")
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		ok, err := dbutil.BucketHasKey(tx, BtcTxsBkt, "test-btc-addr")
+		require.NoError(t, err)
+		require.False(t, ok)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The live deposit is kept, and the test deposit address is still bound
+	dis, err = s.GetDepositInfoOfSkyAddress(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, dis, 2)
+	require.Equal(t, StatusWaitDeposit, dis[0].Status)
+	require.Equal(t, "test-btc-addr", dis[0].DepositAddress)
+	require.Equal(t, live.DepositID, dis[1].DepositID)
+
+	n, err = s.PurgeTestData()
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+// snapshotWriter records the number of open read transactions of db each time a snapshot is written to it.
+// If delay is set, each write waits that long, like a slow download
+type snapshotWriter struct {
+	buf    bytes.Buffer
+	db     *bolt.DB
+	delay  time.Duration
+	openTx []int
+}
+
+func (w *snapshotWriter) Write(p []byte) (int, error) {
+	w.openTx = append(w.openTx, w.db.Stats().OpenTxN)
+	time.Sleep(w.delay)
+	return w.buf.Write(p)
+}
+
+func TestStoreWriteSnapshot(t *testing.T) {
+	tt := []struct {
+		name         string
+		tempFileSize int64
+		openTx       int
+	}{
+		{
+			name:         "written directly",
+			tempFileSize: 0,
+			openTx:       1,
+		},
+		{
+			name:         "smaller than the temp file size",
+			tempFileSize: 1024 * 1024 * 1024,
+			openTx:       1,
+		},
+		{
+			name:         "written from a temp file",
+			tempFileSize: 1,
+			openTx:       0,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s, shutdown := newTestStore(t)
+			defer shutdown()
+			s.SetSnapshotTempFileSize(tc.tempFileSize)
+
+			di, err := s.addDepositInfo(DepositInfo{
+				DepositID:      "foo-tx:0",
+				DepositAddress: "foo-btc-addr",
+				SkyAddress:     testSkyAddr,
+				CoinType:       scanner.CoinTypeBTC,
+				DepositValue:   1e6,
+				ConversionRate: testSkyBtcRate,
+				Status:         StatusWaitSend,
+				BuyMethod:      config.BuyMethodDirect,
+			})
+			require.NoError(t, err)
+
+			tempFiles := filepath.Join(filepath.Dir(s.db.Path()), "teller-snapshot-*")
+			before, err := filepath.Glob(tempFiles)
+			require.NoError(t, err)
+
+			w := &snapshotWriter{db: s.db}
+			n, err := s.WriteSnapshot(w)
+			require.NoError(t, err)
+			require.Equal(t, int64(w.buf.Len()), n)
+
+			// The read transaction is only open while w is written if the snapshot is written directly
+			require.NotEmpty(t, w.openTx)
+			for _, openTx := range w.openTx {
+				require.Equal(t, tc.openTx, openTx)
+			}
+
+			// The temp file is removed
+			after, err := filepath.Glob(tempFiles)
+			require.NoError(t, err)
+			require.Equal(t, before, after)
+
+			// The snapshot is a usable database
+			f, err := ioutil.TempFile("", "teller-snapshot-test")
+			require.NoError(t, err)
+			defer os.Remove(f.Name())
+			_, err = f.Write(w.buf.Bytes())
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			db, err := bolt.Open(f.Name(), 0600, nil)
+			require.NoError(t, err)
+			defer db.Close()
+
+			log, _ := testutil.NewLogger(t)
+			snapshot, err := NewStore(log, db)
+			require.NoError(t, err)
+
+			snapshotDi, err := snapshot.GetDepositInfo("foo-tx:0")
+			require.NoError(t, err)
+			require.Equal(t, di, snapshotDi)
+		})
+	}
+}
+
+// BenchmarkStoreConcurrentReadWrite measures UpdateDepositInfo, the exchange loop's write,
+// while other goroutines read deposits as the API and admin panel do
+func BenchmarkStoreConcurrentReadWrite(b *testing.B) {
+	for _, readers := range []int{0, 1, 4, 16} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			db, shutdown := testutil.PrepareDB(b)
+			defer shutdown()
+
+			log, _ := testutil.NewLogger(b)
+			log.Level = logrus.WarnLevel
+			s, err := NewStore(log, db)
+			require.NoError(b, err)
+
+			for i := 0; i < 1000; i++ {
+				_, err := s.addDepositInfo(DepositInfo{
+					DepositID:      fmt.Sprintf("btx%d:0", i),
+					DepositAddress: fmt.Sprintf("btcaddr%d", i),
+					SkyAddress:     "skyaddr1",
+					DepositValue:   1e6,
+					ConversionRate: testSkyBtcRate,
+					Status:         StatusWaitSend,
+					BuyMethod:      config.BuyMethodDirect,
+				})
+				require.NoError(b, err)
+			}
+
+			quit := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < readers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-quit:
+							return
+						default:
+						}
+
+						_, err := s.GetDepositInfoArray(func(di DepositInfo) bool {
+							return di.Status == StatusWaitSend
+						})
+						if err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}()
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := s.UpdateDepositInfo(fmt.Sprintf("btx%d:0", i%1000), func(di DepositInfo) DepositInfo {
+					di.Error = fmt.Sprint(i)
+					return di
+				})
+				require.NoError(b, err)
+			}
+			b.StopTimer()
+
+			close(quit)
+			wg.Wait()
+		})
+	}
+}
+
+// BenchmarkStoreWriteSnapshot measures how long writes wait while a snapshot is downloaded slowly,
+// written directly from the database or from a temp file. The writes grow the database past its memory map,
+// and the write that remaps it waits for the snapshot's read transaction to finish.
+// Reports the longest write of each snapshot. The database is not synced to disk, so only the waits are measured
+func BenchmarkStoreWriteSnapshot(b *testing.B) {
+	for _, strategy := range []struct {
+		name         string
+		tempFileSize int64
+	}{
+		{"direct", 0},
+		{"temp_file", 1},
+	} {
+		b.Run(strategy.name, func(b *testing.B) {
+			log, _ := testutil.NewLogger(b)
+			log.Level = logrus.WarnLevel
+			errMsg := strings.Repeat("x", 4096)
+
+			newDepositInfo := func(i int) DepositInfo {
+				return DepositInfo{
+					DepositID:      fmt.Sprintf("btx%d:0", i),
+					DepositAddress: fmt.Sprintf("btcaddr%d", i),
+					SkyAddress:     "skyaddr1",
+					DepositValue:   1e6,
+					ConversionRate: testSkyBtcRate,
+					Status:         StatusWaitSend,
+					BuyMethod:      config.BuyMethodDirect,
+					Error:          errMsg,
+				}
+			}
+
+			var maxWaits time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				db, shutdown := testutil.PrepareDB(b)
+				db.NoSync = true
+
+				s, err := NewStore(log, db)
+				require.NoError(b, err)
+				s.SetSnapshotTempFileSize(strategy.tempFileSize)
+
+				for j := 0; j < 1000; j++ {
+					_, err := s.addDepositInfo(newDepositInfo(j))
+					require.NoError(b, err)
+				}
+				b.StartTimer()
+
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					_, err := s.WriteSnapshot(&snapshotWriter{
+						db:    db,
+						delay: time.Millisecond,
+					})
+					if err != nil {
+						b.Error(err)
+					}
+				}()
+
+				var maxWait time.Duration
+				for j := 1000; j < 2000; j++ {
+					start := time.Now()
+					_, err := s.addDepositInfo(newDepositInfo(j))
+					require.NoError(b, err)
+
+					if wait := time.Since(start); wait > maxWait {
+						maxWait = wait
+					}
+				}
+
+				<-done
+				maxWaits += maxWait
+
+				b.StopTimer()
+				shutdown()
+				b.StartTimer()
+			}
+
+			b.ReportMetric(float64(maxWaits.Nanoseconds())/float64(b.N)/1e6, "max-write-ms/op")
+		})
+	}
+}
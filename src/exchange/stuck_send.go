@@ -0,0 +1,89 @@
+package exchange
+
+import (
+	"time"
+)
+
+// stuckSendSweepInterval is how often StatusWaitSend deposits are checked against sky_exchanger.stuck_send_age
+const stuckSendSweepInterval = time.Minute
+
+// SweepStuckSends moves the StatusWaitSend deposits that have waited to be sent for longer than
+// sky_exchanger.stuck_send_age to StatusStuckSend, where they wait for an operator to approve or reject them.
+// A deposit being sent when it is set aside is not sent, see ErrDepositStatusChanged.
+// It returns the deposits that were set aside
+func (s *Send) SweepStuckSends() ([]DepositInfo, error) {
+	if s.cfg.StuckSendAge <= 0 {
+		return nil, nil
+	}
+
+	if s.gate.frozen() {
+		return nil, ErrFrozen
+	}
+
+	now := s.now()
+
+	// Merged deposits follow the deposit they were merged into
+	dis, err := s.store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return di.Status == StatusWaitSend && di.MergedInto == "" && statusAge(di, now) >= s.cfg.StuckSendAge
+	})
+	if err != nil {
+		s.log.WithError(err).Error("GetDepositInfoArray failed")
+		return nil, err
+	}
+
+	var stuck []DepositInfo
+	for _, di := range dis {
+		log := s.log.WithField("depositInfo", di)
+
+		updatedDi, err := s.store.MarkStuckSend(di.DepositID, ErrStuckSend.Error())
+		switch err {
+		case nil:
+		case ErrDepositStatusInvalid:
+			// The deposit was sent or set aside since it was loaded
+			continue
+		default:
+			log.WithError(err).Error("MarkStuckSend failed")
+			return stuck, err
+		}
+
+		stuck = append(stuck, updatedDi)
+
+		setAsideMetric(s.metrics, StatusStuckSend).Inc()
+
+		log.WithField("alert", "stuck_send").WithField("stuckSendAge", s.cfg.StuckSendAge).Error("ALERT: Deposit was not sent within the stuck send age. The deposit is set to StatusStuckSend and must be approved or rejected by an operator.")
+	}
+
+	return stuck, nil
+}
+
+// runStuckSendSweep calls SweepStuckSends every stuckSendSweepInterval
+func (s *Send) runStuckSendSweep() {
+	log := s.log.WithField("goroutine", "runStuckSendSweep")
+
+	ticker := time.NewTicker(stuckSendSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			log.Info("quit")
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.SweepStuckSends(); err != nil && err != ErrFrozen {
+			log.WithError(err).Error("SweepStuckSends failed")
+		}
+	}
+}
+
+// statusAge returns how long a deposit has had its status at now.
+// Deposits saved before the status change was recorded use the time they were last updated
+func statusAge(di DepositInfo, now time.Time) time.Duration {
+	changedAt := di.StatusUpdatedAt
+	if changedAt == 0 {
+		changedAt = di.UpdatedAt
+	}
+
+	return now.Sub(time.Unix(changedAt, 0))
+}
@@ -0,0 +1,75 @@
+// Package metrics defines the metrics emitted by teller, with a no-op
+// implementation and a registry that serves them in the Prometheus text or OpenMetrics format.
+package metrics
+
+// Labels are the label names and values of a metric series
+type Labels map[string]string
+
+// Counter is a value that only increases
+type Counter interface {
+	Inc()
+	Add(float64)
+}
+
+// Gauge is a value that can go up and down
+type Gauge interface {
+	Set(float64)
+	Add(float64)
+}
+
+// Histogram counts observed values in buckets
+type Histogram interface {
+	Observe(float64)
+}
+
+// ExemplarObserver is a Histogram that can attach an exemplar to an observation,
+// e.g. the ID of the transaction that was observed, to link the metric to its trace
+type ExemplarObserver interface {
+	ObserveWithExemplar(v float64, exemplar Labels)
+}
+
+// ObserveWithExemplar observes v with h, attaching exemplar if h is an ExemplarObserver
+func ObserveWithExemplar(h Histogram, v float64, exemplar Labels) {
+	if eo, ok := h.(ExemplarObserver); ok {
+		eo.ObserveWithExemplar(v, exemplar)
+		return
+	}
+
+	h.Observe(v)
+}
+
+// Metrics creates or returns the metric series of the given name and labels.
+// Calling a method again with the same name and labels returns the same series.
+type Metrics interface {
+	Counter(name, help string, labels Labels) Counter
+	Gauge(name, help string, labels Labels) Gauge
+	Histogram(name, help string, buckets []float64, labels Labels) Histogram
+}
+
+// DefaultBuckets are histogram buckets for durations in seconds, from 1 second to 1 day
+var DefaultBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200, 21600, 86400}
+
+// Nop is a Metrics that discards everything. It is used when no metrics backend is configured
+type Nop struct{}
+
+type nopMetric struct{}
+
+func (nopMetric) Inc()            {}
+func (nopMetric) Add(float64)     {}
+func (nopMetric) Set(float64)     {}
+func (nopMetric) Observe(float64) {}
+
+// Counter returns a no-op Counter
+func (Nop) Counter(name, help string, labels Labels) Counter {
+	return nopMetric{}
+}
+
+// Gauge returns a no-op Gauge
+func (Nop) Gauge(name, help string, labels Labels) Gauge {
+	return nopMetric{}
+}
+
+// Histogram returns a no-op Histogram
+func (Nop) Histogram(name, help string, buckets []float64, labels Labels) Histogram {
+	return nopMetric{}
+}
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNop(t *testing.T) {
+	var m Metrics = Nop{}
+	m.Counter("foo_total", "", nil).Inc()
+	m.Counter("foo_total", "", nil).Add(2)
+	m.Gauge("bar", "", nil).Set(1)
+	m.Gauge("bar", "", nil).Add(-1)
+	m.Histogram("baz_seconds", "", DefaultBuckets, nil).Observe(1)
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	r.Counter("teller_deposits_received_total", "Deposits received", Labels{"coin_type": "BTC"}).Inc()
+	r.Counter("teller_deposits_received_total", "Deposits received", Labels{"coin_type": "BTC"}).Add(2)
+	r.Counter("teller_deposits_received_total", "Deposits received", Labels{"coin_type": "ETH"}).Inc()
+
+	r.Gauge("teller_send_paused", "1 if sending is paused", nil).Set(1)
+	r.Gauge("teller_build_info", "Build version", Labels{
+		"version": "v1.0.0",
+		"commit":  `a"b\c` + "\n",
+	}).Set(1)
+
+	h := r.Histogram("teller_confirmation_seconds", "Time to confirm\nin seconds", []float64{60, 1}, nil)
+	h.Observe(0.5)
+	h.Observe(30)
+	h.Observe(120)
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+
+	expected := `# HELP teller_build_info Build version
+# TYPE teller_build_info gauge
+teller_build_info{commit="a\"b\\c\n",version="v1.0.0"} 1
+# HELP teller_confirmation_seconds Time to confirm\nin seconds
+# TYPE teller_confirmation_seconds histogram
+teller_confirmation_seconds_bucket{le="1"} 1
+teller_confirmation_seconds_bucket{le="60"} 2
+teller_confirmation_seconds_bucket{le="+Inf"} 3
+teller_confirmation_seconds_sum 150.5
+teller_confirmation_seconds_count 3
+# HELP teller_deposits_received_total Deposits received
+# TYPE teller_deposits_received_total counter
+teller_deposits_received_total{coin_type="BTC"} 3
+teller_deposits_received_total{coin_type="ETH"} 1
+# HELP teller_send_paused 1 if sending is paused
+# TYPE teller_send_paused gauge
+teller_send_paused 0
+`
+	// Gauges can be set down again
+	r.Gauge("teller_send_paused", "1 if sending is paused", nil).Set(0)
+	buf.Reset()
+	_, err = r.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, expected, buf.String())
+
+	// A name can't be reused with a different type
+	require.Panics(t, func() {
+		r.Gauge("teller_deposits_received_total", "", nil)
+	})
+
+	// Served over HTTP
+	req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, ContentType, rr.Header().Get("Content-Type"))
+	require.Equal(t, expected, rr.Body.String())
+
+	req, err = http.NewRequest(http.MethodPost, "/metrics", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestRegistryOpenMetrics(t *testing.T) {
+	r := NewRegistry()
+	r.now = func() time.Time {
+		return time.Unix(1500000000, 250000000)
+	}
+
+	r.Counter("teller_deposits_received_total", `Deposits "received"`, Labels{"coin_type": "BTC"}).Inc()
+
+	h := r.Histogram("teller_confirmation_seconds", "Time to confirm", []float64{1, 60}, nil)
+	h.Observe(0.5)
+	ObserveWithExemplar(h, 30, Labels{"txid": "abc"})
+	ObserveWithExemplar(h, 45, Labels{"txid": "def"})
+	ObserveWithExemplar(h, 120, Labels{"txid": "ghi"})
+
+	// Nop histograms ignore the exemplar
+	ObserveWithExemplar(Nop{}.Histogram("foo", "", DefaultBuckets, nil), 1, Labels{"txid": "abc"})
+
+	expected := `# HELP teller_confirmation_seconds Time to confirm
+# TYPE teller_confirmation_seconds histogram
+teller_confirmation_seconds_bucket{le="1"} 1
+teller_confirmation_seconds_bucket{le="60"} 3 # {txid="def"} 45 1500000000.250
+teller_confirmation_seconds_bucket{le="+Inf"} 4 # {txid="ghi"} 120 1500000000.250
+teller_confirmation_seconds_sum 195.5
+teller_confirmation_seconds_count 4
+# HELP teller_deposits_received Deposits \"received\"
+# TYPE teller_deposits_received counter
+teller_deposits_received_total{coin_type="BTC"} 1
+# EOF
+`
+
+	var buf bytes.Buffer
+	n, err := r.WriteOpenMetricsTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+	require.Equal(t, expected, buf.String())
+
+	// Exemplars are not written in the Prometheus text format
+	buf.Reset()
+	_, err = r.WriteTo(&buf)
+	require.NoError(t, err)
+	require.NotContains(t, buf.String(), "txid")
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+	}{
+		{
+			name:        "no accept header",
+			contentType: ContentType,
+		},
+		{
+			name:        "prometheus",
+			accept:      "text/plain;version=0.0.4",
+			contentType: ContentType,
+		},
+		{
+			name:        "openmetrics",
+			accept:      "application/openmetrics-text;version=1.0.0;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1",
+			contentType: OpenMetricsContentType,
+		},
+		{
+			name:        "openmetrics not acceptable",
+			accept:      "application/openmetrics-text; q=0, text/plain",
+			contentType: ContentType,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+			require.NoError(t, err)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code)
+			require.Equal(t, tc.contentType, rr.Header().Get("Content-Type"))
+
+			if tc.contentType == OpenMetricsContentType {
+				require.Equal(t, expected, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestTopGauge(t *testing.T) {
+	r := NewRegistry()
+	g := NewTopGauge(r, "teller_deposit_address_value", "Value deposited", "deposit_address", Labels{"coin_type": "BTC"}, 3)
+
+	// seriesOf returns the written samples of the gauge
+	seriesOf := func() []string {
+		var buf bytes.Buffer
+		_, err := r.WriteTo(&buf)
+		require.NoError(t, err)
+
+		var samples []string
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, "teller_deposit_address_value{") {
+				samples = append(samples, line)
+			}
+		}
+		return samples
+	}
+
+	// Fewer values than n are each exported, with no other series
+	g.Add("a1", 10)
+	g.Add("a2", 20)
+	require.Equal(t, []string{
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="a1"} 10`,
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="a2"} 20`,
+	}, seriesOf())
+
+	// The series count stays within n+1 however many label values are seen
+	for i := 0; i < 100; i++ {
+		g.Add(fmt.Sprintf("b%d", i), 1)
+		require.True(t, len(seriesOf()) <= 4)
+	}
+
+	// Ties are ranked by label value
+	require.Equal(t, []string{
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="a1"} 10`,
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="a2"} 20`,
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="b0"} 1`,
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="other"} 99`,
+	}, seriesOf())
+
+	// A value that grows into the top n replaces the smallest, which is summed into other
+	g.Add("b50", 14)
+	require.Equal(t, []string{
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="a1"} 10`,
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="a2"} 20`,
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="b50"} 15`,
+		`teller_deposit_address_value{coin_type="BTC",deposit_address="other"} 99`,
+	}, seriesOf())
+
+	// Works with metrics that can't remove series
+	g = NewTopGauge(Nop{}, "teller_deposit_address_value", "", "deposit_address", nil, 1)
+	g.Add("a1", 1)
+	g.Add("a2", 2)
+}
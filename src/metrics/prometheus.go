@@ -0,0 +1,415 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+
+	// ContentType is the content type of the Prometheus text format
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+	// OpenMetricsContentType is the content type of the OpenMetrics text format
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+	openMetricsMediaType = "application/openmetrics-text"
+)
+
+// Registry is a Metrics that keeps every series in memory and writes them in the
+// Prometheus text exposition format, or in the OpenMetrics text format with exemplars.
+// It does not depend on the Prometheus client library.
+type Registry struct {
+	sync.Mutex
+	families map[string]*family
+	now      func() time.Time // timestamps exemplars
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	sync.Mutex
+	labels string // formatted label pairs, e.g. `coin_type="BTC"`
+	value  float64
+
+	// histograms only
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	// the last exemplar observed in each bucket, and in the +Inf bucket last. Only written in the OpenMetrics format
+	exemplars []*exemplar
+	now       func() time.Time
+}
+
+// exemplar is an observation of a histogram, labeled with e.g. the transaction it was observed for
+type exemplar struct {
+	labels string // formatted label pairs
+	value  float64
+	time   time.Time
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+		now:      time.Now,
+	}
+}
+
+// Counter returns the Counter series of the given name and labels, creating it if needed.
+// Panics if name was registered as a different type.
+func (r *Registry) Counter(name, help string, labels Labels) Counter {
+	return r.series(name, help, typeCounter, nil, labels)
+}
+
+// Gauge returns the Gauge series of the given name and labels, creating it if needed.
+// Panics if name was registered as a different type.
+func (r *Registry) Gauge(name, help string, labels Labels) Gauge {
+	return r.series(name, help, typeGauge, nil, labels)
+}
+
+// Histogram returns the Histogram series of the given name and labels, creating it if needed.
+// The buckets of the first call for a name are used. Panics if name was registered as a different type.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels Labels) Histogram {
+	return r.series(name, help, typeHistogram, buckets, labels)
+}
+
+func (r *Registry) series(name, help, typ string, buckets []float64, labels Labels) *series {
+	r.Lock()
+	defer r.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		if typ == typeHistogram {
+			buckets = append([]float64(nil), buckets...)
+			sort.Float64s(buckets)
+		}
+
+		f = &family{
+			name:    name,
+			help:    help,
+			typ:     typ,
+			buckets: buckets,
+			series:  make(map[string]*series),
+		}
+		r.families[name] = f
+	} else if f.typ != typ {
+		panic(fmt.Sprintf("metric %s is a %s, not a %s", name, f.typ, typ))
+	}
+
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labels: key,
+		}
+		if typ == typeHistogram {
+			s.buckets = f.buckets
+			s.counts = make([]uint64, len(f.buckets))
+			s.exemplars = make([]*exemplar, len(f.buckets)+1)
+			s.now = r.now
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// RemoveSeries removes the series of the given name and labels, if it exists.
+// The family is still written, without the series
+func (r *Registry) RemoveSeries(name string, labels Labels) {
+	r.Lock()
+	defer r.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		delete(f.series, formatLabels(labels))
+	}
+}
+
+func (s *series) Inc() {
+	s.Add(1)
+}
+
+func (s *series) Add(v float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.value += v
+}
+
+func (s *series) Set(v float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.value = v
+}
+
+func (s *series) Observe(v float64) {
+	s.observe(v, nil)
+}
+
+// ObserveWithExemplar observes v, and records exemplar as the last exemplar of the bucket v falls in
+func (s *series) ObserveWithExemplar(v float64, exemplar Labels) {
+	s.observe(v, exemplar)
+}
+
+func (s *series) observe(v float64, exemplarLabels Labels) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.value += v
+	s.count++
+	for i, b := range s.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+
+	if exemplarLabels == nil || s.exemplars == nil {
+		return
+	}
+
+	// The exemplar belongs to the lowest bucket containing v
+	i := sort.SearchFloat64s(s.buckets, v)
+	s.exemplars[i] = &exemplar{
+		labels: formatLabels(exemplarLabels),
+		value:  v,
+		time:   s.now(),
+	}
+}
+
+// WriteTo writes all series in the Prometheus text format, sorted by name and labels
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	return r.write(w, false)
+}
+
+// WriteOpenMetricsTo writes all series in the OpenMetrics text format, sorted by name and labels,
+// with the exemplars of histograms
+func (r *Registry) WriteOpenMetricsTo(w io.Writer) (int64, error) {
+	return r.write(w, true)
+}
+
+func (r *Registry) write(w io.Writer, openMetrics bool) (int64, error) {
+	r.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.Unlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	for _, f := range families {
+		f.write(cw, r, openMetrics)
+	}
+
+	if openMetrics {
+		cw.printf("# EOF\n")
+	}
+
+	if cw.err == nil {
+		cw.err = bw.Flush()
+	}
+
+	return cw.n, cw.err
+}
+
+func (f *family) write(w *countWriter, r *Registry, openMetrics bool) {
+	r.Lock()
+	series := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		series = append(series, s)
+	}
+	r.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].labels < series[j].labels
+	})
+
+	// In OpenMetrics, the samples of a counter are suffixed with _total but its metadata is not
+	name, sampleName := f.name, f.name
+	help := escapeHelp(f.help)
+	if openMetrics {
+		if f.typ == typeCounter {
+			name = strings.TrimSuffix(f.name, "_total")
+			sampleName = name + "_total"
+		}
+		help = escapeOpenMetricsHelp(f.help)
+	}
+
+	if f.help != "" {
+		w.printf("# HELP %s %s\n", name, help)
+	}
+	w.printf("# TYPE %s %s\n", name, f.typ)
+
+	for _, s := range series {
+		s.Lock()
+		switch f.typ {
+		case typeHistogram:
+			for i, b := range s.buckets {
+				w.printf("%s_bucket{%s} %d%s\n", f.name, joinLabels(s.labels, "le="+strconv.Quote(formatFloat(b))), s.counts[i], formatExemplar(s.exemplars[i], openMetrics))
+			}
+			w.printf("%s_bucket{%s} %d%s\n", f.name, joinLabels(s.labels, `le="+Inf"`), s.count, formatExemplar(s.exemplars[len(s.buckets)], openMetrics))
+			w.printf("%s_sum%s %s\n", f.name, braces(s.labels), formatFloat(s.value))
+			w.printf("%s_count%s %d\n", f.name, braces(s.labels), s.count)
+		default:
+			w.printf("%s%s %s\n", sampleName, braces(s.labels), formatFloat(s.value))
+		}
+		s.Unlock()
+	}
+}
+
+// formatExemplar formats the exemplar of a histogram bucket sample, which is only written in the OpenMetrics format
+func formatExemplar(e *exemplar, openMetrics bool) string {
+	if e == nil || !openMetrics {
+		return ""
+	}
+
+	ts := strconv.FormatFloat(float64(e.time.UnixNano())/1e9, 'f', 3, 64)
+	return fmt.Sprintf(" # {%s} %s %s", e.labels, formatFloat(e.value), ts)
+}
+
+// ServeHTTP writes all series in the OpenMetrics format if the request accepts it,
+// otherwise in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if acceptsOpenMetrics(req.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", OpenMetricsContentType)
+		r.WriteOpenMetricsTo(w) // nolint: errcheck
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w) // nolint: errcheck
+}
+
+// acceptsOpenMetrics returns true if an Accept header includes the OpenMetrics media type, without q=0
+func acceptsOpenMetrics(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		if strings.TrimSpace(params[0]) != openMetricsMediaType {
+			continue
+		}
+
+		if !zeroQuality(params[1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// zeroQuality returns true if the parameters of a media range include q=0, i.e. "not acceptable"
+func zeroQuality(params []string) bool {
+	for _, p := range params {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "q" {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		return err == nil && q == 0
+	}
+
+	return false
+}
+
+// countWriter records the bytes written and the first error
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+func formatLabels(labels Labels) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, k := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, k, escapeLabelValue(labels[k]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return helpReplacer.Replace(v)
+}
+
+// escapeOpenMetricsHelp escapes a HELP text in the OpenMetrics format, which also escapes double quotes
+func escapeOpenMetricsHelp(v string) string {
+	return labelValueReplacer.Replace(v)
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+)
+
+// OtherLabelValue is the label value of the series of a TopGauge that sums the values outside its top n
+const OtherLabelValue = "other"
+
+// SeriesRemover is a Metrics that can remove a series, so that it is no longer written.
+// A series that was removed is created again if it is used
+type SeriesRemover interface {
+	RemoveSeries(name string, labels Labels)
+}
+
+// TopGauge is a gauge labeled by a label of unbounded cardinality, such as an address,
+// that only exports the n largest values as their own series. The other values are summed
+// into one series labeled OtherLabelValue, so at most n+1 series are exported however
+// many label values are seen. A series that drops out of the top n is removed if the
+// Metrics is a SeriesRemover. Every value is kept in memory, to rank them
+type TopGauge struct {
+	sync.Mutex
+	m      Metrics
+	name   string
+	help   string
+	label  string
+	labels Labels
+	n      int
+	values map[string]float64
+	// the label values exported as their own series
+	top map[string]struct{}
+}
+
+// NewTopGauge creates a TopGauge of name that exports the n largest values of label.
+// labels are added to every series
+func NewTopGauge(m Metrics, name, help, label string, labels Labels, n int) *TopGauge {
+	return &TopGauge{
+		m:      m,
+		name:   name,
+		help:   help,
+		label:  label,
+		labels: labels,
+		n:      n,
+		values: make(map[string]float64),
+		top:    make(map[string]struct{}),
+	}
+}
+
+// Add adds v to the value of labelValue, then updates the exported series
+func (g *TopGauge) Add(labelValue string, v float64) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.values[labelValue] += v
+	g.export()
+}
+
+// export sets the series of the n largest values and the sum of the others,
+// and removes the series that dropped out of the top n. Ties are ranked by label value
+func (g *TopGauge) export() {
+	ranked := make([]string, 0, len(g.values))
+	for lv := range g.values {
+		ranked = append(ranked, lv)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		vi, vj := g.values[ranked[i]], g.values[ranked[j]]
+		if vi != vj {
+			return vi > vj
+		}
+		return ranked[i] < ranked[j]
+	})
+
+	n := g.n
+	if n > len(ranked) {
+		n = len(ranked)
+	}
+
+	top := make(map[string]struct{}, n)
+	for _, lv := range ranked[:n] {
+		top[lv] = struct{}{}
+		g.m.Gauge(g.name, g.help, g.seriesLabels(lv)).Set(g.values[lv])
+	}
+
+	if remover, ok := g.m.(SeriesRemover); ok {
+		for lv := range g.top {
+			if _, ok := top[lv]; !ok {
+				remover.RemoveSeries(g.name, g.seriesLabels(lv))
+			}
+		}
+	}
+
+	g.top = top
+
+	if len(ranked) == n {
+		return
+	}
+
+	var other float64
+	for _, lv := range ranked[n:] {
+		other += g.values[lv]
+	}
+
+	g.m.Gauge(g.name, g.help, g.seriesLabels(OtherLabelValue)).Set(other)
+}
+
+// seriesLabels returns the labels of the series of labelValue
+func (g *TopGauge) seriesLabels(labelValue string) Labels {
+	labels := make(Labels, len(g.labels)+1)
+	for k, v := range g.labels {
+		labels[k] = v
+	}
+	labels[g.label] = labelValue
+	return labels
+}
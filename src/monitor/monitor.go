@@ -4,13 +4,23 @@ package monitor
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
 
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/dbutil"
 	"github.com/skycoin/teller/src/util/httputil"
 	"github.com/skycoin/teller/src/util/logger"
 )
@@ -28,7 +38,8 @@ const (
 
 // AddrManager interface provides apis to access resource of btc address
 type AddrManager interface {
-	Remaining() uint64 // returns the rest number of btc address in the pool
+	Remaining() uint64    // returns the rest number of btc address in the pool
+	LowWatermark() uint64 // returns the pool size below which a warning is raised, 0 if disabled
 }
 
 // DepositStatusGetter  interface provides api to access exchange resource
@@ -42,9 +53,100 @@ type ScanAddressGetter interface {
 	GetScanAddresses() ([]string, error)
 }
 
+// DeadLetterManager provides apis to review and retry deposits that failed processing
+type DeadLetterManager interface {
+	ListDeadLetters() ([]exchange.DeadLetter, error)
+	RetryDeadLetter(depositID, operator string) (exchange.DeadLetter, error)
+}
+
+// ReviewManager provides apis to approve or reject deposits held for review, to clear deposits held for KYC,
+// and to reprice deposits that were not sent yet
+type ReviewManager interface {
+	PendingReview() ([]exchange.DepositInfo, error)
+	ApproveSend(depositID, operator string) (exchange.DepositInfo, error)
+	RejectSend(depositID, operator, reason string) (exchange.DepositInfo, error)
+	ClearKYC(depositAddr, operator string) ([]exchange.DepositInfo, error)
+	RepricePending(coinType, rate, operator, reason string) (int, error)
+	GetReviewAudits() ([]exchange.ReviewAudit, error)
+}
+
+// SendStatusGetter reports the status of the send service
+type SendStatusGetter interface {
+	Status() error
+	Paused() bool
+}
+
+// SnapshotManager provides apis to pause sending and snapshot the database, e.g. for migrations or node upgrades
+type SnapshotManager interface {
+	Pause(ctx context.Context) error
+	DrainAndSnapshot(ctx context.Context, w io.Writer) error
+	Resume()
+}
+
+// BackendStatusGetter reports the health of the skycoin nodes used for sending
+type BackendStatusGetter interface {
+	Backends() []sender.BackendStatus
+}
+
+// DepositSimulator injects simulated deposits, for testing the deposit pipeline in staging
+type DepositSimulator interface {
+	SimulateDeposit(coinType, depositAddr string, value int64) (string, error)
+}
+
+// Reconciler cross-checks deposits against the chain
+type Reconciler interface {
+	Reconcile(ctx context.Context) (exchange.ReconcileReport, error)
+}
+
+// Rescanner rescans the chain for missed deposits to an address
+type Rescanner interface {
+	Rescan(depositAddr, coinType string) error
+}
+
+// PreflightReporter reports the result of the startup self-test
+type PreflightReporter interface {
+	PreflightReport() *exchange.PreflightReport
+}
+
+// DepositExporter exports deposits for reporting
+type DepositExporter interface {
+	ExportCSV(w io.Writer, flt exchange.ExportFilter) error
+}
+
+// FreezeManager freezes and unfreezes the exchange during an incident
+type FreezeManager interface {
+	Freeze(operator, reason string) (exchange.FreezeState, error)
+	Unfreeze(operator, reason string) (exchange.FreezeState, error)
+	FreezeState() (exchange.FreezeState, error)
+}
+
+// OverviewGetter summarizes the deposits
+type OverviewGetter interface {
+	Overview() (exchange.Overview, error)
+}
+
+// TestDataPurger deletes the deposits saved in test mode
+type TestDataPurger interface {
+	PurgeTestData() (int, error)
+}
+
+// StatusSubscriber provides a stream of all deposit status changes
+type StatusSubscriber interface {
+	Subscribe() (<-chan exchange.StatusEvent, func())
+}
+
 // Config configuration info for monitor service
 type Config struct {
 	Addr string
+	// Token required to stream deposit status changes. The stream is disabled if empty
+	EventsToken string
+	// Tokens of the operators allowed to review held deposits and drain sending, keyed by operator name.
+	// These endpoints are disabled if empty
+	OperatorTokens map[string]string
+	// Metrics serves /metrics, e.g. a *metrics.Registry. The endpoint is disabled if nil
+	Metrics http.Handler
+	// BindEnabled is whether the public API binds addresses. The overview reports the sale as closed if not
+	BindEnabled bool
 }
 
 // Monitor monitor service struct
@@ -54,13 +156,33 @@ type Monitor struct {
 	EthAddrManager AddrManager
 	DepositStatusGetter
 	ScanAddressGetter
-	cfg  Config
-	ln   *http.Server
-	quit chan struct{}
+	DeadLetterManager
+	ReviewManager
+	StatusSubscriber
+	SendStatusGetter
+	SnapshotManager
+	// BackendStatusGetter is nil when the dummy sender is used
+	BackendStatusGetter
+	DepositSimulator
+	Reconciler
+	Rescanner
+	// preflight is nil if no startup self-test is reported
+	preflight PreflightReporter
+	// freezer is nil if the exchange can't be frozen from the monitor
+	freezer FreezeManager
+	// exporter is nil if deposits can't be exported
+	exporter DepositExporter
+	// purger is nil if test data can't be purged
+	purger TestDataPurger
+	// overview is nil if the overview endpoint is disabled
+	overview OverviewGetter
+	cfg      Config
+	ln       *http.Server
+	quit     chan struct{}
 }
 
 // New creates monitor service
-func New(log logrus.FieldLogger, cfg Config, addrManager, ethAddrManager AddrManager, dpstget DepositStatusGetter, sag ScanAddressGetter) *Monitor {
+func New(log logrus.FieldLogger, cfg Config, addrManager, ethAddrManager AddrManager, dpstget DepositStatusGetter, sag ScanAddressGetter, dlm DeadLetterManager, rm ReviewManager, ss StatusSubscriber, ssg SendStatusGetter, snm SnapshotManager, bsg BackendStatusGetter, ds DepositSimulator, rc Reconciler, rs Rescanner) *Monitor {
 	return &Monitor{
 		log:                 log.WithField("prefix", "teller.monitor"),
 		cfg:                 cfg,
@@ -68,10 +190,44 @@ func New(log logrus.FieldLogger, cfg Config, addrManager, ethAddrManager AddrMan
 		EthAddrManager:      ethAddrManager,
 		DepositStatusGetter: dpstget,
 		ScanAddressGetter:   sag,
+		DeadLetterManager:   dlm,
+		ReviewManager:       rm,
+		StatusSubscriber:    ss,
+		SendStatusGetter:    ssg,
+		SnapshotManager:     snm,
+		BackendStatusGetter: bsg,
+		DepositSimulator:    ds,
+		Reconciler:          rc,
+		Rescanner:           rs,
 		quit:                make(chan struct{}),
 	}
 }
 
+// SetPreflightReporter sets where /api/health reads the result of the startup self-test. It must be called before Run
+func (m *Monitor) SetPreflightReporter(p PreflightReporter) {
+	m.preflight = p
+}
+
+// SetDepositExporter enables the deposit export endpoint. It must be called before Run
+func (m *Monitor) SetDepositExporter(e DepositExporter) {
+	m.exporter = e
+}
+
+// SetOverviewGetter enables the overview endpoint. It must be called before Run
+func (m *Monitor) SetOverviewGetter(o OverviewGetter) {
+	m.overview = o
+}
+
+// SetTestDataPurger enables the test data purge endpoint. It must be called before Run
+func (m *Monitor) SetTestDataPurger(p TestDataPurger) {
+	m.purger = p
+}
+
+// SetFreezeManager enables the freeze endpoints, and reporting the freeze in /api/health. It must be called before Run
+func (m *Monitor) SetFreezeManager(f FreezeManager) {
+	m.freezer = f
+}
+
 // Run starts the monitor service
 func (m *Monitor) Run() error {
 	log := m.log.WithField("config", m.cfg)
@@ -104,7 +260,32 @@ func (m *Monitor) setupMux() *http.ServeMux {
 
 	mux.Handle("/api/address", httputil.LogHandler(m.log, m.addressHandler()))
 	mux.Handle("/api/deposit_status", httputil.LogHandler(m.log, m.depositStatus()))
+	mux.Handle("/api/deposits/export", httputil.LogHandler(m.log, m.exportDepositsHandler()))
 	mux.Handle("/api/stats", httputil.LogHandler(m.log, m.statsHandler()))
+	mux.Handle("/api/dead_letters", httputil.LogHandler(m.log, m.deadLettersHandler()))
+	mux.Handle("/api/dead_letters/retry", httputil.LogHandler(m.log, m.retryDeadLetterHandler()))
+	mux.Handle("/api/review", httputil.LogHandler(m.log, m.pendingReviewHandler()))
+	mux.Handle("/api/review/approve", httputil.LogHandler(m.log, m.approveSendHandler()))
+	mux.Handle("/api/review/reject", httputil.LogHandler(m.log, m.rejectSendHandler()))
+	mux.Handle("/api/review/audit", httputil.LogHandler(m.log, m.reviewAuditHandler()))
+	mux.Handle("/api/review/kyc/clear", httputil.LogHandler(m.log, m.clearKYCHandler()))
+	mux.Handle("/api/review/reprice", httputil.LogHandler(m.log, m.repricePendingHandler()))
+	mux.Handle("/api/pause", httputil.LogHandler(m.log, m.pauseHandler()))
+	mux.Handle("/api/drain", httputil.LogHandler(m.log, m.drainHandler()))
+	mux.Handle("/api/resume", httputil.LogHandler(m.log, m.resumeHandler()))
+	mux.Handle("/api/freeze", httputil.LogHandler(m.log, m.freezeHandler()))
+	mux.Handle("/api/unfreeze", httputil.LogHandler(m.log, m.unfreezeHandler()))
+	mux.Handle("/api/simulate_deposit", httputil.LogHandler(m.log, m.simulateDepositHandler()))
+	mux.Handle("/api/reconcile", httputil.LogHandler(m.log, m.reconcileHandler()))
+	mux.Handle("/api/rescan", httputil.LogHandler(m.log, m.rescanHandler()))
+	mux.Handle("/api/test_data/purge", httputil.LogHandler(m.log, m.purgeTestDataHandler()))
+	mux.Handle("/api/health", httputil.LogHandler(m.log, m.healthHandler()))
+	mux.Handle("/api/overview", httputil.LogHandler(m.log, m.overviewHandler()))
+	mux.Handle("/api/events", httputil.LogHandler(m.log, m.eventsHandler()))
+
+	if m.cfg.Metrics != nil {
+		mux.Handle("/metrics", m.cfg.Metrics)
+	}
 	return mux
 }
 
@@ -221,6 +402,93 @@ func (m *Monitor) depositStatus() http.HandlerFunc {
 	}
 }
 
+// exportDepositsHandler downloads deposits as CSV, for reporting. The deposits are streamed as they are read.
+// Method: GET
+// URI: /api/deposits/export
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - status # optional, comma separated statuses of the deposits to export. All statuses if empty
+//     - from # optional, only export deposits last updated at or after this time, as RFC3339 or a YYYY-MM-DD date in UTC
+//     - to # optional, only export deposits last updated before this time, as RFC3339 or a YYYY-MM-DD date in UTC
+func (m *Monitor) exportDepositsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		if m.exporter == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Deposit export is disabled")
+			return
+		}
+
+		var flt exchange.ExportFilter
+
+		if statuses := r.FormValue("status"); statuses != "" {
+			for _, status := range strings.Split(statuses, ",") {
+				st := exchange.NewStatusFromStr(strings.TrimSpace(status))
+				if st == exchange.StatusUnknown {
+					httputil.ErrResponse(w, http.StatusBadRequest, fmt.Sprintf("unknown status %v", status))
+					return
+				}
+				flt.Statuses = append(flt.Statuses, st)
+			}
+		}
+
+		var err error
+		if flt.From, err = parseExportTime(r.FormValue("from")); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid from")
+			return
+		}
+
+		if flt.To, err = parseExportTime(r.FormValue("to")); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid to")
+			return
+		}
+
+		log = log.WithField("operator", operator).WithField("filter", flt)
+		log.Info("Exporting deposits")
+
+		dw := &downloadWriter{
+			w:           w,
+			contentType: "text/csv; charset=utf-8",
+			filename:    fmt.Sprintf("teller-deposits-%d.csv", time.Now().UTC().Unix()),
+		}
+
+		if err := m.exporter.ExportCSV(dw, flt); err != nil {
+			log.WithError(err).Error("ExportCSV failed")
+			// If the export was partially written, the response can't be changed to an error
+			if !dw.written {
+				httputil.ErrResponse(w, http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+}
+
+// parseExportTime parses an RFC3339 time or a YYYY-MM-DD date in UTC. Returns the zero time if s is empty
+func parseExportTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
 // stats returns all deposit stats, including total BTC received and total SKY sent.
 // Method: GET
 // URI: /api/stats
@@ -248,3 +516,1114 @@ func (m *Monitor) statsHandler() http.HandlerFunc {
 		}
 	}
 }
+
+// deadLettersHandler returns deposits that failed processing and are waiting for review
+// Method: GET
+// URI: /api/dead_letters
+// Args:
+//     - send_error_code # optional, only return deposits whose last send failed with this cause
+func (m *Monitor) deadLettersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		code := exchange.SendErrorCode(r.FormValue("send_error_code"))
+		if code != "" {
+			if err := exchange.ValidateSendErrorCode(code); err != nil {
+				httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		dls, err := m.ListDeadLetters()
+		if err != nil {
+			log.WithError(err).Error("ListDeadLetters failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if code != "" {
+			var filtered []exchange.DeadLetter
+			for _, dl := range dls {
+				if dl.DepositInfo.LastSendErrorCode == code {
+					filtered = append(filtered, dl)
+				}
+			}
+			dls = filtered
+		}
+
+		if dls == nil {
+			dls = []exchange.DeadLetter{}
+		}
+
+		if err := httputil.JSONResponse(w, dls); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// retryDeadLetterHandler resubmits a dead-lettered deposit for processing.
+// The retry is audited with the authenticated operator's name.
+// Method: POST
+// URI: /api/dead_letters/retry
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - deposit_id # the deposit's ID, "txid:n"
+func (m *Monitor) retryDeadLetterHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		depositID := r.FormValue("deposit_id")
+		if depositID == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing deposit_id")
+			return
+		}
+
+		log = log.WithField("depositID", depositID).WithField("operator", operator)
+
+		dl, err := m.RetryDeadLetter(depositID, operator)
+		switch err {
+		case nil:
+		case exchange.ErrDeadLetterNotFound:
+			httputil.ErrResponse(w, http.StatusNotFound, err.Error())
+			return
+		case exchange.ErrDepositStatusInvalid:
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		case exchange.ErrFrozen:
+			httputil.ErrResponse(w, http.StatusServiceUnavailable, err.Error())
+			return
+		default:
+			log.WithError(err).Error("RetryDeadLetter failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, dl); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// authenticateOperator returns the name of the operator whose token is in the
+// Authorization header. If the request is not authenticated, it writes an error
+// response and returns false.
+func (m *Monitor) authenticateOperator(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if len(m.cfg.OperatorTokens) == 0 {
+		httputil.ErrResponse(w, http.StatusForbidden, "Operator endpoints are disabled")
+		return "", false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token != "" {
+		for name, operatorToken := range m.cfg.OperatorTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(operatorToken)) == 1 {
+				return name, true
+			}
+		}
+	}
+
+	httputil.ErrResponse(w, http.StatusUnauthorized)
+	return "", false
+}
+
+// pendingReviewHandler returns the deposits held for review
+// Method: GET
+// URI: /api/review
+// Headers:
+//     - Authorization: Bearer <operator token>
+func (m *Monitor) pendingReviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if _, ok := m.authenticateOperator(w, r); !ok {
+			return
+		}
+
+		dis, err := m.PendingReview()
+		if err != nil {
+			log.WithError(err).Error("PendingReview failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if dis == nil {
+			dis = []exchange.DepositInfo{}
+		}
+
+		if err := httputil.JSONResponse(w, dis); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// approveSendHandler sends a deposit held for review.
+// The decision is audited with the authenticated operator's name.
+// Method: POST
+// URI: /api/review/approve
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - deposit_id # the deposit's ID, "txid:n"
+func (m *Monitor) approveSendHandler() http.HandlerFunc {
+	return m.reviewHandler(func(depositID, operator string, r *http.Request) (exchange.DepositInfo, error) {
+		return m.ApproveSend(depositID, operator)
+	})
+}
+
+// rejectSendHandler rejects a deposit held for review. It will not be sent and must be refunded manually.
+// The decision is audited with the authenticated operator's name.
+// Method: POST
+// URI: /api/review/reject
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - deposit_id # the deposit's ID, "txid:n"
+//     - reason # why the deposit was rejected
+func (m *Monitor) rejectSendHandler() http.HandlerFunc {
+	return m.reviewHandler(func(depositID, operator string, r *http.Request) (exchange.DepositInfo, error) {
+		return m.RejectSend(depositID, operator, r.FormValue("reason"))
+	})
+}
+
+// reviewHandler handles the common parts of the approve and reject handlers
+func (m *Monitor) reviewHandler(review func(depositID, operator string, r *http.Request) (exchange.DepositInfo, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		depositID := r.FormValue("deposit_id")
+		if depositID == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing deposit_id")
+			return
+		}
+
+		log = log.WithField("depositID", depositID).WithField("operator", operator)
+
+		di, err := review(depositID, operator, r)
+		switch err.(type) {
+		case nil:
+		case dbutil.ObjectNotExistErr:
+			httputil.ErrResponse(w, http.StatusNotFound, "Deposit not found")
+			return
+		default:
+			switch err {
+			case exchange.ErrDepositNotInReview:
+				httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+				return
+			case exchange.ErrFrozen:
+				httputil.ErrResponse(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+
+			log.WithError(err).Error("Review failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, di); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// clearKYCHandler clears the KYC of a deposit address. Its deposits held for KYC are sent,
+// and its later deposits are not held. The clearance is audited with the authenticated operator's name.
+// Method: POST
+// URI: /api/review/kyc/clear
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - address # the deposit address
+func (m *Monitor) clearKYCHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		depositAddr := r.FormValue("address")
+		if depositAddr == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing address")
+			return
+		}
+
+		log = log.WithField("depositAddr", depositAddr).WithField("operator", operator)
+
+		dis, err := m.ClearKYC(depositAddr, operator)
+		if err != nil {
+			if err == exchange.ErrFrozen {
+				httputil.ErrResponse(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+
+			log.WithError(err).Error("ClearKYC failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if dis == nil {
+			dis = []exchange.DepositInfo{}
+		}
+
+		if err := httputil.JSONResponse(w, dis); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// repricePendingHandler reprices the direct buy deposits of a coin type that were not sent yet.
+// Deposits that were sent keep their rate. Each repriced deposit is audited with the authenticated operator's name.
+// Method: POST
+// URI: /api/review/reprice
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - coin_type # the deposit coin type, BTC or ETH
+//     - rate # the new SKY per coin rate
+//     - reason # optional, the reason for the reprice
+func (m *Monitor) repricePendingHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		coinType := r.FormValue("coin_type")
+		if coinType == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing coin_type")
+			return
+		}
+
+		rate := r.FormValue("rate")
+		if rate == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing rate")
+			return
+		}
+
+		log = log.WithField("coinType", coinType).WithField("rate", rate).WithField("operator", operator)
+
+		n, err := m.RepricePending(coinType, rate, operator, r.FormValue("reason"))
+		if err != nil {
+			switch err {
+			case exchange.ErrInvalidRate, scanner.ErrUnsupportedCoinType:
+				httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			default:
+				log.WithError(err).Error("RepricePending failed")
+				httputil.ErrResponse(w, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := httputil.JSONResponse(w, struct {
+			Repriced int `json:"repriced"`
+		}{
+			Repriced: n,
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// reviewAuditHandler returns all review decisions, oldest first
+// Method: GET
+// URI: /api/review/audit
+// Headers:
+//     - Authorization: Bearer <operator token>
+func (m *Monitor) reviewAuditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if _, ok := m.authenticateOperator(w, r); !ok {
+			return
+		}
+
+		audits, err := m.GetReviewAudits()
+		if err != nil {
+			log.WithError(err).Error("GetReviewAudits failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if audits == nil {
+			audits = []exchange.ReviewAudit{}
+		}
+
+		if err := httputil.JSONResponse(w, audits); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// drainHandler pauses sending once the deposit being sent, if any, is done, then
+// responds with a snapshot of the database. Sending stays paused until /api/resume is called.
+// Method: POST
+// URI: /api/drain
+// Headers:
+//     - Authorization: Bearer <operator token>
+func (m *Monitor) drainHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		log = log.WithField("operator", operator)
+		log.Info("Draining sends for a snapshot")
+
+		filename := fmt.Sprintf("teller-snapshot-%d.db", time.Now().UTC().Unix())
+		dw := &downloadWriter{
+			w:           w,
+			contentType: "application/octet-stream",
+			filename:    filename,
+		}
+
+		if err := m.DrainAndSnapshot(ctx, dw); err != nil {
+			log.WithError(err).Error("DrainAndSnapshot failed")
+			// If the snapshot was partially written, the response can't be changed to an error
+			if !dw.written {
+				httputil.ErrResponse(w, http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+}
+
+// downloadWriter sets the download headers before the first write of a file,
+// so that an error response can still be sent if the file fails before it is written
+type downloadWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	filename    string
+	written     bool
+}
+
+func (dw *downloadWriter) Write(p []byte) (int, error) {
+	if !dw.written {
+		dw.written = true
+		dw.w.Header().Set("Content-Type", dw.contentType)
+		dw.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, dw.filename))
+	}
+	return dw.w.Write(p)
+}
+
+// pauseHandler pauses sending once the deposit being sent, if any, is confirmed, e.g. during a skycoin node upgrade.
+// Deposits are still received and recorded while paused, and sent in order after /api/resume.
+// Method: POST
+// URI: /api/pause
+// Headers:
+//     - Authorization: Bearer <operator token>
+func (m *Monitor) pauseHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		log = log.WithField("operator", operator)
+		log.Info("Pausing sends")
+
+		if err := m.Pause(ctx); err != nil {
+			log.WithError(err).Error("Pause failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("Sending paused")
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// resumeHandler resumes sending after /api/pause or /api/drain
+// Method: POST
+// URI: /api/resume
+// Headers:
+//     - Authorization: Bearer <operator token>
+func (m *Monitor) resumeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		m.Resume()
+		log.WithField("operator", operator).Info("Sending resumed")
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// freezeHandler freezes the exchange, e.g. during a security incident. No addresses are bound and
+// no deposits are processed or sent until /api/unfreeze is called, while deposit statuses can still be read.
+// The decision is audited with the authenticated operator's name.
+// Method: POST
+// URI: /api/freeze
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - reason # why the exchange is frozen
+func (m *Monitor) freezeHandler() http.HandlerFunc {
+	return m.setFreezeHandler(func(operator, reason string) (exchange.FreezeState, error) {
+		return m.freezer.Freeze(operator, reason)
+	})
+}
+
+// unfreezeHandler resumes processing after /api/freeze.
+// The decision is audited with the authenticated operator's name.
+// Method: POST
+// URI: /api/unfreeze
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - reason # why the exchange is unfrozen
+func (m *Monitor) unfreezeHandler() http.HandlerFunc {
+	return m.setFreezeHandler(func(operator, reason string) (exchange.FreezeState, error) {
+		return m.freezer.Unfreeze(operator, reason)
+	})
+}
+
+// setFreezeHandler handles the common parts of the freeze and unfreeze handlers
+func (m *Monitor) setFreezeHandler(set func(operator, reason string) (exchange.FreezeState, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		if m.freezer == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Freezing is disabled")
+			return
+		}
+
+		reason := r.FormValue("reason")
+		if reason == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing reason")
+			return
+		}
+
+		log = log.WithField("operator", operator).WithField("reason", reason)
+
+		state, err := set(operator, reason)
+		switch err {
+		case nil:
+		case exchange.ErrFrozen, exchange.ErrNotFrozen:
+			httputil.ErrResponse(w, http.StatusConflict, err.Error())
+			return
+		default:
+			log.WithError(err).Error("Set freeze state failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, state); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// PurgeTestDataResponse is the response of the purge test data handler
+type PurgeTestDataResponse struct {
+	Purged int `json:"purged"`
+}
+
+// purgeTestDataHandler deletes the deposits saved in test mode that are done or rejected
+// Method: POST
+// URI: /api/test_data/purge
+// Headers:
+//     - Authorization: Bearer <operator token>
+func (m *Monitor) purgeTestDataHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		if m.purger == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Test data purge is disabled")
+			return
+		}
+
+		log = log.WithField("operator", operator)
+
+		n, err := m.purger.PurgeTestData()
+		if err != nil {
+			log.WithError(err).Error("PurgeTestData failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		log.WithField("purged", n).Warning("Test data purged by an operator")
+
+		if err := httputil.JSONResponse(w, PurgeTestDataResponse{
+			Purged: n,
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// SimulateDepositResponse is the response of the simulate deposit handler
+type SimulateDepositResponse struct {
+	DepositID string `json:"deposit_id"`
+}
+
+// simulateDepositHandler injects a simulated deposit, if sky_exchanger.allow_simulated_deposits is enabled
+// Method: POST
+// URI: /api/simulate_deposit
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - addr # a bound deposit address
+//     - value # the deposit value, in satoshis or wei
+//     - coin # the coin type, defaults to BTC
+func (m *Monitor) simulateDepositHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		coinType := r.FormValue("coin")
+		if coinType == "" {
+			coinType = scanner.CoinTypeBTC
+		}
+
+		addr := r.FormValue("addr")
+		if addr == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing addr")
+			return
+		}
+
+		value, err := strconv.ParseInt(r.FormValue("value"), 10, 64)
+		if err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid value")
+			return
+		}
+
+		log = log.WithFields(logrus.Fields{
+			"operator": operator,
+			"coinType": coinType,
+			"addr":     addr,
+			"value":    value,
+		})
+
+		depositID, err := m.SimulateDeposit(coinType, addr, value)
+		switch err {
+		case nil:
+		case exchange.ErrSimulatedDepositsDisabled:
+			httputil.ErrResponse(w, http.StatusForbidden, err.Error())
+			return
+		case exchange.ErrInvalidDepositValue, exchange.ErrNoBoundAddress, scanner.ErrUnsupportedCoinType:
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		case exchange.ErrReceiveClosed, exchange.ErrFrozen:
+			httputil.ErrResponse(w, http.StatusServiceUnavailable, err.Error())
+			return
+		default:
+			log.WithError(err).Error("SimulateDeposit failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		log.WithField("depositID", depositID).Warning("Simulated deposit")
+
+		if err := httputil.JSONResponse(w, SimulateDepositResponse{
+			DepositID: depositID,
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// reconcileHandler cross-checks every deposit against the chain and returns the discrepancies.
+// It looks up every done deposit's skycoin transaction, so it can be slow.
+// Method: GET
+// URI: /api/reconcile
+// Headers:
+//     - Authorization: Bearer <operator token>
+func (m *Monitor) reconcileHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		log = log.WithField("operator", operator)
+
+		report, err := m.Reconcile(ctx)
+		switch err {
+		case nil:
+		case exchange.ErrReconcileUnavailable:
+			httputil.ErrResponse(w, http.StatusServiceUnavailable, err.Error())
+			return
+		case context.Canceled, context.DeadlineExceeded:
+			log.WithError(err).Warning("Reconcile cancelled")
+			return
+		default:
+			log.WithError(err).Error("Reconcile failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, report); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// rescanHandler starts rescanning the chain for missed deposits to a deposit address.
+// The rescan runs in the background, found deposits are processed like newly scanned deposits.
+// Method: POST
+// URI: /api/rescan
+// Headers:
+//     - Authorization: Bearer <operator token>
+// Args:
+//     - addr # a deposit address
+//     - coin # the coin type, defaults to BTC
+func (m *Monitor) rescanHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		operator, ok := m.authenticateOperator(w, r)
+		if !ok {
+			return
+		}
+
+		coinType := r.FormValue("coin")
+		if coinType == "" {
+			coinType = scanner.CoinTypeBTC
+		}
+
+		addr := r.FormValue("addr")
+		if addr == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing addr")
+			return
+		}
+
+		log = log.WithFields(logrus.Fields{
+			"operator": operator,
+			"coinType": coinType,
+			"addr":     addr,
+		})
+
+		err := m.Rescan(addr, coinType)
+		switch err {
+		case nil:
+		case scanner.ErrNotScanAddress, scanner.ErrUnsupportedCoinType:
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		case scanner.ErrRescanInProgress:
+			httputil.ErrResponse(w, http.StatusConflict, err.Error())
+			return
+		default:
+			log.WithError(err).Error("Rescan failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("Rescan started")
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// AddressPoolHealth reports the remaining size of a deposit address pool
+type AddressPoolHealth struct {
+	Remaining    uint64 `json:"remaining"`
+	LowWatermark uint64 `json:"low_watermark"`
+	Low          bool   `json:"low"`
+}
+
+// HealthResponse is the response of the health handler
+type HealthResponse struct {
+	Healthy bool `json:"healthy"`
+	// ReadOnly is true if sending stopped because the deposit store is not writable
+	ReadOnly bool `json:"read_only"`
+	// Paused is true if sending was paused by an operator
+	Paused    bool   `json:"paused"`
+	SendError string `json:"send_error,omitempty"`
+	// Frozen is true if the exchange was frozen by an operator. Freeze tells by whom and why
+	Frozen bool                  `json:"frozen"`
+	Freeze *exchange.FreezeState `json:"freeze,omitempty"`
+	// SkyBackends reports the health of each skycoin node used for sending
+	SkyBackends []sender.BackendStatus `json:"sky_backends,omitempty"`
+
+	BtcAddressPool AddressPoolHealth `json:"btc_address_pool"`
+	EthAddressPool AddressPoolHealth `json:"eth_address_pool"`
+
+	// Preflight is the result of the startup self-test, omitted if it was not run
+	Preflight *exchange.PreflightReport `json:"preflight,omitempty"`
+}
+
+func newAddressPoolHealth(am AddrManager) AddressPoolHealth {
+	remaining := am.Remaining()
+	lowWatermark := am.LowWatermark()
+	return AddressPoolHealth{
+		Remaining:    remaining,
+		LowWatermark: lowWatermark,
+		Low:          lowWatermark != 0 && remaining < lowWatermark,
+	}
+}
+
+// healthHandler returns the send service status and the remaining deposit address pool sizes.
+// Responds with 503 Service Unavailable if the deposit store is not writable.
+// Method: GET
+// URI: /api/health
+func (m *Monitor) healthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		rsp := HealthResponse{
+			BtcAddressPool: newAddressPoolHealth(m.AddrManager),
+			EthAddressPool: newAddressPoolHealth(m.EthAddrManager),
+		}
+
+		sendErr := m.SendStatusGetter.Status()
+		if sendErr != nil {
+			rsp.SendError = sendErr.Error()
+		}
+		rsp.ReadOnly = sendErr == exchange.ErrReadOnly
+		rsp.Paused = m.SendStatusGetter.Paused()
+		if m.BackendStatusGetter != nil {
+			rsp.SkyBackends = m.BackendStatusGetter.Backends()
+		}
+		if m.preflight != nil {
+			rsp.Preflight = m.preflight.PreflightReport()
+		}
+		if m.freezer != nil {
+			freeze, err := m.freezer.FreezeState()
+			if err != nil {
+				log.WithError(err).Error("FreezeState failed")
+				httputil.ErrResponse(w, http.StatusInternalServerError)
+				return
+			}
+			rsp.Frozen = freeze.Frozen
+			rsp.Freeze = &freeze
+		}
+		rsp.Healthy = !rsp.ReadOnly
+
+		if !rsp.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		if err := httputil.JSONResponse(w, rsp); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// OverviewResponse is the response of the overview handler
+type OverviewResponse struct {
+	exchange.Overview
+	// SoldSKY is TotalSKYSent in SKY, as reported by /api/sale/status
+	SoldSKY string `json:"sold_sky"`
+	// SaleOpen is true if the public API binds addresses and the exchange is not frozen
+	SaleOpen bool `json:"sale_open"`
+	Frozen   bool `json:"frozen"`
+	// Paused, ReadOnly, SendError and SkyBackends are as reported by /api/health
+	Paused      bool                   `json:"paused"`
+	ReadOnly    bool                   `json:"read_only"`
+	SendError   string                 `json:"send_error,omitempty"`
+	SkyBackends []sender.BackendStatus `json:"sky_backends,omitempty"`
+
+	BtcAddressPool AddressPoolHealth `json:"btc_address_pool"`
+	EthAddressPool AddressPoolHealth `json:"eth_address_pool"`
+}
+
+// overviewHandler returns a summary of the sale, the deposits and the send service for operator dashboards,
+// instead of querying several endpoints
+// Method: GET
+// URI: /api/overview
+// Headers:
+//     - Authorization: Bearer <operator token>
+func (m *Monitor) overviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if _, ok := m.authenticateOperator(w, r); !ok {
+			return
+		}
+
+		if m.overview == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Overview is disabled")
+			return
+		}
+
+		ov, err := m.overview.Overview()
+		if err != nil {
+			log.WithError(err).Error("Overview failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		soldSKY, err := droplet.ToString(uint64(ov.TotalSKYSent))
+		if err != nil {
+			log.WithError(err).Error("droplet.ToString failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		rsp := OverviewResponse{
+			Overview:       ov,
+			SoldSKY:        soldSKY,
+			BtcAddressPool: newAddressPoolHealth(m.AddrManager),
+			EthAddressPool: newAddressPoolHealth(m.EthAddrManager),
+		}
+
+		if m.freezer != nil {
+			freeze, err := m.freezer.FreezeState()
+			if err != nil {
+				log.WithError(err).Error("FreezeState failed")
+				httputil.ErrResponse(w, http.StatusInternalServerError)
+				return
+			}
+			rsp.Frozen = freeze.Frozen
+		}
+		rsp.SaleOpen = m.cfg.BindEnabled && !rsp.Frozen
+
+		sendErr := m.SendStatusGetter.Status()
+		if sendErr != nil {
+			rsp.SendError = sendErr.Error()
+		}
+		rsp.ReadOnly = sendErr == exchange.ErrReadOnly
+		rsp.Paused = m.SendStatusGetter.Paused()
+		if m.BackendStatusGetter != nil {
+			rsp.SkyBackends = m.BackendStatusGetter.Backends()
+		}
+
+		if err := httputil.JSONResponse(w, rsp); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// eventsHandler streams all deposit status changes over a websocket, as JSON encoded exchange.StatusEvents.
+// Requests must be authenticated with the configured events token.
+// Method: GET
+// URI: /api/events
+// Headers:
+//     - Authorization: Bearer <events token>
+func (m *Monitor) eventsHandler() http.HandlerFunc {
+	ws := websocket.Server{
+		Handler: m.streamEvents,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.cfg.EventsToken == "" {
+			httputil.ErrResponse(w, http.StatusForbidden, "Event stream is disabled")
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(m.cfg.EventsToken)) != 1 {
+			httputil.ErrResponse(w, http.StatusUnauthorized)
+			return
+		}
+
+		ws.ServeHTTP(w, r)
+	}
+}
+
+// streamEvents writes status events to the websocket until the client disconnects or the monitor shuts down
+func (m *Monitor) streamEvents(conn *websocket.Conn) {
+	log := logger.FromContext(conn.Request().Context())
+
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	// The client does not send anything, read only to detect when it disconnects
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-closed:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if err := websocket.JSON.Send(conn, ev); err != nil {
+				log.WithError(err).Debug("Send status event failed")
+				return
+			}
+		}
+	}
+}
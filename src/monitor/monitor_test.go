@@ -1,32 +1,51 @@
 package monitor
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
 
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/metrics"
 	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/mathutil"
 	"github.com/skycoin/teller/src/util/testutil"
 )
 
 type dummyBtcAddrMgr struct {
-	Num uint64
+	Num          uint64
+	lowWatermark uint64
 }
 type dummyEthAddrMgr struct {
-	Num uint64
+	Num          uint64
+	lowWatermark uint64
 }
 
 func (db *dummyBtcAddrMgr) Remaining() uint64 {
 	return db.Num
 }
+func (db *dummyBtcAddrMgr) LowWatermark() uint64 {
+	return db.lowWatermark
+}
 func (db *dummyEthAddrMgr) Remaining() uint64 {
 	return db.Num
 }
+func (db *dummyEthAddrMgr) LowWatermark() uint64 {
+	return db.lowWatermark
+}
 
 type dummyDepositStatusGetter struct {
 	dpis []exchange.DepositInfo
@@ -73,6 +92,171 @@ func (ds dummyScanAddrs) GetScanAddresses() ([]string, error) {
 	return []string{}, nil
 }
 
+type dummyDeadLetterManager struct {
+	dls       []exchange.DeadLetter
+	retriedBy map[string]string
+}
+
+func (dm *dummyDeadLetterManager) ListDeadLetters() ([]exchange.DeadLetter, error) {
+	var dls []exchange.DeadLetter
+	for _, dl := range dm.dls {
+		if dl.Pending {
+			dls = append(dls, dl)
+		}
+	}
+	return dls, nil
+}
+
+func (dm *dummyDeadLetterManager) RetryDeadLetter(depositID, operator string) (exchange.DeadLetter, error) {
+	for i, dl := range dm.dls {
+		if dl.DepositID != depositID || !dl.Pending {
+			continue
+		}
+		if dl.DepositInfo.Status == exchange.StatusDone {
+			return exchange.DeadLetter{}, exchange.ErrDepositStatusInvalid
+		}
+		dm.dls[i].Pending = false
+		if dm.retriedBy == nil {
+			dm.retriedBy = make(map[string]string)
+		}
+		dm.retriedBy[depositID] = operator
+		return dm.dls[i], nil
+	}
+	return exchange.DeadLetter{}, exchange.ErrDeadLetterNotFound
+}
+
+type dummyReviewManager struct {
+	dis    []exchange.DepositInfo
+	audits []exchange.ReviewAudit
+}
+
+func (rm *dummyReviewManager) PendingReview() ([]exchange.DepositInfo, error) {
+	var dis []exchange.DepositInfo
+	for _, di := range rm.dis {
+		if di.Status == exchange.StatusWaitReview {
+			dis = append(dis, di)
+		}
+	}
+	return dis, nil
+}
+
+func (rm *dummyReviewManager) review(depositID string, audit exchange.ReviewAudit, status exchange.Status) (exchange.DepositInfo, error) {
+	for i, di := range rm.dis {
+		if di.DepositID != depositID {
+			continue
+		}
+		if di.Status != exchange.StatusWaitReview {
+			return exchange.DepositInfo{}, exchange.ErrDepositNotInReview
+		}
+		rm.dis[i].Status = status
+		audit.Seq = uint64(len(rm.audits) + 1)
+		audit.DepositID = depositID
+		rm.audits = append(rm.audits, audit)
+		return rm.dis[i], nil
+	}
+	return exchange.DepositInfo{}, dbutil.NewObjectNotExistErr(exchange.DepositInfoBkt, []byte(depositID))
+}
+
+func (rm *dummyReviewManager) ApproveSend(depositID, operator string) (exchange.DepositInfo, error) {
+	return rm.review(depositID, exchange.ReviewAudit{
+		Action:   exchange.ReviewActionApprove,
+		Operator: operator,
+	}, exchange.StatusWaitSend)
+}
+
+func (rm *dummyReviewManager) RejectSend(depositID, operator, reason string) (exchange.DepositInfo, error) {
+	return rm.review(depositID, exchange.ReviewAudit{
+		Action:   exchange.ReviewActionReject,
+		Operator: operator,
+		Reason:   reason,
+	}, exchange.StatusRejected)
+}
+
+func (rm *dummyReviewManager) ClearKYC(depositAddr, operator string) ([]exchange.DepositInfo, error) {
+	var released []exchange.DepositInfo
+	for i, di := range rm.dis {
+		if di.DepositAddress == depositAddr && di.Status == exchange.StatusKYCHold {
+			rm.dis[i].Status = exchange.StatusWaitSend
+			released = append(released, rm.dis[i])
+		}
+	}
+	rm.audits = append(rm.audits, exchange.ReviewAudit{
+		Seq:      uint64(len(rm.audits) + 1),
+		Action:   exchange.ReviewActionKYCClear,
+		Operator: operator,
+		Address:  depositAddr,
+	})
+	return released, nil
+}
+
+func (rm *dummyReviewManager) RepricePending(coinType, rate, operator, reason string) (int, error) {
+	if coinType != scanner.CoinTypeBTC && coinType != scanner.CoinTypeETH {
+		return 0, scanner.ErrUnsupportedCoinType
+	}
+	if _, err := mathutil.ParseRate(rate); err != nil {
+		return 0, exchange.ErrInvalidRate
+	}
+
+	var n int
+	for i, di := range rm.dis {
+		if di.CoinType != coinType || di.Txid != "" || di.ConversionRate == rate {
+			continue
+		}
+		rm.dis[i].ConversionRate = rate
+		rm.audits = append(rm.audits, exchange.ReviewAudit{
+			Seq:       uint64(len(rm.audits) + 1),
+			Action:    exchange.ReviewActionReprice,
+			Operator:  operator,
+			Reason:    reason,
+			DepositID: di.DepositID,
+			Rate:      rate,
+			PrevRate:  di.ConversionRate,
+		})
+		n++
+	}
+	return n, nil
+}
+
+func (rm *dummyReviewManager) GetReviewAudits() ([]exchange.ReviewAudit, error) {
+	return rm.audits, nil
+}
+
+type dummySendStatus struct {
+	err      error
+	paused   bool
+	snapshot []byte
+	drainErr error
+}
+
+func (ds *dummySendStatus) Status() error {
+	return ds.err
+}
+
+func (ds *dummySendStatus) Paused() bool {
+	return ds.paused
+}
+
+func (ds *dummySendStatus) Pause(ctx context.Context) error {
+	if ds.drainErr != nil {
+		return ds.drainErr
+	}
+	ds.paused = true
+	return nil
+}
+
+func (ds *dummySendStatus) DrainAndSnapshot(ctx context.Context, w io.Writer) error {
+	if ds.drainErr != nil {
+		return ds.drainErr
+	}
+	ds.paused = true
+	_, err := w.Write(ds.snapshot)
+	return err
+}
+
+func (ds *dummySendStatus) Resume() {
+	ds.paused = false
+}
+
 func TestRunMonitor(t *testing.T) {
 	dpis := []exchange.DepositInfo{
 		{
@@ -105,11 +289,11 @@ func TestRunMonitor(t *testing.T) {
 	dummyDps := dummyDepositStatusGetter{dpis: dpis}
 
 	cfg := Config{
-		"localhost:7908",
+		Addr: "localhost:7908",
 	}
 
 	log, _ := testutil.NewLogger(t)
-	m := New(log, cfg, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDps, &dummyScanAddrs{})
+	m := New(log, cfg, &dummyBtcAddrMgr{Num: 10}, &dummyEthAddrMgr{Num: 10}, &dummyDps, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
 
 	time.AfterFunc(1*time.Second, func() {
 		rsp, err := http.Get(fmt.Sprintf("http://localhost:7908/api/address"))
@@ -154,7 +338,7 @@ func TestRunMonitor(t *testing.T) {
 			},
 			{
 				"get unknown status",
-				"invalid",
+				"bogus",
 				http.StatusBadRequest,
 				nil,
 			},
@@ -195,3 +379,1346 @@ func TestRunMonitor(t *testing.T) {
 		return
 	}
 }
+
+func TestDeadLetters(t *testing.T) {
+	dm := &dummyDeadLetterManager{
+		dls: []exchange.DeadLetter{
+			{
+				DepositID: "t1:0",
+				Reason:    "create transaction failed",
+				Attempts:  1,
+				Pending:   true,
+				DepositInfo: exchange.DepositInfo{
+					DepositID:         "t1:0",
+					Status:            exchange.StatusWaitSend,
+					LastSendErrorCode: exchange.SendErrorInvalidAddress,
+				},
+			},
+			{
+				DepositID: "t2:0",
+				Reason:    "update status failed",
+				Attempts:  2,
+				Pending:   true,
+				DepositInfo: exchange.DepositInfo{
+					DepositID: "t2:0",
+					Status:    exchange.StatusDone,
+				},
+			},
+		},
+	}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{Num: 10}, &dummyEthAddrMgr{Num: 10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dm, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+	handler := m.setupMux()
+
+	req, err := http.NewRequest(http.MethodGet, "/api/dead_letters", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var dls []exchange.DeadLetter
+	err = json.Unmarshal(rr.Body.Bytes(), &dls)
+	require.NoError(t, err)
+	require.Equal(t, dm.dls, dls)
+
+	// Dead letters can be filtered by the cause of their last failed send
+	req, err = http.NewRequest(http.MethodGet, "/api/dead_letters?send_error_code=invalid_address", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	err = json.Unmarshal(rr.Body.Bytes(), &dls)
+	require.NoError(t, err)
+	require.Equal(t, dm.dls[:1], dls)
+
+	req, err = http.NewRequest(http.MethodGet, "/api/dead_letters?send_error_code=tx_rejected", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "[]", strings.TrimSpace(rr.Body.String()))
+
+	req, err = http.NewRequest(http.MethodGet, "/api/dead_letters?send_error_code=foo", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	tt := []struct {
+		name      string
+		method    string
+		token     string
+		depositID string
+		status    int
+	}{
+		{
+			"405",
+			http.MethodGet,
+			"alice-token",
+			"t1:0",
+			http.StatusMethodNotAllowed,
+		},
+		{
+			"401 missing token",
+			http.MethodPost,
+			"",
+			"t1:0",
+			http.StatusUnauthorized,
+		},
+		{
+			"401 wrong token",
+			http.MethodPost,
+			"wrong",
+			"t1:0",
+			http.StatusUnauthorized,
+		},
+		{
+			"400 missing deposit_id",
+			http.MethodPost,
+			"alice-token",
+			"",
+			http.StatusBadRequest,
+		},
+		{
+			"404 unknown deposit",
+			http.MethodPost,
+			"alice-token",
+			"t3:0",
+			http.StatusNotFound,
+		},
+		{
+			"400 deposit status cannot be retried",
+			http.MethodPost,
+			"alice-token",
+			"t2:0",
+			http.StatusBadRequest,
+		},
+		{
+			"200",
+			http.MethodPost,
+			"alice-token",
+			"t1:0",
+			http.StatusOK,
+		},
+		{
+			"404 already retried",
+			http.MethodPost,
+			"alice-token",
+			"t1:0",
+			http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Set("deposit_id", tc.depositID)
+			req, err := http.NewRequest(tc.method, "/api/dead_letters/retry", strings.NewReader(form.Encode()))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tc.status, rr.Code)
+
+			if rr.Code == http.StatusOK {
+				var dl exchange.DeadLetter
+				err := json.Unmarshal(rr.Body.Bytes(), &dl)
+				require.NoError(t, err)
+				require.Equal(t, tc.depositID, dl.DepositID)
+				require.False(t, dl.Pending)
+				require.Equal(t, "alice", dm.retriedBy[tc.depositID])
+			}
+		})
+	}
+
+	// Retrying is disabled without operator tokens
+	m = New(log, Config{}, &dummyBtcAddrMgr{Num: 10}, &dummyEthAddrMgr{Num: 10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dm, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+	req, err = http.NewRequest(http.MethodPost, "/api/dead_letters/retry", strings.NewReader("deposit_id=t1:0"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestReview(t *testing.T) {
+	rm := &dummyReviewManager{
+		dis: []exchange.DepositInfo{
+			{
+				DepositID: "t1:0",
+				Status:    exchange.StatusWaitReview,
+			},
+			{
+				DepositID: "t2:0",
+				Status:    exchange.StatusWaitReview,
+			},
+			{
+				DepositID: "t3:0",
+				Status:    exchange.StatusDone,
+			},
+		},
+	}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+			"bob":   "bob-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{Num: 10}, &dummyEthAddrMgr{Num: 10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, rm, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+	handler := m.setupMux()
+
+	get := func(uri, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, uri, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get("/api/review", "").Code)
+	require.Equal(t, http.StatusUnauthorized, get("/api/review", "wrong").Code)
+
+	rr := get("/api/review", "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var dis []exchange.DepositInfo
+	err := json.Unmarshal(rr.Body.Bytes(), &dis)
+	require.NoError(t, err)
+	require.Equal(t, rm.dis[:2], dis)
+
+	tt := []struct {
+		name      string
+		method    string
+		uri       string
+		token     string
+		depositID string
+		reason    string
+		status    int
+	}{
+		{
+			name:      "405",
+			method:    http.MethodGet,
+			uri:       "/api/review/approve",
+			token:     "alice-token",
+			depositID: "t1:0",
+			status:    http.StatusMethodNotAllowed,
+		},
+		{
+			name:      "401 missing token",
+			method:    http.MethodPost,
+			uri:       "/api/review/approve",
+			depositID: "t1:0",
+			status:    http.StatusUnauthorized,
+		},
+		{
+			name:      "401 wrong token",
+			method:    http.MethodPost,
+			uri:       "/api/review/approve",
+			token:     "wrong",
+			depositID: "t1:0",
+			status:    http.StatusUnauthorized,
+		},
+		{
+			name:   "400 missing deposit_id",
+			method: http.MethodPost,
+			uri:    "/api/review/approve",
+			token:  "alice-token",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:      "404 unknown deposit",
+			method:    http.MethodPost,
+			uri:       "/api/review/approve",
+			token:     "alice-token",
+			depositID: "t9:0",
+			status:    http.StatusNotFound,
+		},
+		{
+			name:      "400 deposit not in review",
+			method:    http.MethodPost,
+			uri:       "/api/review/approve",
+			token:     "alice-token",
+			depositID: "t3:0",
+			status:    http.StatusBadRequest,
+		},
+		{
+			name:      "200 approve",
+			method:    http.MethodPost,
+			uri:       "/api/review/approve",
+			token:     "alice-token",
+			depositID: "t1:0",
+			status:    http.StatusOK,
+		},
+		{
+			name:      "200 reject",
+			method:    http.MethodPost,
+			uri:       "/api/review/reject",
+			token:     "bob-token",
+			depositID: "t2:0",
+			reason:    "sender failed kyc",
+			status:    http.StatusOK,
+		},
+		{
+			name:      "400 already reviewed",
+			method:    http.MethodPost,
+			uri:       "/api/review/reject",
+			token:     "bob-token",
+			depositID: "t1:0",
+			status:    http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Set("deposit_id", tc.depositID)
+			form.Set("reason", tc.reason)
+			req, err := http.NewRequest(tc.method, tc.uri, strings.NewReader(form.Encode()))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tc.status, rr.Code)
+
+			if rr.Code == http.StatusOK {
+				var di exchange.DepositInfo
+				err := json.Unmarshal(rr.Body.Bytes(), &di)
+				require.NoError(t, err)
+				require.Equal(t, tc.depositID, di.DepositID)
+			}
+		})
+	}
+
+	// Decisions are audited with the authenticated operator's name
+	rr = get("/api/review/audit", "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var audits []exchange.ReviewAudit
+	err = json.Unmarshal(rr.Body.Bytes(), &audits)
+	require.NoError(t, err)
+	require.Equal(t, []exchange.ReviewAudit{
+		{
+			Seq:       1,
+			DepositID: "t1:0",
+			Action:    exchange.ReviewActionApprove,
+			Operator:  "alice",
+		},
+		{
+			Seq:       2,
+			DepositID: "t2:0",
+			Action:    exchange.ReviewActionReject,
+			Operator:  "bob",
+			Reason:    "sender failed kyc",
+		},
+	}, audits)
+}
+
+func TestClearKYC(t *testing.T) {
+	rm := &dummyReviewManager{
+		dis: []exchange.DepositInfo{
+			{
+				DepositID:      "t1:0",
+				DepositAddress: "b1",
+				Status:         exchange.StatusKYCHold,
+			},
+			{
+				DepositID:      "t2:0",
+				DepositAddress: "b2",
+				Status:         exchange.StatusKYCHold,
+			},
+		},
+	}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{Num: 10}, &dummyEthAddrMgr{Num: 10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, rm, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+	handler := m.setupMux()
+
+	post := func(address, token string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("address", address)
+		req, err := http.NewRequest(http.MethodPost, "/api/review/kyc/clear", strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post("b1", "").Code)
+	require.Equal(t, http.StatusBadRequest, post("", "alice-token").Code)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/review/kyc/clear", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	// Only the deposits of the cleared address are released
+	rr = post("b1", "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var dis []exchange.DepositInfo
+	err = json.Unmarshal(rr.Body.Bytes(), &dis)
+	require.NoError(t, err)
+	require.Len(t, dis, 1)
+	require.Equal(t, "t1:0", dis[0].DepositID)
+	require.Equal(t, exchange.StatusWaitSend, dis[0].Status)
+	require.Equal(t, exchange.StatusKYCHold, rm.dis[1].Status)
+
+	// An address without held deposits can be cleared ahead of its deposits
+	rr = post("b3", "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "[]", strings.TrimSpace(rr.Body.String()))
+
+	require.Equal(t, []exchange.ReviewAudit{
+		{
+			Seq:      1,
+			Action:   exchange.ReviewActionKYCClear,
+			Operator: "alice",
+			Address:  "b1",
+		},
+		{
+			Seq:      2,
+			Action:   exchange.ReviewActionKYCClear,
+			Operator: "alice",
+			Address:  "b3",
+		},
+	}, rm.audits)
+}
+
+func TestRepricePending(t *testing.T) {
+	rm := &dummyReviewManager{
+		dis: []exchange.DepositInfo{
+			{
+				DepositID:      "t1:0",
+				CoinType:       scanner.CoinTypeBTC,
+				ConversionRate: "100",
+				Status:         exchange.StatusKYCHold,
+			},
+			{
+				DepositID:      "t2:0",
+				CoinType:       scanner.CoinTypeBTC,
+				ConversionRate: "100",
+				Txid:           "sky-tx",
+				Status:         exchange.StatusDone,
+			},
+		},
+	}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{Num: 10}, &dummyEthAddrMgr{Num: 10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, rm, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+	handler := m.setupMux()
+
+	post := func(coinType, rate, token string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("coin_type", coinType)
+		form.Set("rate", rate)
+		form.Set("reason", "rate moved")
+		req, err := http.NewRequest(http.MethodPost, "/api/review/reprice", strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post(scanner.CoinTypeBTC, "150", "").Code)
+	require.Equal(t, http.StatusBadRequest, post("", "150", "alice-token").Code)
+	require.Equal(t, http.StatusBadRequest, post(scanner.CoinTypeBTC, "", "alice-token").Code)
+	require.Equal(t, http.StatusBadRequest, post(scanner.CoinTypeBTC, "bad", "alice-token").Code)
+	require.Equal(t, http.StatusBadRequest, post("SKY", "150", "alice-token").Code)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/review/reprice", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	// Only the deposit that was not sent is repriced
+	rr = post(scanner.CoinTypeBTC, "150", "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var rsp struct {
+		Repriced int `json:"repriced"`
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+	require.NoError(t, err)
+	require.Equal(t, 1, rsp.Repriced)
+	require.Equal(t, "150", rm.dis[0].ConversionRate)
+	require.Equal(t, "100", rm.dis[1].ConversionRate)
+
+	require.Equal(t, []exchange.ReviewAudit{
+		{
+			Seq:       1,
+			Action:    exchange.ReviewActionReprice,
+			Operator:  "alice",
+			Reason:    "rate moved",
+			DepositID: "t1:0",
+			Rate:      "150",
+			PrevRate:  "100",
+		},
+	}, rm.audits)
+}
+
+func TestReviewDisabled(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := New(log, Config{}, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/review", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer ")
+	rr := httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestDrain(t *testing.T) {
+	ss := &dummySendStatus{
+		snapshot: []byte("snapshot"),
+	}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), ss, ss, nil, nil, nil, nil)
+	handler := m.setupMux()
+
+	post := func(uri, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, uri, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post("/api/drain", "").Code)
+	require.Equal(t, http.StatusUnauthorized, post("/api/resume", "wrong").Code)
+	require.False(t, ss.paused)
+
+	rr := post("/api/drain", "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "snapshot", rr.Body.String())
+	require.Equal(t, "application/octet-stream", rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+	require.True(t, ss.paused)
+
+	// Health reports that sending is paused
+	req, err := http.NewRequest(http.MethodGet, "/api/health", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var hr HealthResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &hr)
+	require.NoError(t, err)
+	require.True(t, hr.Paused)
+
+	rr = post("/api/resume", "alice-token")
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.False(t, ss.paused)
+
+	// A failed drain responds with an error
+	ss.drainErr = context.DeadlineExceeded
+	rr = post("/api/drain", "alice-token")
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	require.Empty(t, rr.Header().Get("Content-Disposition"))
+}
+
+func TestPause(t *testing.T) {
+	ss := &dummySendStatus{}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), ss, ss, nil, nil, nil, nil)
+	handler := m.setupMux()
+
+	post := func(uri, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, uri, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/api/pause", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	require.Equal(t, http.StatusUnauthorized, post("/api/pause", "").Code)
+	require.Equal(t, http.StatusUnauthorized, post("/api/pause", "wrong").Code)
+	require.False(t, ss.paused)
+
+	rr = post("/api/pause", "alice-token")
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.True(t, ss.paused)
+
+	rr = post("/api/resume", "alice-token")
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.False(t, ss.paused)
+
+	// A failed pause responds with an error
+	ss.drainErr = context.DeadlineExceeded
+	rr = post("/api/pause", "alice-token")
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	require.False(t, ss.paused)
+}
+
+type dummyDepositExporter struct {
+	err error
+	flt exchange.ExportFilter
+}
+
+func (de *dummyDepositExporter) ExportCSV(w io.Writer, flt exchange.ExportFilter) error {
+	if de.err != nil {
+		return de.err
+	}
+	de.flt = flt
+	_, err := io.WriteString(w, "deposit_id\nfoo-tx:1\n")
+	return err
+}
+
+func TestExportDeposits(t *testing.T) {
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+
+	get := func(query, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, "/api/deposits/export?"+query, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		m.setupMux().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Disabled without an exporter
+	require.Equal(t, http.StatusForbidden, get("", "alice-token").Code)
+
+	de := &dummyDepositExporter{}
+	m.SetDepositExporter(de)
+
+	require.Equal(t, http.StatusUnauthorized, get("", "").Code)
+	require.Equal(t, http.StatusBadRequest, get("status=done,foo", "alice-token").Code)
+	require.Equal(t, http.StatusBadRequest, get("from=March", "alice-token").Code)
+
+	rr := get("status=done,waiting_confirm&from=2018-03-01&to=2018-04-01T12:00:00Z", "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Header().Get("Content-Disposition"), "attachment; filename=\"teller-deposits-")
+	require.Equal(t, "deposit_id\nfoo-tx:1\n", rr.Body.String())
+	require.Equal(t, exchange.ExportFilter{
+		Statuses: []exchange.Status{exchange.StatusDone, exchange.StatusWaitConfirm},
+		From:     time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2018, 4, 1, 12, 0, 0, 0, time.UTC),
+	}, de.flt)
+
+	// An export that fails before writing anything responds with an error
+	de.err = errors.New("ForEachDepositInfo failed")
+	rr = get("", "alice-token")
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	require.Empty(t, rr.Header().Get("Content-Disposition"))
+}
+
+type dummyOverviewGetter struct {
+	ov  exchange.Overview
+	err error
+}
+
+func (o *dummyOverviewGetter) Overview() (exchange.Overview, error) {
+	return o.ov, o.err
+}
+
+func TestOverview(t *testing.T) {
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+		BindEnabled: true,
+	}
+
+	log, _ := testutil.NewLogger(t)
+	btcAddrMgr := &dummyBtcAddrMgr{Num: 3, lowWatermark: 5}
+	ethAddrMgr := &dummyEthAddrMgr{Num: 10}
+	m := New(log, cfg, btcAddrMgr, ethAddrMgr, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{paused: true}, &dummySendStatus{}, nil, nil, nil, nil)
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, "/api/overview", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		m.setupMux().ServeHTTP(rr, req)
+		return rr
+	}
+
+	overview := func() OverviewResponse {
+		rr := get("alice-token")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var rsp OverviewResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rsp))
+		return rsp
+	}
+
+	// Disabled without an overview getter
+	require.Equal(t, http.StatusForbidden, get("alice-token").Code)
+
+	og := &dummyOverviewGetter{
+		ov: exchange.Overview{
+			Deposits: map[string]int{
+				"done":           2,
+				"waiting_send":   1,
+				"waiting_review": 1,
+			},
+			TotalBTCReceived:   3e8,
+			TotalSKYSent:       1500e6,
+			PendingReview:      1,
+			PendingDeadLetters: 2,
+			DBSize:             32768,
+		},
+	}
+	m.SetOverviewGetter(og)
+
+	require.Equal(t, http.StatusUnauthorized, get("").Code)
+
+	m.BackendStatusGetter = dummyBackends{
+		{Addr: "127.0.0.1:6430", Healthy: true, Active: true, Requests: 2},
+	}
+
+	require.Equal(t, OverviewResponse{
+		Overview: og.ov,
+		SoldSKY:  "1500.000000",
+		SaleOpen: true,
+		Paused:   true,
+		SkyBackends: []sender.BackendStatus{
+			{Addr: "127.0.0.1:6430", Healthy: true, Active: true, Requests: 2},
+		},
+		BtcAddressPool: AddressPoolHealth{
+			Remaining:    3,
+			LowWatermark: 5,
+			Low:          true,
+		},
+		EthAddressPool: AddressPoolHealth{
+			Remaining: 10,
+		},
+	}, overview())
+
+	// The sale is closed while frozen
+	fm := &dummyFreezeManager{}
+	m.SetFreezeManager(fm)
+	_, err := fm.Freeze("alice", "incident")
+	require.NoError(t, err)
+
+	rsp := overview()
+	require.True(t, rsp.Frozen)
+	require.False(t, rsp.SaleOpen)
+
+	og.err = errors.New("GetOverview failed")
+	require.Equal(t, http.StatusInternalServerError, get("alice-token").Code)
+}
+
+type dummyTestDataPurger struct {
+	n   int
+	err error
+}
+
+func (p *dummyTestDataPurger) PurgeTestData() (int, error) {
+	return p.n, p.err
+}
+
+func TestPurgeTestData(t *testing.T) {
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+
+	post := func(method, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/api/test_data/purge", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		m.setupMux().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Disabled without a purger
+	require.Equal(t, http.StatusForbidden, post(http.MethodPost, "alice-token").Code)
+
+	p := &dummyTestDataPurger{n: 3}
+	m.SetTestDataPurger(p)
+
+	require.Equal(t, http.StatusMethodNotAllowed, post(http.MethodGet, "alice-token").Code)
+	require.Equal(t, http.StatusUnauthorized, post(http.MethodPost, "").Code)
+
+	rr := post(http.MethodPost, "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var rsp PurgeTestDataResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rsp))
+	require.Equal(t, PurgeTestDataResponse{Purged: 3}, rsp)
+
+	p.err = errors.New("PurgeTestData failed")
+	require.Equal(t, http.StatusInternalServerError, post(http.MethodPost, "alice-token").Code)
+}
+
+type dummyFreezeManager struct {
+	state exchange.FreezeState
+}
+
+func (fm *dummyFreezeManager) Freeze(operator, reason string) (exchange.FreezeState, error) {
+	if fm.state.Frozen {
+		return exchange.FreezeState{}, exchange.ErrFrozen
+	}
+	fm.state = exchange.FreezeState{Frozen: true, Operator: operator, Reason: reason, UpdatedAt: 1}
+	return fm.state, nil
+}
+
+func (fm *dummyFreezeManager) Unfreeze(operator, reason string) (exchange.FreezeState, error) {
+	if !fm.state.Frozen {
+		return exchange.FreezeState{}, exchange.ErrNotFrozen
+	}
+	fm.state = exchange.FreezeState{Operator: operator, Reason: reason, UpdatedAt: 2}
+	return fm.state, nil
+}
+
+func (fm *dummyFreezeManager) FreezeState() (exchange.FreezeState, error) {
+	return fm.state, nil
+}
+
+func TestFreeze(t *testing.T) {
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+
+	post := func(uri string, form url.Values, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		m.setupMux().ServeHTTP(rr, req)
+		return rr
+	}
+
+	health := func() HealthResponse {
+		req, err := http.NewRequest(http.MethodGet, "/api/health", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		m.setupMux().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var hr HealthResponse
+		err = json.Unmarshal(rr.Body.Bytes(), &hr)
+		require.NoError(t, err)
+		return hr
+	}
+
+	form := url.Values{
+		"reason": {"incident 42"},
+	}
+
+	// Disabled without a freeze manager
+	require.Equal(t, http.StatusForbidden, post("/api/freeze", form, "alice-token").Code)
+
+	fm := &dummyFreezeManager{}
+	m.SetFreezeManager(fm)
+
+	require.Equal(t, http.StatusUnauthorized, post("/api/freeze", form, "").Code)
+	require.Equal(t, http.StatusBadRequest, post("/api/freeze", nil, "alice-token").Code)
+	require.Equal(t, http.StatusConflict, post("/api/unfreeze", form, "alice-token").Code)
+	require.False(t, health().Frozen)
+
+	rr := post("/api/freeze", form, "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var state exchange.FreezeState
+	err := json.Unmarshal(rr.Body.Bytes(), &state)
+	require.NoError(t, err)
+	require.Equal(t, exchange.FreezeState{
+		Frozen:    true,
+		Operator:  "alice",
+		Reason:    "incident 42",
+		UpdatedAt: 1,
+	}, state)
+
+	require.Equal(t, http.StatusConflict, post("/api/freeze", form, "alice-token").Code)
+
+	// Health reports the freeze, but stays healthy so that statuses can still be served
+	hr := health()
+	require.True(t, hr.Healthy)
+	require.True(t, hr.Frozen)
+	require.Equal(t, &state, hr.Freeze)
+
+	rr = post("/api/unfreeze", url.Values{"reason": {"resolved"}}, "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.False(t, health().Frozen)
+}
+
+type dummyDepositSimulator struct {
+	err      error
+	coinType string
+	addr     string
+	value    int64
+}
+
+func (ds *dummyDepositSimulator) SimulateDeposit(coinType, depositAddr string, value int64) (string, error) {
+	if ds.err != nil {
+		return "", ds.err
+	}
+	ds.coinType = coinType
+	ds.addr = depositAddr
+	ds.value = value
+	return "simulated-1:0", nil
+}
+
+func TestSimulateDeposit(t *testing.T) {
+	ds := &dummyDepositSimulator{}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, ds, nil, nil)
+	handler := m.setupMux()
+
+	post := func(form url.Values, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, "/api/simulate_deposit", strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	form := url.Values{
+		"addr":  {"1KF5jqK2PeF3cMpD1btWqF1zgu6EkyXVDX"},
+		"value": {"100000"},
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post(form, "").Code)
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"value": {"1"}}, "alice-token").Code)
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"addr": {"a"}, "value": {"x"}}, "alice-token").Code)
+
+	rr := post(form, "alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp SimulateDepositResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	require.NoError(t, err)
+	require.Equal(t, "simulated-1:0", resp.DepositID)
+	require.Equal(t, scanner.CoinTypeBTC, ds.coinType)
+	require.Equal(t, "1KF5jqK2PeF3cMpD1btWqF1zgu6EkyXVDX", ds.addr)
+	require.Equal(t, int64(100000), ds.value)
+
+	ds.err = exchange.ErrSimulatedDepositsDisabled
+	require.Equal(t, http.StatusForbidden, post(form, "alice-token").Code)
+
+	ds.err = exchange.ErrNoBoundAddress
+	require.Equal(t, http.StatusBadRequest, post(form, "alice-token").Code)
+
+	ds.err = exchange.ErrFrozen
+	require.Equal(t, http.StatusServiceUnavailable, post(form, "alice-token").Code)
+}
+
+type dummyReconciler struct {
+	err error
+}
+
+func (rc *dummyReconciler) Reconcile(ctx context.Context) (exchange.ReconcileReport, error) {
+	if rc.err != nil {
+		return exchange.ReconcileReport{}, rc.err
+	}
+	return exchange.ReconcileReport{
+		Deposits:     1,
+		Transactions: 1,
+		Discrepancies: []exchange.Discrepancy{
+			{
+				DepositID: "t1:0",
+				Txid:      "txid-1",
+				Kind:      exchange.DiscrepancyMissingTx,
+				Detail:    "Transaction not found",
+			},
+		},
+	}, nil
+}
+
+func TestReconcile(t *testing.T) {
+	rc := &dummyReconciler{}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, rc, nil)
+	handler := m.setupMux()
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, "/api/reconcile", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get("").Code)
+
+	rr := get("alice-token")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var report exchange.ReconcileReport
+	err := json.Unmarshal(rr.Body.Bytes(), &report)
+	require.NoError(t, err)
+	require.Len(t, report.Discrepancies, 1)
+	require.Equal(t, exchange.DiscrepancyMissingTx, report.Discrepancies[0].Kind)
+
+	rc.err = exchange.ErrReconcileUnavailable
+	require.Equal(t, http.StatusServiceUnavailable, get("alice-token").Code)
+}
+
+type dummyRescanner struct {
+	err      error
+	addr     string
+	coinType string
+}
+
+func (rs *dummyRescanner) Rescan(addr, coinType string) error {
+	rs.addr = addr
+	rs.coinType = coinType
+	return rs.err
+}
+
+func TestRescan(t *testing.T) {
+	rs := &dummyRescanner{}
+
+	cfg := Config{
+		OperatorTokens: map[string]string{
+			"alice": "alice-token",
+		},
+	}
+
+	log, _ := testutil.NewLogger(t)
+	m := New(log, cfg, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, rs)
+	handler := m.setupMux()
+
+	post := func(form url.Values, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, "/api/rescan", strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	form := url.Values{
+		"addr": {"1N8G4JM8krsHLQZjC51R7ZgwDyihmgsQYA"},
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post(form, "").Code)
+	require.Equal(t, http.StatusBadRequest, post(url.Values{}, "alice-token").Code)
+
+	require.Equal(t, http.StatusAccepted, post(form, "alice-token").Code)
+	require.Equal(t, "1N8G4JM8krsHLQZjC51R7ZgwDyihmgsQYA", rs.addr)
+	require.Equal(t, scanner.CoinTypeBTC, rs.coinType)
+
+	rs.err = scanner.ErrRescanInProgress
+	require.Equal(t, http.StatusConflict, post(form, "alice-token").Code)
+
+	rs.err = scanner.ErrNotScanAddress
+	require.Equal(t, http.StatusBadRequest, post(form, "alice-token").Code)
+}
+
+type dummyBackends []sender.BackendStatus
+
+func (b dummyBackends) Backends() []sender.BackendStatus {
+	return b
+}
+
+type dummyPreflightReporter struct {
+	report *exchange.PreflightReport
+}
+
+func (p dummyPreflightReporter) PreflightReport() *exchange.PreflightReport {
+	return p.report
+}
+
+func TestHealth(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	btcAddrMgr := &dummyBtcAddrMgr{Num: 3, lowWatermark: 5}
+	ethAddrMgr := &dummyEthAddrMgr{Num: 10}
+	m := New(log, Config{}, btcAddrMgr, ethAddrMgr, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+	handler := m.setupMux()
+
+	req, err := http.NewRequest(http.MethodGet, "/api/health", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var hr HealthResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &hr)
+	require.NoError(t, err)
+	require.Equal(t, HealthResponse{
+		Healthy: true,
+		BtcAddressPool: AddressPoolHealth{
+			Remaining:    3,
+			LowWatermark: 5,
+			Low:          true,
+		},
+		EthAddressPool: AddressPoolHealth{
+			Remaining: 10,
+		},
+	}, hr)
+
+	// Unhealthy when the deposit store is not writable
+	m.SendStatusGetter = &dummySendStatus{err: exchange.ErrReadOnly}
+
+	req, err = http.NewRequest(http.MethodGet, "/api/health", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	hr = HealthResponse{}
+	err = json.Unmarshal(rr.Body.Bytes(), &hr)
+	require.NoError(t, err)
+	require.False(t, hr.Healthy)
+	require.True(t, hr.ReadOnly)
+	require.Equal(t, exchange.ErrReadOnly.Error(), hr.SendError)
+
+	// The health of the skycoin backends is reported
+	m.BackendStatusGetter = dummyBackends{
+		{Addr: "127.0.0.1:6430", Healthy: false, Requests: 3, Failures: 3, LastError: "connection refused"},
+		{Addr: "127.0.0.1:6431", Healthy: true, Active: true, Requests: 2},
+	}
+
+	req, err = http.NewRequest(http.MethodGet, "/api/health", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+
+	hr = HealthResponse{}
+	err = json.Unmarshal(rr.Body.Bytes(), &hr)
+	require.NoError(t, err)
+	require.Equal(t, []sender.BackendStatus(m.BackendStatusGetter.(dummyBackends)), hr.SkyBackends)
+	require.Nil(t, hr.Preflight)
+
+	// The result of the startup self-test is reported
+	report := &exchange.PreflightReport{
+		Time:   time.Date(2018, 3, 5, 11, 4, 15, 0, time.UTC),
+		Passed: true,
+		Checks: []exchange.PreflightCheck{
+			{Name: "store", OK: true},
+			{Name: "sender", OK: true},
+			{Name: "scanner", OK: true},
+		},
+	}
+	m.SetPreflightReporter(dummyPreflightReporter{report})
+
+	req, err = http.NewRequest(http.MethodGet, "/api/health", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+
+	hr = HealthResponse{}
+	err = json.Unmarshal(rr.Body.Bytes(), &hr)
+	require.NoError(t, err)
+	require.Equal(t, report, hr.Preflight)
+
+	req, err = http.NewRequest(http.MethodPost, "/api/health", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestEvents(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	feed := exchange.NewStatusFeed()
+	m := New(log, Config{EventsToken: "secret"}, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, feed, &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+	m.quit = make(chan struct{})
+	defer close(m.quit)
+
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/events"
+
+	dial := func(token string) (*websocket.Conn, error) {
+		wsCfg, err := websocket.NewConfig(wsURL, srv.URL)
+		require.NoError(t, err)
+		if token != "" {
+			wsCfg.Header.Set("Authorization", "Bearer "+token)
+		}
+		return websocket.DialConfig(wsCfg)
+	}
+
+	// Unauthenticated requests are rejected
+	_, err := dial("")
+	require.Error(t, err)
+	_, err = dial("wrong")
+	require.Error(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/events", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	conn, err := dial("secret")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ev := exchange.StatusEvent{
+		DepositID: "t1:0",
+		Status:    exchange.StatusWaitSend.String(),
+	}
+
+	// The subscription is registered by the handler after the handshake, so publish until received
+	received := make(chan exchange.StatusEvent, 1)
+	go func() {
+		var got exchange.StatusEvent
+		if err := websocket.JSON.Receive(conn, &got); err == nil {
+			received <- got
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		feed.Publish(ev)
+		select {
+		case got := <-received:
+			require.Equal(t, ev.DepositID, got.DepositID)
+			require.Equal(t, ev.Status, got.Status)
+			return
+		case <-timeout:
+			t.Fatal("Did not receive status event")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestEventsDisabled(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := New(log, Config{}, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer ")
+	rr := httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestMetrics(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	// Disabled without a metrics handler
+	m := New(log, Config{}, &dummyBtcAddrMgr{}, &dummyEthAddrMgr{}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, &dummyDeadLetterManager{}, &dummyReviewManager{}, exchange.NewStatusFeed(), &dummySendStatus{}, &dummySendStatus{}, nil, nil, nil, nil)
+
+	req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	registry := metrics.NewRegistry()
+	registry.Counter("teller_deposits_received_total", "Deposits received", metrics.Labels{"coin_type": "BTC"}).Inc()
+	m.cfg.Metrics = registry
+
+	rr = httptest.NewRecorder()
+	m.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, metrics.ContentType, rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Body.String(), `teller_deposits_received_total{coin_type="BTC"} 1`)
+}
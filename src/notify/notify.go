@@ -0,0 +1,84 @@
+// Package notify delivers notifications of key deposit events, e.g. a completed send,
+// to pluggable sinks such as a webhook, Slack, email or a queue.
+package notify
+
+import (
+	"context"
+	"strings"
+)
+
+// EventType is the kind of a NotificationEvent
+type EventType string
+
+const (
+	// EventDepositReceived is sent when a deposit is received from a scanner
+	EventDepositReceived EventType = "deposit_received"
+	// EventSendDone is sent when a deposit's skycoin transaction is confirmed
+	EventSendDone EventType = "send_done"
+	// EventSendFailed is sent when a deposit failed to send and will not be retried automatically
+	EventSendFailed EventType = "send_failed"
+	// EventReviewNeeded is sent when a deposit is set aside for an operator
+	EventReviewNeeded EventType = "review_needed"
+)
+
+// NotificationEvent describes a key event of a deposit
+type NotificationEvent struct {
+	Type           EventType `json:"type"`
+	DepositID      string    `json:"deposit_id"`
+	CoinType       string    `json:"coin_type"`
+	DepositAddress string    `json:"deposit_address"`
+	SkyAddress     string    `json:"skycoin_address"`
+	Status         string    `json:"status"`
+	// Txid of the deposit's skycoin transaction, if it was sent
+	Txid string `json:"txid,omitempty"`
+	// SKY sent, in droplets, if it was sent
+	SkySent uint64 `json:"sky_sent,omitempty"`
+	// Why the deposit failed or was set aside
+	Error     string `json:"error,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Notifier delivers a NotificationEvent to a sink. Notify should give up when ctx is done
+type Notifier interface {
+	Notify(ctx context.Context, event NotificationEvent) error
+}
+
+// Nop is a Notifier that discards every event. It is used when no sink is configured
+type Nop struct{}
+
+// Notify discards the event
+func (Nop) Notify(context.Context, NotificationEvent) error {
+	return nil
+}
+
+// Multi fans out each event to several Notifiers
+type Multi []Notifier
+
+// Notify delivers the event to every Notifier, even if some fail.
+// It returns a MultiError of the failed deliveries, or nil if they all succeeded
+func (m Multi) Notify(ctx context.Context, event NotificationEvent) error {
+	var errs MultiError
+	for _, n := range m {
+		if err := n.Notify(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// MultiError is returned by Multi if any of its Notifiers failed
+type MultiError []error
+
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
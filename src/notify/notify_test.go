@@ -0,0 +1,185 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// capture is a Notifier that records the events it is given. The first fail calls return an error
+type capture struct {
+	sync.Mutex
+	events []NotificationEvent
+	calls  int
+	fail   int
+	block  chan struct{}
+}
+
+func (c *capture) Notify(ctx context.Context, event NotificationEvent) error {
+	if c.block != nil {
+		select {
+		case <-c.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.calls++
+	if c.calls <= c.fail {
+		return errors.New("capture failed")
+	}
+
+	c.events = append(c.events, event)
+	return nil
+}
+
+func (c *capture) captured() []NotificationEvent {
+	c.Lock()
+	defer c.Unlock()
+	return append([]NotificationEvent(nil), c.events...)
+}
+
+func (c *capture) callCount() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.calls
+}
+
+func waitForEvents(t *testing.T, c *capture, n int) []NotificationEvent {
+	for i := 0; i < 100; i++ {
+		if events := c.captured(); len(events) >= n {
+			return events
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	require.Fail(t, "timed out waiting for notifications")
+	return nil
+}
+
+func TestMulti(t *testing.T) {
+	a := &capture{}
+	b := &capture{fail: 1}
+	c := &capture{}
+
+	m := Multi{a, b, c}
+	event := NotificationEvent{Type: EventSendDone, DepositID: "txid:0"}
+
+	err := m.Notify(context.Background(), event)
+	require.Error(t, err)
+	require.IsType(t, MultiError{}, err)
+	require.Len(t, err.(MultiError), 1)
+
+	// Every notifier is called even if one fails
+	require.Equal(t, []NotificationEvent{event}, a.captured())
+	require.Empty(t, b.captured())
+	require.Equal(t, []NotificationEvent{event}, c.captured())
+
+	require.NoError(t, m.Notify(context.Background(), event))
+	require.Equal(t, []NotificationEvent{event}, b.captured())
+}
+
+func TestQueueRetries(t *testing.T) {
+	ok := &capture{}
+	flaky := &capture{fail: 2}
+
+	log, _ := testutil.NewLogger(t)
+	q := NewQueue(log, Multi{ok, flaky})
+	q.retryWait = time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, q.Run())
+	}()
+
+	event := NotificationEvent{Type: EventDepositReceived, DepositID: "txid:0"}
+	require.NoError(t, q.Notify(context.Background(), event))
+
+	require.Equal(t, []NotificationEvent{event}, waitForEvents(t, flaky, 1))
+	require.Equal(t, 3, flaky.callCount())
+
+	// The sink that succeeded is not notified again when the other is retried
+	require.Equal(t, []NotificationEvent{event}, ok.captured())
+	require.Equal(t, 1, ok.callCount())
+
+	q.Shutdown()
+	<-done
+}
+
+func TestQueueDoesNotBlock(t *testing.T) {
+	slow := &capture{block: make(chan struct{})}
+	fast := &capture{}
+
+	log, _ := testutil.NewLogger(t)
+	q := NewQueue(log, Multi{slow, fast})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, q.Run())
+	}()
+
+	// The slow sink holds one event in delivery and queueSize events in its queue, then drops events
+	var err error
+	for i := 0; i < queueSize+2 && err == nil; i++ {
+		err = q.Notify(context.Background(), NotificationEvent{Type: EventSendDone})
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, ErrQueueFull, err)
+
+	// The fast sink is not held up by the slow one
+	waitForEvents(t, fast, queueSize)
+
+	// Shutdown abandons the blocked delivery
+	q.Shutdown()
+	<-done
+	require.Empty(t, slow.captured())
+}
+
+func TestWebhook(t *testing.T) {
+	var received []NotificationEvent
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var event NotificationEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		received = append(received, event)
+
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL)
+	event := NotificationEvent{
+		Type:           EventSendDone,
+		DepositID:      "txid:0",
+		CoinType:       "BTC",
+		DepositAddress: "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS",
+		SkyAddress:     "cBnu9sUvv12dovBmjQKTtfE4rbjMmf3fzW",
+		Status:         "done",
+		Txid:           "skytxid",
+		SkySent:        100e6,
+		CreatedAt:      1500000000,
+	}
+
+	require.NoError(t, w.Notify(context.Background(), event))
+	require.Equal(t, []NotificationEvent{event}, received)
+
+	status = http.StatusInternalServerError
+	require.Error(t, w.Notify(context.Background(), event))
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// queueSize is the number of events buffered for each sink. Events are dropped for a sink that falls this far behind
+	queueSize = 100
+	// deliveryTimeout is how long a single delivery attempt can take
+	deliveryTimeout = time.Second * 30
+	// maxAttempts is the number of times a delivery is attempted before the event is dropped
+	maxAttempts = 5
+	// retryWait is how long to wait before the first retry. It doubles after each attempt
+	retryWait = time.Second * 2
+)
+
+var (
+	// ErrQueueFull is returned by Queue.Notify if an event was dropped for a sink, because the sink's queue is full
+	ErrQueueFull = errors.New("Notification queue is full")
+)
+
+// sink is a Notifier with its own queue of events
+type sink struct {
+	Notifier
+	events chan NotificationEvent
+}
+
+// Queue delivers events to its Notifiers in the background, so that a slow or failing sink never blocks the caller.
+// Each Notifier of a Multi is given its own queue, so a failing sink doesn't delay the others,
+// and a retry doesn't deliver an event twice to the sinks that succeeded.
+// A failed delivery is retried up to maxAttempts times with a growing wait, then the event is dropped.
+// Events still queued when the Queue is shut down are dropped
+type Queue struct {
+	log       logrus.FieldLogger
+	sinks     []*sink
+	retryWait time.Duration
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewQueue creates a Queue that delivers to n
+func NewQueue(log logrus.FieldLogger, n Notifier) *Queue {
+	notifiers, ok := n.(Multi)
+	if !ok {
+		notifiers = Multi{n}
+	}
+
+	sinks := make([]*sink, len(notifiers))
+	for i, n := range notifiers {
+		sinks[i] = &sink{
+			Notifier: n,
+			events:   make(chan NotificationEvent, queueSize),
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Queue{
+		log:       log.WithField("prefix", "teller.notify"),
+		sinks:     sinks,
+		retryWait: retryWait,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+// Notify queues the event for every sink without blocking. It returns ErrQueueFull if it was dropped for any sink
+func (q *Queue) Notify(ctx context.Context, event NotificationEvent) error {
+	var err error
+	for i, s := range q.sinks {
+		select {
+		case s.events <- event:
+		default:
+			q.log.WithField("sink", i).WithField("event", event).Error("Notification queue is full, the event is dropped")
+			err = ErrQueueFull
+		}
+	}
+
+	return err
+}
+
+// Run delivers the queued events until Shutdown is called
+func (q *Queue) Run() error {
+	defer close(q.done)
+
+	var wg sync.WaitGroup
+	for i, s := range q.sinks {
+		wg.Add(1)
+		go func(i int, s *sink) {
+			defer wg.Done()
+			q.runSink(q.log.WithField("sink", i), s)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// Shutdown stops Run, abandoning any delivery in progress
+func (q *Queue) Shutdown() {
+	q.cancel()
+	<-q.done
+}
+
+func (q *Queue) runSink(log logrus.FieldLogger, s *sink) {
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case event := <-s.events:
+			q.deliver(log.WithField("event", event), s, event)
+		}
+	}
+}
+
+// deliver sends an event to a sink, retrying failed attempts
+func (q *Queue) deliver(log logrus.FieldLogger, s *sink, event NotificationEvent) {
+	wait := q.retryWait
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(q.ctx, deliveryTimeout)
+		err := s.Notify(ctx, event)
+		cancel()
+
+		if err == nil {
+			return
+		}
+
+		log := log.WithError(err).WithField("attempt", attempt)
+		if attempt >= maxAttempts {
+			log.Error("Notification failed, the event is dropped")
+			return
+		}
+
+		log.Warning("Notification failed, retrying")
+
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+	}
+}
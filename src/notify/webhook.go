@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout is how long a webhook delivery can take if the Webhook has no Client
+const webhookTimeout = time.Second * 10
+
+// Webhook POSTs each event as JSON to a URL. A response status other than 2xx is an error
+type Webhook struct {
+	URL string
+	// Client sends the requests. A client with a webhookTimeout timeout is used if nil
+	Client *http.Client
+}
+
+// NewWebhook creates a Webhook that POSTs to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL: url,
+	}
+}
+
+// Notify POSTs the event to the webhook's URL
+func (w *Webhook) Notify(ctx context.Context, event NotificationEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{
+			Timeout: webhookTimeout,
+		}
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned status %d", w.URL, rsp.StatusCode)
+	}
+
+	return nil
+}
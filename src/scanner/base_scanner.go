@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	"errors"
 	"sync"
 	"time"
 
@@ -12,6 +13,9 @@ const (
 	depositBufferSize = 100
 )
 
+// ErrRescanInProgress is returned by Rescan if a rescan is already running
+var ErrRescanInProgress = errors.New("A rescan is already in progress")
+
 // CommonScanner defines the interface a scanner should implement
 type CommonScanner interface {
 	GetScanPeriod() time.Duration
@@ -26,6 +30,11 @@ type CommonScanner interface {
 		waitForNextBlock func(*CommonBlock) (*CommonBlock, error),
 		scanBlock func(*CommonBlock) (int, error),
 	) error
+	Rescan(
+		addr, coinType string,
+		getBlockCount func() (int64, error),
+		getBlockAtHeight func(int64) (*CommonBlock, error),
+	) error
 }
 
 // BaseScanner common structure that provide the scanning functionality
@@ -38,6 +47,10 @@ type BaseScanner struct {
 	scannedDeposits chan Deposit
 	quit            chan struct{}
 	done            chan struct{}
+
+	rescanLock sync.Mutex
+	rescanning bool
+	rescanWg   sync.WaitGroup
 }
 
 // CommonVout common transaction output info
@@ -170,11 +183,102 @@ func (s *BaseScanner) GetScannedDepositChan() chan<- Deposit {
 	return s.scannedDeposits
 }
 
-// Shutdown shutdown base scanner
+// Shutdown shutdown base scanner.
+// Deposits that were not acknowledged by the exchange yet, including those waiting in the
+// deposit channels, remain unprocessed in the store and are sent again when the scanner is restarted
 func (s *BaseScanner) Shutdown() {
-	close(s.depositC)
 	close(s.quit)
 	<-s.done
+	s.rescanWg.Wait()
+	// depositC is closed once nothing can send to it anymore
+	close(s.depositC)
+}
+
+// Rescan starts rescanning the blocks from the initial scan height to the current confirmed height
+// for deposits to addr, in the background. Deposits that were not already recorded are processed
+// like newly scanned deposits; deposits that were already recorded are skipped, so they are never
+// processed twice. Only one rescan runs at a time, otherwise ErrRescanInProgress is returned.
+func (s *BaseScanner) Rescan(
+	addr, coinType string,
+	getBlockCount func() (int64, error),
+	getBlockAtHeight func(int64) (*CommonBlock, error),
+) error {
+	addrs, err := s.store.GetScanAddresses(coinType)
+	if err != nil {
+		return err
+	}
+	if !containsAddress(addrs, addr) {
+		return ErrNotScanAddress
+	}
+
+	s.rescanLock.Lock()
+	defer s.rescanLock.Unlock()
+	if s.rescanning {
+		return ErrRescanInProgress
+	}
+
+	bestHeight, err := getBlockCount()
+	if err != nil {
+		return err
+	}
+
+	s.rescanning = true
+	s.rescanWg.Add(1)
+	go func() {
+		defer s.rescanWg.Done()
+		defer func() {
+			s.rescanLock.Lock()
+			defer s.rescanLock.Unlock()
+			s.rescanning = false
+		}()
+
+		s.rescan(addr, coinType, bestHeight-s.Cfg.ConfirmationsRequired, getBlockAtHeight)
+	}()
+
+	return nil
+}
+
+func (s *BaseScanner) rescan(addr, coinType string, toHeight int64, getBlockAtHeight func(int64) (*CommonBlock, error)) {
+	log := s.log.WithFields(logrus.Fields{
+		"addr":       addr,
+		"fromHeight": s.Cfg.InitialScanHeight,
+		"toHeight":   toHeight,
+	})
+	log.Info("Rescanning address")
+
+	deposits := 0
+	for height := s.Cfg.InitialScanHeight; height <= toHeight; height++ {
+		select {
+		case <-s.quit:
+			log.Info("Rescan stopped by shutdown")
+			return
+		default:
+		}
+
+		block, err := getBlockAtHeight(height)
+		if err != nil {
+			log.WithError(err).WithField("height", height).Error("Rescan failed, getBlockAtHeight failed")
+			return
+		}
+
+		dvs, err := s.store.RescanBlock(block, coinType, addr)
+		if err != nil {
+			log.WithError(err).WithField("height", height).Error("Rescan failed, store.RescanBlock failed")
+			return
+		}
+
+		for _, dv := range dvs {
+			log.WithField("deposit", dv).Warning("Rescan found a missed deposit")
+			select {
+			case <-s.quit:
+				return
+			case s.scannedDeposits <- dv:
+				deposits++
+			}
+		}
+	}
+
+	log.WithField("deposits", deposits).Info("Rescan finished")
 }
 
 // Run starts the scanner
@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// runBaseScanner runs a BaseScanner on a chain of one block, without scanning new deposits
+func runBaseScanner(t *testing.T, store Storer) (*BaseScanner, chan error) {
+	log, _ := testutil.NewLogger(t)
+	s := NewBaseScanner(store, log, Config{
+		ScanPeriod: time.Millisecond * 10,
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(func() (int64, error) {
+			return 0, nil
+		}, func(int64) (*CommonBlock, error) {
+			return &CommonBlock{}, nil
+		}, func(*CommonBlock) (*CommonBlock, error) {
+			<-s.quit
+			return nil, errQuit
+		}, func(*CommonBlock) (int, error) {
+			return 0, nil
+		})
+	}()
+
+	return s, done
+}
+
+func TestBaseScannerShutdownKeepsUnacknowledgedDeposits(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+	err = store.AddSupportedCoin(CoinTypeBTC)
+	require.NoError(t, err)
+
+	dvs := []Deposit{
+		{
+			CoinType: CoinTypeBTC,
+			Address:  "b1",
+			Value:    1,
+			Height:   1,
+			Tx:       "t1",
+			N:        1,
+		},
+		{
+			CoinType: CoinTypeBTC,
+			Address:  "b2",
+			Value:    2,
+			Height:   1,
+			Tx:       "t2",
+			N:        1,
+		},
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, dv := range dvs {
+			if err := store.pushDepositTx(tx, dv); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The exchange acknowledges the first deposit, then teller shuts down
+	// while the second deposit is waiting to be received
+	s, done := runBaseScanner(t, store)
+
+	var acked DepositNote
+	select {
+	case acked = <-s.GetDeposit():
+	case <-time.After(time.Second * 5):
+		t.Fatal("Waiting for a deposit timed out")
+	}
+	acked.ErrC <- nil
+
+	for {
+		unprocessed, err := store.GetUnprocessedDeposits()
+		require.NoError(t, err)
+		if len(unprocessed) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	s.Shutdown()
+	require.NoError(t, <-done)
+
+	// The unacknowledged deposit is sent again on restart
+	unprocessed, err := store.GetUnprocessedDeposits()
+	require.NoError(t, err)
+	require.Len(t, unprocessed, 1)
+	require.NotEqual(t, acked.Deposit.ID(), unprocessed[0].ID())
+
+	s, done = runBaseScanner(t, store)
+
+	select {
+	case dn := <-s.GetDeposit():
+		require.Equal(t, unprocessed[0], dn.Deposit)
+		dn.ErrC <- nil
+	case <-time.After(time.Second * 5):
+		t.Fatal("Waiting for the unacknowledged deposit timed out")
+	}
+
+	s.Shutdown()
+	require.NoError(t, <-done)
+}
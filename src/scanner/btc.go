@@ -101,6 +101,12 @@ func (s *BTCScanner) GetBlockCount() (int64, error) {
 	return s.btcClient.GetBlockCount()
 }
 
+// Ping checks that the bitcoin node is reachable
+func (s *BTCScanner) Ping() error {
+	_, err := s.btcClient.GetBlockCount()
+	return err
+}
+
 // getBlockAtHeight returns that block at a specific height
 func (s *BTCScanner) getBlockAtHeight(height int64) (*CommonBlock, error) {
 	log := s.log.WithField("blockHeight", height)
@@ -241,11 +247,21 @@ func (s *BTCScanner) AddScanAddress(addr, coinType string) error {
 	return s.Base.GetStorer().AddScanAddress(addr, coinType)
 }
 
+// AddScanAddresses adds multiple scan addresses. No addresses are added if any of them fails
+func (s *BTCScanner) AddScanAddresses(addrs []string, coinType string) error {
+	return s.Base.GetStorer().AddScanAddresses(addrs, coinType)
+}
+
 // GetScanAddresses returns the deposit addresses that need to scan
 func (s *BTCScanner) GetScanAddresses() ([]string, error) {
 	return s.Base.GetStorer().GetScanAddresses(CoinTypeBTC)
 }
 
+// Rescan rescans the chain for missed deposits to addr, in the background. See BaseScanner.Rescan
+func (s *BTCScanner) Rescan(addr string) error {
+	return s.Base.Rescan(addr, CoinTypeBTC, s.GetBlockCount, s.getBlockAtHeight)
+}
+
 //GetDeposit returns channel of depositnote
 func (s *BTCScanner) GetDeposit() <-chan DepositNote {
 	return s.Base.GetDeposit()
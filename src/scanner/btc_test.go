@@ -501,6 +501,84 @@ func testBtcScannerInitialGetBlockHashError(t *testing.T, btcDB *bolt.DB) {
 	require.Equal(t, errNoBlockHash, err)
 }
 
+func testBtcScannerRescan(t *testing.T, btcDB *bolt.DB) {
+	// Test that Rescan finds a deposit that was not recorded by the scan loop,
+	// and does not redeliver deposits that were already recorded
+	scr, shutdown := setupBtcScanner(t, btcDB)
+	defer shutdown()
+
+	// Rescan looks up every block by height, set all of their hashes
+	rpc := scr.btcClient.(*dummyBtcrpcclient)
+	for height := int64(235205); height < rpc.blockCount; height++ {
+		hash, err := rpc.GetBlockHash(height)
+		require.NoError(t, err)
+		block, err := rpc.GetBlockVerboseTx(hash)
+		require.NoError(t, err)
+		rpc.blockHashes[height+1] = block.NextHash
+	}
+
+	// This address has:
+	// 1 deposit, in block 235206
+	// 1 deposit, in block 235207
+	addr := "1N8G4JM8krsHLQZjC51R7ZgwDyihmgsQYA"
+	err := scr.AddScanAddress(addr, CoinTypeBTC)
+	require.NoError(t, err)
+
+	err = scr.Rescan("1LcEkgX8DCrQczLMVh9LDTRnkdVV2oun3A")
+	require.Equal(t, ErrNotScanAddress, err)
+
+	deposits := make(chan Deposit, 10)
+	go func() {
+		for dv := range scr.GetDeposit() {
+			dv.ErrC <- nil
+			deposits <- dv.Deposit
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := scr.Run()
+		require.NoError(t, err)
+	}()
+	defer func() {
+		scr.Shutdown()
+		<-done
+	}()
+
+	receive := func() Deposit {
+		select {
+		case dv := <-deposits:
+			return dv
+		case <-time.After(minShutdownWait):
+			t.Fatal("Waiting for deposit timed out")
+			return Deposit{}
+		}
+	}
+
+	first := receive()
+	receive()
+
+	// Forget the first deposit, as if the scanner had missed it
+	err = scr.Base.GetStorer().(*Store).db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(DepositBkt).Delete([]byte(first.ID()))
+	})
+	require.NoError(t, err)
+
+	err = scr.Rescan(addr)
+	require.NoError(t, err)
+
+	// Only the missed deposit is delivered again
+	dv := receive()
+	require.Equal(t, first.ID(), dv.ID())
+
+	select {
+	case dv := <-deposits:
+		t.Fatalf("Unexpected deposit %s", dv.ID())
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
 func TestBtcScanner(t *testing.T) {
 	btcDB := openDummyBtcDB(t)
 	defer testutil.CheckError(t, btcDB.Close)
@@ -568,5 +646,57 @@ func TestBtcScanner(t *testing.T) {
 			}
 			testBtcScannerBlockNextHashAppears(t, btcDB)
 		})
+
+		t.Run("Rescan", func(t *testing.T) {
+			if parallel {
+				t.Parallel()
+			}
+			testBtcScannerRescan(t, btcDB)
+		})
 	})
 }
+
+func TestBtcBlock2CommonBlockSatoshis(t *testing.T) {
+	// btcd reports output values as float64 BTC. They are converted to integer
+	// satoshis here, at the scanner boundary, and never handled as floats afterwards
+	tt := []struct {
+		btc      float64
+		satoshis int64
+	}{
+		{0, 0},
+		{0.00000001, 1},
+		{0.1, 10000000},
+		{0.29, 29000000},
+		{0.3, 30000000},
+		{1.00000001, 100000001},
+		{12.34567891, 1234567891},
+		{20999999.9769, 2099999997690000},
+	}
+
+	block := &btcjson.GetBlockVerboseResult{
+		Hash:   "foo",
+		Height: 1,
+		RawTx: []btcjson.TxRawResult{
+			{
+				Txid: "bar",
+			},
+		},
+	}
+	for _, tc := range tt {
+		block.RawTx[0].Vout = append(block.RawTx[0].Vout, btcjson.Vout{
+			Value: tc.btc,
+			ScriptPubKey: btcjson.ScriptPubKeyResult{
+				Addresses: []string{"1Bmp9Kv9vcbjNKfNxCrmL2YMJvZ3XGJ7uJ"},
+			},
+		})
+	}
+
+	cb, err := btcBlock2CommonBlock(block)
+	require.NoError(t, err)
+	require.Len(t, cb.RawTx, 1)
+	require.Len(t, cb.RawTx[0].Vout, len(tt))
+
+	for i, tc := range tt {
+		require.Equal(t, tc.satoshis, cb.RawTx[0].Vout[i].Value, "%v BTC", tc.btc)
+	}
+}
@@ -43,6 +43,11 @@ func (s *DummyScanner) RegisterCoinType(coinType string) {
 
 // AddScanAddress adds an address
 func (s *DummyScanner) AddScanAddress(addr, coinType string) error {
+	return s.AddScanAddresses([]string{addr}, coinType)
+}
+
+// AddScanAddresses adds multiple addresses. No addresses are added if any of them fails
+func (s *DummyScanner) AddScanAddresses(addrs []string, coinType string) error {
 	s.Lock()
 	defer s.Unlock()
 
@@ -50,12 +55,21 @@ func (s *DummyScanner) AddScanAddress(addr, coinType string) error {
 		return fmt.Errorf("Invalid coin type \"%s\"", coinType)
 	}
 
-	if _, ok := s.addrsMap[addr]; ok {
-		return NewDuplicateDepositAddressErr(addr)
+	added := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if _, ok := s.addrsMap[addr]; ok {
+			return NewDuplicateDepositAddressErr(addr)
+		}
+		if _, ok := added[addr]; ok {
+			return NewDuplicateDepositAddressErr(addr)
+		}
+		added[addr] = struct{}{}
 	}
 
-	s.addrsMap[addr] = struct{}{}
-	s.addrs = append(s.addrs, addr)
+	for _, addr := range addrs {
+		s.addrsMap[addr] = struct{}{}
+		s.addrs = append(s.addrs, addr)
+	}
 
 	return nil
 }
@@ -76,6 +90,19 @@ func (s *DummyScanner) GetDeposit() <-chan DepositNote {
 	return s.deposits
 }
 
+// Rescan does nothing, the dummy scanner has no chain to rescan.
+// Returns ErrNotScanAddress if addr is not a scan address
+func (s *DummyScanner) Rescan(addr string) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.addrsMap[addr]; !ok {
+		return ErrNotScanAddress
+	}
+
+	return nil
+}
+
 // HTTP Interface
 
 // BindHandlers binds dummy scanner HTTP handlers
@@ -135,16 +135,32 @@ func (s *ETHScanner) waitForNextBlock(block *CommonBlock) (*CommonBlock, error)
 	}
 }
 
+// Ping checks that the ethereum node is reachable
+func (s *ETHScanner) Ping() error {
+	_, err := s.ethClient.GetBlockCount()
+	return err
+}
+
 // AddScanAddress adds new scan address
 func (s *ETHScanner) AddScanAddress(addr, coinType string) error {
 	return s.Base.GetStorer().AddScanAddress(addr, coinType)
 }
 
+// AddScanAddresses adds multiple scan addresses. No addresses are added if any of them fails
+func (s *ETHScanner) AddScanAddresses(addrs []string, coinType string) error {
+	return s.Base.GetStorer().AddScanAddresses(addrs, coinType)
+}
+
 // GetScanAddresses returns the deposit addresses that need to scan
 func (s *ETHScanner) GetScanAddresses() ([]string, error) {
 	return s.Base.GetStorer().GetScanAddresses(CoinTypeETH)
 }
 
+// Rescan rescans the chain for missed deposits to addr, in the background. See BaseScanner.Rescan
+func (s *ETHScanner) Rescan(addr string) error {
+	return s.Base.Rescan(addr, CoinTypeETH, s.ethClient.GetBlockCount, s.getBlockAtHeight)
+}
+
 // GetDeposit returns deposit value channel.
 func (s *ETHScanner) GetDeposit() <-chan DepositNote {
 	return s.Base.GetDeposit()
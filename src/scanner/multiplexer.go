@@ -3,6 +3,7 @@ package scanner
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -61,6 +62,60 @@ func (m *Multiplexer) AddScanAddress(depositAddr, coinType string) error {
 	return scanner.AddScanAddress(depositAddr, coinType)
 }
 
+// AddScanAddresses adds multiple scan addresses to the scanner of coinType in one call.
+// Scanners add the addresses atomically: if an error is returned, none were added.
+func (m *Multiplexer) AddScanAddresses(depositAddrs []string, coinType string) error {
+	m.RWMutex.Lock()
+	defer m.RWMutex.Unlock()
+
+	scanner, ok := m.scannerMap[coinType]
+	if !ok {
+		return fmt.Errorf("unknown cointype \"%s\"", coinType)
+	}
+
+	return scanner.AddScanAddresses(depositAddrs, coinType)
+}
+
+// GetScanAddresses returns the deposit addresses watched by the scanner of coinType
+func (m *Multiplexer) GetScanAddresses(coinType string) ([]string, error) {
+	m.RWMutex.RLock()
+	defer m.RWMutex.RUnlock()
+
+	scanner, ok := m.scannerMap[coinType]
+	if !ok {
+		return nil, ErrUnsupportedCoinType
+	}
+
+	return scanner.GetScanAddresses()
+}
+
+// CoinTypes returns the coin types that have a scanner, sorted
+func (m *Multiplexer) CoinTypes() []string {
+	m.RWMutex.RLock()
+	defer m.RWMutex.RUnlock()
+
+	coinTypes := make([]string, 0, len(m.scannerMap))
+	for coinType := range m.scannerMap {
+		coinTypes = append(coinTypes, coinType)
+	}
+	sort.Strings(coinTypes)
+
+	return coinTypes
+}
+
+// Rescan rescans the chain of coinType for missed deposits to depositAddr, in the background
+func (m *Multiplexer) Rescan(depositAddr, coinType string) error {
+	m.RWMutex.RLock()
+	defer m.RWMutex.RUnlock()
+
+	scanner, ok := m.scannerMap[coinType]
+	if !ok {
+		return ErrUnsupportedCoinType
+	}
+
+	return scanner.Rescan(depositAddr)
+}
+
 // ValidateCoinType returns an error if the coinType is invalid
 func (m *Multiplexer) ValidateCoinType(coinType string) error {
 	m.RWMutex.RLock()
@@ -134,3 +189,30 @@ func (m *Multiplexer) GetScanner(coinType string) Scanner {
 	}
 	return scanner
 }
+
+// Pinger is a Scanner that can check that its node is reachable
+type Pinger interface {
+	Ping() error
+}
+
+// Ping checks that the node of every scanner is reachable.
+// Scanners that do not implement Pinger are skipped
+func (m *Multiplexer) Ping() error {
+	coinTypes := m.CoinTypes()
+
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, coinType := range coinTypes {
+		p, ok := m.scannerMap[coinType].(Pinger)
+		if !ok {
+			continue
+		}
+
+		if err := p.Ping(); err != nil {
+			return fmt.Errorf("%s scanner: %v", coinType, err)
+		}
+	}
+
+	return nil
+}
@@ -209,3 +209,59 @@ func TestMultiplexerForAll(t *testing.T) {
 	require.NoError(t, err)
 	<-done
 }
+
+// pingScanner is a Scanner whose Ping returns err
+type pingScanner struct {
+	Scanner
+	err error
+}
+
+func (s pingScanner) Ping() error {
+	return s.err
+}
+
+func TestMultiplexerPing(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := NewMultiplexer(log)
+
+	// No scanners
+	require.NoError(t, m.Ping())
+
+	err := m.AddScanner(pingScanner{}, CoinTypeBTC)
+	require.NoError(t, err)
+	require.NoError(t, m.Ping())
+
+	err = m.AddScanner(pingScanner{err: errors.New("connection refused")}, CoinTypeETH)
+	require.NoError(t, err)
+	require.Equal(t, errors.New("ETH scanner: connection refused"), m.Ping())
+}
+
+func TestMultiplexerGetScanAddresses(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := NewMultiplexer(log)
+
+	btc := NewDummyScanner(log)
+	btc.RegisterCoinType(CoinTypeBTC)
+	eth := NewDummyScanner(log)
+	eth.RegisterCoinType(CoinTypeETH)
+
+	err := m.AddScanner(eth, CoinTypeETH)
+	require.NoError(t, err)
+	err = m.AddScanner(btc, CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, []string{CoinTypeBTC, CoinTypeETH}, m.CoinTypes())
+
+	err = m.AddScanAddresses([]string{"b1", "b2"}, CoinTypeBTC)
+	require.NoError(t, err)
+
+	addrs, err := m.GetScanAddresses(CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b1", "b2"}, addrs)
+
+	addrs, err = m.GetScanAddresses(CoinTypeETH)
+	require.NoError(t, err)
+	require.Empty(t, addrs)
+
+	_, err = m.GetScanAddresses("SKY")
+	require.Equal(t, ErrUnsupportedCoinType, err)
+}
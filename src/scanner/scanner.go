@@ -11,7 +11,10 @@ import (
 // Scanner provids apis for interacting with a scan service
 type Scanner interface {
 	AddScanAddress(string, string) error
+	AddScanAddresses([]string, string) error
+	GetScanAddresses() ([]string, error)
 	GetDeposit() <-chan DepositNote
+	Rescan(string) error
 }
 
 // BtcRPCClient rpcclient interface
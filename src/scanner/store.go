@@ -27,6 +27,9 @@ var (
 
 	// ErrUnsupportedCoinType unsupported coin type
 	ErrUnsupportedCoinType = errors.New("unsupported coin type")
+
+	// ErrNotScanAddress is returned when rescanning an address that is not scanned
+	ErrNotScanAddress = errors.New("address is not a scan address")
 )
 
 const scanMetaBktPrefix = "scan_meta"
@@ -103,9 +106,11 @@ func NewDuplicateDepositAddressErr(addr string) error {
 type Storer interface {
 	GetScanAddresses(string) ([]string, error)
 	AddScanAddress(string, string) error
+	AddScanAddresses([]string, string) error
 	SetDepositProcessed(string) error
 	GetUnprocessedDeposits() ([]Deposit, error)
 	ScanBlock(*CommonBlock, string) ([]Deposit, error)
+	RescanBlock(*CommonBlock, string, string) ([]Deposit, error)
 }
 
 // Store records scanner meta info for BTC deposits
@@ -186,26 +191,40 @@ func (s *Store) getScanAddressesTx(tx *bolt.Tx, coinType string) ([]string, erro
 
 // AddScanAddress adds an address to the scan list
 func (s *Store) AddScanAddress(addr, coinType string) error {
+	return s.AddScanAddresses([]string{addr}, coinType)
+}
+
+// AddScanAddresses adds multiple addresses to the scan list in one transaction.
+// The addition is atomic: if any address is a duplicate, either of an address
+// already in the scan list or of another address in addrs,
+// a DuplicateDepositAddressErr is returned and no addresses are added.
+func (s *Store) AddScanAddresses(addrs []string, coinType string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		addrs, err := s.getScanAddressesTx(tx, coinType)
+		existingAddrs, err := s.getScanAddressesTx(tx, coinType)
 		if err != nil {
 			return err
 		}
 
+		addrsMap := make(map[string]struct{}, len(existingAddrs)+len(addrs))
+		for _, a := range existingAddrs {
+			addrsMap[a] = struct{}{}
+		}
+
 		for _, a := range addrs {
-			if a == addr {
-				return NewDuplicateDepositAddressErr(addr)
+			if _, ok := addrsMap[a]; ok {
+				return NewDuplicateDepositAddressErr(a)
 			}
+			addrsMap[a] = struct{}{}
 		}
 
-		addrs = append(addrs, addr)
+		existingAddrs = append(existingAddrs, addrs...)
 
 		scanBktFullName, err := GetScanMetaBkt(coinType)
 		if err != nil {
 			return err
 		}
 
-		return dbutil.PutBucketValue(tx, scanBktFullName, depositAddressesKey, addrs)
+		return dbutil.PutBucketValue(tx, scanBktFullName, depositAddressesKey, existingAddrs)
 	})
 }
 
@@ -267,17 +286,40 @@ func (s *Store) pushDepositTx(tx *bolt.Tx, dv Deposit) error {
 	return dbutil.PutBucketValue(tx, DepositBkt, key, dv)
 }
 
+// checkDepositHeightTx warns if a deposit that already exists is seen in a block of a different height,
+// which means the chain was reorganized after the deposit was recorded. The recorded deposit keeps its height
+func (s *Store) checkDepositHeightTx(tx *bolt.Tx, dv Deposit) {
+	log := s.log.WithField("deposit", dv)
+
+	var stored Deposit
+	if err := dbutil.GetBucketObject(tx, DepositBkt, dv.ID(), &stored); err != nil {
+		log.WithError(err).Error("GetBucketObject failed")
+		return
+	}
+
+	if stored.Height != dv.Height {
+		log.WithField("recordedHeight", stored.Height).Warning("Deposit seen in a block of a different height than recorded, the chain was reorganized")
+	}
+}
+
 // ScanBlock scans a coin block for deposits and adds them
 // If the deposit already exists, the result is omitted from the returned list
 func (s *Store) ScanBlock(block *CommonBlock, coinType string) ([]Deposit, error) {
-	return s.scanBlock(block, coinType)
+	return s.scanBlock(block, coinType, "")
+}
+
+// RescanBlock scans a coin block for deposits to a single scan address and adds them.
+// If the deposit already exists, the result is omitted from the returned list.
+// Returns ErrNotScanAddress if addr is not a scan address.
+func (s *Store) RescanBlock(block *CommonBlock, coinType, addr string) ([]Deposit, error) {
+	return s.scanBlock(block, coinType, addr)
 }
 
 // scanBlock scans a coin block for deposits and adds them
-// 1. get deposit address by coinType
+// 1. get deposit address by coinType, or only addr if set
 // 2. call callback function to get deposit
 // 3. push deposit into db, finished at one transaction
-func (s *Store) scanBlock(block *CommonBlock, coinType string) ([]Deposit, error) {
+func (s *Store) scanBlock(block *CommonBlock, coinType, addr string) ([]Deposit, error) {
 	var dvs []Deposit
 
 	if err := s.db.Update(func(tx *bolt.Tx) error {
@@ -287,6 +329,13 @@ func (s *Store) scanBlock(block *CommonBlock, coinType string) ([]Deposit, error
 			return err
 		}
 
+		if addr != "" {
+			if !containsAddress(addrs, addr) {
+				return ErrNotScanAddress
+			}
+			addrs = []string{addr}
+		}
+
 		deposits, err := scanSpecifiedBlock(block, coinType, addrs)
 		if err != nil {
 			s.log.WithError(err).Error("ScanBlock failed")
@@ -299,6 +348,7 @@ func (s *Store) scanBlock(block *CommonBlock, coinType string) ([]Deposit, error
 				switch err.(type) {
 				case DepositExistsErr:
 					log.Warning("Deposit already exists in db")
+					s.checkDepositHeightTx(tx, dv)
 					continue
 				default:
 					log.WithError(err).Error("pushDepositTx failed")
@@ -317,6 +367,15 @@ func (s *Store) scanBlock(block *CommonBlock, coinType string) ([]Deposit, error
 	return dvs, nil
 }
 
+func containsAddress(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
 // ScanBTCBlock scan the given block and returns the next block hash or error
 func scanSpecifiedBlock(block *CommonBlock, coinType string, depositAddrs []string) ([]Deposit, error) {
 	var dv []Deposit
@@ -151,6 +151,65 @@ func TestAddDepositAddress(t *testing.T) {
 	}
 }
 
+func TestAddDepositAddresses(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		initAddrs   []string
+		addAddrs    []string
+		expectAddrs []string
+		err         error
+	}{
+		{
+			"ok",
+			[]string{"a1"},
+			[]string{"a2", "a3"},
+			[]string{"a1", "a2", "a3"},
+			nil,
+		},
+		{
+			"dup existing",
+			[]string{"a1", "a2"},
+			[]string{"a3", "a2"},
+			[]string{"a1", "a2"},
+			NewDuplicateDepositAddressErr("a2"),
+		},
+		{
+			"dup in batch",
+			[]string{"a1"},
+			[]string{"a2", "a3", "a2"},
+			[]string{"a1"},
+			NewDuplicateDepositAddressErr("a2"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, shutdown := testutil.PrepareDB(t)
+			defer shutdown()
+			log, _ := testutil.NewLogger(t)
+
+			s, err := NewStore(log, db)
+			require.NoError(t, err)
+			err = s.AddSupportedCoin(CoinTypeBTC)
+			require.NoError(t, err)
+
+			err = db.Update(func(tx *bolt.Tx) error {
+				scanBktFullName := MustGetScanMetaBkt(CoinTypeBTC)
+				return dbutil.PutBucketValue(tx, scanBktFullName, depositAddressesKey, tc.initAddrs)
+			})
+			require.NoError(t, err)
+
+			err = s.AddScanAddresses(tc.addAddrs, CoinTypeBTC)
+			require.Equal(t, tc.err, err)
+
+			// A failed batch must not add any addresses
+			addrs, err := s.GetScanAddresses(CoinTypeBTC)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectAddrs, addrs)
+		})
+	}
+}
+
 func TestPushDeposit(t *testing.T) {
 	db, shutdown := testutil.PrepareDB(t)
 	defer shutdown()
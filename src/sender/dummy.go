@@ -21,6 +21,9 @@ import (
 
 const seed = "survey tank about rely harbor client penalty antenna labor target jaguar bind"
 
+// DummyMaxMemoLength is the maximum length of a DummySender memo
+const DummyMaxMemoLength = 64
+
 func randSHA256() (cipher.SHA256, error) {
 	b := make([]byte, 128)
 	_, err := rand.Read(b)
@@ -62,28 +65,37 @@ func NewDummySender(log logrus.FieldLogger) *DummySender {
 	}
 }
 
-// CreateTransaction creates a fake skycoin transaction
-func (s *DummySender) CreateTransaction(addr string, coins uint64) (*coin.Transaction, error) {
-	if coins > s.coins {
-		return nil, NewRPCError(errors.New("CreateTransaction not enough coins"))
+// CreateTransaction creates a fake skycoin transaction. Its outputs have no coin hours, whatever the option
+func (s *DummySender) CreateTransaction(addr string, coins uint64, opt SendOption) (*coin.Transaction, error) {
+	return s.CreateBatchTransaction([]Recipient{
+		{
+			Addr:  addr,
+			Coins: coins,
+		},
+	}, opt)
+}
+
+// CreateBatchTransaction creates a fake skycoin transaction with an output to each recipient.
+// Its outputs have no coin hours, whatever the option
+func (s *DummySender) CreateBatchTransaction(recipients []Recipient, opt SendOption) (*coin.Transaction, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
 	}
 
-	c, err := droplet.ToString(coins)
-	if err != nil {
-		s.log.WithError(err).Error("droplet.ToString failed")
+	if err := ValidateMemo(s, opt.Memo); err != nil {
 		return nil, err
 	}
 
-	s.log.WithFields(logrus.Fields{
-		"addr":     addr,
-		"droplets": coins,
-		"coins":    c,
-	}).Info("CreateTransaction")
+	var total uint64
+	for _, r := range recipients {
+		total += r.Coins
+	}
 
-	a, err := cipher.DecodeBase58Address(addr)
-	if err != nil {
-		s.log.WithError(err).Error("CreateTransaction called with invalid address")
-		return nil, err
+	if total > s.coins {
+		return nil, RPCError{
+			error: errors.New("CreateTransaction not enough coins"),
+			Kind:  RPCErrorInsufficientBalance,
+		}
 	}
 
 	randomInput, err := randSHA256()
@@ -94,11 +106,39 @@ func (s *DummySender) CreateTransaction(addr string, coins uint64) (*coin.Transa
 
 	txn := &coin.Transaction{}
 	txn.PushInput(randomInput)
-	txn.PushOutput(a, coins, 0)
+
+	for _, r := range recipients {
+		c, err := droplet.ToString(r.Coins)
+		if err != nil {
+			s.log.WithError(err).Error("droplet.ToString failed")
+			return nil, err
+		}
+
+		s.log.WithFields(logrus.Fields{
+			"addr":     r.Addr,
+			"droplets": r.Coins,
+			"coins":    c,
+			"option":   opt,
+		}).Info("CreateTransaction")
+
+		a, err := cipher.DecodeBase58Address(r.Addr)
+		if err != nil {
+			s.log.WithError(err).Error("CreateTransaction called with invalid address")
+			return nil, err
+		}
+
+		txn.PushOutput(a, r.Coins, 0)
+	}
+
 	txn.SignInputs([]cipher.SecKey{s.secKey})
 	return txn, nil
 }
 
+// MaxMemoLength returns DummyMaxMemoLength. The memo of a fake transaction is only logged
+func (s *DummySender) MaxMemoLength() int {
+	return DummyMaxMemoLength
+}
+
 // BroadcastTransaction broadcasts a fake skycoin transaction
 func (s *DummySender) BroadcastTransaction(txn *coin.Transaction) *BroadcastTxResponse {
 	s.log.WithField("txid", txn.TxIDHex()).Info("BroadcastTransaction")
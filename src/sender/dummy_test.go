@@ -1,6 +1,7 @@
 package sender
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -16,7 +17,7 @@ func TestDummySender(t *testing.T) {
 	addr := "2VZu3rZozQ6nN37YSdj3EZJV7wSFVuLSm2X"
 	var coins uint64 = 100
 
-	txn, err := s.CreateTransaction(addr, coins)
+	txn, err := s.CreateTransaction(addr, coins, SendOption{})
 	require.NoError(t, err)
 	require.NotNil(t, txn)
 	require.Len(t, txn.Out, 1)
@@ -24,8 +25,29 @@ func TestDummySender(t *testing.T) {
 	require.Equal(t, addr, txn.Out[0].Address.String())
 	require.Equal(t, coins, txn.Out[0].Coins)
 
+	// A batch txn has an output to each recipient
+	addr2 := "nYTKxHm6SZWAMdDVx6U9BqxKMuCjmSLp93"
+	batchTxn, err := s.CreateBatchTransaction([]Recipient{
+		{Addr: addr, Coins: coins},
+		{Addr: addr2, Coins: 2 * coins},
+	}, SendOption{})
+	require.NoError(t, err)
+	require.Len(t, batchTxn.Out, 2)
+	require.Equal(t, addr2, batchTxn.Out[1].Address.String())
+	require.Equal(t, 2*coins, batchTxn.Out[1].Coins)
+
+	_, err = s.CreateBatchTransaction(nil, SendOption{})
+	require.Equal(t, ErrNoRecipients, err)
+
+	// Memos up to DummyMaxMemoLength are supported
+	_, err = s.CreateTransaction(addr, coins, SendOption{Memo: strings.Repeat("m", DummyMaxMemoLength)})
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(addr, coins, SendOption{Memo: strings.Repeat("m", DummyMaxMemoLength+1)})
+	require.Equal(t, ErrMemoTooLong, err)
+
 	// Another txn with the same dest addr and coins should have a different txid
-	txn2, err := s.CreateTransaction(addr, coins)
+	txn2, err := s.CreateTransaction(addr, coins, SendOption{})
 	require.NoError(t, err)
 	require.NotEqual(t, txn.TxIDHex(), txn2.TxIDHex())
 
@@ -52,3 +74,17 @@ func TestDummySender(t *testing.T) {
 	require.NoError(t, cRsp.Err)
 	require.True(t, cRsp.Confirmed)
 }
+
+func TestValidateMemo(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	dummy := NewDummySender(log)
+
+	require.NoError(t, ValidateMemo(dummy, ""))
+	require.NoError(t, ValidateMemo(dummy, "order-1"))
+	require.Equal(t, ErrMemoTooLong, ValidateMemo(dummy, strings.Repeat("m", DummyMaxMemoLength+1)))
+
+	// A sender that is not a MemoSender supports no memo
+	rpc := &RPC{}
+	require.NoError(t, ValidateMemo(rpc, ""))
+	require.Equal(t, ErrMemoTooLong, ValidateMemo(rpc, "order-1"))
+}
@@ -0,0 +1,211 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/api/cli"
+	"github.com/skycoin/skycoin/src/api/webrpc"
+	"github.com/skycoin/skycoin/src/coin"
+)
+
+// ErrNoBackends is returned by NewFailoverClient if no backends are given
+var ErrNoBackends = errors.New("No skycoin backends configured")
+
+// Backend is a skycoin node used by FailoverClient
+type Backend struct {
+	Addr string
+	SkyClient
+}
+
+// BackendStatus reports the health of a skycoin backend
+type BackendStatus struct {
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+	// Active is true for the backend that requests are sent to first
+	Active        bool      `json:"active"`
+	Requests      uint64    `json:"requests"`
+	Failures      uint64    `json:"failures"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+}
+
+// FailoverClient is a SkyClient that sends requests to the active backend,
+// and fails over to the next backend if the request fails with an RPCError.
+//
+// Transactions are created once and the same signed transaction is broadcast
+// to the next backend on failure, so a send is never duplicated across backends:
+// every backend sees the same txid, spending the same outputs.
+type FailoverClient struct {
+	sync.Mutex
+	log      logrus.FieldLogger
+	backends []Backend
+	status   []BackendStatus
+	active   int
+}
+
+// NewFailoverClient creates a FailoverClient. The first backend is active initially.
+func NewFailoverClient(log logrus.FieldLogger, backends []Backend) (*FailoverClient, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackends
+	}
+
+	status := make([]BackendStatus, len(backends))
+	for i, b := range backends {
+		status[i] = BackendStatus{
+			Addr:    b.Addr,
+			Healthy: true,
+		}
+	}
+
+	return &FailoverClient{
+		log:      log.WithField("prefix", "sender.failover"),
+		backends: backends,
+		status:   status,
+	}, nil
+}
+
+// do calls f with each backend, starting from the active backend, until f does not return an RPCError.
+// The backend that succeeded becomes the active backend.
+// If every backend fails, the last RPCError is returned.
+func (c *FailoverClient) do(name string, f func(b Backend) error) error {
+	c.Lock()
+	start := c.active
+	c.Unlock()
+
+	var err error
+	for n := 0; n < len(c.backends); n++ {
+		i := (start + n) % len(c.backends)
+		b := c.backends[i]
+
+		err = f(b)
+		c.record(i, err)
+
+		if _, ok := err.(RPCError); !ok {
+			return err
+		}
+
+		c.log.WithError(err).WithField("backend", b.Addr).Warningf("%s failed", name)
+	}
+
+	c.log.WithError(err).Errorf("%s failed on all skycoin backends", name)
+	rpcErr, _ := err.(RPCError)
+	return RPCError{
+		error: fmt.Errorf("All skycoin backends failed, last error: %v", err),
+		Kind:  rpcErr.Kind,
+	}
+}
+
+// record updates the health of backend i after a request returned err
+func (c *FailoverClient) record(i int, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	st := &c.status[i]
+	st.Requests++
+
+	if _, ok := err.(RPCError); ok {
+		st.Healthy = false
+		st.Failures++
+		st.LastError = err.Error()
+		st.LastErrorTime = time.Now().UTC()
+		return
+	}
+
+	st.Healthy = true
+	if c.active != i {
+		c.log.WithField("backend", c.backends[i].Addr).Info("Switched active skycoin backend")
+		c.active = i
+	}
+}
+
+// CreateTransaction creates a transaction with the first available backend
+func (c *FailoverClient) CreateTransaction(recvAddr string, amount uint64, opt SendOption) (*coin.Transaction, error) {
+	var txn *coin.Transaction
+	err := c.do("CreateTransaction", func(b Backend) error {
+		var err error
+		txn, err = b.CreateTransaction(recvAddr, amount, opt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return txn, nil
+}
+
+// CreateBatchTransaction creates a transaction to several recipients with the first available backend
+func (c *FailoverClient) CreateBatchTransaction(recipients []Recipient, opt SendOption) (*coin.Transaction, error) {
+	var txn *coin.Transaction
+	err := c.do("CreateBatchTransaction", func(b Backend) error {
+		var err error
+		txn, err = b.CreateBatchTransaction(recipients, opt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return txn, nil
+}
+
+// BroadcastTransaction broadcasts a transaction with the first available backend
+func (c *FailoverClient) BroadcastTransaction(tx *coin.Transaction) (string, error) {
+	var txid string
+	err := c.do("BroadcastTransaction", func(b Backend) error {
+		var err error
+		txid, err = b.BroadcastTransaction(tx)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return txid, nil
+}
+
+// GetTransaction returns a transaction by txid from the first available backend
+func (c *FailoverClient) GetTransaction(txid string) (*webrpc.TxnResult, error) {
+	var txn *webrpc.TxnResult
+	err := c.do("GetTransaction", func(b Backend) error {
+		var err error
+		txn, err = b.GetTransaction(txid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return txn, nil
+}
+
+// Balance returns the wallet balance from the first available backend
+func (c *FailoverClient) Balance() (*cli.Balance, error) {
+	var bal *cli.Balance
+	err := c.do("Balance", func(b Backend) error {
+		var err error
+		bal, err = b.Balance()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bal, nil
+}
+
+// Backends returns the health of each backend
+func (c *FailoverClient) Backends() []BackendStatus {
+	c.Lock()
+	defer c.Unlock()
+
+	status := make([]BackendStatus, len(c.status))
+	copy(status, c.status)
+	status[c.active].Active = true
+
+	return status
+}
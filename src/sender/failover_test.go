@@ -0,0 +1,99 @@
+package sender
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestFailoverClient(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	_, err := NewFailoverClient(log, nil)
+	require.Equal(t, ErrNoBackends, err)
+
+	primary := newDummySkyClient()
+	primary.broadcastTxTxid = "txid"
+	secondary := newDummySkyClient()
+	secondary.broadcastTxTxid = "txid"
+
+	c, err := NewFailoverClient(log, []Backend{
+		{Addr: "primary", SkyClient: primary},
+		{Addr: "secondary", SkyClient: secondary},
+	})
+	require.NoError(t, err)
+
+	pk, _ := cipher.GenerateKeyPair()
+	addr := cipher.AddressFromPubKey(pk).String()
+
+	// The primary backend is used while it is healthy
+	txn, err := c.CreateTransaction(addr, 1e6, SendOption{})
+	require.NoError(t, err)
+
+	txid, err := c.BroadcastTransaction(txn)
+	require.NoError(t, err)
+	require.Equal(t, "txid", txid)
+
+	bs := c.Backends()
+	require.Len(t, bs, 2)
+	require.True(t, bs[0].Active)
+	require.True(t, bs[0].Healthy)
+	require.Equal(t, uint64(2), bs[0].Requests)
+	require.Equal(t, uint64(0), bs[1].Requests)
+
+	// The primary backend fails, the same transaction is broadcast with the secondary backend
+	primary.broadcastTxErr = NewRPCError(errors.New("connection refused"))
+
+	txid, err = c.BroadcastTransaction(txn)
+	require.NoError(t, err)
+	require.Equal(t, "txid", txid)
+
+	bs = c.Backends()
+	require.False(t, bs[0].Healthy)
+	require.False(t, bs[0].Active)
+	require.Equal(t, uint64(1), bs[0].Failures)
+	require.Contains(t, bs[0].LastError, "connection refused")
+	require.True(t, bs[1].Healthy)
+	require.True(t, bs[1].Active)
+
+	// The secondary backend stays active
+	_, err = c.Balance()
+	require.NoError(t, err)
+	bs = c.Backends()
+	require.Equal(t, uint64(3), bs[0].Requests)
+	require.Equal(t, uint64(2), bs[1].Requests)
+
+	// Errors that aren't RPCErrors are returned without failing over
+	errInvalid := errors.New("Invalid address length")
+	secondary.createTxErr = errInvalid
+	_, err = c.CreateTransaction(addr, 1e6, SendOption{})
+	require.Equal(t, errInvalid, err)
+	bs = c.Backends()
+	require.True(t, bs[1].Healthy)
+	require.Equal(t, uint64(3), bs[0].Requests)
+
+	// All backends fail
+	secondary.getTxErr = NewRPCError(errors.New("timeout"))
+	primary.getTxErr = NewRPCError(errors.New("connection refused"))
+	_, err = c.GetTransaction("txid")
+	require.Error(t, err)
+	require.IsType(t, RPCError{}, err)
+
+	bs = c.Backends()
+	require.False(t, bs[0].Healthy)
+	require.False(t, bs[1].Healthy)
+
+	// The primary backend recovers
+	primary.getTxErr = nil
+	_, err = c.GetTransaction("txid")
+	require.NoError(t, err)
+
+	bs = c.Backends()
+	require.True(t, bs[0].Healthy)
+	require.True(t, bs[0].Active)
+}
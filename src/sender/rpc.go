@@ -2,22 +2,66 @@ package sender
 
 import (
 	"errors"
+	"fmt"
+	"net"
 
 	"github.com/skycoin/skycoin/src/api/cli"
 	"github.com/skycoin/skycoin/src/api/webrpc"
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skycoin/src/coin"
+	"github.com/skycoin/skycoin/src/util/fee"
+	"github.com/skycoin/skycoin/src/visor"
 	"github.com/skycoin/skycoin/src/wallet"
 )
 
+// RPCErrorKind classifies the cause of an RPCError
+type RPCErrorKind int
+
+const (
+	// RPCErrorUnknown is an RPCError of no known cause
+	RPCErrorUnknown RPCErrorKind = iota
+	// RPCErrorInsufficientBalance the wallet's balance is too low for the send
+	RPCErrorInsufficientBalance
+	// RPCErrorNodeUnreachable the skycoin node could not be reached
+	RPCErrorNodeUnreachable
+	// RPCErrorTxRejected the skycoin node refused to broadcast the transaction
+	RPCErrorTxRejected
+)
+
 // RPCError wraps errors from the skycoin CLI/RPC library
 type RPCError struct {
 	error
+	Kind RPCErrorKind
 }
 
-// NewRPCError wraps an err with RPCError
+// NewRPCError wraps an err with RPCError, classifying its cause
 func NewRPCError(err error) RPCError {
-	return RPCError{err}
+	kind := RPCErrorUnknown
+
+	switch err.(type) {
+	case net.Error:
+		kind = RPCErrorNodeUnreachable
+	default:
+		switch err {
+		case wallet.ErrInsufficientBalance, cli.ErrTemporaryInsufficientBalance:
+			kind = RPCErrorInsufficientBalance
+		}
+	}
+
+	return RPCError{
+		error: err,
+		Kind:  kind,
+	}
+}
+
+// InvalidAddressErr is returned if the skycoin address of a recipient is invalid
+type InvalidAddressErr struct {
+	error
+}
+
+// NewInvalidAddressErr wraps an err with InvalidAddressErr
+func NewInvalidAddressErr(err error) InvalidAddressErr {
+	return InvalidAddressErr{err}
 }
 
 // RPC provides methods for sending coins
@@ -49,32 +93,186 @@ func NewRPC(wltFile, rpcAddr string) (*RPC, error) {
 	}, nil
 }
 
-// CreateTransaction creates a raw Skycoin transaction offline, that can be broadcast later
-func (c *RPC) CreateTransaction(recvAddr string, amount uint64) (*coin.Transaction, error) {
-	// TODO -- this can support sending to multiple receivers at once,
-	// which would be necessary if the exchange was busy
-	sendAmount := cli.SendAmount{
-		Addr:  recvAddr,
-		Coins: amount,
+// CreateTransaction creates a raw Skycoin transaction offline, that can be broadcast later.
+// The coin hours of the inputs are spent according to opt.CoinHourStrategy
+func (c *RPC) CreateTransaction(recvAddr string, amount uint64, opt SendOption) (*coin.Transaction, error) {
+	return c.CreateBatchTransaction([]Recipient{
+		{
+			Addr:  recvAddr,
+			Coins: amount,
+		},
+	}, opt)
+}
+
+// CreateBatchTransaction creates a raw Skycoin transaction offline with an output to each recipient,
+// that can be broadcast later. The coin hours of the inputs are spent according to opt.CoinHourStrategy
+func (c *RPC) CreateBatchTransaction(recipients []Recipient, opt SendOption) (*coin.Transaction, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	sendAmounts := make([]cli.SendAmount, len(recipients))
+	for i, r := range recipients {
+		sendAmounts[i] = cli.SendAmount{
+			Addr:  r.Addr,
+			Coins: r.Coins,
+		}
+
+		if err := validateSendAmount(sendAmounts[i]); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := validateSendAmount(sendAmount); err != nil {
+	if err := ValidateCoinHourStrategy(opt.CoinHourStrategy); err != nil {
 		return nil, err
 	}
 
-	txn, err := cli.CreateRawTxFromWallet(c.rpcClient, c.walletFile, c.changeAddr, []cli.SendAmount{sendAmount})
+	// Skycoin transactions have no memo field
+	if err := ValidateMemo(c, opt.Memo); err != nil {
+		return nil, err
+	}
+
+	// The skycoin CLI library only supports sharing the hours
+	switch opt.CoinHourStrategy {
+	case "", CoinHourStrategyShare:
+		txn, err := cli.CreateRawTxFromWallet(c.rpcClient, c.walletFile, c.changeAddr, sendAmounts)
+		if err != nil {
+			return nil, NewRPCError(err)
+		}
+
+		return txn, nil
+	default:
+		txn, err := c.createTransaction(sendAmounts, opt.CoinHourStrategy)
+		if err != nil {
+			return nil, NewRPCError(err)
+		}
+
+		return txn, nil
+	}
+}
+
+// createTransaction creates a transaction from the wallet like cli.CreateRawTxFromWallet,
+// distributing the coin hours according to strategy
+func (c *RPC) createTransaction(sendAmounts []cli.SendAmount, strategy CoinHourStrategy) (*coin.Transaction, error) {
+	wlt, err := wallet.Load(c.walletFile)
 	if err != nil {
-		return nil, RPCError{err}
+		return nil, err
 	}
 
-	return txn, nil
+	addrs := wlt.GetAddresses()
+	inAddrs := make([]string, len(addrs))
+	for i, a := range addrs {
+		inAddrs[i] = a.String()
+	}
+
+	unspents, err := c.rpcClient.GetUnspentOutputs(inAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	spendable, err := visor.ReadableOutputsToUxBalances(unspents.Outputs.SpendableOutputs())
+	if err != nil {
+		return nil, err
+	}
+
+	var totalSendCoins uint64
+	for _, a := range sendAmounts {
+		totalSendCoins += a.Coins
+	}
+
+	outs, err := wallet.ChooseSpendsMinimizeUxOuts(spendable, totalSendCoins)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]cipher.SecKey, len(outs))
+	var totalInCoins, totalInHours uint64
+	for i, o := range outs {
+		entry, ok := wlt.GetEntry(o.Address)
+		if !ok {
+			return nil, fmt.Errorf("%s is not in wallet", o.Address.String())
+		}
+		keys[i] = entry.Secret
+
+		totalInCoins += o.Coins
+		totalInHours += o.Hours
+	}
+
+	changeCoins := totalInCoins - totalSendCoins
+	changeHours, recvHours, err := distributeHours(strategy, totalInHours, len(sendAmounts), changeCoins > 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var txOuts []coin.TransactionOutput
+	if changeCoins > 0 {
+		txOuts = append(txOuts, coin.TransactionOutput{
+			Address: cipher.MustDecodeBase58Address(c.changeAddr),
+			Coins:   changeCoins,
+			Hours:   changeHours,
+		})
+	}
+
+	for i, a := range sendAmounts {
+		txOuts = append(txOuts, coin.TransactionOutput{
+			Address: cipher.MustDecodeBase58Address(a.Addr),
+			Coins:   a.Coins,
+			Hours:   recvHours[i],
+		})
+	}
+
+	return cli.NewTransaction(outs, keys, txOuts)
+}
+
+// distributeHours returns the coin hours of the change output and of each of the nRecipients outputs,
+// spending inputHours according to strategy. The fee required by the skycoin network is always burned.
+func distributeHours(strategy CoinHourStrategy, inputHours uint64, nRecipients int, haveChange bool) (uint64, []uint64, error) {
+	if inputHours == 0 {
+		return 0, nil, fee.ErrTxnNoFee
+	}
+
+	var changeHours uint64
+	recvHours := make([]uint64, nRecipients)
+	switch strategy {
+	case "", CoinHourStrategyShare:
+		changeHours, recvHours, _ = wallet.DistributeSpendHours(inputHours, uint64(nRecipients), haveChange)
+	case CoinHourStrategyMinimal:
+		if haveChange {
+			changeHours = inputHours - fee.RequiredFee(inputHours)
+		} else {
+			// Without a change output, the recipients share the hours
+			_, recvHours, _ = wallet.DistributeSpendHours(inputHours, uint64(nRecipients), false)
+		}
+	case CoinHourStrategyBurn:
+	default:
+		return 0, nil, ErrInvalidCoinHourStrategy
+	}
+
+	outHours := changeHours
+	for _, h := range recvHours {
+		outHours += h
+	}
+
+	if err := fee.VerifyTransactionFeeForHours(outHours, inputHours-outHours); err != nil {
+		return 0, nil, err
+	}
+
+	return changeHours, recvHours, nil
 }
 
 // BroadcastTransaction broadcasts a transaction and returns its txid
 func (c *RPC) BroadcastTransaction(tx *coin.Transaction) (string, error) {
 	txid, err := c.rpcClient.InjectTransaction(tx)
 	if err != nil {
-		return "", RPCError{err}
+		// The node answered, but did not accept the transaction
+		if _, ok := err.(webrpc.RPCError); ok {
+			return "", RPCError{
+				error: err,
+				Kind:  RPCErrorTxRejected,
+			}
+		}
+
+		return "", NewRPCError(err)
 	}
 
 	return txid, nil
@@ -84,7 +282,7 @@ func (c *RPC) BroadcastTransaction(tx *coin.Transaction) (string, error) {
 func (c *RPC) GetTransaction(txid string) (*webrpc.TxnResult, error) {
 	txn, err := c.rpcClient.GetTransactionByID(txid)
 	if err != nil {
-		return nil, RPCError{err}
+		return nil, NewRPCError(err)
 	}
 
 	return txn, nil
@@ -94,7 +292,7 @@ func (c *RPC) GetTransaction(txid string) (*webrpc.TxnResult, error) {
 func (c *RPC) Balance() (*cli.Balance, error) {
 	bal, err := cli.CheckWalletBalance(c.rpcClient, c.walletFile)
 	if err != nil {
-		return nil, RPCError{err}
+		return nil, NewRPCError(err)
 	}
 
 	return &bal.Spendable, nil
@@ -103,7 +301,7 @@ func (c *RPC) Balance() (*cli.Balance, error) {
 func validateSendAmount(amt cli.SendAmount) error {
 	// validate the recvAddr
 	if _, err := cipher.DecodeBase58Address(amt.Addr); err != nil {
-		return err
+		return NewInvalidAddressErr(err)
 	}
 
 	if amt.Coins == 0 {
@@ -0,0 +1,183 @@
+package sender
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/api/cli"
+	"github.com/skycoin/skycoin/src/util/fee"
+	"github.com/skycoin/skycoin/src/wallet"
+)
+
+func TestDistributeHours(t *testing.T) {
+	cases := []struct {
+		name        string
+		strategy    CoinHourStrategy
+		inputHours  uint64
+		nRecipients int
+		haveChange  bool
+		changeHours uint64
+		recvHours   []uint64
+		err         error
+	}{
+		{
+			name:        "share",
+			strategy:    CoinHourStrategyShare,
+			inputHours:  101,
+			nRecipients: 1,
+			haveChange:  true,
+			changeHours: 25,
+			recvHours:   []uint64{25},
+		},
+		{
+			name:        "share default",
+			inputHours:  100,
+			nRecipients: 1,
+			haveChange:  true,
+			// 50 hours are burned, the remaining 50 are shared
+			changeHours: 25,
+			recvHours:   []uint64{25},
+		},
+		{
+			name:        "share no change",
+			strategy:    CoinHourStrategyShare,
+			inputHours:  100,
+			nRecipients: 1,
+			recvHours:   []uint64{50},
+		},
+		{
+			name:        "share batch",
+			strategy:    CoinHourStrategyShare,
+			inputHours:  100,
+			nRecipients: 3,
+			haveChange:  true,
+			changeHours: 25,
+			recvHours:   []uint64{9, 8, 8},
+		},
+		{
+			name:        "minimal",
+			strategy:    CoinHourStrategyMinimal,
+			inputHours:  101,
+			nRecipients: 1,
+			haveChange:  true,
+			changeHours: 50,
+			recvHours:   []uint64{0},
+		},
+		{
+			name:        "minimal no change",
+			strategy:    CoinHourStrategyMinimal,
+			inputHours:  100,
+			nRecipients: 1,
+			recvHours:   []uint64{50},
+		},
+		{
+			name:        "minimal batch no change",
+			strategy:    CoinHourStrategyMinimal,
+			inputHours:  100,
+			nRecipients: 2,
+			recvHours:   []uint64{25, 25},
+		},
+		{
+			name:        "burn",
+			strategy:    CoinHourStrategyBurn,
+			inputHours:  100,
+			nRecipients: 2,
+			haveChange:  true,
+			recvHours:   []uint64{0, 0},
+		},
+		{
+			name:        "no input hours",
+			strategy:    CoinHourStrategyMinimal,
+			nRecipients: 1,
+			haveChange:  true,
+			err:         fee.ErrTxnNoFee,
+		},
+		{
+			name:        "invalid strategy",
+			strategy:    "foo",
+			inputHours:  100,
+			nRecipients: 1,
+			err:         ErrInvalidCoinHourStrategy,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			changeHours, recvHours, err := distributeHours(tc.strategy, tc.inputHours, tc.nRecipients, tc.haveChange)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.changeHours, changeHours)
+			require.Equal(t, tc.recvHours, recvHours)
+		})
+	}
+}
+
+func TestRPCCreateBatchTransactionNoRecipients(t *testing.T) {
+	c := &RPC{}
+	_, err := c.CreateBatchTransaction(nil, SendOption{})
+	require.Equal(t, ErrNoRecipients, err)
+}
+
+func TestRPCCreateBatchTransactionInvalidAddress(t *testing.T) {
+	c := &RPC{}
+	_, err := c.CreateBatchTransaction([]Recipient{
+		{
+			Addr:  "invalid address",
+			Coins: 1,
+		},
+	}, SendOption{})
+	require.IsType(t, InvalidAddressErr{}, err)
+}
+
+func TestNewRPCError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		kind RPCErrorKind
+	}{
+		{
+			name: "insufficient balance",
+			err:  wallet.ErrInsufficientBalance,
+			kind: RPCErrorInsufficientBalance,
+		},
+		{
+			name: "temporary insufficient balance",
+			err:  cli.ErrTemporaryInsufficientBalance,
+			kind: RPCErrorInsufficientBalance,
+		},
+		{
+			name: "node unreachable",
+			err: &url.Error{
+				Op:  "Post",
+				URL: "http://127.0.0.1:6430/webrpc",
+				Err: &net.OpError{
+					Op:  "dial",
+					Net: "tcp",
+					Err: errors.New("connection refused"),
+				},
+			},
+			kind: RPCErrorNodeUnreachable,
+		},
+		{
+			name: "unknown",
+			err:  errors.New("unknown"),
+			kind: RPCErrorUnknown,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewRPCError(tc.err)
+			require.Equal(t, tc.kind, err.Kind)
+			require.Equal(t, tc.err.Error(), err.Error())
+		})
+	}
+}
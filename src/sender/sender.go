@@ -12,11 +12,78 @@ var (
 	ErrSendBufferFull = errors.New("Send service's request queue is full")
 	// ErrClosed the sender has closed
 	ErrClosed = errors.New("Send service closed")
+	// ErrInvalidCoinHourStrategy is returned for an unknown CoinHourStrategy
+	ErrInvalidCoinHourStrategy = errors.New("Invalid coin hour strategy")
+	// ErrNoRecipients is returned by CreateBatchTransaction if no recipients are given
+	ErrNoRecipients = errors.New("No recipients")
+	// ErrMemoTooLong is returned by ValidateMemo for a memo longer than the sender supports
+	ErrMemoTooLong = errors.New("Memo is longer than the sender supports")
 )
 
+// CoinHourStrategy selects how the coin hours of a transaction's inputs are spent.
+// Whatever the strategy, at least the fee required by the skycoin network is burned.
+type CoinHourStrategy string
+
+const (
+	// CoinHourStrategyShare splits the hours left after the fee between the recipient and the change output. This is the default
+	CoinHourStrategyShare CoinHourStrategy = "share"
+	// CoinHourStrategyMinimal gives the recipient no hours, keeping the hours left after the fee in the change output.
+	// If there is no change output, the recipient gets them
+	CoinHourStrategyMinimal CoinHourStrategy = "minimal"
+	// CoinHourStrategyBurn burns all of the input hours
+	CoinHourStrategyBurn CoinHourStrategy = "burn"
+)
+
+// ValidateCoinHourStrategy returns ErrInvalidCoinHourStrategy if s is not a known strategy.
+// An empty strategy is valid, it is CoinHourStrategyShare
+func ValidateCoinHourStrategy(s CoinHourStrategy) error {
+	switch s {
+	case "", CoinHourStrategyShare, CoinHourStrategyMinimal, CoinHourStrategyBurn:
+		return nil
+	default:
+		return ErrInvalidCoinHourStrategy
+	}
+}
+
+// SendOption configures how a transaction is created
+type SendOption struct {
+	// CoinHourStrategy defaults to CoinHourStrategyShare if empty
+	CoinHourStrategy CoinHourStrategy
+	// Memo tags the transaction with a note, e.g. an order ID, if the sender supports memos. See ValidateMemo
+	Memo string
+}
+
+// MemoSender is a Sender that can tag transactions with SendOption.Memo
+type MemoSender interface {
+	// MaxMemoLength is the maximum length of a memo, in bytes
+	MaxMemoLength() int
+}
+
+// ValidateMemo returns ErrMemoTooLong if memo is longer than s supports.
+// A sender that is not a MemoSender supports no memo. An empty memo is always valid
+func ValidateMemo(s interface{}, memo string) error {
+	if memo == "" {
+		return nil
+	}
+
+	ms, ok := s.(MemoSender)
+	if !ok || len(memo) > ms.MaxMemoLength() {
+		return ErrMemoTooLong
+	}
+
+	return nil
+}
+
+// Recipient is an output of a transaction created by CreateBatchTransaction
+type Recipient struct {
+	Addr  string
+	Coins uint64
+}
+
 // Sender provids apis for sending skycoin
 type Sender interface {
-	CreateTransaction(string, uint64) (*coin.Transaction, error)
+	CreateTransaction(string, uint64, SendOption) (*coin.Transaction, error)
+	CreateBatchTransaction([]Recipient, SendOption) (*coin.Transaction, error)
 	BroadcastTransaction(*coin.Transaction) *BroadcastTxResponse
 	IsTxConfirmed(string) *ConfirmResponse
 	Balance() (*cli.Balance, error)
@@ -36,8 +103,13 @@ func NewRetrySender(s *SendService) *RetrySender {
 }
 
 // CreateTransaction creates a transaction offline
-func (s *RetrySender) CreateTransaction(recvAddr string, coins uint64) (*coin.Transaction, error) {
-	return s.s.SkyClient.CreateTransaction(recvAddr, coins)
+func (s *RetrySender) CreateTransaction(recvAddr string, coins uint64, opt SendOption) (*coin.Transaction, error) {
+	return s.s.SkyClient.CreateTransaction(recvAddr, coins, opt)
+}
+
+// CreateBatchTransaction creates a transaction offline with an output to each recipient
+func (s *RetrySender) CreateBatchTransaction(recipients []Recipient, opt SendOption) (*coin.Transaction, error) {
+	return s.s.SkyClient.CreateBatchTransaction(recipients, opt)
 }
 
 // BroadcastTransaction sends a transaction in a goroutine
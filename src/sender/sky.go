@@ -74,7 +74,8 @@ type SendService struct {
 
 // SkyClient defines a Skycoin RPC client interface for sending and confirming
 type SkyClient interface {
-	CreateTransaction(string, uint64) (*coin.Transaction, error)
+	CreateTransaction(string, uint64, SendOption) (*coin.Transaction, error)
+	CreateBatchTransaction([]Recipient, SendOption) (*coin.Transaction, error)
 	BroadcastTransaction(*coin.Transaction) (string, error)
 	GetTransaction(string) (*webrpc.TxnResult, error)
 	Balance() (*cli.Balance, error)
@@ -235,6 +236,12 @@ func (s *SendService) BroadcastTxRetry(req BroadcastTxRequest) (*BroadcastTxResp
 	for {
 		txid, err := s.SkyClient.BroadcastTransaction(req.Tx)
 		if err != nil {
+			// Broadcasting a rejected transaction again would be rejected again
+			if rpcErr, ok := err.(RPCError); ok && rpcErr.Kind == RPCErrorTxRejected {
+				log.WithError(err).Error("SkyClient.BroadcastTransaction rejected the transaction")
+				return nil, err
+			}
+
 			log.WithError(err).Error("SkyClient.BroadcastTransaction failed, trying again...")
 
 			select {
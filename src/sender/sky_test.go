@@ -34,7 +34,7 @@ func (ds *dummySkyClient) BroadcastTransaction(tx *coin.Transaction) (string, er
 	return ds.broadcastTxTxid, ds.broadcastTxErr
 }
 
-func (ds *dummySkyClient) CreateTransaction(destAddr string, coins uint64) (*coin.Transaction, error) {
+func (ds *dummySkyClient) CreateTransaction(destAddr string, coins uint64, opt SendOption) (*coin.Transaction, error) {
 	if ds.createTxErr != nil {
 		return nil, ds.createTxErr
 	}
@@ -42,6 +42,23 @@ func (ds *dummySkyClient) CreateTransaction(destAddr string, coins uint64) (*coi
 	return ds.createTransaction(destAddr, coins)
 }
 
+func (ds *dummySkyClient) CreateBatchTransaction(recipients []Recipient, opt SendOption) (*coin.Transaction, error) {
+	if ds.createTxErr != nil {
+		return nil, ds.createTxErr
+	}
+
+	tx := &coin.Transaction{}
+	for _, r := range recipients {
+		rtx, err := ds.createTransaction(r.Addr, r.Coins)
+		if err != nil {
+			return nil, err
+		}
+		tx.Out = append(tx.Out, rtx.Out...)
+	}
+
+	return tx, nil
+}
+
 func (ds *dummySkyClient) createTransaction(destAddr string, coins uint64) (*coin.Transaction, error) {
 	addr, err := cipher.DecodeBase58Address(destAddr)
 	if err != nil {
@@ -114,7 +131,7 @@ func TestSenderBroadcastTransaction(t *testing.T) {
 	sdr := NewRetrySender(s)
 
 	broadcastTx := func(sender Sender, addr string, amt uint64) (string, error) {
-		tx, err := sdr.CreateTransaction(addr, amt)
+		tx, err := sdr.CreateTransaction(addr, amt, SendOption{})
 		if err != nil {
 			return "", err
 		}
@@ -221,3 +238,37 @@ func TestCreateTransactionVerify(t *testing.T) {
 		})
 	}
 }
+
+func TestSenderBroadcastTransactionRejected(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	dsc := newDummySkyClient()
+
+	s := NewService(log, dsc)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run()
+	}()
+	defer func() {
+		s.Shutdown()
+		require.NoError(t, <-done)
+	}()
+
+	sdr := NewRetrySender(s)
+
+	tx, err := sdr.CreateTransaction("KNtZkX2mw1UFuemv6FmEQxxhWCTWTm2Thk", 10, SendOption{})
+	require.NoError(t, err)
+
+	// A rejected transaction is not broadcast again
+	rejectErr := RPCError{
+		error: webrpc.RPCError{
+			Code:    -32603,
+			Message: "inject transaction failed:Transaction violates hard constraint",
+		},
+		Kind: RPCErrorTxRejected,
+	}
+	dsc.changeBroadcastTxErr(rejectErr)
+
+	rsp := sdr.BroadcastTransaction(tx)
+	require.NotNil(t, rsp)
+	require.Equal(t, rejectErr, rsp.Err)
+}
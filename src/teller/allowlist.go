@@ -0,0 +1,125 @@
+package teller
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrAddressNotAllowed is returned if bind is called for a skycoin address that is not on the allowlist
+	ErrAddressNotAllowed = errors.New("Skycoin address is not allowed")
+	// ErrAllowlistNoFile is returned when reloading an allowlist that was not loaded from a file
+	ErrAllowlistNoFile = errors.New("Allowlist was not loaded from a file")
+)
+
+// Allowlist is a set of skycoin addresses that are allowed to bind.
+// An empty allowlist allows all addresses.
+// It can be replaced while in use, with Set or Reload.
+type Allowlist struct {
+	sync.RWMutex
+	filename string
+	addrs    map[string]struct{}
+}
+
+// NewAllowlist creates an Allowlist of skycoin addresses
+func NewAllowlist(addrs []string) (*Allowlist, error) {
+	a := &Allowlist{}
+	if err := a.Set(addrs); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// LoadAllowlist creates an Allowlist from a file of skycoin addresses, one per line.
+// Blank lines and lines starting with # are ignored.
+func LoadAllowlist(filename string) (*Allowlist, error) {
+	a := &Allowlist{
+		filename: filename,
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Set replaces the addresses of the allowlist
+func (a *Allowlist) Set(addrs []string) error {
+	m := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		canonical, err := canonicalSkyAddress(addr)
+		if err != nil {
+			return fmt.Errorf("Invalid skycoin address %q: %v", addr, err.(InvalidSkyAddressError).err)
+		}
+		m[canonical] = struct{}{}
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	a.addrs = m
+
+	return nil
+}
+
+// Reload rereads the addresses from the allowlist's file.
+// If the file can't be read or is invalid, the current addresses are kept.
+func (a *Allowlist) Reload() error {
+	if a.filename == "" {
+		return ErrAllowlistNoFile
+	}
+
+	f, err := os.Open(a.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	addrs, err := readAllowlist(f)
+	if err != nil {
+		return err
+	}
+
+	return a.Set(addrs)
+}
+
+// Allowed returns true if the skycoin address is on the allowlist, or the allowlist is empty
+func (a *Allowlist) Allowed(skyAddr string) bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	if len(a.addrs) == 0 {
+		return true
+	}
+
+	_, ok := a.addrs[skyAddr]
+	return ok
+}
+
+// Len returns the number of addresses on the allowlist
+func (a *Allowlist) Len() int {
+	a.RLock()
+	defer a.RUnlock()
+	return len(a.addrs)
+}
+
+func readAllowlist(r io.Reader) ([]string, error) {
+	var addrs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return addrs, nil
+}
@@ -0,0 +1,110 @@
+package teller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func testSkyAddr(seed string) string {
+	_, secKey := cipher.GenerateDeterministicKeyPair([]byte(seed))
+	return cipher.AddressFromSecKey(secKey).String()
+}
+
+func TestAllowlist(t *testing.T) {
+	allowed := testSkyAddr("allowed")
+	denied := testSkyAddr("denied")
+
+	// An empty allowlist allows all addresses
+	a, err := NewAllowlist(nil)
+	require.NoError(t, err)
+	require.True(t, a.Allowed(allowed))
+	require.True(t, a.Allowed(denied))
+
+	err = a.Set([]string{allowed})
+	require.NoError(t, err)
+	require.True(t, a.Allowed(allowed))
+	require.False(t, a.Allowed(denied))
+
+	// Invalid addresses are rejected and the current addresses are kept
+	err = a.Set([]string{denied, "foo"})
+	require.Error(t, err)
+	require.True(t, a.Allowed(allowed))
+	require.False(t, a.Allowed(denied))
+
+	// Not loaded from a file
+	require.Equal(t, ErrAllowlistNoFile, a.Reload())
+}
+
+func TestLoadAllowlistReload(t *testing.T) {
+	allowed := testSkyAddr("allowed")
+	denied := testSkyAddr("denied")
+
+	dir, err := ioutil.TempDir("", "allowlist")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "allowlist.txt")
+	err = ioutil.WriteFile(filename, []byte("# private sale\n\n"+allowed+"\n"), 0600)
+	require.NoError(t, err)
+
+	a, err := LoadAllowlist(filename)
+	require.NoError(t, err)
+	require.Equal(t, 1, a.Len())
+	require.True(t, a.Allowed(allowed))
+	require.False(t, a.Allowed(denied))
+
+	// Reload picks up changes to the file
+	err = ioutil.WriteFile(filename, []byte(allowed+"\n  "+denied+"  \n"), 0600)
+	require.NoError(t, err)
+	require.NoError(t, a.Reload())
+	require.Equal(t, 2, a.Len())
+	require.True(t, a.Allowed(denied))
+
+	// An invalid file keeps the current addresses
+	err = ioutil.WriteFile(filename, []byte("foo\n"), 0600)
+	require.NoError(t, err)
+	require.Error(t, a.Reload())
+	require.Equal(t, 2, a.Len())
+
+	// A missing file fails to load
+	_, err = LoadAllowlist(filepath.Join(dir, "missing.txt"))
+	require.Error(t, err)
+}
+
+func TestServiceBindAddressAllowlist(t *testing.T) {
+	allowed := testSkyAddr("allowed")
+	denied := testSkyAddr("denied")
+
+	allowlist, err := NewAllowlist([]string{allowed})
+	require.NoError(t, err)
+
+	// Require address proofs, so that allowed binds stop before allocating a deposit address
+	s := &Service{
+		allowlist:       allowlist,
+		challengeIssuer: NewChallengeIssuer(time.Minute),
+	}
+	s.cfg.BindEnabled = true
+
+	_, err = s.BindAddress(denied, "BTC", nil)
+	require.Equal(t, ErrAddressNotAllowed, err)
+
+	_, err = s.BindAddress(allowed, "BTC", nil)
+	require.Equal(t, ErrAddressProofRequired, err)
+
+	// Allowlist changes apply to the running service
+	err = allowlist.Set([]string{denied})
+	require.NoError(t, err)
+
+	_, err = s.BindAddress(denied, "BTC", nil)
+	require.Equal(t, ErrAddressProofRequired, err)
+
+	_, err = s.BindAddress(allowed, "BTC", nil)
+	require.Equal(t, ErrAddressNotAllowed, err)
+}
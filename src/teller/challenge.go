@@ -0,0 +1,136 @@
+package teller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+const (
+	// challengeNonceSize is the number of random bytes in a challenge
+	challengeNonceSize = 32
+)
+
+var (
+	// ErrAddressProofDisabled is returned if a challenge is requested but address proofs are not required
+	ErrAddressProofDisabled = errors.New("Address proof is disabled")
+	// ErrAddressProofRequired is returned if bind is called without an address proof when one is required
+	ErrAddressProofRequired = errors.New("Address proof is required")
+	// ErrChallengeNotFound is returned if an address proof's challenge was not issued, or was already used
+	ErrChallengeNotFound = errors.New("Challenge not found")
+	// ErrChallengeExpired is returned if an address proof's challenge has expired
+	ErrChallengeExpired = errors.New("Challenge expired")
+	// ErrInvalidAddressProof is returned if an address proof's signature does not verify
+	ErrInvalidAddressProof = errors.New("Invalid address proof")
+)
+
+// Challenge is issued to a caller that must prove it owns a skycoin address.
+// The caller signs the SHA256 hash of Challenge with the address's secret key.
+type Challenge struct {
+	SkyAddress string `json:"skyaddr"`
+	Challenge  string `json:"challenge"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+// AddressProof is a signature of a Challenge by the skycoin address it was issued for
+type AddressProof struct {
+	Challenge string `json:"challenge"`
+	Signature string `json:"signature"` // Hex encoded skycoin signature of SHA256(Challenge)
+}
+
+// SignChallenge signs a challenge with the secret key of a skycoin address
+func SignChallenge(challenge string, secKey cipher.SecKey) AddressProof {
+	return AddressProof{
+		Challenge: challenge,
+		Signature: cipher.SignHash(cipher.SumSHA256([]byte(challenge)), secKey).Hex(),
+	}
+}
+
+// ChallengeIssuer issues single use challenges and verifies AddressProofs of them
+type ChallengeIssuer struct {
+	sync.Mutex
+	ttl        time.Duration
+	challenges map[string]Challenge
+	now        func() time.Time
+}
+
+// NewChallengeIssuer creates a ChallengeIssuer whose challenges expire after ttl
+func NewChallengeIssuer(ttl time.Duration) *ChallengeIssuer {
+	return &ChallengeIssuer{
+		ttl:        ttl,
+		challenges: make(map[string]Challenge),
+		now:        time.Now,
+	}
+}
+
+// Issue creates a challenge for a skycoin address
+func (c *ChallengeIssuer) Issue(skyAddr string) (Challenge, error) {
+	nonce := make([]byte, challengeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return Challenge{}, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	c.pruneExpired(now)
+
+	ch := Challenge{
+		SkyAddress: skyAddr,
+		Challenge:  fmt.Sprintf("teller bind %s %s", skyAddr, hex.EncodeToString(nonce)),
+		ExpiresAt:  now.Add(c.ttl).Unix(),
+	}
+
+	c.challenges[ch.Challenge] = ch
+
+	return ch, nil
+}
+
+// Verify checks that proof is a signature by skyAddr of a challenge issued for it.
+// A challenge can only be used once, it is consumed even if verification fails.
+func (c *ChallengeIssuer) Verify(skyAddr string, proof AddressProof) error {
+	c.Lock()
+	ch, ok := c.challenges[proof.Challenge]
+	delete(c.challenges, proof.Challenge)
+	now := c.now()
+	c.Unlock()
+
+	if !ok || ch.SkyAddress != skyAddr {
+		return ErrChallengeNotFound
+	}
+
+	if now.Unix() >= ch.ExpiresAt {
+		return ErrChallengeExpired
+	}
+
+	addr, err := cipher.DecodeBase58Address(skyAddr)
+	if err != nil {
+		return ErrInvalidAddressProof
+	}
+
+	sig, err := cipher.SigFromHex(proof.Signature)
+	if err != nil {
+		return ErrInvalidAddressProof
+	}
+
+	if err := cipher.ChkSig(addr, cipher.SumSHA256([]byte(proof.Challenge)), sig); err != nil {
+		return ErrInvalidAddressProof
+	}
+
+	return nil
+}
+
+// pruneExpired removes expired challenges. It must be called with the lock held.
+func (c *ChallengeIssuer) pruneExpired(now time.Time) {
+	for k, ch := range c.challenges {
+		if now.Unix() >= ch.ExpiresAt {
+			delete(c.challenges, k)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package teller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+func TestChallengeIssuer(t *testing.T) {
+	_, secKey := cipher.GenerateDeterministicKeyPair([]byte("seed"))
+	skyAddr := cipher.AddressFromSecKey(secKey).String()
+
+	_, otherSecKey := cipher.GenerateDeterministicKeyPair([]byte("other seed"))
+	otherSkyAddr := cipher.AddressFromSecKey(otherSecKey).String()
+
+	now := time.Unix(1520000000, 0)
+	c := NewChallengeIssuer(time.Minute)
+	c.now = func() time.Time { return now }
+
+	issue := func(skyAddr string) Challenge {
+		ch, err := c.Issue(skyAddr)
+		require.NoError(t, err)
+		require.Equal(t, skyAddr, ch.SkyAddress)
+		require.Equal(t, now.Add(time.Minute).Unix(), ch.ExpiresAt)
+		return ch
+	}
+
+	// Valid proof
+	ch := issue(skyAddr)
+	require.NoError(t, c.Verify(skyAddr, SignChallenge(ch.Challenge, secKey)))
+
+	// A challenge can only be used once
+	require.Equal(t, ErrChallengeNotFound, c.Verify(skyAddr, SignChallenge(ch.Challenge, secKey)))
+
+	// Unknown challenge
+	require.Equal(t, ErrChallengeNotFound, c.Verify(skyAddr, SignChallenge("teller bind foo", secKey)))
+
+	// Challenge issued for a different address
+	ch = issue(otherSkyAddr)
+	require.Equal(t, ErrChallengeNotFound, c.Verify(skyAddr, SignChallenge(ch.Challenge, secKey)))
+
+	// Signed by a different key
+	ch = issue(skyAddr)
+	require.Equal(t, ErrInvalidAddressProof, c.Verify(skyAddr, SignChallenge(ch.Challenge, otherSecKey)))
+
+	// Malformed signature
+	ch = issue(skyAddr)
+	require.Equal(t, ErrInvalidAddressProof, c.Verify(skyAddr, AddressProof{
+		Challenge: ch.Challenge,
+		Signature: "not hex",
+	}))
+
+	// Expired challenge
+	ch = issue(skyAddr)
+	now = now.Add(time.Minute)
+	require.Equal(t, ErrChallengeExpired, c.Verify(skyAddr, SignChallenge(ch.Challenge, secKey)))
+
+	// Expired challenges are pruned when issuing
+	issue(skyAddr)
+	now = now.Add(time.Minute)
+	issue(skyAddr)
+	require.Len(t, c.challenges, 1)
+}
+
+func TestServiceBindAddressRequiresProof(t *testing.T) {
+	_, secKey := cipher.GenerateDeterministicKeyPair([]byte("seed"))
+	skyAddr := cipher.AddressFromSecKey(secKey).String()
+
+	s := &Service{}
+	s.cfg.BindEnabled = true
+
+	_, err := s.IssueChallenge(skyAddr)
+	require.Equal(t, ErrAddressProofDisabled, err)
+
+	s.challengeIssuer = NewChallengeIssuer(time.Minute)
+
+	_, err = s.BindAddress(skyAddr, "BTC", nil)
+	require.Equal(t, ErrAddressProofRequired, err)
+
+	_, err = s.BindAddress(skyAddr, "BTC", &AddressProof{
+		Challenge: "teller bind foo",
+	})
+	require.Equal(t, ErrChallengeNotFound, err)
+
+	ch, err := s.IssueChallenge(skyAddr)
+	require.NoError(t, err)
+
+	proof := SignChallenge(ch.Challenge, secKey)
+	proof.Signature = SignChallenge("something else", secKey).Signature
+	_, err = s.BindAddress(skyAddr, "BTC", &proof)
+	require.Equal(t, ErrInvalidAddressProof, err)
+}
@@ -3,11 +3,14 @@ package teller
 import (
 	"context"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -20,36 +23,61 @@ import (
 	"github.com/unrolled/secure"
 	"golang.org/x/crypto/acme/autocert"
 
-	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skycoin/src/util/droplet"
 
 	"github.com/skycoin/teller/src/addrs"
 	"github.com/skycoin/teller/src/config"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/metrics"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
 	"github.com/skycoin/teller/src/util/httputil"
 	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/version"
 )
 
 const (
 	shutdownTimeout = time.Second * 5
 
-	// https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
-	// The timeout configuration is necessary for public servers, or else
-	// connections will be used up
-	serverReadTimeout  = time.Second * 10
-	serverWriteTimeout = time.Second * 60
-	serverIdleTimeout  = time.Second * 120
+	// apiVersion is the version of the API response format, sent in the
+	// X-Teller-API-Version header and the api_version field of the response envelope
+	apiVersion = 1
+	// apiVersionHeader is the response header containing apiVersion
+	apiVersionHeader = "X-Teller-API-Version"
 
-	// Directory where cached SSL certs from Let's Encrypt are stored
-	tlsAutoCertCache = "cert-cache"
+	// saleStatusCacheTTL is how long a /api/sale/status response is reused,
+	// so that polling clients don't scan the deposit database on every request
+	saleStatusCacheTTL = time.Second * 10
 )
 
 var (
 	errInternalServerError = errors.New("Internal Server Error")
+
+	// addressQueryParams are the query params of the API that contain addresses,
+	// which are redacted from the access log if web.access_log_redact_addresses is set
+	addressQueryParams = []string{"skyaddr", "address"}
 )
 
+// envelopeCtxKey is the request context key marking that responses are wrapped in an APIResponse
+type envelopeCtxKey struct{}
+
+// APIResponse is the envelope that API responses are wrapped in, when web.api_envelope is enabled.
+// Exactly one of Data and Error is set.
+type APIResponse struct {
+	APIVersion int         `json:"api_version"`
+	Data       interface{} `json:"data"`
+	Error      *APIError   `json:"error"`
+}
+
+// APIError is the error of an APIResponse
+type APIError struct {
+	// Code is a stable, machine-readable error code
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields lists every invalid field of a request rejected with a ValidationError
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
 // HTTPServer exposes the API endpoints and static website
 type HTTPServer struct {
 	cfg           config.Config
@@ -60,6 +88,8 @@ type HTTPServer struct {
 	httpsListener *http.Server
 	quit          chan struct{}
 	done          chan struct{}
+	saleStatus    saleStatusCache
+	metrics       metrics.Metrics
 }
 
 // NewHTTPServer creates an HTTPServer
@@ -71,6 +101,7 @@ func NewHTTPServer(log logrus.FieldLogger, cfg config.Config, service *Service,
 		}),
 		service:   service,
 		exchanger: exchanger,
+		metrics:   metrics.Nop{},
 		quit:      make(chan struct{}),
 		done:      make(chan struct{}),
 	}
@@ -115,7 +146,7 @@ func (s *HTTPServer) Run() error {
 	mux = secureMiddleware.Handler(mux)
 
 	if s.cfg.Web.HTTPAddr != "" {
-		s.httpListener = setupHTTPListener(s.cfg.Web.HTTPAddr, mux)
+		s.httpListener = setupHTTPListener(s.cfg.Web.HTTPAddr, mux, s.cfg.Web)
 	}
 
 	handleListenErr := func(f func() error) error {
@@ -142,7 +173,7 @@ func (s *HTTPServer) Run() error {
 	if s.cfg.Web.HTTPSAddr != "" {
 		log.Info("Using TLS")
 
-		s.httpsListener = setupHTTPListener(s.cfg.Web.HTTPSAddr, mux)
+		s.httpsListener = setupHTTPListener(s.cfg.Web.HTTPSAddr, mux, s.cfg.Web)
 
 		tlsCert = s.cfg.Web.TLSCert
 		tlsKey = s.cfg.Web.TLSKey
@@ -154,11 +185,18 @@ func (s *HTTPServer) Run() error {
 			certManager := autocert.Manager{
 				Prompt:     autocert.AcceptTOS,
 				HostPolicy: autocert.HostWhitelist(s.cfg.Web.AutoTLSHost),
-				Cache:      autocert.DirCache(tlsAutoCertCache),
+				Cache:      autocert.DirCache(s.cfg.Web.AutoTLSCacheDir),
+			}
+
+			observer := &autoCertObserver{
+				host:           s.cfg.Web.AutoTLSHost,
+				getCertificate: certManager.GetCertificate,
+				log:            log,
+				metrics:        s.metrics,
 			}
 
 			s.httpsListener.TLSConfig = &tls.Config{
-				GetCertificate: certManager.GetCertificate,
+				GetCertificate: observer.GetCertificate,
 			}
 
 			// These will be autogenerated by the autocert middleware
@@ -166,6 +204,23 @@ func (s *HTTPServer) Run() error {
 			tlsKey = ""
 		}
 
+		if len(s.cfg.Web.TLSHosts) != 0 {
+			log.WithField("tlsHosts", len(s.cfg.Web.TLSHosts)).Info("Selecting TLS certificates by SNI")
+
+			certs, err := loadSNICertificates(s.cfg.Web.TLSCert, s.cfg.Web.TLSKey, s.cfg.Web.TLSHosts)
+			if err != nil {
+				log.WithError(err).Error("loadSNICertificates failed")
+				return err
+			}
+
+			s.httpsListener.TLSConfig = &tls.Config{
+				GetCertificate: certs.GetCertificate,
+			}
+
+			// The certificates are selected by GetCertificate
+			tlsCert = ""
+			tlsKey = ""
+		}
 	}
 
 	return handleListenErr(func() error {
@@ -250,17 +305,22 @@ func configureSecureMiddleware(sslHost string, allowedHosts []string) *secure.Se
 	})
 }
 
-func setupHTTPListener(addr string, handler http.Handler) *http.Server {
+// setupHTTPListener creates a server with the timeouts of the web config.
+// https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
+// The timeout configuration is necessary for public servers, or else
+// connections will be used up
+func setupHTTPListener(addr string, handler http.Handler, cfg config.Web) *http.Server {
 	return &http.Server{
-		Addr:         addr,
-		Handler:      handler,
-		ReadTimeout:  serverReadTimeout,
-		WriteTimeout: serverWriteTimeout,
-		IdleTimeout:  serverIdleTimeout,
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
 }
 
-func (s *HTTPServer) setupMux() *http.ServeMux {
+func (s *HTTPServer) setupMux() http.Handler {
 	mux := http.NewServeMux()
 
 	ratelimit := func(h http.Handler) http.Handler {
@@ -271,27 +331,79 @@ func (s *HTTPServer) setupMux() *http.ServeMux {
 		return tollbooth.LimitHandler(limiter, h)
 	}
 
-	handleAPI := func(path string, h http.Handler) {
-		// Allow requests from a local skycoin wallet
-		h = cors.New(cors.Options{
-			AllowedOrigins: []string{"http://127.0.0.1:6420"},
-		}).Handler(h)
+	accessLogCfg := httputil.AccessLogConfig{
+		Enabled:     s.cfg.Web.AccessLog,
+		BehindProxy: s.cfg.Web.BehindProxy,
+	}
+	if s.cfg.Web.AccessLogRedactAddresses {
+		accessLogCfg.RedactQueryParams = addressQueryParams
+	}
+
+	// The access log wraps the other middleware, so that throttled requests are logged
+	accessLog := func(h http.Handler) http.Handler {
+		return httputil.AccessLogHandler(s.log, accessLogCfg, h)
+	}
+
+	// CORS wraps the access log and throttle, so that preflight requests are answered without being throttled
+	var corsHandler *cors.Cors
+	if len(s.cfg.Web.CORSAllowedOrigins) != 0 {
+		corsHandler = cors.New(cors.Options{
+			AllowedOrigins:   s.cfg.Web.CORSAllowedOrigins,
+			AllowedMethods:   s.cfg.Web.CORSAllowedMethods,
+			AllowCredentials: s.cfg.Web.CORSAllowCredentials,
+		})
+	}
+
+	apiHandler := func(h http.Handler) http.Handler {
+		h = apiVersionHandler(s.cfg.Web.APIEnvelope, h)
 
-		h = gziphandler.GzipHandler(h)
+		if corsHandler != nil {
+			h = corsHandler.Handler(h)
+		}
+
+		return gziphandler.GzipHandler(h)
+	}
 
-		mux.Handle(path, h)
+	handleAPI := func(path string, h http.Handler) {
+		mux.Handle(path, apiHandler(h))
+	}
+
+	// Long-poll requests wait longer than web.write_timeout allows, so they have their own write deadline.
+	// It is set on the connection's own ResponseWriter, before any middleware wraps it
+	handleLongPollAPI := func(path string, h http.Handler) {
+		mux.Handle(path, httputil.WriteTimeoutHandler(apiHandler(h), s.cfg.Web.LongPollTimeout+s.cfg.Web.WriteTimeout))
 	}
 
 	// API Methods
-	handleAPI("/api/bind", ratelimit(httputil.LogHandler(s.log, BindHandler(s))))
-	handleAPI("/api/status", ratelimit(httputil.LogHandler(s.log, StatusHandler(s))))
-	handleAPI("/api/config", httputil.LogHandler(s.log, ConfigHandler(s)))
-	handleAPI("/api/exchange-status", httputil.LogHandler(s.log, ExchangeStatusHandler(s)))
+	handleAPI("/api/bind", accessLog(ratelimit(BindHandler(s))))
+	handleAPI("/api/bind-challenge", accessLog(ratelimit(BindChallengeHandler(s))))
+	handleAPI("/api/status", accessLog(ratelimit(StatusHandler(s))))
+	handleLongPollAPI("/api/status/longpoll", accessLog(ratelimit(StatusLongPollHandler(s))))
+	handleAPI("/api/status/bulk", accessLog(ratelimit(BulkStatusHandler(s))))
+	handleAPI("/api/history", accessLog(ratelimit(HistoryHandler(s))))
+	handleAPI("/api/config", accessLog(ConfigHandler(s)))
+	handleAPI("/api/exchange-status", accessLog(ExchangeStatusHandler(s)))
+	handleAPI("/api/sale/status", accessLog(ratelimit(SaleStatusHandler(s))))
+	handleAPI("/api/receipt", accessLog(ratelimit(ReceiptHandler(s))))
+	handleAPI("/api/receipt-key", accessLog(ReceiptKeyHandler(s)))
+	handleAPI("/api/version", accessLog(VersionHandler(s)))
 
 	// Static files
 	mux.Handle("/", gziphandler.GzipHandler(http.FileServer(http.Dir(s.cfg.Web.StaticDir))))
 
-	return mux
+	return httputil.MaxBytesHandler(mux, s.cfg.Web.MaxRequestBodyBytes)
+}
+
+// apiVersionHandler sets the API version header and marks the request context
+// if responses should be wrapped in an APIResponse
+func apiVersionHandler(envelope bool, hd http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, strconv.Itoa(apiVersion))
+		if envelope {
+			r = r.WithContext(context.WithValue(r.Context(), envelopeCtxKey{}, true))
+		}
+		hd.ServeHTTP(w, r)
+	})
 }
 
 // Shutdown stops the HTTPServer
@@ -336,11 +448,45 @@ type BindResponse struct {
 	DepositAddress string `json:"deposit_address,omitempty"`
 	CoinType       string `json:"coin_type,omitempty"`
 	BuyMethod      string `json:"buy_method"`
+	// Derivation is how the deposit address was derived from an HD wallet, if known
+	Derivation *addrs.Derivation `json:"derivation,omitempty"`
+	// StatusURL and EventsURL poll the statuses of the skycoin address's deposits with /api/status
+	// and /api/status/longpoll. They are only set if web.base_url is configured
+	StatusURL string `json:"status_url,omitempty"`
+	EventsURL string `json:"events_url,omitempty"`
+}
+
+// statusURLs returns the /api/status and /api/status/longpoll URLs of skyAddr under baseURL,
+// keeping the path prefix of baseURL. It returns empty URLs if baseURL is empty
+func statusURLs(baseURL, skyAddr string) (string, string, error) {
+	if baseURL == "" {
+		return "", "", nil
+	}
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	query := url.Values{
+		"skyaddr": []string{skyAddr},
+	}.Encode()
+
+	apiURL := func(path string) string {
+		u := *base
+		u.Path = strings.TrimSuffix(base.Path, "/") + path
+		u.RawPath = ""
+		u.RawQuery = query
+		return u.String()
+	}
+
+	return apiURL("/api/status"), apiURL("/api/status/longpoll"), nil
 }
 
 type bindRequest struct {
-	SkyAddr  string `json:"skyaddr"`
-	CoinType string `json:"coin_type"`
+	SkyAddr  string        `json:"skyaddr"`
+	CoinType string        `json:"coin_type"`
+	Proof    *AddressProof `json:"proof,omitempty"`
 }
 
 // BindHandler binds skycoin address with a bitcoin address
@@ -348,7 +494,8 @@ type bindRequest struct {
 // Accept: application/json
 // URI: /api/bind
 // Args:
-//    {"skyaddr": "...", "coin_type": "BTC"}
+//    {"skyaddr": "...", "coin_type": "BTC", "proof": {"challenge": "...", "signature": "..."}}
+//    proof is only required if teller.require_address_proof is enabled
 func BindHandler(s *HTTPServer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -365,81 +512,53 @@ func BindHandler(s *HTTPServer) http.HandlerFunc {
 			return
 		}
 
-		bindReq := &bindRequest{}
-		decoder := json.NewDecoder(r.Body)
-		if err := decoder.Decode(&bindReq); err != nil {
-			err = fmt.Errorf("Invalid json request body: %v", err)
-			errorResponse(ctx, w, http.StatusBadRequest, err)
-			return
-		}
 		defer func(log logrus.FieldLogger) {
 			if err := r.Body.Close(); err != nil {
 				log.WithError(err).Warn("Failed to closed request body")
 			}
 		}(log)
 
-		// Remove extraneous whitespace
-		bindReq.SkyAddr = strings.Trim(bindReq.SkyAddr, "\n\t ")
-
-		log = log.WithField("bindReq", bindReq)
-		ctx = logger.WithContext(ctx, log)
-
-		if bindReq.SkyAddr == "" {
-			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddr"))
-			return
-		}
-
-		switch bindReq.CoinType {
-		case scanner.CoinTypeBTC:
-			if !s.cfg.BtcRPC.Enabled {
-				errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("%s not enabled", scanner.CoinTypeBTC))
-				return
-			}
-		case scanner.CoinTypeETH:
-			if !s.cfg.EthRPC.Enabled {
-				errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("%s not enabled", scanner.CoinTypeETH))
+		bindReq := &bindRequest{}
+		if err := decodeRequest(r, s, bindReq); err != nil {
+			if _, ok := err.(*http.MaxBytesError); ok {
+				errorResponse(ctx, w, http.StatusRequestEntityTooLarge, err)
 				return
 			}
-		case "":
-			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing coin_type"))
-			return
-		default:
-			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid coin_type"))
+
+			errorResponse(ctx, w, http.StatusBadRequest, err)
 			return
 		}
 
-		log.Info()
+		log = log.WithField("bindReq", bindReq)
+		ctx = logger.WithContext(ctx, log)
 
-		if !verifySkycoinAddress(ctx, w, bindReq.SkyAddr) {
-			return
-		}
+		log.Info()
 
 		log.Info("Calling service.BindAddress")
 
-		boundAddr, err := s.service.BindAddress(bindReq.SkyAddr, bindReq.CoinType)
+		boundAddr, err := s.service.BindAddress(bindReq.SkyAddr, bindReq.CoinType, bindReq.Proof)
 		if err != nil {
 			log.WithError(err).Error("service.BindAddress failed")
-			switch err {
-			case ErrBindDisabled:
-				errorResponse(ctx, w, http.StatusForbidden, err)
-			default:
-				switch err {
-				case addrs.ErrDepositAddressEmpty, ErrMaxBoundAddresses:
-				default:
-					err = errInternalServerError
-				}
-				errorResponse(ctx, w, http.StatusInternalServerError, err)
-			}
+			serviceErrorResponse(ctx, w, err)
 			return
 		}
 
 		log = log.WithField("boundAddr", boundAddr)
 		log.Infof("Bound sky and %s addresses", bindReq.CoinType)
 
-		if err := httputil.JSONResponse(w, BindResponse{
+		// web.base_url is validated at startup
+		statusURL, eventsURL, err := statusURLs(s.cfg.Web.BaseURL, bindReq.SkyAddr)
+		if err != nil {
+			log.WithError(err).Error("statusURLs failed")
+		}
+
+		if err := jsonResponse(ctx, w, BindResponse{
 			DepositAddress: boundAddr.Address,
 			CoinType:       boundAddr.CoinType,
 			BuyMethod:      boundAddr.BuyMethod,
+			Derivation:     boundAddr.Derivation,
+			StatusURL:      statusURL,
+			EventsURL:      eventsURL,
 		}); err != nil {
 			log.WithError(err).Error(err)
 		}
@@ -451,6 +570,67 @@ type StatusResponse struct {
 	Statuses []exchange.DepositStatus `json:"statuses,omitempty"`
 }
 
+// HistoryResponse http response for /api/history
+type HistoryResponse struct {
+	Events []exchange.DepositEvent `json:"events"`
+}
+
+type bindChallengeRequest struct {
+	SkyAddr string `json:"skyaddr"`
+}
+
+// BindChallengeHandler issues a challenge to sign with the skycoin address's key,
+// to prove ownership of the address when binding
+// Method: POST
+// Accept: application/json
+// URI: /api/bind-challenge
+// Args:
+//    {"skyaddr": "..."}
+func BindChallengeHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		w.Header().Set("Accept", "application/json")
+
+		if !validMethod(ctx, w, r, []string{http.MethodPost}) {
+			return
+		}
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			errorResponse(ctx, w, http.StatusUnsupportedMediaType, errors.New("Invalid content type"))
+			return
+		}
+
+		req := &bindChallengeRequest{}
+		if err := decodeRequest(r, s, req); err != nil {
+			if _, ok := err.(*http.MaxBytesError); ok {
+				errorResponse(ctx, w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+
+			errorResponse(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+
+		ch, err := s.service.IssueChallenge(req.SkyAddr)
+		if err != nil {
+			switch err {
+			case ErrAddressProofDisabled:
+				errorResponse(ctx, w, http.StatusNotFound, err)
+			default:
+				log.WithError(err).Error("service.IssueChallenge failed")
+				errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			}
+			return
+		}
+
+		if err := jsonResponse(ctx, w, ch); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
 // StatusHandler returns the deposit status of specific skycoin address
 // Method: GET
 // URI: /api/status
@@ -465,10 +645,8 @@ func StatusHandler(s *HTTPServer) http.HandlerFunc {
 			return
 		}
 
-		skyAddr := r.URL.Query().Get("skyaddr")
-
 		// Remove extraneous whitespace
-		skyAddr = strings.Trim(skyAddr, "\n\t ")
+		skyAddr := trimSkyAddress(r.URL.Query().Get("skyaddr"))
 
 		if skyAddr == "" {
 			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddr"))
@@ -480,7 +658,8 @@ func StatusHandler(s *HTTPServer) http.HandlerFunc {
 
 		log.Info()
 
-		if !verifySkycoinAddress(ctx, w, skyAddr) {
+		skyAddr, ok := verifySkycoinAddress(ctx, w, skyAddr)
+		if !ok {
 			return
 		}
 
@@ -499,7 +678,198 @@ func StatusHandler(s *HTTPServer) http.HandlerFunc {
 		})
 		log.Info("Got depositStatuses")
 
-		if err := httputil.JSONResponse(w, StatusResponse{
+		if err := jsonResponse(ctx, w, StatusResponse{
+			Statuses: depositStatuses,
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// HistoryHandler returns the status changes of the deposits to a deposit address, oldest first
+// Method: GET
+// URI: /api/history
+// Args:
+//     address - the BTC or ETH deposit address
+func HistoryHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		depositAddr := strings.Trim(r.URL.Query().Get("address"), "\n\t ")
+		if depositAddr == "" {
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing address"))
+			return
+		}
+
+		log = log.WithField("depositAddr", depositAddr)
+		ctx = logger.WithContext(ctx, log)
+
+		events, err := s.service.DepositHistory(depositAddr)
+		if err != nil {
+			log.WithError(err).Error("service.DepositHistory failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		if events == nil {
+			events = []exchange.DepositEvent{}
+		}
+
+		if err := jsonResponse(ctx, w, HistoryResponse{
+			Events: events,
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// StatusLongPollHandler returns the deposit statuses of a skycoin address once any of them changes.
+// It is a fallback for clients that can't receive streamed updates, e.g. behind proxies that buffer responses.
+// If a status was updated after since, it returns immediately. Otherwise it waits up to web.long_poll_timeout
+// for a status change, then returns the current statuses.
+// Method: GET
+// URI: /api/status/longpoll
+// Args:
+//     skyaddr
+//     since # optional unix timestamp, the latest updated_at the client has seen
+func StatusLongPollHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		skyAddr := trimSkyAddress(r.URL.Query().Get("skyaddr"))
+		if skyAddr == "" {
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddr"))
+			return
+		}
+
+		var since int64
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			since, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid since"))
+				return
+			}
+		}
+
+		log = log.WithField("skyAddr", skyAddr)
+		ctx = logger.WithContext(ctx, log)
+
+		skyAddr, ok := verifySkycoinAddress(ctx, w, skyAddr)
+		if !ok {
+			return
+		}
+
+		// Stop waiting when the server shuts down
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-s.quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		depositStatuses, err := s.service.WaitDepositStatuses(ctx, skyAddr, since, s.cfg.Web.LongPollTimeout)
+		if err != nil {
+			log.WithError(err).Error("service.WaitDepositStatuses failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		if err := jsonResponse(ctx, w, StatusResponse{
+			Statuses: depositStatuses,
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// BulkStatusResponse http response for /api/status/bulk
+type BulkStatusResponse struct {
+	// Statuses are the deposit statuses of each requested skycoin address, empty if nothing is bound to it
+	Statuses map[string][]exchange.DepositStatus `json:"statuses"`
+}
+
+type bulkStatusRequest struct {
+	SkyAddrs []string `json:"skyaddrs"`
+}
+
+// BulkStatusHandler returns the deposit statuses of several skycoin addresses at once,
+// read in one db transaction. At most web.max_bulk_status_addrs addresses can be requested.
+// Method: POST
+// Accept: application/json
+// URI: /api/status/bulk
+// Args:
+//    {"skyaddrs": ["...", "..."]}
+func BulkStatusHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		w.Header().Set("Accept", "application/json")
+
+		if !validMethod(ctx, w, r, []string{http.MethodPost}) {
+			return
+		}
+
+		if r.Header.Get("Content-Type") != "application/json" {
+			errorResponse(ctx, w, http.StatusUnsupportedMediaType, errors.New("Invalid content type"))
+			return
+		}
+
+		var req bulkStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if _, ok := err.(*http.MaxBytesError); ok {
+				errorResponse(ctx, w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+
+			err = fmt.Errorf("Invalid json request body: %v", err)
+			errorResponse(ctx, w, http.StatusBadRequest, err)
+			return
+		}
+
+		if len(req.SkyAddrs) == 0 {
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddrs"))
+			return
+		}
+
+		if len(req.SkyAddrs) > s.cfg.Web.MaxBulkStatusAddrs {
+			errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("Too many skyaddrs, the maximum is %d", s.cfg.Web.MaxBulkStatusAddrs))
+			return
+		}
+
+		for i, skyAddr := range req.SkyAddrs {
+			skyAddr, ok := verifySkycoinAddress(ctx, w, skyAddr)
+			if !ok {
+				return
+			}
+			req.SkyAddrs[i] = skyAddr
+		}
+
+		log = log.WithField("skyAddrsLen", len(req.SkyAddrs))
+		ctx = logger.WithContext(ctx, log)
+
+		depositStatuses, err := s.service.GetDepositStatusesOfSkyAddresses(req.SkyAddrs)
+		if err != nil {
+			log.WithError(err).Error("service.GetDepositStatusesOfSkyAddresses failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		if err := jsonResponse(ctx, w, BulkStatusResponse{
 			Statuses: depositStatuses,
 		}); err != nil {
 			log.WithError(err).Error(err)
@@ -530,45 +900,156 @@ func ConfigHandler(s *HTTPServer) http.HandlerFunc {
 			return
 		}
 
-		// Convert the exchange rate to a skycoin balance string
-		rate := s.cfg.SkyExchanger.SkyBtcExchangeRate
-		maxDecimals := s.cfg.SkyExchanger.MaxDecimals
-		dropletsPerBTC, err := exchange.CalculateBtcSkyValue(exchange.SatoshisPerBTC, rate, maxDecimals)
+		skyPerBTC, skyPerETH, err := exchangeRates(s.cfg.SkyExchanger)
 		if err != nil {
-			log.WithError(err).Error("exchange.CalculateBtcSkyValue failed")
+			log.WithError(err).Error("exchangeRates failed")
 			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
 			return
 		}
 
-		skyPerBTC, err := droplet.ToString(dropletsPerBTC)
-		if err != nil {
-			log.WithError(err).Error("droplet.ToString failed")
-			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+		if err := jsonResponse(ctx, w, ConfigResponse{
+			Enabled:                  s.cfg.Teller.BindEnabled,
+			BtcConfirmationsRequired: s.cfg.BtcScanner.ConfirmationsRequired,
+			EthConfirmationsRequired: s.cfg.EthScanner.ConfirmationsRequired,
+			SkyBtcExchangeRate:       skyPerBTC,
+			SkyEthExchangeRate:       skyPerETH,
+			MaxDecimals:              s.cfg.SkyExchanger.MaxDecimals,
+			MaxBoundAddresses:        s.cfg.Teller.MaxBoundAddresses,
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// exchangeRates returns the SKY bought by one BTC and by one ETH, as skycoin balance strings
+func exchangeRates(cfg config.SkyExchanger) (string, string, error) {
+	btcRate, ethRate := cfg.SkyBtcExchangeRate, cfg.SkyEthExchangeRate
+	// Every deposit is converted at the test mode rate in test mode
+	if cfg.TestMode {
+		btcRate, ethRate = cfg.TestModeRate, cfg.TestModeRate
+	}
+
+	perBTC, err := exchange.CalculateBtcSkyValueRounded(exchange.SatoshisPerBTC, btcRate, cfg.MaxDecimals, cfg.RoundingMode)
+	if err != nil {
+		return "", "", err
+	}
+
+	skyPerBTC, err := droplet.ToString(perBTC.Droplets)
+	if err != nil {
+		return "", "", err
+	}
+
+	perETH, err := exchange.CalculateEthSkyValueRounded(big.NewInt(exchange.WeiPerETH), ethRate, cfg.MaxDecimals, cfg.RoundingMode)
+	if err != nil {
+		return "", "", err
+	}
+
+	skyPerETH, err := droplet.ToString(perETH.Droplets)
+	if err != nil {
+		return "", "", err
+	}
+
+	return skyPerBTC, skyPerETH, nil
+}
+
+// SaleStatusResponse http response for /api/sale/status
+type SaleStatusResponse struct {
+	// TotalSKY is the SKY on sale, nil if the sale is uncapped
+	TotalSKY *string `json:"total_sky"`
+	SoldSKY  string  `json:"sold_sky"`
+	// RemainingSKY is the SKY left on sale, nil if the sale is uncapped
+	RemainingSKY *string `json:"remaining_sky"`
+	// Rate is the SKY bought by one coin of each coin type
+	Rate map[string]string `json:"rate"`
+	Open bool              `json:"open"`
+}
+
+// saleStatusCache holds the last /api/sale/status response
+type saleStatusCache struct {
+	sync.Mutex
+	resp      SaleStatusResponse
+	expiresAt time.Time
+}
+
+// SaleStatusHandler returns the progress of the sale: the SKY sold so far, the exchange rates,
+// and whether new addresses can be bound. Only totals are returned, never per-address data.
+// The sale is uncapped, so total_sky and remaining_sky are null.
+// Method: GET
+// URI: /api/sale/status
+func SaleStatusHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
 			return
 		}
-		rate = s.cfg.SkyExchanger.SkyEthExchangeRate
-		dropletsPerETH, err := exchange.CalculateEthSkyValue(big.NewInt(exchange.WeiPerETH), rate, maxDecimals)
+
+		resp, err := s.getSaleStatus()
 		if err != nil {
-			log.WithError(err).Error("exchange.CalculateEthSkyValue failed")
+			log.WithError(err).Error("getSaleStatus failed")
 			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
 			return
 		}
-		skyPerETH, err := droplet.ToString(dropletsPerETH)
-		if err != nil {
-			log.WithError(err).Error("droplet.ToString failed")
-			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+
+		if err := jsonResponse(ctx, w, resp); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// getSaleStatus returns the cached sale status, computing it again if it has expired
+func (s *HTTPServer) getSaleStatus() (SaleStatusResponse, error) {
+	c := &s.saleStatus
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if now.Before(c.expiresAt) {
+		return c.resp, nil
+	}
+
+	stats, err := s.exchanger.GetDepositStats()
+	if err != nil {
+		return SaleStatusResponse{}, err
+	}
+
+	soldSKY, err := droplet.ToString(uint64(stats.TotalSKYSent))
+	if err != nil {
+		return SaleStatusResponse{}, err
+	}
+
+	skyPerBTC, skyPerETH, err := exchangeRates(s.cfg.SkyExchanger)
+	if err != nil {
+		return SaleStatusResponse{}, err
+	}
+
+	c.resp = SaleStatusResponse{
+		SoldSKY: soldSKY,
+		Rate: map[string]string{
+			scanner.CoinTypeBTC: skyPerBTC,
+			scanner.CoinTypeETH: skyPerETH,
+		},
+		Open: s.cfg.Teller.BindEnabled && !s.exchanger.Frozen(),
+	}
+	c.expiresAt = now.Add(saleStatusCacheTTL)
+
+	return c.resp, nil
+}
+
+// VersionHandler returns the build version of teller
+// Method: GET
+// URI: /api/version
+func VersionHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
 			return
 		}
 
-		if err := httputil.JSONResponse(w, ConfigResponse{
-			Enabled:                  s.cfg.Teller.BindEnabled,
-			BtcConfirmationsRequired: s.cfg.BtcScanner.ConfirmationsRequired,
-			EthConfirmationsRequired: s.cfg.EthScanner.ConfirmationsRequired,
-			SkyBtcExchangeRate:       skyPerBTC,
-			SkyEthExchangeRate:       skyPerETH,
-			MaxDecimals:              maxDecimals,
-			MaxBoundAddresses:        s.cfg.Teller.MaxBoundAddresses,
-		}); err != nil {
+		if err := jsonResponse(ctx, w, version.Get()); err != nil {
 			log.WithError(err).Error(err)
 		}
 	}
@@ -634,7 +1115,116 @@ func ExchangeStatusHandler(s *HTTPServer) http.HandlerFunc {
 
 		log.WithField("resp", resp).Info()
 
-		if err := httputil.JSONResponse(w, resp); err != nil {
+		if err := jsonResponse(ctx, w, resp); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// ReceiptHandler returns a signed receipt for a completed deposit, as a downloadable file
+// Method: GET
+// URI: /api/receipt
+// Args:
+//     deposit_id - the deposit's "txid:n"
+//     format - "json" (default) or "jwt"
+func ReceiptHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		depositID := strings.Trim(r.URL.Query().Get("deposit_id"), "\n\t ")
+		if depositID == "" {
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing deposit_id"))
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "", "json", "jwt":
+		default:
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid format"))
+			return
+		}
+
+		log = log.WithField("depositID", depositID)
+		ctx = logger.WithContext(ctx, log)
+
+		handleErr := func(err error) {
+			log.WithError(err).Error("service.Receipt failed")
+			switch err {
+			case ErrReceiptsDisabled, ErrDepositNotFound:
+				errorResponse(ctx, w, http.StatusNotFound, err)
+			case ErrReceiptNotAvailable:
+				errorResponse(ctx, w, http.StatusBadRequest, err)
+			default:
+				errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			}
+		}
+
+		// Receipts are named after the deposit's txid, which is hex
+		filename := "receipt-" + strings.Replace(depositID, ":", "-", -1)
+
+		if format == "jwt" {
+			token, err := s.service.ReceiptJWT(depositID)
+			if err != nil {
+				handleErr(err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/jwt")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.jwt"`, filename))
+			if _, err := w.Write([]byte(token)); err != nil {
+				log.WithError(err).Error(err)
+			}
+			return
+		}
+
+		receipt, err := s.service.Receipt(depositID)
+		if err != nil {
+			handleErr(err)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		// The receipt is a file download, not an API response, so it is never wrapped in the envelope
+		if err := httputil.JSONResponse(w, receipt); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// ReceiptKeyResponse http response for /api/receipt-key
+type ReceiptKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+}
+
+// ReceiptKeyHandler returns the public key that verifies receipts
+// Method: GET
+// URI: /api/receipt-key
+func ReceiptKeyHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		pubKey, err := s.service.ReceiptPublicKey()
+		if err != nil {
+			errorResponse(ctx, w, http.StatusNotFound, err)
+			return
+		}
+
+		if err := jsonResponse(ctx, w, ReceiptKeyResponse{
+			Algorithm: "ed25519",
+			PublicKey: hex.EncodeToString(pubKey),
+		}); err != nil {
 			log.WithError(err).Error(err)
 		}
 	}
@@ -655,32 +1245,141 @@ func validMethod(ctx context.Context, w http.ResponseWriter, r *http.Request, al
 	return false
 }
 
-func verifySkycoinAddress(ctx context.Context, w http.ResponseWriter, skyAddr string) bool {
+// verifySkycoinAddress returns the canonical form of a skycoin address, see canonicalSkyAddress.
+// If it is invalid, it writes a 400 response and returns false
+func verifySkycoinAddress(ctx context.Context, w http.ResponseWriter, skyAddr string) (string, bool) {
 	log := logger.FromContext(ctx)
 
-	if _, err := cipher.DecodeBase58Address(skyAddr); err != nil {
-		msg := fmt.Sprintf("Invalid skycoin address: %v", err)
-		httputil.ErrResponse(w, http.StatusBadRequest, msg)
-		log.WithFields(logrus.Fields{
-			"status":  http.StatusBadRequest,
-			"skyAddr": skyAddr,
-		}).WithError(err).Info("Invalid skycoin address")
-		return false
+	canonical, err := canonicalSkyAddress(skyAddr)
+	if err != nil {
+		ctx = logger.WithContext(ctx, log.WithField("skyAddr", skyAddr))
+		errorResponse(ctx, w, http.StatusBadRequest, err)
+		return "", false
 	}
 
-	return true
+	return canonical, true
 }
 
-func errorResponse(ctx context.Context, w http.ResponseWriter, code int, err error) {
+func errorResponse(ctx context.Context, w http.ResponseWriter, status int, err error) {
 	log := logger.FromContext(ctx)
 	log.WithFields(logrus.Fields{
-		"status":    code,
-		"statusMsg": http.StatusText(code),
+		"status":    status,
+		"statusMsg": http.StatusText(status),
 	}).WithError(err).Info()
 
-	if err != errInternalServerError {
-		httputil.ErrResponse(w, code, err.Error())
-	} else {
-		httputil.ErrResponse(w, code)
+	if !envelopeEnabled(ctx) {
+		if err != errInternalServerError {
+			httputil.ErrResponse(w, status, err.Error())
+		} else {
+			httputil.ErrResponse(w, status)
+		}
+		return
+	}
+
+	code := errorCode(status)
+	if _, c, ok := errorStatus(err); ok {
+		code = c
+	}
+
+	apiErr := &APIError{
+		Code:    code,
+		Message: err.Error(),
+	}
+	if verr, ok := err.(ValidationError); ok {
+		apiErr.Fields = verr.Fields
+	}
+
+	d, mErr := json.MarshalIndent(APIResponse{
+		APIVersion: apiVersion,
+		Error:      apiErr,
+	}, "", "    ")
+	if mErr != nil {
+		log.WithError(mErr).Error("json.MarshalIndent failed")
+		httputil.ErrResponse(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(d); err != nil {
+		log.WithError(err).Error(err)
+	}
+}
+
+// serviceErrorResponse writes an error returned by the Service, with the HTTP status
+// and error code from errorStatus. Unmapped errors are internal server errors.
+func serviceErrorResponse(ctx context.Context, w http.ResponseWriter, err error) {
+	status, _, ok := errorStatus(err)
+	if !ok {
+		status = http.StatusInternalServerError
+		err = errInternalServerError
+	}
+
+	errorResponse(ctx, w, status, err)
+}
+
+// errorStatus maps an error that is returned to API clients to its HTTP status and
+// machine-readable error code. ok is false if the error has no specific mapping.
+func errorStatus(err error) (status int, code string, ok bool) {
+	switch e := err.(type) {
+	case InvalidSkyAddressError:
+		return http.StatusBadRequest, "invalid_sky_address", true
+	case ValidationError:
+		return http.StatusBadRequest, e.code(), true
+	}
+
+	switch err {
+	case ErrBindDisabled:
+		return http.StatusForbidden, "sale_not_started", true
+	case ErrAddressNotAllowed:
+		return http.StatusForbidden, "address_not_allowed", true
+	case ErrMaxBoundAddresses:
+		return http.StatusConflict, "already_bound", true
+	case ErrAddressProofRequired:
+		return http.StatusUnauthorized, "address_proof_required", true
+	case ErrChallengeNotFound, ErrChallengeExpired, ErrInvalidAddressProof:
+		return http.StatusUnauthorized, "invalid_address_proof", true
+	case addrs.ErrDepositAddressEmpty:
+		return http.StatusInternalServerError, "deposit_address_unavailable", true
+	case exchange.ErrFrozen:
+		return http.StatusServiceUnavailable, "frozen", true
+	default:
+		return 0, "", false
+	}
+}
+
+// InvalidSkyAddressError is returned to API clients for a malformed skycoin address
+type InvalidSkyAddressError struct {
+	err error
+}
+
+func (e InvalidSkyAddressError) Error() string {
+	return fmt.Sprintf("Invalid skycoin address: %v", e.err)
+}
+
+// jsonResponse writes data as JSON, wrapped in an APIResponse if the envelope is enabled
+func jsonResponse(ctx context.Context, w http.ResponseWriter, data interface{}) error {
+	if !envelopeEnabled(ctx) {
+		return httputil.JSONResponse(w, data)
+	}
+
+	return httputil.JSONResponse(w, APIResponse{
+		APIVersion: apiVersion,
+		Data:       data,
+	})
+}
+
+func envelopeEnabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(envelopeCtxKey{}).(bool)
+	return enabled
+}
+
+// errorCode returns the default machine-readable error code for an HTTP status,
+// e.g. "bad_request" for 400 Bad Request
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "unknown_error"
 	}
+	return strings.ToLower(strings.Replace(text, " ", "_", -1))
 }
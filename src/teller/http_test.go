@@ -1,25 +1,41 @@
 package teller
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/skycoin/skycoin/src/api/cli"
+	"github.com/skycoin/skycoin/src/cipher"
 
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/config"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/dbutil"
 	"github.com/skycoin/teller/src/util/testutil"
+	"github.com/skycoin/teller/src/version"
 )
 
 type fakeExchanger struct {
 	mock.Mock
+	frozen bool
+}
+
+func (e *fakeExchanger) Frozen() bool {
+	return e.frozen
 }
 
 func (e *fakeExchanger) BindAddress(skyAddr, depositAddr, coinType string) (*exchange.BoundAddress, error) {
@@ -38,11 +54,36 @@ func (e *fakeExchanger) GetDepositStatuses(skyAddr string) ([]exchange.DepositSt
 	return args.Get(0).([]exchange.DepositStatus), args.Error(1)
 }
 
+func (e *fakeExchanger) GetDepositStatusesOfSkyAddresses(skyAddrs []string) (map[string][]exchange.DepositStatus, error) {
+	args := e.Called(skyAddrs)
+
+	dss := args.Get(0)
+	if dss == nil {
+		return nil, args.Error(1)
+	}
+
+	return dss.(map[string][]exchange.DepositStatus), args.Error(1)
+}
+
 func (e *fakeExchanger) GetDepositStatusDetail(flt exchange.DepositFilter) ([]exchange.DepositStatusDetail, error) {
 	args := e.Called(flt)
 	return args.Get(0).([]exchange.DepositStatusDetail), args.Error(1)
 }
 
+func (e *fakeExchanger) GetDepositInfo(depositID string) (exchange.DepositInfo, error) {
+	args := e.Called(depositID)
+	return args.Get(0).(exchange.DepositInfo), args.Error(1)
+}
+
+func (e *fakeExchanger) GetDepositHistory(depositAddr string) ([]exchange.DepositEvent, error) {
+	args := e.Called(depositAddr)
+	events := args.Get(0)
+	if events == nil {
+		return nil, args.Error(1)
+	}
+	return events.([]exchange.DepositEvent), args.Error(1)
+}
+
 func (e *fakeExchanger) GetBindNum(skyAddr string) (int, error) {
 	args := e.Called(skyAddr)
 	return args.Int(0), args.Error(1)
@@ -58,6 +99,11 @@ func (e *fakeExchanger) Status() error {
 	return args.Error(0)
 }
 
+func (e *fakeExchanger) Subscribe() (<-chan exchange.StatusEvent, func()) {
+	args := e.Called()
+	return args.Get(0).(<-chan exchange.StatusEvent), args.Get(1).(func())
+}
+
 func (e *fakeExchanger) Balance() (*cli.Balance, error) {
 	args := e.Called()
 
@@ -205,3 +251,1139 @@ func TestExchangeStatusHandler(t *testing.T) {
 	}
 
 }
+
+func TestSaleStatusHandler(t *testing.T) {
+	tt := []struct {
+		name        string
+		bindEnabled bool
+		frozen      bool
+		open        bool
+	}{
+		{"open", true, false, true},
+		{"closed, bind disabled", false, false, false},
+		{"closed, frozen", true, true, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &fakeExchanger{
+				frozen: tc.frozen,
+			}
+			e.On("GetDepositStats").Return(&exchange.DepositStats{
+				TotalBTCReceived: 1e8,
+				TotalSKYSent:     123e6,
+			}, nil)
+
+			log, _ := testutil.NewLogger(t)
+
+			httpServ := &HTTPServer{
+				log:       log,
+				exchanger: e,
+				cfg: config.Config{
+					Teller: config.Teller{
+						BindEnabled: tc.bindEnabled,
+					},
+					SkyExchanger: config.SkyExchanger{
+						SkyBtcExchangeRate: "123",
+						SkyEthExchangeRate: "30",
+					},
+				},
+			}
+			handler := httpServ.setupMux()
+
+			// The second request is served from the cache
+			for i := 0; i < 2; i++ {
+				req, err := http.NewRequest(http.MethodGet, "/api/sale/status", nil)
+				require.NoError(t, err)
+
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+				var msg map[string]interface{}
+				err = json.Unmarshal(rr.Body.Bytes(), &msg)
+				require.NoError(t, err)
+
+				// Uncapped, and no per-user data
+				require.Equal(t, map[string]interface{}{
+					"total_sky":     nil,
+					"sold_sky":      "123.000000",
+					"remaining_sky": nil,
+					"rate": map[string]interface{}{
+						"BTC": "123.000000",
+						"ETH": "30.000000",
+					},
+					"open": tc.open,
+				}, msg)
+			}
+
+			e.AssertNumberOfCalls(t, "GetDepositStats", 1)
+		})
+	}
+
+	t.Run("stats error", func(t *testing.T) {
+		e := &fakeExchanger{}
+		e.On("GetDepositStats").Return((*exchange.DepositStats)(nil), errors.New("stats failed"))
+
+		log, _ := testutil.NewLogger(t)
+
+		httpServ := &HTTPServer{
+			log:       log,
+			exchanger: e,
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/api/sale/status", nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		httpServ.setupMux().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
+
+func TestReceiptHandler(t *testing.T) {
+	signer, err := NewReceiptSigner(testReceiptKey)
+	require.NoError(t, err)
+
+	done := testCompletedDeposit()
+	pending := testCompletedDeposit()
+	pending.DepositID = "aa:1"
+	pending.Status = exchange.StatusWaitConfirm
+
+	tt := []struct {
+		name     string
+		method   string
+		url      string
+		disabled bool
+		status   int
+		err      string
+	}{
+		{
+			"405",
+			http.MethodPost,
+			"/api/receipt?deposit_id=" + done.DepositID,
+			false,
+			http.StatusMethodNotAllowed,
+			"Invalid request method",
+		},
+		{
+			"400 missing deposit_id",
+			http.MethodGet,
+			"/api/receipt",
+			false,
+			http.StatusBadRequest,
+			"Missing deposit_id",
+		},
+		{
+			"400 invalid format",
+			http.MethodGet,
+			"/api/receipt?format=xml&deposit_id=" + done.DepositID,
+			false,
+			http.StatusBadRequest,
+			"Invalid format",
+		},
+		{
+			"404 receipts disabled",
+			http.MethodGet,
+			"/api/receipt?deposit_id=" + done.DepositID,
+			true,
+			http.StatusNotFound,
+			ErrReceiptsDisabled.Error(),
+		},
+		{
+			"404 deposit not found",
+			http.MethodGet,
+			"/api/receipt?deposit_id=bb:0",
+			false,
+			http.StatusNotFound,
+			ErrDepositNotFound.Error(),
+		},
+		{
+			"400 deposit not complete",
+			http.MethodGet,
+			"/api/receipt?deposit_id=" + pending.DepositID,
+			false,
+			http.StatusBadRequest,
+			ErrReceiptNotAvailable.Error(),
+		},
+		{
+			"200 json",
+			http.MethodGet,
+			"/api/receipt?deposit_id=" + done.DepositID,
+			false,
+			http.StatusOK,
+			"",
+		},
+		{
+			"200 jwt",
+			http.MethodGet,
+			"/api/receipt?format=jwt&deposit_id=" + done.DepositID,
+			false,
+			http.StatusOK,
+			"",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &fakeExchanger{}
+			e.On("GetDepositInfo", done.DepositID).Return(done, nil)
+			e.On("GetDepositInfo", pending.DepositID).Return(pending, nil)
+			e.On("GetDepositInfo", "bb:0").Return(exchange.DepositInfo{}, dbutil.NewObjectNotExistErr(exchange.DepositInfoBkt, []byte("bb:0")))
+
+			service := &Service{
+				exchanger:     e,
+				receiptSigner: signer,
+			}
+			if tc.disabled {
+				service.receiptSigner = nil
+			}
+
+			req, err := http.NewRequest(tc.method, tc.url, nil)
+			require.NoError(t, err)
+
+			log, _ := testutil.NewLogger(t)
+
+			rr := httptest.NewRecorder()
+			httpServ := &HTTPServer{
+				log:       log,
+				exchanger: e,
+				service:   service,
+			}
+			httpServ.cfg.Web.ThrottleMax = 10
+			httpServ.cfg.Web.ThrottleDuration = time.Second
+			handler := httpServ.setupMux()
+
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.status, rr.Code)
+
+			if rr.Code != http.StatusOK {
+				require.Equal(t, tc.err, strings.TrimSpace(rr.Body.String()))
+				return
+			}
+
+			require.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+
+			if strings.Contains(tc.url, "format=jwt") {
+				r, err := VerifyReceiptJWT(signer.PublicKey(), rr.Body.String())
+				require.NoError(t, err)
+				require.Equal(t, done.DepositID, r.DepositID)
+				return
+			}
+
+			var sr SignedReceipt
+			err = json.Unmarshal(rr.Body.Bytes(), &sr)
+			require.NoError(t, err)
+			require.NoError(t, VerifyReceipt(signer.PublicKey(), sr))
+			require.Equal(t, done.DepositID, sr.Receipt.DepositID)
+		})
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	events := []exchange.DepositEvent{
+		{
+			DepositID: "aa:0",
+			Status:    exchange.StatusWaitSend.String(),
+			CreatedAt: 1500000000,
+		},
+		{
+			DepositID: "aa:0",
+			Status:    exchange.StatusWaitConfirm.String(),
+			Txid:      "sky-tx",
+			CreatedAt: 1500000060,
+		},
+	}
+
+	e := &fakeExchanger{}
+	e.On("GetDepositHistory", "btc-addr").Return(events, nil)
+	e.On("GetDepositHistory", "unused-addr").Return(nil, nil)
+	e.On("GetDepositHistory", "bad-addr").Return(nil, errors.New("db failed"))
+
+	log, _ := testutil.NewLogger(t)
+	httpServ := &HTTPServer{
+		log:       log,
+		exchanger: e,
+		service: &Service{
+			cfg: config.Teller{
+				StatusMessages: map[string]string{
+					exchange.StatusWaitConfirm.String(): "status.waiting_confirm",
+				},
+			},
+			exchanger: e,
+		},
+	}
+	httpServ.cfg.Web.ThrottleMax = 10
+	httpServ.cfg.Web.ThrottleDuration = time.Second
+	handler := httpServ.setupMux()
+
+	get := func(method, url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusMethodNotAllowed, get(http.MethodPost, "/api/history?address=btc-addr").Code)
+
+	rr := get(http.MethodGet, "/api/history")
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Equal(t, "Missing address", strings.TrimSpace(rr.Body.String()))
+
+	require.Equal(t, http.StatusInternalServerError, get(http.MethodGet, "/api/history?address=bad-addr").Code)
+
+	rr = get(http.MethodGet, "/api/history?address=unused-addr")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `{"events":[]}`, rr.Body.String())
+
+	// Each event has its status message
+	rr = get(http.MethodGet, "/api/history?address=btc-addr")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var hr HistoryResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &hr)
+	require.NoError(t, err)
+
+	expected := events
+	expected[0].Message = exchange.StatusWaitSend.Message()
+	expected[1].Message = "status.waiting_confirm"
+	require.Equal(t, expected, hr.Events)
+}
+
+func TestReceiptKeyHandler(t *testing.T) {
+	signer, err := NewReceiptSigner(testReceiptKey)
+	require.NoError(t, err)
+
+	log, _ := testutil.NewLogger(t)
+
+	// Receipts disabled
+	httpServ := &HTTPServer{
+		log:     log,
+		service: &Service{},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/api/receipt-key", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	httpServ.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Receipts enabled
+	httpServ.service.receiptSigner = signer
+
+	rr = httptest.NewRecorder()
+	httpServ.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var rsp ReceiptKeyResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+	require.NoError(t, err)
+	require.Equal(t, "ed25519", rsp.Algorithm)
+	require.Equal(t, hex.EncodeToString(signer.PublicKey()), rsp.PublicKey)
+}
+
+func TestVersionHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	httpServ := &HTTPServer{
+		log:     log,
+		service: &Service{},
+	}
+
+	defer func(v, c, b string) {
+		version.Version, version.Commit, version.BuildTime = v, c, b
+	}(version.Version, version.Commit, version.BuildTime)
+	version.Version = "v1.0.0"
+	version.Commit = "8798b5ee43c7ce43b9b75d57a1a6cd2c1295cd1e"
+	version.BuildTime = "2018-03-05T11:04:15Z"
+
+	req, err := http.NewRequest(http.MethodGet, "/api/version", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	httpServ.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var rsp version.Info
+	err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+	require.NoError(t, err)
+	require.Equal(t, version.Info{
+		Version:   "v1.0.0",
+		Commit:    "8798b5ee43c7ce43b9b75d57a1a6cd2c1295cd1e",
+		BuildTime: "2018-03-05T11:04:15Z",
+	}, rsp)
+
+	req, err = http.NewRequest(http.MethodPost, "/api/version", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	httpServ.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestAccessLog(t *testing.T) {
+	log, hook := testutil.NewLogger(t)
+
+	httpServ := &HTTPServer{
+		log: log,
+		cfg: config.Config{
+			Web: config.Web{
+				ThrottleMax:              1,
+				ThrottleDuration:         time.Minute,
+				AccessLog:                true,
+				AccessLogRedactAddresses: true,
+			},
+		},
+	}
+	handler := httpServ.setupMux()
+
+	req, err := http.NewRequest(http.MethodPost, "/api/status?skyaddr=2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = "1.2.3.4:1234"
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, "HTTP Request", entry.Message)
+	require.Equal(t, "/api/status", entry.Data["path"])
+	require.Equal(t, "skyaddr=%3Credacted%3E", entry.Data["query"])
+	require.Equal(t, "1.2.3.4", entry.Data["remoteIP"])
+	require.Equal(t, http.StatusMethodNotAllowed, entry.Data["status"])
+
+	// Throttled requests are logged
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+
+	entry = hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, "HTTP Request", entry.Message)
+	require.Equal(t, http.StatusTooManyRequests, entry.Data["status"])
+}
+
+func TestMaxRequestBodyBytes(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	httpServ := &HTTPServer{
+		log: log,
+		cfg: config.Config{
+			Web: config.Web{
+				MaxRequestBodyBytes: 16,
+			},
+		},
+	}
+	handler := httpServ.setupMux()
+
+	body := `{"skyaddr":"2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW","coin_type":"BTC"}`
+
+	// Rejected by Content-Length
+	req, err := http.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	// Rejected while reading a body of unknown length
+	req, err = http.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = -1
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+func TestStatusLongPollHandler(t *testing.T) {
+	skyAddr := "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+
+	oldStatuses := []exchange.DepositStatus{
+		{
+			Seq:       1,
+			UpdatedAt: 1000,
+			Status:    exchange.StatusWaitDeposit.String(),
+			CoinType:  "BTC",
+		},
+	}
+	newStatuses := []exchange.DepositStatus{
+		{
+			Seq:       1,
+			UpdatedAt: 1010,
+			Status:    exchange.StatusWaitSend.String(),
+			CoinType:  "BTC",
+		},
+	}
+
+	setup := func(t *testing.T, timeout time.Duration) (*fakeExchanger, *exchange.StatusFeed, http.Handler) {
+		feed := exchange.NewStatusFeed()
+		e := &fakeExchanger{}
+		events, unsubscribe := feed.Subscribe()
+		e.On("Subscribe").Return(events, unsubscribe)
+
+		log, _ := testutil.NewLogger(t)
+		httpServ := &HTTPServer{
+			log: log,
+			cfg: config.Config{
+				Web: config.Web{
+					LongPollTimeout: timeout,
+				},
+			},
+			service: &Service{
+				exchanger: e,
+			},
+			exchanger: e,
+			quit:      make(chan struct{}),
+		}
+
+		return e, feed, httpServ.setupMux()
+	}
+
+	get := func(t *testing.T, handler http.Handler, query string) (*httptest.ResponseRecorder, StatusResponse) {
+		req, err := http.NewRequest(http.MethodGet, "/api/status/longpoll?"+query, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		var rsp StatusResponse
+		if rr.Code == http.StatusOK {
+			err := json.Unmarshal(rr.Body.Bytes(), &rsp)
+			require.NoError(t, err)
+		}
+		return rr, rsp
+	}
+
+	t.Run("invalid args", func(t *testing.T) {
+		_, _, handler := setup(t, time.Second)
+
+		rr, _ := get(t, handler, "")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		rr, _ = get(t, handler, "skyaddr="+skyAddr+"&since=foo")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("updated since", func(t *testing.T) {
+		e, _, handler := setup(t, time.Minute)
+		e.On("GetDepositStatuses", skyAddr).Return(oldStatuses, nil)
+
+		rr, rsp := get(t, handler, "skyaddr="+skyAddr+"&since=999")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, oldStatuses, rsp.Statuses)
+	})
+
+	t.Run("status change", func(t *testing.T) {
+		e, feed, handler := setup(t, time.Minute)
+		e.On("GetDepositStatuses", skyAddr).Return(oldStatuses, nil).Once()
+		e.On("GetDepositStatuses", skyAddr).Return(newStatuses, nil).Once()
+
+		go func() {
+			// Changes of other addresses don't end the wait
+			feed.Publish(exchange.StatusEvent{SkyAddress: "other"})
+			feed.Publish(exchange.StatusEvent{SkyAddress: skyAddr})
+		}()
+
+		rr, rsp := get(t, handler, "skyaddr="+skyAddr+"&since=1000")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, newStatuses, rsp.Statuses)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		e, _, handler := setup(t, time.Millisecond*10)
+		e.On("GetDepositStatuses", skyAddr).Return(oldStatuses, nil)
+
+		rr, rsp := get(t, handler, "skyaddr="+skyAddr+"&since=1000")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, oldStatuses, rsp.Statuses)
+	})
+}
+
+func TestBulkStatusHandler(t *testing.T) {
+	skyAddr1 := "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+	skyAddr2 := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+
+	statuses := map[string][]exchange.DepositStatus{
+		skyAddr1: {
+			{
+				Seq:       0,
+				UpdatedAt: 1000,
+				Status:    exchange.StatusWaitSend.String(),
+				CoinType:  "BTC",
+			},
+		},
+		skyAddr2: {},
+	}
+
+	e := &fakeExchanger{}
+	e.On("GetDepositStatusesOfSkyAddresses", []string{skyAddr1, skyAddr2}).Return(statuses, nil)
+
+	log, _ := testutil.NewLogger(t)
+	httpServ := &HTTPServer{
+		log: log,
+		cfg: config.Config{
+			Web: config.Web{
+				MaxBulkStatusAddrs:  2,
+				MaxRequestBodyBytes: 1024,
+			},
+		},
+		service: &Service{
+			exchanger: e,
+		},
+		exchanger: e,
+	}
+	handler := httpServ.setupMux()
+
+	post := func(t *testing.T, method, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/api/status/bulk", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{name: "get", method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{name: "invalid json", method: http.MethodPost, body: "{", status: http.StatusBadRequest},
+		{name: "no addresses", method: http.MethodPost, body: `{"skyaddrs":[]}`, status: http.StatusBadRequest},
+		{name: "too many addresses", method: http.MethodPost, body: fmt.Sprintf(`{"skyaddrs":["%s","%s","%s"]}`, skyAddr1, skyAddr2, skyAddr1), status: http.StatusBadRequest},
+		{name: "invalid address", method: http.MethodPost, body: fmt.Sprintf(`{"skyaddrs":["%s","foo"]}`, skyAddr1), status: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := post(t, tc.method, tc.body)
+			require.Equal(t, tc.status, rr.Code)
+		})
+	}
+
+	t.Run("200", func(t *testing.T) {
+		rr := post(t, http.MethodPost, fmt.Sprintf(`{"skyaddrs":[" %s ","%s"]}`, skyAddr1, skyAddr2))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var rsp BulkStatusResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &rsp)
+		require.NoError(t, err)
+		require.Equal(t, statuses, rsp.Statuses)
+	})
+}
+
+func TestAPIEnvelope(t *testing.T) {
+	signer, err := NewReceiptSigner(testReceiptKey)
+	require.NoError(t, err)
+
+	log, _ := testutil.NewLogger(t)
+
+	httpServ := &HTTPServer{
+		log: log,
+		cfg: config.Config{
+			Web: config.Web{
+				APIEnvelope: true,
+			},
+		},
+		service: &Service{
+			receiptSigner: signer,
+		},
+	}
+	handler := httpServ.setupMux()
+
+	// Success responses are wrapped in data
+	req, err := http.NewRequest(http.MethodGet, "/api/receipt-key", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("X-Teller-API-Version"))
+
+	var rsp struct {
+		APIVersion int                 `json:"api_version"`
+		Data       *ReceiptKeyResponse `json:"data"`
+		Error      *APIError           `json:"error"`
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+	require.NoError(t, err)
+	require.Equal(t, apiVersion, rsp.APIVersion)
+	require.Nil(t, rsp.Error)
+	require.NotNil(t, rsp.Data)
+	require.Equal(t, hex.EncodeToString(signer.PublicKey()), rsp.Data.PublicKey)
+
+	// Error responses have a machine-readable code
+	req, err = http.NewRequest(http.MethodPost, "/api/receipt-key", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var errRsp APIResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &errRsp)
+	require.NoError(t, err)
+	require.Equal(t, APIResponse{
+		APIVersion: apiVersion,
+		Error: &APIError{
+			Code:    "method_not_allowed",
+			Message: "Invalid request method",
+		},
+	}, errRsp)
+
+	// Without the envelope, the version header is still set and errors are plain text
+	httpServ.cfg.Web.APIEnvelope = false
+	rr = httptest.NewRecorder()
+	httpServ.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("X-Teller-API-Version"))
+	require.Equal(t, "Invalid request method", strings.TrimSpace(rr.Body.String()))
+}
+
+func TestErrorStatus(t *testing.T) {
+	tt := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{InvalidSkyAddressError{errors.New("Invalid base58 character")}, http.StatusBadRequest, "invalid_sky_address"},
+		{ErrBindDisabled, http.StatusForbidden, "sale_not_started"},
+		{ErrAddressNotAllowed, http.StatusForbidden, "address_not_allowed"},
+		{ErrMaxBoundAddresses, http.StatusConflict, "already_bound"},
+		{ErrAddressProofRequired, http.StatusUnauthorized, "address_proof_required"},
+		{ErrChallengeNotFound, http.StatusUnauthorized, "invalid_address_proof"},
+		{ErrChallengeExpired, http.StatusUnauthorized, "invalid_address_proof"},
+		{ErrInvalidAddressProof, http.StatusUnauthorized, "invalid_address_proof"},
+		{addrs.ErrDepositAddressEmpty, http.StatusInternalServerError, "deposit_address_unavailable"},
+		{exchange.ErrFrozen, http.StatusServiceUnavailable, "frozen"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.code, func(t *testing.T) {
+			status, code, ok := errorStatus(tc.err)
+			require.True(t, ok)
+			require.Equal(t, tc.status, status)
+			require.Equal(t, tc.code, code)
+		})
+	}
+
+	_, _, ok := errorStatus(errors.New("unknown"))
+	require.False(t, ok)
+}
+
+func TestBindHandlerErrorCodes(t *testing.T) {
+	skyAddr := "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+
+	allowlist, err := NewAllowlist([]string{skyAddr})
+	require.NoError(t, err)
+
+	tt := []struct {
+		name        string
+		skyAddr     string
+		bindEnabled bool
+		frozen      bool
+		status      int
+		code        string
+	}{
+		{"invalid sky address", "foo", true, false, http.StatusBadRequest, "invalid_sky_address"},
+		{"sale not started", skyAddr, false, false, http.StatusForbidden, "sale_not_started"},
+		{"address not allowed", testSkyAddr("denied"), true, false, http.StatusForbidden, "address_not_allowed"},
+		{"already bound", skyAddr, true, false, http.StatusConflict, "already_bound"},
+		{"frozen", skyAddr, true, true, http.StatusServiceUnavailable, "frozen"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &fakeExchanger{
+				frozen: tc.frozen,
+			}
+			e.On("GetBindNum", skyAddr).Return(1, nil)
+
+			log, _ := testutil.NewLogger(t)
+			httpServ := &HTTPServer{
+				log: log,
+				cfg: config.Config{
+					BtcRPC: config.BtcRPC{
+						Enabled: true,
+					},
+					Web: config.Web{
+						APIEnvelope:         true,
+						MaxRequestBodyBytes: 1024,
+					},
+				},
+				service: &Service{
+					cfg: config.Teller{
+						BindEnabled:       tc.bindEnabled,
+						MaxBoundAddresses: 1,
+					},
+					exchanger: e,
+					allowlist: allowlist,
+				},
+			}
+
+			body := fmt.Sprintf(`{"skyaddr":"%s","coin_type":"BTC"}`, tc.skyAddr)
+			req, err := http.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			httpServ.setupMux().ServeHTTP(rr, req)
+			require.Equal(t, tc.status, rr.Code)
+
+			var rsp APIResponse
+			err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+			require.NoError(t, err)
+			require.NotNil(t, rsp.Error)
+			require.Equal(t, tc.code, rsp.Error.Code)
+		})
+	}
+}
+
+func TestBindRequestValidation(t *testing.T) {
+	skyAddr := testSkyAddr("bind")
+
+	_, err := cipher.DecodeBase58Address("foo")
+	require.Error(t, err)
+	invalidSkyAddr := InvalidSkyAddressError{err}.Error()
+
+	tt := []struct {
+		name   string
+		body   string
+		code   string
+		fields []FieldError
+	}{
+		{
+			name: "malformed json",
+			body: `{"skyaddr":`,
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "body", Code: "malformed_json", Message: "Invalid json request body: unexpected EOF"},
+			},
+		},
+		{
+			name: "missing skyaddr",
+			body: `{"coin_type":"BTC"}`,
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "skyaddr", Code: "missing_field", Message: "Missing skyaddr"},
+			},
+		},
+		{
+			name: "blank skyaddr",
+			body: `{"skyaddr":" \n","coin_type":"BTC"}`,
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "skyaddr", Code: "missing_field", Message: "Missing skyaddr"},
+			},
+		},
+		{
+			name: "invalid skyaddr",
+			body: `{"skyaddr":"foo","coin_type":"BTC"}`,
+			code: "invalid_sky_address",
+			fields: []FieldError{
+				{Field: "skyaddr", Code: "invalid_sky_address", Message: invalidSkyAddr},
+			},
+		},
+		{
+			name: "skyaddr not a string",
+			body: `{"skyaddr":1,"coin_type":"BTC"}`,
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "skyaddr", Code: "invalid_type", Message: "Invalid skyaddr: must be a string"},
+			},
+		},
+		{
+			name: "missing coin_type",
+			body: fmt.Sprintf(`{"skyaddr":"%s"}`, skyAddr),
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "coin_type", Code: "missing_field", Message: "Missing coin_type"},
+			},
+		},
+		{
+			name: "unsupported coin_type",
+			body: fmt.Sprintf(`{"skyaddr":"%s","coin_type":"DOGE"}`, skyAddr),
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "coin_type", Code: "unsupported_coin_type", Message: "Invalid coin_type"},
+			},
+		},
+		{
+			name: "disabled coin_type",
+			body: fmt.Sprintf(`{"skyaddr":"%s","coin_type":"ETH"}`, skyAddr),
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "coin_type", Code: "coin_type_disabled", Message: "ETH not enabled"},
+			},
+		},
+		{
+			name: "every field invalid",
+			body: `{"skyaddr":"foo","coin_type":"DOGE"}`,
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "skyaddr", Code: "invalid_sky_address", Message: invalidSkyAddr},
+				{Field: "coin_type", Code: "unsupported_coin_type", Message: "Invalid coin_type"},
+			},
+		},
+		{
+			name: "every field missing",
+			body: `{}`,
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "skyaddr", Code: "missing_field", Message: "Missing skyaddr"},
+				{Field: "coin_type", Code: "missing_field", Message: "Missing coin_type"},
+			},
+		},
+		{
+			name: "wrong type and missing field",
+			body: `{"skyaddr":["foo"]}`,
+			code: "bad_request",
+			fields: []FieldError{
+				{Field: "skyaddr", Code: "invalid_type", Message: "Invalid skyaddr: must be a string"},
+				{Field: "coin_type", Code: "missing_field", Message: "Missing coin_type"},
+			},
+		},
+		{
+			name: "valid",
+			body: fmt.Sprintf(`{"skyaddr":" %s\t","coin_type":"BTC"}`, skyAddr),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			addrManager := addrs.NewAddrManager()
+			err := addrManager.PushGenerator(&fakeAddrGenerator{
+				addrs: []string{"b1"},
+			}, scanner.CoinTypeBTC)
+			require.NoError(t, err)
+
+			e := &fakeExchanger{}
+			e.On("BindAddress", skyAddr, "b1", scanner.CoinTypeBTC).Return(&exchange.BoundAddress{
+				Address:   "b1",
+				CoinType:  scanner.CoinTypeBTC,
+				BuyMethod: config.BuyMethodDirect,
+			}, nil)
+
+			log, _ := testutil.NewLogger(t)
+			httpServ := &HTTPServer{
+				log: log,
+				cfg: config.Config{
+					BtcRPC: config.BtcRPC{
+						Enabled: true,
+					},
+					Web: config.Web{
+						APIEnvelope:         true,
+						MaxRequestBodyBytes: 1024,
+					},
+				},
+				service: &Service{
+					cfg: config.Teller{
+						BindEnabled: true,
+					},
+					exchanger:   e,
+					addrManager: addrManager,
+				},
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(tc.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			httpServ.setupMux().ServeHTTP(rr, req)
+
+			if tc.fields == nil {
+				require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+				var rsp struct {
+					Data BindResponse `json:"data"`
+				}
+				err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+				require.NoError(t, err)
+				require.Equal(t, "b1", rsp.Data.DepositAddress)
+				e.AssertExpectations(t)
+				return
+			}
+
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+
+			var rsp APIResponse
+			err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+			require.NoError(t, err)
+			require.NotNil(t, rsp.Error)
+			require.Equal(t, tc.code, rsp.Error.Code)
+			require.Equal(t, tc.fields, rsp.Error.Fields)
+			e.AssertNotCalled(t, "BindAddress", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestRequestValidationResponses(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	httpServ := &HTTPServer{
+		log: log,
+		cfg: config.Config{
+			Web: config.Web{
+				APIEnvelope:         true,
+				MaxRequestBodyBytes: 1024,
+			},
+		},
+		service: &Service{},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/api/bind-challenge", strings.NewReader(`{"skyaddr":false}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	httpServ.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	var rsp APIResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &rsp)
+	require.NoError(t, err)
+	require.NotNil(t, rsp.Error)
+	require.Equal(t, []FieldError{
+		{Field: "skyaddr", Code: "invalid_type", Message: "Invalid skyaddr: must be a string"},
+	}, rsp.Error.Fields)
+
+	// Without the envelope, every invalid field is listed in the plain text error
+	httpServ.cfg.Web.APIEnvelope = false
+	req, err = http.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	rr = httptest.NewRecorder()
+	httpServ.setupMux().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Equal(t, "Missing skyaddr; Missing coin_type", strings.TrimSpace(rr.Body.String()))
+}
+
+func TestCORS(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	newServer := func(web config.Web) http.Handler {
+		web.ThrottleMax = 1
+		web.ThrottleDuration = time.Hour
+		web.MaxRequestBodyBytes = 1024
+		httpServ := &HTTPServer{
+			log: log,
+			cfg: config.Config{
+				Web: web,
+			},
+			service: &Service{},
+		}
+		return httpServ.setupMux()
+	}
+
+	request := func(h http.Handler, method, path, origin string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// No CORS headers are sent by default
+	h := newServer(config.Web{})
+	rr := request(h, http.MethodGet, "/api/version", "https://status.example.com")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+
+	h = newServer(config.Web{
+		CORSAllowedOrigins:   []string{"https://status.example.com"},
+		CORSAllowCredentials: true,
+	})
+
+	rr = request(h, http.MethodGet, "/api/version", "https://status.example.com")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "https://status.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Other origins are not allowed
+	rr = request(h, http.MethodGet, "/api/version", "https://evil.example.com")
+	require.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+
+	// Preflight requests are answered without reaching the handler, and are not throttled by
+	// the limit of 1 request
+	for i := 0; i < 3; i++ {
+		rr = request(h, http.MethodOptions, "/api/status", "https://status.example.com")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "https://status.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+		require.Equal(t, http.MethodGet, rr.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestHTTPServerCutsOffSlowHeaders(t *testing.T) {
+	srv := setupHTTPListener("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), config.Web{
+		ReadTimeout:       time.Second * 5,
+		ReadHeaderTimeout: time.Millisecond * 100,
+		WriteTimeout:      time.Second * 5,
+		IdleTimeout:       time.Second * 5,
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l) // nolint: errcheck
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Send part of the headers, then stall
+	_, err = conn.Write([]byte("GET /api/version HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = conn.SetReadDeadline(start.Add(time.Second * 3))
+	require.NoError(t, err)
+
+	// The server closes the connection once web.read_header_timeout passes,
+	// well before the read timeout
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) < time.Second*3)
+}
+
+func TestStatusURLs(t *testing.T) {
+	skyAddr := "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+
+	tt := []struct {
+		name      string
+		baseURL   string
+		statusURL string
+		eventsURL string
+	}{
+		{"unset", "", "", ""},
+		{
+			"host",
+			"https://example.com",
+			"https://example.com/api/status?skyaddr=" + skyAddr,
+			"https://example.com/api/status/longpoll?skyaddr=" + skyAddr,
+		},
+		{
+			"path prefix",
+			"https://example.com/teller/",
+			"https://example.com/teller/api/status?skyaddr=" + skyAddr,
+			"https://example.com/teller/api/status/longpoll?skyaddr=" + skyAddr,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			statusURL, eventsURL, err := statusURLs(tc.baseURL, skyAddr)
+			require.NoError(t, err)
+			require.Equal(t, tc.statusURL, statusURL)
+			require.Equal(t, tc.eventsURL, eventsURL)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package teller
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
+
+	"github.com/skycoin/teller/src/exchange"
+)
+
+const (
+	// receiptJWTHeader is the JOSE header of receipts encoded as a JWT
+	receiptJWTHeader = `{"alg":"EdDSA","typ":"JWT"}`
+)
+
+var (
+	// ErrReceiptsDisabled is returned if no receipt signing key is configured
+	ErrReceiptsDisabled = errors.New("Receipts are disabled")
+	// ErrReceiptNotAvailable is returned if a receipt is requested for a deposit that has not completed
+	ErrReceiptNotAvailable = errors.New("Receipts are only available for completed deposits")
+	// ErrInvalidReceiptSignature is returned if a receipt's signature does not verify
+	ErrInvalidReceiptSignature = errors.New("Invalid receipt signature")
+	// ErrInvalidReceiptJWT is returned if a receipt JWT is malformed
+	ErrInvalidReceiptJWT = errors.New("Invalid receipt JWT")
+)
+
+// Receipt records the details of a completed deposit
+type Receipt struct {
+	DepositID      string `json:"deposit_id"`
+	CoinType       string `json:"coin_type"`
+	DepositAddress string `json:"deposit_address"`
+	DepositTxid    string `json:"deposit_txid"`
+	DepositValue   int64  `json:"deposit_value"` // measured in the smallest unit of the coin type (e.g. satoshis)
+	ConversionRate string `json:"conversion_rate"`
+	SkyAddress     string `json:"skycoin_address"`
+	SkySent        string `json:"sky_sent"`
+	SkyTxid        string `json:"skycoin_txid"`
+	CompletedAt    int64  `json:"completed_at"`
+	IssuedAt       int64  `json:"issued_at"`
+	// Precise decimal of the rate applied by the send, empty if unknown
+	AppliedRate string `json:"applied_rate,omitempty"`
+	// Height of the block the deposit was first observed in, 0 if the deposit was simulated
+	DepositBlockHeight int64 `json:"deposit_block_height,omitempty"`
+}
+
+// SignedReceipt is a Receipt with an Ed25519 signature of its JSON encoding
+type SignedReceipt struct {
+	Receipt   Receipt `json:"receipt"`
+	Signature string  `json:"signature"` // hex encoded
+}
+
+// NewReceipt creates a Receipt from a completed deposit
+func NewReceipt(di exchange.DepositInfo) (Receipt, error) {
+	if di.Status != exchange.StatusDone {
+		return Receipt{}, ErrReceiptNotAvailable
+	}
+
+	skySent, err := droplet.ToString(di.SkySent)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	return Receipt{
+		DepositID:      di.DepositID,
+		CoinType:       di.CoinType,
+		DepositAddress: di.DepositAddress,
+		DepositTxid:    di.Deposit.Tx,
+		DepositValue:   di.DepositValue,
+		ConversionRate: di.ConversionRate,
+		SkyAddress:     di.SkyAddress,
+		SkySent:        skySent,
+		SkyTxid:        di.Txid,
+		CompletedAt:    di.UpdatedAt,
+		IssuedAt:       time.Now().UTC().Unix(),
+		AppliedRate:    di.AppliedRate,
+
+		DepositBlockHeight: di.Deposit.Height,
+	}, nil
+}
+
+// ReceiptSigner signs receipts with an Ed25519 key
+type ReceiptSigner struct {
+	key ed25519.PrivateKey
+}
+
+// NewReceiptSigner creates a ReceiptSigner from a hex encoded Ed25519 seed
+func NewReceiptSigner(seedHex string) (*ReceiptSigner, error) {
+	seed, err := hex.DecodeString(seedHex)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid receipt key: %v", err)
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("Invalid receipt key: must be %d bytes", ed25519.SeedSize)
+	}
+
+	return &ReceiptSigner{
+		key: ed25519.NewKeyFromSeed(seed),
+	}, nil
+}
+
+// PublicKey returns the public key that verifies signed receipts
+func (s *ReceiptSigner) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Sign signs the JSON encoding of a Receipt
+func (s *ReceiptSigner) Sign(r Receipt) (*SignedReceipt, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedReceipt{
+		Receipt:   r,
+		Signature: hex.EncodeToString(ed25519.Sign(s.key, b)),
+	}, nil
+}
+
+// SignJWT encodes a Receipt as a JWT signed with EdDSA
+func (s *ReceiptSigner) SignJWT(r Receipt) (string, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	input := enc.EncodeToString([]byte(receiptJWTHeader)) + "." + enc.EncodeToString(b)
+	sig := ed25519.Sign(s.key, []byte(input))
+
+	return input + "." + enc.EncodeToString(sig), nil
+}
+
+// VerifyReceipt verifies the signature of a SignedReceipt
+func VerifyReceipt(pubKey ed25519.PublicKey, sr SignedReceipt) error {
+	sig, err := hex.DecodeString(sr.Signature)
+	if err != nil {
+		return ErrInvalidReceiptSignature
+	}
+
+	b, err := json.Marshal(sr.Receipt)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pubKey, b, sig) {
+		return ErrInvalidReceiptSignature
+	}
+
+	return nil
+}
+
+// VerifyReceiptJWT verifies a receipt JWT and returns its Receipt
+func VerifyReceiptJWT(pubKey ed25519.PublicKey, token string) (Receipt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Receipt{}, ErrInvalidReceiptJWT
+	}
+
+	enc := base64.RawURLEncoding
+
+	header, err := enc.DecodeString(parts[0])
+	if err != nil || !bytes.Equal(header, []byte(receiptJWTHeader)) {
+		return Receipt{}, ErrInvalidReceiptJWT
+	}
+
+	payload, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return Receipt{}, ErrInvalidReceiptJWT
+	}
+
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return Receipt{}, ErrInvalidReceiptJWT
+	}
+
+	if !ed25519.Verify(pubKey, []byte(parts[0]+"."+parts[1]), sig) {
+		return Receipt{}, ErrInvalidReceiptSignature
+	}
+
+	var r Receipt
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return Receipt{}, ErrInvalidReceiptJWT
+	}
+
+	return r, nil
+}
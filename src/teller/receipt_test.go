@@ -0,0 +1,140 @@
+package teller
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+const testReceiptKey = "5b3c8b1e7d2f4a6c9e0b1d3f5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a3c"
+
+func testCompletedDeposit() exchange.DepositInfo {
+	return exchange.DepositInfo{
+		Seq:            1,
+		UpdatedAt:      1520000000,
+		Status:         exchange.StatusDone,
+		CoinType:       scanner.CoinTypeBTC,
+		SkyAddress:     "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW",
+		DepositAddress: "1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB",
+		DepositID:      "edb29a9b561a8d6a6118eb1f724c87f853bf471d7e4f0e9ccb9e1d340235687b:0",
+		Txid:           "4fc9743b04c2e3f5e467cde38c0872e3e3ad9ec05d59081ad1a8bd88045635de",
+		ConversionRate: "500",
+		AppliedRate:    "500",
+		DepositValue:   1e8,
+		SkySent:        500e6,
+		Deposit: scanner.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  "1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB",
+			Value:    1e8,
+			Height:   494713,
+			Tx:       "edb29a9b561a8d6a6118eb1f724c87f853bf471d7e4f0e9ccb9e1d340235687b",
+			N:        0,
+		},
+	}
+}
+
+func TestNewReceiptSigner(t *testing.T) {
+	_, err := NewReceiptSigner("zz")
+	require.Error(t, err)
+
+	_, err = NewReceiptSigner("abcd")
+	require.Error(t, err)
+
+	s, err := NewReceiptSigner(testReceiptKey)
+	require.NoError(t, err)
+	require.Len(t, s.PublicKey(), ed25519.PublicKeySize)
+}
+
+func TestNewReceipt(t *testing.T) {
+	di := testCompletedDeposit()
+
+	r, err := NewReceipt(di)
+	require.NoError(t, err)
+	require.NotEmpty(t, r.IssuedAt)
+	require.Equal(t, Receipt{
+		DepositID:      di.DepositID,
+		CoinType:       scanner.CoinTypeBTC,
+		DepositAddress: di.DepositAddress,
+		DepositTxid:    di.Deposit.Tx,
+		DepositValue:   1e8,
+		ConversionRate: "500",
+		SkyAddress:     di.SkyAddress,
+		SkySent:        "500.000000",
+		SkyTxid:        di.Txid,
+		CompletedAt:    di.UpdatedAt,
+		IssuedAt:       r.IssuedAt,
+		AppliedRate:    "500",
+
+		DepositBlockHeight: 494713,
+	}, r)
+
+	di.Status = exchange.StatusWaitConfirm
+	_, err = NewReceipt(di)
+	require.Equal(t, ErrReceiptNotAvailable, err)
+}
+
+func TestReceiptSignVerify(t *testing.T) {
+	s, err := NewReceiptSigner(testReceiptKey)
+	require.NoError(t, err)
+
+	r, err := NewReceipt(testCompletedDeposit())
+	require.NoError(t, err)
+
+	sr, err := s.Sign(r)
+	require.NoError(t, err)
+	require.NoError(t, VerifyReceipt(s.PublicKey(), *sr))
+
+	// Tampered receipt
+	tampered := *sr
+	tampered.Receipt.SkySent = "5000.000000"
+	require.Equal(t, ErrInvalidReceiptSignature, VerifyReceipt(s.PublicKey(), tampered))
+
+	// Malformed signature
+	tampered = *sr
+	tampered.Signature = "zz"
+	require.Equal(t, ErrInvalidReceiptSignature, VerifyReceipt(s.PublicKey(), tampered))
+
+	// Wrong key
+	other, err := NewReceiptSigner(strings.Repeat("01", ed25519.SeedSize))
+	require.NoError(t, err)
+	require.Equal(t, ErrInvalidReceiptSignature, VerifyReceipt(other.PublicKey(), *sr))
+}
+
+func TestReceiptSignVerifyJWT(t *testing.T) {
+	s, err := NewReceiptSigner(testReceiptKey)
+	require.NoError(t, err)
+
+	r, err := NewReceipt(testCompletedDeposit())
+	require.NoError(t, err)
+
+	token, err := s.SignJWT(r)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(token, "."), 3)
+
+	verified, err := VerifyReceiptJWT(s.PublicKey(), token)
+	require.NoError(t, err)
+	require.Equal(t, r, verified)
+
+	other, err := NewReceiptSigner(strings.Repeat("01", ed25519.SeedSize))
+	require.NoError(t, err)
+	_, err = VerifyReceiptJWT(other.PublicKey(), token)
+	require.Equal(t, ErrInvalidReceiptSignature, err)
+
+	otherToken, err := other.SignJWT(r)
+	require.NoError(t, err)
+	parts := strings.Split(token, ".")
+	otherParts := strings.Split(otherToken, ".")
+	_, err = VerifyReceiptJWT(s.PublicKey(), parts[0]+"."+parts[1]+"."+otherParts[2])
+	require.Equal(t, ErrInvalidReceiptSignature, err)
+
+	_, err = VerifyReceiptJWT(s.PublicKey(), "foo.bar")
+	require.Equal(t, ErrInvalidReceiptJWT, err)
+
+	_, err = VerifyReceiptJWT(s.PublicKey(), "foo."+parts[1]+"."+parts[2])
+	require.Equal(t, ErrInvalidReceiptJWT, err)
+}
@@ -0,0 +1,135 @@
+package teller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+// FieldError is a problem with one field of a request
+type FieldError struct {
+	// Field is the JSON name of the field, or "body" if the request body is not valid JSON
+	Field string `json:"field"`
+	// Code is a stable, machine-readable error code
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request. It is returned with 400 Bad Request
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// code returns the error code of the APIError. A request whose only problem is its skycoin address
+// keeps the invalid_sky_address code of InvalidSkyAddressError
+func (e ValidationError) code() string {
+	if len(e.Fields) == 1 && e.Fields[0].Code == "invalid_sky_address" {
+		return e.Fields[0].Code
+	}
+	return errorCode(http.StatusBadRequest)
+}
+
+// add adds an error for field. Only the first error of a field is kept
+func (e *ValidationError) add(field, code, message string) {
+	if e.has(field) {
+		return
+	}
+
+	e.Fields = append(e.Fields, FieldError{
+		Field:   field,
+		Code:    code,
+		Message: message,
+	})
+}
+
+func (e *ValidationError) has(field string) bool {
+	for _, f := range e.Fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// requestValidator is a request body that validates its own fields
+type requestValidator interface {
+	// validate adds the request's invalid fields to verr, and normalizes the valid ones
+	validate(s *HTTPServer, verr *ValidationError)
+}
+
+// decodeRequest decodes a JSON request body into req and validates it, returning every invalid field at once
+// in a ValidationError. A field of the wrong JSON type is reported as invalid and the other fields are still
+// validated; a body that is not valid JSON is reported alone. Returns *http.MaxBytesError if the body is too large
+func decodeRequest(r *http.Request, s *HTTPServer, req requestValidator) error {
+	verr := &ValidationError{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		switch e := err.(type) {
+		case *http.MaxBytesError:
+			return err
+		case *json.UnmarshalTypeError:
+			verr.add(e.Field, "invalid_type", fmt.Sprintf("Invalid %s: must be a %s", e.Field, e.Type.Kind()))
+		default:
+			verr.add("body", "malformed_json", fmt.Sprintf("Invalid json request body: %v", err))
+			return *verr
+		}
+	}
+
+	req.validate(s, verr)
+
+	if len(verr.Fields) != 0 {
+		return *verr
+	}
+
+	return nil
+}
+
+// validateSkyAddr checks that a skycoin address is valid, and replaces it with its canonical form
+func validateSkyAddr(skyAddr *string, verr *ValidationError) {
+	if trimSkyAddress(*skyAddr) == "" {
+		verr.add("skyaddr", "missing_field", "Missing skyaddr")
+		return
+	}
+
+	canonical, err := canonicalSkyAddress(*skyAddr)
+	if err != nil {
+		verr.add("skyaddr", "invalid_sky_address", err.Error())
+		return
+	}
+
+	*skyAddr = canonical
+}
+
+func (req *bindRequest) validate(s *HTTPServer, verr *ValidationError) {
+	validateSkyAddr(&req.SkyAddr, verr)
+
+	switch req.CoinType {
+	case scanner.CoinTypeBTC:
+		if !s.cfg.BtcRPC.Enabled {
+			verr.add("coin_type", "coin_type_disabled", fmt.Sprintf("%s not enabled", scanner.CoinTypeBTC))
+		}
+	case scanner.CoinTypeETH:
+		if !s.cfg.EthRPC.Enabled {
+			verr.add("coin_type", "coin_type_disabled", fmt.Sprintf("%s not enabled", scanner.CoinTypeETH))
+		}
+	case "":
+		verr.add("coin_type", "missing_field", "Missing coin_type")
+	default:
+		verr.add("coin_type", "unsupported_coin_type", "Invalid coin_type")
+	}
+}
+
+func (req *bindChallengeRequest) validate(s *HTTPServer, verr *ValidationError) {
+	validateSkyAddr(&req.SkyAddr, verr)
+}
@@ -0,0 +1,30 @@
+package teller
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// trimSkyAddress removes the whitespace around a skycoin address, including the zero width spaces
+// and byte order marks that copying an address from a web page or document can add
+func trimSkyAddress(skyAddr string) string {
+	return strings.TrimFunc(skyAddr, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '\u200b' || r == '\ufeff'
+	})
+}
+
+// canonicalSkyAddress returns the form of a skycoin address that is bound and looked up: it is trimmed,
+// decoded and encoded again. Every skycoin address accepted from a user is canonicalized, so that the stored
+// addresses and the queried ones match exactly.
+// An invalid address is rejected with an InvalidSkyAddressError, not corrected. In particular base58 is
+// case sensitive, so an address in a different casing is a different, almost always invalid, address
+func canonicalSkyAddress(skyAddr string) (string, error) {
+	addr, err := cipher.DecodeBase58Address(trimSkyAddress(skyAddr))
+	if err != nil {
+		return "", InvalidSkyAddressError{err}
+	}
+
+	return addr.String(), nil
+}
@@ -0,0 +1,134 @@
+package teller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// swapCase returns s with the case of every letter swapped
+func swapCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return r
+		}
+	}, s)
+}
+
+func TestCanonicalSkyAddress(t *testing.T) {
+	skyAddr := testSkyAddr("canonical")
+
+	variants := []struct {
+		name    string
+		skyAddr string
+	}{
+		{"canonical", skyAddr},
+		{"spaces", "  " + skyAddr + " "},
+		{"newlines", "\n" + skyAddr + "\r\n"},
+		{"tabs", "\t" + skyAddr + "\t"},
+		{"no-break space", "\u00a0" + skyAddr},
+		{"zero width space", "\u200b" + skyAddr + "\u200b"},
+		{"byte order mark", "\ufeff" + skyAddr},
+	}
+
+	for _, tc := range variants {
+		t.Run(tc.name, func(t *testing.T) {
+			canonical, err := canonicalSkyAddress(tc.skyAddr)
+			require.NoError(t, err)
+			require.Equal(t, skyAddr, canonical)
+		})
+	}
+
+	invalid := []struct {
+		name    string
+		skyAddr string
+	}{
+		{"empty", ""},
+		{"only whitespace", " \u200b\n"},
+		{"swapped case", swapCase(skyAddr)},
+		{"one letter in another case", swapCase(skyAddr[:1]) + skyAddr[1:]},
+		{"inner space", skyAddr[:10] + " " + skyAddr[10:]},
+		{"truncated", skyAddr[:len(skyAddr)-1]},
+	}
+
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			canonical, err := canonicalSkyAddress(tc.skyAddr)
+			require.Error(t, err)
+			require.IsType(t, InvalidSkyAddressError{}, err)
+			require.Empty(t, canonical)
+		})
+	}
+}
+
+func TestSkyAddressLookupsCanonical(t *testing.T) {
+	skyAddr := testSkyAddr("lookup")
+	padded := "\u200b " + skyAddr + "\n"
+
+	e := &fakeExchanger{}
+	e.On("GetDepositStatuses", skyAddr).Return([]exchange.DepositStatus{}, nil)
+	e.On("GetDepositStatusesOfSkyAddresses", []string{skyAddr}).Return(map[string][]exchange.DepositStatus{
+		skyAddr: {},
+	}, nil)
+
+	log, _ := testutil.NewLogger(t)
+	httpServ := &HTTPServer{
+		log: log,
+		cfg: config.Config{
+			Web: config.Web{
+				MaxBulkStatusAddrs:  1,
+				MaxRequestBodyBytes: 1024,
+			},
+		},
+		service: &Service{
+			exchanger: e,
+		},
+		exchanger: e,
+	}
+	handler := httpServ.setupMux()
+
+	get := func(skyAddr string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, "/api/status?skyaddr="+url.QueryEscape(skyAddr), nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The padded address is looked up in its canonical form
+	rr := get(padded)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	e.AssertCalled(t, "GetDepositStatuses", skyAddr)
+
+	// An address in another casing is rejected, not looked up
+	rr = get(swapCase(skyAddr))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Contains(t, rr.Body.String(), "Invalid skycoin address")
+	e.AssertNumberOfCalls(t, "GetDepositStatuses", 1)
+
+	req, err := http.NewRequest(http.MethodPost, "/api/status/bulk", strings.NewReader(`{"skyaddrs":["\u200b `+skyAddr+`\n"]}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	e.AssertCalled(t, "GetDepositStatusesOfSkyAddresses", []string{skyAddr})
+
+	// The allowlist stores the canonical form of its addresses
+	a, err := NewAllowlist([]string{padded})
+	require.NoError(t, err)
+	require.True(t, a.Allowed(skyAddr))
+}
@@ -1,13 +1,19 @@
 package teller
 
 import (
+	"context"
+	"crypto/ed25519"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/skycoin/teller/src/addrs"
 	"github.com/skycoin/teller/src/config"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/metrics"
+	"github.com/skycoin/teller/src/util/dbutil"
 )
 
 var (
@@ -15,6 +21,8 @@ var (
 	ErrMaxBoundAddresses = errors.New("The maximum number of addresses have been assigned to this SKY address")
 	// ErrBindDisabled is returned if address binding is disabled
 	ErrBindDisabled = errors.New("Address binding is disabled")
+	// ErrDepositNotFound is returned if a deposit does not exist
+	ErrDepositNotFound = errors.New("Deposit not found")
 )
 
 // Teller provides the HTTP and teller service
@@ -27,18 +35,56 @@ type Teller struct {
 }
 
 // New creates a Teller
-func New(log logrus.FieldLogger, exchanger exchange.Exchanger, addrManager *addrs.AddrManager, cfg config.Config) *Teller {
+func New(log logrus.FieldLogger, exchanger exchange.Exchanger, addrManager *addrs.AddrManager, cfg config.Config) (*Teller, error) {
+	for st := range cfg.Teller.StatusMessages {
+		if !exchange.ValidStatusStr(st) {
+			return nil, fmt.Errorf("teller.status_messages.%s is not a deposit status", st)
+		}
+	}
+
+	var receiptSigner *ReceiptSigner
+	if cfg.Teller.ReceiptKey != "" {
+		var err error
+		receiptSigner, err = NewReceiptSigner(cfg.Teller.ReceiptKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var allowlist *Allowlist
+	if cfg.Teller.AllowlistFile != "" {
+		var err error
+		allowlist, err = LoadAllowlist(cfg.Teller.AllowlistFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Web.AutoTLSHost != "" {
+		if err := prepareAutoCertCache(cfg.Web.AutoTLSCacheDir); err != nil {
+			return nil, err
+		}
+	}
+
+	var challengeIssuer *ChallengeIssuer
+	if cfg.Teller.RequireAddressProof {
+		challengeIssuer = NewChallengeIssuer(cfg.Teller.AddressProofTTL)
+	}
+
 	return &Teller{
 		cfg:  cfg.Teller,
 		log:  log.WithField("prefix", "teller"),
 		quit: make(chan struct{}),
 		done: make(chan struct{}),
 		httpServ: NewHTTPServer(log, cfg.Redacted(), &Service{
-			cfg:         cfg.Teller,
-			exchanger:   exchanger,
-			addrManager: addrManager,
+			cfg:             cfg.Teller,
+			exchanger:       exchanger,
+			addrManager:     addrManager,
+			receiptSigner:   receiptSigner,
+			challengeIssuer: challengeIssuer,
+			allowlist:       allowlist,
 		}, exchanger),
-	}
+	}, nil
 }
 
 // Run starts the Teller
@@ -71,20 +117,64 @@ func (s *Teller) Shutdown() {
 	<-s.done
 }
 
+// SetMetrics sets where the HTTP server emits metrics. It must be called before Run
+func (s *Teller) SetMetrics(m metrics.Metrics) {
+	s.httpServ.metrics = m
+}
+
+// ReloadAllowlist rereads the allowlist file, if one is configured.
+// If the file can't be read or is invalid, the current allowlist is kept.
+func (s *Teller) ReloadAllowlist() error {
+	allowlist := s.httpServ.service.allowlist
+	if allowlist == nil {
+		return nil
+	}
+
+	if err := allowlist.Reload(); err != nil {
+		return err
+	}
+
+	s.log.WithField("addresses", allowlist.Len()).Info("Reloaded allowlist")
+	return nil
+}
+
 // Service combines Exchanger and AddrGenerator
 type Service struct {
-	cfg         config.Teller
-	exchanger   exchange.Exchanger // exchange Teller client
-	addrManager *addrs.AddrManager // address manager
+	cfg             config.Teller
+	exchanger       exchange.Exchanger // exchange Teller client
+	addrManager     *addrs.AddrManager // address manager
+	receiptSigner   *ReceiptSigner     // signs deposit receipts, nil if receipts are disabled
+	challengeIssuer *ChallengeIssuer   // issues address proof challenges, nil if address proofs are not required
+	allowlist       *Allowlist         // skycoin addresses allowed to bind, nil if all addresses are allowed
 }
 
 // BindAddress binds skycoin address with a deposit address according to coinType
-// return deposit address
-func (s *Service) BindAddress(skyAddr, coinType string) (*exchange.BoundAddress, error) {
+// return deposit address. If address proofs are required, proof must be a
+// signature by skyAddr of a challenge from IssueChallenge.
+func (s *Service) BindAddress(skyAddr, coinType string, proof *AddressProof) (*exchange.BoundAddress, error) {
 	if !s.cfg.BindEnabled {
 		return nil, ErrBindDisabled
 	}
 
+	if s.allowlist != nil && !s.allowlist.Allowed(skyAddr) {
+		return nil, ErrAddressNotAllowed
+	}
+
+	if s.challengeIssuer != nil {
+		if proof == nil {
+			return nil, ErrAddressProofRequired
+		}
+
+		if err := s.challengeIssuer.Verify(skyAddr, *proof); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check before taking a deposit address, so that none is used up while frozen
+	if s.exchanger.Frozen() {
+		return nil, exchange.ErrFrozen
+	}
+
 	if s.cfg.MaxBoundAddresses > 0 {
 		num, err := s.exchanger.GetBindNum(skyAddr)
 		if err != nil {
@@ -104,7 +194,152 @@ func (s *Service) BindAddress(skyAddr, coinType string) (*exchange.BoundAddress,
 	return s.exchanger.BindAddress(skyAddr, depositAddr, coinType)
 }
 
+// IssueChallenge returns a challenge that skyAddr must sign to prove ownership when binding
+func (s *Service) IssueChallenge(skyAddr string) (Challenge, error) {
+	if s.challengeIssuer == nil {
+		return Challenge{}, ErrAddressProofDisabled
+	}
+
+	return s.challengeIssuer.Issue(skyAddr)
+}
+
 // GetDepositStatuses returns deposit status of given skycoin address
 func (s *Service) GetDepositStatuses(skyAddr string) ([]exchange.DepositStatus, error) {
-	return s.exchanger.GetDepositStatuses(skyAddr)
+	dss, err := s.exchanger.GetDepositStatuses(skyAddr)
+	return s.withStatusMessages(dss), err
+}
+
+// GetDepositStatusesOfSkyAddresses returns the deposit statuses of each of the given skycoin addresses
+func (s *Service) GetDepositStatusesOfSkyAddresses(skyAddrs []string) (map[string][]exchange.DepositStatus, error) {
+	dss, err := s.exchanger.GetDepositStatusesOfSkyAddresses(skyAddrs)
+	for skyAddr := range dss {
+		dss[skyAddr] = s.withStatusMessages(dss[skyAddr])
+	}
+	return dss, err
+}
+
+// DepositHistory returns the status changes of the deposits to a deposit address, oldest first, with their status messages
+func (s *Service) DepositHistory(depositAddr string) ([]exchange.DepositEvent, error) {
+	events, err := s.exchanger.GetDepositHistory(depositAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		events[i].Message = s.statusMessage(events[i].Status)
+	}
+
+	return events, nil
+}
+
+// statusMessage returns the message of a status configured in teller.status_messages, or its default message
+func (s *Service) statusMessage(status string) string {
+	if msg, ok := s.cfg.StatusMessages[status]; ok {
+		return msg
+	}
+	return exchange.NewStatusFromStr(status).Message()
+}
+
+// withStatusMessages replaces the default status messages with those configured in teller.status_messages
+func (s *Service) withStatusMessages(dss []exchange.DepositStatus) []exchange.DepositStatus {
+	for i := range dss {
+		if msg, ok := s.cfg.StatusMessages[dss[i].Status]; ok {
+			dss[i].Message = msg
+		}
+	}
+	return dss
+}
+
+// WaitDepositStatuses returns the deposit statuses of a skycoin address once any of them
+// was updated after since (a unix timestamp), waiting up to timeout for a status change.
+// If no status changes before the timeout or ctx is done, the current statuses are returned.
+func (s *Service) WaitDepositStatuses(ctx context.Context, skyAddr string, since int64, timeout time.Duration) ([]exchange.DepositStatus, error) {
+	dss, err := s.waitDepositStatuses(ctx, skyAddr, since, timeout)
+	return s.withStatusMessages(dss), err
+}
+
+func (s *Service) waitDepositStatuses(ctx context.Context, skyAddr string, since int64, timeout time.Duration) ([]exchange.DepositStatus, error) {
+	// Subscribe before reading the statuses, so that no change is missed in between
+	events, unsubscribe := s.exchanger.Subscribe()
+	defer unsubscribe()
+
+	statuses, err := s.exchanger.GetDepositStatuses(skyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, st := range statuses {
+		if st.UpdatedAt > since {
+			return statuses, nil
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return statuses, nil
+			}
+			// Events are dropped if the subscriber falls behind, so also wake on
+			// dropped events in case one of them was for this address
+			if ev.SkyAddress != skyAddr && ev.Dropped == 0 {
+				continue
+			}
+			return s.exchanger.GetDepositStatuses(skyAddr)
+		case <-timer.C:
+			return statuses, nil
+		case <-ctx.Done():
+			return statuses, nil
+		}
+	}
+}
+
+// Receipt returns a signed receipt for a completed deposit
+func (s *Service) Receipt(depositID string) (*SignedReceipt, error) {
+	r, err := s.receipt(depositID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.receiptSigner.Sign(r)
+}
+
+// ReceiptJWT returns a receipt for a completed deposit, encoded as a signed JWT
+func (s *Service) ReceiptJWT(depositID string) (string, error) {
+	r, err := s.receipt(depositID)
+	if err != nil {
+		return "", err
+	}
+
+	return s.receiptSigner.SignJWT(r)
+}
+
+// ReceiptPublicKey returns the public key that verifies receipts
+func (s *Service) ReceiptPublicKey() (ed25519.PublicKey, error) {
+	if s.receiptSigner == nil {
+		return nil, ErrReceiptsDisabled
+	}
+
+	return s.receiptSigner.PublicKey(), nil
+}
+
+func (s *Service) receipt(depositID string) (Receipt, error) {
+	if s.receiptSigner == nil {
+		return Receipt{}, ErrReceiptsDisabled
+	}
+
+	di, err := s.exchanger.GetDepositInfo(depositID)
+	if err != nil {
+		switch err.(type) {
+		case dbutil.ObjectNotExistErr:
+			return Receipt{}, ErrDepositNotFound
+		default:
+			return Receipt{}, err
+		}
+	}
+
+	return NewReceipt(di)
 }
@@ -0,0 +1,200 @@
+package teller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestServiceBindAddressMaxBoundAddresses(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	btcAddrs, err := addrs.NewAddrs(log, db, []string{"b1", "b2", "b3", "b4", "b5"}, "test_bind_btc")
+	require.NoError(t, err)
+
+	addrManager := addrs.NewAddrManager()
+	err = addrManager.PushGenerator(btcAddrs, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+
+	skyAddr := testSkyAddr("abuser")
+	otherSkyAddr := testSkyAddr("other")
+
+	e := &fakeExchanger{}
+	s := &Service{
+		cfg: config.Teller{
+			BindEnabled:       true,
+			MaxBoundAddresses: 2,
+		},
+		exchanger:   e,
+		addrManager: addrManager,
+	}
+
+	e.On("BindAddress", mock.Anything, mock.Anything, scanner.CoinTypeBTC).Return(&exchange.BoundAddress{
+		CoinType: scanner.CoinTypeBTC,
+	}, nil)
+
+	// bind binds skyAddr, which is already bound to num addresses
+	bind := func(skyAddr string, num int) (*exchange.BoundAddress, error) {
+		e.On("GetBindNum", skyAddr).Return(num, nil).Once()
+		return s.BindAddress(skyAddr, scanner.CoinTypeBTC, nil)
+	}
+
+	// Binds up to the limit
+	for i := 0; i < 2; i++ {
+		_, err := bind(skyAddr, i)
+		require.NoError(t, err)
+	}
+	require.Equal(t, uint64(3), btcAddrs.Remaining())
+
+	// Binds beyond the limit are rejected, without taking a deposit address from the pool
+	for i := 2; i < 4; i++ {
+		_, err := bind(skyAddr, i)
+		require.Equal(t, ErrMaxBoundAddresses, err)
+	}
+	require.Equal(t, uint64(3), btcAddrs.Remaining())
+
+	// Other skycoin addresses can still bind
+	_, err = bind(otherSkyAddr, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), btcAddrs.Remaining())
+
+	// Unlimited if 0
+	s.cfg.MaxBoundAddresses = 0
+	_, err = s.BindAddress(skyAddr, scanner.CoinTypeBTC, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), btcAddrs.Remaining())
+	e.AssertNumberOfCalls(t, "BindAddress", 4)
+}
+
+// fakeAddrGenerator allocates deposit addresses from a fixed list
+type fakeAddrGenerator struct {
+	addrs []string
+}
+
+func (g *fakeAddrGenerator) NewAddress() (string, error) {
+	if len(g.addrs) == 0 {
+		return "", addrs.ErrDepositAddressEmpty
+	}
+
+	addr := g.addrs[0]
+	g.addrs = g.addrs[1:]
+	return addr, nil
+}
+
+func (g *fakeAddrGenerator) Derivation(addr string) *addrs.Derivation {
+	return nil
+}
+
+func TestServiceBindAddressAllocates(t *testing.T) {
+	g := &fakeAddrGenerator{
+		addrs: []string{"b1", "b2"},
+	}
+
+	addrManager := addrs.NewAddrManager()
+	err := addrManager.PushGenerator(g, scanner.CoinTypeBTC)
+	require.NoError(t, err)
+
+	skyAddr := testSkyAddr("allocate")
+
+	e := &fakeExchanger{}
+	s := &Service{
+		cfg: config.Teller{
+			BindEnabled: true,
+		},
+		exchanger:   e,
+		addrManager: addrManager,
+	}
+
+	// Each bind binds the next address allocated from the pool
+	for _, depositAddr := range []string{"b1", "b2"} {
+		e.On("BindAddress", skyAddr, depositAddr, scanner.CoinTypeBTC).Return(&exchange.BoundAddress{
+			SkyAddress: skyAddr,
+			Address:    depositAddr,
+			CoinType:   scanner.CoinTypeBTC,
+		}, nil).Once()
+
+		ba, err := s.BindAddress(skyAddr, scanner.CoinTypeBTC, nil)
+		require.NoError(t, err)
+		require.Equal(t, depositAddr, ba.Address)
+	}
+
+	// Once the pool is exhausted, nothing is bound
+	_, err = s.BindAddress(skyAddr, scanner.CoinTypeBTC, nil)
+	require.Equal(t, addrs.ErrDepositAddressEmpty, err)
+	e.AssertNumberOfCalls(t, "BindAddress", 2)
+
+	// Coin types without a pool are rejected
+	_, err = s.BindAddress(skyAddr, scanner.CoinTypeETH, nil)
+	require.Equal(t, addrs.ErrCoinTypeNotExists, err)
+	e.AssertNumberOfCalls(t, "BindAddress", 2)
+}
+
+func TestServiceStatusMessages(t *testing.T) {
+	skyAddr := testSkyAddr("status")
+
+	statuses := func() []exchange.DepositStatus {
+		return []exchange.DepositStatus{
+			{
+				Seq:     1,
+				Status:  exchange.StatusWaitSend.String(),
+				Message: exchange.StatusWaitSend.Message(),
+			},
+			{
+				Seq:     2,
+				Status:  exchange.StatusDone.String(),
+				Message: exchange.StatusDone.Message(),
+			},
+		}
+	}
+
+	e := &fakeExchanger{}
+	e.On("GetDepositStatuses", skyAddr).Return(statuses(), nil).Once()
+	e.On("GetDepositStatusesOfSkyAddresses", []string{skyAddr}).Return(map[string][]exchange.DepositStatus{
+		skyAddr: statuses(),
+	}, nil).Once()
+
+	s := &Service{
+		cfg: config.Teller{
+			StatusMessages: map[string]string{
+				exchange.StatusDone.String(): "status.done",
+			},
+		},
+		exchanger: e,
+	}
+
+	// Configured messages replace the defaults, other statuses keep theirs
+	expected := statuses()
+	expected[1].Message = "status.done"
+
+	dss, err := s.GetDepositStatuses(skyAddr)
+	require.NoError(t, err)
+	require.Equal(t, expected, dss)
+
+	bulk, err := s.GetDepositStatusesOfSkyAddresses([]string{skyAddr})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]exchange.DepositStatus{
+		skyAddr: expected,
+	}, bulk)
+}
+
+func TestNewInvalidStatusMessages(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	_, err := New(log, &fakeExchanger{}, nil, config.Config{
+		Teller: config.Teller{
+			StatusMessages: map[string]string{
+				"sent": "Your SKY has been sent",
+			},
+		},
+	})
+	require.EqualError(t, err, "teller.status_messages.sent is not a deposit status")
+}
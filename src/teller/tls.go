@@ -0,0 +1,112 @@
+package teller
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/metrics"
+)
+
+// sniCertificates selects the TLS certificate for the hostname a client requests with SNI
+type sniCertificates struct {
+	hosts map[string]*tls.Certificate
+	// fallback is served to clients requesting an unknown hostname, or none
+	fallback *tls.Certificate
+}
+
+// loadSNICertificates loads the fallback certificate and the certificate of each host.
+// Returns an error if any of them fails to load, so that a bad pair is found at startup
+// rather than by the first client requesting its host.
+func loadSNICertificates(fallbackCert, fallbackKey string, hosts []config.TLSHost) (*sniCertificates, error) {
+	fallback, err := tls.LoadX509KeyPair(fallbackCert, fallbackKey)
+	if err != nil {
+		return nil, fmt.Errorf("load web.tls_cert and web.tls_key failed: %v", err)
+	}
+
+	c := &sniCertificates{
+		hosts:    make(map[string]*tls.Certificate, len(hosts)),
+		fallback: &fallback,
+	}
+
+	for _, h := range hosts {
+		cert, err := tls.LoadX509KeyPair(h.Cert, h.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load web.tls_hosts certificate of %s failed: %v", h.Host, err)
+		}
+
+		c.hosts[strings.ToLower(h.Host)] = &cert
+	}
+
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *sniCertificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, ok := c.hosts[strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))]; ok {
+		return cert, nil
+	}
+
+	return c.fallback, nil
+}
+
+// prepareAutoCertCache creates the autocert cache directory if it is missing, and checks that it is writable.
+// autocert only writes to it once it obtains a certificate, during the first TLS handshake,
+// so an unusable directory would otherwise only be found then.
+func prepareAutoCertCache(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create web.auto_tls_cache_dir %s failed: %v", dir, err)
+	}
+
+	f, err := ioutil.TempFile(dir, ".write-check-")
+	if err != nil {
+		return fmt.Errorf("web.auto_tls_cache_dir %s is not writable: %v", dir, err)
+	}
+
+	f.Close() // nolint: errcheck
+
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("web.auto_tls_cache_dir %s is not writable: %v", dir, err)
+	}
+
+	return nil
+}
+
+// autoCertObserver logs and counts the errors of getting the autocert certificate of host, and exports when
+// the certificate it serves expires. autocert renews certificates in the background without reporting errors,
+// so a renewal that keeps failing shows as an expiry that doesn't move forward, until the certificate expires
+// and handshakes fail.
+type autoCertObserver struct {
+	host           string
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	log            logrus.FieldLogger
+	metrics        metrics.Metrics
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (o *autoCertObserver) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := o.getCertificate(hello)
+
+	// Other hostnames are refused by the host policy, which is not a certificate error
+	if !strings.EqualFold(strings.TrimSuffix(hello.ServerName, "."), o.host) {
+		return cert, err
+	}
+
+	if err != nil {
+		o.log.WithError(err).WithField("host", o.host).Error("Getting the autocert certificate failed")
+		o.metrics.Counter("teller_autocert_errors_total", "Errors getting the Let's Encrypt certificate of web.auto_tls_host", nil).Inc()
+		return nil, err
+	}
+
+	// autocert parses the leaf of the certificates it returns
+	if cert.Leaf != nil {
+		o.metrics.Gauge("teller_autocert_expiry_timestamp_seconds", "When the Let's Encrypt certificate of web.auto_tls_host expires, as a Unix time", nil).Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+
+	return cert, nil
+}
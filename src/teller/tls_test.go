@@ -0,0 +1,232 @@
+package teller
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/metrics"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// writeTestCert writes a self-signed certificate for host and its key to dir
+func writeTestCert(t *testing.T, dir, host string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, host+".crt")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+
+	keyFile := filepath.Join(dir, host+".key")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	require.NoError(t, err)
+
+	return certFile, keyFile
+}
+
+func TestSNICertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fallbackCert, fallbackKey := writeTestCert(t, dir, "default.example.com")
+
+	var hosts []config.TLSHost
+	for _, host := range []string{"teller.example.com", "sale.example.org"} {
+		cert, key := writeTestCert(t, dir, host)
+		hosts = append(hosts, config.TLSHost{
+			Host: host,
+			Cert: cert,
+			Key:  key,
+		})
+	}
+
+	certs, err := loadSNICertificates(fallbackCert, fallbackKey, hosts)
+	require.NoError(t, err)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: certs.GetCertificate,
+	})
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake() // nolint: errcheck
+			}()
+		}
+	}()
+
+	tt := []struct {
+		serverName string
+		host       string
+	}{
+		{"teller.example.com", "teller.example.com"},
+		{"sale.example.org", "sale.example.org"},
+		{"TELLER.example.com", "teller.example.com"},
+		{"other.example.com", "default.example.com"},
+		{"", "default.example.com"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.serverName, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+				ServerName:         tc.serverName,
+				InsecureSkipVerify: true, // nolint: gosec
+			})
+			require.NoError(t, err)
+			defer conn.Close()
+
+			peerCerts := conn.ConnectionState().PeerCertificates
+			require.NotEmpty(t, peerCerts)
+			require.Equal(t, []string{tc.host}, peerCerts[0].DNSNames)
+		})
+	}
+}
+
+func TestLoadSNICertificatesInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fallbackCert, fallbackKey := writeTestCert(t, dir, "default.example.com")
+	cert, _ := writeTestCert(t, dir, "teller.example.com")
+
+	// A host whose key does not match its certificate fails to load
+	_, err = loadSNICertificates(fallbackCert, fallbackKey, []config.TLSHost{
+		{
+			Host: "teller.example.com",
+			Cert: cert,
+			Key:  fallbackKey,
+		},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "teller.example.com")
+
+	_, err = loadSNICertificates(fallbackCert, filepath.Join(dir, "missing.key"), nil)
+	require.Error(t, err)
+}
+
+func TestPrepareAutoCertCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A missing directory is created
+	cacheDir := filepath.Join(dir, "state", "cert-cache")
+	require.NoError(t, prepareAutoCertCache(cacheDir))
+	fi, err := os.Stat(cacheDir)
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+
+	// The write check leaves the directory empty
+	files, err := ioutil.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	// An existing directory is used as is
+	require.NoError(t, prepareAutoCertCache(cacheDir))
+
+	// A directory that can't be created
+	notDir := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(notDir, nil, 0600))
+	err = prepareAutoCertCache(filepath.Join(notDir, "cert-cache"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "create web.auto_tls_cache_dir")
+
+	// A directory that can't be written to. Permissions don't apply to root
+	if os.Geteuid() == 0 {
+		return
+	}
+
+	readOnly := filepath.Join(dir, "read-only")
+	require.NoError(t, os.Mkdir(readOnly, 0500))
+	err = prepareAutoCertCache(readOnly)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not writable")
+}
+
+func TestAutoCertObserver(t *testing.T) {
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	var getErr error
+
+	registry := metrics.NewRegistry()
+	log, _ := testutil.NewLogger(t)
+	o := &autoCertObserver{
+		host: "teller.example.com",
+		getCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if getErr != nil {
+				return nil, getErr
+			}
+			return &tls.Certificate{
+				Leaf: &x509.Certificate{
+					NotAfter: notAfter,
+				},
+			}, nil
+		},
+		log:     log,
+		metrics: registry,
+	}
+
+	exported := func() string {
+		var buf bytes.Buffer
+		_, err := registry.WriteTo(&buf)
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	cert, err := o.GetCertificate(&tls.ClientHelloInfo{ServerName: "teller.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, notAfter, cert.Leaf.NotAfter)
+	require.Contains(t, exported(), "teller_autocert_expiry_timestamp_seconds "+strconv.FormatFloat(float64(notAfter.Unix()), 'g', -1, 64)+"\n")
+
+	// Errors of the host are counted
+	getErr = errors.New("acme: rate limited")
+	_, err = o.GetCertificate(&tls.ClientHelloInfo{ServerName: "TELLER.example.com."})
+	require.Equal(t, getErr, err)
+	require.Contains(t, exported(), "teller_autocert_errors_total 1\n")
+
+	// Other hostnames are refused by the host policy, not counted
+	_, err = o.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	require.Equal(t, getErr, err)
+	_, err = o.GetCertificate(&tls.ClientHelloInfo{})
+	require.Equal(t, getErr, err)
+	require.Contains(t, exported(), "teller_autocert_errors_total 1\n")
+}
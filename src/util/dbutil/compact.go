@@ -0,0 +1,116 @@
+package dbutil
+
+import (
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// CompactMetaBkt records when the database was last compacted
+	CompactMetaBkt = []byte("compact_meta")
+
+	lastCompactedKey = "last_compacted"
+)
+
+// FreeSpacePercent returns the percentage of the database file that is free pages
+func FreeSpacePercent(db *bolt.DB) (float64, error) {
+	fi, err := os.Stat(db.Path())
+	if err != nil {
+		return 0, err
+	}
+
+	if fi.Size() == 0 {
+		return 0, nil
+	}
+
+	return float64(db.Stats().FreeAlloc) / float64(fi.Size()) * 100, nil
+}
+
+// LastCompacted returns when the database was last compacted by Compact.
+// Returns the zero time if it was never compacted.
+func LastCompacted(db *bolt.DB) (time.Time, error) {
+	var ts int64
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(CompactMetaBkt) == nil {
+			return nil
+		}
+
+		return GetBucketObject(tx, CompactMetaBkt, lastCompactedKey, &ts)
+	}); err != nil {
+		switch err.(type) {
+		case ObjectNotExistErr:
+			return time.Time{}, nil
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	if ts == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(ts, 0).UTC(), nil
+}
+
+// Compact copies all buckets and keys of src into a new database at dstPath,
+// leaving out the free pages of src, and records the time of compaction in it.
+// The copy is made in a single read transaction of src, so it is consistent.
+// If the copy fails, dstPath is removed.
+func Compact(src *bolt.DB, dstPath string) error {
+	dst, err := bolt.Open(dstPath, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := compact(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return err
+	}
+
+	return dst.Close()
+}
+
+func compact(dst, src *bolt.DB) error {
+	return src.View(func(stx *bolt.Tx) error {
+		return dst.Update(func(dtx *bolt.Tx) error {
+			if err := stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := dtx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return NewCreateBucketFailedErr(name, err)
+				}
+				return copyBucket(nb, b)
+			}); err != nil {
+				return err
+			}
+
+			if _, err := dtx.CreateBucketIfNotExists(CompactMetaBkt); err != nil {
+				return NewCreateBucketFailedErr(CompactMetaBkt, err)
+			}
+
+			return PutBucketValue(dtx, CompactMetaBkt, lastCompactedKey, time.Now().UTC().Unix())
+		})
+	})
+}
+
+// copyBucket copies the keys, nested buckets and sequence of src into dst
+func copyBucket(dst, src *bolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		// Nested buckets have a nil value
+		if v == nil {
+			nb, err := dst.CreateBucket(k)
+			if err != nil {
+				return NewCreateBucketFailedErr(k, err)
+			}
+			return copyBucket(nb, src.Bucket(k))
+		}
+
+		return dst.Put(k, v)
+	})
+}
@@ -0,0 +1,100 @@
+package dbutil
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestCompact(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	lastCompacted, err := LastCompacted(db)
+	require.NoError(t, err)
+	require.True(t, lastCompacted.IsZero())
+
+	parentBkt := []byte("parent")
+	childBkt := []byte("child")
+
+	// Write a lot of data, then delete most of it to leave free pages
+	err = db.Update(func(tx *bolt.Tx) error {
+		parent, err := tx.CreateBucket(parentBkt)
+		require.NoError(t, err)
+		require.NoError(t, parent.SetSequence(42))
+
+		child, err := parent.CreateBucket(childBkt)
+		require.NoError(t, err)
+
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, parent.Put([]byte(fmt.Sprintf("key-%04d", i)), make([]byte, 1024)))
+		}
+		require.NoError(t, child.Put([]byte("foo"), []byte("bar")))
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		parent := tx.Bucket(parentBkt)
+		for i := 10; i < 1000; i++ {
+			require.NoError(t, parent.Delete([]byte(fmt.Sprintf("key-%04d", i))))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	freePercent, err := FreeSpacePercent(db)
+	require.NoError(t, err)
+	require.True(t, freePercent > 25, "freePercent=%v", freePercent)
+
+	dstPath := db.Path() + ".compact"
+	defer os.Remove(dstPath)
+
+	err = Compact(db, dstPath)
+	require.NoError(t, err)
+
+	srcInfo, err := os.Stat(db.Path())
+	require.NoError(t, err)
+	dstInfo, err := os.Stat(dstPath)
+	require.NoError(t, err)
+	require.True(t, dstInfo.Size() < srcInfo.Size(), "%d >= %d", dstInfo.Size(), srcInfo.Size())
+
+	dst, err := bolt.Open(dstPath, 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+
+	lastCompacted, err = LastCompacted(dst)
+	require.NoError(t, err)
+	require.False(t, lastCompacted.IsZero())
+
+	// All buckets, keys and sequences are copied
+	err = dst.View(func(tx *bolt.Tx) error {
+		parent := tx.Bucket(parentBkt)
+		require.NotNil(t, parent)
+		require.Equal(t, uint64(42), parent.Sequence())
+
+		n := 0
+		require.NoError(t, parent.ForEach(func(k, v []byte) error {
+			if v != nil {
+				require.Equal(t, fmt.Sprintf("key-%04d", n), string(k))
+				require.Len(t, v, 1024)
+				n++
+			}
+			return nil
+		}))
+		require.Equal(t, 10, n)
+
+		child := parent.Bucket(childBkt)
+		require.NotNil(t, child)
+		require.Equal(t, []byte("bar"), child.Get([]byte("foo")))
+
+		return nil
+	})
+	require.NoError(t, err)
+}
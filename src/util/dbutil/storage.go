@@ -0,0 +1,56 @@
+package dbutil
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNetworkFS is returned by Open if the database is on a network filesystem and StorageOptions.AllowNetworkFS is not set
+var ErrNetworkFS = errors.New("The database is on a network filesystem. bolt's file lock and memory map are not reliable on network filesystems, which can corrupt the database. Move the database to a local disk, or set db_allow_network_fs to open it anyway")
+
+// StorageOptions configures how the database file is opened
+type StorageOptions struct {
+	// Timeout is how long to wait for the database's file lock. 0 waits indefinitely
+	Timeout time.Duration
+	// InitialMmapSize is the initial size in bytes of the database's memory map. 0 uses bolt's default
+	InitialMmapSize int
+	// AllowNetworkFS opens the database even if it is on a network filesystem.
+	// The database is then opened with networkFSMmapFlags
+	AllowNetworkFS bool
+}
+
+// networkFS returns the name of the network filesystem that dir is on, or "" if it is not on one.
+// It is a variable so that tests can fake a network filesystem
+var networkFS = statfsNetworkFS
+
+// Open opens the bolt database at path. The filesystem of its directory is checked first:
+// if it is a network filesystem, ErrNetworkFS is returned unless opts.AllowNetworkFS is set.
+// The check is best effort, an unknown filesystem is treated as local
+func Open(log logrus.FieldLogger, path string, opts StorageOptions) (*bolt.DB, error) {
+	boltOpts := &bolt.Options{
+		Timeout:         opts.Timeout,
+		InitialMmapSize: opts.InitialMmapSize,
+	}
+
+	fsName, err := networkFS(filepath.Dir(path))
+	if err != nil {
+		log.WithError(err).WithField("path", path).Warning("Could not check the database's filesystem, assuming it is local")
+	}
+
+	if fsName != "" {
+		log := log.WithField("path", path).WithField("filesystem", fsName)
+		if !opts.AllowNetworkFS {
+			log.Error("The database is on a network filesystem")
+			return nil, ErrNetworkFS
+		}
+
+		log.Warning("The database is on a network filesystem. Only one teller may use the database file, and it must not be modified by another host")
+		boltOpts.MmapFlags = networkFSMmapFlags
+	}
+
+	return bolt.Open(path, 0700, boltOpts)
+}
@@ -0,0 +1,30 @@
+package dbutil
+
+import "syscall"
+
+// networkFSMmapFlags prefaults the whole memory map when a database on a network filesystem is opened,
+// so that reads within a transaction don't fault pages in from the server
+const networkFSMmapFlags = syscall.MAP_POPULATE
+
+// networkFSTypes are the names of network filesystems, keyed by their statfs(2) f_type magic number
+var networkFSTypes = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xfe534d42: "smb2",
+	0xff534d42: "cifs",
+	0x5346414f: "afs",
+	0x73757245: "coda",
+	0x01021997: "9p",
+	0x00c36400: "ceph",
+	0x0bd00bd0: "lustre",
+	0x47504653: "gpfs",
+}
+
+func statfsNetworkFS(dir string) (string, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return "", err
+	}
+
+	return networkFSTypes[uint32(st.Type)], nil
+}
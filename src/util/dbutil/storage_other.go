@@ -0,0 +1,11 @@
+//go:build !linux
+
+package dbutil
+
+// networkFSMmapFlags is not used, since network filesystems are only detected on linux
+const networkFSMmapFlags = 0
+
+// statfsNetworkFS can't tell the filesystem type outside of linux, so every filesystem is treated as local
+func statfsNetworkFS(dir string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,69 @@
+package dbutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "teller-storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "teller.db")
+	log, _ := testutil.NewLogger(t)
+
+	defer func(f func(string) (string, error)) {
+		networkFS = f
+	}(networkFS)
+
+	var checkedDir string
+	fsName := ""
+	networkFS = func(dir string) (string, error) {
+		checkedDir = dir
+		return fsName, nil
+	}
+
+	opts := StorageOptions{
+		Timeout:         time.Second,
+		InitialMmapSize: 1 << 20,
+	}
+
+	// A database on a local filesystem is opened with the options
+	db, err := Open(log, path, opts)
+	require.NoError(t, err)
+	require.Equal(t, dir, checkedDir)
+	require.Equal(t, 0, db.MmapFlags)
+	require.NoError(t, db.Close())
+
+	// A database on a network filesystem is refused
+	fsName = "nfs"
+	_, err = Open(log, path, opts)
+	require.Equal(t, ErrNetworkFS, err)
+
+	// unless allowed
+	opts.AllowNetworkFS = true
+	db, err = Open(log, path, opts)
+	require.NoError(t, err)
+	require.Equal(t, networkFSMmapFlags, db.MmapFlags)
+
+	// The file lock is held, so opening it again times out
+	opts.Timeout = time.Millisecond * 10
+	_, err = Open(log, path, opts)
+	require.Error(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestStatfsNetworkFS(t *testing.T) {
+	// The temp dir is on a local filesystem
+	fsName, err := statfsNetworkFS(os.TempDir())
+	require.NoError(t, err)
+	require.Empty(t, fsName)
+}
@@ -0,0 +1,68 @@
+package dbutil
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/metrics"
+)
+
+// TxWaitBuckets are the histogram buckets, in seconds, of transaction wait and hold times
+var TxWaitBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// TxTimer runs bolt transactions and records how long each waited to start and how long it was held,
+// in the teller_db_tx_wait_seconds and teller_db_tx_hold_seconds histograms, labeled by op and type.
+//
+// bolt allows one read-write transaction at a time, so an Update waits for any Update in progress.
+// A View never waits for an Update, unless the Update grows the database file and has to remap it:
+// then the Update waits for every open View to finish, and new Views wait for the remap.
+type TxTimer struct {
+	m metrics.Metrics
+}
+
+// NewTxTimer creates a TxTimer that records to m
+func NewTxTimer(m metrics.Metrics) *TxTimer {
+	if m == nil {
+		m = metrics.Nop{}
+	}
+
+	return &TxTimer{
+		m: m,
+	}
+}
+
+// View runs f in a read-only transaction of db
+func (t *TxTimer) View(db *bolt.DB, op string, f func(*bolt.Tx) error) error {
+	return t.run(db.View, op, "view", f)
+}
+
+// Update runs f in a read-write transaction of db
+func (t *TxTimer) Update(db *bolt.DB, op string, f func(*bolt.Tx) error) error {
+	return t.run(db.Update, op, "update", f)
+}
+
+func (t *TxTimer) run(tx func(func(*bolt.Tx) error) error, op, typ string, f func(*bolt.Tx) error) error {
+	labels := metrics.Labels{
+		"op":   op,
+		"type": typ,
+	}
+
+	start := time.Now()
+	var started time.Time
+	err := tx(func(tx *bolt.Tx) error {
+		started = time.Now()
+		t.m.Histogram("teller_db_tx_wait_seconds", "Time waited for a db transaction to start", TxWaitBuckets, labels).Observe(started.Sub(start).Seconds())
+		return f(tx)
+	})
+
+	// The transaction failed to begin
+	if started.IsZero() {
+		return err
+	}
+
+	// Includes the commit, for read-write transactions
+	t.m.Histogram("teller_db_tx_hold_seconds", "Time a db transaction was held", TxWaitBuckets, labels).Observe(time.Since(started).Seconds())
+
+	return err
+}
@@ -0,0 +1,49 @@
+package dbutil
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/metrics"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestTxTimer(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	registry := metrics.NewRegistry()
+	timer := NewTxTimer(registry)
+
+	err := timer.Update(db, "Put", func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("foo"))
+		return err
+	})
+	require.NoError(t, err)
+
+	errFail := errors.New("fail")
+	err = timer.View(db, "Get", func(tx *bolt.Tx) error {
+		return errFail
+	})
+	require.Equal(t, errFail, err)
+
+	var buf bytes.Buffer
+	_, err = registry.WriteTo(&buf)
+	require.NoError(t, err)
+
+	out := buf.String()
+	require.Contains(t, out, `teller_db_tx_wait_seconds_count{op="Put",type="update"} 1`)
+	require.Contains(t, out, `teller_db_tx_hold_seconds_count{op="Put",type="update"} 1`)
+	require.Contains(t, out, `teller_db_tx_wait_seconds_count{op="Get",type="view"} 1`)
+	require.Contains(t, out, `teller_db_tx_hold_seconds_count{op="Get",type="view"} 1`)
+
+	// A nil Metrics records nothing
+	err = NewTxTimer(nil).View(db, "Get", func(tx *bolt.Tx) error {
+		return nil
+	})
+	require.NoError(t, err)
+}
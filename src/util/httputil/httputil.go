@@ -1,9 +1,13 @@
 package httputil
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -33,15 +37,35 @@ func JSONResponse(w http.ResponseWriter, data interface{}) error {
 	return err
 }
 
+// AccessLogConfig configures AccessLogHandler
+type AccessLogConfig struct {
+	// Log each request after it is handled
+	Enabled bool
+	// Query params whose values are replaced with "<redacted>" in the logs
+	RedactQueryParams []string
+	// Use the first X-Forwarded-For address as the remote IP, when behind a proxy
+	BehindProxy bool
+}
+
 // LogHandler log middleware
 func LogHandler(log logrus.FieldLogger, hd http.Handler) http.Handler {
+	return AccessLogHandler(log, AccessLogConfig{Enabled: true}, hd)
+}
+
+// AccessLogHandler adds a request scoped logger to the request context and,
+// if enabled, logs the method, path, status, duration and remote IP of each request.
+// It should wrap other middleware, so that requests they reject are logged too.
+func AccessLogHandler(log logrus.FieldLogger, cfg AccessLogConfig, hd http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		log = log.WithFields(logrus.Fields{
-			"method":     r.Method,
-			"remoteAddr": r.RemoteAddr,
-			"url":        r.URL.String(),
+		log := log.WithFields(logrus.Fields{
+			"method":   r.Method,
+			"remoteIP": RemoteIP(r, cfg.BehindProxy),
+			"path":     r.URL.Path,
 		})
+		if r.URL.RawQuery != "" {
+			log = log.WithField("query", redactQuery(r.URL.Query(), cfg.RedactQueryParams))
+		}
 		ctx = logger.WithContext(ctx, log)
 		r = r.WithContext(ctx)
 
@@ -51,6 +75,10 @@ func LogHandler(log logrus.FieldLogger, hd http.Handler) http.Handler {
 
 		hd.ServeHTTP(lrw, r)
 
+		if !cfg.Enabled {
+			return
+		}
+
 		log.WithFields(logrus.Fields{
 			"duration":   fmt.Sprintf("%dms", time.Since(t)/time.Millisecond),
 			"status":     lrw.statusCode,
@@ -59,6 +87,63 @@ func LogHandler(log logrus.FieldLogger, hd http.Handler) http.Handler {
 	})
 }
 
+// RemoteIP returns the IP address of the client. If behindProxy is true,
+// the first address in X-Forwarded-For is used when present.
+func RemoteIP(r *http.Request, behindProxy bool) string {
+	if behindProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// redactQuery encodes query with the values of the params in redact replaced
+func redactQuery(query url.Values, redact []string) string {
+	for _, k := range redact {
+		if vs, ok := query[k]; ok {
+			for i := range vs {
+				vs[i] = "<redacted>"
+			}
+		}
+	}
+	return query.Encode()
+}
+
+// MaxBytesHandler limits the size of request bodies to maxBytes.
+// Requests with a larger Content-Length are rejected with 413 Request Entity Too Large.
+// Otherwise, reading beyond maxBytes from the body returns an *http.MaxBytesError,
+// which handlers should report with 413 Request Entity Too Large.
+func MaxBytesHandler(hd http.Handler, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBytes {
+			ErrResponse(w, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		hd.ServeHTTP(w, r)
+	})
+}
+
+// WriteTimeoutHandler replaces the server's write timeout with timeout for the requests of hd,
+// e.g. for long-poll requests that wait longer than the server's write timeout.
+// If w is not the server's ResponseWriter and does not wrap it with an Unwrap method,
+// the server's write timeout is kept
+func WriteTimeoutHandler(hd http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The only error is http.ErrNotSupported
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout)) // nolint: errcheck
+
+		hd.ServeHTTP(w, r)
+	})
+}
+
 // Captures the response status of a http handler
 type loggingResponseWriter struct {
 	http.ResponseWriter
@@ -76,3 +161,14 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.statusCode = code
 	lrw.ResponseWriter.WriteHeader(code)
 }
+
+// Hijack implements http.Hijacker, so that websocket handlers can be logged
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.ResponseWriter does not implement http.Hijacker")
+	}
+
+	lrw.statusCode = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
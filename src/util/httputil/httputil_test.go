@@ -0,0 +1,95 @@
+package httputil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestAccessLogHandler(t *testing.T) {
+	log, hook := testutil.NewLogger(t)
+
+	hd := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ErrResponse(w, http.StatusTeapot)
+	})
+
+	h := AccessLogHandler(log, AccessLogConfig{
+		Enabled:           true,
+		RedactQueryParams: []string{"skyaddr"},
+		BehindProxy:       true,
+	}, hd)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/status?skyaddr=2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW&format=json", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusTeapot, rr.Code)
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, "HTTP Request", entry.Message)
+	require.Equal(t, http.MethodGet, entry.Data["method"])
+	require.Equal(t, "/api/status", entry.Data["path"])
+	require.Equal(t, "format=json&skyaddr=%3Credacted%3E", entry.Data["query"])
+	require.Equal(t, "1.2.3.4", entry.Data["remoteIP"])
+	require.Equal(t, http.StatusTeapot, entry.Data["status"])
+	require.Contains(t, entry.Data, "duration")
+
+	// Disabled access logs don't log requests
+	hook.Reset()
+	h = AccessLogHandler(log, AccessLogConfig{}, hd)
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusTeapot, rr.Code)
+	require.Nil(t, hook.LastEntry())
+}
+
+func TestRemoteIP(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+
+	require.Equal(t, "10.0.0.1", RemoteIP(req, false))
+	require.Equal(t, "1.2.3.4", RemoteIP(req, true))
+
+	req.Header.Del("X-Forwarded-For")
+	require.Equal(t, "10.0.0.1", RemoteIP(req, true))
+}
+
+func TestWriteTimeoutHandler(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 300)
+		w.Write([]byte("ok")) // nolint: errcheck
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/slow", slow)
+	mux.Handle("/extended", WriteTimeoutHandler(slow, time.Second*5))
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Config.WriteTimeout = time.Millisecond * 100
+	srv.Start()
+	defer srv.Close()
+
+	// The server's write timeout cuts off the response
+	_, err := http.Get(srv.URL + "/slow")
+	require.Error(t, err)
+
+	rsp, err := http.Get(srv.URL + "/extended")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(body))
+}
@@ -0,0 +1,306 @@
+// Package lifecycle runs a set of services until a signal, then shuts them down in order
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrShutdownTimeout is the result of a service that did not stop within the shutdown timeout
+	ErrShutdownTimeout = errors.New("shutdown timed out")
+	// ErrNotClosed is the result of a closer that was not closed because a service did not stop
+	ErrNotClosed = errors.New("not closed, a service did not stop")
+)
+
+// Result is how a service stopped, or a closer closed, during shutdown
+type Result struct {
+	Name string
+	// Err is nil if it stopped or closed cleanly
+	Err error
+}
+
+// ShutdownError is returned by RunUntilSignal if the shutdown was unclean: a service failed
+// or did not stop in time, or a closer failed or was not closed.
+// Results has the result of every service, in the order they were shut down,
+// followed by the result of every closer, in the order they were closed.
+type ShutdownError struct {
+	Results []Result
+}
+
+// Error implements the error interface
+func (e *ShutdownError) Error() string {
+	results := make([]string, len(e.Results))
+	for i, r := range e.Results {
+		if r.Err == nil {
+			results[i] = r.Name + ": ok"
+		} else {
+			results[i] = fmt.Sprintf("%s: %v", r.Name, r.Err)
+		}
+	}
+
+	return "unclean shutdown: " + strings.Join(results, ", ")
+}
+
+// Failed returns the results that are not clean
+func (e *ShutdownError) Failed() []Result {
+	var failed []Result
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// component is a service that runs in the background until it is shut down
+type component struct {
+	name     string
+	run      func() error
+	shutdown func()
+}
+
+// closer is a resource that is closed after every component has stopped, e.g. the db
+type closer struct {
+	name  string
+	close func() error
+}
+
+// Manager owns the services of a teller process. It starts them in the order they were added,
+// and on a signal or the first failure, shuts them down in the reverse order and waits for them
+// to stop before closing the resources they share, such as the db.
+//
+// Add the services that everything else depends on first, e.g. the exchange before the scanners
+// that feed it and the http servers that accept binds, so that the servers stop accepting requests
+// and the scanners stop producing deposits before the exchange is drained and stopped.
+type Manager struct {
+	log        logrus.FieldLogger
+	components []component
+	closers    []closer
+	onRepeat   func()
+	timeout    time.Duration
+}
+
+// NewManager creates a Manager
+func NewManager(log logrus.FieldLogger) *Manager {
+	return &Manager{
+		log: log.WithField("prefix", "lifecycle"),
+	}
+}
+
+// Add adds a service. run is called in its own goroutine by RunUntilSignal and should block until
+// shutdown is called. If run returns an error, every service is shut down.
+func (m *Manager) Add(name string, run func() error, shutdown func()) {
+	m.components = append(m.components, component{
+		name:     name,
+		run:      run,
+		shutdown: shutdown,
+	})
+}
+
+// AddCloser adds a resource that is closed after every service has stopped.
+// Closers are closed in the reverse order they were added.
+func (m *Manager) AddCloser(name string, close func() error) {
+	m.closers = append(m.closers, closer{
+		name:  name,
+		close: close,
+	})
+}
+
+// SetRepeatSignalHandler sets a func that is called if a signal is received again while
+// shutting down, e.g. to dump goroutines when shutdown is stuck. It must be called before RunUntilSignal
+func (m *Manager) SetRepeatSignalHandler(f func()) {
+	m.onRepeat = f
+}
+
+// SetShutdownTimeout sets how long each service's shutdown func may block, and how long
+// RunUntilSignal then waits for every service to stop. Services that miss it are reported
+// as timed out instead of blocking shutdown forever. 0 means no timeout, the default.
+// It must be called before RunUntilSignal
+func (m *Manager) SetShutdownTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
+// RunUntilSignal starts every service and blocks until one of the signals is received
+// or a service fails. It then shuts down the services in the reverse order they were added,
+// waits for them to stop and closes the closers. Defaults to os.Interrupt if no signals are given.
+// If a service did not stop, the closers are not closed, since the services may still be using them.
+// Returns a *ShutdownError if the shutdown was unclean.
+func (m *Manager) RunUntilSignal(signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt}
+	}
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, signals...)
+	defer signal.Stop(sigC)
+
+	errC := make(chan error, len(m.components))
+	runErrs := make([]error, len(m.components))
+	stopped := make([]chan struct{}, len(m.components))
+
+	for i, c := range m.components {
+		m.log.Infof("Starting %s", c.name)
+		stopped[i] = make(chan struct{})
+		go func(i int, c component) {
+			defer close(stopped[i])
+			if err := c.run(); err != nil {
+				m.log.WithError(err).Errorf("%s failed", c.name)
+				runErrs[i] = err
+				errC <- err
+			} else {
+				m.log.Infof("%s stopped", c.name)
+			}
+		}(i, c)
+	}
+
+	select {
+	case sig := <-sigC:
+		m.log.WithField("signal", sig).Info("Received signal, shutting down")
+	case err := <-errC:
+		m.log.WithError(err).Error("Shutting down after a failure")
+	}
+
+	done := make(chan struct{})
+	if m.onRepeat != nil {
+		go func() {
+			select {
+			case <-sigC:
+				m.onRepeat()
+			case <-done:
+			}
+		}()
+	}
+	defer close(done)
+
+	timedOut := make([]bool, len(m.components))
+
+	for i := len(m.components) - 1; i >= 0; i-- {
+		c := m.components[i]
+		m.log.Infof("Shutting down %s", c.name)
+		if !m.callWithTimeout(c.shutdown) {
+			m.log.Errorf("Shutting down %s timed out", c.name)
+			timedOut[i] = true
+		}
+	}
+
+	m.log.Info("Waiting for services to stop")
+
+	ctx, cancel := m.timeoutContext()
+	defer cancel()
+
+	for i := len(m.components) - 1; i >= 0; i-- {
+		if timedOut[i] {
+			continue
+		}
+
+		// A service that already stopped is not timed out, even if the deadline has passed
+		select {
+		case <-stopped[i]:
+			continue
+		default:
+		}
+
+		select {
+		case <-stopped[i]:
+		case <-ctx.Done():
+			m.log.Errorf("%s did not stop in time", m.components[i].name)
+			timedOut[i] = true
+		}
+	}
+
+	var results []Result
+	clean := true
+	allStopped := true
+
+	for i := len(m.components) - 1; i >= 0; i-- {
+		r := Result{
+			Name: m.components[i].name,
+		}
+
+		if timedOut[i] {
+			r.Err = ErrShutdownTimeout
+			allStopped = false
+		} else {
+			r.Err = runErrs[i]
+		}
+
+		if r.Err != nil {
+			clean = false
+		}
+
+		results = append(results, r)
+	}
+
+	for i := len(m.closers) - 1; i >= 0; i-- {
+		c := m.closers[i]
+		r := Result{
+			Name: c.name,
+		}
+
+		if !allStopped {
+			m.log.Errorf("Not closing %s, a service did not stop", c.name)
+			r.Err = ErrNotClosed
+		} else {
+			m.log.Infof("Closing %s", c.name)
+			if err := c.close(); err != nil {
+				m.log.WithError(err).Errorf("Closing %s failed", c.name)
+				r.Err = err
+			}
+		}
+
+		if r.Err != nil {
+			clean = false
+		}
+
+		results = append(results, r)
+	}
+
+	if !clean {
+		err := &ShutdownError{
+			Results: results,
+		}
+		m.log.WithError(err).Error("Shutdown complete")
+		return err
+	}
+
+	m.log.Info("Shutdown complete")
+
+	return nil
+}
+
+// timeoutContext returns a context that is done after the shutdown timeout, or never if there is none
+func (m *Manager) timeoutContext() (context.Context, context.CancelFunc) {
+	if m.timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), m.timeout)
+}
+
+// callWithTimeout calls f and returns true if it returned within the shutdown timeout.
+// If it did not, f is left running in the background
+func (m *Manager) callWithTimeout(f func()) bool {
+	ctx, cancel := m.timeoutContext()
+	defer cancel()
+
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		f()
+	}()
+
+	select {
+	case <-returned:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
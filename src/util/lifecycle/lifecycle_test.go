@@ -0,0 +1,232 @@
+package lifecycle
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// recorder records the order of events of the test services
+type recorder struct {
+	sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) get() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+// addService adds a service that runs until it is shut down, or fails with err after started is closed
+func addService(m *Manager, r *recorder, name string, started chan struct{}, err error) {
+	quit := make(chan struct{})
+	m.Add(name, func() error {
+		if err != nil {
+			<-started
+			return err
+		}
+		<-quit
+		r.record(name + " stopped")
+		return nil
+	}, func() {
+		r.record(name + " shutdown")
+		close(quit)
+	})
+}
+
+func TestRunUntilSignal(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := NewManager(log)
+	r := &recorder{}
+
+	addService(m, r, "exchange", nil, nil)
+	addService(m, r, "server", nil, nil)
+	m.AddCloser("db", func() error {
+		r.record("db closed")
+		return nil
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- m.RunUntilSignal(syscall.SIGUSR1)
+	}()
+
+	// Wait for the signal handler to be registered
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunUntilSignal did not return")
+	}
+
+	events := r.get()
+	require.Len(t, events, 5)
+
+	// Services are shut down in reverse order, and the db is closed after every service stopped
+	require.Equal(t, "server shutdown", events[0])
+	require.Contains(t, events, "exchange shutdown")
+	require.Contains(t, events, "server stopped")
+	require.Contains(t, events, "exchange stopped")
+	require.Equal(t, "db closed", events[4])
+}
+
+func TestRunUntilSignalServiceFailed(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := NewManager(log)
+	r := &recorder{}
+
+	started := make(chan struct{})
+	addService(m, r, "exchange", nil, nil)
+	addService(m, r, "scanner", started, errors.New("scan failed"))
+
+	closeErr := errors.New("close failed")
+	m.AddCloser("db", func() error {
+		r.record("db closed")
+		return closeErr
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- m.RunUntilSignal(syscall.SIGUSR1)
+	}()
+
+	close(started)
+
+	select {
+	case err := <-done:
+		// Both the service failure and the close failure are returned
+		require.Equal(t, &ShutdownError{
+			Results: []Result{
+				{Name: "scanner", Err: errors.New("scan failed")},
+				{Name: "exchange"},
+				{Name: "db", Err: closeErr},
+			},
+		}, err)
+		require.Equal(t, "unclean shutdown: scanner: scan failed, exchange: ok, db: close failed", err.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunUntilSignal did not return")
+	}
+
+	require.Equal(t, []string{
+		"scanner shutdown",
+		"exchange shutdown",
+		"exchange stopped",
+		"db closed",
+	}, r.get())
+}
+
+func TestRunUntilSignalShutdownTimeout(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := NewManager(log)
+	m.SetShutdownTimeout(100 * time.Millisecond)
+	r := &recorder{}
+
+	// The exchange's Shutdown blocks past the deadline
+	unblock := make(chan struct{})
+	defer close(unblock)
+	m.Add("exchange", func() error {
+		<-unblock
+		return nil
+	}, func() {
+		r.record("exchange shutdown")
+		<-unblock
+	})
+
+	addService(m, r, "server", nil, nil)
+
+	m.AddCloser("db", func() error {
+		r.record("db closed")
+		return nil
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- m.RunUntilSignal(syscall.SIGUSR1)
+	}()
+
+	// Wait for the signal handler to be registered
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+
+	select {
+	case err := <-done:
+		// The server stopped cleanly, the exchange timed out, and the db was not closed
+		// because the exchange may still be using it
+		shutdownErr, ok := err.(*ShutdownError)
+		require.True(t, ok)
+		require.Equal(t, []Result{
+			{Name: "server"},
+			{Name: "exchange", Err: ErrShutdownTimeout},
+			{Name: "db", Err: ErrNotClosed},
+		}, shutdownErr.Results)
+		require.Equal(t, []Result{
+			{Name: "exchange", Err: ErrShutdownTimeout},
+			{Name: "db", Err: ErrNotClosed},
+		}, shutdownErr.Failed())
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunUntilSignal did not return")
+	}
+
+	// The db was not closed
+	events := r.get()
+	require.Len(t, events, 3)
+	require.Equal(t, "server shutdown", events[0])
+	require.Contains(t, events, "server stopped")
+	require.Contains(t, events, "exchange shutdown")
+}
+
+func TestRunUntilSignalStopTimeout(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := NewManager(log)
+	m.SetShutdownTimeout(100 * time.Millisecond)
+	r := &recorder{}
+
+	addService(m, r, "exchange", nil, nil)
+
+	// The scanner's Shutdown returns, but the scanner keeps running past the deadline
+	unblock := make(chan struct{})
+	defer close(unblock)
+	m.Add("scanner", func() error {
+		<-unblock
+		return nil
+	}, func() {
+		r.record("scanner shutdown")
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- m.RunUntilSignal(syscall.SIGUSR1)
+	}()
+
+	// Wait for the signal handler to be registered
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR1))
+
+	select {
+	case err := <-done:
+		require.Equal(t, &ShutdownError{
+			Results: []Result{
+				{Name: "scanner", Err: ErrShutdownTimeout},
+				{Name: "exchange"},
+			},
+		}, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunUntilSignal did not return")
+	}
+}
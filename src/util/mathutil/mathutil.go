@@ -58,3 +58,23 @@ func ParseRate(rate string) (decimal.Decimal, error) {
 
 	return r, nil
 }
+
+// ParseBtcAmount parses a decimal BTC amount string into satoshis.
+// It fails if the amount is negative or has more than 8 decimal places.
+func ParseBtcAmount(amount string) (int64, error) {
+	d, err := decimal.NewFromString(amount)
+	if err != nil {
+		return 0, err
+	}
+
+	if d.LessThan(decimal.New(0, 0)) {
+		return 0, errors.New("BTC amount can't be negative")
+	}
+
+	satoshis := d.Mul(decimal.New(1e8, 0))
+	if !satoshis.Equal(satoshis.Truncate(0)) {
+		return 0, errors.New("BTC amount has more than 8 decimal places")
+	}
+
+	return satoshis.IntPart(), nil
+}
@@ -88,3 +88,48 @@ func TestWei2Gwei(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBtcAmount(t *testing.T) {
+	cases := []struct {
+		s        string
+		satoshis int64
+		err      error
+	}{
+		{
+			s:   "bad",
+			err: errors.New("can't convert bad to decimal"),
+		},
+		{
+			s:   "-0.1",
+			err: errors.New("BTC amount can't be negative"),
+		},
+		{
+			s:   "0.000000001",
+			err: errors.New("BTC amount has more than 8 decimal places"),
+		},
+		{
+			s:        "0",
+			satoshis: 0,
+		},
+		{
+			s:        "0.00000001",
+			satoshis: 1,
+		},
+		{
+			s:        "1.5",
+			satoshis: 150000000,
+		},
+		{
+			s:        "21000000",
+			satoshis: 2100000000000000,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.s, func(t *testing.T) {
+			satoshis, err := ParseBtcAmount(tc.s)
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.satoshis, satoshis)
+		})
+	}
+}
@@ -14,7 +14,7 @@ import (
 )
 
 // PrepareDB initializes a temporary bolt.DB
-func PrepareDB(t *testing.T) (*bolt.DB, func()) {
+func PrepareDB(t testing.TB) (*bolt.DB, func()) {
 	f, err := ioutil.TempFile("", "testdb")
 	require.NoError(t, err)
 
@@ -37,7 +37,7 @@ func CheckError(t *testing.T, f func() error) {
 }
 
 // NewLogger returns a logger that only writes to stdout and with debug level
-func NewLogger(t *testing.T) (*logrus.Logger, *logrus_test.Hook) {
+func NewLogger(t testing.TB) (*logrus.Logger, *logrus_test.Hook) {
 	log, err := logger.NewLogger("", true)
 	require.NoError(t, err)
 
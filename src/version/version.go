@@ -0,0 +1,31 @@
+// Package version reports the build version of teller.
+// The values are set at build time with ldflags, e.g.:
+//
+//	go build -ldflags "-X github.com/skycoin/teller/src/version.Version=v1.0.0 -X github.com/skycoin/teller/src/version.Commit=$(git rev-parse HEAD)" ./cmd/teller
+package version
+
+// These are set with ldflags at build time
+var (
+	// Version is the release version of teller
+	Version = "dev"
+	// Commit is the git commit teller was built from
+	Commit = ""
+	// BuildTime is when teller was built, e.g. in RFC3339 format
+	BuildTime = ""
+)
+
+// Info is the build version of teller
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// Get returns the build version of teller
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+	}
+}